@@ -0,0 +1,191 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/spf13/cobra"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Profile is the manage api address and credential of a metad.
+type Profile struct {
+	Endpoint string `yaml:"endpoint" json:"endpoint"`
+	Token    string `yaml:"token,omitempty" json:"-"`
+}
+
+// Config is the metadctl config file, the named contexts and the current one.
+type Config struct {
+	CurrentContext string              `yaml:"current_context"`
+	Contexts       map[string]*Profile `yaml:"contexts"`
+}
+
+func defaultConfigFile() string {
+	if f := os.Getenv("METADCTL_CONFIG"); f != "" {
+		return f
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".metadctl.yml"
+	}
+	return filepath.Join(home, ".metadctl.yml")
+}
+
+// loadConfig load the config file, a missing file is an empty config.
+func loadConfig(file string) (*Config, error) {
+	config := &Config{Contexts: map[string]*Profile{}}
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return config, nil
+		}
+		return nil, err
+	}
+	if err := yaml.Unmarshal(b, config); err != nil {
+		return nil, fmt.Errorf("parse config file [%s] error: %s", file, err.Error())
+	}
+	if config.Contexts == nil {
+		config.Contexts = map[string]*Profile{}
+	}
+	return config, nil
+}
+
+func (c *Config) save(file string) error {
+	b, err := yaml.Marshal(c)
+	if err != nil {
+		return err
+	}
+	// the tokens are credentials.
+	return ioutil.WriteFile(file, b, 0600)
+}
+
+// resolve return a copy of the named context, or the current context if name is empty,
+// an empty profile if no context is selected.
+func (c *Config) resolve(name string) (*Profile, error) {
+	if name == "" {
+		name = c.CurrentContext
+	}
+	if name == "" {
+		return &Profile{}, nil
+	}
+	profile, ok := c.Contexts[name]
+	if !ok {
+		return nil, fmt.Errorf("context [%s] not found.", name)
+	}
+	copied := *profile
+	return &copied, nil
+}
+
+var contextCmd = &cobra.Command{
+	Use:   "context",
+	Short: "Manage the contexts of metad endpoints",
+}
+
+var contextListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the contexts, the current one is marked with *",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		config, err := loadConfig(configFile)
+		if err != nil {
+			return err
+		}
+		names := make([]string, 0, len(config.Contexts))
+		for name := range config.Contexts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if output == outputJSON {
+			result := map[string]interface{}{"current_context": config.CurrentContext, "contexts": config.Contexts}
+			return printJSON(os.Stdout, result)
+		}
+		rows := make([][]string, 0, len(names))
+		for _, name := range names {
+			current := ""
+			if name == config.CurrentContext {
+				current = "*"
+			}
+			rows = append(rows, []string{current, name, config.Contexts[name].Endpoint})
+		}
+		return printTable(os.Stdout, []string{"CURRENT", "NAME", "ENDPOINT"}, rows)
+	},
+}
+
+var contextUseCmd = &cobra.Command{
+	Use:   "use NAME",
+	Short: "Set the current context",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		config, err := loadConfig(configFile)
+		if err != nil {
+			return err
+		}
+		if _, ok := config.Contexts[args[0]]; !ok {
+			return fmt.Errorf("context [%s] not found.", args[0])
+		}
+		config.CurrentContext = args[0]
+		return config.save(configFile)
+	},
+}
+
+var contextSetCmd = &cobra.Command{
+	Use:   "set NAME --endpoint http://127.0.0.1:9611 [--token $token]",
+	Short: "Create or update a context with the --endpoint and --token flags, the first context is the current one",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		config, err := loadConfig(configFile)
+		if err != nil {
+			return err
+		}
+		profile, ok := config.Contexts[args[0]]
+		if !ok {
+			profile = &Profile{}
+			config.Contexts[args[0]] = profile
+		}
+		if cmd.Flags().Changed("endpoint") {
+			profile.Endpoint = endpoint
+		}
+		if cmd.Flags().Changed("token") {
+			profile.Token = token
+		}
+		if profile.Endpoint == "" {
+			return fmt.Errorf("context [%s] endpoint should not be empty.", args[0])
+		}
+		if config.CurrentContext == "" {
+			config.CurrentContext = args[0]
+		}
+		return config.save(configFile)
+	},
+}
+
+var contextDeleteCmd = &cobra.Command{
+	Use:   "delete NAME",
+	Short: "Delete a context",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		config, err := loadConfig(configFile)
+		if err != nil {
+			return err
+		}
+		if _, ok := config.Contexts[args[0]]; !ok {
+			return fmt.Errorf("context [%s] not found.", args[0])
+		}
+		delete(config.Contexts, args[0])
+		if config.CurrentContext == args[0] {
+			config.CurrentContext = ""
+		}
+		return config.save(configFile)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(contextCmd)
+	contextCmd.AddCommand(contextListCmd, contextUseCmd, contextSetCmd, contextDeleteCmd)
+}
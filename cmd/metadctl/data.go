@@ -0,0 +1,151 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	putFile  string
+	putMerge bool
+	delSubs  []string
+)
+
+var getCmd = &cobra.Command{
+	Use:   "get [path](default is /)",
+	Short: "Get the metadata",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: runWithClient(func(c *apiClient, args []string) error {
+		return getResource(c, "/v1/data", pathArg(args))
+	}),
+}
+
+var putCmd = &cobra.Command{
+	Use:   "put path [value] [-f file]",
+	Short: "Create or replace the metadata, merge with --merge",
+	Long: "Create or replace the metadata of the path, or merge with --merge. The value (or the file content, - is stdin) " +
+		"is parsed as json if valid, otherwise it is a string value.",
+	Args: cobra.RangeArgs(1, 2),
+	RunE: runWithClient(func(c *apiClient, args []string) error {
+		return putResource(c, "/v1/data", args)
+	}),
+}
+
+var deleteCmd = &cobra.Command{
+	Use:   "delete path [--subs a,b]",
+	Short: "Delete the metadata of the path, or the sub paths of --subs",
+	Args:  cobra.ExactArgs(1),
+	RunE: runWithClient(func(c *apiClient, args []string) error {
+		return deleteResource(c, "/v1/data", args)
+	}),
+}
+
+func init() {
+	RootCmd.AddCommand(getCmd, putCmd, deleteCmd)
+	addPutFlags(putCmd)
+	addDeleteFlags(deleteCmd)
+}
+
+func addPutFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&putFile, "file", "f", "", "read the value from the file, - is stdin")
+	cmd.Flags().BoolVar(&putMerge, "merge", false, "merge the value to the current instead of replace")
+}
+
+func addDeleteFlags(cmd *cobra.Command) {
+	cmd.Flags().StringSliceVar(&delSubs, "subs", nil, "the sub paths to delete")
+}
+
+func pathArg(args []string) string {
+	if len(args) == 0 {
+		return "/"
+	}
+	return path.Join("/", args[0])
+}
+
+// parseValue parse the value as json if valid, otherwise it is a string.
+func parseValue(s string) interface{} {
+	var v interface{}
+	if err := json.Unmarshal([]byte(s), &v); err == nil {
+		return v
+	}
+	return s
+}
+
+// readValue read the value of put from the args or --file.
+func readValue(args []string) (interface{}, error) {
+	if putFile == "" {
+		if len(args) < 2 {
+			return nil, errors.New("missing the value or --file.")
+		}
+		return parseValue(args[1]), nil
+	}
+	if len(args) > 1 {
+		return nil, errors.New("the value and --file should not be both present.")
+	}
+	var b []byte
+	var err error
+	if putFile == "-" {
+		b, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		b, err = ioutil.ReadFile(putFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseValue(strings.TrimSpace(string(b))), nil
+}
+
+func getResource(c *apiClient, api string, nodePath string) error {
+	v, err := c.do("GET", apiPath(api, nodePath), nil, nil)
+	if err != nil {
+		return err
+	}
+	return printValue(os.Stdout, nodePath, v)
+}
+
+// putResource replace the value of the path by POST, or merge by PUT.
+func putResource(c *apiClient, api string, args []string) error {
+	value, err := readValue(args)
+	if err != nil {
+		return err
+	}
+	method := "POST"
+	if putMerge {
+		method = "PUT"
+	}
+	v, err := c.do(method, apiPath(api, pathArg(args)), nil, value)
+	if err != nil {
+		return err
+	}
+	return printResult(os.Stdout, v)
+}
+
+func deleteResource(c *apiClient, api string, args []string) error {
+	var query url.Values
+	if len(delSubs) > 0 {
+		query = url.Values{"subs": {strings.Join(delSubs, ",")}}
+	}
+	v, err := c.do("DELETE", apiPath(api, pathArg(args)), query, nil)
+	if err != nil {
+		return err
+	}
+	return printResult(os.Stdout, v)
+}
+
+func apiPath(api string, nodePath string) string {
+	if nodePath == "/" {
+		return api
+	}
+	return api + nodePath
+}
@@ -0,0 +1,48 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var mappingCmd = &cobra.Command{
+	Use:   "mapping",
+	Short: "Manage the ip mapping",
+}
+
+var mappingGetCmd = &cobra.Command{
+	Use:   "get [path](default is /)",
+	Short: "Get the mapping, the first path element is the client ip",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: runWithClient(func(c *apiClient, args []string) error {
+		return getResource(c, "/v1/mapping", pathArg(args))
+	}),
+}
+
+var mappingPutCmd = &cobra.Command{
+	Use:   "put path [value] [-f file]",
+	Short: "Create or replace the mapping, merge with --merge",
+	Args:  cobra.RangeArgs(1, 2),
+	RunE: runWithClient(func(c *apiClient, args []string) error {
+		return putResource(c, "/v1/mapping", args)
+	}),
+}
+
+var mappingDeleteCmd = &cobra.Command{
+	Use:   "delete path [--subs a,b]",
+	Short: "Delete the mapping of the path, or the sub paths of --subs",
+	Args:  cobra.ExactArgs(1),
+	RunE: runWithClient(func(c *apiClient, args []string) error {
+		return deleteResource(c, "/v1/mapping", args)
+	}),
+}
+
+func init() {
+	RootCmd.AddCommand(mappingCmd)
+	mappingCmd.AddCommand(mappingGetCmd, mappingPutCmd, mappingDeleteCmd)
+	addPutFlags(mappingPutCmd)
+	addDeleteFlags(mappingDeleteCmd)
+}
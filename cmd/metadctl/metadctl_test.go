@@ -0,0 +1,124 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	. "openpitrix.io/metad/pkg/assert"
+)
+
+func TestConfigContexts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metadctl")
+	Assert(t, nil == err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "config.yml")
+
+	config, err := loadConfig(file)
+	Assert(t, nil == err)
+	profile, err := config.resolve("")
+	Assert(t, nil == err)
+	Assert(t, "" == profile.Endpoint)
+	_, err = config.resolve("prod")
+	Assert(t, nil != err)
+
+	config.Contexts["prod"] = &Profile{Endpoint: "http://10.0.0.1:9611", Token: "secret"}
+	config.Contexts["dev"] = &Profile{Endpoint: "http://127.0.0.1:9611"}
+	config.CurrentContext = "dev"
+	Assert(t, nil == config.save(file))
+
+	config, err = loadConfig(file)
+	Assert(t, nil == err)
+	profile, err = config.resolve("")
+	Assert(t, nil == err)
+	Assert(t, "http://127.0.0.1:9611" == profile.Endpoint)
+	profile, err = config.resolve("prod")
+	Assert(t, nil == err)
+	Assert(t, "secret" == profile.Token)
+	// the resolved profile is a copy.
+	profile.Endpoint = "x"
+	Assert(t, "http://10.0.0.1:9611" == config.Contexts["prod"].Endpoint)
+}
+
+func TestAPIClient(t *testing.T) {
+	var auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		auth = req.Header.Get("Authorization")
+		if req.URL.Path == "/v1/data/nodes" {
+			w.Write([]byte(`{"1": {"ip": "192.168.1.1"}, "2": {"ip": "192.168.1.2"}}`))
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"code": 404, "message": "Not found", "type": "ERROR"}`))
+	}))
+	defer server.Close()
+
+	c := &apiClient{endpoint: server.URL, token: "secret", httpClient: http.DefaultClient}
+	v, err := c.do("GET", "/v1/data/nodes", nil, nil)
+	Assert(t, nil == err)
+	Assert(t, "Bearer secret" == auth)
+	rows := flattenRows("/nodes", v)
+	Assert(t, reflect.DeepEqual([][]string{{"/nodes/1/ip", "192.168.1.1"}, {"/nodes/2/ip", "192.168.1.2"}}, rows), rows)
+
+	_, err = c.do("GET", "/v1/data/notexist", nil, nil)
+	e, ok := err.(*apiError)
+	Assert(t, ok && 404 == e.Status && "Not found" == e.Message, err)
+
+	values, err := readValues(c, "/notexist")
+	Assert(t, nil == err && 0 == len(values))
+}
+
+func TestDiffValues(t *testing.T) {
+	changes := diffValues(map[string]string{"/a": "1", "/b": "2"}, map[string]string{"/a": "1", "/b": "3", "/c": "4"})
+	Assert(t, 2 == len(changes))
+	Assert(t, reflect.DeepEqual(&change{Action: "set", Path: "/b", Value: "3"}, changes[0]))
+	changes = diffValues(map[string]string{"/a": "1"}, map[string]string{})
+	Assert(t, reflect.DeepEqual([]*change{{Action: "delete", Path: "/a"}}, changes))
+}
+
+func TestParseRules(t *testing.T) {
+	rules, err := parseRules([]string{"192.168.1.1", "/=0", "/clusters/cl-1=1"})
+	Assert(t, nil == err)
+	b, _ := json.Marshal(rules)
+	Assert(t, `{"192.168.1.1":[{"mode":0,"path":"/"},{"mode":1,"path":"/clusters/cl-1"}]}` == string(b), string(b))
+	_, err = parseRules([]string{"192.168.1.1", "/clusters"})
+	Assert(t, nil != err)
+	_, err = parseRules([]string{"192.168.1.1"})
+	Assert(t, nil != err)
+
+	var buf bytes.Buffer
+	Assert(t, nil == printTable(&buf, []string{"HOST", "PATH", "MODE"}, ruleRows(parseValue(string(b)))))
+	Assert(t, "HOST         PATH            MODE\n192.168.1.1  /               0\n192.168.1.1  /clusters/cl-1  1\n" == buf.String(), buf.String())
+}
+
+func TestParseSubscription(t *testing.T) {
+	defer func() {
+		subscriptionURL, subscriptionPrefix, subscriptionActions, subscriptionSecret = "", "/", nil, ""
+	}()
+	_, err := parseSubscription()
+	Assert(t, nil != err)
+
+	subscriptionURL = "http://cmdb.example.com/metad"
+	subscriptionPrefix = "/nodes"
+	subscriptionActions = []string{"update", "delete"}
+	subscriptionSecret = "secret"
+	subscription, err := parseSubscription()
+	Assert(t, nil == err)
+	b, _ := json.Marshal(subscription)
+	Assert(t, `{"actions":["UPDATE","DELETE"],"prefix":"/nodes","secret":"secret","url":"http://cmdb.example.com/metad"}` == string(b), string(b))
+
+	var buf bytes.Buffer
+	v := parseValue(`[{"name": "cmdb", "url": "http://cmdb.example.com/metad", "prefix": "/nodes", "source": "api", "metrics": {"delivered": 12, "dead_lettered": 0}}]`)
+	Assert(t, nil == printTable(&buf, []string{"NAME", "URL", "PREFIX", "SOURCE", "DELIVERED", "DEAD_LETTERED"}, subscriptionRows(v)))
+	Assert(t, "NAME  URL                            PREFIX  SOURCE  DELIVERED  DEAD_LETTERED\ncmdb  http://cmdb.example.com/metad  /nodes  api     12         0\n" == buf.String(), buf.String())
+}
@@ -0,0 +1,88 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"openpitrix.io/metad/pkg/flatmap"
+)
+
+// the output formats.
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+)
+
+func printJSON(w io.Writer, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(b))
+	return err
+}
+
+func printTable(w io.Writer, header []string, rows [][]string) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+// flattenRows flatten the value under the nodePath to the sorted (key, value) rows, the keys are full paths.
+func flattenRows(nodePath string, v interface{}) [][]string {
+	var flat map[string]string
+	switch t := v.(type) {
+	case map[string]interface{}, []interface{}:
+		flat = flatmap.Flatten(t)
+	case nil:
+		flat = map[string]string{}
+	default:
+		flat = map[string]string{"": fmt.Sprintf("%v", t)}
+	}
+	keys := make([]string, 0, len(flat))
+	for k := range flat {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	rows := make([][]string, 0, len(keys))
+	for _, k := range keys {
+		rows = append(rows, []string{path.Join("/", nodePath, k), flat[k]})
+	}
+	return rows
+}
+
+// printValue print the value of the nodePath, a table of the flattened keys or the json.
+func printValue(w io.Writer, nodePath string, v interface{}) error {
+	if output == outputJSON {
+		return printJSON(w, v)
+	}
+	if s, ok := v.(string); ok {
+		_, err := fmt.Fprintln(w, s)
+		return err
+	}
+	return printTable(w, []string{"KEY", "VALUE"}, flattenRows(nodePath, v))
+}
+
+// printResult print the response of a write, the message of the default response in table format.
+func printResult(w io.Writer, v interface{}) error {
+	if output == outputJSON {
+		return printJSON(w, v)
+	}
+	if m, ok := v.(map[string]interface{}); ok && m["type"] == "OK" {
+		_, err := fmt.Fprintln(w, "OK")
+		return err
+	}
+	return printValue(w, "/", v)
+}
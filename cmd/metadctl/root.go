@@ -0,0 +1,163 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	defaultEndpoint = "http://127.0.0.1:9611"
+	requestTimeout  = 30 * time.Second
+)
+
+// RootCmd is the base command when called without any subcommands.
+var RootCmd = &cobra.Command{
+	Use:           "metadctl",
+	Short:         "A command line client of the metad manage api",
+	SilenceUsage:  true,
+	SilenceErrors: true,
+}
+
+var (
+	configFile  string
+	contextName string
+	endpoint    string
+	token       string
+	output      string
+)
+
+func init() {
+	RootCmd.PersistentFlags().StringVar(&configFile, "config", defaultConfigFile(), "metadctl config file of the contexts, $METADCTL_CONFIG if set")
+	RootCmd.PersistentFlags().StringVar(&contextName, "context", "", "the context to use, default the current context of the config file")
+	RootCmd.PersistentFlags().StringVar(&endpoint, "endpoint", "", "metad manage api address, override the context")
+	RootCmd.PersistentFlags().StringVar(&token, "token", "", "bearer token of the manage api, override the context")
+	RootCmd.PersistentFlags().StringVarP(&output, "output", "o", outputTable, "output format: table|json")
+}
+
+// apiError is the error response of the manage api.
+type apiError struct {
+	Status  int
+	Message string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("metad response status [%d]: %s", e.Status, e.Message)
+}
+
+// apiClient call the manage api of the endpoint.
+type apiClient struct {
+	endpoint   string
+	token      string
+	httpClient *http.Client
+}
+
+// newAPIClient create the client of the selected context, the flags override the context.
+func newAPIClient() (*apiClient, error) {
+	if output != outputTable && output != outputJSON {
+		return nil, fmt.Errorf("unsupported output format [%s], should be table or json.", output)
+	}
+	config, err := loadConfig(configFile)
+	if err != nil {
+		return nil, err
+	}
+	profile, err := config.resolve(contextName)
+	if err != nil {
+		return nil, err
+	}
+	if endpoint != "" {
+		profile.Endpoint = endpoint
+	}
+	if token != "" {
+		profile.Token = token
+	}
+	if profile.Endpoint == "" {
+		profile.Endpoint = defaultEndpoint
+	}
+	if !strings.HasPrefix(profile.Endpoint, "http://") && !strings.HasPrefix(profile.Endpoint, "https://") {
+		profile.Endpoint = "http://" + profile.Endpoint
+	}
+	return &apiClient{
+		endpoint:   strings.TrimRight(profile.Endpoint, "/"),
+		token:      profile.Token,
+		httpClient: &http.Client{Timeout: requestTimeout},
+	}, nil
+}
+
+// do send the request with the json body if not nil, and decode the json response.
+func (c *apiClient) do(method string, api string, query url.Values, body interface{}) (interface{}, error) {
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(b)
+	}
+	u := c.endpoint + api
+	if len(query) > 0 {
+		u = u + "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, u, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var result interface{}
+	if len(b) > 0 {
+		if err := json.Unmarshal(b, &result); err != nil {
+			if resp.StatusCode != http.StatusOK {
+				return nil, &apiError{Status: resp.StatusCode, Message: strings.TrimSpace(string(b))}
+			}
+			return nil, fmt.Errorf("invalid json response, error:%s", err.Error())
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		e := &apiError{Status: resp.StatusCode, Message: http.StatusText(resp.StatusCode)}
+		if m, ok := result.(map[string]interface{}); ok {
+			if msg, ok := m["message"].(string); ok {
+				e.Message = msg
+			}
+		}
+		return nil, e
+	}
+	return result, nil
+}
+
+// runWithClient adapt the command func to cobra, create the client before run.
+func runWithClient(run func(c *apiClient, args []string) error) func(cmd *cobra.Command, args []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		c, err := newAPIClient()
+		if err != nil {
+			return err
+		}
+		return run(c, args)
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var ruleCmd = &cobra.Command{
+	Use:   "rule",
+	Short: "Manage the access rules of the hosts",
+}
+
+var ruleGetCmd = &cobra.Command{
+	Use:   "get [host...]",
+	Short: "Get the access rules of the hosts, default all hosts",
+	RunE: runWithClient(func(c *apiClient, args []string) error {
+		v, err := c.do("GET", "/v1/rule", hostsQuery(args), nil)
+		if err != nil {
+			return err
+		}
+		if output == outputJSON {
+			return printJSON(os.Stdout, v)
+		}
+		return printTable(os.Stdout, []string{"HOST", "PATH", "MODE"}, ruleRows(v))
+	}),
+}
+
+var rulePutCmd = &cobra.Command{
+	Use:   "put (host path=mode... | -f file)",
+	Short: "Replace the access rules of the host, or the hosts of the file",
+	Long: "Replace the access rules of the host by the path=mode arguments, the mode is 0 (forbidden) or 1 (read), " +
+		"or the rules of the json file {\"$host\": [{\"path\": $path, \"mode\": $mode}]}, - is stdin.",
+	RunE: runWithClient(func(c *apiClient, args []string) error {
+		rules, err := parseRules(args)
+		if err != nil {
+			return err
+		}
+		v, err := c.do("PUT", "/v1/rule", nil, rules)
+		if err != nil {
+			return err
+		}
+		return printResult(os.Stdout, v)
+	}),
+}
+
+var ruleDeleteCmd = &cobra.Command{
+	Use:   "delete host...",
+	Short: "Delete the access rules of the hosts",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: runWithClient(func(c *apiClient, args []string) error {
+		v, err := c.do("DELETE", "/v1/rule", hostsQuery(args), nil)
+		if err != nil {
+			return err
+		}
+		return printResult(os.Stdout, v)
+	}),
+}
+
+func init() {
+	RootCmd.AddCommand(ruleCmd)
+	ruleCmd.AddCommand(ruleGetCmd, rulePutCmd, ruleDeleteCmd)
+	rulePutCmd.Flags().StringVarP(&putFile, "file", "f", "", "read the rules from the json file, - is stdin")
+}
+
+func hostsQuery(hosts []string) url.Values {
+	if len(hosts) == 0 {
+		return nil
+	}
+	return url.Values{"hosts": {strings.Join(hosts, ",")}}
+}
+
+// parseRules parse the rules of put from the args or --file.
+func parseRules(args []string) (interface{}, error) {
+	if putFile != "" {
+		if len(args) > 0 {
+			return nil, errors.New("the rules and --file should not be both present.")
+		}
+		v, err := readValue(nil)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := v.(map[string]interface{}); !ok {
+			return nil, errors.New("the rules file should be a json object of the hosts.")
+		}
+		return v, nil
+	}
+	if len(args) < 2 {
+		return nil, errors.New("missing the host and rules, or --file.")
+	}
+	rules := make([]interface{}, 0, len(args)-1)
+	for _, arg := range args[1:] {
+		i := strings.LastIndex(arg, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid rule [%s], should be path=mode.", arg)
+		}
+		mode, err := strconv.Atoi(arg[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid rule [%s] mode, should be 0 or 1.", arg)
+		}
+		rules = append(rules, map[string]interface{}{"path": arg[:i], "mode": mode})
+	}
+	return map[string]interface{}{args[0]: rules}, nil
+}
+
+// ruleRows convert the rules {"$host": [{"path": $path, "mode": $mode}]} to the rows sorted by host.
+func ruleRows(v interface{}) [][]string {
+	hosts, _ := v.(map[string]interface{})
+	names := make([]string, 0, len(hosts))
+	for host := range hosts {
+		names = append(names, host)
+	}
+	sort.Strings(names)
+	var rows [][]string
+	for _, host := range names {
+		rules, _ := hosts[host].([]interface{})
+		for _, r := range rules {
+			rule, _ := r.(map[string]interface{})
+			rows = append(rows, []string{host, fmt.Sprintf("%v", rule["path"]), fmt.Sprintf("%v", rule["mode"])})
+		}
+	}
+	return rows
+}
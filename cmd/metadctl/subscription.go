@@ -0,0 +1,238 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	subscriptionURL     string
+	subscriptionPrefix  string
+	subscriptionActions []string
+	subscriptionPaths   []string
+	subscriptionFormat  string
+	subscriptionSecret  string
+)
+
+var subscriptionCmd = &cobra.Command{
+	Use:   "subscription",
+	Short: "Manage the webhook subscriptions of the data changes",
+}
+
+var subscriptionGetCmd = &cobra.Command{
+	Use:   "get [name]",
+	Short: "Get the subscription, default all subscriptions with the delivery metrics",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: runWithClient(func(c *apiClient, args []string) error {
+		api := "/v1/subscription"
+		if len(args) > 0 {
+			api = api + "/" + args[0]
+		}
+		v, err := c.do("GET", api, nil, nil)
+		if err != nil {
+			return err
+		}
+		if output == outputJSON {
+			return printJSON(os.Stdout, v)
+		}
+		if len(args) > 0 {
+			v = []interface{}{v}
+		}
+		return printTable(os.Stdout, []string{"NAME", "URL", "PREFIX", "SOURCE", "DELIVERED", "DEAD_LETTERED"}, subscriptionRows(v))
+	}),
+}
+
+var subscriptionPutCmd = &cobra.Command{
+	Use:   "put name (--url url [--prefix path] [--actions a,b] [--paths p,q] [--format json|text] [--secret s] | -f file)",
+	Short: "Create or replace the subscription",
+	Long: "Create or replace the subscription by the flags, or the subscription json of the file, - is stdin. " +
+		"The changes under the prefix are POSTed to the url, filtered by the actions and the path glob patterns, " +
+		"and signed by the secret if present.",
+	Args: cobra.ExactArgs(1),
+	RunE: runWithClient(func(c *apiClient, args []string) error {
+		subscription, err := parseSubscription()
+		if err != nil {
+			return err
+		}
+		v, err := c.do("PUT", "/v1/subscription/"+args[0], nil, subscription)
+		if err != nil {
+			return err
+		}
+		return printResult(os.Stdout, v)
+	}),
+}
+
+var subscriptionDeleteCmd = &cobra.Command{
+	Use:   "delete name",
+	Short: "Delete the subscription",
+	Args:  cobra.ExactArgs(1),
+	RunE: runWithClient(func(c *apiClient, args []string) error {
+		v, err := c.do("DELETE", "/v1/subscription/"+args[0], nil, nil)
+		if err != nil {
+			return err
+		}
+		return printResult(os.Stdout, v)
+	}),
+}
+
+var subscriptionTestCmd = &cobra.Command{
+	Use:   "test name",
+	Short: "Deliver a test notification to the subscription once",
+	Args:  cobra.ExactArgs(1),
+	RunE: runWithClient(func(c *apiClient, args []string) error {
+		v, err := c.do("POST", "/v1/subscription/"+args[0]+"/test", nil, nil)
+		if err != nil {
+			return err
+		}
+		return printResult(os.Stdout, v)
+	}),
+}
+
+var deadLetterCmd = &cobra.Command{
+	Use:   "deadletter",
+	Short: "Manage the webhook notifications failed after all retries",
+}
+
+var deadLetterGetCmd = &cobra.Command{
+	Use:   "get [id]",
+	Short: "Get the dead letter, default all dead letters",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: runWithClient(func(c *apiClient, args []string) error {
+		api := "/v1/deadletter"
+		if len(args) > 0 {
+			api = api + "/" + args[0]
+		}
+		v, err := c.do("GET", api, nil, nil)
+		if err != nil {
+			return err
+		}
+		if output == outputJSON {
+			return printJSON(os.Stdout, v)
+		}
+		if len(args) > 0 {
+			v = []interface{}{v}
+		}
+		return printTable(os.Stdout, []string{"ID", "URL", "ATTEMPTS", "ERROR"}, deadLetterRows(v))
+	}),
+}
+
+var deadLetterReplayCmd = &cobra.Command{
+	Use:   "replay id",
+	Short: "Deliver the dead letter again, it is discarded if delivered",
+	Args:  cobra.ExactArgs(1),
+	RunE: runWithClient(func(c *apiClient, args []string) error {
+		v, err := c.do("POST", "/v1/deadletter/"+args[0]+"/replay", nil, nil)
+		if err != nil {
+			return err
+		}
+		return printResult(os.Stdout, v)
+	}),
+}
+
+var deadLetterDeleteCmd = &cobra.Command{
+	Use:   "delete id",
+	Short: "Discard the dead letter",
+	Args:  cobra.ExactArgs(1),
+	RunE: runWithClient(func(c *apiClient, args []string) error {
+		v, err := c.do("DELETE", "/v1/deadletter/"+args[0], nil, nil)
+		if err != nil {
+			return err
+		}
+		return printResult(os.Stdout, v)
+	}),
+}
+
+func init() {
+	RootCmd.AddCommand(subscriptionCmd, deadLetterCmd)
+	subscriptionCmd.AddCommand(subscriptionGetCmd, subscriptionPutCmd, subscriptionDeleteCmd, subscriptionTestCmd)
+	deadLetterCmd.AddCommand(deadLetterGetCmd, deadLetterReplayCmd, deadLetterDeleteCmd)
+	flags := subscriptionPutCmd.Flags()
+	flags.StringVarP(&putFile, "file", "f", "", "read the subscription json from the file, - is stdin")
+	flags.StringVar(&subscriptionURL, "url", "", "the webhook url")
+	flags.StringVar(&subscriptionPrefix, "prefix", "/", "the data path to watch")
+	flags.StringSliceVar(&subscriptionActions, "actions", nil, "only notify the events of the actions, UPDATE or DELETE")
+	flags.StringSliceVar(&subscriptionPaths, "paths", nil, "only notify the events whose path match one of the glob patterns")
+	flags.StringVar(&subscriptionFormat, "format", "", "the notification format, json or text")
+	flags.StringVar(&subscriptionSecret, "secret", "", "the secret to sign the notifications")
+}
+
+// parseSubscription parse the subscription of put from the flags or --file.
+func parseSubscription() (interface{}, error) {
+	if putFile != "" {
+		if subscriptionURL != "" {
+			return nil, errors.New("the --url and --file should not be both present.")
+		}
+		v, err := readValue(nil)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := v.(map[string]interface{}); !ok {
+			return nil, errors.New("the subscription file should be a json object.")
+		}
+		return v, nil
+	}
+	if subscriptionURL == "" {
+		return nil, errors.New("missing the --url or --file.")
+	}
+	subscription := map[string]interface{}{"url": subscriptionURL, "prefix": subscriptionPrefix}
+	if len(subscriptionActions) > 0 {
+		actions := make([]string, 0, len(subscriptionActions))
+		for _, action := range subscriptionActions {
+			actions = append(actions, strings.ToUpper(action))
+		}
+		subscription["actions"] = actions
+	}
+	if len(subscriptionPaths) > 0 {
+		subscription["paths"] = subscriptionPaths
+	}
+	if subscriptionFormat != "" {
+		subscription["format"] = subscriptionFormat
+	}
+	if subscriptionSecret != "" {
+		subscription["secret"] = subscriptionSecret
+	}
+	return subscription, nil
+}
+
+// subscriptionRows convert the subscription list to the rows in the response order.
+func subscriptionRows(v interface{}) [][]string {
+	list, _ := v.([]interface{})
+	rows := make([][]string, 0, len(list))
+	for _, item := range list {
+		subscription, _ := item.(map[string]interface{})
+		metrics, _ := subscription["metrics"].(map[string]interface{})
+		rows = append(rows, []string{
+			fmt.Sprintf("%v", subscription["name"]),
+			fmt.Sprintf("%v", subscription["url"]),
+			fmt.Sprintf("%v", subscription["prefix"]),
+			fmt.Sprintf("%v", subscription["source"]),
+			fmt.Sprintf("%v", metrics["delivered"]),
+			fmt.Sprintf("%v", metrics["dead_lettered"]),
+		})
+	}
+	return rows
+}
+
+// deadLetterRows convert the dead letter list to the rows in the response order.
+func deadLetterRows(v interface{}) [][]string {
+	list, _ := v.([]interface{})
+	rows := make([][]string, 0, len(list))
+	for _, item := range list {
+		deadLetter, _ := item.(map[string]interface{})
+		rows = append(rows, []string{
+			fmt.Sprintf("%v", deadLetter["id"]),
+			fmt.Sprintf("%v", deadLetter["url"]),
+			fmt.Sprintf("%v", deadLetter["attempts"]),
+			fmt.Sprintf("%v", deadLetter["error"]),
+		})
+	}
+	return rows
+}
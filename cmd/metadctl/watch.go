@@ -0,0 +1,129 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var watchInterval time.Duration
+
+var watchCmd = &cobra.Command{
+	Use:   "watch [path](default is /)",
+	Short: "Watch the changes of the metadata",
+	Long: "Watch the changes of the metadata under the path, the manage api is polled every --interval " +
+		"and the changed keys are printed, until interrupted.",
+	Args: cobra.MaximumNArgs(1),
+	RunE: runWithClient(func(c *apiClient, args []string) error {
+		if watchInterval <= 0 {
+			return fmt.Errorf("invalid interval [%v], should be positive.", watchInterval)
+		}
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+		return watch(c, pathArg(args), watchInterval, os.Stdout, stop)
+	}),
+}
+
+func init() {
+	RootCmd.AddCommand(watchCmd)
+	watchCmd.Flags().DurationVar(&watchInterval, "interval", time.Second, "the poll interval")
+}
+
+// change is a changed key of the watch, the action is set or delete.
+type change struct {
+	Action string `json:"action"`
+	Path   string `json:"path"`
+	Value  string `json:"value,omitempty"`
+}
+
+// diffValues return the changes from the old to the new flattened values, in path order.
+func diffValues(old map[string]string, new map[string]string) []*change {
+	var changes []*change
+	for k, v := range new {
+		if ov, ok := old[k]; !ok || ov != v {
+			changes = append(changes, &change{Action: "set", Path: k, Value: v})
+		}
+	}
+	for k := range old {
+		if _, ok := new[k]; !ok {
+			changes = append(changes, &change{Action: "delete", Path: k})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+	return changes
+}
+
+// readValues read the flattened values under the path, a missing path is empty.
+func readValues(c *apiClient, nodePath string) (map[string]string, error) {
+	v, err := c.do("GET", apiPath("/v1/data", nodePath), nil, nil)
+	if err != nil {
+		if e, ok := err.(*apiError); ok && e.Status == http.StatusNotFound {
+			return map[string]string{}, nil
+		}
+		return nil, err
+	}
+	values := map[string]string{}
+	for _, row := range flattenRows(nodePath, v) {
+		values[row[0]] = row[1]
+	}
+	return values, nil
+}
+
+// watch print the current values as set changes, then the changes of every poll. The poll errors are
+// printed to stderr and retried at next poll, so a restarting metad does not stop the watch.
+func watch(c *apiClient, nodePath string, interval time.Duration, w io.Writer, stop <-chan os.Signal) error {
+	current, err := readValues(c, nodePath)
+	if err != nil {
+		return err
+	}
+	var tw *tabwriter.Writer
+	encoder := json.NewEncoder(w)
+	print := func(changes []*change) {
+		for _, ch := range changes {
+			if output == outputJSON {
+				encoder.Encode(ch)
+				continue
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", ch.Action, ch.Path, ch.Value)
+		}
+		if tw != nil {
+			tw.Flush()
+		}
+	}
+	if output != outputJSON {
+		tw = tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ACTION\tKEY\tVALUE")
+	}
+	print(diffValues(map[string]string{}, current))
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			values, err := readValues(c, nodePath)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				continue
+			}
+			print(diffValues(current, values))
+			current = values
+		case <-stop:
+			return nil
+		}
+	}
+}
@@ -11,6 +11,7 @@ require (
 	github.com/golang/gddo v0.0.0-20180703174436-daffe1f90ec5
 	github.com/golang/protobuf v0.0.0-20180622174009-9eb2c01ac278
 	github.com/gorilla/mux v0.0.0-20180605211556-cb4698366aa6
+	github.com/klauspost/compress v1.13.6
 	github.com/mattn/go-isatty v0.0.0-20171107050531-6ca4dbf54d38
 	github.com/matttproud/golang_protobuf_extensions v0.0.0-20160424113007-c12348ce28de
 	github.com/prometheus/client_golang v0.0.0-20171224160406-180b8fdc22b4
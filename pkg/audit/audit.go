@@ -0,0 +1,193 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"openpitrix.io/metad/pkg/logger"
+)
+
+const RecordAudit = "audit"
+
+// Entry is one audit log record.
+type Entry struct {
+	Time      string `json:"time"`
+	RequestID string `json:"request_id"`
+	Identity  string `json:"identity"`
+	TokenID   string `json:"token_id,omitempty"`
+	ClientIP  string `json:"client_ip"`
+	API       string `json:"api"`
+	Action    string `json:"action"`
+	Path      string `json:"path"`
+	Revision  int64  `json:"revision"`
+	Status    int    `json:"status"`
+	// Elapsed (milliseconds) and Bytes are only set for access entries.
+	Elapsed float64 `json:"elapsed,omitempty"`
+	Bytes   int     `json:"bytes,omitempty"`
+}
+
+type Auditor interface {
+	Audit(entry *Entry)
+	Close() error
+}
+
+// RecordWriter write audit entry to backend, see backends.StoreClient.
+type RecordWriter interface {
+	PutRecord(kind string, key string, value string) error
+}
+
+func marshalEntry(entry *Entry) []byte {
+	if entry.Time == "" {
+		entry.Time = time.Now().Format(time.RFC3339Nano)
+	}
+	b, err := json.Marshal(entry)
+	if err != nil {
+		logger.Error("Marshal audit entry error: %s", err.Error())
+		return nil
+	}
+	return b
+}
+
+type fileAuditor struct {
+	file       string
+	maxSize    int64
+	maxBackups int
+	out        *os.File
+	size       int64
+	lock       sync.Mutex
+}
+
+// NewFileAuditor append audit entries as json lines to the file,
+// when the file size exceeds maxSize, the file is rotated to file.1, file.2 ... and keep at most maxBackups files.
+// maxSize <= 0 means never rotate.
+func NewFileAuditor(file string, maxSize int64, maxBackups int) (Auditor, error) {
+	a := &fileAuditor{file: file, maxSize: maxSize, maxBackups: maxBackups}
+	err := a.open()
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *fileAuditor) open() error {
+	out, err := os.OpenFile(a.file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := out.Stat()
+	if err != nil {
+		out.Close()
+		return err
+	}
+	a.out = out
+	a.size = info.Size()
+	return nil
+}
+
+func (a *fileAuditor) rotate() error {
+	a.out.Close()
+	a.out = nil
+	if a.maxBackups > 0 {
+		for i := a.maxBackups - 1; i > 0; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", a.file, i), fmt.Sprintf("%s.%d", a.file, i+1))
+		}
+		if err := os.Rename(a.file, a.file+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(a.file); err != nil {
+		return err
+	}
+	return a.open()
+}
+
+func (a *fileAuditor) Audit(entry *Entry) {
+	line := marshalEntry(entry)
+	if line == nil {
+		return
+	}
+	line = append(line, '\n')
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.out == nil {
+		if err := a.open(); err != nil {
+			logger.Error("Open audit file %s error: %s", a.file, err.Error())
+			return
+		}
+	}
+	if a.maxSize > 0 && a.size > 0 && a.size+int64(len(line)) > a.maxSize {
+		if err := a.rotate(); err != nil {
+			logger.Error("Rotate audit file %s error: %s", a.file, err.Error())
+			return
+		}
+	}
+	n, err := a.out.Write(line)
+	a.size += int64(n)
+	if err != nil {
+		logger.Error("Write audit file %s error: %s", a.file, err.Error())
+	}
+}
+
+func (a *fileAuditor) Close() error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.out == nil {
+		return nil
+	}
+	err := a.out.Close()
+	a.out = nil
+	return err
+}
+
+type recordAuditor struct {
+	writer RecordWriter
+}
+
+// NewRecordAuditor write audit entries to backend as records of kind "audit".
+func NewRecordAuditor(writer RecordWriter) Auditor {
+	return &recordAuditor{writer: writer}
+}
+
+func (a *recordAuditor) Audit(entry *Entry) {
+	value := marshalEntry(entry)
+	if value == nil {
+		return
+	}
+	key := fmt.Sprintf("%d-%s", time.Now().UnixNano(), entry.RequestID)
+	if err := a.writer.PutRecord(RecordAudit, key, string(value)); err != nil {
+		logger.Error("Write audit record error: %s", err.Error())
+	}
+}
+
+func (a *recordAuditor) Close() error {
+	return nil
+}
+
+type multiAuditor []Auditor
+
+// NewMultiAuditor write audit entry to all the auditors.
+func NewMultiAuditor(auditors ...Auditor) Auditor {
+	return multiAuditor(auditors)
+}
+
+func (m multiAuditor) Audit(entry *Entry) {
+	for _, a := range m {
+		a.Audit(entry)
+	}
+}
+
+func (m multiAuditor) Close() error {
+	var err error
+	for _, a := range m {
+		if e := a.Close(); e != nil {
+			err = e
+		}
+	}
+	return err
+}
@@ -0,0 +1,146 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "openpitrix.io/metad/pkg/assert"
+)
+
+func TestFileAuditorRotate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metad_audit")
+	Assert(t, err == nil, err)
+	defer os.RemoveAll(dir)
+
+	file := path.Join(dir, "audit.log")
+	auditor, err := NewFileAuditor(file, 200, 2)
+	Assert(t, err == nil, err)
+	for i := 0; i < 10; i++ {
+		auditor.Audit(&Entry{RequestID: "REQ-1", Action: "PUT", Path: "/nodes/1", Status: 200})
+	}
+	Assert(t, nil == auditor.Close())
+
+	_, err = os.Stat(file + ".1")
+	Assert(t, err == nil, err)
+	_, err = os.Stat(file + ".2")
+	Assert(t, err == nil, err)
+	_, err = os.Stat(file + ".3")
+	Assert(t, os.IsNotExist(err))
+
+	f, err := os.Open(file)
+	Assert(t, err == nil, err)
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	Assert(t, scanner.Scan())
+	entry := Entry{}
+	Assert(t, nil == json.Unmarshal(scanner.Bytes(), &entry))
+	Assert(t, "/nodes/1" == entry.Path)
+	Assert(t, entry.Time != "")
+}
+
+type testRecordWriter map[string]string
+
+func (w testRecordWriter) PutRecord(kind string, key string, value string) error {
+	w[kind+key] = value
+	return nil
+}
+
+func TestRecordAuditor(t *testing.T) {
+	writer := testRecordWriter{}
+	auditor := NewMultiAuditor(NewRecordAuditor(writer))
+	auditor.Audit(&Entry{RequestID: "REQ-1"})
+	auditor.Audit(&Entry{RequestID: "REQ-2"})
+	Assert(t, 2 == len(writer))
+}
+
+func TestStreamAuditorTCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	Assert(t, err == nil, err)
+	defer l.Close()
+
+	auditor, err := NewStreamAuditor("tcp://"+l.Addr().String(), 100)
+	Assert(t, err == nil, err)
+	for i := 0; i < 10; i++ {
+		auditor.Audit(&Entry{RequestID: "REQ-1", Action: "PUT", Path: "/nodes/1", Status: 200})
+	}
+
+	conn, err := l.Accept()
+	Assert(t, err == nil, err)
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	scanner := bufio.NewScanner(conn)
+	for i := 0; i < 10; i++ {
+		Assert(t, scanner.Scan(), scanner.Err())
+		entry := Entry{}
+		Assert(t, nil == json.Unmarshal(scanner.Bytes(), &entry))
+		Assert(t, "/nodes/1" == entry.Path)
+	}
+	Assert(t, nil == auditor.Close())
+}
+
+func TestStreamAuditorHTTPRetry(t *testing.T) {
+	var lock sync.Mutex
+	var lines []string
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		Assert(t, "application/x-ndjson" == req.Header.Get("Content-Type"))
+		b, _ := ioutil.ReadAll(req.Body)
+		lock.Lock()
+		lines = append(lines, strings.Split(strings.TrimSpace(string(b)), "\n")...)
+		lock.Unlock()
+	}))
+	defer server.Close()
+
+	auditor, err := NewStreamAuditor(server.URL, 100)
+	Assert(t, err == nil, err)
+	auditor.Audit(&Entry{RequestID: "REQ-1"})
+	auditor.Audit(&Entry{RequestID: "REQ-2"})
+	time.Sleep(1500 * time.Millisecond)
+	auditor.Audit(&Entry{RequestID: "REQ-3"})
+	Assert(t, nil == auditor.Close())
+
+	lock.Lock()
+	defer lock.Unlock()
+	Assert(t, atomic.LoadInt32(&requests) >= 2)
+	Assert(t, 3 == len(lines), lines)
+	entry := Entry{}
+	Assert(t, nil == json.Unmarshal([]byte(lines[0]), &entry))
+	Assert(t, "REQ-1" == entry.RequestID)
+}
+
+func TestStreamAuditorBufferFull(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	Assert(t, err == nil, err)
+	address := l.Addr().String()
+	l.Close()
+
+	auditor, err := NewStreamAuditor("tcp://"+address, 2)
+	Assert(t, err == nil, err)
+	for i := 0; i < 10; i++ {
+		auditor.Audit(&Entry{RequestID: "REQ-1"})
+	}
+	Assert(t, atomic.LoadInt64(&auditor.(*streamAuditor).dropped) > 0)
+	Assert(t, nil == auditor.Close())
+
+	_, err = NewStreamAuditor("file:///tmp/audit.log", 2)
+	Assert(t, err != nil)
+}
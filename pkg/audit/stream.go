@@ -0,0 +1,230 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package audit
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	"openpitrix.io/metad/pkg/logger"
+)
+
+const (
+	streamBatchSize   = 100
+	streamTimeout     = 10 * time.Second
+	streamMinBackoff  = time.Second
+	streamMaxBackoff  = 30 * time.Second
+	streamDropLogStep = 1000
+)
+
+// sender send a batch of json lines to the endpoint.
+type sender interface {
+	send(lines [][]byte) error
+	close()
+}
+
+type connSender struct {
+	network string
+	address string
+	conn    net.Conn
+}
+
+// send write the lines to tcp connection, or one datagram per line to udp, reconnect on next send if error.
+func (s *connSender) send(lines [][]byte) error {
+	if s.conn == nil {
+		conn, err := net.DialTimeout(s.network, s.address, streamTimeout)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(streamTimeout))
+	var err error
+	if s.network == "udp" {
+		for _, line := range lines {
+			if _, err = s.conn.Write(line); err != nil {
+				break
+			}
+		}
+	} else {
+		_, err = s.conn.Write(bytes.Join(lines, nil))
+	}
+	if err != nil {
+		s.close()
+	}
+	return err
+}
+
+func (s *connSender) close() {
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+}
+
+type httpSender struct {
+	url    string
+	client *http.Client
+}
+
+// send POST the lines as application/x-ndjson, non 2xx response status is error.
+func (s *httpSender) send(lines [][]byte) error {
+	resp, err := s.client.Post(s.url, "application/x-ndjson", bytes.NewReader(bytes.Join(lines, nil)))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("siem response status %d.", resp.StatusCode)
+	}
+	return nil
+}
+
+func (s *httpSender) close() {}
+
+type streamAuditor struct {
+	address  string
+	sender   sender
+	entries  chan []byte
+	dropped  int64
+	stopChan chan struct{}
+	done     chan struct{}
+}
+
+// NewStreamAuditor stream the audit entries as json lines to the SIEM endpoint,
+// the address is tcp://host:port, udp://host:port or http(s) url.
+// The entries are buffered up to bufferSize when the endpoint is slow or unavailable, and dropped if the buffer is full,
+// the failed batch is retried with backoff until sent, so the entries are kept in order.
+func NewStreamAuditor(address string, bufferSize int) (Auditor, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+	var s sender
+	switch u.Scheme {
+	case "tcp", "udp":
+		if u.Host == "" {
+			return nil, fmt.Errorf("invalid siem address [%s].", address)
+		}
+		s = &connSender{network: u.Scheme, address: u.Host}
+	case "http", "https":
+		s = &httpSender{url: address, client: &http.Client{Timeout: streamTimeout}}
+	default:
+		return nil, fmt.Errorf("invalid siem address [%s], should be tcp://, udp://, http:// or https://.", address)
+	}
+	if bufferSize <= 0 {
+		bufferSize = streamBatchSize
+	}
+	a := &streamAuditor{
+		address:  address,
+		sender:   s,
+		entries:  make(chan []byte, bufferSize),
+		stopChan: make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go a.run()
+	return a, nil
+}
+
+func (a *streamAuditor) Audit(entry *Entry) {
+	b := marshalEntry(entry)
+	if b == nil {
+		return
+	}
+	select {
+	case a.entries <- append(b, '\n'):
+	default:
+		dropped := atomic.AddInt64(&a.dropped, 1)
+		if dropped%streamDropLogStep == 1 {
+			logger.Warn("Audit stream [%s] buffer is full, %d entries dropped.", a.address, dropped)
+		}
+	}
+}
+
+func (a *streamAuditor) run() {
+	defer close(a.done)
+	defer a.sender.close()
+	for {
+		var lines [][]byte
+		select {
+		case line := <-a.entries:
+			lines = append(lines, line)
+		case <-a.stopChan:
+			a.flush()
+			return
+		}
+		lines = a.fill(lines)
+		if !a.sendWithBackoff(lines) {
+			a.flush()
+			return
+		}
+	}
+}
+
+// fill add the buffered entries to the batch without waiting.
+func (a *streamAuditor) fill(lines [][]byte) [][]byte {
+	for len(lines) < streamBatchSize {
+		select {
+		case line := <-a.entries:
+			lines = append(lines, line)
+		default:
+			return lines
+		}
+	}
+	return lines
+}
+
+// sendWithBackoff retry the batch until sent, return false if stopped.
+func (a *streamAuditor) sendWithBackoff(lines [][]byte) bool {
+	backoff := streamMinBackoff
+	for {
+		err := a.sender.send(lines)
+		if err == nil {
+			return true
+		}
+		logger.Warn("Send audit stream [%s] error: %s, retry after %s.", a.address, err.Error(), backoff)
+		select {
+		case <-time.After(backoff):
+		case <-a.stopChan:
+			// try once more before quit.
+			if err := a.sender.send(lines); err != nil {
+				logger.Error("Send audit stream [%s] error: %s, %d entries lost.", a.address, err.Error(), len(lines))
+			}
+			return false
+		}
+		backoff *= 2
+		if backoff > streamMaxBackoff {
+			backoff = streamMaxBackoff
+		}
+	}
+}
+
+// flush send the buffered entries once when closing.
+func (a *streamAuditor) flush() {
+	for {
+		lines := a.fill(nil)
+		if len(lines) == 0 {
+			return
+		}
+		if err := a.sender.send(lines); err != nil {
+			logger.Error("Send audit stream [%s] error: %s, %d entries lost.", a.address, err.Error(), len(lines)+len(a.entries))
+			return
+		}
+	}
+}
+
+func (a *streamAuditor) Close() error {
+	close(a.stopChan)
+	<-a.done
+	return nil
+}
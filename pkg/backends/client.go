@@ -12,9 +12,11 @@ import (
 	"errors"
 	"path"
 	"strings"
+	"time"
 
 	"openpitrix.io/metad/pkg/backends/etcdv3"
 	"openpitrix.io/metad/pkg/backends/local"
+	"openpitrix.io/metad/pkg/backends/upstream"
 	"openpitrix.io/metad/pkg/logger"
 	"openpitrix.io/metad/pkg/store"
 )
@@ -23,13 +25,19 @@ import (
 // key/value pairs from a backend store.
 type StoreClient interface {
 	Get(nodePath string, dir bool) (interface{}, error)
+	// GetAtRevision/GetMappingAtRevision read the value of nodePath at a past backend revision,
+	// return nil if nodePath not exist at the revision, return error if the revision has been compacted or backend not support.
+	GetAtRevision(nodePath string, revision int64) (interface{}, error)
 	Put(nodePath string, value interface{}, replace bool) error
 	// Delete
 	// if the 'key' represent a dir, 'dir' should be true.
 	Delete(nodePath string, dir bool) error
+	// Move copy the values under 'from' to 'to' and delete 'from', in one transaction if backend support.
+	Move(from string, to string) error
 	Sync(store store.Store, stopChan chan bool)
 
 	GetMapping(nodePath string, dir bool) (interface{}, error)
+	GetMappingAtRevision(nodePath string, revision int64) (interface{}, error)
 	PutMapping(nodePath string, mapping interface{}, replace bool) error
 	DeleteMapping(nodePath string, dir bool) error
 	SyncMapping(mapping store.Store, stopChan chan bool)
@@ -38,6 +46,31 @@ type StoreClient interface {
 	PutAccessRule(rules map[string][]store.AccessRule) error
 	DeleteAccessRule(hosts []string) error
 	SyncAccessRule(accessStore store.AccessStore, stopChan chan bool)
+
+	// GetRecords/PutRecord/DeleteRecord/SyncRecords manage metad's internal records, such as releases,
+	// every kind of record is kept in a separate namespace of the group, the key is treated as a path.
+	GetRecords(kind string) (map[string]string, error)
+	PutRecord(kind string, key string, value string) error
+	DeleteRecord(kind string, key string) error
+	SyncRecords(kind string, recordStore store.RecordStore, stopChan chan bool)
+
+	// SyncStatus return whether all the syncs have received the initial values from backend,
+	// and how long the slowest sync is behind backend.
+	SyncStatus() (synced bool, lag time.Duration, err error)
+	// SyncedRevisions return the backend revisions which the data and mapping syncs have caught up with,
+	// the store reflect the backend at the revision, 0 if the backend has no revision.
+	SyncedRevisions() (data int64, mapping int64)
+
+	// Campaign campaign for the leader of the group as the candidate in background until stopChan closed, leaderChan
+	// receive true when the candidate became the leader and false when it lost the leadership, then it campaign again.
+	Campaign(candidate string, ttl int, leaderChan chan<- bool, stopChan chan bool)
+	// Leader return the candidate of the current leader of the group, empty if no leader.
+	Leader() (string, error)
+
+	// Endpoints return the health of the backend endpoints in the configured order, nil if the backend has no endpoint.
+	Endpoints() []*store.EndpointStatus
+	// SetEndpoints replace the backend endpoints at runtime without restart.
+	SetEndpoints(endpoints []string) error
 }
 
 // New is used to create a storage client based on our configuration.
@@ -54,17 +87,57 @@ func New(config Config) (StoreClient, error) {
 	if len(backendNodes) == 0 {
 		backendNodes = GetDefaultBackends(config.Backend)
 	}
+	client, err := newStoreClient(config, backendNodes)
+	if err != nil || len(config.EncryptionPrefixes) == 0 {
+		return client, err
+	}
+	return newEncryptedClient(client, config.EncryptionPrefixes, config.EncryptedRecords, config.EncryptionKey)
+}
+
+func newStoreClient(config Config, backendNodes []string) (StoreClient, error) {
 	switch config.Backend {
 	case "etcd", "etcdv3":
+		if err := readCredentialFiles(&config); err != nil {
+			return nil, err
+		}
 		// Create the etcdv3 client upfront and use it for the life of the process.
-		return etcdv3.NewEtcdClient(config.Group, config.Prefix, backendNodes, config.ClientCert, config.ClientKey, config.ClientCaKeys, config.BasicAuth, config.Username, config.Password)
+		client, err := etcdv3.NewEtcdClient(config.Group, config.Prefix, backendNodes, config.ClientCert, config.ClientKey, config.ClientCaKeys, config.BasicAuth, config.Username, config.Password)
+		if err != nil {
+			return nil, err
+		}
+		client.WatchCredentials(config.UsernameFile, config.PasswordFile)
+		return client, nil
 	case "local":
 		return local.NewLocalClient()
+	case "metad":
+		// a read replica of the upstream metad, the backend nodes are the upstream manage urls.
+		return upstream.NewUpstreamClient(backendNodes, config.ClientCert, config.ClientKey, config.ClientCaKeys, config.Password)
 	}
 
 	return nil, errors.New("Invalid backend")
 }
 
+// readCredentialFiles read the username and password from the files if configured, the basic auth is enabled by the
+// password file.
+func readCredentialFiles(config *Config) error {
+	if config.UsernameFile != "" {
+		username, err := etcdv3.ReadCredentialFile(config.UsernameFile)
+		if err != nil {
+			return err
+		}
+		config.Username = username
+	}
+	if config.PasswordFile != "" {
+		password, err := etcdv3.ReadCredentialFile(config.PasswordFile)
+		if err != nil {
+			return err
+		}
+		config.Password = password
+		config.BasicAuth = true
+	}
+	return nil
+}
+
 func GetDefaultBackends(backend string) []string {
 	switch backend {
 	case "etcd", "etcdv3":
@@ -9,14 +9,17 @@
 package backends
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"math/rand"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	. "openpitrix.io/metad/pkg/assert"
+	"openpitrix.io/metad/pkg/backends/etcdv3"
 	"openpitrix.io/metad/pkg/flatmap"
 	"openpitrix.io/metad/pkg/logger"
 	"openpitrix.io/metad/pkg/store"
@@ -317,7 +320,49 @@ func TestClientNoPrefix(t *testing.T) {
 }
 
 func TestClientSetMaxOps(t *testing.T) {
-	//TODO for etcd3 batch update max ops
+	maxOps := etcdv3.MaxOpsPerTxn
+	etcdv3.MaxOpsPerTxn = 4
+	defer func() {
+		etcdv3.MaxOpsPerTxn = maxOps
+	}()
+	for _, backend := range backendNodes {
+		storeClient := NewTestClient(backend)
+		Assert(t, nil == storeClient.Delete("/", true))
+
+		values := map[string]interface{}{}
+		for i := 0; i < 10; i++ {
+			values[fmt.Sprintf("%d", i)] = map[string]interface{}{"name": fmt.Sprintf("n%d", i)}
+		}
+		Assert(t, nil == storeClient.Put("/nodes", values, false))
+		val, err := storeClient.Get("/nodes", true)
+		Assert(t, nil == err)
+		Assert(t, reflect.DeepEqual(values, val), val)
+
+		// replace delete the stale keys in the batches.
+		values2 := map[string]interface{}{}
+		for i := 5; i < 12; i++ {
+			values2[fmt.Sprintf("%d", i)] = map[string]interface{}{"name": fmt.Sprintf("n%d", i)}
+		}
+		Assert(t, nil == storeClient.Put("/nodes", values2, true))
+		val, err = storeClient.Get("/nodes", true)
+		Assert(t, nil == err)
+		Assert(t, reflect.DeepEqual(values2, val), val)
+
+		hosts := make([]string, 0, 10)
+		rules := map[string][]store.AccessRule{}
+		for i := 0; i < 10; i++ {
+			host := fmt.Sprintf("192.168.1.%d", i)
+			hosts = append(hosts, host)
+			rules[host] = []store.AccessRule{{Path: "/nodes", Mode: store.AccessModeRead}}
+		}
+		Assert(t, nil == storeClient.PutAccessRule(rules))
+		Assert(t, nil == storeClient.DeleteAccessRule(hosts))
+		rulesGet, err := storeClient.GetAccessRule()
+		Assert(t, nil == err)
+		Assert(t, 0 == len(rulesGet), rulesGet)
+
+		storeClient.Delete("/", true)
+	}
 }
 
 func TestClientSync(t *testing.T) {
@@ -545,6 +590,164 @@ func TestAccessRule(t *testing.T) {
 	}
 }
 
+func TestClientMove(t *testing.T) {
+	for _, backend := range backendNodes {
+		storeClient := NewTestClient(backend)
+		err := storeClient.Delete("/", true)
+		Assert(t, nil == err)
+
+		err = storeClient.Put("/", map[string]interface{}{
+			"nodes":  map[string]interface{}{"1": "n1", "2": map[string]interface{}{"name": "n2"}},
+			"nodes1": "n",
+		}, false)
+		Assert(t, nil == err)
+
+		err = storeClient.Move("/nodes", "/hosts")
+		Assert(t, nil == err)
+
+		val, err := storeClient.Get("/", true)
+		Assert(t, nil == err)
+		Assert(t, reflect.DeepEqual(map[string]interface{}{
+			"hosts":  map[string]interface{}{"1": "n1", "2": map[string]interface{}{"name": "n2"}},
+			"nodes1": "n",
+		}, val), val)
+
+		storeClient.Delete("/", true)
+	}
+}
+
+func TestClientGetAtRevision(t *testing.T) {
+	storeClient := NewTestClient("etcdv3")
+	etcdClient := storeClient.(*etcdv3.Client)
+	err := storeClient.Delete("/", true)
+	Assert(t, nil == err)
+
+	err = storeClient.Put("/nodes", map[string]interface{}{"1": "n1"}, false)
+	Assert(t, nil == err)
+	err = storeClient.PutMapping("/192.168.1.1", map[string]interface{}{"node": "/nodes/1"}, false)
+	Assert(t, nil == err)
+	revision, err := etcdClient.Revision()
+	Assert(t, nil == err)
+
+	err = storeClient.Put("/nodes", map[string]interface{}{"1": "n1-new", "2": "n2"}, true)
+	Assert(t, nil == err)
+	err = storeClient.DeleteMapping("/192.168.1.1", true)
+	Assert(t, nil == err)
+
+	val, err := storeClient.GetAtRevision("/nodes", revision)
+	Assert(t, nil == err)
+	Assert(t, reflect.DeepEqual(map[string]interface{}{"1": "n1"}, val), val)
+	val, err = storeClient.GetAtRevision("/nodes/1", revision)
+	Assert(t, nil == err)
+	Assert(t, "n1" == val)
+	val, err = storeClient.GetAtRevision("/nodes/2", revision)
+	Assert(t, nil == err)
+	Assert(t, nil == val)
+	val, err = storeClient.GetMappingAtRevision("/192.168.1.1", revision)
+	Assert(t, nil == err)
+	Assert(t, reflect.DeepEqual(map[string]interface{}{"node": "/nodes/1"}, val), val)
+
+	storeClient.Delete("/", true)
+}
+
+func TestClientEncryption(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	for _, backend := range backendNodes {
+		println("Test backend: ", backend)
+		storeClient, err := New(Config{
+			Backend:            backend,
+			BackendNodes:       GetDefaultBackends(backend),
+			Prefix:             fmt.Sprintf("/prefix%v", rand.Intn(1000)),
+			EncryptionPrefixes: []string{"/secrets"},
+			EncryptionKey:      key,
+			EncryptedRecords:   []string{"release"},
+		})
+		Assert(t, nil == err, err)
+		raw := storeClient.(*encryptedClient).StoreClient
+		storeClient.Delete("/", true)
+
+		stopChan := make(chan bool)
+		metastore := store.New()
+		storeClient.Sync(metastore, stopChan)
+
+		err = storeClient.Put("/", map[string]interface{}{
+			"secrets": map[string]interface{}{"db": map[string]interface{}{"password": "pw"}},
+			"public":  "p",
+		}, false)
+		Assert(t, nil == err, err)
+
+		// encrypted in the backend, plain for the reads and the sync.
+		val, err := raw.Get("/secrets/db/password", false)
+		Assert(t, nil == err, err)
+		Assert(t, strings.HasPrefix(val.(string), encryptedValuePrefix), val)
+		val, err = raw.Get("/public", false)
+		Assert(t, nil == err && "p" == val, val)
+		val, err = storeClient.Get("/", true)
+		Assert(t, nil == err, err)
+		Assert(t, reflect.DeepEqual(map[string]interface{}{
+			"secrets": map[string]interface{}{"db": map[string]interface{}{"password": "pw"}},
+			"public":  "p",
+		}, val), val)
+		time.Sleep(1000 * time.Millisecond)
+		_, val = metastore.Get("/secrets/db/password")
+		Assert(t, "pw" == val, val)
+
+		// the encrypted value is bound to its path, the copy to another path is not decrypted.
+		sealed, _ := raw.Get("/secrets/db/password", false)
+		Assert(t, nil == raw.Put("/secrets/copy", sealed, false))
+		val, _ = storeClient.Get("/secrets/copy", false)
+		Assert(t, sealed == val, val)
+		Assert(t, nil == raw.Delete("/secrets/copy", false))
+
+		// the value encrypted without the path is still decrypted.
+		legacy := storeClient.(*encryptedClient).aead
+		nonce := make([]byte, legacy.NonceSize())
+		Assert(t, nil == raw.Put("/secrets/legacy", legacyEncryptedValuePrefix+base64.StdEncoding.EncodeToString(legacy.Seal(nonce, nonce, []byte("old"), nil)), false))
+		val, _ = storeClient.Get("/secrets/legacy", false)
+		Assert(t, "old" == val, val)
+		Assert(t, nil == raw.Delete("/secrets/legacy", false))
+
+		// the value moved into the prefix is encrypted, the value moved out is plain.
+		Assert(t, nil == storeClient.Move("/public", "/secrets/public"))
+		val, _ = raw.Get("/secrets/public", false)
+		Assert(t, strings.HasPrefix(val.(string), encryptedValuePrefix), val)
+		val, _ = storeClient.Get("/secrets/public", false)
+		Assert(t, "p" == val, val)
+		Assert(t, nil == storeClient.Move("/secrets/db", "/db"))
+		val, _ = raw.Get("/db/password", false)
+		Assert(t, "pw" == val, val)
+		time.Sleep(1000 * time.Millisecond)
+		_, val = metastore.Get("/")
+		Assert(t, reflect.DeepEqual(map[string]interface{}{
+			"secrets": map[string]interface{}{"public": "p"},
+			"db":      map[string]interface{}{"password": "pw"},
+		}, val), val)
+
+		// the records of the encrypted kinds are encrypted in the backend, plain for the reads and the sync.
+		recordStore := store.NewRecordStore()
+		storeClient.SyncRecords("release", recordStore, stopChan)
+		Assert(t, nil == storeClient.PutRecord("release", "r1", `{"name":"r1"}`))
+		Assert(t, nil == storeClient.PutRecord("token", "t1", `{"id":"t1"}`))
+		records, err := raw.GetRecords("release")
+		Assert(t, nil == err && strings.HasPrefix(records["/r1"], encryptedValuePrefix), records)
+		records, _ = raw.GetRecords("token")
+		Assert(t, `{"id":"t1"}` == records["/t1"], records)
+		records, _ = storeClient.GetRecords("release")
+		Assert(t, `{"name":"r1"}` == records["/r1"], records)
+		time.Sleep(1000 * time.Millisecond)
+		record, _ := recordStore.Get("/r1")
+		Assert(t, `{"name":"r1"}` == record, record)
+		storeClient.DeleteRecord("release", "r1")
+		storeClient.DeleteRecord("token", "t1")
+
+		stopChan <- true
+		storeClient.Delete("/", true)
+	}
+
+	_, err := New(Config{Backend: "local", EncryptionPrefixes: []string{"/secrets"}, EncryptionKey: []byte("short")})
+	Assert(t, err != nil)
+}
+
 func NewTestClient(backend string) StoreClient {
 	prefix := fmt.Sprintf("/prefix%v", rand.Intn(1000))
 	group := fmt.Sprintf("/group%v", rand.Intn(1000))
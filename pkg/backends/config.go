@@ -19,4 +19,12 @@ type Config struct {
 	BackendNodes []string
 	Password     string
 	Username     string
+	// UsernameFile/PasswordFile are the files of the etcd credential, checked periodically for rotation.
+	UsernameFile string
+	PasswordFile string
+	// EncryptionPrefixes are the data prefixes encrypted in the backend by the AES EncryptionKey.
+	EncryptionPrefixes []string
+	EncryptionKey      []byte
+	// EncryptedRecords are the record kinds encrypted as a whole with the EncryptionPrefixes, as they keep data values.
+	EncryptedRecords []string
 }
@@ -0,0 +1,334 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package backends
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"openpitrix.io/metad/pkg/logger"
+	"openpitrix.io/metad/pkg/store"
+)
+
+// encryptedValuePrefix is the prefix of the encrypted values in the backend, followed by the base64 of the nonce and
+// the AES-GCM sealed value, the additional data is the path of the value, so a value copied to another path in the
+// backend fails to decrypt instead of being served there.
+const encryptedValuePrefix = "metad:enc:v2:"
+
+// legacyEncryptedValuePrefix is the prefix of the values encrypted without the additional data, still decrypted.
+const legacyEncryptedValuePrefix = "metad:enc:v1:"
+
+// encryptedClient encrypt the data values under the sensitive prefixes before written to the backend, and decrypt
+// the values read or synced from the backend, so the values are only plain in memory and served through the access
+// rules. The records of the encrypted kinds, which keep the data values such as the release backups, are encrypted
+// as a whole.
+type encryptedClient struct {
+	StoreClient
+	prefixes []string
+	records  map[string]bool
+	aead     cipher.AEAD
+}
+
+// newEncryptedClient wrap the client to encrypt the data under prefixes and the records of the kinds with the AES
+// key, the key is 16, 24 or 32 bytes for AES-128, AES-192 or AES-256.
+func newEncryptedClient(client StoreClient, prefixes []string, records []string, key []byte) (StoreClient, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key: %s", err.Error())
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	c := &encryptedClient{StoreClient: client, records: map[string]bool{}, aead: aead}
+	for _, prefix := range prefixes {
+		c.prefixes = append(c.prefixes, path.Join("/", prefix))
+	}
+	for _, kind := range records {
+		c.records[kind] = true
+	}
+	return c, nil
+}
+
+// isSubPath check whether nodePath is the parent itself or under the parent.
+func isSubPath(nodePath string, parent string) bool {
+	if parent == "/" || nodePath == parent {
+		return true
+	}
+	return strings.HasPrefix(nodePath, parent+"/")
+}
+
+func (c *encryptedClient) sensitive(nodePath string) bool {
+	for _, prefix := range c.prefixes {
+		if isSubPath(nodePath, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// overlaps return whether any sensitive prefix is under or above nodePath.
+func (c *encryptedClient) overlaps(nodePath string) bool {
+	for _, prefix := range c.prefixes {
+		if isSubPath(nodePath, prefix) || isSubPath(prefix, nodePath) {
+			return true
+		}
+	}
+	return false
+}
+
+// dataAAD return the additional data of the value at the data path.
+func dataAAD(nodePath string) []byte {
+	return []byte(path.Join("/", nodePath))
+}
+
+// recordAAD return the additional data of the record, never same as a data path.
+func recordAAD(kind string, key string) []byte {
+	return []byte("record:" + kind + path.Join("/", key))
+}
+
+func isEncrypted(value string) bool {
+	return strings.HasPrefix(value, encryptedValuePrefix) || strings.HasPrefix(value, legacyEncryptedValuePrefix)
+}
+
+func (c *encryptedClient) encrypt(aad []byte, value string) (string, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	return encryptedValuePrefix + base64.StdEncoding.EncodeToString(c.aead.Seal(nonce, nonce, []byte(value), aad)), nil
+}
+
+func (c *encryptedClient) decrypt(aad []byte, value string) (string, error) {
+	var sealed []byte
+	var err error
+	switch {
+	case strings.HasPrefix(value, encryptedValuePrefix):
+		sealed, err = base64.StdEncoding.DecodeString(value[len(encryptedValuePrefix):])
+	case strings.HasPrefix(value, legacyEncryptedValuePrefix):
+		sealed, err = base64.StdEncoding.DecodeString(value[len(legacyEncryptedValuePrefix):])
+		aad = nil
+	default:
+		return value, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < c.aead.NonceSize() {
+		return "", errors.New("encrypted value is too short.")
+	}
+	nonce := sealed[:c.aead.NonceSize()]
+	plain, err := c.aead.Open(nil, nonce, sealed[c.aead.NonceSize():], aad)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+// decryptValue decrypt the value synced from the backend, the value failed to decrypt (such as encrypted by another
+// key or copied from another path) is kept encrypted, so it is never served as plain.
+func (c *encryptedClient) decryptValue(nodePath string, value string) string {
+	plain, err := c.decrypt(dataAAD(nodePath), value)
+	if err != nil {
+		logger.Error("Decrypt value of %s error: %s", nodePath, err.Error())
+		return value
+	}
+	return plain
+}
+
+func (c *encryptedClient) encryptValue(nodePath string, value string) (string, error) {
+	if c.sensitive(nodePath) && !isEncrypted(value) {
+		return c.encrypt(dataAAD(nodePath), value)
+	}
+	return value, nil
+}
+
+// encryptTree encrypt the sensitive leaves of the value at nodePath, see transform.
+func (c *encryptedClient) encryptTree(nodePath string, value interface{}) (interface{}, error) {
+	var err error
+	encrypted := transform(nodePath, value, func(nodePath string, value string) string {
+		v, e := c.encryptValue(nodePath, value)
+		if e != nil && err == nil {
+			err = e
+		}
+		return v
+	})
+	return encrypted, err
+}
+
+// transform apply fn to the leaf values of the value at nodePath, the value is a string or a tree of maps and slices
+// as accepted by Put.
+func transform(nodePath string, value interface{}, fn func(nodePath string, value string) string) interface{} {
+	switch t := value.(type) {
+	case string:
+		return fn(nodePath, t)
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(t))
+		for k, v := range t {
+			result[k] = transform(path.Join(nodePath, k), v, fn)
+		}
+		return result
+	case map[string]string:
+		result := make(map[string]interface{}, len(t))
+		for k, v := range t {
+			result[k] = transform(path.Join(nodePath, k), v, fn)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(t))
+		for i, v := range t {
+			result[i] = transform(path.Join(nodePath, fmt.Sprintf("%d", i)), v, fn)
+		}
+		return result
+	case nil:
+		return nil
+	default:
+		return fn(nodePath, fmt.Sprintf("%v", t))
+	}
+}
+
+func (c *encryptedClient) Get(nodePath string, dir bool) (interface{}, error) {
+	value, err := c.StoreClient.Get(nodePath, dir)
+	if err != nil {
+		return nil, err
+	}
+	return transform(nodePath, value, c.decryptValue), nil
+}
+
+func (c *encryptedClient) GetAtRevision(nodePath string, revision int64) (interface{}, error) {
+	value, err := c.StoreClient.GetAtRevision(nodePath, revision)
+	if err != nil {
+		return nil, err
+	}
+	return transform(nodePath, value, c.decryptValue), nil
+}
+
+func (c *encryptedClient) Put(nodePath string, value interface{}, replace bool) error {
+	encrypted, err := c.encryptTree(nodePath, value)
+	if err != nil {
+		return err
+	}
+	return c.StoreClient.Put(nodePath, encrypted, replace)
+}
+
+// Move move the values as is, then the moved values are put again if any was encrypted or moved into the sensitive
+// prefixes, as the encrypted values are bound to their paths: the values under the prefixes are encrypted for the
+// new paths, the others are plain.
+func (c *encryptedClient) Move(from string, to string) error {
+	raw, err := c.StoreClient.Get(from, true)
+	if err != nil {
+		return err
+	}
+	encrypted := false
+	transform(from, raw, func(nodePath string, value string) string {
+		encrypted = encrypted || isEncrypted(value)
+		return value
+	})
+	if err := c.StoreClient.Move(from, to); err != nil {
+		return err
+	}
+	if !encrypted && !c.overlaps(to) {
+		return nil
+	}
+	return c.Put(to, transform(from, raw, c.decryptValue), false)
+}
+
+// decryptRecord decrypt the record of the encrypted kinds, the record failed to decrypt is kept encrypted.
+func (c *encryptedClient) decryptRecord(kind string, key string, value string) string {
+	if !c.records[kind] {
+		return value
+	}
+	plain, err := c.decrypt(recordAAD(kind, key), value)
+	if err != nil {
+		logger.Error("Decrypt %s record %s error: %s", kind, key, err.Error())
+		return value
+	}
+	return plain
+}
+
+func (c *encryptedClient) GetRecords(kind string) (map[string]string, error) {
+	records, err := c.StoreClient.GetRecords(kind)
+	if err != nil || !c.records[kind] {
+		return records, err
+	}
+	plain := make(map[string]string, len(records))
+	for k, v := range records {
+		plain[k] = c.decryptRecord(kind, k, v)
+	}
+	return plain, nil
+}
+
+func (c *encryptedClient) PutRecord(kind string, key string, value string) error {
+	if c.records[kind] {
+		var err error
+		if value, err = c.encrypt(recordAAD(kind, key), value); err != nil {
+			return err
+		}
+	}
+	return c.StoreClient.PutRecord(kind, key, value)
+}
+
+func (c *encryptedClient) SyncRecords(kind string, recordStore store.RecordStore, stopChan chan bool) {
+	if c.records[kind] {
+		recordStore = &decryptRecordStore{RecordStore: recordStore, client: c, kind: kind}
+	}
+	c.StoreClient.SyncRecords(kind, recordStore, stopChan)
+}
+
+func (c *encryptedClient) Sync(s store.Store, stopChan chan bool) {
+	c.StoreClient.Sync(&decryptStore{Store: s, client: c}, stopChan)
+}
+
+// decryptStore decrypt the values put by the backend sync.
+type decryptStore struct {
+	store.Store
+	client *encryptedClient
+}
+
+func (s *decryptStore) Put(nodePath string, value interface{}) {
+	s.Store.Put(nodePath, transform(nodePath, value, s.client.decryptValue))
+}
+
+func (s *decryptStore) PutBulk(nodePath string, values map[string]string) {
+	plain := make(map[string]string, len(values))
+	for k, v := range values {
+		plain[k] = s.client.decryptValue(path.Join(nodePath, k), v)
+	}
+	s.Store.PutBulk(nodePath, plain)
+}
+
+func (s *decryptStore) Load(values map[string]string) bool {
+	plain := make(map[string]string, len(values))
+	for k, v := range values {
+		plain[k] = s.client.decryptValue(path.Join("/", k), v)
+	}
+	return s.Store.Load(plain)
+}
+
+// decryptRecordStore decrypt the records put by the backend sync.
+type decryptRecordStore struct {
+	store.RecordStore
+	client *encryptedClient
+	kind   string
+}
+
+func (s *decryptRecordStore) Put(key string, value string) {
+	s.RecordStore.Put(key, s.client.decryptRecord(s.kind, key, value))
+}
+
+func (s *decryptRecordStore) Puts(values map[string]string) {
+	plain := make(map[string]string, len(values))
+	for k, v := range values {
+		plain[k] = s.client.decryptRecord(s.kind, k, v)
+	}
+	s.RecordStore.Puts(plain)
+}
@@ -10,12 +10,10 @@ package etcdv3
 
 import (
 	"context"
-	"crypto/tls"
-	"crypto/x509"
 	"fmt"
-	"io/ioutil"
 	"path"
 	"reflect"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -25,24 +23,45 @@ import (
 
 	"openpitrix.io/metad/pkg/flatmap"
 	"openpitrix.io/metad/pkg/logger"
+	"openpitrix.io/metad/pkg/redact"
 	"openpitrix.io/metad/pkg/store"
 	"openpitrix.io/metad/pkg/util"
 )
 
+const META_PATH = "/_metad"
 const SELF_MAPPING_PATH = "/_metad/mapping"
 const RULE_PATH = "/_metad/rule"
 
 var (
 	//see github.com/coreos/etcd/etcdserver/api/v3rpc/key.go
 	MaxOpsPerTxn = 128
+	// GetPageSize is the max keys of one range request, the larger prefix is read in pages at the same revision,
+	// so a large initial load does not exceed the etcd request size limit.
+	GetPageSize int64 = 1000
 )
 
 // Client is a wrapper around the etcd client
 type Client struct {
 	client        *client.Client
 	prefix        string
+	group         string
 	mappingPrefix string
 	rulePrefix    string
+	syncStates    map[string]*syncState
+	syncLock      sync.Mutex
+	certs         *certReloader
+	// endpoints are the configured endpoints, the etcd client use them in the order of health.
+	endpoints  []string
+	health     map[string]*store.EndpointStatus
+	healthLock sync.Mutex
+}
+
+// syncState is the state of the sync of a prefix, revision is the etcd revision the sync has caught up with,
+// behindSince is the time first found the sync is behind etcd.
+type syncState struct {
+	init        bool
+	revision    int64
+	behindSince time.Time
 }
 
 // NewEtcdClient returns an *etcd.Client with a connection to named machines.
@@ -50,10 +69,6 @@ func NewEtcdClient(group string, prefix string, machines []string, cert, key, ca
 	var c *client.Client
 	var err error
 
-	tlsConfig := &tls.Config{
-		InsecureSkipVerify: false,
-	}
-
 	cfg := client.Config{
 		Endpoints:   machines,
 		DialTimeout: time.Duration(3) * time.Second,
@@ -64,35 +79,30 @@ func NewEtcdClient(group string, prefix string, machines []string, cert, key, ca
 		cfg.Password = password
 	}
 
-	if caCert != "" {
-		certBytes, err := ioutil.ReadFile(caCert)
-		if err != nil {
-			return nil, err
-		}
-
-		caCertPool := x509.NewCertPool()
-		ok := caCertPool.AppendCertsFromPEM(certBytes)
-
-		if ok {
-			tlsConfig.RootCAs = caCertPool
-		}
-	}
-
-	if cert != "" && key != "" {
-		tlsCert, err := tls.LoadX509KeyPair(cert, key)
-		if err != nil {
-			return nil, err
-		}
-		tlsConfig.Certificates = []tls.Certificate{tlsCert}
+	// the cert and ca files are reloaded by WatchCredentials.
+	certs, err := newCertReloader(cert, key, caCert)
+	if err != nil {
+		return nil, err
 	}
-
-	cfg.TLS = tlsConfig
+	cfg.TLS = certs.tlsConfig()
 
 	c, err = client.New(cfg)
 	if err != nil {
 		return nil, err
 	}
-	return &Client{c, prefix, path.Join(SELF_MAPPING_PATH, group), path.Join(RULE_PATH, group)}, nil
+	etcdClient := &Client{
+		client:        c,
+		prefix:        prefix,
+		group:         group,
+		mappingPrefix: path.Join(SELF_MAPPING_PATH, group),
+		rulePrefix:    path.Join(RULE_PATH, group),
+		syncStates:    make(map[string]*syncState),
+		certs:         certs,
+		endpoints:     append([]string{}, machines...),
+		health:        make(map[string]*store.EndpointStatus),
+	}
+	go etcdClient.checkHealth()
+	return etcdClient, nil
 }
 
 // Get queries etcd for nodePath.
@@ -108,6 +118,10 @@ func (c *Client) Get(nodePath string, dir bool) (interface{}, error) {
 	}
 }
 
+func (c *Client) GetAtRevision(nodePath string, revision int64) (interface{}, error) {
+	return c.internalGetAtRevision(c.prefix, nodePath, revision)
+}
+
 func (c *Client) Put(nodePath string, value interface{}, replace bool) error {
 	return c.internalPut(c.prefix, nodePath, value, replace)
 }
@@ -116,6 +130,10 @@ func (c *Client) Delete(nodePath string, dir bool) error {
 	return c.internalDelete(c.prefix, nodePath, dir)
 }
 
+func (c *Client) Move(from string, to string) error {
+	return c.internalMove(c.prefix, from, to)
+}
+
 func (c *Client) Sync(store store.Store, stopChan chan bool) {
 	initWG := &sync.WaitGroup{}
 	initWG.Add(1)
@@ -135,6 +153,10 @@ func (c *Client) GetMapping(nodePath string, dir bool) (interface{}, error) {
 	}
 }
 
+func (c *Client) GetMappingAtRevision(nodePath string, revision int64) (interface{}, error) {
+	return c.internalGetAtRevision(c.mappingPrefix, nodePath, revision)
+}
+
 func (c *Client) PutMapping(nodePath string, mapping interface{}, replace bool) error {
 	logger.Debug("UpdateMapping nodePath:%s, mapping:%v, replace:%v", nodePath, mapping, replace)
 	return c.internalPut(c.mappingPrefix, nodePath, mapping, replace)
@@ -179,16 +201,14 @@ func (c *Client) PutAccessRule(rules map[string][]store.AccessRule) error {
 }
 
 func (c *Client) DeleteAccessRule(hosts []string) error {
+	ops := make([]client.Op, 0, len(hosts))
 	for _, host := range hosts {
 		if host == "" {
 			continue
 		}
-		err := c.internalDelete(c.rulePrefix, path.Join("/", host), false)
-		if err != nil {
-			return err
-		}
+		ops = append(ops, client.OpDelete(util.AppendPathPrefix(path.Join("/", host), c.rulePrefix)))
 	}
-	return nil
+	return c.commitOps(ops)
 }
 
 func (c *Client) SyncAccessRule(accessStore store.AccessStore, stopChan chan bool) {
@@ -199,6 +219,12 @@ func (c *Client) SyncAccessRule(accessStore store.AccessStore, stopChan chan boo
 		if err != nil {
 			return err
 		}
+		// drop the rules deleted while the watch was compacted.
+		for host := range accessStore.GetAccessRule(nil) {
+			if _, ok := val[host]; !ok {
+				accessStore.Delete(host)
+			}
+		}
 		accessStore.Puts(val)
 		return nil
 	}, func(event *client.Event, nodePath, value string) {
@@ -219,21 +245,131 @@ func (c *Client) SyncAccessRule(accessStore store.AccessStore, stopChan chan boo
 	initWG.Wait()
 }
 
+func (c *Client) recordPrefix(kind string) string {
+	return path.Join(META_PATH, kind, c.group)
+}
+
+func (c *Client) GetRecords(kind string) (map[string]string, error) {
+	return c.internalGets(c.recordPrefix(kind), "/")
+}
+
+func (c *Client) PutRecord(kind string, key string, value string) error {
+	return c.internalPutValue(c.recordPrefix(kind), path.Join("/", key), value)
+}
+
+func (c *Client) DeleteRecord(kind string, key string) error {
+	return c.internalDelete(c.recordPrefix(kind), path.Join("/", key), false)
+}
+
+func (c *Client) SyncRecords(kind string, recordStore store.RecordStore, stopChan chan bool) {
+	initWG := &sync.WaitGroup{}
+	initWG.Add(1)
+	go c.internalSync(c.recordPrefix(kind), stopChan, initWG, func() error {
+		val, err := c.GetRecords(kind)
+		if err != nil {
+			return err
+		}
+		// drop the records deleted while the watch was compacted.
+		for key := range recordStore.GetAll() {
+			if _, ok := val[key]; !ok {
+				recordStore.Delete(key)
+			}
+		}
+		recordStore.Puts(val)
+		return nil
+	}, func(event *client.Event, nodePath, value string) {
+		switch event.Type {
+		case mvccpb.PUT:
+			recordStore.Put(nodePath, value)
+		case mvccpb.DELETE:
+			recordStore.Delete(nodePath)
+		default:
+			logger.Warn("Unknow watch event type: %s ", event.Type)
+		}
+	})
+	initWG.Wait()
+}
+
 func (c *Client) internalGets(prefix, nodePath string) (map[string]string, error) {
 	vars := make(map[string]string)
-	resp, err := c.client.Get(context.Background(), util.AppendPathPrefix(nodePath, prefix), client.WithPrefix())
+	kvs, _, err := c.getPrefix(util.AppendPathPrefix(nodePath, prefix), 0)
 	if err != nil {
 		return nil, err
 	}
 
-	err = handleGetResp(prefix, resp, vars)
+	err = handleGetResp(prefix, kvs, vars)
 	if err != nil {
 		return nil, err
 	}
-	logger.Debug("GetValues prefix:%s, nodePath:%s, resp:%v", prefix, nodePath, vars)
+	if prefix == c.prefix {
+		logger.Debug("GetValues prefix:%s, nodePath:%s, resp:%v", prefix, nodePath, redact.Values("/", vars))
+	} else {
+		logger.Debug("GetValues prefix:%s, nodePath:%s, resp:%v", prefix, nodePath, vars)
+	}
 	return vars, nil
 }
 
+// getPrefix read the keys with the prefix in pages of GetPageSize, at the revision if not 0, otherwise at the
+// revision of the first page, and return the revision read at. The later pages fail with ErrCompacted if the revision
+// is compacted during the read.
+func (c *Client) getPrefix(key string, revision int64, opts ...client.OpOption) ([]*mvccpb.KeyValue, int64, error) {
+	end := client.GetPrefixRangeEnd(key)
+	start := key
+	var kvs []*mvccpb.KeyValue
+	for {
+		pageOpts := append([]client.OpOption{client.WithRange(end), client.WithLimit(GetPageSize)}, opts...)
+		if revision > 0 {
+			pageOpts = append(pageOpts, client.WithRev(revision))
+		}
+		resp, err := c.client.Get(context.Background(), start, pageOpts...)
+		if err != nil {
+			return nil, 0, err
+		}
+		if revision == 0 {
+			revision = resp.Header.Revision
+		}
+		kvs = append(kvs, resp.Kvs...)
+		if !resp.More || len(resp.Kvs) == 0 {
+			return kvs, revision, nil
+		}
+		// the next page start after the last key.
+		start = string(resp.Kvs[len(resp.Kvs)-1].Key) + "\x00"
+	}
+}
+
+// Revision return the current revision of etcd.
+func (c *Client) Revision() (int64, error) {
+	resp, err := c.client.Get(context.Background(), META_PATH, client.WithCountOnly())
+	if err != nil {
+		return 0, err
+	}
+	return resp.Header.Revision, nil
+}
+
+func (c *Client) internalGetAtRevision(prefix, nodePath string, revision int64) (interface{}, error) {
+	key := util.AppendPathPrefix(nodePath, prefix)
+	kvs, _, err := c.getPrefix(key, revision)
+	if err != nil {
+		return nil, err
+	}
+	vars := make(map[string]string)
+	for _, kv := range kvs {
+		k := string(kv.Key)
+		if k == key {
+			return string(kv.Value), nil
+		}
+	}
+	err = handleGetResp(prefix, kvs, vars)
+	if err != nil {
+		return nil, err
+	}
+	m := flatmap.Expand(vars, nodePath)
+	if len(m) == 0 {
+		return nil, nil
+	}
+	return m, nil
+}
+
 func (c *Client) internalGet(prefix, nodePath string) (string, error) {
 	resp, err := c.client.Get(context.Background(), util.AppendPathPrefix(nodePath, prefix))
 	if err != nil {
@@ -247,27 +383,114 @@ func (c *Client) internalGet(prefix, nodePath string) (string, error) {
 }
 
 // nodeWalk recursively descends nodes, updating vars.
-func handleGetResp(prefix string, resp *client.GetResponse, vars map[string]string) error {
-	if resp != nil {
-		kvs := resp.Kvs
-		for _, kv := range kvs {
-			key := string(kv.Key)
-			value := string(kv.Value)
-			// avoid output mapping config as metadata when prefix is "/"
-			if (prefix == "" || prefix == "/") && strings.HasPrefix(key, SELF_MAPPING_PATH) {
-				continue
-			}
-			vars[util.TrimPathPrefix(key, prefix)] = value
+// redactValue mask the value for the logs if it is the data under the sensitive prefixes.
+func (c *Client) redactValue(prefix string, nodePath string, value string) string {
+	if prefix != c.prefix {
+		return value
+	}
+	return redact.Value(nodePath, value)
+}
+
+func handleGetResp(prefix string, kvs []*mvccpb.KeyValue, vars map[string]string) error {
+	for _, kv := range kvs {
+		key := string(kv.Key)
+		value := string(kv.Value)
+		// avoid output metad config as metadata when prefix is "/"
+		if (prefix == "" || prefix == "/") && strings.HasPrefix(key, META_PATH+"/") {
+			continue
 		}
-		//TODO handle resp.More for pages
+		vars[util.TrimPathPrefix(key, prefix)] = value
 	}
 	return nil
 }
 
+func (c *Client) initSyncState(prefix string) {
+	c.syncLock.Lock()
+	defer c.syncLock.Unlock()
+	c.syncStates[prefix] = &syncState{}
+}
+
+func (c *Client) updateSyncState(prefix string, revision int64) {
+	c.syncLock.Lock()
+	defer c.syncLock.Unlock()
+	state, ok := c.syncStates[prefix]
+	if !ok {
+		return
+	}
+	state.init = true
+	if revision > state.revision {
+		state.revision = revision
+	}
+}
+
+func (c *Client) SyncedRevisions() (int64, int64) {
+	c.syncLock.Lock()
+	defer c.syncLock.Unlock()
+	var data, mapping int64
+	if state, ok := c.syncStates[c.prefix]; ok {
+		data = state.revision
+	}
+	if state, ok := c.syncStates[c.mappingPrefix]; ok {
+		mapping = state.revision
+	}
+	return data, mapping
+}
+
+// SyncStatus check whether all the syncs have been initialized, and how long the slowest sync is behind etcd,
+// a sync is behind if a key of the prefix has been modified after the revision the sync caught up with.
+func (c *Client) SyncStatus() (bool, time.Duration, error) {
+	c.syncLock.Lock()
+	revisions := make(map[string]int64, len(c.syncStates))
+	for prefix, state := range c.syncStates {
+		if !state.init {
+			c.syncLock.Unlock()
+			return false, 0, nil
+		}
+		revisions[prefix] = state.revision
+	}
+	c.syncLock.Unlock()
+	if len(revisions) == 0 {
+		return false, 0, nil
+	}
+
+	modRevisions := make(map[string]int64, len(revisions))
+	for prefix := range revisions {
+		resp, err := c.client.Get(context.Background(), prefix, client.WithPrefix(), client.WithKeysOnly(),
+			client.WithSort(client.SortByModRevision, client.SortDescend), client.WithLimit(1))
+		if err != nil {
+			return true, 0, err
+		}
+		if len(resp.Kvs) > 0 {
+			modRevisions[prefix] = resp.Kvs[0].ModRevision
+		}
+	}
+
+	now := time.Now()
+	var lag time.Duration
+	c.syncLock.Lock()
+	defer c.syncLock.Unlock()
+	for prefix, state := range c.syncStates {
+		if modRevisions[prefix] <= state.revision {
+			state.behindSince = time.Time{}
+			continue
+		}
+		if state.behindSince.IsZero() {
+			state.behindSince = now
+		}
+		if l := now.Sub(state.behindSince); l > lag {
+			lag = l
+		}
+	}
+	return true, lag, nil
+}
+
 func (c *Client) internalSync(prefix string, stopChan chan bool, initWG *sync.WaitGroup, initStoreFunc func() error, processChangeFunc func(event *client.Event, nodePath, value string)) {
 	var rev int64 = 0
 	init := false
+	// waiting is whether the initial load is not done, init is reset for the resync after compaction.
+	waiting := true
 	stop := false
+	c.initSyncState(prefix)
 	cancelRoutine := make(chan bool)
 	defer close(cancelRoutine)
 
@@ -289,7 +512,7 @@ func (c *Client) internalSync(prefix string, stopChan chan bool, initWG *sync.Wa
 
 	for {
 		if stop {
-			if !init {
+			if waiting {
 				initWG.Done()
 			}
 			return
@@ -301,7 +524,9 @@ func (c *Client) internalSync(prefix string, stopChan chan bool, initWG *sync.Wa
 		}
 		for !init {
 			if stop {
-				initWG.Done()
+				if waiting {
+					initWG.Done()
+				}
 				return
 			}
 			err := initStoreFunc()
@@ -313,23 +538,43 @@ func (c *Client) internalSync(prefix string, stopChan chan bool, initWG *sync.Wa
 			}
 			logger.Info("Init store for prefix %s success.", prefix)
 			init = true
-			initWG.Done()
+			// the values are up to date with the current revision, the later changes are delivered by watch.
+			currentRev, _ := c.Revision()
+			c.updateSyncState(prefix, currentRev)
+			if waiting {
+				waiting = false
+				initWG.Done()
+			}
 		}
 		for resp := range watchChan {
+			if resp.CompactRevision != 0 {
+				// the changes since rev have been compacted away, load all the values again and watch from now.
+				logger.Warn("Sync %s watch revision %d has been compacted at %d, resync.", prefix, rev, resp.CompactRevision)
+				init = false
+				rev = 0
+				break
+			}
+			if err := resp.Err(); err != nil {
+				logger.Warn("Sync %s watch error: %s, rewatch.", prefix, err.Error())
+				time.Sleep(time.Second)
+				break
+			}
 			for _, event := range resp.Events {
 				nodePath := string(event.Kv.Key)
-				// avoid sync mapping config as metadata when prefix is "/"
-				if (prefix == "" || prefix == "/") && (strings.HasPrefix(nodePath, SELF_MAPPING_PATH) || strings.HasPrefix(nodePath, RULE_PATH)) {
+				// avoid sync metad config as metadata when prefix is "/"
+				if (prefix == "" || prefix == "/") && strings.HasPrefix(nodePath, META_PATH+"/") {
 					continue
 				}
 
 				nodePath = util.TrimPathPrefix(nodePath, prefix)
 				value := string(event.Kv.Value)
-				logger.Debug("process sync change, event_type: %s, prefix: %v, nodePath:%v, value: %v ", event.Type, prefix, nodePath, value)
+				logger.Debug("process sync change, event_type: %s, prefix: %v, nodePath:%v, value: %v ", event.Type, prefix, nodePath, c.redactValue(prefix, nodePath, value))
 				processChangeFunc(event, nodePath, value)
 			}
 			rev = resp.Header.Revision
+			c.updateSyncState(prefix, rev)
 		}
+		cancel()
 	}
 }
 
@@ -339,6 +584,22 @@ func (c *Client) newInitStoreFunc(prefix string, store store.Store) func() error
 		if err != nil {
 			return err
 		}
+		// the cold start load the values in bulk.
+		if store.Load(val) {
+			return nil
+		}
+		// drop the values not in backend, such as the stale values loaded from the local cache.
+		if _, old := store.Get("/"); old != nil {
+			keys := make(map[string]bool, len(val))
+			for k := range val {
+				keys[path.Join("/", k)] = true
+			}
+			for k := range flatmap.Flatten(old) {
+				if !keys[path.Join("/", k)] {
+					store.Delete(k)
+				}
+			}
+		}
 		store.PutBulk("/", val)
 		return nil
 	}
@@ -373,50 +634,148 @@ func (c *Client) internalPut(prefix, nodePath string, value interface{}, replace
 	}
 }
 
+// internalPutValues put the values under nodePath in transactions of at most MaxOpsPerTxn ops. If replace, the old
+// keys not in values are deleted with the puts, so the replace is atomic if it fits in one transaction, and the
+// unchanged keys never disappear between the transactions.
 func (c *Client) internalPutValues(prefix string, nodePath string, values map[string]string, replace bool) error {
 
 	new_prefix := util.AppendPathPrefix(nodePath, prefix)
+	keys := make([]string, 0, len(values))
+	puts := make(map[string]bool, len(values))
+	for k := range values {
+		key := util.AppendPathPrefix(k, new_prefix)
+		keys = append(keys, k)
+		puts[key] = true
+	}
+	// commit the values in key order, so a failed batch leave the sorted prefix of the keys applied.
+	sort.Strings(keys)
 	ops := make([]client.Op, 0, len(values)+1)
+	for _, k := range keys {
+		key := util.AppendPathPrefix(k, new_prefix)
+		ops = append(ops, client.OpPut(key, values[k]))
+		logger.Debug("SetValue prefix:%s, nodePath:%s, value:%s", new_prefix, key, c.redactValue(prefix, path.Join(nodePath, k), values[k]))
+	}
 	if replace {
-		//delete and put can not in same txn.
-		c.internalDelete(prefix, nodePath, true)
+		// etcd reject the delete range overlapping the puts in one txn, so delete the stale keys one by one.
+		stale, err := c.staleKeys(new_prefix, puts)
+		if err != nil {
+			return err
+		}
+		for _, key := range stale {
+			ops = append(ops, client.OpDelete(key))
+		}
 	}
-	for k, v := range values {
-		k = util.AppendPathPrefix(k, new_prefix)
-		ops = append(ops, client.OpPut(k, v))
-		logger.Debug("SetValue prefix:%s, nodePath:%s, value:%s", new_prefix, k, v)
+	return c.commitOps(ops)
+}
+
+// staleKeys return the existing keys of nodeKey and under it which are not in keys, the metad keys are kept if
+// nodeKey is the root.
+func (c *Client) staleKeys(nodeKey string, keys map[string]bool) ([]string, error) {
+	kvs, _, err := c.getPrefix(nodeKey, 0, client.WithKeysOnly())
+	if err != nil {
+		return nil, err
 	}
-	for ok := true; ok; {
-		var commitOps []client.Op
-		if len(ops) > MaxOpsPerTxn {
+	dirKey := strings.TrimRight(nodeKey, "/") + "/"
+	var stale []string
+	for _, kv := range kvs {
+		key := string(kv.Key)
+		// avoid delete "/nodes1" when replace "/nodes".
+		if key != nodeKey && !strings.HasPrefix(key, dirKey) {
+			continue
+		}
+		if nodeKey == "/" && strings.HasPrefix(key, META_PATH+"/") {
+			continue
+		}
+		if !keys[key] {
+			stale = append(stale, key)
+		}
+	}
+	return stale, nil
+}
+
+// commitOps commit the ops in transactions of at most MaxOpsPerTxn ops, every transaction is atomic, the ops
+// after a failed transaction are not applied.
+func (c *Client) commitOps(ops []client.Op) error {
+	for len(ops) > 0 {
+		commitOps := ops
+		if len(commitOps) > MaxOpsPerTxn {
 			commitOps = ops[:MaxOpsPerTxn]
-			ops = ops[MaxOpsPerTxn:]
-		} else {
-			commitOps = ops
-			ok = false
 		}
+		ops = ops[len(commitOps):]
 		txn := c.client.Txn(context.TODO())
 		txn.Then(commitOps...)
 		resp, err := txn.Commit()
-		logger.Debug("SetValues err:%v, resp:%v", err, resp)
+		logger.Debug("Commit %d ops err:%v, resp:%v", len(commitOps), err, resp)
 		if err != nil {
 			return err
 		}
 	}
-
 	return nil
 }
 
 func (c *Client) internalPutValue(prefix string, nodePath string, value string) error {
-	nodePath = util.AppendPathPrefix(nodePath, prefix)
-	resp, err := c.client.Put(context.TODO(), nodePath, value)
-	logger.Debug("SetValue nodePath: %s, value:%s, resp:%v", nodePath, value, resp)
+	key := util.AppendPathPrefix(nodePath, prefix)
+	resp, err := c.client.Put(context.TODO(), key, value)
+	logger.Debug("SetValue nodePath: %s, value:%s, resp:%v", key, c.redactValue(prefix, nodePath, value), resp)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+func (c *Client) internalMove(prefix, from, to string) error {
+	fromKey := util.AppendPathPrefix(from, prefix)
+	toKey := util.AppendPathPrefix(to, prefix)
+	kvs, _, err := c.getPrefix(fromKey, 0)
+	if err != nil {
+		return err
+	}
+	putOps := make([]client.Op, 0, len(kvs))
+	for _, kv := range kvs {
+		key := string(kv.Key)
+		// avoid move "/nodes1" when move "/nodes".
+		if key != fromKey && !strings.HasPrefix(key, fromKey+"/") {
+			continue
+		}
+		newKey := toKey + key[len(fromKey):]
+		logger.Debug("Move in backend, key:%s, newKey:%s", key, newKey)
+		putOps = append(putOps, client.OpPut(newKey, string(kv.Value)))
+	}
+	if len(putOps) == 0 {
+		return nil
+	}
+	deleteOps := []client.Op{client.OpDelete(fromKey), client.OpDelete(fromKey+"/", client.WithPrefix())}
+	if len(putOps)+len(deleteOps) <= MaxOpsPerTxn {
+		txn := c.client.Txn(context.TODO())
+		txn.Then(append(putOps, deleteOps...)...)
+		_, err = txn.Commit()
+		return err
+	}
+	// too many values for one txn, put all values to new path first, then delete the old path,
+	// the new path did not exist, so the committed puts are rolled back by deleting it if one txn fail.
+	if err = c.commitOps(putOps); err != nil {
+		return c.rollbackMove(from, to, toKey, err)
+	}
+	txn := c.client.Txn(context.TODO())
+	txn.Then(deleteOps...)
+	if _, err = txn.Commit(); err != nil {
+		return c.rollbackMove(from, to, toKey, err)
+	}
+	return nil
+}
+
+// rollbackMove delete the values put to the new path by a failed move, return the move error,
+// or the error of both if the rollback also failed, the move is partially applied in that case.
+func (c *Client) rollbackMove(from, to, toKey string, moveErr error) error {
+	logger.Error("Move [%s] to [%s] error: %s, rollback.", from, to, moveErr.Error())
+	txn := c.client.Txn(context.TODO())
+	txn.Then(client.OpDelete(toKey), client.OpDelete(toKey+"/", client.WithPrefix()))
+	if _, err := txn.Commit(); err != nil {
+		return fmt.Errorf("move [%s] to [%s] partially applied, both paths may exist: %s, rollback error: %s", from, to, moveErr.Error(), err.Error())
+	}
+	return moveErr
+}
+
 func (c *Client) internalDelete(prefix, nodePath string, dir bool) error {
 	logger.Debug("Delete from backend, prefix:%s, nodePath:%s, dir:%v", prefix, nodePath, dir)
 	nodePath = util.AppendPathPrefix(nodePath, prefix)
@@ -448,11 +807,7 @@ func (c *Client) internalDelete(prefix, nodePath string, dir bool) error {
 						ops = append(ops, client.OpDelete(key))
 					}
 				}
-				if len(ops) != 0 {
-					txn := c.client.Txn(context.TODO())
-					txn.Then(ops...)
-					_, err = txn.Commit()
-				}
+				err = c.commitOps(ops)
 			}
 		} else {
 			_, err = c.client.Delete(context.Background(), nodePath, client.WithPrefix())
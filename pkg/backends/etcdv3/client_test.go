@@ -9,6 +9,7 @@
 package etcdv3
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
 	"sync"
@@ -90,3 +91,81 @@ func TestClientSyncStopWhenInitError(t *testing.T) {
 	initWG.Wait()
 	doneWG.Wait()
 }
+
+func TestClientGetPrefixPages(t *testing.T) {
+	prefix := fmt.Sprintf("/prefix%v", rand.Intn(1000))
+	nodes := []string{"http://127.0.0.1:2379"}
+	storeClient, err := NewEtcdClient("default", prefix, nodes, "", "", "", false, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pageSize := GetPageSize
+	GetPageSize = 2
+	defer func() {
+		GetPageSize = pageSize
+	}()
+	defer storeClient.internalDelete(prefix, "/", true)
+
+	values := map[string]string{}
+	for i := 0; i < 5; i++ {
+		values[fmt.Sprintf("/nodes/%d", i)] = fmt.Sprintf("n%d", i)
+	}
+	if err := storeClient.internalPutValues(prefix, "/", values, false); err != nil {
+		t.Fatal(err)
+	}
+	vars, err := storeClient.internalGets(prefix, "/nodes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vars) != 5 {
+		t.Fatalf("expect 5 values got %v", vars)
+	}
+
+	// the read at a compacted revision fail.
+	_, revision, err := storeClient.getPrefix(prefix, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storeClient.internalPutValue(prefix, "/nodes/0", "n"); err != nil {
+		t.Fatal(err)
+	}
+	current, err := storeClient.Revision()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storeClient.client.Compact(context.Background(), current); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := storeClient.getPrefix(prefix, revision); err == nil {
+		t.Fatal("expect compacted error")
+	}
+}
+
+func TestClientEndpoints(t *testing.T) {
+	nodes := []string{"http://127.0.0.1:2379"}
+	storeClient, err := NewEtcdClient("default", "/", nodes, "", "", "", false, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	storeClient.checkEndpoints()
+	endpoints := storeClient.Endpoints()
+	if len(endpoints) != 1 || !endpoints[0].Healthy {
+		t.Fatalf("expect healthy endpoint, got %v", endpoints[0])
+	}
+
+	// the unhealthy endpoint is moved after the healthy.
+	if err := storeClient.SetEndpoints([]string{"http://127.0.0.1:1", "http://127.0.0.1:2379"}); err != nil {
+		t.Fatal(err)
+	}
+	storeClient.checkEndpoints()
+	endpoints = storeClient.Endpoints()
+	if len(endpoints) != 2 || endpoints[0].Healthy || endpoints[0].Failures == 0 || !endpoints[1].Healthy {
+		t.Fatalf("unexpected endpoints %v %v", endpoints[0], endpoints[1])
+	}
+	if storeClient.client.Endpoints()[0] != "http://127.0.0.1:2379" {
+		t.Fatalf("expect healthy endpoint first, got %v", storeClient.client.Endpoints())
+	}
+	if err := storeClient.SetEndpoints(nil); err == nil {
+		t.Fatal("expect error of empty endpoints")
+	}
+}
@@ -0,0 +1,180 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package etcdv3
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"openpitrix.io/metad/pkg/logger"
+)
+
+// credentialCheckInterval is the interval of checking the credential files for rotation.
+const credentialCheckInterval = 10 * time.Second
+
+// ReadCredentialFile read the credential from the file, the surrounding whitespaces (such as the trailing newline)
+// are trimmed.
+func ReadCredentialFile(file string) (string, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// certReloader serve the client cert and the ca of the tls config from the files, and load them again when the files
+// modified, the new connections use the rotated cert and ca.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	caFile   string
+	lock     sync.RWMutex
+	cert     *tls.Certificate
+	roots    *x509.CertPool
+	modTimes map[string]time.Time
+}
+
+func newCertReloader(certFile, keyFile, caFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile, caFile: caFile, modTimes: map[string]time.Time{}}
+	if _, err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// reload load the files if any modified since the last load, return whether loaded.
+func (r *certReloader) reload() (bool, error) {
+	modTimes := map[string]time.Time{}
+	changed := false
+	for _, file := range []string{r.certFile, r.keyFile, r.caFile} {
+		if file == "" {
+			continue
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			return false, err
+		}
+		modTimes[file] = info.ModTime()
+		if !info.ModTime().Equal(r.modTimes[file]) {
+			changed = true
+		}
+	}
+	if !changed {
+		return false, nil
+	}
+	var cert *tls.Certificate
+	if r.certFile != "" && r.keyFile != "" {
+		c, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+		if err != nil {
+			return false, err
+		}
+		cert = &c
+	}
+	var roots *x509.CertPool
+	if r.caFile != "" {
+		certBytes, err := ioutil.ReadFile(r.caFile)
+		if err != nil {
+			return false, err
+		}
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(certBytes) {
+			roots = nil
+		}
+	}
+	r.lock.Lock()
+	r.cert, r.roots, r.modTimes = cert, roots, modTimes
+	r.lock.Unlock()
+	return true, nil
+}
+
+// tlsConfig return the tls config using the current cert and ca of the reloader. The server cert is verified by
+// VerifyConnection with the current ca instead of the static RootCAs, so the rotated ca takes effect.
+func (r *certReloader) tlsConfig() *tls.Config {
+	config := &tls.Config{}
+	if r.certFile != "" && r.keyFile != "" {
+		config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			r.lock.RLock()
+			defer r.lock.RUnlock()
+			return r.cert, nil
+		}
+	}
+	r.lock.RLock()
+	roots := r.roots
+	r.lock.RUnlock()
+	if roots != nil {
+		config.InsecureSkipVerify = true
+		config.VerifyConnection = func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return errors.New("etcd server has no certificate.")
+			}
+			r.lock.RLock()
+			roots := r.roots
+			r.lock.RUnlock()
+			opts := x509.VerifyOptions{DNSName: cs.ServerName, Roots: roots, Intermediates: x509.NewCertPool()}
+			for _, cert := range cs.PeerCertificates[1:] {
+				opts.Intermediates.AddCert(cert)
+			}
+			_, err := cs.PeerCertificates[0].Verify(opts)
+			return err
+		}
+	}
+	return config
+}
+
+// WatchCredentials check the credential files every credentialCheckInterval for the life of the process, the rotated
+// username and password are used when the auth token is refreshed, and the etcd client refresh it on the invalid
+// token error, such as the password changed in etcd. The rotated client cert and ca are used by the new connections.
+// The username file is optional, the configured username is kept if empty.
+func (c *Client) WatchCredentials(usernameFile, passwordFile string) {
+	go func() {
+		for {
+			time.Sleep(credentialCheckInterval)
+			c.checkCredentials(usernameFile, passwordFile)
+		}
+	}()
+}
+
+func (c *Client) checkCredentials(usernameFile, passwordFile string) {
+	if c.certs != nil {
+		if reloaded, err := c.certs.reload(); err != nil {
+			logger.Error("Reload etcd client cert error: %s", err.Error())
+		} else if reloaded {
+			logger.Info("Etcd client cert reloaded.")
+		}
+	}
+	if passwordFile == "" {
+		return
+	}
+	username := c.client.Username
+	if usernameFile != "" {
+		u, err := ReadCredentialFile(usernameFile)
+		if err != nil {
+			logger.Error("Read etcd username file error: %s", err.Error())
+			return
+		}
+		username = u
+	}
+	password, err := ReadCredentialFile(passwordFile)
+	if err != nil {
+		logger.Error("Read etcd password file error: %s", err.Error())
+		return
+	}
+	if username == "" || password == "" {
+		logger.Warn("Etcd username or password file is empty, keep the current credential.")
+		return
+	}
+	if username != c.client.Username || password != c.client.Password {
+		// the etcd client read them only when refreshing the token.
+		c.client.Username = username
+		c.client.Password = password
+		logger.Info("Etcd credential of user [%s] rotated.", username)
+	}
+}
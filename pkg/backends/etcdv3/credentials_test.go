@@ -0,0 +1,105 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package etcdv3
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeTestCert(t *testing.T, dir string, name string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	if err := ioutil.WriteFile(filepath.Join(dir, "cert.pem"), certPem, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "key.pem"), keyPem, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCertReloader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metad-cert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeTestCert(t, dir, "client-1")
+
+	r, err := newCertReloader(certFile, keyFile, certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := r.tlsConfig()
+	cert, err := config.GetClientCertificate(nil)
+	if err != nil || cert == nil {
+		t.Fatalf("expect client cert, got %v %v", cert, err)
+	}
+	if reloaded, err := r.reload(); err != nil || reloaded {
+		t.Fatalf("expect not reloaded, got %v %v", reloaded, err)
+	}
+
+	writeTestCert(t, dir, "client-2")
+	later := time.Now().Add(time.Minute)
+	for _, file := range []string{certFile, keyFile} {
+		if err := os.Chtimes(file, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if reloaded, err := r.reload(); err != nil || !reloaded {
+		t.Fatalf("expect reloaded, got %v %v", reloaded, err)
+	}
+	rotated, _ := config.GetClientCertificate(nil)
+	leaf, err := x509.ParseCertificate(rotated.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leaf.Subject.CommonName != "client-2" {
+		t.Fatalf("expect rotated cert, got %s", leaf.Subject.CommonName)
+	}
+}
+
+func TestReadCredentialFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "metad-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("secret\n")
+	f.Close()
+	password, err := ReadCredentialFile(f.Name())
+	if err != nil || password != "secret" {
+		t.Fatalf("expect secret, got %q %v", password, err)
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package etcdv3
+
+import (
+	"context"
+	"errors"
+	"path"
+	"time"
+
+	client "github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+
+	"openpitrix.io/metad/pkg/logger"
+)
+
+const LEADER_PATH = "/_metad/leader"
+
+// campaignRetryInterval is the interval to campaign again after the campaign failed, such as etcd unreachable.
+const campaignRetryInterval = time.Second
+
+var errLeaseLost = errors.New("leader lease lost")
+
+func (c *Client) leaderKey() string {
+	return path.Join(LEADER_PATH, c.group)
+}
+
+// Campaign campaign for the leader of the group in background until stopChan closed. The leader key is put with a
+// lease of ttl seconds kept alive while metad is the leader, so the standby take over after the ttl if the leader is
+// gone, the lease is revoked when stopped for the fast failover.
+func (c *Client) Campaign(candidate string, ttl int, leaderChan chan<- bool, stopChan chan bool) {
+	go func() {
+		for {
+			err := c.campaign(candidate, ttl, leaderChan, stopChan)
+			select {
+			case <-stopChan:
+				return
+			default:
+			}
+			logger.Warn("Campaign leader of group [%s] error: %s, retry.", c.group, err.Error())
+			select {
+			case <-time.After(campaignRetryInterval):
+			case <-stopChan:
+				return
+			}
+		}
+	}()
+}
+
+// campaign wait until the candidate become the leader, and hold the leadership until the lease lost or stopped.
+func (c *Client) campaign(candidate string, ttl int, leaderChan chan<- bool, stopChan chan bool) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stopChan:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	lease, err := c.client.Grant(ctx, int64(ttl))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		revokeCtx, revokeCancel := context.WithTimeout(context.Background(), time.Second)
+		defer revokeCancel()
+		c.client.Revoke(revokeCtx, lease.ID)
+	}()
+	keepAlive, err := c.client.KeepAlive(ctx, lease.ID)
+	if err != nil {
+		return err
+	}
+	key := c.leaderKey()
+	for {
+		resp, err := c.client.Txn(ctx).If(client.Compare(client.CreateRevision(key), "=", 0)).
+			Then(client.OpPut(key, candidate, client.WithLease(lease.ID))).
+			Else(client.OpGet(key)).Commit()
+		if err != nil {
+			return err
+		}
+		if resp.Succeeded {
+			break
+		}
+		if err := c.waitLeaderDeleted(ctx, key, resp.Header.Revision, keepAlive); err != nil {
+			return err
+		}
+	}
+	logger.Info("Became the leader of group [%s] as [%s].", c.group, candidate)
+	select {
+	case leaderChan <- true:
+	case <-stopChan:
+		return ctx.Err()
+	}
+	defer func() {
+		select {
+		case leaderChan <- false:
+		case <-stopChan:
+		}
+	}()
+	for {
+		select {
+		case _, ok := <-keepAlive:
+			if !ok {
+				return errLeaseLost
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// waitLeaderDeleted wait the leader key of other candidate deleted after the revision, the keepalive responses of
+// the lease are consumed while waiting.
+func (c *Client) waitLeaderDeleted(ctx context.Context, key string, revision int64, keepAlive <-chan *client.LeaseKeepAliveResponse) error {
+	watchChan := c.client.Watch(ctx, key, client.WithRev(revision+1))
+	for {
+		select {
+		case resp, ok := <-watchChan:
+			if !ok {
+				return ctx.Err()
+			}
+			if err := resp.Err(); err != nil {
+				return err
+			}
+			for _, event := range resp.Events {
+				if event.Type == mvccpb.DELETE {
+					return nil
+				}
+			}
+		case _, ok := <-keepAlive:
+			if !ok {
+				return errLeaseLost
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Leader return the candidate of the current leader of the group, empty if no leader.
+func (c *Client) Leader() (string, error) {
+	return c.internalGet("/", c.leaderKey())
+}
@@ -0,0 +1,128 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package etcdv3
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"time"
+
+	"openpitrix.io/metad/pkg/logger"
+	"openpitrix.io/metad/pkg/store"
+)
+
+// healthCheckInterval is the interval of checking the etcd endpoints.
+const healthCheckInterval = 5 * time.Second
+
+// healthCheckTimeout is the timeout of the status request of an endpoint.
+const healthCheckTimeout = 2 * time.Second
+
+// checkHealth check the endpoints every healthCheckInterval for the life of the process.
+func (c *Client) checkHealth() {
+	for {
+		c.checkEndpoints()
+		time.Sleep(healthCheckInterval)
+	}
+}
+
+// checkEndpoints request the status of every endpoint, then reorder the endpoints of the etcd client, the healthy
+// endpoints first in the configured order, so the client fail over to a healthy endpoint.
+func (c *Client) checkEndpoints() {
+	c.healthLock.Lock()
+	endpoints := c.endpoints
+	c.healthLock.Unlock()
+	results := make([]*store.EndpointStatus, len(endpoints))
+	wg := sync.WaitGroup{}
+	for i, endpoint := range endpoints {
+		wg.Add(1)
+		go func(i int, endpoint string) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+			defer cancel()
+			start := time.Now()
+			resp, err := c.client.Status(ctx, endpoint)
+			status := &store.EndpointStatus{Endpoint: endpoint, CheckedAt: time.Now(), LatencyMs: int64(time.Since(start).Seconds() * 1000)}
+			if err != nil {
+				status.Error = err.Error()
+			} else {
+				status.Healthy = true
+				status.Leader = resp.Leader == resp.Header.MemberId
+			}
+			results[i] = status
+		}(i, endpoint)
+	}
+	wg.Wait()
+
+	c.healthLock.Lock()
+	defer c.healthLock.Unlock()
+	if !reflect.DeepEqual(endpoints, c.endpoints) {
+		// the endpoints are replaced during the check.
+		return
+	}
+	var healthy, unhealthy []string
+	for _, status := range results {
+		if old := c.health[status.Endpoint]; !status.Healthy {
+			status.Failures = 1
+			if old != nil {
+				status.Failures = old.Failures + 1
+			}
+			if status.Failures == 1 {
+				logger.Warn("Etcd endpoint %s is unhealthy: %s", status.Endpoint, status.Error)
+			}
+			unhealthy = append(unhealthy, status.Endpoint)
+		} else {
+			if old != nil && !old.Healthy {
+				logger.Info("Etcd endpoint %s is healthy again.", status.Endpoint)
+			}
+			healthy = append(healthy, status.Endpoint)
+		}
+		c.health[status.Endpoint] = status
+	}
+	ordered := append(healthy, unhealthy...)
+	if !reflect.DeepEqual(ordered, c.client.Endpoints()) {
+		logger.Info("Reorder etcd endpoints to %v", ordered)
+		c.client.SetEndpoints(ordered...)
+	}
+}
+
+// Endpoints return the health of the configured endpoints, the endpoint has not been checked is unhealthy without
+// checked_at.
+func (c *Client) Endpoints() []*store.EndpointStatus {
+	c.healthLock.Lock()
+	defer c.healthLock.Unlock()
+	result := make([]*store.EndpointStatus, 0, len(c.endpoints))
+	for _, endpoint := range c.endpoints {
+		if status, ok := c.health[endpoint]; ok {
+			s := *status
+			result = append(result, &s)
+		} else {
+			result = append(result, &store.EndpointStatus{Endpoint: endpoint})
+		}
+	}
+	return result
+}
+
+// SetEndpoints replace the endpoints of the etcd client, the watches are moved to the new endpoints by the client.
+func (c *Client) SetEndpoints(endpoints []string) error {
+	if len(endpoints) == 0 {
+		return errors.New("etcd endpoints should not be empty.")
+	}
+	c.healthLock.Lock()
+	c.endpoints = append([]string{}, endpoints...)
+	health := make(map[string]*store.EndpointStatus, len(endpoints))
+	for _, endpoint := range endpoints {
+		if status, ok := c.health[endpoint]; ok {
+			health[endpoint] = status
+		}
+	}
+	c.health = health
+	c.client.SetEndpoints(endpoints...)
+	c.healthLock.Unlock()
+	logger.Info("Set etcd endpoints to %v", endpoints)
+	go c.checkEndpoints()
+	return nil
+}
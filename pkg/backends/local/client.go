@@ -9,23 +9,36 @@
 package local
 
 import (
+	"errors"
+	"path"
+	"sync"
+	"time"
+
 	"openpitrix.io/metad/pkg/logger"
+	"openpitrix.io/metad/pkg/redact"
 	"openpitrix.io/metad/pkg/store"
 )
 
 // a backend just for test.
 type Client struct {
-	data        store.Store
-	mapping     store.Store
-	rules       map[string][]store.AccessRule
-	accessStore store.AccessStore
+	data         store.Store
+	mapping      store.Store
+	rules        map[string][]store.AccessRule
+	accessStore  store.AccessStore
+	records      map[string]map[string]string
+	recordStores map[string]store.RecordStore
+	recordLock   sync.RWMutex
+	leader       string
+	leaderLock   sync.Mutex
 }
 
 func NewLocalClient() (*Client, error) {
 	return &Client{
-		data:    store.New(),
-		mapping: store.New(),
-		rules:   map[string][]store.AccessRule{},
+		data:         store.New(),
+		mapping:      store.New(),
+		rules:        map[string][]store.AccessRule{},
+		records:      map[string]map[string]string{},
+		recordStores: map[string]store.RecordStore{},
 	}, nil
 }
 
@@ -43,6 +56,10 @@ func (c *Client) Get(nodePath string, dir bool) (interface{}, error) {
 	}
 }
 
+func (c *Client) GetAtRevision(nodePath string, revision int64) (interface{}, error) {
+	return nil, errors.New("local backend does not support read at revision.")
+}
+
 func (c *Client) Put(nodePath string, value interface{}, replace bool) error {
 	if replace {
 		c.data.Delete(nodePath)
@@ -56,6 +73,18 @@ func (c *Client) Delete(nodePath string, dir bool) error {
 	return nil
 }
 
+// Move put the values to the new path then delete the old path, the memory store can not fail,
+// but it is not atomic, a reader may see both paths between the two changes.
+func (c *Client) Move(from string, to string) error {
+	_, v := c.data.Get(from)
+	if v == nil {
+		return nil
+	}
+	c.data.Put(to, v)
+	c.data.Delete(from)
+	return nil
+}
+
 func (c *Client) Sync(s store.Store, stopChan chan bool) {
 	go c.internalSync("data", c.data, s, stopChan)
 }
@@ -73,6 +102,10 @@ func (c *Client) GetMapping(nodePath string, dir bool) (interface{}, error) {
 	}
 }
 
+func (c *Client) GetMappingAtRevision(nodePath string, revision int64) (interface{}, error) {
+	return nil, errors.New("local backend does not support read at revision.")
+}
+
 func (c *Client) PutMapping(nodePath string, mapping interface{}, replace bool) error {
 	if replace {
 		c.mapping.Delete(nodePath)
@@ -131,8 +164,100 @@ func (c *Client) SyncAccessRule(accessStore store.AccessStore, stopChan chan boo
 	}()
 }
 
+func (c *Client) GetRecords(kind string) (map[string]string, error) {
+	c.recordLock.RLock()
+	defer c.recordLock.RUnlock()
+	result := make(map[string]string, len(c.records[kind]))
+	for k, v := range c.records[kind] {
+		result[k] = v
+	}
+	return result, nil
+}
+
+func (c *Client) PutRecord(kind string, key string, value string) error {
+	key = path.Join("/", key)
+	c.recordLock.Lock()
+	defer c.recordLock.Unlock()
+	m, ok := c.records[kind]
+	if !ok {
+		m = map[string]string{}
+		c.records[kind] = m
+	}
+	m[key] = value
+	if recordStore := c.recordStores[kind]; recordStore != nil {
+		recordStore.Put(key, value)
+	}
+	return nil
+}
+
+func (c *Client) DeleteRecord(kind string, key string) error {
+	key = path.Join("/", key)
+	c.recordLock.Lock()
+	defer c.recordLock.Unlock()
+	delete(c.records[kind], key)
+	if recordStore := c.recordStores[kind]; recordStore != nil {
+		recordStore.Delete(key)
+	}
+	return nil
+}
+
+func (c *Client) SyncRecords(kind string, recordStore store.RecordStore, stopChan chan bool) {
+	c.recordLock.Lock()
+	c.recordStores[kind] = recordStore
+	recordStore.Puts(c.records[kind])
+	c.recordLock.Unlock()
+	go func() {
+		select {
+		case <-stopChan:
+			c.recordLock.Lock()
+			delete(c.recordStores, kind)
+			c.recordLock.Unlock()
+		}
+	}()
+}
+
+// Campaign make the candidate the leader immediately, as the local backend is not shared.
+func (c *Client) Campaign(candidate string, ttl int, leaderChan chan<- bool, stopChan chan bool) {
+	go func() {
+		c.leaderLock.Lock()
+		c.leader = candidate
+		c.leaderLock.Unlock()
+		select {
+		case leaderChan <- true:
+		case <-stopChan:
+		}
+		<-stopChan
+		c.leaderLock.Lock()
+		c.leader = ""
+		c.leaderLock.Unlock()
+	}()
+}
+
+func (c *Client) Leader() (string, error) {
+	c.leaderLock.Lock()
+	defer c.leaderLock.Unlock()
+	return c.leader, nil
+}
+
+func (c *Client) Endpoints() []*store.EndpointStatus {
+	return nil
+}
+
+func (c *Client) SetEndpoints(endpoints []string) error {
+	return errors.New("local backend has no endpoints.")
+}
+
+func (c *Client) SyncStatus() (bool, time.Duration, error) {
+	return true, 0, nil
+}
+
+func (c *Client) SyncedRevisions() (int64, int64) {
+	return 0, 0
+}
+
 func (c *Client) internalSync(name string, from store.Store, to store.Store, stopChan chan bool) {
-	w := from.Watch("/", 5000)
+	// block the writes of local backend instead of losing the events, the sync does not write from store.
+	w := from.WatchWithPolicy("/", 5000, store.Block)
 	_, meta := from.Get("/")
 	if meta != nil {
 		to.Put("/", meta)
@@ -143,12 +268,18 @@ func (c *Client) internalSync(name string, from store.Store, to store.Store, sto
 			if !ok {
 				return
 			}
-			logger.Debug("processEvent %s %s %s", e.Action, e.Path, e.Value)
-			switch e.Action {
-			case store.Delete:
-				to.Delete(e.Path)
-			case store.Update:
-				to.Put(e.Path, e.Value)
+			for _, e := range e.Flatten() {
+				value := e.Value
+				if name == "data" {
+					value = redact.Value(e.Path, value)
+				}
+				logger.Debug("processEvent %s %s %s", e.Action, e.Path, value)
+				switch e.Action {
+				case store.Delete:
+					to.Delete(e.Path)
+				case store.Update:
+					to.Put(e.Path, e.Value)
+				}
 			}
 		case <-stopChan:
 			logger.Info("Stop sync %s", name)
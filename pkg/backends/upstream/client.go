@@ -0,0 +1,495 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package upstream
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"openpitrix.io/metad/pkg/flatmap"
+	"openpitrix.io/metad/pkg/logger"
+	"openpitrix.io/metad/pkg/redact"
+	"openpitrix.io/metad/pkg/store"
+)
+
+// the replicated kinds of the upstream replicate api, the records are kindRecordPrefix + record kind.
+const (
+	kindData         = "data"
+	kindMapping      = "mapping"
+	kindRule         = "rule"
+	kindRecordPrefix = "record/"
+)
+
+// streamTimeout is how long the stream is idle before reconnect, the upstream send a heartbeat every 10 seconds.
+const streamTimeout = 30 * time.Second
+
+// retryInterval is the interval to reconnect after the stream failed, such as upstream unreachable.
+const retryInterval = time.Second
+
+var ErrReadReplica = errors.New("metad backend is a read-only replica, write to the upstream metad.")
+
+// update is the ReplicaUpdate of the upstream replicate api.
+type update struct {
+	Snapshot bool                          `json:"snapshot"`
+	Values   map[string]string             `json:"values,omitempty"`
+	Rules    map[string][]store.AccessRule `json:"rules,omitempty"`
+	Events   []*store.Event                `json:"events,omitempty"`
+}
+
+// Client is a read replica backend syncing from the replicate api of the upstream metad, the writes are rejected.
+type Client struct {
+	nodes      []string
+	token      string
+	httpClient *http.Client
+	syncStates map[string]*syncState
+	syncLock   sync.Mutex
+	// next is the index of the upstream node to connect next.
+	next   int
+	health map[string]*store.EndpointStatus
+}
+
+// syncState is the state of the stream of a kind, disconnectedSince is the time the stream lost the upstream,
+// zero if connected.
+type syncState struct {
+	init              bool
+	disconnectedSince time.Time
+}
+
+// NewUpstreamClient return the client of the upstream metad manage api at nodes, the token is sent as the bearer token
+// if not empty.
+func NewUpstreamClient(nodes []string, cert, key, caCert string, token string) (*Client, error) {
+	if len(nodes) == 0 {
+		return nil, errors.New("metad backend requires the upstream manage urls as backend nodes.")
+	}
+	tlsConfig := &tls.Config{}
+	if caCert != "" {
+		certBytes, err := ioutil.ReadFile(caCert)
+		if err != nil {
+			return nil, err
+		}
+		caCertPool := x509.NewCertPool()
+		if caCertPool.AppendCertsFromPEM(certBytes) {
+			tlsConfig.RootCAs = caCertPool
+		}
+	}
+	if cert != "" && key != "" {
+		tlsCert, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{tlsCert}
+	}
+	return &Client{
+		nodes:      nodeURLs(nodes),
+		token:      token,
+		httpClient: &http.Client{Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: tlsConfig}},
+		syncStates: make(map[string]*syncState),
+		health:     make(map[string]*store.EndpointStatus),
+	}, nil
+}
+
+func nodeURLs(nodes []string) []string {
+	urls := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		node = strings.TrimRight(node, "/")
+		if !strings.Contains(node, "://") {
+			node = "http://" + node
+		}
+		urls = append(urls, node)
+	}
+	return urls
+}
+
+func (c *Client) Get(nodePath string, dir bool) (interface{}, error) {
+	return c.get(kindData, nodePath, dir)
+}
+
+func (c *Client) GetAtRevision(nodePath string, revision int64) (interface{}, error) {
+	return nil, errors.New("metad backend does not support read at revision.")
+}
+
+func (c *Client) Put(nodePath string, value interface{}, replace bool) error {
+	return ErrReadReplica
+}
+
+func (c *Client) Delete(nodePath string, dir bool) error {
+	return ErrReadReplica
+}
+
+func (c *Client) Move(from string, to string) error {
+	return ErrReadReplica
+}
+
+func (c *Client) Sync(s store.Store, stopChan chan bool) {
+	c.sync(kindData, stopChan, func(u *update) {
+		applyStore(s, u, true)
+	})
+}
+
+func (c *Client) GetMapping(nodePath string, dir bool) (interface{}, error) {
+	return c.get(kindMapping, nodePath, dir)
+}
+
+func (c *Client) GetMappingAtRevision(nodePath string, revision int64) (interface{}, error) {
+	return nil, errors.New("metad backend does not support read at revision.")
+}
+
+func (c *Client) PutMapping(nodePath string, mapping interface{}, replace bool) error {
+	return ErrReadReplica
+}
+
+func (c *Client) DeleteMapping(nodePath string, dir bool) error {
+	return ErrReadReplica
+}
+
+func (c *Client) SyncMapping(mapping store.Store, stopChan chan bool) {
+	c.sync(kindMapping, stopChan, func(u *update) {
+		applyStore(mapping, u, false)
+	})
+}
+
+func (c *Client) GetAccessRule() (map[string][]store.AccessRule, error) {
+	u, err := c.snapshot(kindRule)
+	if err != nil {
+		return nil, err
+	}
+	if u.Rules == nil {
+		return map[string][]store.AccessRule{}, nil
+	}
+	return u.Rules, nil
+}
+
+func (c *Client) PutAccessRule(rules map[string][]store.AccessRule) error {
+	return ErrReadReplica
+}
+
+func (c *Client) DeleteAccessRule(hosts []string) error {
+	return ErrReadReplica
+}
+
+func (c *Client) SyncAccessRule(accessStore store.AccessStore, stopChan chan bool) {
+	c.sync(kindRule, stopChan, func(u *update) {
+		for host := range accessStore.GetAccessRule(nil) {
+			if _, ok := u.Rules[host]; !ok {
+				accessStore.Delete(host)
+			}
+		}
+		accessStore.Puts(u.Rules)
+	})
+}
+
+func (c *Client) GetRecords(kind string) (map[string]string, error) {
+	u, err := c.snapshot(kindRecordPrefix + kind)
+	if err != nil {
+		return nil, err
+	}
+	if u.Values == nil {
+		return map[string]string{}, nil
+	}
+	return u.Values, nil
+}
+
+func (c *Client) PutRecord(kind string, key string, value string) error {
+	return ErrReadReplica
+}
+
+func (c *Client) DeleteRecord(kind string, key string) error {
+	return ErrReadReplica
+}
+
+func (c *Client) SyncRecords(kind string, recordStore store.RecordStore, stopChan chan bool) {
+	c.sync(kindRecordPrefix+kind, stopChan, func(u *update) {
+		for key := range recordStore.GetAll() {
+			if _, ok := u.Values[key]; !ok {
+				recordStore.Delete(key)
+			}
+		}
+		recordStore.Puts(u.Values)
+	})
+}
+
+// Campaign never make the replica the leader, the manage api writes are rejected by the backend anyway.
+func (c *Client) Campaign(candidate string, ttl int, leaderChan chan<- bool, stopChan chan bool) {
+}
+
+func (c *Client) Leader() (string, error) {
+	return "", nil
+}
+
+// Endpoints return the health of the upstream nodes by the last connection of the streams.
+func (c *Client) Endpoints() []*store.EndpointStatus {
+	c.syncLock.Lock()
+	defer c.syncLock.Unlock()
+	result := make([]*store.EndpointStatus, 0, len(c.nodes))
+	for _, node := range c.nodes {
+		if status, ok := c.health[node]; ok {
+			s := *status
+			result = append(result, &s)
+		} else {
+			result = append(result, &store.EndpointStatus{Endpoint: node})
+		}
+	}
+	return result
+}
+
+// SetEndpoints replace the upstream nodes, the connected streams are kept until they fail, then reconnect to the
+// new nodes.
+func (c *Client) SetEndpoints(endpoints []string) error {
+	if len(endpoints) == 0 {
+		return errors.New("metad backend requires the upstream manage urls as backend nodes.")
+	}
+	c.syncLock.Lock()
+	defer c.syncLock.Unlock()
+	c.nodes = nodeURLs(endpoints)
+	c.next = 0
+	health := make(map[string]*store.EndpointStatus, len(c.nodes))
+	for _, node := range c.nodes {
+		if status, ok := c.health[node]; ok {
+			health[node] = status
+		}
+	}
+	c.health = health
+	logger.Info("Set upstream metad nodes to %v", c.nodes)
+	return nil
+}
+
+// updateHealth record the result of the connection to the node.
+func (c *Client) updateHealth(node string, start time.Time, err error) {
+	c.syncLock.Lock()
+	defer c.syncLock.Unlock()
+	status := &store.EndpointStatus{Endpoint: node, Healthy: err == nil, CheckedAt: time.Now(), LatencyMs: int64(time.Since(start).Seconds() * 1000)}
+	if err != nil {
+		status.Error = err.Error()
+		status.Failures = 1
+		if old, ok := c.health[node]; ok {
+			status.Failures = old.Failures + 1
+		}
+	}
+	c.health[node] = status
+}
+
+func (c *Client) SyncStatus() (bool, time.Duration, error) {
+	c.syncLock.Lock()
+	defer c.syncLock.Unlock()
+	if len(c.syncStates) == 0 {
+		return false, 0, nil
+	}
+	var lag time.Duration
+	for _, state := range c.syncStates {
+		if !state.init {
+			return false, 0, nil
+		}
+		if !state.disconnectedSince.IsZero() {
+			if l := time.Since(state.disconnectedSince); l > lag {
+				lag = l
+			}
+		}
+	}
+	return true, lag, nil
+}
+
+func (c *Client) SyncedRevisions() (int64, int64) {
+	return 0, 0
+}
+
+// applyStore replace the store by the snapshot, or apply the events, the values of data are redacted in the logs.
+func applyStore(s store.Store, u *update, data bool) {
+	if u.Snapshot {
+		if s.Load(u.Values) {
+			return
+		}
+		if _, val := s.Get("/"); val != nil {
+			for k := range flatmap.Flatten(val) {
+				if _, ok := u.Values[k]; !ok {
+					s.Delete(k)
+				}
+			}
+		}
+		s.PutBulk("/", u.Values)
+		return
+	}
+	for _, e := range u.Events {
+		for _, e := range e.Flatten() {
+			value := e.Value
+			if data {
+				value = redact.Value(e.Path, value)
+			}
+			logger.Debug("processEvent %s %s %s", e.Action, e.Path, value)
+			switch e.Action {
+			case store.Delete:
+				s.Delete(e.Path)
+			case store.Update:
+				s.Put(e.Path, e.Value)
+			}
+		}
+	}
+}
+
+// get read nodePath from the snapshot of the kind.
+func (c *Client) get(kind string, nodePath string, dir bool) (interface{}, error) {
+	u, err := c.snapshot(kind)
+	if err != nil {
+		return nil, err
+	}
+	nodePath = path.Join("/", nodePath)
+	if dir {
+		return flatmap.Expand(u.Values, nodePath), nil
+	}
+	return u.Values[nodePath], nil
+}
+
+// snapshot read the first update of the stream of the kind, which is the snapshot.
+func (c *Client) snapshot(kind string) (*update, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var result *update
+	err := c.stream(ctx, kind, func(u *update) error {
+		result = u
+		return io.EOF
+	})
+	if result != nil {
+		return result, nil
+	}
+	return nil, err
+}
+
+// sync apply the updates of the stream of the kind until stopChan received, reconnect if the stream failed. It block
+// until the first snapshot applied, as the etcd backend block until the initial values loaded.
+func (c *Client) sync(kind string, stopChan chan bool, apply func(*update)) {
+	state := &syncState{}
+	c.syncLock.Lock()
+	c.syncStates[kind] = state
+	c.syncLock.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	initChan := make(chan struct{})
+	go func() {
+		<-stopChan
+		logger.Info("Stop sync %s", kind)
+		cancel()
+	}()
+	go func() {
+		var initOnce sync.Once
+		for {
+			err := c.stream(ctx, kind, func(u *update) error {
+				apply(u)
+				c.syncLock.Lock()
+				state.init = true
+				state.disconnectedSince = time.Time{}
+				c.syncLock.Unlock()
+				initOnce.Do(func() { close(initChan) })
+				return nil
+			})
+			if ctx.Err() != nil {
+				return
+			}
+			logger.Warn("Sync %s from upstream metad error: %v, retry.", kind, err)
+			c.syncLock.Lock()
+			if state.disconnectedSince.IsZero() {
+				state.disconnectedSince = time.Now()
+			}
+			c.syncLock.Unlock()
+			select {
+			case <-time.After(retryInterval):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	select {
+	case <-initChan:
+	case <-ctx.Done():
+	}
+}
+
+// stream read the server-sent events of the replicate api of the kind from the next upstream node, and call handle
+// with every update until ctx done, the stream failed or handle return error.
+func (c *Client) stream(ctx context.Context, kind string, handle func(*update) error) error {
+	c.syncLock.Lock()
+	node := c.nodes[c.next%len(c.nodes)]
+	c.syncLock.Unlock()
+	err := c.streamNode(ctx, node, kind, handle)
+	if err != nil && ctx.Err() == nil && err != io.EOF {
+		// try the other node next time.
+		c.syncLock.Lock()
+		c.next++
+		c.syncLock.Unlock()
+	}
+	return err
+}
+
+func (c *Client) streamNode(ctx context.Context, node string, kind string, handle func(*update) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", node+"/v1/replicate/"+kind, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	// reconnect if the stream is idle, the connection may be broken silently.
+	idle := time.AfterFunc(streamTimeout, cancel)
+	defer idle.Stop()
+	start := time.Now()
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		if ctx.Err() == nil {
+			c.updateHealth(node, start, err)
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		err := fmt.Errorf("upstream %s respond %d: %s", node, resp.StatusCode, strings.TrimSpace(string(b)))
+		c.updateHealth(node, start, err)
+		return err
+	}
+	c.updateHealth(node, start, nil)
+	reader := bufio.NewReader(resp.Body)
+	var data strings.Builder
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			if ctx.Err() != nil && err != io.EOF {
+				return fmt.Errorf("upstream %s stream idle or stopped: %s", node, err.Error())
+			}
+			return err
+		}
+		idle.Reset(streamTimeout)
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case line == "":
+			if data.Len() == 0 {
+				continue
+			}
+			u := &update{}
+			if err := json.Unmarshal([]byte(data.String()), u); err != nil {
+				return fmt.Errorf("invalid update from upstream %s: %s", node, err.Error())
+			}
+			data.Reset()
+			if err := handle(u); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "data:"):
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+		// the event names and the heartbeat comments are not needed, the update tell whether it is a snapshot.
+	}
+}
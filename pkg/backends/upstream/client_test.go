@@ -0,0 +1,347 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package upstream
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"openpitrix.io/metad/pkg/logger"
+	"openpitrix.io/metad/pkg/store"
+)
+
+func init() {
+	logger.SetLevelByString("debug")
+}
+
+const testToken = "test-token"
+
+// testUpstream serve the replicate api like the upstream metad, the snapshot of the kind is sent on connect, then
+// the updates sent to the stream of the data kind, until the connections are dropped.
+type testUpstream struct {
+	*httptest.Server
+	lock      sync.Mutex
+	snapshots map[string]*update
+	connects  map[string]int
+	updates   chan *update
+	drop      chan struct{}
+	stopped   bool
+}
+
+func newTestUpstream() *testUpstream {
+	u := &testUpstream{
+		snapshots: map[string]*update{},
+		connects:  map[string]int{},
+		updates:   make(chan *update),
+		drop:      make(chan struct{}),
+	}
+	u.Server = httptest.NewServer(http.HandlerFunc(u.serve))
+	return u
+}
+
+func (u *testUpstream) serve(w http.ResponseWriter, req *http.Request) {
+	if req.Header.Get("Authorization") != "Bearer "+testToken {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+	kind := strings.TrimPrefix(req.URL.Path, "/v1/replicate/")
+	u.lock.Lock()
+	if u.stopped {
+		u.lock.Unlock()
+		http.Error(w, "upstream stopped", http.StatusServiceUnavailable)
+		return
+	}
+	snapshot, ok := u.snapshots[kind]
+	u.connects[kind]++
+	drop := u.drop
+	u.lock.Unlock()
+	if !ok {
+		http.Error(w, "unknown kind", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.WriteHeader(http.StatusOK)
+	send := func(event string, up *update) {
+		b, _ := json.Marshal(up)
+		fmt.Fprintf(w, ": heartbeat\n\nevent: %s\ndata: %s\n\n", event, string(b))
+		w.(http.Flusher).Flush()
+	}
+	send("snapshot", snapshot)
+	for {
+		var updates chan *update
+		if kind == kindData {
+			updates = u.updates
+		}
+		select {
+		case up := <-updates:
+			send("events", up)
+		case <-drop:
+			return
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
+
+func (u *testUpstream) setSnapshot(kind string, snapshot *update) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	snapshot.Snapshot = true
+	u.snapshots[kind] = snapshot
+}
+
+// dropConnections close the connected streams, the new connections are kept.
+func (u *testUpstream) dropConnections() {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	close(u.drop)
+	u.drop = make(chan struct{})
+}
+
+// stop respond error to the new connections and drop the connected streams, so the server can be closed.
+func (u *testUpstream) stop() {
+	u.lock.Lock()
+	u.stopped = true
+	u.lock.Unlock()
+	u.dropConnections()
+}
+
+func (u *testUpstream) Close() {
+	u.stop()
+	u.Server.Close()
+}
+
+func (u *testUpstream) connectCount(kind string) int {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	return u.connects[kind]
+}
+
+func newTestClient(t *testing.T, nodes ...string) *Client {
+	client, err := NewUpstreamClient(nodes, "", "", "", testToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+// waitFor wait until cond is true, fail the test if timeout.
+func waitFor(t *testing.T, msg string, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", msg)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestClientRead(t *testing.T) {
+	upstream := newTestUpstream()
+	defer upstream.Close()
+	upstream.setSnapshot(kindData, &update{Values: map[string]string{"/nodes/1/ip": "192.168.1.1", "/nodes/2/ip": "192.168.1.2"}})
+	upstream.setSnapshot(kindMapping, &update{Values: map[string]string{"/192.168.1.1/node": "/nodes/1"}})
+	upstream.setSnapshot(kindRule, &update{Rules: map[string][]store.AccessRule{"192.168.1.1": {{Path: "/nodes", Mode: store.AccessModeRead}}}})
+	upstream.setSnapshot(kindRecordPrefix+"alias", &update{Values: map[string]string{"/current": "/nodes/1"}})
+	upstream.setSnapshot(kindRecordPrefix+"token", &update{})
+
+	client := newTestClient(t, upstream.URL)
+
+	val, err := client.Get("/nodes/1/ip", false)
+	if err != nil || val != "192.168.1.1" {
+		t.Fatalf("get leaf: %v %v", val, err)
+	}
+	val, err = client.Get("nodes", true)
+	expect := map[string]interface{}{"1": map[string]interface{}{"ip": "192.168.1.1"}, "2": map[string]interface{}{"ip": "192.168.1.2"}}
+	if err != nil || !reflect.DeepEqual(expect, val) {
+		t.Fatalf("get dir: %v %v", val, err)
+	}
+	val, err = client.GetMapping("/192.168.1.1/node", false)
+	if err != nil || val != "/nodes/1" {
+		t.Fatalf("get mapping: %v %v", val, err)
+	}
+	rules, err := client.GetAccessRule()
+	if err != nil || len(rules["192.168.1.1"]) != 1 || rules["192.168.1.1"][0].Path != "/nodes" {
+		t.Fatalf("get access rule: %v %v", rules, err)
+	}
+	records, err := client.GetRecords("alias")
+	if err != nil || records["/current"] != "/nodes/1" {
+		t.Fatalf("get records: %v %v", records, err)
+	}
+	// the empty snapshot is read as empty, not nil.
+	records, err = client.GetRecords("token")
+	if err != nil || records == nil || len(records) != 0 {
+		t.Fatalf("get empty records: %v %v", records, err)
+	}
+	if _, err := client.GetAtRevision("/nodes", 1); err == nil {
+		t.Fatal("read at revision should not be supported")
+	}
+
+	// the upstream error is returned.
+	if _, err := client.GetRecords("unknown"); err == nil || !strings.Contains(err.Error(), "400") {
+		t.Fatalf("unknown kind should fail: %v", err)
+	}
+	unauthorized, err := NewUpstreamClient([]string{upstream.URL}, "", "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := unauthorized.Get("/nodes", true); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Fatalf("request without token should fail: %v", err)
+	}
+
+	// the writes are rejected.
+	for _, err := range []error{
+		client.Put("/nodes/3/ip", "192.168.1.3", false),
+		client.Delete("/nodes/1", true),
+		client.Move("/nodes/1", "/nodes/3"),
+		client.PutMapping("/192.168.1.3", map[string]interface{}{"node": "/nodes/3"}, false),
+		client.DeleteMapping("/192.168.1.1", true),
+		client.PutAccessRule(map[string][]store.AccessRule{}),
+		client.DeleteAccessRule([]string{"192.168.1.1"}),
+		client.PutRecord("alias", "/current", "/nodes/2"),
+		client.DeleteRecord("alias", "/current"),
+	} {
+		if err != ErrReadReplica {
+			t.Fatalf("write should be rejected: %v", err)
+		}
+	}
+}
+
+func TestClientSync(t *testing.T) {
+	upstream := newTestUpstream()
+	defer upstream.Close()
+	upstream.setSnapshot(kindData, &update{Values: map[string]string{"/nodes/1/ip": "192.168.1.1", "/nodes/2/ip": "192.168.1.2"}})
+	upstream.setSnapshot(kindRule, &update{Rules: map[string][]store.AccessRule{"192.168.1.1": {{Path: "/nodes", Mode: store.AccessModeRead}}}})
+	upstream.setSnapshot(kindRecordPrefix+"alias", &update{Values: map[string]string{"/current": "/nodes/1"}})
+
+	client := newTestClient(t, upstream.URL)
+	if synced, _, _ := client.SyncStatus(); synced {
+		t.Fatal("should not be synced before sync")
+	}
+	stopChan := make(chan bool)
+	defer close(stopChan)
+
+	// Sync block until the snapshot applied.
+	data := store.New()
+	client.Sync(data, stopChan)
+	if _, val := data.Get("/nodes/1/ip"); val != "192.168.1.1" {
+		t.Fatalf("snapshot not applied: %v", val)
+	}
+	accessStore := store.NewAccessStore()
+	client.SyncAccessRule(accessStore, stopChan)
+	if rules := accessStore.GetAccessRule([]string{"192.168.1.1"}); len(rules["192.168.1.1"]) != 1 {
+		t.Fatalf("access rule not synced: %v", rules)
+	}
+	recordStore := store.NewRecordStore()
+	recordStore.Put("/stale", "/nodes/2")
+	client.SyncRecords("alias", recordStore, stopChan)
+	if records := recordStore.GetAll(); !reflect.DeepEqual(map[string]string{"/current": "/nodes/1"}, records) {
+		t.Fatalf("records not synced: %v", records)
+	}
+	if synced, lag, _ := client.SyncStatus(); !synced || lag != 0 {
+		t.Fatalf("should be synced without lag: %v %v", synced, lag)
+	}
+
+	// the events are applied in order.
+	upstream.updates <- &update{Events: []*store.Event{
+		{Action: store.Update, Path: "/nodes/3/ip", Value: "192.168.1.3"},
+		{Action: store.Delete, Path: "/nodes/2/ip"},
+	}}
+	waitFor(t, "the events applied", func() bool {
+		_, val := data.Get("/nodes/3/ip")
+		return val == "192.168.1.3"
+	})
+	if _, val := data.Get("/nodes/2"); val != nil {
+		t.Fatalf("deleted path should be removed: %v", val)
+	}
+
+	// reconnect after the stream dropped, the new snapshot replace the store.
+	connects := upstream.connectCount(kindData)
+	upstream.setSnapshot(kindData, &update{Values: map[string]string{"/nodes/1/ip": "192.168.1.11", "/nodes/4/ip": "192.168.1.4"}})
+	upstream.dropConnections()
+	waitFor(t, "the snapshot resynced", func() bool {
+		_, val := data.Get("/nodes/1/ip")
+		return val == "192.168.1.11"
+	})
+	if upstream.connectCount(kindData) <= connects {
+		t.Fatal("should reconnect to the upstream")
+	}
+	if _, val := data.Get("/nodes/3"); val != nil {
+		t.Fatalf("path not in the snapshot should be removed: %v", val)
+	}
+	if _, val := data.Get("/nodes/4/ip"); val != "192.168.1.4" {
+		t.Fatalf("path in the snapshot should be added: %v", val)
+	}
+	waitFor(t, "synced without lag", func() bool {
+		synced, lag, _ := client.SyncStatus()
+		return synced && lag == 0
+	})
+
+	// the lag is reported while the upstream is down.
+	upstream.stop()
+	waitFor(t, "lag reported", func() bool {
+		synced, lag, _ := client.SyncStatus()
+		return synced && lag > 0
+	})
+	waitFor(t, "upstream unhealthy", func() bool {
+		endpoints := client.Endpoints()
+		return len(endpoints) == 1 && !endpoints[0].Healthy && endpoints[0].Failures > 0 && strings.Contains(endpoints[0].Error, "503")
+	})
+}
+
+func TestClientFailover(t *testing.T) {
+	upstream := newTestUpstream()
+	defer upstream.Close()
+	upstream.setSnapshot(kindData, &update{Values: map[string]string{"/nodes/1/ip": "192.168.1.1"}})
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	client := newTestClient(t, down.URL, upstream.URL+"/")
+	stopChan := make(chan bool)
+	defer close(stopChan)
+
+	// the down node is skipped on retry.
+	data := store.New()
+	client.Sync(data, stopChan)
+	if _, val := data.Get("/nodes/1/ip"); val != "192.168.1.1" {
+		t.Fatalf("snapshot not applied: %v", val)
+	}
+	endpoints := client.Endpoints()
+	if len(endpoints) != 2 {
+		t.Fatalf("endpoints: %v", endpoints)
+	}
+	if endpoints[0].Endpoint != down.URL || endpoints[0].Healthy || endpoints[0].Failures != 1 {
+		t.Fatalf("down node should be unhealthy: %+v", endpoints[0])
+	}
+	if endpoints[1].Endpoint != upstream.URL || !endpoints[1].Healthy {
+		t.Fatalf("upstream should be healthy: %+v", endpoints[1])
+	}
+
+	// the streams reconnect to the new nodes after they fail.
+	if err := client.SetEndpoints(nil); err == nil {
+		t.Fatal("empty endpoints should be rejected")
+	}
+	other := newTestUpstream()
+	defer other.Close()
+	other.setSnapshot(kindData, &update{Values: map[string]string{"/nodes/1/ip": "192.168.1.11"}})
+	if err := client.SetEndpoints([]string{strings.TrimPrefix(other.URL, "http://")}); err != nil {
+		t.Fatal(err)
+	}
+	upstream.dropConnections()
+	waitFor(t, "synced from the new node", func() bool {
+		_, val := data.Get("/nodes/1/ip")
+		return val == "192.168.1.11"
+	})
+	if endpoints := client.Endpoints(); len(endpoints) != 1 || endpoints[0].Endpoint != other.URL || !endpoints[0].Healthy {
+		t.Fatalf("endpoints should be the new node: %+v", endpoints)
+	}
+}
@@ -0,0 +1,252 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+// Package bench is the load-test harness of metad, it runs the concurrent clients against the metad under test, in
+// process for the go benchmarks or by http for the benchmark tool, and reports the latencies in the format of the go
+// benchmark results, so the numbers can be compared by benchstat.
+package bench
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// the defaults of the long-poll options.
+const (
+	defaultSettle  = 200 * time.Millisecond
+	defaultTimeout = 30 * time.Second
+)
+
+// Target is the metad under test, the requests are served in process by Handler if set, such as the router of metad
+// in the go benchmarks, otherwise sent to URL by Client.
+type Target struct {
+	URL     string
+	Handler http.Handler
+	// Client default http.DefaultClient, its Transport should keep enough idle connections for the concurrent clients.
+	Client *http.Client
+	Header http.Header
+}
+
+// Get request the uri, return the status and the X-Metad-Version of the response, the body is dropped.
+func (t *Target) Get(ctx context.Context, uri string) (int, int64, error) {
+	return t.Do(ctx, "GET", uri, nil)
+}
+
+// Do send the request, return the status and the X-Metad-Version of the response, the body is dropped.
+func (t *Target) Do(ctx context.Context, method string, uri string, body io.Reader) (int, int64, error) {
+	if t.Handler != nil {
+		req := httptest.NewRequest(method, uri, body).WithContext(ctx)
+		t.setHeader(req)
+		w := httptest.NewRecorder()
+		t.Handler.ServeHTTP(w, req)
+		return w.Code, responseVersion(w.Header()), nil
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(t.URL, "/")+uri, body)
+	if err != nil {
+		return 0, 0, err
+	}
+	req = req.WithContext(ctx)
+	t.setHeader(req)
+	client := t.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	return resp.StatusCode, responseVersion(resp.Header), nil
+}
+
+func (t *Target) setHeader(req *http.Request) {
+	req.Header.Set("Accept", "application/json")
+	for k, v := range t.Header {
+		req.Header[k] = v
+	}
+}
+
+func responseVersion(header http.Header) int64 {
+	version, _ := strconv.ParseInt(header.Get("X-Metad-Version"), 10, 64)
+	return version
+}
+
+// Report is the result of a benchmark run.
+type Report struct {
+	Name    string
+	Ops     int
+	Errors  int
+	Elapsed time.Duration
+	// Latencies is the sorted latencies of the succeeded ops.
+	Latencies []time.Duration
+}
+
+func newReport(name string, elapsed time.Duration, latencies []time.Duration, errors int) *Report {
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	return &Report{Name: name, Ops: len(latencies) + errors, Errors: errors, Elapsed: elapsed, Latencies: latencies}
+}
+
+// Percentile return the latency of the percentile, such as 0.99 for p99, 0 if no op succeeded.
+func (r *Report) Percentile(p float64) time.Duration {
+	if len(r.Latencies) == 0 {
+		return 0
+	}
+	i := int(float64(len(r.Latencies))*p+0.5) - 1
+	if i < 0 {
+		i = 0
+	} else if i >= len(r.Latencies) {
+		i = len(r.Latencies) - 1
+	}
+	return r.Latencies[i]
+}
+
+// OpsPerSecond return the throughput of the run.
+func (r *Report) OpsPerSecond() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Ops) / r.Elapsed.Seconds()
+}
+
+// String format the report as a go benchmark result line, the latencies are the extra units of benchstat.
+func (r *Report) String() string {
+	var nsPerOp int64
+	if r.Ops > 0 {
+		nsPerOp = r.Elapsed.Nanoseconds() / int64(r.Ops)
+	}
+	return fmt.Sprintf("Benchmark%s\t%d\t%d ns/op\t%d p50-ns\t%d p99-ns\t%.0f ops/s\t%d errors",
+		r.Name, r.Ops, nsPerOp, r.Percentile(0.5).Nanoseconds(), r.Percentile(0.99).Nanoseconds(), r.OpsPerSecond(), r.Errors)
+}
+
+// Run run total ops by the concurrent clients, op is called with the client index and the op index.
+func Run(name string, clients int, total int, op func(client int, i int) error) *Report {
+	if clients <= 0 {
+		clients = 1
+	}
+	var next int64 = -1
+	var wg sync.WaitGroup
+	latencies := make([][]time.Duration, clients)
+	errors := make([]int, clients)
+	start := time.Now()
+	for c := 0; c < clients; c++ {
+		wg.Add(1)
+		go func(c int) {
+			defer wg.Done()
+			for {
+				i := int(atomic.AddInt64(&next, 1))
+				if i >= total {
+					return
+				}
+				st := time.Now()
+				if err := op(c, i); err != nil {
+					errors[c]++
+				} else {
+					latencies[c] = append(latencies[c], time.Since(st))
+				}
+			}
+		}(c)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	var all []time.Duration
+	errorCount := 0
+	for c := 0; c < clients; c++ {
+		all = append(all, latencies[c]...)
+		errorCount += errors[c]
+	}
+	return newReport(name, elapsed, all, errorCount)
+}
+
+// LongPollOptions is the options of the long-poll benchmark.
+type LongPollOptions struct {
+	Target *Target
+	// Path is the uri watched by the clients, such as /self or /nodes.
+	Path    string
+	Clients int
+	Rounds  int
+	// Settle is the wait after all clients sent their long-polls before the change, so their watches are registered,
+	// default 200ms.
+	Settle time.Duration
+	// Timeout is the timeout of the long-polls of a round, default 30s.
+	Timeout time.Duration
+	// Change change the watched data in the round, every long-poll should return after it.
+	Change func(round int) error
+}
+
+// LongPoll run the long-poll benchmark, in every round all the clients long-poll the path with the current version,
+// then the data is changed, the latency is from the change to the long-poll returned. The long-polls returned before
+// the change, failed or timeout are errors.
+func LongPoll(name string, opts *LongPollOptions) (*Report, error) {
+	settle, timeout := opts.Settle, opts.Timeout
+	if settle <= 0 {
+		settle = defaultSettle
+	}
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	sep := "?"
+	if strings.Contains(opts.Path, "?") {
+		sep = "&"
+	}
+	var elapsed time.Duration
+	var latencies []time.Duration
+	errors := 0
+	for round := 0; round < opts.Rounds; round++ {
+		status, version, err := opts.Target.Get(context.Background(), opts.Path)
+		if err != nil {
+			return nil, err
+		}
+		if status != http.StatusOK {
+			return nil, fmt.Errorf("get %s status %d", opts.Path, status)
+		}
+		uri := fmt.Sprintf("%s%swait=true&prev_version=%d", opts.Path, sep, version)
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		// changed is the unix nano of the change, 0 before the change.
+		var changed int64
+		var lock sync.Mutex
+		var ready, done sync.WaitGroup
+		ready.Add(opts.Clients)
+		done.Add(opts.Clients)
+		for c := 0; c < opts.Clients; c++ {
+			go func() {
+				defer done.Done()
+				ready.Done()
+				status, _, err := opts.Target.Get(ctx, uri)
+				at := atomic.LoadInt64(&changed)
+				latency := time.Since(time.Unix(0, at))
+				lock.Lock()
+				defer lock.Unlock()
+				if err != nil || status != http.StatusOK || at == 0 || ctx.Err() != nil {
+					errors++
+				} else {
+					latencies = append(latencies, latency)
+				}
+			}()
+		}
+		ready.Wait()
+		time.Sleep(settle)
+		start := time.Now()
+		atomic.StoreInt64(&changed, start.UnixNano())
+		if err := opts.Change(round); err != nil {
+			cancel()
+			done.Wait()
+			return nil, err
+		}
+		done.Wait()
+		elapsed += time.Since(start)
+		cancel()
+	}
+	return newReport(name, elapsed, latencies, errors), nil
+}
@@ -0,0 +1,100 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package bench
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "openpitrix.io/metad/pkg/assert"
+)
+
+// versionHandler is a fake metad, the long-polls with the current version wait for the next change.
+type versionHandler struct {
+	lock    sync.Mutex
+	cond    *sync.Cond
+	version int64
+}
+
+func newVersionHandler() *versionHandler {
+	h := &versionHandler{version: 1}
+	h.cond = sync.NewCond(&h.lock)
+	return h
+}
+
+func (h *versionHandler) change() {
+	h.lock.Lock()
+	h.version++
+	h.lock.Unlock()
+	h.cond.Broadcast()
+}
+
+func (h *versionHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	h.lock.Lock()
+	if req.FormValue("wait") == "true" {
+		prev := req.FormValue("prev_version")
+		for prev == fmt.Sprintf("%d", h.version) {
+			h.cond.Wait()
+		}
+	}
+	version := h.version
+	h.lock.Unlock()
+	w.Header().Set("X-Metad-Version", fmt.Sprintf("%d", version))
+	w.Write([]byte(`{}`))
+}
+
+func TestRun(t *testing.T) {
+	r := Run("Op", 4, 100, func(client int, i int) error {
+		if i%10 == 0 {
+			return errors.New("failed")
+		}
+		return nil
+	})
+	Assert(t, r.Ops == 100 && r.Errors == 10 && len(r.Latencies) == 90)
+	Assert(t, r.Percentile(0.5) <= r.Percentile(0.99))
+	Assert(t, strings.HasPrefix(r.String(), "BenchmarkOp\t100\t"), r.String())
+	Assert(t, strings.Contains(r.String(), "\t10 errors"), r.String())
+}
+
+func TestLongPoll(t *testing.T) {
+	h := newVersionHandler()
+	server := httptest.NewServer(h)
+	defer server.Close()
+	for _, target := range []*Target{{Handler: h}, {URL: server.URL}} {
+		r, err := LongPoll("LongPoll", &LongPollOptions{
+			Target:  target,
+			Path:    "/nodes",
+			Clients: 50,
+			Rounds:  3,
+			Settle:  50 * time.Millisecond,
+			Change: func(round int) error {
+				h.change()
+				return nil
+			},
+		})
+		Assert(t, err == nil, err)
+		Assert(t, r.Ops == 150 && r.Errors == 0, r.String())
+	}
+
+	_, err := LongPoll("LongPoll", &LongPollOptions{
+		Target:  &Target{Handler: h},
+		Path:    "/nodes",
+		Clients: 1,
+		Rounds:  1,
+		Settle:  time.Millisecond,
+		Timeout: 100 * time.Millisecond,
+		Change: func(round int) error {
+			h.change()
+			return errors.New("change failed")
+		},
+	})
+	Assert(t, err != nil && err.Error() == "change failed")
+}
@@ -0,0 +1,42 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metad
+
+import (
+	"net/http"
+)
+
+// Auth set the credential of the requests to metad, such as the bearer token.
+type Auth interface {
+	Apply(req *http.Request) error
+}
+
+// AuthFunc adapt a func to Auth.
+type AuthFunc func(req *http.Request) error
+
+func (f AuthFunc) Apply(req *http.Request) error {
+	return f(req)
+}
+
+// BearerToken authenticate by the static token of /v1/token.
+func BearerToken(token string) Auth {
+	return AuthFunc(func(req *http.Request) error {
+		req.Header.Set("Authorization", "Bearer "+token)
+		return nil
+	})
+}
+
+// BearerTokenSource authenticate by the token returned by the source on every request, such as a token rotated
+// by the orchestrator, the request fail if the source return error.
+func BearerTokenSource(source func() (string, error)) Auth {
+	return AuthFunc(func(req *http.Request) error {
+		token, err := source()
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		return nil
+	})
+}
@@ -9,11 +9,11 @@
 package metad
 
 import (
-	"container/ring"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"math/rand"
 	"net"
@@ -21,25 +21,51 @@ import (
 	"reflect"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"openpitrix.io/metad/pkg/logger"
 )
 
+const (
+	DefaultHealthCheckInterval = 10 * time.Second
+	DefaultMaxRetryInterval    = 8 * time.Second
+	minRetryInterval           = 500 * time.Millisecond
+	maxSelectTime              = 15 * time.Second
+	healthCheckTimeout         = 3 * time.Second
+)
+
 type Connection struct {
 	url        string
 	httpClient *http.Client
+	auth       Auth
 	waitIndex  uint64
 	errTimes   uint32
+	// downUntil is the unix nano time before which the connection is treated as unhealthy.
+	downUntil int64
 }
 
-func (c *Connection) makeMetaDataRequest(path string) ([]byte, error) {
-	req, err := http.NewRequest("GET", strings.Join([]string{c.url, path}, ""), nil)
+// newRequest create the json request of the uri relative to the server, with the credential of the auth.
+func (c *Connection) newRequest(ctx context.Context, method string, uri string) (*http.Request, error) {
+	req, err := http.NewRequest(method, c.url+uri, nil)
 	if err != nil {
 		return nil, err
 	}
 	req.Header.Set("Accept", "application/json")
+	if c.auth != nil {
+		if err := c.auth.Apply(req); err != nil {
+			return nil, err
+		}
+	}
+	return req.WithContext(ctx), nil
+}
+
+func (c *Connection) makeMetaDataRequest(path string) ([]byte, error) {
+	req, err := c.newRequest(context.Background(), "GET", path)
+	if err != nil {
+		return nil, err
+	}
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
@@ -50,17 +76,85 @@ func (c *Connection) makeMetaDataRequest(path string) ([]byte, error) {
 	return ioutil.ReadAll(resp.Body)
 }
 
+// check whether the server is healthy by a HEAD request, which respond no body,
+// the server respond 5xx (such as shutting down) is unhealthy.
+func (c *Connection) check() error {
+	ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+	defer cancel()
+	req, err := c.newRequest(ctx, "HEAD", "/")
+	if err != nil {
+		return err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("metad response status [%v]", resp.StatusCode)
+	}
+	return nil
+}
+
+func (c *Connection) isDown(now time.Time) bool {
+	return atomic.LoadInt64(&c.downUntil) > now.UnixNano()
+}
+
+func (c *Connection) markDown(interval time.Duration) {
+	atomic.StoreInt64(&c.downUntil, time.Now().Add(interval).UnixNano())
+}
+
+func (c *Connection) markUp() {
+	atomic.StoreInt64(&c.downUntil, 0)
+	atomic.StoreUint32(&c.errTimes, 0)
+}
+
+var errNoConnection = errors.New("fail to connect any backend.")
+
+// ClientOptions is the failover options of the client.
+type ClientOptions struct {
+	// PreferOrder prefer the servers in the given order, otherwise the order is shuffled to spread the load of clients.
+	PreferOrder bool
+	// HealthCheckInterval is how long a failed server is skipped before checked again.
+	HealthCheckInterval time.Duration
+	// MaxRetryInterval is the max interval between the retries when all servers failed, the interval is jittered.
+	MaxRetryInterval time.Duration
+	// Auth set the credential of the requests, such as BearerToken, anonymous if nil.
+	Auth Auth
+}
+
+// Client use one server until it fails (sticky), then fail over to the first healthy server in the preference order.
 type Client struct {
-	connections *ring.Ring
+	connections []*Connection
 	current     *Connection
+	options     ClientOptions
+	lock        sync.Mutex
 }
 
 func NewMetadClient(backendNodes []string) (*Client, error) {
-	connections := ring.New(len(backendNodes))
+	return NewMetadClientWithOptions(backendNodes, ClientOptions{})
+}
+
+func NewMetadClientWithOptions(backendNodes []string, options ClientOptions) (*Client, error) {
+	if len(backendNodes) == 0 {
+		return nil, errors.New("backend nodes must not be empty.")
+	}
+	if options.HealthCheckInterval <= 0 {
+		options.HealthCheckInterval = DefaultHealthCheckInterval
+	}
+	if options.MaxRetryInterval <= 0 {
+		options.MaxRetryInterval = DefaultMaxRetryInterval
+	}
+	connections := make([]*Connection, 0, len(backendNodes))
 	for _, backendNode := range backendNodes {
-		url := "http://" + backendNode
+		url := backendNode
+		if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+			url = "http://" + backendNode
+		}
 		connection := &Connection{
-			url: url,
+			url:  url,
+			auth: options.Auth,
 			httpClient: &http.Client{
 				Transport: &http.Transport{
 					Proxy: http.ProxyFromEnvironment,
@@ -71,12 +165,18 @@ func NewMetadClient(backendNodes []string) (*Client, error) {
 				},
 			},
 		}
-		connections.Value = connection
-		connections = connections.Next()
+		connections = append(connections, connection)
+	}
+	if !options.PreferOrder {
+		random := rand.New(rand.NewSource(time.Now().UnixNano()))
+		random.Shuffle(len(connections), func(i, j int) {
+			connections[i], connections[j] = connections[j], connections[i]
+		})
 	}
 
 	client := &Client{
 		connections: connections,
+		options:     options,
 	}
 
 	err := client.selectConnection()
@@ -85,57 +185,90 @@ func NewMetadClient(backendNodes []string) (*Client, error) {
 
 }
 
+// getCurrent return the current connection, reselect if it failed too many times.
+func (c *Client) getCurrent() *Connection {
+	c.lock.Lock()
+	current := c.current
+	c.lock.Unlock()
+	if current == nil || atomic.LoadUint32(&current.errTimes) >= 3 {
+		if err := c.selectConnection(); err != nil {
+			logger.Error("Select metad connection error: %s", err.Error())
+		}
+		c.lock.Lock()
+		current = c.current
+		c.lock.Unlock()
+	}
+	return current
+}
+
+// selectConnection select the first healthy server in preference order, retry with jittered backoff if all failed.
+// The lock is only held to update the current connection, the checks and the backoff sleeps do not block getCurrent.
 func (c *Client) selectConnection() error {
-	maxTime := 15 * time.Second
-	i := 1 * time.Second
-	for ; i < maxTime; i *= time.Duration(2) {
-		if conn, err := c.testConnection(); err == nil {
-			//found available conn
-			if c.current != nil {
-				atomic.StoreUint32(&c.current.errTimes, 0)
+	c.lock.Lock()
+	if c.current != nil && atomic.LoadUint32(&c.current.errTimes) >= 3 {
+		c.current.markDown(c.options.HealthCheckInterval)
+	}
+	c.lock.Unlock()
+	deadline := time.Now().Add(maxSelectTime)
+	backoff := minRetryInterval
+	for {
+		if conn := c.testConnection(); conn != nil {
+			c.lock.Lock()
+			if conn != c.current {
+				logger.Info("Using Metad URL: " + conn.url)
 			}
 			c.current = conn
+			c.lock.Unlock()
+			return nil
+		}
+		if time.Now().Add(backoff).After(deadline) {
 			break
 		}
-		time.Sleep(i)
-	}
-	if i >= maxTime {
-		return fmt.Errorf("fail to connect any backend.")
+		// full jitter, avoid the clients retry at same time after a server restart.
+		time.Sleep(backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1)))
+		backoff *= 2
+		if backoff > c.options.MaxRetryInterval {
+			backoff = c.options.MaxRetryInterval
+		}
 	}
-	logger.Info("Using Metad URL: " + c.current.url)
-	return nil
+	return errNoConnection
 }
 
-func (c *Client) testConnection() (*Connection, error) {
-	//random start
-	if c.current == nil {
-		rand.Seed(time.Now().Unix())
-		r := rand.Intn(c.connections.Len())
-		c.connections = c.connections.Move(r)
-	}
-	c.connections = c.connections.Next()
-	conn := c.connections.Value.(*Connection)
-	startConn := conn
-	_, err := conn.makeMetaDataRequest("/")
-	for err != nil {
-		logger.Error("connection to [%s], error: [%v]", conn.url, err)
-		c.connections = c.connections.Next()
-		conn = c.connections.Value.(*Connection)
-		if conn == startConn {
-			break
+// testConnection check the servers not marked down in preference order, then the down ones, return nil if all failed.
+func (c *Client) testConnection() *Connection {
+	now := time.Now()
+	candidates := make([]*Connection, 0, len(c.connections))
+	var downs []*Connection
+	for _, conn := range c.connections {
+		if conn.isDown(now) {
+			downs = append(downs, conn)
+		} else {
+			candidates = append(candidates, conn)
 		}
-		_, err = conn.makeMetaDataRequest("/")
 	}
-	return conn, err
+	for _, conn := range append(candidates, downs...) {
+		if err := conn.check(); err != nil {
+			logger.Error("connection to [%s], error: [%v]", conn.url, err)
+			conn.markDown(c.options.HealthCheckInterval)
+			continue
+		}
+		conn.markUp()
+		return conn
+	}
+	return nil
 }
 
 func (c *Client) GetValues(keys []string) (map[string]string, error) {
 	vars := map[string]string{}
 
 	for _, key := range keys {
-		body, err := c.current.makeMetaDataRequest(key)
+		conn := c.getCurrent()
+		if conn == nil {
+			return vars, errNoConnection
+		}
+		body, err := conn.makeMetaDataRequest(key)
 		if err != nil {
-			atomic.AddUint32(&c.current.errTimes, 1)
+			atomic.AddUint32(&conn.errTimes, 1)
 			return vars, err
 		}
 
@@ -184,12 +317,11 @@ func treeWalk(root string, val interface{}, vars map[string]string) error {
 
 func (c *Client) WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
 
-	if c.current.errTimes >= 3 {
-		c.selectConnection()
+	conn := c.getCurrent()
+	if conn == nil {
+		return 0, errNoConnection
 	}
 
-	conn := c.current
-
 	// return something > 0 to trigger a key retrieval from the store
 	if waitIndex == 0 {
 		conn.waitIndex = 1
@@ -203,13 +335,10 @@ func (c *Client) WatchPrefix(prefix string, keys []string, waitIndex uint64, sto
 	done := make(chan struct{})
 	defer close(done)
 	ctx, cancel := context.WithCancel(context.Background())
-	req, err := http.NewRequest("GET", fmt.Sprintf("%s%s?wait=true&prev_version=%d", conn.url, prefix, waitIndex), nil)
+	req, err := conn.newRequest(ctx, "GET", fmt.Sprintf("%s?wait=true&prev_version=%d", prefix, waitIndex))
 	if err != nil {
 		return conn.waitIndex, err
 	}
-
-	req.Header.Set("Accept", "application/json")
-	req = req.WithContext(ctx)
 	go func() {
 		select {
 		case <-stopChan:
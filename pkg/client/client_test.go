@@ -0,0 +1,178 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metad
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "openpitrix.io/metad/pkg/assert"
+)
+
+func TestClientFailover(t *testing.T) {
+	server1 := newTestServer(`{"k":"1"}`)
+	defer server1.Close()
+	server2 := newTestServer(`{"k":"2"}`)
+	defer server2.Close()
+
+	options := ClientOptions{PreferOrder: true, HealthCheckInterval: 200 * time.Millisecond}
+	client, err := NewMetadClientWithOptions([]string{server1.URL, server2.URL}, options)
+	Assert(t, nil == err)
+
+	getValue := func() (string, error) {
+		vars, err := client.GetValues([]string{"/"})
+		return vars["//k"], err
+	}
+
+	// sticky, the first server is used until it fails, even the other one is down.
+	server2.setDown(true)
+	for i := 0; i < 5; i++ {
+		v, err := getValue()
+		Assert(t, nil == err)
+		Assert(t, "1" == v, v)
+	}
+	Assert(t, 0 == atomic.LoadInt32(&server2.requests))
+	server2.setDown(false)
+
+	// demotion, switch to the next server after 3 failures.
+	server1.setDown(true)
+	for i := 0; i < 3; i++ {
+		_, err := getValue()
+		Assert(t, nil != err)
+	}
+	v, err := getValue()
+	Assert(t, nil == err)
+	Assert(t, "2" == v, v)
+	Assert(t, server2.URL == client.current.url)
+	Assert(t, client.connections[0].isDown(time.Now()))
+
+	// recovery, the recovered server is not used until the current one fails.
+	server1.setDown(false)
+	v, err = getValue()
+	Assert(t, nil == err)
+	Assert(t, "2" == v, v)
+
+	server2.setDown(true)
+	for i := 0; i < 3; i++ {
+		_, err := getValue()
+		Assert(t, nil != err)
+	}
+	v, err = getValue()
+	Assert(t, nil == err)
+	Assert(t, "1" == v, v)
+	Assert(t, !client.connections[0].isDown(time.Now()))
+}
+
+func TestClientSelectHealthy(t *testing.T) {
+	server1 := newTestServer(`{"k":"1"}`)
+	defer server1.Close()
+	server2 := newTestServer(`{"k":"2"}`)
+	defer server2.Close()
+
+	// the unhealthy server is skipped on start.
+	server1.setDown(true)
+	client, err := NewMetadClientWithOptions([]string{server1.URL, server2.URL}, ClientOptions{PreferOrder: true})
+	Assert(t, nil == err)
+	Assert(t, server2.URL == client.current.url)
+	Assert(t, client.connections[0].isDown(time.Now()))
+
+	vars, err := client.GetValues([]string{"/"})
+	Assert(t, nil == err)
+	Assert(t, "2" == vars["//k"], vars)
+
+	// the lock is not held while retry with backoff when all servers are down.
+	server2.setDown(true)
+	done := make(chan error)
+	go func() {
+		done <- client.selectConnection()
+	}()
+	time.Sleep(100 * time.Millisecond)
+	locked := make(chan struct{})
+	go func() {
+		client.lock.Lock()
+		client.lock.Unlock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Fatal("client lock is held while selecting connection")
+	}
+	server1.setDown(false)
+	Assert(t, nil == <-done)
+	Assert(t, server1.URL == client.getCurrent().url)
+}
+
+func TestClientWatch(t *testing.T) {
+	var lock sync.Mutex
+	value, version := `{"ip":"192.168.1.1"}`, 1
+	changed := make(chan struct{})
+	var auth atomic.Value
+	var waits int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		auth.Store(req.Header.Get("Authorization"))
+		lock.Lock()
+		v, ch := version, changed
+		lock.Unlock()
+		if req.FormValue("wait") == "true" {
+			if atomic.AddInt32(&waits, 1) == 1 {
+				// the first wait fail, the watch retry with backoff.
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			if req.FormValue("prev_version") == strconv.Itoa(v) {
+				select {
+				case <-ch:
+				case <-req.Context().Done():
+					return
+				}
+			}
+			w.Write([]byte(`{}`))
+			return
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		w.Header().Set("X-Metad-Version", strconv.Itoa(version))
+		w.Write([]byte(value))
+	}))
+	defer server.Close()
+
+	client, err := NewMetadClientWithOptions([]string{server.URL}, ClientOptions{Auth: BearerToken("secret")})
+	Assert(t, nil == err)
+	ctx, cancel := context.WithCancel(context.Background())
+	values := make(chan interface{}, 10)
+	done := make(chan error)
+	go func() {
+		done <- client.Watch(ctx, "/self/node", func(value interface{}, version uint64) {
+			values <- value
+		})
+	}()
+	Assert(t, reflect.DeepEqual(map[string]interface{}{"ip": "192.168.1.1"}, <-values))
+	Assert(t, "Bearer secret" == auth.Load())
+
+	time.Sleep(100 * time.Millisecond)
+	lock.Lock()
+	value, version = `{"ip":"192.168.1.2"}`, 2
+	close(changed)
+	changed = make(chan struct{})
+	lock.Unlock()
+	select {
+	case v := <-values:
+		Assert(t, reflect.DeepEqual(map[string]interface{}{"ip": "192.168.1.2"}, v), v)
+	case <-time.After(5 * time.Second):
+		t.Fatal("watch handler is not called after changed")
+	}
+	Assert(t, atomic.LoadInt32(&waits) >= 2)
+
+	cancel()
+	Assert(t, context.Canceled == <-done)
+}
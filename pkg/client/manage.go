@@ -0,0 +1,231 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metad
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"openpitrix.io/metad/pkg/store"
+)
+
+const (
+	DefaultManageEndpoint = "http://127.0.0.1:9611"
+	defaultManageTimeout  = 30 * time.Second
+	mergePatchType        = "application/merge-patch+json"
+)
+
+// APIError is the error response of metad.
+type APIError struct {
+	Status    int
+	Message   string
+	RequestID string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("metad response status [%d]: %s, requestID: [%s]", e.Status, e.Message, e.RequestID)
+}
+
+// IsNotFound return whether the err is a 404 response.
+func IsNotFound(err error) bool {
+	var e *APIError
+	return errors.As(err, &e) && e.Status == http.StatusNotFound
+}
+
+// decodeResponse decode the json response to the result if not nil, return APIError if not 200.
+func decodeResponse(resp *http.Response, result interface{}) error {
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		e := &APIError{Status: resp.StatusCode, Message: http.StatusText(resp.StatusCode), RequestID: resp.Header.Get("X-Metad-RequestID")}
+		var obj struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(body, &obj) == nil && obj.Message != "" {
+			e.Message = obj.Message
+		}
+		return e
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.Unmarshal(body, result); err != nil {
+		return fmt.Errorf("invalid metad response: %s", err.Error())
+	}
+	return nil
+}
+
+// responseVersion return the X-Metad-Version of the response, 0 if absent.
+func responseVersion(resp *http.Response) uint64 {
+	v, _ := strconv.ParseUint(resp.Header.Get("X-Metad-Version"), 10, 64)
+	return v
+}
+
+// ManageOptions is the options of the manage client.
+type ManageOptions struct {
+	// Auth set the credential of the requests, such as BearerToken, anonymous if nil.
+	Auth Auth
+	// HTTPClient send the requests, default a client with 30s timeout.
+	HTTPClient *http.Client
+}
+
+// ManageClient call the manage api of a metad with typed methods.
+type ManageClient struct {
+	url        string
+	auth       Auth
+	httpClient *http.Client
+}
+
+// NewManageClient create the client of the manage api endpoint, DefaultManageEndpoint if empty.
+func NewManageClient(endpoint string, options ManageOptions) *ManageClient {
+	if endpoint == "" {
+		endpoint = DefaultManageEndpoint
+	}
+	if !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") {
+		endpoint = "http://" + endpoint
+	}
+	httpClient := options.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: defaultManageTimeout}
+	}
+	return &ManageClient{url: strings.TrimRight(endpoint, "/"), auth: options.Auth, httpClient: httpClient}
+}
+
+// do send the request with the body encoded as contentType json if not nil, and decode the response to the result.
+func (c *ManageClient) do(ctx context.Context, method string, uri string, query url.Values, contentType string, body interface{}, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(b)
+	}
+	u := c.url + uri
+	if len(query) > 0 {
+		u = u + "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, u, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.auth != nil {
+		if err := c.auth.Apply(req); err != nil {
+			return err
+		}
+	}
+	resp, err := c.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return decodeResponse(resp, result)
+}
+
+func apiPath(api string, nodePath string) string {
+	nodePath = path.Join("/", nodePath)
+	if nodePath == "/" {
+		return api
+	}
+	return api + nodePath
+}
+
+func subsQuery(subs []string) url.Values {
+	if len(subs) == 0 {
+		return nil
+	}
+	return url.Values{"subs": {strings.Join(subs, ",")}}
+}
+
+func hostsQuery(hosts []string) url.Values {
+	if len(hosts) == 0 {
+		return nil
+	}
+	return url.Values{"hosts": {strings.Join(hosts, ",")}}
+}
+
+// GetData return the metadata of the nodePath, the error is IsNotFound if missing.
+func (c *ManageClient) GetData(ctx context.Context, nodePath string) (interface{}, error) {
+	var data interface{}
+	err := c.do(ctx, "GET", apiPath("/v1/data", nodePath), nil, "", nil, &data)
+	return data, err
+}
+
+// PutData replace the metadata of the nodePath with the data if replace, otherwise merge the data.
+func (c *ManageClient) PutData(ctx context.Context, nodePath string, data interface{}, replace bool) error {
+	method := "PUT"
+	if replace {
+		method = "POST"
+	}
+	return c.do(ctx, method, apiPath("/v1/data", nodePath), nil, "application/json", data, nil)
+}
+
+// PatchData apply the JSON merge patch to the metadata of the nodePath, the nil members are deleted.
+func (c *ManageClient) PatchData(ctx context.Context, nodePath string, patch map[string]interface{}) error {
+	return c.do(ctx, "PATCH", apiPath("/v1/data", nodePath), nil, mergePatchType, patch, nil)
+}
+
+// DeleteData delete the metadata of the nodePath, or only the sub nodes if subs present.
+func (c *ManageClient) DeleteData(ctx context.Context, nodePath string, subs ...string) error {
+	return c.do(ctx, "DELETE", apiPath("/v1/data", nodePath), subsQuery(subs), "", nil, nil)
+}
+
+// GetMapping return the ip mapping of the nodePath, the first path element is the client ip (or host).
+func (c *ManageClient) GetMapping(ctx context.Context, nodePath string) (interface{}, error) {
+	var mapping interface{}
+	err := c.do(ctx, "GET", apiPath("/v1/mapping", nodePath), nil, "", nil, &mapping)
+	return mapping, err
+}
+
+// PutMapping replace the mapping of the nodePath if replace, otherwise merge the mapping.
+func (c *ManageClient) PutMapping(ctx context.Context, nodePath string, mapping interface{}, replace bool) error {
+	method := "PUT"
+	if replace {
+		method = "POST"
+	}
+	return c.do(ctx, method, apiPath("/v1/mapping", nodePath), nil, "application/json", mapping, nil)
+}
+
+// DeleteMapping delete the mapping of the nodePath, or only the sub nodes if subs present.
+func (c *ManageClient) DeleteMapping(ctx context.Context, nodePath string, subs ...string) error {
+	return c.do(ctx, "DELETE", apiPath("/v1/mapping", nodePath), subsQuery(subs), "", nil, nil)
+}
+
+// GetAccessRules return the access rules of the hosts, all hosts if empty.
+func (c *ManageClient) GetAccessRules(ctx context.Context, hosts ...string) (map[string][]store.AccessRule, error) {
+	rules := map[string][]store.AccessRule{}
+	err := c.do(ctx, "GET", "/v1/rule", hostsQuery(hosts), "", nil, &rules)
+	return rules, err
+}
+
+// PutAccessRules replace the access rules of the hosts.
+func (c *ManageClient) PutAccessRules(ctx context.Context, rules map[string][]store.AccessRule) error {
+	return c.do(ctx, "PUT", "/v1/rule", nil, "application/json", rules, nil)
+}
+
+// DeleteAccessRules delete the access rules of the hosts.
+func (c *ManageClient) DeleteAccessRules(ctx context.Context, hosts ...string) error {
+	if len(hosts) == 0 {
+		return errors.New("hosts must not be empty.")
+	}
+	return c.do(ctx, "DELETE", "/v1/rule", hostsQuery(hosts), "", nil, nil)
+}
@@ -0,0 +1,91 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metad
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	. "openpitrix.io/metad/pkg/assert"
+	"openpitrix.io/metad/pkg/store"
+)
+
+func TestManageClient(t *testing.T) {
+	type request struct {
+		method, uri, contentType, auth, body string
+	}
+	var requests []request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		requests = append(requests, request{req.Method, req.URL.RequestURI(), req.Header.Get("Content-Type"), req.Header.Get("Authorization"), string(body)})
+		w.Header().Set("X-Metad-RequestID", "REQ-1")
+		switch {
+		case req.Method == "GET" && req.URL.Path == "/v1/data/nodes/1":
+			w.Write([]byte(`{"ip": "192.168.1.1"}`))
+		case req.Method == "GET" && req.URL.Path == "/v1/rule":
+			w.Write([]byte(`{"192.168.1.1": [{"path": "/", "mode": 1}]}`))
+		case req.Method == "GET":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"code": 404, "message": "Not found", "type": "ERROR"}`))
+		default:
+			w.Write([]byte(`{"code": 200, "type": "OK"}`))
+		}
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	c := NewManageClient(server.URL, ManageOptions{Auth: BearerToken("secret")})
+	data, err := c.GetData(ctx, "nodes/1")
+	Assert(t, nil == err)
+	Assert(t, reflect.DeepEqual(map[string]interface{}{"ip": "192.168.1.1"}, data), data)
+	Assert(t, "Bearer secret" == requests[0].auth)
+
+	_, err = c.GetData(ctx, "/nodes/2")
+	Assert(t, IsNotFound(err), err)
+	var apiErr *APIError
+	Assert(t, errors.As(err, &apiErr) && "Not found" == apiErr.Message && "REQ-1" == apiErr.RequestID)
+
+	Assert(t, nil == c.PutData(ctx, "/nodes/1", map[string]interface{}{"ip": "192.168.1.2"}, true))
+	Assert(t, nil == c.PutData(ctx, "/", map[string]interface{}{"env": "test"}, false))
+	Assert(t, nil == c.PatchData(ctx, "/nodes/1", map[string]interface{}{"name": nil}))
+	Assert(t, nil == c.DeleteData(ctx, "/nodes", "1", "2"))
+	Assert(t, nil == c.PutMapping(ctx, "/192.168.1.1", map[string]interface{}{"node": "/nodes/1"}, false))
+	Assert(t, nil == c.DeleteMapping(ctx, "/192.168.1.1"))
+
+	rules, err := c.GetAccessRules(ctx, "192.168.1.1", "192.168.1.2")
+	Assert(t, nil == err)
+	Assert(t, reflect.DeepEqual(map[string][]store.AccessRule{"192.168.1.1": {{Path: "/", Mode: store.AccessModeRead}}}, rules), rules)
+	Assert(t, nil == c.PutAccessRules(ctx, rules))
+	Assert(t, nil != c.DeleteAccessRules(ctx))
+	Assert(t, nil == c.DeleteAccessRules(ctx, "192.168.1.1"))
+
+	expected := []request{
+		{"GET", "/v1/data/nodes/1", "", "Bearer secret", ""},
+		{"GET", "/v1/data/nodes/2", "", "Bearer secret", ""},
+		{"POST", "/v1/data/nodes/1", "application/json", "Bearer secret", `{"ip":"192.168.1.2"}`},
+		{"PUT", "/v1/data", "application/json", "Bearer secret", `{"env":"test"}`},
+		{"PATCH", "/v1/data/nodes/1", "application/merge-patch+json", "Bearer secret", `{"name":null}`},
+		{"DELETE", "/v1/data/nodes?subs=1%2C2", "", "Bearer secret", ""},
+		{"PUT", "/v1/mapping/192.168.1.1", "application/json", "Bearer secret", `{"node":"/nodes/1"}`},
+		{"DELETE", "/v1/mapping/192.168.1.1", "", "Bearer secret", ""},
+		{"GET", "/v1/rule?hosts=192.168.1.1%2C192.168.1.2", "", "Bearer secret", ""},
+		{"PUT", "/v1/rule", "application/json", "Bearer secret", `{"192.168.1.1":[{"path":"/","mode":1}]}`},
+		{"DELETE", "/v1/rule?hosts=192.168.1.1", "", "Bearer secret", ""},
+	}
+	Assert(t, reflect.DeepEqual(expected, requests), requests)
+
+	// the request fail if the auth fail.
+	c = NewManageClient(server.URL, ManageOptions{Auth: BearerTokenSource(func() (string, error) {
+		return "", errors.New("token expired")
+	})})
+	_, err = c.GetData(ctx, "/nodes/1")
+	Assert(t, nil != err && "token expired" == err.Error(), err)
+	Assert(t, len(expected) == len(requests))
+}
@@ -0,0 +1,296 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metad
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"openpitrix.io/metad/pkg/logger"
+)
+
+const (
+	DefaultReplicaListen          = "127.0.0.1:9181"
+	DefaultReplicaRefreshInterval = 30 * time.Second
+	replicaRetryInterval          = 5 * time.Second
+)
+
+// replicaCache is the persisted /self view.
+type replicaCache struct {
+	Version  uint64          `json:"version"`
+	ETag     string          `json:"etag"`
+	SyncedAt time.Time       `json:"synced_at"`
+	Data     json.RawMessage `json:"data"`
+}
+
+// Replica keep a local copy of the client's /self view, persist it to the cache file, and serve it over http,
+// so the node local consumers can still read the metadata when metad is unreachable.
+// The response has X-Metad-Stale header if the last sync failed or the copy is only loaded from the cache file,
+// and X-Metad-Synced-At header of the last successful sync.
+type Replica struct {
+	client          *Client
+	cacheFile       string
+	refreshInterval time.Duration
+	cache           replicaCache
+	data            interface{}
+	stale           bool
+	lock            sync.RWMutex
+	stopChan        chan bool
+	done            chan struct{}
+	stopped         int32
+}
+
+// NewReplica create the replica of the client, the previous copy is loaded from the cacheFile if exists,
+// the copy is refreshed every refreshInterval to detect the metad is unreachable.
+func NewReplica(client *Client, cacheFile string, refreshInterval time.Duration) (*Replica, error) {
+	if refreshInterval <= 0 {
+		refreshInterval = DefaultReplicaRefreshInterval
+	}
+	r := &Replica{
+		client:          client,
+		cacheFile:       cacheFile,
+		refreshInterval: refreshInterval,
+		stale:           true,
+		stopChan:        make(chan bool),
+		done:            make(chan struct{}),
+	}
+	if cacheFile == "" {
+		return r, nil
+	}
+	b, err := ioutil.ReadFile(cacheFile)
+	if os.IsNotExist(err) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	cache := replicaCache{}
+	if err := json.Unmarshal(b, &cache); err != nil {
+		return nil, fmt.Errorf("invalid replica cache file [%s]: %s", cacheFile, err.Error())
+	}
+	var data interface{}
+	if err := json.Unmarshal(cache.Data, &data); err != nil {
+		return nil, fmt.Errorf("invalid replica cache file [%s]: %s", cacheFile, err.Error())
+	}
+	r.cache = cache
+	r.data = data
+	// cached version may be not match the restarted metad, so fetch the data instead of wait it.
+	r.cache.Version = 0
+	return r, nil
+}
+
+// Start sync the /self view in background until Stop.
+func (r *Replica) Start() {
+	go r.run()
+}
+
+func (r *Replica) Stop() {
+	if atomic.CompareAndSwapInt32(&r.stopped, 0, 1) {
+		close(r.stopChan)
+		<-r.done
+	}
+}
+
+func (r *Replica) isStopped() bool {
+	return atomic.LoadInt32(&r.stopped) == 1
+}
+
+func (r *Replica) run() {
+	defer close(r.done)
+	for !r.isStopped() {
+		changed, err := r.sync()
+		if r.isStopped() {
+			return
+		}
+		if err != nil {
+			logger.Warn("Sync replica error: %s, serve the copy synced at %s.", err.Error(), r.syncedAt().Format(time.RFC3339))
+			r.setStale()
+			select {
+			case <-time.After(replicaRetryInterval):
+			case <-r.stopChan:
+				return
+			}
+			continue
+		}
+		if changed {
+			r.persist()
+		}
+	}
+}
+
+// sync fetch the /self view if no version synced, otherwise wait its change until refreshInterval,
+// return whether the data changed.
+func (r *Replica) sync() (bool, error) {
+	conn := r.client.getCurrent()
+	if conn == nil {
+		return false, errNoConnection
+	}
+	r.lock.RLock()
+	version, etag := r.cache.Version, r.cache.ETag
+	r.lock.RUnlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), r.refreshInterval)
+	defer cancel()
+	go func() {
+		select {
+		case <-r.stopChan:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	uri := "/self"
+	if version > 0 {
+		uri = fmt.Sprintf("%s?wait=true&prev_version=%d", uri, version)
+	}
+	req, err := conn.newRequest(ctx, "GET", uri)
+	if err != nil {
+		return false, err
+	}
+	if etag != "" && version == 0 {
+		req.Header.Set("If-None-Match", etag)
+	}
+	resp, err := conn.httpClient.Do(req)
+	if err != nil {
+		if version > 0 && ctx.Err() == context.DeadlineExceeded {
+			// no change in the refresh interval, check metad is still reachable by fetch again.
+			r.lock.Lock()
+			r.cache.Version = 0
+			r.lock.Unlock()
+			return false, nil
+		}
+		atomic.AddUint32(&conn.errTimes, 1)
+		return false, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		atomic.AddUint32(&conn.errTimes, 1)
+		return false, err
+	}
+	atomic.StoreUint32(&conn.errTimes, 0)
+	newVersion, _ := strconv.ParseUint(resp.Header.Get("X-Metad-Version"), 10, 64)
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.cache.SyncedAt = time.Now()
+	r.stale = false
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		r.cache.Version = newVersion
+		return false, nil
+	case http.StatusOK:
+		var data interface{}
+		if err := json.Unmarshal(body, &data); err != nil {
+			r.stale = true
+			return false, fmt.Errorf("invalid metad response: %s", err.Error())
+		}
+		r.cache.Version = newVersion
+		r.cache.ETag = resp.Header.Get("ETag")
+		r.cache.Data = body
+		r.data = data
+		return true, nil
+	default:
+		r.stale = true
+		return false, fmt.Errorf("metad response status [%v], requestID: [%s]", resp.StatusCode, resp.Header.Get("X-Metad-RequestID"))
+	}
+}
+
+// persist write the copy to the cache file atomically.
+func (r *Replica) persist() {
+	if r.cacheFile == "" {
+		return
+	}
+	r.lock.RLock()
+	b, err := json.Marshal(r.cache)
+	r.lock.RUnlock()
+	if err != nil {
+		logger.Error("Marshal replica cache error: %s", err.Error())
+		return
+	}
+	tmpFile := r.cacheFile + ".tmp"
+	if err := ioutil.WriteFile(tmpFile, b, 0600); err != nil {
+		logger.Error("Write replica cache file [%s] error: %s", tmpFile, err.Error())
+		return
+	}
+	if err := os.Rename(tmpFile, r.cacheFile); err != nil {
+		logger.Error("Rename replica cache file [%s] error: %s", tmpFile, err.Error())
+	}
+}
+
+func (r *Replica) setStale() {
+	r.lock.Lock()
+	r.stale = true
+	r.lock.Unlock()
+}
+
+func (r *Replica) syncedAt() time.Time {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.cache.SyncedAt
+}
+
+// Get return the value at nodePath of the copy, and whether it is stale and when it was synced.
+func (r *Replica) Get(nodePath string) (interface{}, bool, time.Time) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	val := r.data
+	for _, elem := range strings.Split(path.Clean(path.Join("/", nodePath)), "/") {
+		if elem == "" {
+			continue
+		}
+		m, ok := val.(map[string]interface{})
+		if !ok {
+			val = nil
+			break
+		}
+		val = m[elem]
+	}
+	return val, r.stale, r.cache.SyncedAt
+}
+
+// ServeHTTP serve the copy as json, the path is relative to /self.
+func (r *Replica) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" && req.Method != "HEAD" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	val, stale, syncedAt := r.Get(strings.TrimPrefix(req.URL.Path, "/self"))
+	w.Header().Set("Content-Type", "application/json")
+	if !syncedAt.IsZero() {
+		w.Header().Set("X-Metad-Synced-At", syncedAt.Format(time.RFC3339))
+	}
+	w.Header().Set("X-Metad-Stale", strconv.FormatBool(stale))
+	if val == nil {
+		status := http.StatusNotFound
+		if syncedAt.IsZero() {
+			// never synced.
+			status = http.StatusServiceUnavailable
+		}
+		w.WriteHeader(status)
+		b, _ := json.Marshal(map[string]interface{}{"message": http.StatusText(status), "type": "ERROR"})
+		w.Write(b)
+		return
+	}
+	b, _ := json.Marshal(val)
+	w.Write(b)
+}
+
+// ListenAndServe serve the copy on the listen address, DefaultReplicaListen if empty.
+func (r *Replica) ListenAndServe(listen string) error {
+	if listen == "" {
+		listen = DefaultReplicaListen
+	}
+	return http.ListenAndServe(listen, r)
+}
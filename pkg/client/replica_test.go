@@ -0,0 +1,161 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metad
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "openpitrix.io/metad/pkg/assert"
+)
+
+// testServer is a fake metad, serve the same json value on every path, abort the connections when down.
+type testServer struct {
+	*httptest.Server
+	value    string
+	version  int64
+	down     int32
+	requests int32
+}
+
+func newTestServer(value string) *testServer {
+	s := &testServer{value: value, version: 1}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+func (s *testServer) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	atomic.AddInt32(&s.requests, 1)
+	if s.isDown() {
+		if req.Method == "HEAD" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		// close the connection, the client get an error instead of a response.
+		panic(http.ErrAbortHandler)
+	}
+	if req.FormValue("wait") == "true" {
+		// no change, wait until the client give up.
+		<-req.Context().Done()
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Metad-Version", strconv.FormatInt(atomic.LoadInt64(&s.version), 10))
+	w.Write([]byte(s.value))
+}
+
+func (s *testServer) setDown(down bool) {
+	var v int32
+	if down {
+		v = 1
+	}
+	atomic.StoreInt32(&s.down, v)
+}
+
+func (s *testServer) isDown() bool {
+	return atomic.LoadInt32(&s.down) == 1
+}
+
+// waitFor check the condition until it is true or timeout.
+func waitFor(condition func() bool) bool {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if condition() {
+			return true
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return false
+}
+
+func TestReplica(t *testing.T) {
+	server := newTestServer(`{"host":"h1","env":{"k":"v"}}`)
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "metad_replica")
+	Assert(t, nil == err)
+	defer os.RemoveAll(dir)
+	cacheFile := filepath.Join(dir, "replica.json")
+
+	client, err := NewMetadClientWithOptions([]string{server.URL}, ClientOptions{PreferOrder: true})
+	Assert(t, nil == err)
+
+	// never synced.
+	replica, err := NewReplica(client, cacheFile, 200*time.Millisecond)
+	Assert(t, nil == err)
+	w := httptest.NewRecorder()
+	replica.ServeHTTP(w, httptest.NewRequest("GET", "/self/host", nil))
+	Assert(t, http.StatusServiceUnavailable == w.Code, w.Code)
+
+	// fill.
+	replica.Start()
+	Assert(t, waitFor(func() bool {
+		val, stale, _ := replica.Get("/host")
+		return "h1" == val && !stale
+	}))
+	w = httptest.NewRecorder()
+	replica.ServeHTTP(w, httptest.NewRequest("GET", "/self/env", nil))
+	Assert(t, http.StatusOK == w.Code, w.Code)
+	Assert(t, `{"k":"v"}` == w.Body.String(), w.Body.String())
+	Assert(t, "false" == w.Header().Get("X-Metad-Stale"))
+	Assert(t, "" != w.Header().Get("X-Metad-Synced-At"))
+
+	w = httptest.NewRecorder()
+	replica.ServeHTTP(w, httptest.NewRequest("GET", "/self/not_exist", nil))
+	Assert(t, http.StatusNotFound == w.Code, w.Code)
+
+	w = httptest.NewRecorder()
+	replica.ServeHTTP(w, httptest.NewRequest("PUT", "/self/host", nil))
+	Assert(t, http.StatusMethodNotAllowed == w.Code, w.Code)
+
+	Assert(t, waitFor(func() bool {
+		_, err := os.Stat(cacheFile)
+		return err == nil
+	}))
+
+	// offline, the copy is still served but stale after the refresh failed.
+	server.setDown(true)
+	Assert(t, waitFor(func() bool {
+		_, stale, _ := replica.Get("/")
+		return stale
+	}))
+	w = httptest.NewRecorder()
+	replica.ServeHTTP(w, httptest.NewRequest("GET", "/self/host", nil))
+	Assert(t, http.StatusOK == w.Code, w.Code)
+	Assert(t, `"h1"` == w.Body.String(), w.Body.String())
+	Assert(t, "true" == w.Header().Get("X-Metad-Stale"))
+	replica.Stop()
+
+	// restart offline, the copy is loaded from the cache file, stale until synced.
+	replica, err = NewReplica(client, cacheFile, 200*time.Millisecond)
+	Assert(t, nil == err)
+	val, stale, syncedAt := replica.Get("/env/k")
+	Assert(t, "v" == val, val)
+	Assert(t, stale)
+	Assert(t, !syncedAt.IsZero())
+
+	b, err := ioutil.ReadFile(cacheFile)
+	Assert(t, nil == err)
+	cache := replicaCache{}
+	Assert(t, nil == json.Unmarshal(b, &cache))
+	Assert(t, 1 == cache.Version, cache.Version)
+
+	// back online.
+	server.setDown(false)
+	replica.Start()
+	defer replica.Stop()
+	Assert(t, waitFor(func() bool {
+		_, stale, _ := replica.Get("/")
+		return !stale
+	}))
+}
@@ -0,0 +1,130 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metad
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"openpitrix.io/metad/pkg/logger"
+)
+
+// WatchHandler is called with the value of the watched path (nil if missing) and its version,
+// on start and after every change.
+type WatchHandler func(value interface{}, version uint64)
+
+// get read the nodePath from the connection, the 5xx and transport errors count to the connection failures.
+func (c *Client) get(ctx context.Context, conn *Connection, nodePath string) (interface{}, uint64, error) {
+	req, err := conn.newRequest(ctx, "GET", nodePath)
+	if err != nil {
+		return nil, 0, err
+	}
+	resp, err := conn.httpClient.Do(req)
+	if err != nil {
+		atomic.AddUint32(&conn.errTimes, 1)
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		atomic.AddUint32(&conn.errTimes, 1)
+	} else {
+		atomic.StoreUint32(&conn.errTimes, 0)
+	}
+	var value interface{}
+	err = decodeResponse(resp, &value)
+	return value, responseVersion(resp), err
+}
+
+// Get read the metadata of the nodePath (such as /self/node, relative to the client's view), return the value
+// and the version, the error is IsNotFound if missing.
+func (c *Client) Get(ctx context.Context, nodePath string) (interface{}, uint64, error) {
+	conn := c.getCurrent()
+	if conn == nil {
+		return nil, 0, errNoConnection
+	}
+	return c.get(ctx, conn, nodePath)
+}
+
+// wait long-poll the change of the nodePath after the version, return whether changed,
+// false if the server respond 504 at the request timeout without change.
+func (c *Client) wait(ctx context.Context, conn *Connection, nodePath string, version uint64) (bool, error) {
+	req, err := conn.newRequest(ctx, "GET", fmt.Sprintf("%s?wait=true&prev_version=%d", nodePath, version))
+	if err != nil {
+		return false, err
+	}
+	resp, err := conn.httpClient.Do(req)
+	if err != nil {
+		atomic.AddUint32(&conn.errTimes, 1)
+		return false, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		atomic.StoreUint32(&conn.errTimes, 0)
+		// the changes are not used, the handler is called with the value read again.
+		io.Copy(ioutil.Discard, resp.Body)
+		return true, nil
+	case resp.StatusCode == http.StatusGatewayTimeout:
+		return false, nil
+	case resp.StatusCode >= 500:
+		atomic.AddUint32(&conn.errTimes, 1)
+	}
+	return false, decodeResponse(resp, nil)
+}
+
+// Watch call the handler with the value of the nodePath, then long-poll its changes and call the handler with
+// the value read again after every change, until the ctx done. The errors are logged and retried with jittered
+// backoff up to MaxRetryInterval, the client fail over to another server after 3 failures, and the value is read
+// again from the new server as the versions of the servers differ.
+func (c *Client) Watch(ctx context.Context, nodePath string, handler WatchHandler) error {
+	var current *Connection
+	var version uint64
+	// read the value again on start and after changed.
+	read := true
+	backoff := minRetryInterval
+	for ctx.Err() == nil {
+		conn := c.getCurrent()
+		var err error
+		if conn == nil {
+			err = errNoConnection
+		} else if conn != current || read {
+			var value interface{}
+			var v uint64
+			value, v, err = c.get(ctx, conn, nodePath)
+			if IsNotFound(err) {
+				value, err = nil, nil
+			}
+			if err == nil {
+				current, version, read = conn, v, false
+				handler(value, version)
+			}
+		} else {
+			read, err = c.wait(ctx, conn, nodePath, version)
+		}
+		if err == nil {
+			backoff = minRetryInterval
+			continue
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		logger.Warn("Watch [%s] error: %s, retry after %v.", nodePath, err.Error(), backoff)
+		select {
+		case <-time.After(backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))):
+		case <-ctx.Done():
+		}
+		backoff *= 2
+		if backoff > c.options.MaxRetryInterval {
+			backoff = c.options.MaxRetryInterval
+		}
+	}
+	return ctx.Err()
+}
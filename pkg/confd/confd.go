@@ -0,0 +1,312 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+// Package confd convert the confd configuration dir (confd.toml, conf.d/*.toml and templates/) to the metad
+// config options, the render definitions and the mapping rule for migrating confd onto metad, and render the confd
+// templates of the render definitions.
+package confd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// The files of the confd config dir.
+const (
+	configFile   = "confd.toml"
+	resourcesDir = "conf.d"
+	templatesDir = "templates"
+)
+
+// MappingRuleName is the name of the converted mapping rule, which match all clients.
+const MappingRuleName = "confd"
+
+// Render is the render definition of a confd template resource: the template Src is rendered to Dest with the
+// values of the Keys, and the keys of the template functions (such as getv) are relative to Prefix.
+type Render struct {
+	Name      string   `yaml:"name" json:"name"`
+	Src       string   `yaml:"src" json:"src"`
+	Dest      string   `yaml:"dest" json:"dest"`
+	Prefix    string   `yaml:"prefix" json:"prefix"`
+	Keys      []string `yaml:"keys" json:"keys"`
+	Owner     string   `yaml:"owner,omitempty" json:"owner,omitempty"`
+	Mode      string   `yaml:"mode,omitempty" json:"mode,omitempty"`
+	UID       *int64   `yaml:"uid,omitempty" json:"uid,omitempty"`
+	GID       *int64   `yaml:"gid,omitempty" json:"gid,omitempty"`
+	CheckCmd  string   `yaml:"check_cmd,omitempty" json:"check_cmd,omitempty"`
+	ReloadCmd string   `yaml:"reload_cmd,omitempty" json:"reload_cmd,omitempty"`
+}
+
+// MappingRule is the converted mapping rule, see the mapping rule api.
+type MappingRule struct {
+	Name    string                 `yaml:"name" json:"name"`
+	Match   string                 `yaml:"match" json:"match"`
+	Mapping map[string]interface{} `yaml:"mapping" json:"mapping"`
+}
+
+// Unconverted is an option (or a whole file if Key is empty) of the confd config that has no metad equivalent.
+type Unconverted struct {
+	File   string `yaml:"file" json:"file"`
+	Key    string `yaml:"key,omitempty" json:"key,omitempty"`
+	Reason string `yaml:"reason" json:"reason"`
+}
+
+func (u *Unconverted) String() string {
+	if u.Key == "" {
+		return fmt.Sprintf("%s: %s", u.File, u.Reason)
+	}
+	return fmt.Sprintf("%s: [%s] %s", u.File, u.Key, u.Reason)
+}
+
+// Import is the result of the conversion.
+type Import struct {
+	// Config is the metad config options of the confd backend config, empty if confd use metad as backend.
+	Config      map[string]interface{} `yaml:"config" json:"config"`
+	Renders     []*Render              `yaml:"renders" json:"renders"`
+	MappingRule *MappingRule           `yaml:"mapping_rule,omitempty" json:"mapping_rule,omitempty"`
+	Unconverted []*Unconverted         `yaml:"unconverted" json:"unconverted"`
+}
+
+func (im *Import) unconverted(file string, key string, reason string, args ...interface{}) {
+	im.Unconverted = append(im.Unconverted, &Unconverted{File: file, Key: key, Reason: fmt.Sprintf(reason, args...)})
+}
+
+// the confd backend options converted to the metad options.
+var configOptions = map[string]string{
+	"nodes":         "nodes",
+	"username":      "username",
+	"password":      "password",
+	"basic_auth":    "basic_auth",
+	"client_cert":   "client_cert",
+	"client_key":    "client_key",
+	"client_cakeys": "client_ca_keys",
+	"log-level":     "log_level",
+}
+
+// the confd options of the agent, the render definitions are rendered on every change instead.
+var agentOptions = map[string]bool{
+	"confdir":  true,
+	"interval": true,
+	"watch":    true,
+	"onetime":  true,
+	"noop":     true,
+}
+
+// Convert convert the confd config dir. The template resources are rendered with the /self view: the keys of
+// a resource read from a backend other than metad are mapped to the /self view by the mapping rule of all clients,
+// and the keys of a resource read from metad are kept. The options without metad equivalent are reported unconverted.
+func Convert(dir string) (*Import, error) {
+	im := &Import{Config: map[string]interface{}{}, Renders: []*Render{}, Unconverted: []*Unconverted{}}
+	config := map[string]interface{}{}
+	b, err := ioutil.ReadFile(filepath.Join(dir, configFile))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if config, err = parseTOML(string(b)); err != nil {
+			return nil, fmt.Errorf("parse %s error: %s", configFile, err.Error())
+		}
+	}
+	backend, _ := config["backend"].(string)
+	if backend == "" {
+		backend = "etcd"
+	}
+	fromMetad := backend == "metad"
+	prefix, _ := config["prefix"].(string)
+	prefix = path.Join("/", prefix)
+	im.convertConfig(config, backend)
+
+	files, err := filepath.Glob(filepath.Join(dir, resourcesDir, "*.toml"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no template resource in %s.", filepath.Join(dir, resourcesDir))
+	}
+	sort.Strings(files)
+	var dataPaths []string
+	for _, file := range files {
+		render, paths := im.convertResource(dir, file, prefix, fromMetad)
+		if render != nil {
+			im.Renders = append(im.Renders, render)
+			dataPaths = append(dataPaths, paths...)
+		}
+	}
+	if len(dataPaths) > 0 {
+		im.MappingRule = &MappingRule{Name: MappingRuleName, Match: "*", Mapping: selfMapping(dataPaths)}
+	}
+	return im, nil
+}
+
+func (im *Import) convertConfig(config map[string]interface{}, backend string) {
+	for _, k := range sortedKeys(config) {
+		v := config[k]
+		switch {
+		case k == "backend":
+			switch backend {
+			case "metad":
+			case "etcdv3":
+				im.Config["backend"] = "etcdv3"
+			case "etcd":
+				im.Config["backend"] = "etcdv3"
+				im.unconverted(configFile, k, "the etcd v2 keys are not visible to metad etcdv3 backend, migrate the keys to v3.")
+			default:
+				im.unconverted(configFile, k, "backend [%s] is not supported by metad.", backend)
+			}
+		case k == "prefix":
+			// the keys of the resources are prefixed.
+		case backend == "metad" && k == "nodes":
+			// the metad endpoints.
+		case configOptions[k] != "":
+			im.Config[configOptions[k]] = v
+		case agentOptions[k]:
+			im.unconverted(configFile, k, "the agent option is not converted, the renders are rendered on every change.")
+		default:
+			im.unconverted(configFile, k, "no metad equivalent.")
+		}
+	}
+}
+
+// convertResource convert the template resource file, return the render definition and the data paths of the keys,
+// nil if the file is unconvertible.
+func (im *Import) convertResource(dir string, file string, prefix string, fromMetad bool) (*Render, []string) {
+	name := strings.TrimSuffix(filepath.Base(file), ".toml")
+	relFile := filepath.Join(resourcesDir, filepath.Base(file))
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		im.unconverted(relFile, "", "read error: %s", err.Error())
+		return nil, nil
+	}
+	doc, err := parseTOML(string(b))
+	if err != nil {
+		im.unconverted(relFile, "", "parse error: %s", err.Error())
+		return nil, nil
+	}
+	resource, ok := doc["template"].(map[string]interface{})
+	if !ok {
+		im.unconverted(relFile, "", "missing [template] table.")
+		return nil, nil
+	}
+	for _, k := range sortedKeys(doc) {
+		if k != "template" {
+			im.unconverted(relFile, k, "unknown table or key.")
+		}
+	}
+	render := &Render{Name: name}
+	var keys []string
+	resourcePrefix := ""
+	for _, k := range sortedKeys(resource) {
+		v := resource[k]
+		var s string
+		switch t := v.(type) {
+		case string:
+			s = t
+		case int64:
+			s = fmt.Sprintf("%d", t)
+		}
+		switch k {
+		case "src":
+			render.Src = filepath.Join(dir, templatesDir, s)
+		case "dest":
+			render.Dest = s
+		case "keys":
+			array, _ := v.([]interface{})
+			for _, key := range array {
+				if key, ok := key.(string); ok {
+					keys = append(keys, key)
+				}
+			}
+		case "prefix":
+			resourcePrefix = s
+		case "owner":
+			render.Owner = s
+		case "mode":
+			render.Mode = s
+		case "uid", "gid":
+			id, ok := v.(int64)
+			if !ok {
+				im.unconverted(relFile, k, "should be an integer.")
+			} else if k == "uid" {
+				render.UID = &id
+			} else {
+				render.GID = &id
+			}
+		case "check_cmd":
+			render.CheckCmd = s
+		case "reload_cmd":
+			render.ReloadCmd = s
+		default:
+			im.unconverted(relFile, k, "no render definition equivalent.")
+		}
+	}
+	if render.Src == "" || render.Dest == "" || len(keys) == 0 {
+		im.unconverted(relFile, "", "src, dest and keys should not be empty.")
+		return nil, nil
+	}
+	if _, err := os.Stat(render.Src); err != nil {
+		im.unconverted(relFile, "src", "template %s not found.", filepath.Join(templatesDir, filepath.Base(render.Src)))
+	}
+	sort.Strings(keys)
+	if fromMetad {
+		// the keys are read from metad, keep them.
+		render.Prefix = path.Join(prefix, resourcePrefix)
+		render.Keys = keys
+		return render, nil
+	}
+	// the data paths are relative to the backend prefix of the metad config, as the confd prefix.
+	if prefix != "/" {
+		im.Config["prefix"] = prefix
+	}
+	render.Prefix = path.Join("/self", resourcePrefix)
+	render.Keys = keys
+	paths := make([]string, 0, len(keys))
+	for _, key := range keys {
+		p := path.Join("/", resourcePrefix, key)
+		if p == "/" {
+			im.unconverted(relFile, "keys", "the root key can not be mapped to /self.")
+			continue
+		}
+		paths = append(paths, p)
+	}
+	return render, paths
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// selfMapping map the data paths to the same paths of the /self view, the paths under another one are covered by it.
+func selfMapping(paths []string) map[string]interface{} {
+	sort.Strings(paths)
+	mapping := map[string]interface{}{}
+	var last string
+	for _, p := range paths {
+		if last != "" && (p == last || strings.HasPrefix(p, last+"/")) {
+			continue
+		}
+		last = p
+		node := mapping
+		elems := strings.Split(strings.TrimPrefix(p, "/"), "/")
+		for _, elem := range elems[:len(elems)-1] {
+			sub, ok := node[elem].(map[string]interface{})
+			if !ok {
+				sub = map[string]interface{}{}
+				node[elem] = sub
+			}
+			node = sub
+		}
+		node[elems[len(elems)-1]] = p
+	}
+	return mapping
+}
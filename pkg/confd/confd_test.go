@@ -0,0 +1,175 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package confd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	. "openpitrix.io/metad/pkg/assert"
+)
+
+func TestParseTOML(t *testing.T) {
+	doc, err := parseTOML(`
+# comment
+backend = "etcdv3" # trailing comment
+interval = 1_000
+watch = true
+ratio = 0.5
+'literal key' = 'C:\path'
+nodes = [
+  "http://127.0.0.1:2379", # first
+  "http://127.0.0.2:2379",
+]
+
+[template]
+src = "a \"b\".tmpl"
+keys = ["/a", "/b"]
+
+[a.b]
+c = []
+`)
+	Assert(t, nil == err, err)
+	expected := map[string]interface{}{
+		"backend":     "etcdv3",
+		"interval":    int64(1000),
+		"watch":       true,
+		"ratio":       0.5,
+		"literal key": `C:\path`,
+		"nodes":       []interface{}{"http://127.0.0.1:2379", "http://127.0.0.2:2379"},
+		"template":    map[string]interface{}{"src": `a "b".tmpl`, "keys": []interface{}{"/a", "/b"}},
+		"a":           map[string]interface{}{"b": map[string]interface{}{"c": []interface{}{}}},
+	}
+	Assert(t, reflect.DeepEqual(expected, doc), doc)
+
+	for _, invalid := range []string{
+		"[[template]]",
+		"a = 1\na = 2",
+		"a = {b = 1}",
+		`a = """x"""`,
+		`a = "x`,
+		"a = [1, 2",
+		"a = x",
+		"a = 1 2",
+		"a.b = 1",
+		"a",
+	} {
+		_, err := parseTOML(invalid)
+		Assert(t, nil != err, invalid)
+	}
+}
+
+func TestConvert(t *testing.T) {
+	dir, err := ioutil.TempDir("", "confd")
+	Assert(t, nil == err)
+	defer os.RemoveAll(dir)
+	write := func(file string, content string) {
+		p := filepath.Join(dir, file)
+		Assert(t, nil == os.MkdirAll(filepath.Dir(p), 0755))
+		Assert(t, nil == ioutil.WriteFile(p, []byte(content), 0644))
+	}
+
+	_, err = Convert(dir)
+	Assert(t, nil != err)
+
+	write("confd.toml", "backend = \"etcdv3\"\nnodes = [\"http://127.0.0.1:2379\"]\nprefix = \"/app\"\ninterval = 60\nscheme = \"http\"\n")
+	write("templates/nginx.conf.tmpl", "")
+	write("conf.d/nginx.toml", `[template]
+src = "nginx.conf.tmpl"
+dest = "/etc/nginx/nginx.conf"
+prefix = "/nginx"
+keys = ["/upstreams", "/upstreams/a", "/domain"]
+mode = "0644"
+uid = 0
+reload_cmd = "service nginx reload"
+keep_stage_file = true
+`)
+	write("conf.d/hosts.toml", "[template]\nsrc = \"hosts.tmpl\"\ndest = \"/etc/hosts\"\nkeys = [\"/hosts\"]\n")
+	write("conf.d/bad.toml", "[template\n")
+
+	im, err := Convert(dir)
+	Assert(t, nil == err, err)
+	Assert(t, reflect.DeepEqual(map[string]interface{}{"backend": "etcdv3", "nodes": []interface{}{"http://127.0.0.1:2379"}, "prefix": "/app"}, im.Config), im.Config)
+	Assert(t, 2 == len(im.Renders))
+	Assert(t, "hosts" == im.Renders[0].Name)
+	render := im.Renders[1]
+	var uid int64
+	Assert(t, reflect.DeepEqual(&Render{Name: "nginx", Src: filepath.Join(dir, "templates/nginx.conf.tmpl"), Dest: "/etc/nginx/nginx.conf",
+		Prefix: "/self/nginx", Keys: []string{"/domain", "/upstreams", "/upstreams/a"}, Mode: "0644", UID: &uid, ReloadCmd: "service nginx reload"}, render), render)
+	expected := map[string]interface{}{
+		"hosts": "/hosts",
+		"nginx": map[string]interface{}{"domain": "/nginx/domain", "upstreams": "/nginx/upstreams"},
+	}
+	Assert(t, "*" == im.MappingRule.Match)
+	Assert(t, reflect.DeepEqual(expected, im.MappingRule.Mapping), im.MappingRule.Mapping)
+	unconverted := []string{}
+	for _, u := range im.Unconverted {
+		unconverted = append(unconverted, u.String())
+	}
+	Assert(t, reflect.DeepEqual([]string{
+		"confd.toml: [interval] the agent option is not converted, the renders are rendered on every change.",
+		"confd.toml: [scheme] no metad equivalent.",
+		"conf.d/bad.toml: parse error: line 1: invalid table header",
+		"conf.d/hosts.toml: [src] template templates/hosts.tmpl not found.",
+		"conf.d/nginx.toml: [keep_stage_file] no render definition equivalent.",
+	}, unconverted), unconverted)
+
+	// the keys read from metad are kept.
+	write("confd.toml", "backend = \"metad\"\nnodes = [\"http://127.0.0.1\"]\n")
+	write("conf.d/hosts.toml", "[template]\nsrc = \"nginx.conf.tmpl\"\ndest = \"/etc/hosts\"\nkeys = [\"/self/hosts\"]\n")
+	im, err = Convert(dir)
+	Assert(t, nil == err, err)
+	Assert(t, 0 == len(im.Config) && nil == im.MappingRule)
+	Assert(t, "/" == im.Renders[0].Prefix && reflect.DeepEqual([]string{"/self/hosts"}, im.Renders[0].Keys))
+	Assert(t, "/nginx" == im.Renders[1].Prefix)
+}
+
+func TestRenderTemplate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "confd")
+	Assert(t, nil == err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "nginx.conf.tmpl")
+	Assert(t, nil == ioutil.WriteFile(src, []byte(`{{range gets "/upstreams/*"}}server {{.Value}}; # {{base .Key}}
+{{end}}{{range lsdir "/hosts"}}host {{.}} {{getv (printf "/hosts/%s/ip" .)}}
+{{end}}port {{getv "/port" "80"}} {{if exists "/tls"}}tls{{end}} {{join (ls "/hosts") ","}} {{toUpper (getv "/name")}}
+`), 0644))
+
+	values := map[string]string{
+		"/upstreams/2": "10.0.0.2",
+		"/upstreams/1": "10.0.0.1",
+		"/hosts/b/ip":  "192.168.1.2",
+		"/hosts/a/ip":  "192.168.1.1",
+		"/name":        "web",
+	}
+	content, err := RenderTemplate(src, values)
+	Assert(t, nil == err, err)
+	expected := `server 10.0.0.1; # 1
+server 10.0.0.2; # 2
+host a 192.168.1.1
+host b 192.168.1.2
+port 80  a,b WEB
+`
+	Assert(t, expected == string(content), string(content))
+
+	// missing key without default is error.
+	delete(values, "/name")
+	_, err = RenderTemplate(src, values)
+	Assert(t, err != nil && strings.Contains(err.Error(), "key does not exist: /name"), err)
+	Assert(t, nil == ioutil.WriteFile(src, []byte(`{{getv`), 0644))
+	_, err = RenderTemplate(src, values)
+	Assert(t, err != nil && strings.Contains(err.Error(), "parse template"), err)
+}
+
+func TestExecuteTemplate(t *testing.T) {
+	content, err := ExecuteTemplate("app.ini", `name={{.name}} port={{getv "/port"}}`, map[string]interface{}{"name": "web"}, map[string]string{"/port": "80"})
+	Assert(t, nil == err, err)
+	Assert(t, "name=web port=80" == string(content), string(content))
+	_, err = ExecuteTemplate("env", `{{getenv "HOME"}}`, nil, nil)
+	Assert(t, err != nil && strings.Contains(err.Error(), `function "getenv" not defined`), err)
+}
@@ -0,0 +1,218 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package confd
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// KVPair is a key and its value of the template store.
+type KVPair struct {
+	Key   string
+	Value string
+}
+
+// kvStore is the flat values of a render, the keys are absolute paths relative to the render prefix.
+type kvStore map[string]string
+
+func (s kvStore) exists(key string) bool {
+	_, ok := s[path.Join("/", key)]
+	return ok
+}
+
+func (s kvStore) get(key string) (KVPair, error) {
+	key = path.Join("/", key)
+	v, ok := s[key]
+	if !ok {
+		return KVPair{}, fmt.Errorf("key does not exist: %s", key)
+	}
+	return KVPair{Key: key, Value: v}, nil
+}
+
+func (s kvStore) getv(key string, defaultValue ...string) (string, error) {
+	kv, err := s.get(key)
+	if err != nil && len(defaultValue) > 0 {
+		return defaultValue[0], nil
+	}
+	return kv.Value, err
+}
+
+// gets return the pairs of the keys match the glob pattern, in key order.
+func (s kvStore) gets(pattern string) ([]KVPair, error) {
+	pattern = path.Join("/", pattern)
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	pairs := []KVPair{}
+	for k, v := range s {
+		if ok, _ := path.Match(pattern, k); ok {
+			pairs = append(pairs, KVPair{Key: k, Value: v})
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i].Key < pairs[j].Key
+	})
+	return pairs, nil
+}
+
+func (s kvStore) getvs(pattern string) ([]string, error) {
+	pairs, err := s.gets(pattern)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]string, 0, len(pairs))
+	for _, kv := range pairs {
+		values = append(values, kv.Value)
+	}
+	sort.Strings(values)
+	return values, nil
+}
+
+// children return the sorted names of the children of the dir, only the dirs if onlyDir.
+func (s kvStore) children(dir string, onlyDir bool) []string {
+	dir = path.Join("/", dir)
+	prefix := dir + "/"
+	if dir == "/" {
+		prefix = dir
+	}
+	names := map[string]bool{}
+	for k := range s {
+		if !strings.HasPrefix(k, prefix) {
+			continue
+		}
+		name := strings.TrimPrefix(k, prefix)
+		idx := strings.Index(name, "/")
+		if idx > 0 {
+			names[name[:idx]] = true
+		} else if !onlyDir {
+			names[name] = true
+		}
+	}
+	result := make([]string, 0, len(names))
+	for name := range names {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func (s kvStore) ls(dir string) []string {
+	return s.children(dir, false)
+}
+
+func (s kvStore) lsdir(dir string) []string {
+	return s.children(dir, true)
+}
+
+func unmarshalJSON(data string) (map[string]interface{}, error) {
+	var v map[string]interface{}
+	err := json.Unmarshal([]byte(data), &v)
+	return v, err
+}
+
+func unmarshalJSONArray(data string) ([]interface{}, error) {
+	var v []interface{}
+	err := json.Unmarshal([]byte(data), &v)
+	return v, err
+}
+
+func base64Decode(data string) (string, error) {
+	b, err := base64.StdEncoding.DecodeString(data)
+	return string(b), err
+}
+
+func fileExists(file string) bool {
+	_, err := os.Stat(file)
+	return err == nil
+}
+
+// localFuncs are the template functions reading the local environment, only for the local renders.
+var localFuncs = []string{"getenv", "fileExists"}
+
+// templateFuncs return the confd template functions, the store functions read the values, the localFuncs are
+// excluded unless local.
+func templateFuncs(values map[string]string, local bool) template.FuncMap {
+	s := kvStore(values)
+	funcs := template.FuncMap{
+		"exists":       s.exists,
+		"get":          s.get,
+		"gets":         s.gets,
+		"getv":         s.getv,
+		"getvs":        s.getvs,
+		"ls":           s.ls,
+		"lsdir":        s.lsdir,
+		"base":         path.Base,
+		"dir":          path.Dir,
+		"split":        strings.Split,
+		"join":         strings.Join,
+		"json":         unmarshalJSON,
+		"jsonArray":    unmarshalJSONArray,
+		"toUpper":      strings.ToUpper,
+		"toLower":      strings.ToLower,
+		"contains":     strings.Contains,
+		"replace":      strings.Replace,
+		"trimSuffix":   strings.TrimSuffix,
+		"getenv":       os.Getenv,
+		"datetime":     time.Now,
+		"fileExists":   fileExists,
+		"base64Encode": func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) },
+		"base64Decode": base64Decode,
+		"parseBool":    strconv.ParseBool,
+		"atoi":         strconv.Atoi,
+		"add":          func(a, b int) int { return a + b },
+		"sub":          func(a, b int) int { return a - b },
+		"mul":          func(a, b int) int { return a * b },
+		"div":          func(a, b int) int { return a / b },
+		"mod":          func(a, b int) int { return a % b },
+	}
+	if !local {
+		for _, name := range localFuncs {
+			delete(funcs, name)
+		}
+	}
+	return funcs
+}
+
+// RenderTemplate execute the confd template file with the values, the keys of the values are absolute paths
+// relative to the render prefix, such as /upstreams/1. The file is parsed on every render, so the template
+// changes take effect at the next render.
+func RenderTemplate(src string, values map[string]string) ([]byte, error) {
+	content, err := ioutil.ReadFile(src)
+	if err != nil {
+		return nil, err
+	}
+	return executeTemplate(src, string(content), nil, values, true)
+}
+
+// ExecuteTemplate parse and execute the template content with the data as dot, the store functions read the values.
+// The functions reading the local environment (getenv and fileExists) are not available, as the content and the
+// result may be from and to remote.
+func ExecuteTemplate(name string, content string, data interface{}, values map[string]string) ([]byte, error) {
+	return executeTemplate(name, content, data, values, false)
+}
+
+func executeTemplate(name string, content string, data interface{}, values map[string]string, local bool) ([]byte, error) {
+	tmpl, err := template.New(filepath.Base(name)).Funcs(templateFuncs(values, local)).Parse(content)
+	if err != nil {
+		return nil, fmt.Errorf("parse template [%s] error: %s", name, err.Error())
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("execute template [%s] error: %s", name, err.Error())
+	}
+	return buf.Bytes(), nil
+}
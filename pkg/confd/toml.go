@@ -0,0 +1,252 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package confd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseTOML parse the subset of TOML used by the confd config files: the [table] headers, the bare or quoted keys,
+// and the basic or literal strings, integers, floats, booleans and (multiline) arrays of them. The array of tables,
+// the inline tables, the multiline strings and the dotted keys are errors.
+func parseTOML(data string) (map[string]interface{}, error) {
+	root := map[string]interface{}{}
+	table := root
+	lines := strings.Split(data, "\n")
+	for i := 0; i < len(lines); i++ {
+		lineNo := i + 1
+		line := strings.TrimSpace(lines[i])
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if line[0] == '[' {
+			if strings.HasPrefix(line, "[[") {
+				return nil, fmt.Errorf("line %d: array of tables is not supported", lineNo)
+			}
+			end := strings.Index(line, "]")
+			if end < 0 || !isComment(line[end+1:]) {
+				return nil, fmt.Errorf("line %d: invalid table header", lineNo)
+			}
+			var err error
+			if table, err = tomlTable(root, strings.TrimSpace(line[1:end])); err != nil {
+				return nil, fmt.Errorf("line %d: %s", lineNo, err.Error())
+			}
+			continue
+		}
+		eq := strings.Index(line, "=")
+		if eq <= 0 {
+			return nil, fmt.Errorf("line %d: expected key = value", lineNo)
+		}
+		key, err := tomlKey(strings.TrimSpace(line[:eq]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", lineNo, err.Error())
+		}
+		if _, ok := table[key]; ok {
+			return nil, fmt.Errorf("line %d: duplicate key [%s]", lineNo, key)
+		}
+		s := strings.TrimSpace(line[eq+1:])
+		// the array may continue on the next lines.
+		for strings.HasPrefix(s, "[") && !arrayClosed(s) && i+1 < len(lines) {
+			i++
+			s = s + "\n" + lines[i]
+		}
+		value, rest, err := tomlValue(s)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: key [%s] %s", lineNo, key, err.Error())
+		}
+		if !isComment(rest) {
+			return nil, fmt.Errorf("line %d: unexpected [%s] after value", lineNo, strings.TrimSpace(rest))
+		}
+		table[key] = value
+	}
+	return root, nil
+}
+
+func isComment(s string) bool {
+	s = strings.TrimSpace(s)
+	return s == "" || s[0] == '#'
+}
+
+func tomlTable(root map[string]interface{}, name string) (map[string]interface{}, error) {
+	if name == "" {
+		return nil, fmt.Errorf("empty table name")
+	}
+	table := root
+	for _, elem := range strings.Split(name, ".") {
+		key, err := tomlKey(strings.TrimSpace(elem))
+		if err != nil {
+			return nil, err
+		}
+		switch t := table[key].(type) {
+		case nil:
+			sub := map[string]interface{}{}
+			table[key] = sub
+			table = sub
+		case map[string]interface{}:
+			table = t
+		default:
+			return nil, fmt.Errorf("key [%s] is not a table", key)
+		}
+	}
+	return table, nil
+}
+
+func tomlKey(s string) (string, error) {
+	if s == "" {
+		return "", fmt.Errorf("empty key")
+	}
+	if s[0] == '"' || s[0] == '\'' {
+		key, rest, err := tomlString(s)
+		if err != nil {
+			return "", err
+		}
+		if rest != "" {
+			return "", fmt.Errorf("invalid key [%s]", s)
+		}
+		return key, nil
+	}
+	for _, c := range s {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-') {
+			return "", fmt.Errorf("invalid key [%s]", s)
+		}
+	}
+	return s, nil
+}
+
+// arrayClosed return whether the brackets outside the strings and comments are balanced.
+func arrayClosed(s string) bool {
+	depth := 0
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			// skip the comment to the line end.
+			for i < len(s) && s[i] != '\n' {
+				i++
+			}
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+			if depth == 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// tomlValue parse the value at the start of s, return the value and the rest of s.
+func tomlValue(s string) (interface{}, string, error) {
+	if s == "" {
+		return nil, "", fmt.Errorf("missing value")
+	}
+	switch s[0] {
+	case '"', '\'':
+		return tomlString(s)
+	case '[':
+		return tomlArray(s)
+	case '{':
+		return nil, "", fmt.Errorf("inline table is not supported")
+	}
+	end := strings.IndexAny(s, " \t,]#\n")
+	if end < 0 {
+		end = len(s)
+	}
+	token, rest := s[:end], s[end:]
+	switch token {
+	case "true":
+		return true, rest, nil
+	case "false":
+		return false, rest, nil
+	}
+	number := strings.Replace(token, "_", "", -1)
+	if i, err := strconv.ParseInt(number, 10, 64); err == nil {
+		return i, rest, nil
+	}
+	if f, err := strconv.ParseFloat(number, 64); err == nil {
+		return f, rest, nil
+	}
+	return nil, "", fmt.Errorf("invalid value [%s]", token)
+}
+
+func tomlString(s string) (string, string, error) {
+	if strings.HasPrefix(s, `"""`) || strings.HasPrefix(s, "'''") {
+		return "", "", fmt.Errorf("multiline string is not supported")
+	}
+	if s[0] == '\'' {
+		end := strings.Index(s[1:], "'")
+		if end < 0 || strings.Contains(s[1:end+1], "\n") {
+			return "", "", fmt.Errorf("unterminated string")
+		}
+		return s[1 : end+1], s[end+2:], nil
+	}
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '\n':
+			return "", "", fmt.Errorf("unterminated string")
+		case '"':
+			value, err := strconv.Unquote(s[:i+1])
+			if err != nil {
+				return "", "", fmt.Errorf("invalid string %s", s[:i+1])
+			}
+			return value, s[i+1:], nil
+		}
+	}
+	return "", "", fmt.Errorf("unterminated string")
+}
+
+func tomlArray(s string) ([]interface{}, string, error) {
+	array := []interface{}{}
+	s = s[1:]
+	for {
+		s = skipSpaces(s)
+		if s == "" {
+			return nil, "", fmt.Errorf("unterminated array")
+		}
+		if s[0] == ']' {
+			return array, s[1:], nil
+		}
+		value, rest, err := tomlValue(s)
+		if err != nil {
+			return nil, "", err
+		}
+		array = append(array, value)
+		s = skipSpaces(rest)
+		if strings.HasPrefix(s, ",") {
+			s = s[1:]
+		} else if !strings.HasPrefix(s, "]") {
+			return nil, "", fmt.Errorf("expected , or ] in array")
+		}
+	}
+}
+
+// skipSpaces skip the whitespaces, newlines and comments.
+func skipSpaces(s string) string {
+	for {
+		s = strings.TrimLeft(s, " \t\r\n")
+		if !strings.HasPrefix(s, "#") {
+			return s
+		}
+		if end := strings.Index(s, "\n"); end >= 0 {
+			s = s[end:]
+		} else {
+			return ""
+		}
+	}
+}
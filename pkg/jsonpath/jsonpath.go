@@ -0,0 +1,228 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+// Package jsonpath select the values of the metadata tree by a subset of JSONPath.
+// The metadata arrays are objects with index keys, so the index selector [0] is the member named "0".
+// The supported selectors are the root $, the child .name, ['name'] and [0], the union ['a','b'], the wildcard
+// .* and [*], and the recursive descent ..name and ..*, the filter and the script expressions are not supported.
+package jsonpath
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type segment struct {
+	// names are the selected member names, nil means all members.
+	names     []string
+	recursive bool
+}
+
+// Path is a compiled JSONPath expression.
+type Path struct {
+	expr     string
+	segments []segment
+}
+
+func (p *Path) String() string {
+	return p.expr
+}
+
+// Compile parse the expression, which should begin with $.
+func Compile(expr string) (*Path, error) {
+	p := &Path{expr: expr}
+	s := strings.TrimSpace(expr)
+	if !strings.HasPrefix(s, "$") {
+		return nil, fmt.Errorf("invalid jsonpath [%s], should begin with $.", expr)
+	}
+	s = s[1:]
+	for s != "" {
+		seg := segment{}
+		switch {
+		case strings.HasPrefix(s, ".."):
+			seg.recursive = true
+			s = s[2:]
+			if strings.HasPrefix(s, "[") {
+				break
+			}
+			fallthrough
+		case strings.HasPrefix(s, "."):
+			s = strings.TrimPrefix(s, ".")
+			end := strings.IndexAny(s, ".[")
+			if end < 0 {
+				end = len(s)
+			}
+			name := s[:end]
+			s = s[end:]
+			if name == "" {
+				return nil, fmt.Errorf("invalid jsonpath [%s], empty member name.", expr)
+			}
+			if name != "*" {
+				seg.names = []string{name}
+			}
+			p.segments = append(p.segments, seg)
+			continue
+		case strings.HasPrefix(s, "["):
+		default:
+			return nil, fmt.Errorf("invalid jsonpath [%s], unexpected [%s].", expr, s)
+		}
+		names, rest, err := parseBracket(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid jsonpath [%s], %s", expr, err.Error())
+		}
+		seg.names = names
+		s = rest
+		p.segments = append(p.segments, seg)
+	}
+	return p, nil
+}
+
+// parseBracket parse the bracket selector at the beginning of s, return the names (nil for *) and the rest.
+func parseBracket(s string) ([]string, string, error) {
+	s = s[1:]
+	names := []string{}
+	for {
+		s = strings.TrimLeft(s, " ")
+		if s == "" {
+			return nil, "", fmt.Errorf("unclosed [.")
+		}
+		var name string
+		switch s[0] {
+		case '*':
+			if len(names) > 0 {
+				return nil, "", fmt.Errorf("* can not be in union.")
+			}
+			s = strings.TrimLeft(s[1:], " ")
+			if !strings.HasPrefix(s, "]") {
+				return nil, "", fmt.Errorf("expect ] after *.")
+			}
+			return nil, s[1:], nil
+		case '\'', '"':
+			end := strings.IndexByte(s[1:], s[0])
+			if end < 0 {
+				return nil, "", fmt.Errorf("unclosed quote.")
+			}
+			name = s[1 : end+1]
+			s = s[end+2:]
+		case '?', '(':
+			return nil, "", fmt.Errorf("filter and script expressions are not supported.")
+		default:
+			end := strings.IndexAny(s, ",]")
+			if end < 0 {
+				return nil, "", fmt.Errorf("unclosed [.")
+			}
+			name = strings.TrimSpace(s[:end])
+			s = s[end:]
+			if name == "" || strings.Contains(name, ":") {
+				return nil, "", fmt.Errorf("invalid selector [%s], only names and indexes are supported.", name)
+			}
+		}
+		names = append(names, name)
+		s = strings.TrimLeft(s, " ")
+		switch {
+		case strings.HasPrefix(s, ","):
+			s = s[1:]
+		case strings.HasPrefix(s, "]"):
+			return names, s[1:], nil
+		default:
+			return nil, "", fmt.Errorf("expect , or ] after [%s].", name)
+		}
+	}
+}
+
+// Match is a selected value and its path relative to the root.
+type Match struct {
+	Path  string
+	Value interface{}
+}
+
+// Find return the values selected from the root in path order.
+func (p *Path) Find(root interface{}) []*Match {
+	matches := []*Match{{Path: "/", Value: root}}
+	for _, seg := range p.segments {
+		next := []*Match{}
+		for _, m := range matches {
+			if seg.recursive {
+				descend(m, func(d *Match) {
+					next = append(next, selectChildren(d, seg.names)...)
+				})
+			} else {
+				next = append(next, selectChildren(m, seg.names)...)
+			}
+		}
+		matches = next
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return lessPath(matches[i].Path, matches[j].Path)
+	})
+	return matches
+}
+
+// lessPath compare the paths by elements, the index elements are compared as numbers, so /10 is after /9.
+func lessPath(a string, b string) bool {
+	as, bs := strings.Split(a, "/"), strings.Split(b, "/")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if as[i] == bs[i] {
+			continue
+		}
+		an, aerr := strconv.Atoi(as[i])
+		bn, berr := strconv.Atoi(bs[i])
+		if aerr == nil && berr == nil {
+			return an < bn
+		}
+		return as[i] < bs[i]
+	}
+	return len(as) < len(bs)
+}
+
+// Values return the values selected from the root in path order.
+func (p *Path) Values(root interface{}) []interface{} {
+	matches := p.Find(root)
+	values := make([]interface{}, 0, len(matches))
+	for _, m := range matches {
+		values = append(values, m.Value)
+	}
+	return values
+}
+
+func childPath(parent string, name string) string {
+	if parent == "/" {
+		return "/" + name
+	}
+	return parent + "/" + name
+}
+
+func selectChildren(m *Match, names []string) []*Match {
+	obj, ok := m.Value.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	result := []*Match{}
+	if names == nil {
+		for k, v := range obj {
+			result = append(result, &Match{Path: childPath(m.Path, k), Value: v})
+		}
+		return result
+	}
+	for _, name := range names {
+		if v, ok := obj[name]; ok {
+			result = append(result, &Match{Path: childPath(m.Path, name), Value: v})
+		}
+	}
+	return result
+}
+
+// descend call f with the match and all its descendants.
+func descend(m *Match, f func(*Match)) {
+	f(m)
+	obj, ok := m.Value.(map[string]interface{})
+	if !ok {
+		return
+	}
+	for k, v := range obj {
+		descend(&Match{Path: childPath(m.Path, k), Value: v}, f)
+	}
+}
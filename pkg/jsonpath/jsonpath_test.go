@@ -0,0 +1,57 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package jsonpath
+
+import (
+	"reflect"
+	"testing"
+
+	. "openpitrix.io/metad/pkg/assert"
+)
+
+func TestFind(t *testing.T) {
+	root := map[string]interface{}{
+		"clusters": map[string]interface{}{
+			"cl-1": map[string]interface{}{"ip": "10.0.0.1", "name": "a", "nodes": map[string]interface{}{"ip": "10.0.1.1"}},
+			"cl-2": map[string]interface{}{"ip": "10.0.0.2", "name": "b"},
+		},
+		"list": map[string]interface{}{"2": "c", "10": "k", "1": "b"},
+	}
+	tests := []struct {
+		expr   string
+		values []interface{}
+	}{
+		{"$", []interface{}{root}},
+		{"$.clusters[*].ip", []interface{}{"10.0.0.1", "10.0.0.2"}},
+		{"$.clusters.*.name", []interface{}{"a", "b"}},
+		{"$['clusters'][\"cl-2\"].ip", []interface{}{"10.0.0.2"}},
+		{"$.clusters['cl-1','cl-2'].name", []interface{}{"a", "b"}},
+		{"$..ip", []interface{}{"10.0.0.1", "10.0.1.1", "10.0.0.2"}},
+		{"$.clusters..nodes[*]", []interface{}{"10.0.1.1"}},
+		{"$.list[*]", []interface{}{"b", "c", "k"}},
+		{"$.list[10]", []interface{}{"k"}},
+		{"$.missing.ip", []interface{}{}},
+		{"$.clusters.cl-1.ip.x", []interface{}{}},
+	}
+	for _, test := range tests {
+		p, err := Compile(test.expr)
+		Assert(t, nil == err, test.expr, err)
+		values := p.Values(root)
+		Assert(t, reflect.DeepEqual(test.values, values), test.expr, values)
+	}
+	matches := mustCompile(t, "$..name").Find(root)
+	Assert(t, 2 == len(matches) && "/clusters/cl-1/name" == matches[0].Path && "/clusters/cl-2/name" == matches[1].Path)
+
+	for _, expr := range []string{"", "clusters", "$.", "$.a[", "$.a['b]", "$[?(@.ip)]", "$.a[1:2]", "$.a[*,b]", "$a"} {
+		_, err := Compile(expr)
+		Assert(t, err != nil, expr)
+	}
+}
+
+func mustCompile(t *testing.T, expr string) *Path {
+	p, err := Compile(expr)
+	Assert(t, nil == err, err)
+	return p
+}
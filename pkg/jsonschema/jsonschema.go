@@ -0,0 +1,551 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+// Package jsonschema validate the metadata values by a subset of JSON Schema.
+// The metadata leaves are strings, so the scalar types are checked by the string value: integer, number and boolean
+// accept the strings parsed as them, and array accept the object with index keys (0, 1, ...) as the metadata store it.
+// The supported keywords are type, enum, const, properties, required, additionalProperties, patternProperties,
+// minProperties, maxProperties, items, minItems, maxItems, minLength, maxLength, pattern, minimum, maximum,
+// exclusiveMinimum, exclusiveMaximum, allOf, anyOf, oneOf and not, the annotation keywords (such as title) are ignored,
+// and $ref is not supported.
+package jsonschema
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// ValidationError is a violation of the schema, the Path is the path of the value relative to the validated root.
+type ValidationError struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+// Schema is a compiled JSON Schema.
+type Schema struct {
+	types                []string
+	enum                 []interface{}
+	hasConst             bool
+	constValue           interface{}
+	properties           map[string]*Schema
+	required             []string
+	additionalProperties *Schema
+	noAdditional         bool
+	patternProperties    map[*regexp.Regexp]*Schema
+	minProperties        *int
+	maxProperties        *int
+	items                *Schema
+	minItems             *int
+	maxItems             *int
+	minLength            *int
+	maxLength            *int
+	pattern              *regexp.Regexp
+	minimum              *float64
+	maximum              *float64
+	exclusiveMinimum     *float64
+	exclusiveMaximum     *float64
+	allOf                []*Schema
+	anyOf                []*Schema
+	oneOf                []*Schema
+	not                  *Schema
+	// never is the false schema.
+	never bool
+}
+
+var validTypes = map[string]bool{"object": true, "array": true, "string": true, "integer": true, "number": true, "boolean": true, "null": true}
+
+// Parse compile the schema of the JSON document.
+func Parse(data []byte) (*Schema, error) {
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid schema json, error: %s", err.Error())
+	}
+	return Compile(doc)
+}
+
+// Compile compile the schema of the decoded JSON document.
+func Compile(doc interface{}) (*Schema, error) {
+	return compile(doc, "#")
+}
+
+func compile(doc interface{}, at string) (*Schema, error) {
+	switch t := doc.(type) {
+	case bool:
+		return &Schema{never: !t}, nil
+	case map[string]interface{}:
+		s := &Schema{}
+		for k, v := range t {
+			if err := s.compileKeyword(k, v, at); err != nil {
+				return nil, err
+			}
+		}
+		return s, nil
+	}
+	return nil, fmt.Errorf("schema %s should be object or boolean.", at)
+}
+
+func (s *Schema) compileKeyword(k string, v interface{}, at string) error {
+	var err error
+	switch k {
+	case "$ref":
+		return fmt.Errorf("schema %s: $ref is not supported.", at)
+	case "type":
+		switch t := v.(type) {
+		case string:
+			s.types = []string{t}
+		case []interface{}:
+			for _, e := range t {
+				name, ok := e.(string)
+				if !ok {
+					return fmt.Errorf("schema %s/type should be string or array of strings.", at)
+				}
+				s.types = append(s.types, name)
+			}
+		default:
+			return fmt.Errorf("schema %s/type should be string or array of strings.", at)
+		}
+		for _, name := range s.types {
+			if !validTypes[name] {
+				return fmt.Errorf("schema %s/type [%s] is unknown.", at, name)
+			}
+		}
+	case "enum":
+		enum, ok := v.([]interface{})
+		if !ok {
+			return fmt.Errorf("schema %s/enum should be array.", at)
+		}
+		s.enum = enum
+	case "const":
+		s.hasConst, s.constValue = true, v
+	case "properties":
+		props, ok := v.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("schema %s/properties should be object.", at)
+		}
+		s.properties = make(map[string]*Schema, len(props))
+		for name, doc := range props {
+			if s.properties[name], err = compile(doc, at+"/properties/"+name); err != nil {
+				return err
+			}
+		}
+	case "patternProperties":
+		props, ok := v.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("schema %s/patternProperties should be object.", at)
+		}
+		s.patternProperties = make(map[*regexp.Regexp]*Schema, len(props))
+		for pattern, doc := range props {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return fmt.Errorf("schema %s/patternProperties [%s] is invalid, error: %s", at, pattern, err.Error())
+			}
+			if s.patternProperties[re], err = compile(doc, at+"/patternProperties/"+pattern); err != nil {
+				return err
+			}
+		}
+	case "required":
+		required, ok := v.([]interface{})
+		if !ok {
+			return fmt.Errorf("schema %s/required should be array of strings.", at)
+		}
+		for _, e := range required {
+			name, ok := e.(string)
+			if !ok {
+				return fmt.Errorf("schema %s/required should be array of strings.", at)
+			}
+			s.required = append(s.required, name)
+		}
+	case "additionalProperties":
+		if b, ok := v.(bool); ok {
+			s.noAdditional = !b
+			return nil
+		}
+		s.additionalProperties, err = compile(v, at+"/additionalProperties")
+	case "items":
+		s.items, err = compile(v, at+"/items")
+	case "allOf", "anyOf", "oneOf":
+		docs, ok := v.([]interface{})
+		if !ok || len(docs) == 0 {
+			return fmt.Errorf("schema %s/%s should be non empty array.", at, k)
+		}
+		schemas := make([]*Schema, 0, len(docs))
+		for i, doc := range docs {
+			sub, err := compile(doc, fmt.Sprintf("%s/%s/%d", at, k, i))
+			if err != nil {
+				return err
+			}
+			schemas = append(schemas, sub)
+		}
+		switch k {
+		case "allOf":
+			s.allOf = schemas
+		case "anyOf":
+			s.anyOf = schemas
+		default:
+			s.oneOf = schemas
+		}
+	case "not":
+		s.not, err = compile(v, at+"/not")
+	case "pattern":
+		p, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("schema %s/pattern should be string.", at)
+		}
+		if s.pattern, err = regexp.Compile(p); err != nil {
+			return fmt.Errorf("schema %s/pattern is invalid, error: %s", at, err.Error())
+		}
+	case "minProperties", "maxProperties", "minItems", "maxItems", "minLength", "maxLength":
+		f, ok := v.(float64)
+		if !ok || f < 0 || f != float64(int(f)) {
+			return fmt.Errorf("schema %s/%s should be non negative integer.", at, k)
+		}
+		n := int(f)
+		switch k {
+		case "minProperties":
+			s.minProperties = &n
+		case "maxProperties":
+			s.maxProperties = &n
+		case "minItems":
+			s.minItems = &n
+		case "maxItems":
+			s.maxItems = &n
+		case "minLength":
+			s.minLength = &n
+		default:
+			s.maxLength = &n
+		}
+	case "minimum", "maximum", "exclusiveMinimum", "exclusiveMaximum":
+		f, ok := v.(float64)
+		if !ok {
+			return fmt.Errorf("schema %s/%s should be number.", at, k)
+		}
+		switch k {
+		case "minimum":
+			s.minimum = &f
+		case "maximum":
+			s.maximum = &f
+		case "exclusiveMinimum":
+			s.exclusiveMinimum = &f
+		default:
+			s.exclusiveMaximum = &f
+		}
+	}
+	return err
+}
+
+// Validate return the violations of the value, sorted by path, empty if the value is valid.
+func (s *Schema) Validate(value interface{}) []*ValidationError {
+	errs := []*ValidationError{}
+	s.validate(value, "/", &errs)
+	sort.SliceStable(errs, func(i, j int) bool {
+		return errs[i].Path < errs[j].Path
+	})
+	return errs
+}
+
+func (s *Schema) valid(value interface{}) bool {
+	errs := []*ValidationError{}
+	s.validate(value, "/", &errs)
+	return len(errs) == 0
+}
+
+func (s *Schema) validate(value interface{}, at string, errs *[]*ValidationError) {
+	fail := func(format string, args ...interface{}) {
+		*errs = append(*errs, &ValidationError{Path: at, Message: fmt.Sprintf(format, args...)})
+	}
+	if s.never {
+		fail("is not allowed.")
+		return
+	}
+	if len(s.types) > 0 && !s.matchType(value) {
+		fail("should be %s.", strings.Join(s.types, " or "))
+		return
+	}
+	if s.enum != nil {
+		matched := false
+		for _, e := range s.enum {
+			if equal(e, value) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			fail("should be one of the enum values.")
+		}
+	}
+	if s.hasConst && !equal(s.constValue, value) {
+		fail("should be the const value.")
+	}
+	switch t := value.(type) {
+	case map[string]interface{}:
+		s.validateObject(t, at, errs, fail)
+	case []interface{}:
+		s.validateItems(t, at, errs, fail)
+	case nil:
+	default:
+		s.validateScalar(t, fail)
+	}
+	for _, sub := range s.allOf {
+		sub.validate(value, at, errs)
+	}
+	if s.anyOf != nil {
+		matched := false
+		for _, sub := range s.anyOf {
+			if sub.valid(value) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			fail("should match any of the schemas.")
+		}
+	}
+	if s.oneOf != nil {
+		matched := 0
+		for _, sub := range s.oneOf {
+			if sub.valid(value) {
+				matched++
+			}
+		}
+		if matched != 1 {
+			fail("should match exactly one of the schemas, matched %d.", matched)
+		}
+	}
+	if s.not != nil && s.not.valid(value) {
+		fail("should not match the schema.")
+	}
+}
+
+func (s *Schema) validateObject(obj map[string]interface{}, at string, errs *[]*ValidationError, fail func(string, ...interface{})) {
+	if s.items != nil || s.minItems != nil || s.maxItems != nil {
+		if items, ok := asArray(obj); ok {
+			s.validateItems(items, at, errs, fail)
+		}
+	}
+	for _, name := range s.required {
+		if _, ok := obj[name]; !ok {
+			fail("required property [%s] is missing.", name)
+		}
+	}
+	if s.minProperties != nil && len(obj) < *s.minProperties {
+		fail("should have at least %d properties.", *s.minProperties)
+	}
+	if s.maxProperties != nil && len(obj) > *s.maxProperties {
+		fail("should have at most %d properties.", *s.maxProperties)
+	}
+	names := make([]string, 0, len(obj))
+	for name := range obj {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		v := obj[name]
+		matched := false
+		if sub, ok := s.properties[name]; ok {
+			matched = true
+			sub.validate(v, path.Join(at, name), errs)
+		}
+		for re, sub := range s.patternProperties {
+			if re.MatchString(name) {
+				matched = true
+				sub.validate(v, path.Join(at, name), errs)
+			}
+		}
+		if matched {
+			continue
+		}
+		if s.noAdditional {
+			*errs = append(*errs, &ValidationError{Path: path.Join(at, name), Message: "additional property is not allowed."})
+		} else if s.additionalProperties != nil {
+			s.additionalProperties.validate(v, path.Join(at, name), errs)
+		}
+	}
+}
+
+func (s *Schema) validateItems(items []interface{}, at string, errs *[]*ValidationError, fail func(string, ...interface{})) {
+	if s.minItems != nil && len(items) < *s.minItems {
+		fail("should have at least %d items.", *s.minItems)
+	}
+	if s.maxItems != nil && len(items) > *s.maxItems {
+		fail("should have at most %d items.", *s.maxItems)
+	}
+	if s.items != nil {
+		for i, item := range items {
+			s.items.validate(item, path.Join(at, strconv.Itoa(i)), errs)
+		}
+	}
+}
+
+func (s *Schema) validateScalar(value interface{}, fail func(string, ...interface{})) {
+	str := scalarString(value)
+	n := utf8.RuneCountInString(str)
+	if s.minLength != nil && n < *s.minLength {
+		fail("should be at least %d characters.", *s.minLength)
+	}
+	if s.maxLength != nil && n > *s.maxLength {
+		fail("should be at most %d characters.", *s.maxLength)
+	}
+	if s.pattern != nil && !s.pattern.MatchString(str) {
+		fail("should match pattern [%s].", s.pattern.String())
+	}
+	if s.minimum == nil && s.maximum == nil && s.exclusiveMinimum == nil && s.exclusiveMaximum == nil {
+		return
+	}
+	f, ok := asNumber(value)
+	if !ok {
+		return
+	}
+	if s.minimum != nil && f < *s.minimum {
+		fail("should be >= %v.", *s.minimum)
+	}
+	if s.maximum != nil && f > *s.maximum {
+		fail("should be <= %v.", *s.maximum)
+	}
+	if s.exclusiveMinimum != nil && f <= *s.exclusiveMinimum {
+		fail("should be > %v.", *s.exclusiveMinimum)
+	}
+	if s.exclusiveMaximum != nil && f >= *s.exclusiveMaximum {
+		fail("should be < %v.", *s.exclusiveMaximum)
+	}
+}
+
+func (s *Schema) matchType(value interface{}) bool {
+	for _, name := range s.types {
+		if matchType(name, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchType(name string, value interface{}) bool {
+	switch name {
+	case "null":
+		return value == nil
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "array":
+		switch t := value.(type) {
+		case []interface{}:
+			return true
+		case map[string]interface{}:
+			_, ok := asArray(t)
+			return ok
+		}
+		return false
+	case "boolean":
+		switch t := value.(type) {
+		case bool:
+			return true
+		case string:
+			return t == "true" || t == "false"
+		}
+		return false
+	case "integer":
+		f, ok := asNumber(value)
+		return ok && f == float64(int64(f))
+	case "number":
+		_, ok := asNumber(value)
+		return ok
+	case "string":
+		switch value.(type) {
+		case map[string]interface{}, []interface{}, nil:
+			return false
+		}
+		return true
+	}
+	return false
+}
+
+// asArray return the items of the object with index keys, as the metadata store the arrays.
+func asArray(obj map[string]interface{}) ([]interface{}, bool) {
+	items := make([]interface{}, len(obj))
+	for k, v := range obj {
+		i, err := strconv.Atoi(k)
+		if err != nil || i < 0 || i >= len(obj) || strconv.Itoa(i) != k {
+			return nil, false
+		}
+		items[i] = v
+	}
+	return items, true
+}
+
+func asNumber(value interface{}) (float64, bool) {
+	switch t := value.(type) {
+	case float64:
+		return t, true
+	case json.Number:
+		f, err := t.Float64()
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(t), 64)
+		return f, err == nil && t != ""
+	}
+	return 0, false
+}
+
+func scalarString(value interface{}) string {
+	if str, ok := value.(string); ok {
+		return str
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+// equal compare the values as the metadata store them, the scalars are compared by the string value.
+func equal(a interface{}, b interface{}) bool {
+	switch x := a.(type) {
+	case map[string]interface{}:
+		y, ok := b.(map[string]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for k, v := range x {
+			if !equal(v, y[k]) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		var y []interface{}
+		switch t := b.(type) {
+		case []interface{}:
+			y = t
+		case map[string]interface{}:
+			items, ok := asArray(t)
+			if !ok {
+				return false
+			}
+			y = items
+		default:
+			return false
+		}
+		if len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !equal(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	case nil:
+		return b == nil
+	}
+	switch b.(type) {
+	case map[string]interface{}, []interface{}, nil:
+		return false
+	}
+	return scalarString(a) == scalarString(b)
+}
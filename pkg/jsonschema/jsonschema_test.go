@@ -0,0 +1,107 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package jsonschema
+
+import (
+	"testing"
+
+	. "openpitrix.io/metad/pkg/assert"
+)
+
+func TestParseInvalid(t *testing.T) {
+	for _, doc := range []string{
+		`"object"`,
+		`{"type": "map"}`,
+		`{"type": 1}`,
+		`{"required": "name"}`,
+		`{"minLength": -1}`,
+		`{"pattern": "["}`,
+		`{"properties": {"a": 1}}`,
+		`{"anyOf": []}`,
+		`{"$ref": "#/definitions/a"}`,
+		`{`,
+	} {
+		_, err := Parse([]byte(doc))
+		Assert(t, err != nil, doc)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	schema, err := Parse([]byte(`{
+		"title": "cluster",
+		"type": "object",
+		"required": ["name", "port"],
+		"properties": {
+			"name": {"type": "string", "minLength": 2, "pattern": "^cl-"},
+			"port": {"type": "integer", "minimum": 1, "maximum": 65535},
+			"enabled": {"type": "boolean"},
+			"mode": {"enum": ["a", "b"]},
+			"tags": {"type": "array", "items": {"type": "string"}, "maxItems": 2}
+		},
+		"patternProperties": {"^x-": {"type": "number"}},
+		"additionalProperties": false
+	}`))
+	Assert(t, err == nil, err)
+
+	errs := schema.Validate(map[string]interface{}{
+		"name":    "cl-1",
+		"port":    "8080",
+		"enabled": "true",
+		"mode":    "a",
+		"tags":    map[string]interface{}{"0": "a", "1": "b"},
+		"x-rate":  "0.5",
+	})
+	Assert(t, len(errs) == 0, errs)
+
+	errs = schema.Validate(map[string]interface{}{
+		"name":    "c",
+		"port":    "http",
+		"enabled": "yes",
+		"mode":    "c",
+		"tags":    map[string]interface{}{"0": "a", "1": "b", "2": "c"},
+		"other":   "1",
+	})
+	paths := []string{}
+	for _, e := range errs {
+		paths = append(paths, e.Path)
+	}
+	Assert(t, len(errs) == 7, errs)
+	Assert(t, "/enabled" == paths[0] && "/mode" == paths[1] && "/name" == paths[2] && "/name" == paths[3], paths)
+	Assert(t, "/other" == paths[4] && "/port" == paths[5] && "/tags" == paths[6], paths)
+
+	errs = schema.Validate(map[string]interface{}{"name": "cl-1"})
+	Assert(t, len(errs) == 1 && errs[0].Path == "/" && errs[0].Message == "required property [port] is missing.", errs)
+
+	errs = schema.Validate("cl-1")
+	Assert(t, len(errs) == 1 && errs[0].Message == "should be object.", errs)
+}
+
+func TestValidateCombinators(t *testing.T) {
+	schema, err := Parse([]byte(`{
+		"oneOf": [{"type": "integer"}, {"type": "string", "maxLength": 1}],
+		"not": {"const": "0"}
+	}`))
+	Assert(t, err == nil, err)
+	Assert(t, len(schema.Validate("12")) == 0)
+	Assert(t, len(schema.Validate("ab")) == 1)
+	// "1" is both an integer and a string of one character.
+	Assert(t, len(schema.Validate("1")) == 1)
+	Assert(t, len(schema.Validate("0")) == 2)
+
+	schema, err = Parse([]byte(`{"anyOf": [{"type": "null"}, {"type": "object", "minProperties": 1}], "allOf": [true]}`))
+	Assert(t, err == nil, err)
+	Assert(t, len(schema.Validate(nil)) == 0)
+	Assert(t, len(schema.Validate(map[string]interface{}{})) == 1)
+
+	schema, err = Parse([]byte(`{"additionalProperties": {"type": "integer", "exclusiveMaximum": 10}}`))
+	Assert(t, err == nil, err)
+	Assert(t, len(schema.Validate(map[string]interface{}{"a": "9", "b": float64(1)})) == 0)
+	errs := schema.Validate(map[string]interface{}{"a": "10", "b": "1.5"})
+	Assert(t, len(errs) == 2 && errs[0].Path == "/a" && errs[1].Path == "/b", errs)
+
+	schema, err = Parse([]byte(`false`))
+	Assert(t, err == nil, err)
+	Assert(t, len(schema.Validate("a")) == 1)
+}
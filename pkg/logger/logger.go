@@ -7,10 +7,13 @@
 package logger
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"runtime"
+	"sort"
 	"strings"
 	"sync/atomic"
 	"time"
@@ -59,6 +62,14 @@ func StringToLevel(level string) Level {
 	return InfoLevel
 }
 
+const (
+	TextFormat = "text"
+	JSONFormat = "json"
+)
+
+// Fields are the structured key/values attached to a log line.
+type Fields map[string]interface{}
+
 var logger = NewLogger()
 
 func Info(format string, v ...interface{}) {
@@ -90,6 +101,15 @@ func SetOutput(output io.Writer) {
 	logger.SetOutput(output)
 }
 
+// SetFormat set the output format, text or json.
+func SetFormat(format string) {
+	logger.SetFormat(format)
+}
+
+func WithFields(fields Fields) *Entry {
+	return logger.WithFields(fields)
+}
+
 var globalLogLevel = InfoLevel
 
 func SetLevelByString(level string) {
@@ -112,6 +132,7 @@ type Logger struct {
 	prefix        string
 	output        io.Writer
 	hideCallstack bool
+	jsonFormat    uint32
 }
 
 func (logger *Logger) level() Level {
@@ -126,35 +147,83 @@ func (logger *Logger) SetLevelByString(level string) {
 	logger.SetLevel(StringToLevel(level))
 }
 
-func (logger *Logger) formatOutput(level Level, output string) string {
-	now := time.Now().Format("2006-01-02 15:04:05.99999")
+func (logger *Logger) caller(skip int) string {
+	_, file, line, ok := runtime.Caller(skip + 1)
+	if !ok {
+		file = "???"
+		line = 0
+	}
+	// short file name
+	for i := len(file) - 1; i > 0; i-- {
+		if file[i] == '/' {
+			file = file[i+1:]
+			break
+		}
+	}
+	return fmt.Sprintf("%s:%d", file, line)
+}
+
+// formatOutput format the log line, skip is the stack frames to skip for finding the caller.
+func (logger *Logger) formatOutput(level Level, output string, fields Fields, skip int) string {
+	now := time.Now()
+	if atomic.LoadUint32(&logger.jsonFormat) == 1 {
+		return logger.formatJSON(now, level, output, fields, skip+1)
+	}
+	output = output + formatFields(fields)
+	timeStr := now.Format("2006-01-02 15:04:05.99999")
 	if logger.hideCallstack {
 		return fmt.Sprintf("%-25s -%s- %s%s%s",
-			now, strings.ToUpper(level.String()), logger.prefix, output, logger.suffix)
+			timeStr, strings.ToUpper(level.String()), logger.prefix, output, logger.suffix)
 	} else {
-		_, file, line, ok := runtime.Caller(4)
-		if !ok {
-			file = "???"
-			line = 0
-		}
-		// short file name
-		for i := len(file) - 1; i > 0; i-- {
-			if file[i] == '/' {
-				file = file[i+1:]
-				break
-			}
-		}
 		// 2018-03-27 02:08:44.93894 -INFO- Api service start http://openpitrix-api-gateway:9100 (main.go:44)
-		return fmt.Sprintf("%-25s -%s- %s%s (%s:%d)%s",
-			now, strings.ToUpper(level.String()), logger.prefix, output, file, line, logger.suffix)
+		return fmt.Sprintf("%-25s -%s- %s%s (%s)%s",
+			timeStr, strings.ToUpper(level.String()), logger.prefix, output, logger.caller(skip+1), logger.suffix)
 	}
 }
 
+func (logger *Logger) formatJSON(now time.Time, level Level, output string, fields Fields, skip int) string {
+	obj := make(map[string]interface{}, len(fields)+4)
+	for k, v := range fields {
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		obj[k] = v
+	}
+	obj["time"] = now.Format(time.RFC3339Nano)
+	obj["level"] = level.String()
+	obj["msg"] = logger.prefix + output + logger.suffix
+	if !logger.hideCallstack {
+		obj["caller"] = logger.caller(skip + 1)
+	}
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return fmt.Sprintf(`{"level":"error","msg":"marshal log error: %s"}`, err.Error())
+	}
+	return string(b)
+}
+
+// formatFields format fields as " key=value" pairs ordered by key.
+func formatFields(fields Fields) string {
+	if len(fields) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var buffer bytes.Buffer
+	for _, k := range keys {
+		buffer.WriteString(fmt.Sprintf(" %s=%v", k, fields[k]))
+	}
+	return buffer.String()
+}
+
 func (logger *Logger) logf(level Level, format string, args ...interface{}) {
 	if logger.level() < level {
 		return
 	}
-	fmt.Fprintln(logger.output, logger.formatOutput(level, fmt.Sprintf(format, args...)))
+	fmt.Fprintln(logger.output, logger.formatOutput(level, fmt.Sprintf(format, args...), nil, 3))
 }
 
 func (logger *Logger) Debug(format string, args ...interface{}) {
@@ -198,3 +267,58 @@ func (logger *Logger) HideCallstack() *Logger {
 	logger.hideCallstack = true
 	return logger
 }
+
+// SetFormat set the output format, it is safe to call while logging, for the config reload.
+func (logger *Logger) SetFormat(format string) *Logger {
+	var jsonFormat uint32
+	if strings.ToLower(format) == JSONFormat {
+		jsonFormat = 1
+	}
+	atomic.StoreUint32(&logger.jsonFormat, jsonFormat)
+	return logger
+}
+
+func (logger *Logger) WithFields(fields Fields) *Entry {
+	return &Entry{logger: logger, fields: fields}
+}
+
+// Entry is a logger with fields, which are attached to every log line.
+type Entry struct {
+	logger *Logger
+	fields Fields
+}
+
+// WithFields return a new entry with the fields merged.
+func (entry *Entry) WithFields(fields Fields) *Entry {
+	merged := make(Fields, len(entry.fields)+len(fields))
+	for k, v := range entry.fields {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return &Entry{logger: entry.logger, fields: merged}
+}
+
+func (entry *Entry) logf(level Level, format string, args ...interface{}) {
+	if entry.logger.level() < level {
+		return
+	}
+	fmt.Fprintln(entry.logger.output, entry.logger.formatOutput(level, fmt.Sprintf(format, args...), entry.fields, 2))
+}
+
+func (entry *Entry) Debug(format string, args ...interface{}) {
+	entry.logf(DebugLevel, format, args...)
+}
+
+func (entry *Entry) Info(format string, args ...interface{}) {
+	entry.logf(InfoLevel, format, args...)
+}
+
+func (entry *Entry) Warn(format string, args ...interface{}) {
+	entry.logf(WarnLevel, format, args...)
+}
+
+func (entry *Entry) Error(format string, args ...interface{}) {
+	entry.logf(ErrorLevel, format, args...)
+}
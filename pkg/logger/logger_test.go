@@ -6,7 +6,9 @@ package logger
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"regexp"
 	"strings"
 	"testing"
@@ -126,3 +128,55 @@ func TestLogger(t *testing.T) {
 	})
 	t.Log(log)
 }
+
+func TestLoggerFields(t *testing.T) {
+	buf := new(bytes.Buffer)
+	l := NewLogger().SetOutput(buf)
+	l.SetLevel(InfoLevel)
+
+	l.WithFields(Fields{"request_id": "REQ-1", "status": 200}).Info("access")
+	log := tReadBuf(buf)
+	tAssert(t, strings.Contains(log, "-INFO- access request_id=REQ-1 status=200 (logger_test.go:"), log)
+
+	l.SetFormat(JSONFormat)
+	l.WithFields(Fields{"request_id": "REQ-2"}).WithFields(Fields{"status": 404}).Warn("not %s", "found")
+	obj := make(map[string]interface{})
+	tAssertFunc(t, func() error {
+		return json.Unmarshal(buf.Bytes(), &obj)
+	})
+	tAssert(t, obj["msg"] == "not found", obj)
+	tAssert(t, obj["level"] == "warning", obj)
+	tAssert(t, obj["request_id"] == "REQ-2", obj)
+	tAssert(t, obj["status"] == float64(404), obj)
+	tAssert(t, strings.HasPrefix(obj["caller"].(string), "logger_test.go:"), obj)
+}
+
+func TestLoggerSetFormatConcurrent(t *testing.T) {
+	l := NewLogger().SetOutput(ioutil.Discard)
+	l.SetLevel(InfoLevel)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			l.Info("log %d", i)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		if i%2 == 0 {
+			l.SetFormat(JSONFormat)
+		} else {
+			l.SetFormat(TextFormat)
+		}
+	}
+	<-done
+
+	buf := new(bytes.Buffer)
+	l.SetOutput(buf)
+	l.SetFormat(JSONFormat)
+	l.Info("json")
+	tAssert(t, strings.HasPrefix(tReadBuf(buf), "{"))
+	l.SetFormat(TextFormat)
+	l.Info("text")
+	tAssert(t, !strings.HasPrefix(tReadBuf(buf), "{"))
+}
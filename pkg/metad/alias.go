@@ -0,0 +1,63 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metad
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+
+	"github.com/gorilla/mux"
+
+	"openpitrix.io/metad/pkg/metadata"
+)
+
+func (m *Metad) aliasList(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	return m.metadataRepo.GetAliases(), nil
+}
+
+func (m *Metad) aliasGet(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	alias := m.metadataRepo.GetAlias(mux.Vars(req)["nodePath"])
+	if alias == nil {
+		return nil, NewHttpError(http.StatusNotFound, "Not found")
+	}
+	return alias, nil
+}
+
+// aliasUpdate create or replace the alias of the path, the request is {"target": "/clusters/cl-1"}.
+func (m *Metad) aliasUpdate(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	decoder := json.NewDecoder(req.Body)
+	var alias metadata.Alias
+	err := decoder.Decode(&alias)
+	if err != nil {
+		return nil, NewHttpError(http.StatusBadRequest, fmt.Sprintf("invalid json format, error:%s", err.Error()))
+	}
+	alias.Path = path.Join("/", mux.Vars(req)["nodePath"])
+	// the alias exposes the target, so the target should be writable too.
+	if httpErr := m.authorizeWrite(ctx, req, "alias", alias.Path, path.Join("/", alias.Target)); httpErr != nil {
+		return nil, httpErr
+	}
+	if err := m.metadataRepo.PutAlias(&alias); err != nil {
+		return nil, writeError(err, http.StatusBadRequest)
+	}
+	requestLogger(ctx).Info("Alias [%s] to [%s]", alias.Path, alias.Target)
+	return &alias, nil
+}
+
+func (m *Metad) aliasDelete(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	nodePath := path.Join("/", mux.Vars(req)["nodePath"])
+	if m.metadataRepo.GetAlias(nodePath) == nil {
+		return nil, NewHttpError(http.StatusNotFound, "Not found")
+	}
+	if httpErr := m.authorizeWrite(ctx, req, "alias", nodePath); httpErr != nil {
+		return nil, httpErr
+	}
+	if err := m.metadataRepo.DeleteAlias(nodePath); err != nil {
+		return nil, NewServerError(err)
+	}
+	return nil, nil
+}
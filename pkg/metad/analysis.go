@@ -0,0 +1,14 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metad
+
+import (
+	"context"
+	"net/http"
+)
+
+func (m *Metad) unreferencedDataGet(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	return m.metadataRepo.GetUnreferencedData(), nil
+}
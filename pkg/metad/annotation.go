@@ -0,0 +1,77 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metad
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"openpitrix.io/metad/pkg/metadata"
+)
+
+func (m *Metad) annotationGet(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	vars := mux.Vars(req)
+	nodePath := vars["nodePath"]
+	if nodePath == "" {
+		nodePath = "/"
+	}
+	// recursive or filter show all annotations under the nodePath.
+	if strings.ToLower(req.FormValue("recursive")) == "true" || req.FormValue("owner") != "" || req.FormValue("tag") != "" || req.FormValue("expired") != "" {
+		filter := metadata.AnnotationFilter{
+			Owner:   req.FormValue("owner"),
+			Tag:     req.FormValue("tag"),
+			Expired: strings.ToLower(req.FormValue("expired")) == "true",
+		}
+		return m.metadataRepo.GetAnnotations(nodePath, filter), nil
+	}
+	annotation := m.metadataRepo.GetAnnotation(nodePath)
+	if annotation == nil {
+		return nil, NewHttpError(http.StatusNotFound, "Not found")
+	}
+	return annotation, nil
+}
+
+func (m *Metad) annotationUpdate(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	vars := mux.Vars(req)
+	nodePath := vars["nodePath"]
+	if nodePath == "" {
+		nodePath = "/"
+	}
+	if httpErr := m.authorizeWrite(ctx, req, "annotate", nodePath); httpErr != nil {
+		return nil, httpErr
+	}
+	decoder := json.NewDecoder(req.Body)
+	var annotation metadata.Annotation
+	err := decoder.Decode(&annotation)
+	if err != nil {
+		return nil, NewHttpError(http.StatusBadRequest, fmt.Sprintf("invalid json format, error:%s", err.Error()))
+	}
+	err = m.metadataRepo.PutAnnotation(nodePath, &annotation)
+	if err != nil {
+		return nil, NewHttpError(http.StatusBadRequest, err.Error())
+	}
+	return nil, nil
+}
+
+func (m *Metad) annotationDelete(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	vars := mux.Vars(req)
+	nodePath := vars["nodePath"]
+	if nodePath == "" {
+		nodePath = "/"
+	}
+	if httpErr := m.authorizeWrite(ctx, req, "annotate", nodePath); httpErr != nil {
+		return nil, httpErr
+	}
+	err := m.metadataRepo.DeleteAnnotation(nodePath)
+	if err != nil {
+		return nil, NewServerError(err)
+	}
+	return nil, nil
+}
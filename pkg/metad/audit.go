@@ -0,0 +1,155 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metad
+
+import (
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"openpitrix.io/metad/pkg/audit"
+	"openpitrix.io/metad/pkg/backends"
+	"openpitrix.io/metad/pkg/logger"
+)
+
+// newAuditor return the auditor of audit entries, and the SIEM stream auditor for access entries if audit_siem_access is enabled.
+func newAuditor(config *Config, storeClient backends.StoreClient) (audit.Auditor, audit.Auditor, error) {
+	var auditors []audit.Auditor
+	if config.AuditFile != "" {
+		fileAuditor, err := audit.NewFileAuditor(config.AuditFile, int64(config.AuditMaxSize)*1024*1024, config.AuditMaxBackups)
+		if err != nil {
+			return nil, nil, err
+		}
+		auditors = append(auditors, fileAuditor)
+	}
+	if config.AuditBackend {
+		auditors = append(auditors, audit.NewRecordAuditor(storeClient))
+	}
+	var siemAuditor audit.Auditor
+	if config.AuditSIEM != "" {
+		streamAuditor, err := audit.NewStreamAuditor(config.AuditSIEM, config.AuditSIEMBuffer)
+		if err != nil {
+			for _, a := range auditors {
+				a.Close()
+			}
+			return nil, nil, err
+		}
+		auditors = append(auditors, streamAuditor)
+		if config.AuditSIEMAccess {
+			siemAuditor = streamAuditor
+		}
+	}
+	switch len(auditors) {
+	case 0:
+		return nil, nil, nil
+	case 1:
+		return auditors[0], siemAuditor, nil
+	default:
+		return audit.NewMultiAuditor(auditors...), siemAuditor, nil
+	}
+}
+
+// requestIdentity return the authenticated identity of the requester and the token id if authenticated by token.
+func (m *Metad) requestIdentity(req *http.Request) (identity string, tokenID string) {
+	if secret := bearerToken(req); secret != "" {
+		if token := m.metadataRepo.GetToken(secret); token != nil {
+			identity = token.Host
+			if identity == "" {
+				identity = token.Team
+			}
+			return identity, token.ID
+		}
+	}
+	if identity := certIdentity(req); identity != "" {
+		return identity, ""
+	}
+	return peerIdentity(req), ""
+}
+
+func (m *Metad) newAuditEntry(requestID string, api string, req *http.Request, version int64, status int) *audit.Entry {
+	identity, tokenID := m.requestIdentity(req)
+	return &audit.Entry{
+		RequestID: requestID,
+		Identity:  identity,
+		TokenID:   tokenID,
+		ClientIP:  m.requestIP(req),
+		API:       api,
+		Action:    req.Method,
+		Path:      req.URL.Path,
+		Revision:  version,
+		Status:    status,
+	}
+}
+
+// auditManage record the mutation request of manage api.
+func (m *Metad) auditManage(requestID string, req *http.Request, version int64, status int) {
+	if m.auditor == nil || req.Method == "GET" || req.Method == "HEAD" {
+		return
+	}
+	m.auditor.Audit(m.newAuditEntry(requestID, "manage", req, version, status))
+}
+
+// auditRead record the read request of metadata api, if the request read any secret path.
+func (m *Metad) auditRead(requestID string, req *http.Request, version int64, status int) {
+	if m.auditor == nil || len(m.getConfig().AuditSecretPaths) == 0 {
+		return
+	}
+	for _, p := range m.readPaths(req) {
+		if m.isSecretPath(p) {
+			entry := m.newAuditEntry(requestID, "data", req, version, status)
+			entry.Action = "read"
+			m.auditor.Audit(entry)
+			return
+		}
+	}
+}
+
+// readPaths return the data paths read by the metadata api request, the mapped paths for self request.
+func (m *Metad) readPaths(req *http.Request) []string {
+	nodePath := path.Join("/", mux.Vars(req)["nodePath"])
+	if req.URL.Path == "/self" || strings.HasPrefix(req.URL.Path, "/self/") {
+		host, repo, httpErr := m.clientRepo(req)
+		if httpErr != nil {
+			return nil
+		}
+		return repo.SelfPaths(host, nodePath)
+	}
+	return []string{nodePath}
+}
+
+// auditAccess stream the access entry of metadata api request to the SIEM endpoint.
+func (m *Metad) auditAccess(requestID string, req *http.Request, version int64, status int, elapsed time.Duration, len int) {
+	if m.siemAuditor == nil {
+		return
+	}
+	entry := m.newAuditEntry(requestID, "data", req, version, status)
+	entry.Action = "access"
+	entry.Elapsed = float64(elapsed.Nanoseconds()) / 1e6
+	entry.Bytes = len
+	m.siemAuditor.Audit(entry)
+}
+
+// isSecretPath check whether the path is a secret path, or the parent of a secret path.
+func (m *Metad) isSecretPath(nodePath string) bool {
+	for _, secretPath := range m.getConfig().AuditSecretPaths {
+		secretPath = path.Join("/", secretPath)
+		if nodePath == "/" || nodePath == secretPath || strings.HasPrefix(nodePath, secretPath+"/") || strings.HasPrefix(secretPath, nodePath+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Metad) closeAuditor() {
+	if m.auditor == nil {
+		return
+	}
+	if err := m.auditor.Close(); err != nil {
+		logger.Error("Close audit log error: %s", err.Error())
+	}
+}
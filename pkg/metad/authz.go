@@ -0,0 +1,164 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metad
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// the api of the authorization input.
+const (
+	AuthzAPIMetadata = "metadata"
+	AuthzAPIManage   = "manage"
+)
+
+// maxAuthzCacheSize bound the cached decisions, the cache is reset if full of unexpired decisions.
+const maxAuthzCacheSize = 10000
+
+var authzClient = &http.Client{Timeout: 2 * time.Second}
+
+var errAuthzUnavailable = NewHttpError(http.StatusServiceUnavailable, "authorization decision unavailable.")
+
+// AuthzInput is the input of the authorization decision, posted to authz_url as {"input": $input}.
+type AuthzInput struct {
+	API      string `json:"api"`
+	Method   string `json:"method"`
+	Path     string `json:"path"`
+	Query    string `json:"query"`
+	ClientIP string `json:"client_ip"`
+	// Identity is the token's host or team, or the client certificate identity, empty if anonymous.
+	Identity string `json:"identity"`
+	TokenID  string `json:"token_id,omitempty"`
+}
+
+type authzDecision struct {
+	allow    bool
+	reason   string
+	expireAt time.Time
+}
+
+// authzCache keep the decisions of the external decision point for authz_cache_ttl.
+type authzCache struct {
+	decisions map[string]*authzDecision
+	lock      sync.Mutex
+}
+
+func newAuthzCache() *authzCache {
+	return &authzCache{decisions: map[string]*authzDecision{}}
+}
+
+func (c *authzCache) get(key string, now time.Time) *authzDecision {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	d, ok := c.decisions[key]
+	if !ok {
+		return nil
+	}
+	if now.After(d.expireAt) {
+		delete(c.decisions, key)
+		return nil
+	}
+	return d
+}
+
+func (c *authzCache) put(key string, d *authzDecision, now time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if len(c.decisions) >= maxAuthzCacheSize {
+		for k, old := range c.decisions {
+			if now.After(old.expireAt) {
+				delete(c.decisions, k)
+			}
+		}
+		if len(c.decisions) >= maxAuthzCacheSize {
+			c.decisions = map[string]*authzDecision{}
+		}
+	}
+	c.decisions[key] = d
+}
+
+// authorizeRequest ask the external decision point (such as OPA) at authz_url whether the request is allowed,
+// return 403 if denied. If the decision point is unavailable, the request is allowed if authz_fail_open,
+// otherwise respond 503.
+func (m *Metad) authorizeRequest(ctx context.Context, req *http.Request, api string) *HttpError {
+	config := m.getConfig()
+	if config.AuthzURL == "" {
+		return nil
+	}
+	identity, tokenID := m.requestIdentity(req)
+	input := &AuthzInput{API: api, Method: req.Method, Path: req.URL.Path, Query: req.URL.RawQuery,
+		ClientIP: m.requestIP(req), Identity: identity, TokenID: tokenID}
+	b, _ := json.Marshal(input)
+	key := config.AuthzURL + "\n" + string(b)
+	now := time.Now()
+	d := m.authz.get(key, now)
+	if d == nil {
+		var err error
+		d, err = queryAuthz(ctx, config.AuthzURL, b)
+		if err != nil {
+			requestLogger(ctx).Warn("Query authorization decision of %s %s error: %s", req.Method, req.URL.Path, err.Error())
+			if config.AuthzFailOpen {
+				return nil
+			}
+			return errAuthzUnavailable
+		}
+		if config.AuthzCacheTTL > 0 {
+			d.expireAt = now.Add(time.Duration(config.AuthzCacheTTL) * time.Second)
+			m.authz.put(key, d, now)
+		}
+	}
+	if d.allow {
+		return nil
+	}
+	if d.reason != "" {
+		return NewHttpError(http.StatusForbidden, fmt.Sprintf("Forbidden by authorization policy: %s", d.reason))
+	}
+	return NewHttpError(http.StatusForbidden, "Forbidden by authorization policy")
+}
+
+// queryAuthz post the input to the decision point, the result should be a boolean or {"allow": bool, "reason": string},
+// an undefined result is denied.
+func queryAuthz(ctx context.Context, url string, input []byte) (*authzDecision, error) {
+	body := bytes.NewBufferString(`{"input":`)
+	body.Write(input)
+	body.WriteString("}")
+	req, err := http.NewRequestWithContext(ctx, "POST", url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := authzClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	var decision struct {
+		Result interface{} `json:"result"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1024*1024)).Decode(&decision); err != nil {
+		return nil, fmt.Errorf("invalid json format, error:%s", err.Error())
+	}
+	switch t := decision.Result.(type) {
+	case nil:
+		return &authzDecision{reason: "undefined decision"}, nil
+	case bool:
+		return &authzDecision{allow: t}, nil
+	case map[string]interface{}:
+		allow, _ := t["allow"].(bool)
+		reason, _ := t["reason"].(string)
+		return &authzDecision{allow: allow, reason: reason}, nil
+	}
+	return nil, fmt.Errorf("unexpected decision result %v", decision.Result)
+}
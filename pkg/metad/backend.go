@@ -0,0 +1,59 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metad
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+
+	"openpitrix.io/metad/pkg/logger"
+)
+
+// backendEndpointsPath is the manage api replacing the backend endpoints of this metad, it is not frozen and is
+// accepted by the standby, as the endpoints are not shared by the group.
+const backendEndpointsPath = "/v1/backend/endpoints"
+
+func (m *Metad) backendGet(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	return map[string]interface{}{
+		"backend":   m.getConfig().Backend,
+		"endpoints": m.metadataRepo.BackendEndpoints(),
+	}, nil
+}
+
+// backendEndpointsUpdate replace the backend endpoints without restart, the request is the list of the endpoints,
+// the reload of the config file set the endpoints to its nodes again.
+func (m *Metad) backendEndpointsUpdate(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	var endpoints []string
+	if err := json.NewDecoder(req.Body).Decode(&endpoints); err != nil {
+		return nil, NewHttpError(http.StatusBadRequest, fmt.Sprintf("invalid json format, error:%s", err.Error()))
+	}
+	m.reloadLock.Lock()
+	defer m.reloadLock.Unlock()
+	if err := m.metadataRepo.SetBackendEndpoints(endpoints); err != nil {
+		return nil, NewHttpError(http.StatusBadRequest, err.Error())
+	}
+	m.configLock.Lock()
+	config := *m.config
+	config.BackendNodes = endpoints
+	m.config = &config
+	m.configLock.Unlock()
+	requestLogger(ctx).Info("Set backend endpoints to %v", endpoints)
+	return m.metadataRepo.BackendEndpoints(), nil
+}
+
+// applyBackendEndpoints set the backend endpoints to the reloaded nodes if changed.
+func (m *Metad) applyBackendEndpoints(old *Config, config *Config) error {
+	if reflect.DeepEqual(old.BackendNodes, config.BackendNodes) {
+		return nil
+	}
+	if err := m.metadataRepo.SetBackendEndpoints(config.BackendNodes); err != nil {
+		return err
+	}
+	logger.Info("Backend endpoints reloaded: %v", config.BackendNodes)
+	return nil
+}
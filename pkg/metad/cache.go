@@ -0,0 +1,171 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metad
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strings"
+	"sync"
+
+	"openpitrix.io/metad/pkg/metadata"
+)
+
+// cachedResponse is a serialized response of metadata api.
+type cachedResponse struct {
+	key         string
+	etag        string
+	version     int64
+	contentType string
+	body        []byte
+}
+
+// responseCache keep the latest serialized response of every (client, url, format), evict the least recently used if full.
+type responseCache struct {
+	size    int
+	entries map[string]*list.Element
+	lru     *list.List
+	lock    sync.Mutex
+}
+
+// newResponseCache return nil if size <= 0, which disable the cache.
+func newResponseCache(size int) *responseCache {
+	if size <= 0 {
+		return nil
+	}
+	return &responseCache{size: size, entries: make(map[string]*list.Element), lru: list.New()}
+}
+
+// get return the response of the key, nil if not cached or the cached one is stale.
+func (c *responseCache) get(key string, etag string) *cachedResponse {
+	if c == nil {
+		return nil
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	resp := e.Value.(*cachedResponse)
+	if resp.etag != etag {
+		return nil
+	}
+	c.lru.MoveToFront(e)
+	return resp
+}
+
+func (c *responseCache) put(resp *cachedResponse) {
+	if c == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if e, ok := c.entries[resp.key]; ok {
+		e.Value = resp
+		c.lru.MoveToFront(e)
+		return
+	}
+	c.entries[resp.key] = c.lru.PushFront(resp)
+	for c.lru.Len() > c.size {
+		e := c.lru.Back()
+		c.lru.Remove(e)
+		delete(c.entries, e.Value.(*cachedResponse).key)
+	}
+}
+
+// clear drop all the cached responses.
+func (c *responseCache) clear() {
+	if c == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.entries = make(map[string]*list.Element)
+	c.lru.Init()
+}
+
+// cacheWriter capture the response body and status.
+type cacheWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *cacheWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *cacheWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+// responseCacheKey return the cache key, etag and data version of the metadata api read request,
+// the key is empty if the request is not cacheable, such as long-poll, history read and tiered read of the stale local.
+func (m *Metad) responseCacheKey(req *http.Request) (string, string, int64) {
+	if req.Method != "GET" && req.Method != "HEAD" {
+		return "", "", 0
+	}
+	// the continue token of the paginated response is a header, not cached.
+	if strings.ToLower(req.FormValue("wait")) == "true" || req.FormValue("at_revision") != "" || req.FormValue("limit") != "" {
+		return "", "", 0
+	}
+	if m.tieredStale() {
+		return "", "", 0
+	}
+	host, httpErr := m.requestHost(req)
+	if httpErr != nil {
+		return "", "", 0
+	}
+	version, revision := m.metadataRepo.ReadRevision()
+	// the mapping placeholders are resolved by the request, the same host may get different result.
+	// the accept header is in the key as the typed leaf is negotiated by it.
+	vars := m.mappingVars(req)
+	key := fmt.Sprintf("%s\x00%s\x00%s\x00%s\x00%d\x00%s\x00%s?%s", host, vars[metadata.MappingVarIP], vars[metadata.MappingVarHostname],
+		vars[metadata.MappingVarTokenSub], contentType(req), req.Header.Get("Accept"), req.URL.Path, req.URL.RawQuery)
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	h.Write([]byte{0})
+	h.Write([]byte(revision))
+	return key, fmt.Sprintf(`"%x"`, h.Sum64()), version
+}
+
+// matchETag check whether the If-None-Match header match the etag.
+func matchETag(ifNoneMatch string, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// respondCached respond the result, and cache the serialized response if succeeded.
+func (m *Metad) respondCached(w http.ResponseWriter, req *http.Request, key string, etag string, version int64, result interface{}) int {
+	if m.cache == nil || req.Method != "GET" {
+		return respondSuccess(w, req, result)
+	}
+	cw := &cacheWriter{ResponseWriter: w, status: http.StatusOK}
+	n := respondSuccess(cw, req, result)
+	if cw.status == http.StatusOK {
+		m.cache.put(&cachedResponse{
+			key:         key,
+			etag:        etag,
+			version:     version,
+			contentType: w.Header().Get("Content-Type"),
+			body:        cw.body.Bytes(),
+		})
+	}
+	return n
+}
@@ -0,0 +1,205 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metad
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"openpitrix.io/metad/pkg/flatmap"
+	"openpitrix.io/metad/pkg/logger"
+	"openpitrix.io/metad/pkg/redact"
+	"openpitrix.io/metad/pkg/store"
+)
+
+// ChangeLogReset is the change log action clearing the data, the entries following it are the snapshot of the data.
+// It is logged when metad started and when the changes are dropped by the subscription.
+const ChangeLogReset = "RESET"
+
+// ChangeLogEntry is a line of the change log, a leaf update or delete of the data, or a reset.
+type ChangeLogEntry struct {
+	Time   time.Time `json:"time"`
+	Action string    `json:"action"`
+	Path   string    `json:"path,omitempty"`
+	Value  string    `json:"value,omitempty"`
+	Actor  string    `json:"actor,omitempty"`
+}
+
+// changeLog append the data changes to the local file, the file is only appended, so the data at any time since the
+// log started can be reconstructed by replaying it. The values of the sensitive_prefixes and encryption_prefixes are
+// masked, as the file is plain.
+type changeLog struct {
+	file   *os.File
+	writer *bufio.Writer
+	lock   sync.Mutex
+	done   <-chan struct{}
+}
+
+func openChangeLog(file string) (*changeLog, error) {
+	if file == "" {
+		return nil, nil
+	}
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &changeLog{file: f, writer: bufio.NewWriter(f)}, nil
+}
+
+func (l *changeLog) append(entries []*ChangeLogEntry) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	encoder := json.NewEncoder(l.writer)
+	for _, entry := range entries {
+		if err := encoder.Encode(entry); err != nil {
+			logger.Error("Write change log [%s] error: %s", l.file.Name(), err.Error())
+			return
+		}
+	}
+	if err := l.writer.Flush(); err != nil {
+		logger.Error("Write change log [%s] error: %s", l.file.Name(), err.Error())
+	}
+}
+
+// changeLogValue return the value logged, the sensitive value is masked.
+func changeLogValue(nodePath string, value string) string {
+	if value == "" {
+		return value
+	}
+	return redact.Value(nodePath, value)
+}
+
+// changeLogSnapshot return the reset entry and the update entries of all the leaves of the data.
+func (m *Metad) changeLogSnapshot(now time.Time) []*ChangeLogEntry {
+	entries := []*ChangeLogEntry{{Time: now, Action: ChangeLogReset}}
+	val := m.metadataRepo.GetData("/")
+	values, ok := val.(map[string]interface{})
+	if !ok {
+		return entries
+	}
+	for p, v := range flatmap.Flatten(values) {
+		entries = append(entries, &ChangeLogEntry{Time: now, Action: store.Update, Path: p, Value: changeLogValue(p, v)})
+	}
+	return entries
+}
+
+// startChangeLog subscribe the data changes and append them to the change log until metad stopped, the current
+// data is logged as the snapshot first.
+func (m *Metad) startChangeLog() {
+	if m.changeLog == nil {
+		return
+	}
+	l := m.changeLog
+	l.done = m.metadataRepo.SubscribeData("/", m.shutdownChan, func(events []*store.Event) {
+		now := time.Now()
+		entries := make([]*ChangeLogEntry, 0, len(events))
+		for _, e := range events {
+			if e.Action == store.Resync {
+				entries = append(entries, m.changeLogSnapshot(now)...)
+				continue
+			}
+			entries = append(entries, &ChangeLogEntry{Time: now, Action: e.Action, Path: e.Path, Value: changeLogValue(e.Path, e.Value), Actor: e.Actor})
+		}
+		l.append(entries)
+	})
+	l.append(m.changeLogSnapshot(time.Now()))
+}
+
+// closeChangeLog close the change log after the subscription stopped.
+func (m *Metad) closeChangeLog() {
+	l := m.changeLog
+	if l == nil {
+		return
+	}
+	if l.done != nil {
+		<-l.done
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.file.Close()
+}
+
+// parseAt parse the at query parameter, in RFC3339 format or unix seconds.
+func parseAt(at string) (time.Time, bool) {
+	if t, err := time.Parse(time.RFC3339Nano, at); err == nil {
+		return t, true
+	}
+	if s, err := strconv.ParseInt(at, 10, 64); err == nil {
+		return time.Unix(s, 0), true
+	}
+	return time.Time{}, false
+}
+
+// replayChangeLog return the leaves under nodePath at the time by replaying the change log, nil if the log does not
+// reach the time.
+func replayChangeLog(file string, nodePath string, at time.Time) (map[string]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var values map[string]string
+	prefix := nodePath + "/"
+	if nodePath == "/" {
+		prefix = nodePath
+	}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		entry := &ChangeLogEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), entry); err != nil {
+			// the last line may be partially written when metad crashed.
+			continue
+		}
+		if entry.Time.After(at) {
+			break
+		}
+		if entry.Action == ChangeLogReset {
+			values = map[string]string{}
+			continue
+		}
+		if values == nil || (entry.Path != nodePath && !strings.HasPrefix(entry.Path, prefix)) {
+			continue
+		}
+		switch entry.Action {
+		case store.Update:
+			values[entry.Path] = entry.Value
+		case store.Delete:
+			delete(values, entry.Path)
+		}
+	}
+	return values, scanner.Err()
+}
+
+// dataAt respond the data at nodePath at the time of the at query parameter, reconstructed from the change log.
+func (m *Metad) dataAt(nodePath string, req *http.Request, p *projection) (interface{}, *HttpError) {
+	file := m.getConfig().ChangeLog
+	if m.changeLog == nil || file == "" {
+		return nil, NewHttpError(http.StatusBadRequest, "at require change_log.")
+	}
+	at, ok := parseAt(req.FormValue("at"))
+	if !ok {
+		return nil, NewHttpError(http.StatusBadRequest, "invalid at ["+req.FormValue("at")+"], should be RFC3339 format or unix seconds.")
+	}
+	nodePath = path.Clean(path.Join("/", nodePath))
+	values, err := replayChangeLog(file, nodePath, at)
+	if err != nil {
+		return nil, NewHttpError(http.StatusInternalServerError, "read change log error: "+err.Error())
+	}
+	if v, ok := values[nodePath]; ok {
+		return p.apply(v), nil
+	}
+	if len(values) == 0 {
+		return nil, NewHttpError(http.StatusNotFound, "Not found")
+	}
+	return p.apply(flatmap.Expand(values, nodePath)), nil
+}
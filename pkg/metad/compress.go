@@ -0,0 +1,157 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metad
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// compressor is a content encoding, the writers are pooled to avoid allocating the compress state per response.
+type compressor struct {
+	encoding  string
+	newWriter func(w io.Writer) compressWriteCloser
+	pool      sync.Pool
+}
+
+type compressWriteCloser interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+}
+
+func (c *compressor) get(w io.Writer) compressWriteCloser {
+	if v := c.pool.Get(); v != nil {
+		cw := v.(compressWriteCloser)
+		cw.Reset(w)
+		return cw
+	}
+	return c.newWriter(w)
+}
+
+func (c *compressor) put(cw compressWriteCloser) {
+	c.pool.Put(cw)
+}
+
+// compressors in order of server preference, which is used when the client accept them with the same q-value.
+var compressors = []*compressor{
+	{encoding: "zstd", newWriter: func(w io.Writer) compressWriteCloser {
+		// the response is compressed by the request goroutine.
+		zw, _ := zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+		return zw
+	}},
+	{encoding: "gzip", newWriter: func(w io.Writer) compressWriteCloser {
+		return gzip.NewWriter(w)
+	}},
+	{encoding: "deflate", newWriter: func(w io.Writer) compressWriteCloser {
+		fw, _ := flate.NewWriter(w, flate.DefaultCompression)
+		return fw
+	}},
+}
+
+// negotiateCompressor choose the compressor with the highest q-value of the Accept-Encoding header, nil if none acceptable.
+func negotiateCompressor(acceptEncoding string) *compressor {
+	if acceptEncoding == "" {
+		return nil
+	}
+	accepted := map[string]float64{}
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		params := strings.Split(part, ";")
+		encoding := strings.ToLower(strings.TrimSpace(params[0]))
+		q := 1.0
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		accepted[encoding] = q
+	}
+	var chosen *compressor
+	var chosenQ float64
+	for _, c := range compressors {
+		q, present := accepted[c.encoding]
+		if !present {
+			q = accepted["*"]
+		}
+		if q > chosenQ {
+			chosen, chosenQ = c, q
+		}
+	}
+	return chosen
+}
+
+// compressResponseWriter compress the response body if it is not less than minSize.
+// The header is delayed to the first Write, as the encoding is decided by the body size.
+type compressResponseWriter struct {
+	http.ResponseWriter
+	compressor *compressor
+	minSize    int
+	status     int
+	decided    bool
+	writer     compressWriteCloser
+}
+
+// compressWriter wrap the writer if the client accept a supported encoding, return nil if compression is disabled or not acceptable.
+func (m *Metad) compressWriter(w http.ResponseWriter, req *http.Request) *compressResponseWriter {
+	minSize := m.getConfig().CompressMinSize
+	if minSize <= 0 {
+		return nil
+	}
+	w.Header().Add("Vary", "Accept-Encoding")
+	c := negotiateCompressor(req.Header.Get("Accept-Encoding"))
+	if c == nil {
+		return nil
+	}
+	return &compressResponseWriter{ResponseWriter: w, compressor: c, minSize: minSize, status: http.StatusOK}
+}
+
+func (w *compressResponseWriter) WriteHeader(status int) {
+	if !w.decided {
+		w.status = status
+	}
+}
+
+func (w *compressResponseWriter) decide(size int) {
+	w.decided = true
+	if size >= w.minSize && w.status != http.StatusNotModified && w.status != http.StatusNoContent && w.Header().Get("Content-Encoding") == "" {
+		w.Header().Set("Content-Encoding", w.compressor.encoding)
+		w.Header().Del("Content-Length")
+		w.writer = w.compressor.get(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+}
+
+func (w *compressResponseWriter) Write(b []byte) (int, error) {
+	if !w.decided {
+		w.decide(len(b))
+	}
+	if w.writer == nil {
+		return w.ResponseWriter.Write(b)
+	}
+	return w.writer.Write(b)
+}
+
+// Close flush the compressed data, and write the header if nothing written.
+func (w *compressResponseWriter) Close() error {
+	if !w.decided {
+		w.decide(0)
+	}
+	if w.writer == nil {
+		return nil
+	}
+	err := w.writer.Close()
+	w.compressor.put(w.writer)
+	w.writer = nil
+	return err
+}
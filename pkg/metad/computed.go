@@ -0,0 +1,214 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metad
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"openpitrix.io/metad/pkg/logger"
+	"openpitrix.io/metad/pkg/store"
+	"openpitrix.io/metad/pkg/util"
+)
+
+// the functions of the computed key expressions, the operands of the source path are the children of the dir, or the
+// nodes matched by the glob path such as /clusters/*/nodes/*/ip.
+const (
+	// ComputedCount is the count of the operands.
+	ComputedCount = "count"
+	// ComputedKeys join the names of the operands.
+	ComputedKeys = "keys"
+	// ComputedJoin join the leaf values of the operands.
+	ComputedJoin = "join"
+	// ComputedSum is the sum of the number leaf values of the operands, the others are ignored.
+	ComputedSum = "sum"
+)
+
+// defaultComputedSeparator is the separator of keys and join if not present.
+const defaultComputedSeparator = ","
+
+// computedKey is a derived data leaf whose value is computed from the source path by the expression, it is mounted as
+// a read only leaf and recomputed when the data under the source changed, so the watchers see it like other data.
+type computedKey struct {
+	path   string
+	expr   string
+	fn     string
+	source string
+	sep    string
+	// prefix is the static prefix of the source watched, elems is the glob path elements relative to it.
+	prefix string
+	elems  []string
+}
+
+// parseComputedKey parse the computed key in format path=func(source[, separator]), such as
+// /stats/node_count=count(/nodes) or /stats/ips=join(/nodes/*/ip, " ").
+func parseComputedKey(s string) (*computedKey, error) {
+	kv := strings.SplitN(s, "=", 2)
+	if len(kv) != 2 {
+		return nil, fmt.Errorf("invalid computed key [%s], should be path=func(source).", s)
+	}
+	k := &computedKey{path: path.Join("/", strings.TrimSpace(kv[0])), expr: strings.TrimSpace(kv[1]), sep: defaultComputedSeparator}
+	if k.path == "/" {
+		return nil, fmt.Errorf("invalid computed key [%s], can not compute root path.", s)
+	}
+	open := strings.Index(k.expr, "(")
+	if open < 0 || !strings.HasSuffix(k.expr, ")") {
+		return nil, fmt.Errorf("invalid computed key [%s], the expression should be func(source).", s)
+	}
+	k.fn = strings.TrimSpace(k.expr[:open])
+	switch k.fn {
+	case ComputedCount, ComputedKeys, ComputedJoin, ComputedSum:
+	default:
+		return nil, fmt.Errorf("invalid computed key [%s], unknown func [%s], should be count, keys, join or sum.", s, k.fn)
+	}
+	args := strings.SplitN(k.expr[open+1:len(k.expr)-1], ",", 2)
+	k.source = strings.TrimSpace(args[0])
+	if !strings.HasPrefix(k.source, "/") {
+		return nil, fmt.Errorf("invalid computed key [%s], the source should be absolute path.", s)
+	}
+	k.source = path.Clean(k.source)
+	if len(args) == 2 {
+		if k.fn != ComputedKeys && k.fn != ComputedJoin {
+			return nil, fmt.Errorf("invalid computed key [%s], only keys and join have separator.", s)
+		}
+		sep := strings.TrimSpace(args[1])
+		if unquoted, err := strconv.Unquote(sep); err == nil {
+			sep = unquoted
+		}
+		k.sep = sep
+	}
+	elems := strings.Split(strings.TrimPrefix(k.source, "/"), "/")
+	for i, elem := range elems {
+		if !strings.ContainsAny(elem, "*?[") {
+			continue
+		}
+		if _, err := path.Match(elem, ""); err != nil {
+			return nil, fmt.Errorf("invalid computed key [%s], bad glob [%s].", s, elem)
+		}
+		if k.elems == nil {
+			k.prefix = path.Join(append([]string{"/"}, elems[:i]...)...)
+			k.elems = elems[i:]
+		}
+	}
+	if k.elems == nil {
+		k.prefix = k.source
+	}
+	return k, nil
+}
+
+func configComputedKeys(config *Config, sources []*httpSource) ([]*computedKey, error) {
+	keys := make([]*computedKey, 0, len(config.ComputedKeys))
+	for _, s := range config.ComputedKeys {
+		k, err := parseComputedKey(s)
+		if err != nil {
+			return nil, err
+		}
+		for _, other := range keys {
+			if k.path == other.path || strings.HasPrefix(k.path, other.path+"/") || strings.HasPrefix(other.path, k.path+"/") {
+				return nil, fmt.Errorf("computed key [%s] overlaps [%s].", k.path, other.path)
+			}
+		}
+		for _, source := range sources {
+			if k.path == source.prefix || strings.HasPrefix(k.path, source.prefix+"/") || strings.HasPrefix(source.prefix, k.path+"/") {
+				return nil, fmt.Errorf("computed key [%s] overlaps http source [%s].", k.path, source.prefix)
+			}
+		}
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+type computedOperand struct {
+	path  string
+	value interface{}
+}
+
+// operands return the operands of the source in util.NaturalLess path order, val is the data of the prefix.
+func (k *computedKey) operands(val interface{}) []computedOperand {
+	var operands []computedOperand
+	if k.elems == nil {
+		if dir, ok := val.(map[string]interface{}); ok {
+			for name, v := range dir {
+				operands = append(operands, computedOperand{path: path.Join(k.prefix, name), value: v})
+			}
+		} else if val != nil {
+			operands = append(operands, computedOperand{path: k.prefix, value: val})
+		}
+	} else {
+		operands = matchOperands(operands, k.prefix, val, k.elems)
+	}
+	sort.Slice(operands, func(i, j int) bool {
+		return util.NaturalLess(operands[i].path, operands[j].path)
+	})
+	return operands
+}
+
+func matchOperands(operands []computedOperand, nodePath string, val interface{}, elems []string) []computedOperand {
+	if len(elems) == 0 {
+		return append(operands, computedOperand{path: nodePath, value: val})
+	}
+	dir, ok := val.(map[string]interface{})
+	if !ok {
+		return operands
+	}
+	for name, v := range dir {
+		if matched, _ := path.Match(elems[0], name); matched {
+			operands = matchOperands(operands, path.Join(nodePath, name), v, elems[1:])
+		}
+	}
+	return operands
+}
+
+// compute return the value of the expression, val is the data of the prefix.
+func (k *computedKey) compute(val interface{}) string {
+	operands := k.operands(val)
+	switch k.fn {
+	case ComputedCount:
+		return strconv.Itoa(len(operands))
+	case ComputedKeys:
+		names := make([]string, 0, len(operands))
+		for _, operand := range operands {
+			names = append(names, path.Base(operand.path))
+		}
+		return strings.Join(names, k.sep)
+	case ComputedJoin:
+		values := make([]string, 0, len(operands))
+		for _, operand := range operands {
+			if v, ok := operand.value.(string); ok {
+				values = append(values, v)
+			}
+		}
+		return strings.Join(values, k.sep)
+	default:
+		var sum float64
+		for _, operand := range operands {
+			if v, ok := operand.value.(string); ok {
+				if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+					sum += f
+				}
+			}
+		}
+		return strconv.FormatFloat(sum, 'f', -1, 64)
+	}
+}
+
+// startComputedKey compute the key, and recompute it when the data under the source prefix changed until metad
+// stopped.
+func (m *Metad) startComputedKey(k *computedKey) {
+	m.metadataRepo.SubscribeData(k.prefix, m.shutdownChan, func(events []*store.Event) {
+		m.computeKey(k)
+	})
+	m.computeKey(k)
+}
+
+func (m *Metad) computeKey(k *computedKey) {
+	updated, _ := m.metadataRepo.PutMountedData(k.path, k.compute(m.metadataRepo.GetData(k.prefix)))
+	if updated > 0 {
+		logger.Debug("Recompute key [%s] by [%s]", k.path, k.expr)
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metad
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// conditionalSyncTimeout is the max time the conditional write wait for its change synced back from the backend.
+const conditionalSyncTimeout = time.Second
+
+// revisionETag return the ETag of the data revision.
+func revisionETag(revision int64) string {
+	return fmt.Sprintf(`"%d"`, revision)
+}
+
+// matchRevision check whether the If-Match header match the revision, "*" match any existing path, and the revision
+// 0 match the path not exist.
+func matchRevision(ifMatch string, revision int64) bool {
+	for _, tag := range strings.Split(ifMatch, ",") {
+		tag = strings.Trim(strings.TrimPrefix(strings.TrimSpace(tag), "W/"), `"`)
+		if tag == "*" {
+			if revision > 0 {
+				return true
+			}
+			continue
+		}
+		if r, err := strconv.ParseInt(tag, 10, 64); err == nil && r == revision {
+			return true
+		}
+	}
+	return false
+}
+
+// conditional check the If-Match header of the data write with the revision of the nodePath, respond 412 with the
+// current revision as the ETag if not match, so the concurrent operators do not overwrite each other's changes.
+// The revision is of the metad's store, so the check is only reliable for the writes through the same metad.
+// The conditional write exclude the other data writes of the metad, and wait for the overlapping writes synced back
+// before the check, the succeeded one respond the new revision after its change synced back.
+func (m *Metad) conditional(manager manageFunc) manageFunc {
+	return func(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+		nodePath := path.Join("/", mux.Vars(req)["nodePath"])
+		ifMatch := req.Header.Get("If-Match")
+		if ifMatch == "" {
+			m.conditionalLock.RLock()
+			defer m.conditionalLock.RUnlock()
+			result, httpErr := manager(ctx, req)
+			if httpErr == nil {
+				m.addUnsyncedWrite(nodePath)
+			}
+			return result, httpErr
+		}
+		if !m.lockConditional(ctx, nodePath) {
+			setResponseHeader(ctx, "ETag", revisionETag(m.metadataRepo.DataRevision(nodePath)))
+			return nil, NewHttpError(http.StatusPreconditionFailed, fmt.Sprintf("the concurrent writes of [%s] not synced in %s, retry with the current revision.", nodePath, conditionalSyncTimeout))
+		}
+		revision := m.metadataRepo.DataRevision(nodePath)
+		if !matchRevision(ifMatch, revision) {
+			m.conditionalLock.Unlock()
+			setResponseHeader(ctx, "ETag", revisionETag(revision))
+			return nil, NewHttpError(http.StatusPreconditionFailed, fmt.Sprintf("revision of [%s] is %d, not match If-Match [%s].", nodePath, revision, ifMatch))
+		}
+		result, httpErr := manager(ctx, req)
+		if httpErr == nil {
+			m.addUnsyncedWrite(nodePath)
+		}
+		m.conditionalLock.Unlock()
+		if httpErr != nil {
+			return result, httpErr
+		}
+		if m.metadataRepo.WaitDataSynced(nodePath, conditionalSyncTimeout) {
+			setResponseHeader(ctx, "ETag", revisionETag(m.metadataRepo.DataRevision(nodePath)))
+		} else {
+			requestLogger(ctx).Debug("Conditional write of [%s] not synced in %s.", nodePath, conditionalSyncTimeout)
+		}
+		return result, nil
+	}
+}
+
+// lockConditional lock the conditionalLock when no overlapping write of nodePath is unsynced, the overlapping writes
+// are waited with the lock released, so the other writes are not stalled. Return false without the lock if the
+// overlapping writes keep coming for conditionalSyncTimeout.
+func (m *Metad) lockConditional(ctx context.Context, nodePath string) bool {
+	deadline := time.Now().Add(conditionalSyncTimeout)
+	for {
+		m.conditionalLock.Lock()
+		writes := m.takeUnsyncedWrites(nodePath)
+		if len(writes) == 0 {
+			return true
+		}
+		m.conditionalLock.Unlock()
+		if time.Now().After(deadline) {
+			return false
+		}
+		m.waitUnsyncedWrites(ctx, writes)
+	}
+}
+
+// addUnsyncedWrite record the data write of nodePath, the records older than conditionalSyncTimeout are dropped.
+func (m *Metad) addUnsyncedWrite(nodePath string) {
+	now := time.Now()
+	m.unsyncedWritesLock.Lock()
+	defer m.unsyncedWritesLock.Unlock()
+	if m.unsyncedWrites == nil {
+		m.unsyncedWrites = map[string]time.Time{}
+	}
+	for p, t := range m.unsyncedWrites {
+		if now.Sub(t) > conditionalSyncTimeout {
+			delete(m.unsyncedWrites, p)
+		}
+	}
+	m.unsyncedWrites[nodePath] = now
+}
+
+// takeUnsyncedWrites remove and return the recorded writes of nodePath, its parents and sub paths.
+func (m *Metad) takeUnsyncedWrites(nodePath string) map[string]time.Time {
+	m.unsyncedWritesLock.Lock()
+	defer m.unsyncedWritesLock.Unlock()
+	writes := map[string]time.Time{}
+	for p, t := range m.unsyncedWrites {
+		if coversPath([]string{p}, nodePath) || coversPath([]string{nodePath}, p) {
+			writes[p] = t
+			delete(m.unsyncedWrites, p)
+		}
+	}
+	return writes
+}
+
+// waitUnsyncedWrites wait for the writes synced back, at most conditionalSyncTimeout since every write.
+func (m *Metad) waitUnsyncedWrites(ctx context.Context, writes map[string]time.Time) {
+	for p, t := range writes {
+		timeout := conditionalSyncTimeout - time.Since(t)
+		if timeout <= 0 || !m.metadataRepo.WaitDataSynced(p, timeout) {
+			requestLogger(ctx).Debug("Data write of [%s] not synced in %s.", p, conditionalSyncTimeout)
+		}
+	}
+}
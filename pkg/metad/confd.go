@@ -0,0 +1,35 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metad
+
+import (
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v2"
+
+	"openpitrix.io/metad/pkg/confd"
+)
+
+// importConfdMain print the conversion of the confd config dir as yaml to stdout, and report the unconverted
+// options to stderr, so the output can be redirected to a file.
+func importConfdMain(dir string) {
+	im, err := confd.Convert(dir)
+	if err == nil {
+		var b []byte
+		if b, err = yaml.Marshal(im); err == nil {
+			fmt.Print(string(b))
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Import confd config dir [%s] error: %s\n", dir, err.Error())
+		os.Exit(1)
+	}
+	for _, u := range im.Unconverted {
+		fmt.Fprintf(os.Stderr, "Unconverted %s\n", u.String())
+	}
+	fmt.Fprintf(os.Stderr, "Converted %d template resources of [%s], %d unconverted.\n", len(im.Renders), dir, len(im.Unconverted))
+	os.Exit(0)
+}
@@ -18,7 +18,10 @@ import (
 	"gopkg.in/yaml.v2"
 
 	"openpitrix.io/metad/pkg/backends"
+	"openpitrix.io/metad/pkg/confd"
 	"openpitrix.io/metad/pkg/logger"
+	"openpitrix.io/metad/pkg/metadata"
+	"openpitrix.io/metad/pkg/store"
 )
 
 type Nodes []string
@@ -37,14 +40,18 @@ func (n *Nodes) Set(node string) error {
 var (
 	metad *Metad
 
-	printVersion bool
-	logLevel     string
-	enableXff    bool
-	prefix       string
-	listen       string
-	listenManage string
-	configFile   string
-	pidFile      string
+	printVersion   bool
+	importConfd    string
+	logLevel       string
+	logFormat      string
+	enableXff      bool
+	prefix         string
+	listen         string
+	listenManage   string
+	listenUnix     string
+	listenUnixMode string
+	configFile     string
+	pidFile        string
 
 	backend      string
 	basicAuth    bool
@@ -54,38 +61,243 @@ var (
 	nodes        Nodes
 	username     string
 	password     string
+	usernameFile string
+	passwordFile string
 	group        string
+
+	tlsCert              string
+	tlsKey               string
+	tlsClientCA          string
+	tlsRequireClientCert bool
+
+	auditFile        string
+	auditBackend     bool
+	auditMaxSize     int
+	auditMaxBackups  int
+	auditSecretPaths Nodes
+	auditSIEM        string
+	auditSIEMBuffer  int
+	auditSIEMAccess  bool
+
+	readyMaxSyncLag int
+
+	notifyWebhooks      Nodes
+	notifyRetries       int
+	notifyRetryInterval int
+	notifySecret        string
+
+	drainTimeout int
+
+	rateLimit            float64
+	rateLimitBurst       int
+	clientRateLimit      float64
+	clientRateLimitBurst int
+
+	responseCacheSize int
+	compressMinSize   int
+
+	quotas    Nodes
+	quotaMode string
+
+	cacheFile     string
+	cacheInterval int
+
+	readPeers  Nodes
+	readBudget int
+
+	trustedProxies Nodes
+	proxyProtocol  bool
+
+	verifyRepair   bool
+	verifyInterval int
+
+	httpSources        Nodes
+	httpSourceInterval int
+
+	computedKeys Nodes
+
+	maxRequestTimeout int
+
+	authzURL      string
+	authzFailOpen bool
+	authzCacheTTL int
+
+	sloAvailability  float64
+	sloLatencyMs     int
+	sloLatencyTarget float64
+
+	renderHost string
+
+	indexKeys Nodes
+
+	templatePrefix string
+
+	typedValues bool
+
+	nodeHistory   int
+	changeLogFile string
+
+	trashRetention int
+
+	leaderElection bool
+	leaderTTL      int
+	leaderID       string
+
+	encryptionPrefixes Nodes
+	encryptionKeyFile  string
+	sensitivePrefixes  Nodes
+
+	adminToken string
+
+	manageTLSCert     string
+	manageTLSKey      string
+	manageTLSClientCA string
+	manageRBAC        bool
+
+	memoryReportTop   int
+	memoryReportDepth int
 )
 
 type Config struct {
-	Backend      string   `yaml:"backend"`
-	LogLevel     string   `yaml:"log_level"`
-	PIDFile      string   `yaml:"pid_file"`
-	EnableXff    bool     `yaml:"xff"`
-	Prefix       string   `yaml:"prefix"`
-	Listen       string   `yaml:"listen"`
-	ListenManage string   `yaml:"listen_manage"`
-	BasicAuth    bool     `yaml:"basic_auth"`
-	ClientCaKeys string   `yaml:"client_ca_keys"`
-	ClientCert   string   `yaml:"client_cert"`
-	ClientKey    string   `yaml:"client_key"`
-	BackendNodes []string `yaml:"nodes"`
-	Username     string   `yaml:"username"`
-	Password     string   `yaml:"password"`
-	Group        string   `yaml:"Group"`
+	Backend      string `yaml:"backend"`
+	LogLevel     string `yaml:"log_level"`
+	LogFormat    string `yaml:"log_format"`
+	PIDFile      string `yaml:"pid_file"`
+	EnableXff    bool   `yaml:"xff"`
+	Prefix       string `yaml:"prefix"`
+	Listen       string `yaml:"listen"`
+	ListenManage string `yaml:"listen_manage"`
+	ListenUnix   string `yaml:"listen_unix"`
+	// ListenUnixMode is the octal file mode of the listen_unix socket, default 0660.
+	ListenUnixMode string   `yaml:"listen_unix_mode"`
+	BasicAuth      bool     `yaml:"basic_auth"`
+	ClientCaKeys   string   `yaml:"client_ca_keys"`
+	ClientCert     string   `yaml:"client_cert"`
+	ClientKey      string   `yaml:"client_key"`
+	BackendNodes   []string `yaml:"nodes"`
+	Username       string   `yaml:"username"`
+	Password       string   `yaml:"password"`
+	UsernameFile   string   `yaml:"username_file"`
+	PasswordFile   string   `yaml:"password_file"`
+	Group          string   `yaml:"Group"`
+
+	TLSCert              string `yaml:"tls_cert"`
+	TLSKey               string `yaml:"tls_key"`
+	TLSClientCA          string `yaml:"tls_client_ca"`
+	TLSRequireClientCert bool   `yaml:"tls_require_client_cert"`
+
+	AuditFile        string   `yaml:"audit_file"`
+	AuditBackend     bool     `yaml:"audit_backend"`
+	AuditMaxSize     int      `yaml:"audit_max_size"`
+	AuditMaxBackups  int      `yaml:"audit_max_backups"`
+	AuditSecretPaths []string `yaml:"audit_secret_paths,omitempty"`
+	AuditSIEM        string   `yaml:"audit_siem"`
+	AuditSIEMBuffer  int      `yaml:"audit_siem_buffer"`
+	AuditSIEMAccess  bool     `yaml:"audit_siem_access"`
+
+	ReadyMaxSyncLag int `yaml:"ready_max_sync_lag"`
+
+	NotifyWebhooks      []string `yaml:"notify_webhooks,omitempty"`
+	NotifyRetries       int      `yaml:"notify_retries"`
+	NotifyRetryInterval int      `yaml:"notify_retry_interval"`
+	NotifySecret        string   `yaml:"notify_secret"`
+
+	DrainTimeout int `yaml:"drain_timeout"`
+
+	RateLimit            float64 `yaml:"rate_limit"`
+	RateLimitBurst       int     `yaml:"rate_limit_burst"`
+	ClientRateLimit      float64 `yaml:"client_rate_limit"`
+	ClientRateLimitBurst int     `yaml:"client_rate_limit_burst"`
+
+	ResponseCacheSize int `yaml:"response_cache_size"`
+	CompressMinSize   int `yaml:"compress_min_size"`
+
+	Quotas    []string `yaml:"quotas,omitempty"`
+	QuotaMode string   `yaml:"quota_mode"`
+
+	CacheFile     string `yaml:"cache_file"`
+	CacheInterval int    `yaml:"cache_interval"`
+
+	ReadPeers  []string `yaml:"read_peers,omitempty"`
+	ReadBudget int      `yaml:"read_budget"`
+
+	TrustedProxies []string `yaml:"trusted_proxies,omitempty"`
+	ProxyProtocol  bool     `yaml:"proxy_protocol"`
+
+	VerifyRepair   bool `yaml:"verify_repair"`
+	VerifyInterval int  `yaml:"verify_interval"`
+
+	HTTPSources        []string `yaml:"http_sources,omitempty"`
+	HTTPSourceInterval int      `yaml:"http_source_interval"`
+
+	ComputedKeys []string `yaml:"computed_keys,omitempty"`
+
+	MaxRequestTimeout int `yaml:"max_request_timeout"`
+
+	AuthzURL      string `yaml:"authz_url"`
+	AuthzFailOpen bool   `yaml:"authz_fail_open"`
+	AuthzCacheTTL int    `yaml:"authz_cache_ttl"`
+
+	SLOAvailability  float64 `yaml:"slo_availability"`
+	SLOLatencyMs     int     `yaml:"slo_latency_ms"`
+	SLOLatencyTarget float64 `yaml:"slo_latency_target"`
+
+	Renders    []*confd.Render `yaml:"renders,omitempty"`
+	RenderHost string          `yaml:"render_host"`
+
+	IndexKeys []string `yaml:"index_keys,omitempty"`
+
+	TemplatePrefix string `yaml:"template_prefix"`
+
+	TypedValues bool `yaml:"typed_values"`
+
+	NodeHistory int `yaml:"node_history"`
+
+	ChangeLog string `yaml:"change_log"`
+
+	TrashRetention int `yaml:"trash_retention"`
+
+	LeaderElection bool   `yaml:"leader_election"`
+	LeaderTTL      int    `yaml:"leader_ttl"`
+	LeaderID       string `yaml:"leader_id"`
+
+	EncryptionPrefixes []string `yaml:"encryption_prefixes,omitempty"`
+	EncryptionKeyFile  string   `yaml:"encryption_key_file"`
+	SensitivePrefixes  []string `yaml:"sensitive_prefixes,omitempty"`
+
+	AdminToken string `yaml:"admin_token"`
+
+	ManageTLSCert     string                  `yaml:"manage_tls_cert"`
+	ManageTLSKey      string                  `yaml:"manage_tls_key"`
+	ManageTLSClientCA string                  `yaml:"manage_tls_client_ca"`
+	ManageRBAC        bool                    `yaml:"manage_rbac"`
+	RoleBindings      []*metadata.RoleBinding `yaml:"role_bindings,omitempty"`
+
+	Namespaces []*Namespace `yaml:"namespaces,omitempty"`
+
+	SelfProfiles []*SelfProfile `yaml:"self_profiles,omitempty"`
+
+	MemoryReportTop   int `yaml:"memory_report_top"`
+	MemoryReportDepth int `yaml:"memory_report_depth"`
 }
 
 func init() {
 	flag.BoolVar(&printVersion, "version", false, "Show metad version")
+	flag.StringVar(&importConfd, "import_confd", "", "Convert the confd config dir to metad config, render definitions and mapping rule, print them as yaml and exit")
 	flag.StringVar(&configFile, "config", "", "The configuration file path")
 	flag.StringVar(&backend, "backend", "local", "The metad backend type")
 	flag.StringVar(&logLevel, "log_level", "info", "Log level for metad print out: debug|info|warning")
+	flag.StringVar(&logFormat, "log_format", "text", "Log output format: text|json")
 	flag.StringVar(&pidFile, "pid_file", "", "PID to write to")
 	flag.BoolVar(&enableXff, "xff", false, "X-Forwarded-For header support")
+	flag.Var(&trustedProxies, "trusted_proxies", "List of trusted proxy CIDRs, X-Forwarded-For and X-Real-IP are only honored from them if present")
+	flag.BoolVar(&proxyProtocol, "proxy_protocol", false, "Read the PROXY protocol v1/v2 header on the metadata listener")
 	flag.StringVar(&prefix, "prefix", "", "Backend key path prefix")
 	flag.StringVar(&group, "group", "default", "The metad's group name, same group share same mapping config from backend")
 	flag.StringVar(&listen, "listen", ":9180", "Address to listen to (TCP)")
 	flag.StringVar(&listenManage, "listen_manage", "127.0.0.1:9611", "Address to listen to for manage requests (TCP)")
+	flag.StringVar(&listenUnix, "listen_unix", "", "Unix socket path to listen to for metadata requests, the peer uid is the client identity")
+	flag.StringVar(&listenUnixMode, "listen_unix_mode", "0660", "The octal file mode of the listen_unix socket")
 	flag.BoolVar(&basicAuth, "basic_auth", false, "Use Basic Auth to authenticate (only used with -backend=etcd)")
 	flag.StringVar(&clientCaKeys, "client_ca_keys", "", "The client ca keys")
 	flag.StringVar(&clientCert, "client_cert", "", "The client cert")
@@ -93,34 +305,87 @@ func init() {
 	flag.Var(&nodes, "nodes", "List of backend nodes")
 	flag.StringVar(&username, "username", "", "The username to authenticate as (only used with etcd backends)")
 	flag.StringVar(&password, "password", "", "The password to authenticate with (only used with etcd backends)")
+	flag.StringVar(&usernameFile, "username_file", "", "The file of the username to authenticate as, checked periodically for rotation (only used with etcd backends)")
+	flag.StringVar(&passwordFile, "password_file", "", "The file of the password to authenticate with, checked periodically for rotation and enable basic auth (only used with etcd backends)")
+	flag.StringVar(&tlsCert, "tls_cert", "", "The server cert of metadata listener, enable https if present")
+	flag.StringVar(&tlsKey, "tls_key", "", "The server key of metadata listener")
+	flag.StringVar(&tlsClientCA, "tls_client_ca", "", "The ca to verify client cert of metadata listener, verified cert's CN/SAN is used as client identity")
+	flag.BoolVar(&tlsRequireClientCert, "tls_require_client_cert", false, "Require client cert on metadata listener")
+	flag.StringVar(&auditFile, "audit_file", "", "The audit log file, manage api mutations and secret reads are appended as json lines")
+	flag.BoolVar(&auditBackend, "audit_backend", false, "Write audit log to backend")
+	flag.IntVar(&auditMaxSize, "audit_max_size", 100, "Max size in megabytes of the audit log file before rotated, 0 means never rotate")
+	flag.IntVar(&auditMaxBackups, "audit_max_backups", 5, "Max number of rotated audit log files to keep")
+	flag.Var(&auditSecretPaths, "audit_secret_paths", "List of secret data paths, reads of them by metadata api are audited")
+	flag.StringVar(&auditSIEM, "audit_siem", "", "The SIEM endpoint (tcp://host:port, udp://host:port or http(s) url) to stream audit entries as json lines")
+	flag.IntVar(&auditSIEMBuffer, "audit_siem_buffer", 10000, "Max number of audit entries buffered when the SIEM endpoint is slow or unavailable, new entries are dropped if full")
+	flag.BoolVar(&auditSIEMAccess, "audit_siem_access", false, "Also stream the access entry of every metadata api request to the SIEM endpoint")
+	flag.IntVar(&readyMaxSyncLag, "ready_max_sync_lag", 30, "Max seconds of backend sync lag before /readyz report not ready, 0 means no limit")
+	flag.Var(&notifyWebhooks, "notify_webhooks", "List of webhooks in format [prefix=]url, data change events under the prefix are POSTed to the url")
+	flag.IntVar(&notifyRetries, "notify_retries", 3, "Max retries of a failed webhook notification before put to dead letter")
+	flag.IntVar(&notifyRetryInterval, "notify_retry_interval", 1, "Seconds before the first retry of a failed webhook notification, doubled every retry")
+	flag.StringVar(&notifySecret, "notify_secret", "", "The secret to sign the notifications of notify_webhooks")
+	flag.IntVar(&drainTimeout, "drain_timeout", 30, "Max seconds to wait the in-flight requests finish when shutdown")
+	flag.Float64Var(&rateLimit, "rate_limit", 0, "Max requests per second of metadata api, 0 means unlimited")
+	flag.IntVar(&rateLimitBurst, "rate_limit_burst", 0, "Max burst requests of metadata api, 0 means same as rate_limit")
+	flag.Float64Var(&clientRateLimit, "client_rate_limit", 0, "Max requests per second of metadata api per client, 0 means unlimited")
+	flag.IntVar(&clientRateLimitBurst, "client_rate_limit_burst", 0, "Max burst requests of metadata api per client, 0 means same as client_rate_limit")
+	flag.IntVar(&responseCacheSize, "response_cache_size", 1000, "Max number of serialized metadata api responses cached, 0 means disable the cache")
+	flag.IntVar(&compressMinSize, "compress_min_size", 1024, "Min size in bytes of metadata api response to be compressed if client accept zstd, gzip or deflate encoding, 0 means disable the compression")
+	flag.Var(&quotas, "quotas", "List of data quotas in format prefix=max_keys[,max_bytes] of top level prefixes, 0 means unlimited")
+	flag.StringVar(&quotaMode, "quota_mode", "reject", "How to handle the writes exceeding the quota: reject|flag")
+	flag.StringVar(&cacheFile, "cache_file", "", "The local cache file of the synced metadata, loaded on startup and served as stale until backend synced")
+	flag.IntVar(&cacheInterval, "cache_interval", 60, "Seconds between saving the synced metadata to cache_file")
+	flag.Var(&readPeers, "read_peers", "List of peer metad manage urls, tried before the backend by the tiered read")
+	flag.IntVar(&readBudget, "read_budget", 0, "Milliseconds of the tiered read budget to try the peers and the backend when the data is missing or stale locally, 0 means disable the tiered read")
+	flag.BoolVar(&verifyRepair, "verify_repair", false, "Repair the discrepancies of the store found by the consistency check after initial sync")
+	flag.IntVar(&verifyInterval, "verify_interval", 0, "Seconds between the periodic consistency checks of the store with the backend, 0 means check only after initial sync")
+	flag.Var(&httpSources, "http_sources", "List of external http json sources in format prefix=url, mounted as read only data subtrees")
+	flag.IntVar(&httpSourceInterval, "http_source_interval", 60, "Seconds between polling the http_sources")
+	flag.Var(&computedKeys, "computed_keys", "List of computed keys in format path=func(source), such as /stats/node_count=count(/nodes), recomputed when the source changed")
+	flag.IntVar(&maxRequestTimeout, "max_request_timeout", 300, "Max seconds of the X-Request-Timeout header of the metadata api requests, 0 means ignore the header")
+	flag.StringVar(&authzURL, "authz_url", "", "The external authorization decision url (such as OPA data api), every metadata and manage api request is authorized by it if present")
+	flag.BoolVar(&authzFailOpen, "authz_fail_open", false, "Allow the requests when the authz_url is unavailable, otherwise respond 503")
+	flag.IntVar(&authzCacheTTL, "authz_cache_ttl", 10, "Seconds to cache the decisions of authz_url, 0 means disable the cache")
+	flag.Float64Var(&sloAvailability, "slo_availability", defaultSLOAvailability, "The availability objective of the metadata and manage api, the ratio of the requests not responding 5xx")
+	flag.IntVar(&sloLatencyMs, "slo_latency_ms", defaultSLOLatencyMs, "Milliseconds of the latency threshold of the latency objective")
+	flag.Float64Var(&sloLatencyTarget, "slo_latency_target", defaultSLOLatencyTarget, "The latency objective of the metadata and manage api, the ratio of the requests responded within slo_latency_ms")
+	flag.StringVar(&renderHost, "render_host", "", "The client ip (or host) of the /self view of the renders")
+	flag.Var(&indexKeys, "index_keys", "List of the data leaf names (such as ip) indexed by value for the reverse lookup of /v1/find")
+	flag.StringVar(&templatePrefix, "template_prefix", "", "The data prefix of the templates rendered by /render/{name} of metadata api against the self subtree, disabled if empty")
+	flag.IntVar(&nodeHistory, "node_history", store.DefaultHistorySize, "The number of the previous values kept by every data leaf, see /v1/data?meta=true, 0 means not keep")
+	flag.StringVar(&changeLogFile, "change_log", "", "The local file appended with the data changes, to read the past data by /v1/data?at=")
+	flag.IntVar(&trashRetention, "trash_retention", 0, "Seconds to keep the data deleted by the manage api in the trash for restore, 0 means delete without trash")
+	flag.BoolVar(&leaderElection, "leader_election", false, "Elect a leader of the metad group by backend, only the leader accept the manage api writes and perform the housekeeping, all metad serve the reads")
+	flag.IntVar(&leaderTTL, "leader_ttl", 10, "Seconds of the leader lease, the standby take over after the leader is gone for leader_ttl")
+	flag.StringVar(&leaderID, "leader_id", "", "The unique name of this metad in the leader election, default the hostname")
+	flag.Var(&encryptionPrefixes, "encryption_prefixes", "List of sensitive data prefixes, the values under them are encrypted in the backend")
+	flag.StringVar(&encryptionKeyFile, "encryption_key_file", "", "The file of the base64 AES key to encrypt the encryption_prefixes, default the METAD_ENCRYPTION_KEY env")
+	flag.Var(&sensitivePrefixes, "sensitive_prefixes", "List of sensitive data prefixes, the values under them are masked in the logs")
+	flag.StringVar(&manageTLSCert, "manage_tls_cert", "", "The server cert of manage listener, enable https if present")
+	flag.StringVar(&manageTLSKey, "manage_tls_key", "", "The server key of manage listener")
+	flag.StringVar(&manageTLSClientCA, "manage_tls_client_ca", "", "The ca to verify client cert of manage listener, verified cert's CN/SAN is the identity of role_bindings")
+	flag.BoolVar(&manageRBAC, "manage_rbac", false, "Enforce the roles of the tokens and role_bindings on the manage api")
+	flag.IntVar(&memoryReportTop, "memory_report_top", 10, "Number of the heaviest data prefixes reported by the memory gauge, 0 means disable the gauge")
+	flag.IntVar(&memoryReportDepth, "memory_report_depth", 1, "Depth of the data prefixes reported by the memory gauge, 1 means the top level prefixes")
+	flag.BoolVar(&typedValues, "typed_values", false, "Keep the json types of the numbers, booleans and nulls written by the manage api, the reads respond them in the same types")
+	flag.StringVar(&adminToken, "admin_token", "", "The bootstrap token to create and delete tokens of token api, in addition to the admin tokens")
 }
 
 func initConfig() (*Config, error) {
-
-	// Set defaults.
-	config := &Config{
-		Backend:      "local",
-		Prefix:       "",
-		Group:        "default",
-		LogLevel:     "info",
-		Listen:       ":9180",
-		ListenManage: "127.0.0.1:9611",
-	}
-	if configFile != "" {
-		err := loadConfigFile(configFile, config)
-		if err != nil {
-			return nil, err
-		}
+	config, err := loadConfig()
+	if err != nil {
+		return nil, err
 	}
 
-	// Update config from commandline flags.
-	processFlags(config)
-
 	if config.LogLevel != "" {
 		println("set log level to:", config.LogLevel)
 		logger.SetLevelByString(config.LogLevel)
 	}
 
+	if config.LogFormat != "" {
+		logger.SetFormat(config.LogFormat)
+	}
+
 	if config.PIDFile != "" {
 		logger.Info("Writing pid %d to %s", os.Getpid(), config.PIDFile)
 		if err := ioutil.WriteFile(config.PIDFile, []byte(strconv.Itoa(os.Getpid())), 0644); err != nil {
@@ -128,6 +393,65 @@ func initConfig() (*Config, error) {
 		}
 	}
 
+	return config, nil
+}
+
+// loadConfig load the config from defaults, the config file and the command line flags in order.
+func loadConfig() (*Config, error) {
+
+	// Set defaults.
+	config := &Config{
+		Backend:         "local",
+		Prefix:          "",
+		Group:           "default",
+		LogLevel:        "info",
+		LogFormat:       "text",
+		Listen:          ":9180",
+		ListenManage:    "127.0.0.1:9611",
+		ListenUnixMode:  "0660",
+		AuditMaxSize:    100,
+		AuditMaxBackups: 5,
+		AuditSIEMBuffer: 10000,
+		ReadyMaxSyncLag: 30,
+
+		NotifyRetries:       3,
+		NotifyRetryInterval: 1,
+
+		DrainTimeout: 30,
+
+		ResponseCacheSize: 1000,
+		CompressMinSize:   1024,
+
+		QuotaMode: QuotaModeReject,
+
+		CacheInterval: 60,
+
+		HTTPSourceInterval: 60,
+
+		MemoryReportTop:   10,
+		MemoryReportDepth: 1,
+
+		MaxRequestTimeout: 300,
+
+		AuthzCacheTTL: 10,
+
+		SLOAvailability:  defaultSLOAvailability,
+		SLOLatencyMs:     defaultSLOLatencyMs,
+		SLOLatencyTarget: defaultSLOLatencyTarget,
+
+		NodeHistory: store.DefaultHistorySize,
+		LeaderTTL:   10,
+	}
+	if configFile != "" {
+		err := loadConfigFile(configFile, config)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Update config from commandline flags.
+	processFlags(config)
+
 	if len(config.BackendNodes) == 0 {
 		config.BackendNodes = backends.GetDefaultBackends(config.Backend)
 	}
@@ -164,6 +488,8 @@ func setConfigFromFlag(config *Config, f *flag.Flag) {
 		config.Backend = backend
 	case "log_level":
 		config.LogLevel = logLevel
+	case "log_format":
+		config.LogFormat = logFormat
 	case "pid_file":
 		config.PIDFile = pidFile
 	case "xff":
@@ -176,6 +502,10 @@ func setConfigFromFlag(config *Config, f *flag.Flag) {
 		config.Listen = listen
 	case "listen_manage":
 		config.ListenManage = listenManage
+	case "listen_unix":
+		config.ListenUnix = listenUnix
+	case "listen_unix_mode":
+		config.ListenUnixMode = listenUnixMode
 	case "basic_auth":
 		config.BasicAuth = basicAuth
 	case "client_cert":
@@ -190,5 +520,137 @@ func setConfigFromFlag(config *Config, f *flag.Flag) {
 		config.Username = username
 	case "password":
 		config.Password = password
+	case "username_file":
+		config.UsernameFile = usernameFile
+	case "password_file":
+		config.PasswordFile = passwordFile
+	case "tls_cert":
+		config.TLSCert = tlsCert
+	case "tls_key":
+		config.TLSKey = tlsKey
+	case "tls_client_ca":
+		config.TLSClientCA = tlsClientCA
+	case "tls_require_client_cert":
+		config.TLSRequireClientCert = tlsRequireClientCert
+	case "audit_file":
+		config.AuditFile = auditFile
+	case "audit_backend":
+		config.AuditBackend = auditBackend
+	case "audit_max_size":
+		config.AuditMaxSize = auditMaxSize
+	case "audit_max_backups":
+		config.AuditMaxBackups = auditMaxBackups
+	case "audit_secret_paths":
+		config.AuditSecretPaths = auditSecretPaths
+	case "audit_siem":
+		config.AuditSIEM = auditSIEM
+	case "audit_siem_buffer":
+		config.AuditSIEMBuffer = auditSIEMBuffer
+	case "audit_siem_access":
+		config.AuditSIEMAccess = auditSIEMAccess
+	case "ready_max_sync_lag":
+		config.ReadyMaxSyncLag = readyMaxSyncLag
+	case "notify_webhooks":
+		config.NotifyWebhooks = notifyWebhooks
+	case "notify_retries":
+		config.NotifyRetries = notifyRetries
+	case "notify_retry_interval":
+		config.NotifyRetryInterval = notifyRetryInterval
+	case "notify_secret":
+		config.NotifySecret = notifySecret
+	case "drain_timeout":
+		config.DrainTimeout = drainTimeout
+	case "rate_limit":
+		config.RateLimit = rateLimit
+	case "rate_limit_burst":
+		config.RateLimitBurst = rateLimitBurst
+	case "client_rate_limit":
+		config.ClientRateLimit = clientRateLimit
+	case "client_rate_limit_burst":
+		config.ClientRateLimitBurst = clientRateLimitBurst
+	case "response_cache_size":
+		config.ResponseCacheSize = responseCacheSize
+	case "compress_min_size":
+		config.CompressMinSize = compressMinSize
+	case "quotas":
+		config.Quotas = quotas
+	case "quota_mode":
+		config.QuotaMode = quotaMode
+	case "cache_file":
+		config.CacheFile = cacheFile
+	case "cache_interval":
+		config.CacheInterval = cacheInterval
+	case "read_peers":
+		config.ReadPeers = readPeers
+	case "trusted_proxies":
+		config.TrustedProxies = trustedProxies
+	case "proxy_protocol":
+		config.ProxyProtocol = proxyProtocol
+	case "read_budget":
+		config.ReadBudget = readBudget
+	case "admin_token":
+		config.AdminToken = adminToken
+	case "verify_repair":
+		config.VerifyRepair = verifyRepair
+	case "verify_interval":
+		config.VerifyInterval = verifyInterval
+	case "http_sources":
+		config.HTTPSources = httpSources
+	case "http_source_interval":
+		config.HTTPSourceInterval = httpSourceInterval
+	case "computed_keys":
+		config.ComputedKeys = computedKeys
+	case "max_request_timeout":
+		config.MaxRequestTimeout = maxRequestTimeout
+	case "authz_url":
+		config.AuthzURL = authzURL
+	case "authz_fail_open":
+		config.AuthzFailOpen = authzFailOpen
+	case "authz_cache_ttl":
+		config.AuthzCacheTTL = authzCacheTTL
+	case "slo_availability":
+		config.SLOAvailability = sloAvailability
+	case "slo_latency_ms":
+		config.SLOLatencyMs = sloLatencyMs
+	case "slo_latency_target":
+		config.SLOLatencyTarget = sloLatencyTarget
+	case "render_host":
+		config.RenderHost = renderHost
+	case "index_keys":
+		config.IndexKeys = indexKeys
+	case "template_prefix":
+		config.TemplatePrefix = templatePrefix
+	case "typed_values":
+		config.TypedValues = typedValues
+	case "node_history":
+		config.NodeHistory = nodeHistory
+	case "change_log":
+		config.ChangeLog = changeLogFile
+	case "trash_retention":
+		config.TrashRetention = trashRetention
+	case "leader_election":
+		config.LeaderElection = leaderElection
+	case "leader_ttl":
+		config.LeaderTTL = leaderTTL
+	case "leader_id":
+		config.LeaderID = leaderID
+	case "encryption_prefixes":
+		config.EncryptionPrefixes = encryptionPrefixes
+	case "encryption_key_file":
+		config.EncryptionKeyFile = encryptionKeyFile
+	case "sensitive_prefixes":
+		config.SensitivePrefixes = sensitivePrefixes
+	case "manage_tls_cert":
+		config.ManageTLSCert = manageTLSCert
+	case "manage_tls_key":
+		config.ManageTLSKey = manageTLSKey
+	case "manage_tls_client_ca":
+		config.ManageTLSClientCA = manageTLSClientCA
+	case "manage_rbac":
+		config.ManageRBAC = manageRBAC
+	case "memory_report_top":
+		config.MemoryReportTop = memoryReportTop
+	case "memory_report_depth":
+		config.MemoryReportDepth = memoryReportDepth
 	}
 }
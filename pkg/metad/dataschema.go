@@ -0,0 +1,63 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metad
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"openpitrix.io/metad/pkg/metadata"
+)
+
+func (m *Metad) dataSchemaPrefixes() []string {
+	prefixes := []string{}
+	for _, s := range m.metadataRepo.GetDataSchemas() {
+		prefixes = append(prefixes, s.Prefix)
+	}
+	return prefixes
+}
+
+func (m *Metad) dataSchemaList(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	return m.metadataRepo.GetDataSchemas(), nil
+}
+
+func (m *Metad) dataSchemaGet(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	s := m.metadataRepo.GetDataSchema(mux.Vars(req)["prefix"])
+	if s == nil {
+		return nil, NewHttpError(http.StatusNotFound, "Not found")
+	}
+	return s, nil
+}
+
+// dataSchemaUpdate register the JSON Schema of the request body to the prefix, the current data of the prefix should
+// match the schema, unless force=true.
+func (m *Metad) dataSchemaUpdate(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	decoder := json.NewDecoder(req.Body)
+	var schema interface{}
+	err := decoder.Decode(&schema)
+	if err != nil {
+		return nil, NewHttpError(http.StatusBadRequest, fmt.Sprintf("invalid json format, error:%s", err.Error()))
+	}
+	s := &metadata.DataSchema{Prefix: mux.Vars(req)["prefix"], Schema: schema}
+	force := strings.ToLower(req.FormValue("force")) == "true"
+	err = m.metadataRepo.PutDataSchema(s, force)
+	if err != nil {
+		return nil, writeError(err, http.StatusBadRequest)
+	}
+	return s, nil
+}
+
+func (m *Metad) dataSchemaDelete(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	err := m.metadataRepo.DeleteDataSchema(mux.Vars(req)["prefix"])
+	if err != nil {
+		return nil, NewServerError(err)
+	}
+	return nil, nil
+}
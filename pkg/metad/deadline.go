@@ -0,0 +1,50 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metad
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RequestTimeoutHeader is the request header of the timeout of the whole metadata api request.
+const RequestTimeoutHeader = "X-Request-Timeout"
+
+var errRequestTimeout = NewHttpError(http.StatusGatewayTimeout, "request timeout exceeded.")
+
+// parseRequestTimeout parse the timeout in seconds (such as 1.5) or Go duration (such as 1500ms).
+func parseRequestTimeout(s string) (time.Duration, error) {
+	timeout, err := time.ParseDuration(s)
+	if err != nil {
+		seconds, ferr := strconv.ParseFloat(s, 64)
+		if ferr != nil {
+			return 0, fmt.Errorf("invalid %s header [%s], should be seconds or duration.", RequestTimeoutHeader, s)
+		}
+		timeout = time.Duration(seconds * float64(time.Second))
+	}
+	if timeout <= 0 {
+		return 0, fmt.Errorf("invalid %s header [%s], should be positive.", RequestTimeoutHeader, s)
+	}
+	return timeout, nil
+}
+
+// requestTimeout return the timeout of the request bounded by max_request_timeout, 0 if not present or ignored.
+func (m *Metad) requestTimeout(req *http.Request) (time.Duration, *HttpError) {
+	s := req.Header.Get(RequestTimeoutHeader)
+	maxTimeout := time.Duration(m.getConfig().MaxRequestTimeout) * time.Second
+	if s == "" || maxTimeout <= 0 {
+		return 0, nil
+	}
+	timeout, err := parseRequestTimeout(s)
+	if err != nil {
+		return 0, NewHttpError(http.StatusBadRequest, err.Error())
+	}
+	if timeout > maxTimeout {
+		timeout = maxTimeout
+	}
+	return timeout, nil
+}
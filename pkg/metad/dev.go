@@ -0,0 +1,220 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metad
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"openpitrix.io/metad/pkg/logger"
+)
+
+// The files of the fixture dir, all are optional.
+const (
+	fixtureDataFile    = "data.yml"
+	fixtureMappingFile = "mapping.yml"
+	fixtureScriptFile  = "script.yml"
+)
+
+// The actions of the fixture script step.
+const (
+	FixtureActionPut           = "put"
+	FixtureActionReplace       = "replace"
+	FixtureActionDelete        = "delete"
+	FixtureActionPutMapping    = "put_mapping"
+	FixtureActionDeleteMapping = "delete_mapping"
+)
+
+// FixtureStep is a timed mutation of the fixture script, it is applied after the previous step.
+type FixtureStep struct {
+	After  time.Duration `yaml:"after"`
+	Action string        `yaml:"action"`
+	Path   string        `yaml:"path"`
+	Value  interface{}   `yaml:"value"`
+}
+
+// FixtureScript is the mutations applied to the fixture in order, restart from the first step if Loop.
+type FixtureScript struct {
+	Loop  bool           `yaml:"loop"`
+	Steps []*FixtureStep `yaml:"steps"`
+}
+
+// Fixture is the metadata tree, the mappings and the script loaded from the fixture dir.
+type Fixture struct {
+	Data    interface{}
+	Mapping interface{}
+	Script  *FixtureScript
+}
+
+// LoadFixture load the fixture from dir, the files are yaml (or json).
+func LoadFixture(dir string) (*Fixture, error) {
+	fixture := &Fixture{Script: &FixtureScript{}}
+	if err := loadFixtureFile(filepath.Join(dir, fixtureDataFile), &fixture.Data); err != nil {
+		return nil, err
+	}
+	if err := loadFixtureFile(filepath.Join(dir, fixtureMappingFile), &fixture.Mapping); err != nil {
+		return nil, err
+	}
+	if err := loadFixtureFile(filepath.Join(dir, fixtureScriptFile), fixture.Script); err != nil {
+		return nil, err
+	}
+	fixture.Data = normalizeYaml(fixture.Data)
+	fixture.Mapping = normalizeYaml(fixture.Mapping)
+	for i, step := range fixture.Script.Steps {
+		switch step.Action {
+		case FixtureActionPut, FixtureActionReplace, FixtureActionPutMapping:
+			if step.Value == nil {
+				return nil, fmt.Errorf("fixture script step %d: value is required by action [%s].", i+1, step.Action)
+			}
+		case FixtureActionDelete, FixtureActionDeleteMapping:
+		default:
+			return nil, fmt.Errorf("fixture script step %d: invalid action [%s].", i+1, step.Action)
+		}
+		step.Value = normalizeYaml(step.Value)
+	}
+	return fixture, nil
+}
+
+// loadFixtureFile unmarshal the file to v, ignore the file not exist.
+func loadFixtureFile(file string, v interface{}) error {
+	b, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := yaml.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("invalid fixture file [%s]: %s", file, err.Error())
+	}
+	return nil
+}
+
+// normalizeYaml convert the yaml value to the value can be put to store,
+// the maps are converted to map[string]interface{}, and the scalars to string.
+func normalizeYaml(v interface{}) interface{} {
+	switch t := v.(type) {
+	case nil:
+		return nil
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, v := range t {
+			m[fmt.Sprint(k)] = normalizeYaml(v)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(t))
+		for i, v := range t {
+			l[i] = normalizeYaml(v)
+		}
+		return l
+	case string:
+		return t
+	default:
+		return fmt.Sprint(t)
+	}
+}
+
+// LoadFixture put the fixture's data and mapping.
+func (m *Metad) LoadFixture(fixture *Fixture) error {
+	if fixture.Data != nil {
+		if err := m.metadataRepo.PutData("/", fixture.Data, true); err != nil {
+			return err
+		}
+	}
+	if fixture.Mapping != nil {
+		if err := m.metadataRepo.PutMapping("/", fixture.Mapping, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RunFixtureScript apply the script's steps in order until finished or metad stopped.
+func (m *Metad) RunFixtureScript(script *FixtureScript) {
+	if len(script.Steps) == 0 {
+		return
+	}
+	for {
+		for _, step := range script.Steps {
+			select {
+			case <-time.After(step.After):
+			case <-m.shutdownChan:
+				return
+			}
+			if err := m.applyFixtureStep(step); err != nil {
+				logger.Error("Apply fixture step %s %s error: %s", step.Action, step.Path, err.Error())
+				continue
+			}
+			logger.Info("Apply fixture step %s %s", step.Action, step.Path)
+		}
+		if !script.Loop {
+			return
+		}
+	}
+}
+
+func (m *Metad) applyFixtureStep(step *FixtureStep) error {
+	switch step.Action {
+	case FixtureActionPut:
+		return m.metadataRepo.PutData(step.Path, step.Value, false)
+	case FixtureActionReplace:
+		return m.metadataRepo.PutData(step.Path, step.Value, true)
+	case FixtureActionDelete:
+		return m.metadataRepo.DeleteData(step.Path)
+	case FixtureActionPutMapping:
+		return m.metadataRepo.PutMapping(step.Path, step.Value, false)
+	case FixtureActionDeleteMapping:
+		return m.metadataRepo.DeleteMapping(step.Path)
+	}
+	return fmt.Errorf("invalid action [%s].", step.Action)
+}
+
+// devMain serve the fixture with the local backend, for integration testing the clients without a backend.
+func devMain(args []string) {
+	flags := flag.NewFlagSet("dev", flag.ExitOnError)
+	fixtureDir := flags.String("fixture", "", "The fixture dir contains data.yml, mapping.yml and script.yml")
+	listen := flags.String("listen", ":9180", "Address to listen to (TCP)")
+	listenManage := flags.String("listen_manage", "127.0.0.1:9611", "Address to listen to for manage requests (TCP)")
+	xff := flags.Bool("xff", false, "X-Forwarded-For header support")
+	logLevel := flags.String("log_level", "info", "Log level for metad print out: debug|info|warning")
+	flags.Parse(args)
+
+	if *fixtureDir == "" {
+		logger.Fatal("The fixture dir is required.")
+	}
+	fixture, err := LoadFixture(*fixtureDir)
+	if err != nil {
+		logger.Fatal("Load fixture error: %s", err.Error())
+	}
+	config, err := loadConfig()
+	if err != nil {
+		logger.Fatal("%v", err)
+	}
+	config.Backend = "local"
+	config.BackendNodes = nil
+	config.Listen = *listen
+	config.ListenManage = *listenManage
+	config.EnableXff = *xff
+	logger.SetLevelByString(*logLevel)
+
+	logger.Info("Starting metad dev server with fixture %s", *fixtureDir)
+	metad, err = New(config)
+	if err != nil {
+		logger.Fatal("%s", err.Error())
+	}
+	metad.Init()
+	if err := metad.LoadFixture(fixture); err != nil {
+		logger.Fatal("Load fixture error: %s", err.Error())
+	}
+	go metad.RunFixtureScript(fixture.Script)
+	metad.Serve()
+}
@@ -0,0 +1,39 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metad
+
+import (
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// encryptionKeyEnv is the env of the encryption key if encryption_key_file is empty.
+const encryptionKeyEnv = "METAD_ENCRYPTION_KEY"
+
+// readEncryptionKey return the AES key of encryption_prefixes from encryption_key_file or the env, nil if no prefix.
+func readEncryptionKey(config *Config) ([]byte, error) {
+	if len(config.EncryptionPrefixes) == 0 {
+		return nil, nil
+	}
+	encoded := os.Getenv(encryptionKeyEnv)
+	if config.EncryptionKeyFile != "" {
+		b, err := ioutil.ReadFile(config.EncryptionKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		encoded = string(b)
+	}
+	if strings.TrimSpace(encoded) == "" {
+		return nil, errors.New("encryption_prefixes require encryption_key_file or " + encryptionKeyEnv + " env.")
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, errors.New("encryption key should be base64 encoded: " + err.Error())
+	}
+	return key, nil
+}
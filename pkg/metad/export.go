@@ -0,0 +1,78 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metad
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"openpitrix.io/metad/pkg/metadata"
+)
+
+// dataExport export the data of the prefix parameter (the whole store if missing) with the version and revision,
+// in the format of the Accept header.
+func (m *Metad) dataExport(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	if httpErr := m.checkRoleRead(req, req.FormValue("prefix")); httpErr != nil {
+		return nil, httpErr
+	}
+	export, err := m.metadataRepo.ExportData(req.FormValue("prefix"))
+	if err != nil {
+		return nil, NewHttpError(http.StatusNotFound, err.Error())
+	}
+	return export, nil
+}
+
+// dataImport import the export of the request body, in json or yaml by the Content-Type, to the prefix parameter
+// (the exported prefix if missing). mode=replace replace the data of the prefix, the default mode=merge merge to it.
+func (m *Metad) dataImport(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	mode := strings.ToLower(req.FormValue("mode"))
+	if mode == "" {
+		mode = "merge"
+	}
+	if mode != "merge" && mode != "replace" {
+		return nil, NewHttpError(http.StatusBadRequest, fmt.Sprintf("invalid import mode [%s], should be merge or replace.", mode))
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, NewHttpError(http.StatusBadRequest, err.Error())
+	}
+	var export metadata.DataExport
+	if strings.Contains(requestMediaType(req), "yaml") {
+		err = yaml.Unmarshal(body, &export)
+		export.Data = normalizeYaml(export.Data)
+	} else {
+		err = json.Unmarshal(body, &export)
+	}
+	if err != nil {
+		return nil, NewHttpError(http.StatusBadRequest, fmt.Sprintf("invalid import format, error:%s", err.Error()))
+	}
+	if export.Data == nil {
+		return nil, NewHttpError(http.StatusBadRequest, "import data should not be empty.")
+	}
+	prefix := req.FormValue("prefix")
+	if prefix == "" {
+		prefix = export.Prefix
+	}
+	prefix = path.Join("/", prefix)
+	if httpErr := m.authorizeWrite(ctx, req, "import", prefix); httpErr != nil {
+		return nil, httpErr
+	}
+	replace := mode == "replace"
+	untrack := m.metadataRepo.TrackPut(m.requestActor(req), prefix, export.Data, replace)
+	count, err := m.metadataRepo.ImportData(prefix, export.Data, replace)
+	if err != nil {
+		untrack()
+		return nil, writeError(err, http.StatusBadRequest)
+	}
+	requestLogger(ctx).Info("Import data to [%s], mode: %s, keys: %d", prefix, mode, count)
+	return map[string]interface{}{"prefix": prefix, "mode": mode, "keys": count}, nil
+}
@@ -0,0 +1,48 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metad
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// FindResult is the response of /v1/find.
+type FindResult struct {
+	Key   string   `json:"key"`
+	Value string   `json:"value"`
+	Paths []string `json:"paths"`
+}
+
+func checkIndexKeys(config *Config) error {
+	for _, key := range config.IndexKeys {
+		if key == "" || strings.Contains(key, "/") {
+			return fmt.Errorf("invalid index key [%s], should be a leaf name such as ip.", key)
+		}
+	}
+	return nil
+}
+
+// dataFind return the data paths of the leaves named key with the value, by the inverted index of index_keys.
+func (m *Metad) dataFind(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	key := req.FormValue("key")
+	if key == "" {
+		return nil, NewHttpError(http.StatusBadRequest, "key is required.")
+	}
+	if _, ok := req.Form["value"]; !ok {
+		return nil, NewHttpError(http.StatusBadRequest, "value is required.")
+	}
+	value := req.FormValue("value")
+	if httpErr := m.checkRoleRead(req, req.FormValue("prefix")); httpErr != nil {
+		return nil, httpErr
+	}
+	paths, ok := m.metadataRepo.FindData(key, value, req.FormValue("prefix"))
+	if !ok {
+		return nil, NewHttpError(http.StatusBadRequest, fmt.Sprintf("key [%s] is not indexed, indexed keys: %v.", key, m.metadataRepo.IndexKeys()))
+	}
+	return &FindResult{Key: key, Value: value, Paths: paths}, nil
+}
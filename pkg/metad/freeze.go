@@ -0,0 +1,82 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metad
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"openpitrix.io/metad/pkg/metadata"
+)
+
+// checkFreeze reject the manage requests may mutate if the writes are frozen, respond 423 with the freeze reason
+// and owner. The reads, the dry runs and the freeze api itself are allowed.
+func (m *Metad) checkFreeze(req *http.Request) *HttpError {
+	switch strings.ToUpper(req.Method) {
+	case "GET", "HEAD", "OPTIONS":
+		return nil
+	}
+	if req.URL.Path == "/v1/freeze" || req.URL.Path == backendEndpointsPath || isDryRun(req) {
+		return nil
+	}
+	if err := m.metadataRepo.CheckFreeze(); err != nil {
+		return writeError(err, http.StatusLocked)
+	}
+	return nil
+}
+
+func (m *Metad) freezeGet(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	f := m.metadataRepo.GetFreeze()
+	if f == nil {
+		return nil, NewHttpError(http.StatusNotFound, "Not frozen")
+	}
+	return f, nil
+}
+
+// freezeUpdate freeze the writes, the request is {"reason": $reason, "owner": $owner, "ttl": 3600} or with "expire_at"
+// unix time in place of ttl seconds, the owner is the requester's identity if empty.
+func (m *Metad) freezeUpdate(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	var body struct {
+		metadata.Freeze
+		TTL int64 `json:"ttl"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		return nil, NewHttpError(http.StatusBadRequest, fmt.Sprintf("invalid json format, error:%s", err.Error()))
+	}
+	f := &body.Freeze
+	if body.TTL > 0 {
+		if f.ExpireAt > 0 {
+			return nil, NewHttpError(http.StatusBadRequest, "ttl and expire_at should not be both present.")
+		}
+		f.ExpireAt = time.Now().Unix() + body.TTL
+	}
+	if f.ExpireAt == 0 {
+		return nil, NewHttpError(http.StatusBadRequest, "freeze should have a deadline, ttl or expire_at.")
+	}
+	if f.Owner == "" {
+		f.Owner = strings.TrimPrefix(m.requestActor(req), "manage:")
+	}
+	if err := m.metadataRepo.PutFreeze(f); err != nil {
+		return nil, NewHttpError(http.StatusBadRequest, err.Error())
+	}
+	requestLogger(ctx).Warn("Freeze writes by [%s] until %s: %s", f.Owner, time.Unix(f.ExpireAt, 0).UTC().Format(time.RFC3339), f.Reason)
+	return f, nil
+}
+
+func (m *Metad) freezeDelete(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	f := m.metadataRepo.GetFreeze()
+	if f == nil {
+		return nil, NewHttpError(http.StatusNotFound, "Not frozen")
+	}
+	if err := m.metadataRepo.DeleteFreeze(); err != nil {
+		return nil, NewServerError(err)
+	}
+	requestLogger(ctx).Warn("Lift the freeze of [%s]: %s", f.Owner, f.Reason)
+	return nil, nil
+}
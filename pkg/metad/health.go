@@ -0,0 +1,52 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metad
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// healthzHandler report the process is up.
+func (m *Metad) healthzHandler(w http.ResponseWriter, req *http.Request) {
+	writeStatus(w, http.StatusOK, map[string]interface{}{"status": "up"})
+}
+
+// readyzHandler report whether metad is ready to serve, the backend should have been synced at least once,
+// and the sync lag should not exceed the ready_max_sync_lag, and not shutting down.
+func (m *Metad) readyzHandler(w http.ResponseWriter, req *http.Request) {
+	synced, lag, err := m.metadataRepo.SyncStatus()
+	status := map[string]interface{}{
+		"status":      "ready",
+		"synced":      synced,
+		"sync_lag_ms": int64(lag / time.Millisecond),
+	}
+	maxLag := time.Duration(m.getConfig().ReadyMaxSyncLag) * time.Second
+	switch {
+	case m.shuttingDown():
+		status["reason"] = "metad is shutting down"
+	case err != nil:
+		status["reason"] = fmt.Sprintf("check backend sync error: %s", err.Error())
+	case !synced:
+		status["reason"] = "backend has not been synced"
+	case maxLag > 0 && lag > maxLag:
+		status["reason"] = fmt.Sprintf("backend sync lag exceeds %s", maxLag)
+	}
+	if _, ok := status["reason"]; ok {
+		status["status"] = "not_ready"
+		writeStatus(w, http.StatusServiceUnavailable, status)
+		return
+	}
+	writeStatus(w, http.StatusOK, status)
+}
+
+func writeStatus(w http.ResponseWriter, code int, status map[string]interface{}) {
+	result, _ := json.Marshal(status)
+	w.Header().Set("Content-Type", ContentTypeJSON)
+	w.WriteHeader(code)
+	w.Write(result)
+}
@@ -0,0 +1,190 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metad
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"openpitrix.io/metad/pkg/logger"
+)
+
+// maxHTTPSourceSize is the max size of the http source response, the sources are for small amounts of data.
+const maxHTTPSourceSize = 10 * 1024 * 1024
+
+var httpSourceClient = &http.Client{Timeout: 10 * time.Second}
+
+// httpSource is an external http json endpoint mounted as a read only data subtree,
+// the last fetched value is kept if the endpoint is unavailable.
+type httpSource struct {
+	prefix       string
+	url          string
+	etag         string
+	lastModified string
+	fetchedAt    int64
+	updatedAt    int64
+	err          string
+	lock         sync.Mutex
+}
+
+// parseHTTPSource parse the http source in format prefix=url.
+func parseHTTPSource(s string) (*httpSource, error) {
+	kv := strings.SplitN(s, "=", 2)
+	if len(kv) != 2 {
+		return nil, fmt.Errorf("invalid http source [%s], should be prefix=url.", s)
+	}
+	prefix := path.Join("/", strings.TrimSpace(kv[0]))
+	if prefix == "/" {
+		return nil, fmt.Errorf("invalid http source [%s], can not mount to root path.", s)
+	}
+	u, err := url.Parse(strings.TrimSpace(kv[1]))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid http source [%s], url should be http or https.", s)
+	}
+	return &httpSource{prefix: prefix, url: u.String()}, nil
+}
+
+func configHTTPSources(config *Config) ([]*httpSource, error) {
+	sources := make([]*httpSource, 0, len(config.HTTPSources))
+	for _, s := range config.HTTPSources {
+		source, err := parseHTTPSource(s)
+		if err != nil {
+			return nil, err
+		}
+		for _, other := range sources {
+			if source.prefix == other.prefix || strings.HasPrefix(source.prefix, other.prefix+"/") || strings.HasPrefix(other.prefix, source.prefix+"/") {
+				return nil, fmt.Errorf("http source prefix [%s] overlaps [%s].", source.prefix, other.prefix)
+			}
+		}
+		sources = append(sources, source)
+	}
+	return sources, nil
+}
+
+// fetch get the value of the source, return nil if not modified since the last fetch.
+func (s *httpSource) fetch(ctx context.Context) (interface{}, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", s.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	s.lock.Lock()
+	if s.etag != "" {
+		req.Header.Set("If-None-Match", s.etag)
+	}
+	if s.lastModified != "" {
+		req.Header.Set("If-Modified-Since", s.lastModified)
+	}
+	s.lock.Unlock()
+	resp, err := httpSourceClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	decoder := json.NewDecoder(io.LimitReader(resp.Body, maxHTTPSourceSize))
+	decoder.UseNumber()
+	var val interface{}
+	if err := decoder.Decode(&val); err != nil {
+		return nil, fmt.Errorf("invalid json format, error:%s", err.Error())
+	}
+	if val == nil {
+		return nil, errors.New("source value is null.")
+	}
+	s.lock.Lock()
+	s.etag = resp.Header.Get("ETag")
+	s.lastModified = resp.Header.Get("Last-Modified")
+	s.lock.Unlock()
+	return val, nil
+}
+
+func (s *httpSource) status() map[string]interface{} {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return map[string]interface{}{
+		"prefix":     s.prefix,
+		"url":        s.url,
+		"etag":       s.etag,
+		"fetched_at": s.fetchedAt,
+		"updated_at": s.updatedAt,
+		"error":      s.err,
+	}
+}
+
+// pollHTTPSource fetch the source every http_source_interval until metad stopped.
+func (m *Metad) pollHTTPSource(source *httpSource) {
+	interval := time.Duration(m.getConfig().HTTPSourceInterval) * time.Second
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		m.fetchHTTPSource(source)
+		select {
+		case <-ticker.C:
+		case <-m.shutdownChan:
+			return
+		}
+	}
+}
+
+func (m *Metad) fetchHTTPSource(source *httpSource) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-m.shutdownChan:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	val, err := source.fetch(ctx)
+	now := time.Now().Unix()
+	source.lock.Lock()
+	source.fetchedAt = now
+	if err != nil {
+		source.err = err.Error()
+	} else {
+		source.err = ""
+	}
+	source.lock.Unlock()
+	if err != nil {
+		logger.Warn("Fetch http source [%s] error: %s, keep the last value.", source.url, err.Error())
+		return
+	}
+	if val == nil {
+		return
+	}
+	updated, deleted := m.metadataRepo.PutMountedData(source.prefix, val)
+	source.lock.Lock()
+	source.updatedAt = now
+	source.lock.Unlock()
+	if updated+deleted > 0 {
+		logger.Info("Reload http source [%s] to [%s], updated: %d, deleted: %d", source.url, source.prefix, updated, deleted)
+	}
+}
+
+func (m *Metad) sourceList(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	result := make([]map[string]interface{}, 0, len(m.sources))
+	for _, source := range m.sources {
+		result = append(result, source.status())
+	}
+	return result, nil
+}
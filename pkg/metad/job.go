@@ -0,0 +1,518 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metad
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"openpitrix.io/metad/pkg/logger"
+	"openpitrix.io/metad/pkg/metadata"
+)
+
+const (
+	JobTypeDelete = "delete"
+	JobTypeImport = "import"
+	JobTypeExport = "export"
+	JobTypeMove   = "move"
+	JobTypeCopy   = "copy"
+
+	JobStatusRunning   = "running"
+	JobStatusSucceeded = "succeeded"
+	JobStatusFailed    = "failed"
+	JobStatusCanceled  = "canceled"
+
+	// maxFinishedJobs is the number of finished jobs kept for query.
+	maxFinishedJobs = 100
+	// maxJobLogs is the number of recent log lines kept per job.
+	maxJobLogs = 1000
+
+	defaultBatchSize = 100
+	defaultInterval  = 100
+)
+
+// Job is an asynchronous manage operation, Done and Total are the progress.
+type Job struct {
+	ID         string `json:"id"`
+	Type       string `json:"type"`
+	Path       string `json:"path,omitempty"`
+	From       string `json:"from,omitempty"`
+	To         string `json:"to,omitempty"`
+	Status     string `json:"status"`
+	Done       int    `json:"done"`
+	Total      int    `json:"total"`
+	Error      string `json:"error,omitempty"`
+	Actor      string `json:"actor"`
+	CreatedAt  int64  `json:"created_at"`
+	FinishedAt int64  `json:"finished_at,omitempty"`
+
+	logs   []string
+	result interface{}
+	cancel context.CancelFunc
+}
+
+// jobRequest submit a job, the fields used depend on the job type, BatchSize and Interval (milliseconds) throttle the
+// delete and import job.
+type jobRequest struct {
+	Type      string      `json:"type"`
+	Path      string      `json:"path"`
+	From      string      `json:"from"`
+	To        string      `json:"to"`
+	Data      interface{} `json:"data"`
+	BatchSize int         `json:"batch_size"`
+	Interval  int         `json:"interval"`
+	metadata.CopyOptions
+}
+
+func (r *jobRequest) batch() (int, time.Duration) {
+	batchSize := r.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultBatchSize
+	}
+	interval := r.Interval
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+	return batchSize, time.Duration(interval) * time.Millisecond
+}
+
+// jobRunner run the job until finished or ctx done, report the progress, logs and result by jobContext.
+type jobRunner func(ctx context.Context, jc *jobContext) error
+
+type jobContext struct {
+	jm  *jobManager
+	job *Job
+}
+
+func (jc *jobContext) progress(done, total int) {
+	jc.jm.lock.Lock()
+	jc.job.Done = done
+	jc.job.Total = total
+	jc.jm.lock.Unlock()
+}
+
+func (jc *jobContext) log(format string, args ...interface{}) {
+	line := time.Now().Format(time.RFC3339) + " " + fmt.Sprintf(format, args...)
+	jc.jm.lock.Lock()
+	jc.job.logs = append(jc.job.logs, line)
+	if len(jc.job.logs) > maxJobLogs {
+		jc.job.logs = jc.job.logs[len(jc.job.logs)-maxJobLogs:]
+	}
+	jc.jm.lock.Unlock()
+	logger.Debug("Job %s %s", jc.job.ID, line)
+}
+
+func (jc *jobContext) setResult(result interface{}) {
+	jc.jm.lock.Lock()
+	jc.job.result = result
+	jc.jm.lock.Unlock()
+}
+
+// jobManager keep the running jobs and the recent finished jobs in memory.
+type jobManager struct {
+	jobs   map[string]*Job
+	order  []string
+	nextID int64
+	lock   sync.Mutex
+	wg     sync.WaitGroup
+}
+
+func newJobManager() *jobManager {
+	return &jobManager{jobs: make(map[string]*Job)}
+}
+
+// start run the job in background.
+func (jm *jobManager) start(job *Job, run jobRunner) *Job {
+	ctx, cancel := context.WithCancel(context.Background())
+	jm.lock.Lock()
+	jm.nextID++
+	job.ID = fmt.Sprintf("job-%d", jm.nextID)
+	job.Status = JobStatusRunning
+	job.CreatedAt = time.Now().Unix()
+	job.cancel = cancel
+	jm.jobs[job.ID] = job
+	jm.order = append(jm.order, job.ID)
+	jm.gc()
+	snapshot := *job
+	jm.lock.Unlock()
+
+	jm.wg.Add(1)
+	go func() {
+		defer jm.wg.Done()
+		defer cancel()
+		jc := &jobContext{jm: jm, job: job}
+		jc.log("%s job started by %s.", job.Type, job.Actor)
+		err := run(ctx, jc)
+		var status string
+		switch {
+		case err == nil:
+			status = JobStatusSucceeded
+			jc.log("job succeeded.")
+		case ctx.Err() != nil:
+			status = JobStatusCanceled
+			jc.log("job canceled.")
+		default:
+			status = JobStatusFailed
+			jc.log("job failed: %s", err.Error())
+		}
+		jm.lock.Lock()
+		defer jm.lock.Unlock()
+		job.Status = status
+		job.FinishedAt = time.Now().Unix()
+		if status == JobStatusFailed {
+			job.Error = err.Error()
+		}
+		logger.Info("Job %s %s %s, %d/%d done.", job.ID, job.Type, job.Status, job.Done, job.Total)
+	}()
+	return &snapshot
+}
+
+// gc remove the oldest finished jobs exceed maxFinishedJobs, require lock held.
+func (jm *jobManager) gc() {
+	finished := 0
+	for _, id := range jm.order {
+		if jm.jobs[id].Status != JobStatusRunning {
+			finished++
+		}
+	}
+	order := jm.order[:0]
+	for _, id := range jm.order {
+		if finished > maxFinishedJobs && jm.jobs[id].Status != JobStatusRunning {
+			delete(jm.jobs, id)
+			finished--
+			continue
+		}
+		order = append(order, id)
+	}
+	jm.order = order
+}
+
+func (jm *jobManager) list() []*Job {
+	jm.lock.Lock()
+	defer jm.lock.Unlock()
+	jobs := make([]*Job, 0, len(jm.order))
+	for _, id := range jm.order {
+		snapshot := *jm.jobs[id]
+		jobs = append(jobs, &snapshot)
+	}
+	return jobs
+}
+
+// get return a snapshot of the job, nil if not found.
+func (jm *jobManager) get(id string) *Job {
+	jm.lock.Lock()
+	defer jm.lock.Unlock()
+	job, ok := jm.jobs[id]
+	if !ok {
+		return nil
+	}
+	snapshot := *job
+	snapshot.logs = append([]string{}, job.logs...)
+	return &snapshot
+}
+
+// cancel cancel the running job, return false if the job not found.
+func (jm *jobManager) cancel(id string) bool {
+	jm.lock.Lock()
+	defer jm.lock.Unlock()
+	job, ok := jm.jobs[id]
+	if !ok {
+		return false
+	}
+	job.cancel()
+	return true
+}
+
+// stop cancel all running jobs and wait them quit.
+func (jm *jobManager) stop() {
+	jm.lock.Lock()
+	for _, job := range jm.jobs {
+		job.cancel()
+	}
+	jm.lock.Unlock()
+	jm.wg.Wait()
+}
+
+// jobList list the jobs, only the jobs whose paths are readable by the request's roles.
+func (m *Metad) jobList(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	jobs := m.jobs.list()
+	readable := jobs[:0]
+	for _, job := range jobs {
+		if m.checkRoleRead(req, jobReadPaths(job)...) == nil {
+			readable = append(readable, job)
+		}
+	}
+	return readable, nil
+}
+
+func (m *Metad) getJob(req *http.Request) (*Job, *HttpError) {
+	id := mux.Vars(req)["id"]
+	job := m.jobs.get(id)
+	if job == nil {
+		return nil, NewHttpError(http.StatusNotFound, fmt.Sprintf("job [%s] not found.", id))
+	}
+	return job, nil
+}
+
+func (m *Metad) jobGet(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	job, httpErr := m.getJob(req)
+	if httpErr != nil {
+		return nil, httpErr
+	}
+	if httpErr := m.checkRoleRead(req, jobReadPaths(job)...); httpErr != nil {
+		return nil, httpErr
+	}
+	return job, nil
+}
+
+func (m *Metad) jobLog(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	job, httpErr := m.getJob(req)
+	if httpErr != nil {
+		return nil, httpErr
+	}
+	if httpErr := m.checkRoleRead(req, jobReadPaths(job)...); httpErr != nil {
+		return nil, httpErr
+	}
+	return job.logs, nil
+}
+
+func (m *Metad) jobResult(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	job, httpErr := m.getJob(req)
+	if httpErr != nil {
+		return nil, httpErr
+	}
+	if job.result == nil {
+		return nil, NewHttpError(http.StatusNotFound, fmt.Sprintf("job [%s] has no result.", job.ID))
+	}
+	if httpErr := m.checkRoleRead(req, jobReadPaths(job)...); httpErr != nil {
+		return nil, httpErr
+	}
+	return job.result, nil
+}
+
+func (m *Metad) jobCreate(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	decoder := json.NewDecoder(req.Body)
+	var jobReq jobRequest
+	err := decoder.Decode(&jobReq)
+	if err != nil {
+		return nil, NewHttpError(http.StatusBadRequest, fmt.Sprintf("invalid json format, error:%s", err.Error()))
+	}
+	if m.shuttingDown() {
+		return nil, errShuttingDown
+	}
+	job := &Job{Type: jobReq.Type, Actor: m.requestActor(req)}
+	var run jobRunner
+	var httpErr *HttpError
+	switch jobReq.Type {
+	case JobTypeDelete:
+		run, httpErr = m.deleteJob(ctx, req, job, &jobReq)
+	case JobTypeImport:
+		run, httpErr = m.importJob(ctx, req, job, &jobReq)
+	case JobTypeExport:
+		run, httpErr = m.exportJob(ctx, req, job, &jobReq)
+	case JobTypeMove:
+		run, httpErr = m.moveJob(ctx, req, job, &jobReq)
+	case JobTypeCopy:
+		run, httpErr = m.copyJob(ctx, req, job, &jobReq)
+	default:
+		httpErr = NewHttpError(http.StatusBadRequest, fmt.Sprintf("invalid job type [%s].", jobReq.Type))
+	}
+	if httpErr != nil {
+		return nil, httpErr
+	}
+	return m.jobs.start(job, run), nil
+}
+
+// jobCancel cancel the job, the request should be allowed to write the paths of the job.
+func (m *Metad) jobCancel(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	job, httpErr := m.getJob(req)
+	if httpErr != nil {
+		return nil, httpErr
+	}
+	if httpErr := m.authorizeWrite(ctx, req, "cancel", jobWritePaths(job)...); httpErr != nil {
+		return nil, httpErr
+	}
+	id := job.ID
+	if !m.jobs.cancel(id) {
+		return nil, NewHttpError(http.StatusNotFound, fmt.Sprintf("job [%s] not found.", id))
+	}
+	return nil, nil
+}
+
+// jobReadPaths return the data paths the result of the job is read from.
+func jobReadPaths(job *Job) []string {
+	var paths []string
+	for _, p := range []string{job.Path, job.From} {
+		if p != "" {
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
+
+// jobWritePaths return the data paths the job reads or writes, the to path of the move and copy job included.
+func jobWritePaths(job *Job) []string {
+	paths := jobReadPaths(job)
+	if job.To != "" {
+		paths = append(paths, job.To)
+	}
+	return paths
+}
+
+func jobPath(nodePath string) (string, *HttpError) {
+	if nodePath == "" {
+		return "", NewHttpError(http.StatusBadRequest, "job path should not be empty.")
+	}
+	return path.Join("/", nodePath), nil
+}
+
+// deleteJob delete the subtree in throttled batches, see MetadataRepo.BulkDelete.
+func (m *Metad) deleteJob(ctx context.Context, req *http.Request, job *Job, jobReq *jobRequest) (jobRunner, *HttpError) {
+	nodePath, httpErr := jobPath(jobReq.Path)
+	if httpErr != nil {
+		return nil, httpErr
+	}
+	if httpErr := m.authorizeWrite(ctx, req, "delete", nodePath); httpErr != nil {
+		return nil, httpErr
+	}
+	job.Path = nodePath
+	batchSize, interval := jobReq.batch()
+	return func(ctx context.Context, jc *jobContext) error {
+		// the batches are deleted permanently, so the whole subtree is copied to the trash first, it is kept even if
+		// the job is canceled or failed, and restored by overwrite.
+		if m.trashEnabled() {
+			entry, err := m.metadataRepo.TrashData(job.Actor, nodePath)
+			if err != nil {
+				return err
+			}
+			if entry != nil {
+				jc.log("moved %v to trash [%s].", entry.Paths, entry.ID)
+			}
+		}
+		untrack := m.metadataRepo.TrackDelete(job.Actor, nodePath)
+		err := m.metadataRepo.BulkDelete(ctx, nodePath, batchSize, interval, func(done, total int) {
+			jc.progress(done, total)
+			jc.log("deleted %d/%d keys.", done, total)
+		})
+		if err != nil {
+			untrack()
+		}
+		return err
+	}, nil
+}
+
+// importJob merge the data to the path in throttled batches, see MetadataRepo.BulkPut.
+func (m *Metad) importJob(ctx context.Context, req *http.Request, job *Job, jobReq *jobRequest) (jobRunner, *HttpError) {
+	nodePath, httpErr := jobPath(jobReq.Path)
+	if httpErr != nil {
+		return nil, httpErr
+	}
+	if jobReq.Data == nil {
+		return nil, NewHttpError(http.StatusBadRequest, "job data should not be null.")
+	}
+	if httpErr := m.authorizeWrite(ctx, req, "update", nodePath); httpErr != nil {
+		return nil, httpErr
+	}
+	job.Path = nodePath
+	data := jobReq.Data
+	batchSize, interval := jobReq.batch()
+	return func(ctx context.Context, jc *jobContext) error {
+		untrack := m.metadataRepo.TrackActor(job.Actor, nodePath)
+		err := m.metadataRepo.BulkPut(ctx, nodePath, data, batchSize, interval, func(done, total int) {
+			jc.progress(done, total)
+			jc.log("imported %d/%d keys.", done, total)
+		})
+		if err != nil {
+			untrack()
+		}
+		return err
+	}, nil
+}
+
+// exportJob snapshot the subtree, the data is got by GET /v1/job/{id}/result.
+func (m *Metad) exportJob(ctx context.Context, req *http.Request, job *Job, jobReq *jobRequest) (jobRunner, *HttpError) {
+	nodePath, httpErr := jobPath(jobReq.Path)
+	if httpErr != nil {
+		return nil, httpErr
+	}
+	if httpErr := m.checkRoleRead(req, nodePath); httpErr != nil {
+		return nil, httpErr
+	}
+	job.Path = nodePath
+	return func(ctx context.Context, jc *jobContext) error {
+		jc.progress(0, 1)
+		data := m.metadataRepo.GetData(nodePath)
+		if data == nil {
+			return fmt.Errorf("path [%s] not found.", nodePath)
+		}
+		jc.setResult(data)
+		jc.progress(1, 1)
+		jc.log("exported %s.", nodePath)
+		return nil
+	}, nil
+}
+
+// moveJob move the subtree and update the mappings linked to it, see MetadataRepo.MoveData.
+func (m *Metad) moveJob(ctx context.Context, req *http.Request, job *Job, jobReq *jobRequest) (jobRunner, *HttpError) {
+	if jobReq.From == "" || jobReq.To == "" {
+		return nil, NewHttpError(http.StatusBadRequest, "job from and to should not be empty.")
+	}
+	if httpErr := m.authorizeWrite(ctx, req, "move", jobReq.From, jobReq.To); httpErr != nil {
+		return nil, httpErr
+	}
+	job.From, job.To = jobReq.From, jobReq.To
+	return func(ctx context.Context, jc *jobContext) error {
+		jc.progress(0, 1)
+		untrackFrom := m.metadataRepo.TrackDelete(job.Actor, job.From)
+		untrackTo := m.metadataRepo.TrackActor(job.Actor, job.To)
+		mappings, err := m.metadataRepo.MoveData(job.From, job.To)
+		if err != nil {
+			untrackFrom()
+			untrackTo()
+			return err
+		}
+		for _, mapping := range mappings {
+			jc.log("updated mapping %s.", mapping)
+		}
+		jc.setResult(map[string]interface{}{"from": job.From, "to": job.To, "mappings": mappings})
+		jc.progress(1, 1)
+		return nil
+	}, nil
+}
+
+// copyJob copy the subtree with the copy options, see MetadataRepo.CopyData.
+func (m *Metad) copyJob(ctx context.Context, req *http.Request, job *Job, jobReq *jobRequest) (jobRunner, *HttpError) {
+	if jobReq.From == "" || jobReq.To == "" {
+		return nil, NewHttpError(http.StatusBadRequest, "job from and to should not be empty.")
+	}
+	if httpErr := m.checkRoleRead(req, jobReq.From); httpErr != nil {
+		return nil, httpErr
+	}
+	if httpErr := m.authorizeWrite(ctx, req, "copy", jobReq.To); httpErr != nil {
+		return nil, httpErr
+	}
+	job.From, job.To = jobReq.From, jobReq.To
+	options := jobReq.CopyOptions
+	return func(ctx context.Context, jc *jobContext) error {
+		jc.progress(0, 1)
+		untrack := m.metadataRepo.TrackActor(job.Actor, job.To)
+		count, err := m.metadataRepo.CopyData(job.From, job.To, &options)
+		if err != nil {
+			untrack()
+			return err
+		}
+		jc.log("copied %d keys.", count)
+		jc.setResult(map[string]interface{}{"from": job.From, "to": job.To, "keys": count})
+		jc.progress(1, 1)
+		return nil
+	}, nil
+}
@@ -0,0 +1,317 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metad
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"unicode/utf8"
+)
+
+// jsonStreamBufferSize is the bytes buffered before written to the response, the errors before the first write can
+// still be responded as error.
+const jsonStreamBufferSize = 32 * 1024
+
+const hexDigits = "0123456789abcdef"
+
+var errUnsupportedFloat = errors.New("json: unsupported value NaN or Inf")
+
+// jsonStream write the json of the result tree directly to the writer, the same bytes as json.Marshal (or
+// json.MarshalIndent if pretty) but without the reflection and the whole response in memory. The encoders, their
+// buffers and the key slices of the maps are reused, the values of other types are encoded by json.Marshal.
+type jsonStream struct {
+	w       io.Writer
+	buf     []byte
+	written int
+	pretty  bool
+	depth   int
+	// keys is the reused key slices of the maps by depth.
+	keys [][]string
+	err  error
+	// closed is set if the writer failed, such as the client gone, the rest is dropped.
+	closed bool
+}
+
+var jsonStreamPool = sync.Pool{
+	New: func() interface{} {
+		return &jsonStream{buf: make([]byte, 0, jsonStreamBufferSize)}
+	},
+}
+
+// writeJSON write the json of val to w, return the bytes written, and whether any byte is written when the value can
+// not be encoded, the errors of the writer are ignored as the other responses.
+func writeJSON(w io.Writer, val interface{}, pretty bool) (int, bool, error) {
+	e := jsonStreamPool.Get().(*jsonStream)
+	e.w, e.pretty = w, pretty
+	e.encode(val)
+	if e.err == nil {
+		e.flush()
+	}
+	n, err := e.written, e.err
+	e.w, e.buf, e.written, e.depth, e.err, e.closed = nil, e.buf[:0], 0, 0, nil, false
+	jsonStreamPool.Put(e)
+	return n, n > 0, err
+}
+
+func (e *jsonStream) flush() {
+	if len(e.buf) == 0 || e.closed {
+		return
+	}
+	n, err := e.w.Write(e.buf)
+	e.written += n
+	e.buf = e.buf[:0]
+	if err != nil {
+		e.closed = true
+	}
+}
+
+func (e *jsonStream) encode(v interface{}) {
+	if e.err != nil || e.closed {
+		return
+	}
+	if len(e.buf) >= jsonStreamBufferSize {
+		e.flush()
+	}
+	switch t := v.(type) {
+	case nil:
+		e.buf = append(e.buf, "null"...)
+	case string:
+		e.writeString(t)
+	case bool:
+		e.buf = strconv.AppendBool(e.buf, t)
+	case int:
+		e.buf = strconv.AppendInt(e.buf, int64(t), 10)
+	case int64:
+		e.buf = strconv.AppendInt(e.buf, t, 10)
+	case float64:
+		e.writeFloat(t)
+	case map[string]interface{}:
+		if t == nil {
+			e.buf = append(e.buf, "null"...)
+			return
+		}
+		keys := e.sortedKeys(len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		e.openValue('{', len(keys))
+		for i, k := range keys {
+			e.writeKey(i, k)
+			e.encode(t[k])
+		}
+		e.closeValue('}', len(keys))
+		e.releaseKeys(keys)
+	case map[string]string:
+		if t == nil {
+			e.buf = append(e.buf, "null"...)
+			return
+		}
+		keys := e.sortedKeys(len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		e.openValue('{', len(keys))
+		for i, k := range keys {
+			e.writeKey(i, k)
+			e.writeString(t[k])
+		}
+		e.closeValue('}', len(keys))
+		e.releaseKeys(keys)
+	case *orderedDir:
+		e.openValue('{', len(t.names))
+		for i, k := range t.names {
+			e.writeKey(i, k)
+			e.encode(t.values[k])
+		}
+		e.closeValue('}', len(t.names))
+	case []interface{}:
+		if t == nil {
+			e.buf = append(e.buf, "null"...)
+			return
+		}
+		e.openValue('[', len(t))
+		for i, item := range t {
+			e.writeElem(i)
+			e.encode(item)
+		}
+		e.closeValue(']', len(t))
+	case []string:
+		if t == nil {
+			e.buf = append(e.buf, "null"...)
+			return
+		}
+		e.openValue('[', len(t))
+		for i, item := range t {
+			e.writeElem(i)
+			e.writeString(item)
+		}
+		e.closeValue(']', len(t))
+	default:
+		e.writeMarshal(v)
+	}
+}
+
+// sortedKeys return the reused key slice of the current depth.
+func (e *jsonStream) sortedKeys(size int) []string {
+	for len(e.keys) <= e.depth {
+		e.keys = append(e.keys, nil)
+	}
+	if cap(e.keys[e.depth]) < size {
+		e.keys[e.depth] = make([]string, 0, size)
+	}
+	return e.keys[e.depth][:0]
+}
+
+// releaseKeys clear the keys so the pooled encoder does not keep the names of the result alive.
+func (e *jsonStream) releaseKeys(keys []string) {
+	for i := range keys {
+		keys[i] = ""
+	}
+	e.keys[e.depth] = keys[:0]
+}
+
+// openValue open the object or array of size elements, the elements are indented one more level if pretty.
+func (e *jsonStream) openValue(c byte, size int) {
+	e.buf = append(e.buf, c)
+	if size > 0 {
+		e.depth++
+	}
+}
+
+func (e *jsonStream) closeValue(c byte, size int) {
+	if size > 0 {
+		e.depth--
+		e.newline()
+	}
+	e.buf = append(e.buf, c)
+}
+
+func (e *jsonStream) writeElem(i int) {
+	if i > 0 {
+		e.buf = append(e.buf, ',')
+	}
+	e.newline()
+}
+
+func (e *jsonStream) writeKey(i int, k string) {
+	e.writeElem(i)
+	e.writeString(k)
+	e.buf = append(e.buf, ':')
+	if e.pretty {
+		e.buf = append(e.buf, ' ')
+	}
+}
+
+func (e *jsonStream) newline() {
+	if !e.pretty {
+		return
+	}
+	e.buf = append(e.buf, '\n')
+	for i := 0; i < e.depth; i++ {
+		e.buf = append(e.buf, ' ', ' ')
+	}
+}
+
+// writeFloat write the float as json.Marshal, the exponent format for the very small and large numbers.
+func (e *jsonStream) writeFloat(f float64) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		e.err = errUnsupportedFloat
+		return
+	}
+	format := byte('f')
+	if abs := math.Abs(f); abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+		format = 'e'
+	}
+	e.buf = strconv.AppendFloat(e.buf, f, format, -1, 64)
+	if format == 'e' {
+		// clean up e-09 to e-9
+		n := len(e.buf)
+		if n >= 4 && e.buf[n-4] == 'e' && e.buf[n-3] == '-' && e.buf[n-2] == '0' {
+			e.buf[n-2] = e.buf[n-1]
+			e.buf = e.buf[:n-1]
+		}
+	}
+}
+
+// writeString write the quoted string as json.Marshal, the html characters are escaped and the invalid utf-8 is
+// replaced by U+FFFD.
+func (e *jsonStream) writeString(s string) {
+	e.buf = append(e.buf, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		if b := s[i]; b < utf8.RuneSelf {
+			if b >= 0x20 && b != '"' && b != '\\' && b != '<' && b != '>' && b != '&' {
+				i++
+				continue
+			}
+			e.buf = append(e.buf, s[start:i]...)
+			switch b {
+			case '"', '\\':
+				e.buf = append(e.buf, '\\', b)
+			case '\b':
+				e.buf = append(e.buf, '\\', 'b')
+			case '\f':
+				e.buf = append(e.buf, '\\', 'f')
+			case '\n':
+				e.buf = append(e.buf, '\\', 'n')
+			case '\r':
+				e.buf = append(e.buf, '\\', 'r')
+			case '\t':
+				e.buf = append(e.buf, '\\', 't')
+			default:
+				e.buf = append(e.buf, '\\', 'u', '0', '0', hexDigits[b>>4], hexDigits[b&0xF])
+			}
+			i++
+			start = i
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			e.buf = append(e.buf, s[start:i]...)
+			e.buf = append(e.buf, "\ufffd"...)
+			i += size
+			start = i
+			continue
+		}
+		// U+2028 and U+2029 are valid json but break the javascript.
+		if r == '\u2028' || r == '\u2029' {
+			e.buf = append(e.buf, s[start:i]...)
+			e.buf = append(e.buf, '\\', 'u', '2', '0', '2', hexDigits[r&0xF])
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	e.buf = append(e.buf, s[start:]...)
+	e.buf = append(e.buf, '"')
+}
+
+// writeMarshal write the value of other types, such as the structs of the manage api, by json.Marshal.
+func (e *jsonStream) writeMarshal(v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		e.err = err
+		return
+	}
+	if !e.pretty {
+		e.buf = append(e.buf, b...)
+		return
+	}
+	buf := bytes.NewBuffer(e.buf)
+	if err := json.Indent(buf, b, string(bytes.Repeat([]byte("  "), e.depth)), "  "); err != nil {
+		e.err = err
+		return
+	}
+	e.buf = buf.Bytes()
+}
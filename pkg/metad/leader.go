@@ -0,0 +1,136 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metad
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"openpitrix.io/metad/pkg/logger"
+)
+
+var leaderGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "metad_leader",
+	Help: "Whether this metad is the leader of the group, always 1 if leader_election is disabled.",
+})
+
+func init() {
+	prometheus.MustRegister(leaderGauge)
+}
+
+func checkLeaderConfig(config *Config) error {
+	if config.LeaderElection && config.LeaderTTL <= 0 {
+		return errors.New("leader_ttl should be positive.")
+	}
+	return nil
+}
+
+// candidateID return the candidate name of this metad in the leader election, default the hostname.
+func candidateID(config *Config) string {
+	if config.LeaderID != "" {
+		return config.LeaderID
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		logger.Warn("Get hostname error: %s, use listen_manage as leader_id.", err.Error())
+		return config.ListenManage
+	}
+	return hostname
+}
+
+// isLeader return whether this metad is the leader of the group, every metad is the leader if leader_election disabled.
+func (m *Metad) isLeader() bool {
+	return !m.getConfig().LeaderElection || atomic.LoadInt32(&m.leader) == 1
+}
+
+// startElection campaign for the leader of the group until metad stopped if leader_election enabled. The leader
+// perform the manage api writes and the housekeeping (the webhook notifications and the trash expiry), all the
+// metad serve the reads.
+func (m *Metad) startElection() {
+	config := m.getConfig()
+	if !config.LeaderElection {
+		leaderGauge.Set(1)
+		return
+	}
+	leaderGauge.Set(0)
+	id := candidateID(config)
+	leaderChan := make(chan bool)
+	stopChan := make(chan bool)
+	m.metadataRepo.Campaign(id, config.LeaderTTL, leaderChan, stopChan)
+	go func() {
+		for {
+			select {
+			case leader := <-leaderChan:
+				m.setLeader(id, leader)
+			case <-m.shutdownChan:
+				close(stopChan)
+				return
+			}
+		}
+	}()
+}
+
+func (m *Metad) setLeader(id string, leader bool) {
+	var v int32
+	if leader {
+		v = 1
+	}
+	if atomic.SwapInt32(&m.leader, v) == v {
+		return
+	}
+	leaderGauge.Set(float64(v))
+	if leader {
+		logger.Info("Metad [%s] became the leader.", id)
+	} else {
+		logger.Warn("Metad [%s] lost the leadership, standby now.", id)
+	}
+	// start or stop the webhook notifications.
+	m.reloadLock.Lock()
+	defer m.reloadLock.Unlock()
+	if m.shuttingDown() {
+		return
+	}
+	if err := m.updateSubscriptions(m.getConfig()); err != nil {
+		logger.Error("Update subscriptions error: %s", err.Error())
+	}
+}
+
+// checkLeader reject the manage api writes of the standby, so the writes are serialized by the leader.
+func (m *Metad) checkLeader(req *http.Request) *HttpError {
+	switch strings.ToUpper(req.Method) {
+	case "GET", "HEAD", "OPTIONS":
+		return nil
+	}
+	if m.isLeader() || req.URL.Path == backendEndpointsPath {
+		return nil
+	}
+	leader, _ := m.metadataRepo.Leader()
+	return NewHttpError(http.StatusServiceUnavailable, fmt.Sprintf("metad is standby, send the writes to the leader [%s].", leader))
+}
+
+func (m *Metad) leaderGet(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	config := m.getConfig()
+	result := map[string]interface{}{
+		"leader_election": config.LeaderElection,
+		"is_leader":       m.isLeader(),
+	}
+	if !config.LeaderElection {
+		return result, nil
+	}
+	leader, err := m.metadataRepo.Leader()
+	if err != nil {
+		return nil, NewServerError(err)
+	}
+	result["id"] = candidateID(config)
+	result["leader"] = leader
+	return result, nil
+}
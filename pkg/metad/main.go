@@ -21,6 +21,14 @@ import (
 )
 
 func Main() {
+	if len(os.Args) > 1 && os.Args[1] == "dev" {
+		devMain(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		replayMain(os.Args[2:])
+		return
+	}
 	flag.Parse()
 
 	if printVersion {
@@ -28,6 +36,11 @@ func Main() {
 		os.Exit(0)
 	}
 
+	if importConfd != "" {
+		importConfdMain(importConfd)
+		return
+	}
+
 	if err := agent.Listen(agent.Options{}); err != nil {
 		log.Fatal(err)
 	}
@@ -0,0 +1,81 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metad
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"openpitrix.io/metad/pkg/metadata"
+)
+
+func intParam(req *http.Request, name string) (int, *HttpError) {
+	v := req.FormValue(name)
+	if v == "" {
+		return 0, nil
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil || i < 0 {
+		return 0, NewHttpError(http.StatusBadRequest, fmt.Sprintf("invalid %s parameter [%s]", name, v))
+	}
+	return i, nil
+}
+
+func (m *Metad) mappingList(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	offset, httpErr := intParam(req, "offset")
+	if httpErr != nil {
+		return nil, httpErr
+	}
+	limit, httpErr := intParam(req, "limit")
+	if httpErr != nil {
+		return nil, httpErr
+	}
+	mappings, total, err := m.metadataRepo.ListMappings(req.FormValue("host"), offset, limit)
+	if err != nil {
+		return nil, NewHttpError(http.StatusBadRequest, err.Error())
+	}
+	return map[string]interface{}{"total": total, "mappings": mappings}, nil
+}
+
+func (m *Metad) mappingExport(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	return m.metadataRepo.ExportMappings(), nil
+}
+
+func (m *Metad) mappingImport(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	return m.importMappings(ctx, req, false)
+}
+
+func (m *Metad) mappingReplace(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	return m.importMappings(ctx, req, true)
+}
+
+func (m *Metad) importMappings(ctx context.Context, req *http.Request, replace bool) (interface{}, *HttpError) {
+	decoder := json.NewDecoder(req.Body)
+	var table metadata.MappingTable
+	err := decoder.Decode(&table)
+	if err != nil {
+		return nil, NewHttpError(http.StatusBadRequest, fmt.Sprintf("invalid json format, error:%s", err.Error()))
+	}
+	dryRun := strings.ToLower(req.FormValue("dry_run")) == "true"
+	diff, err := m.metadataRepo.ImportMappings(&table, replace, dryRun)
+	if err != nil {
+		if _, invalid := err.(*metadata.MappingError); invalid || table.Mappings == nil {
+			return nil, NewHttpError(http.StatusBadRequest, err.Error())
+		}
+		if err == metadata.ErrMappingConflict {
+			return nil, NewHttpError(http.StatusConflict, err.Error())
+		}
+		return nil, NewServerError(err)
+	}
+	if !dryRun {
+		requestLogger(ctx).Info("Import mappings, replace: %v, added: %d, updated: %d, removed: %d",
+			replace, len(diff.Added), len(diff.Updated), len(diff.Removed))
+	}
+	return diff, nil
+}
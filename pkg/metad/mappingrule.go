@@ -0,0 +1,51 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metad
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"openpitrix.io/metad/pkg/metadata"
+)
+
+func (m *Metad) mappingRuleList(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	return m.metadataRepo.GetMappingRules(), nil
+}
+
+func (m *Metad) mappingRuleGet(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	rule := m.metadataRepo.GetMappingRule(mux.Vars(req)["name"])
+	if rule == nil {
+		return nil, NewHttpError(http.StatusNotFound, "Not found")
+	}
+	return rule, nil
+}
+
+func (m *Metad) mappingRuleUpdate(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	decoder := json.NewDecoder(req.Body)
+	var rule metadata.MappingRule
+	err := decoder.Decode(&rule)
+	if err != nil {
+		return nil, NewHttpError(http.StatusBadRequest, fmt.Sprintf("invalid json format, error:%s", err.Error()))
+	}
+	rule.Name = mux.Vars(req)["name"]
+	err = m.metadataRepo.PutMappingRule(&rule)
+	if err != nil {
+		return nil, NewHttpError(http.StatusBadRequest, err.Error())
+	}
+	return &rule, nil
+}
+
+func (m *Metad) mappingRuleDelete(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	err := m.metadataRepo.DeleteMappingRule(mux.Vars(req)["name"])
+	if err != nil {
+		return nil, NewServerError(err)
+	}
+	return nil, nil
+}
@@ -0,0 +1,73 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metad
+
+import (
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/golang/gddo/httputil"
+	"github.com/gorilla/mux"
+)
+
+// typedLeaf is the leaf value responded as is, with the media type declared by the annotation content_type.
+type typedLeaf struct {
+	value     string
+	mediaType string
+}
+
+// typedLeafResult wrap the leaf result of the read request as typedLeaf if its media type is declared,
+// and the client accept it, the explicit request of text, json or yaml is responded as before.
+func (m *Metad) typedLeafResult(req *http.Request, result interface{}) interface{} {
+	value, ok := result.(string)
+	if !ok {
+		return result
+	}
+	nodePath := path.Join("/", mux.Vars(req)["nodePath"])
+	if req.URL.Path == "/self" || strings.HasPrefix(req.URL.Path, "/self/") {
+		host, repo, httpErr := m.clientRepo(req)
+		if httpErr != nil {
+			return result
+		}
+		paths := repo.SelfPaths(host, nodePath)
+		if len(paths) != 1 {
+			return result
+		}
+		nodePath = paths[0]
+	}
+	annotation := m.metadataRepo.GetAnnotation(nodePath)
+	if annotation == nil || annotation.ContentType == "" {
+		return result
+	}
+	offers := []string{annotation.ContentType, "text/plain", "application/json", "application/yaml", "application/x-yaml", "text/yaml", "text/x-yaml"}
+	if httputil.NegotiateContentType(req, offers, annotation.ContentType) != annotation.ContentType {
+		return result
+	}
+	return &typedLeaf{value: value, mediaType: annotation.ContentType}
+}
+
+func respondTypedLeaf(w http.ResponseWriter, leaf *typedLeaf) int {
+	w.Header().Set("Content-Type", leaf.mediaType)
+	n, _ := w.Write([]byte(leaf.value))
+	return n
+}
+
+// typedResult convert the typed leaves of the metadata api result back to their json types, see typed_values.
+// The events of with_events are not converted, the event values are always strings.
+func (m *Metad) typedResult(req *http.Request, result interface{}) interface{} {
+	if !m.metadataRepo.TypedValues() || strings.ToLower(req.FormValue("with_events")) == "true" {
+		return result
+	}
+	nodePath := path.Join("/", mux.Vars(req)["nodePath"])
+	if isSelfPath(path.Clean(req.URL.Path)) {
+		host, repo, httpErr := m.clientRepo(req)
+		if httpErr != nil {
+			return result
+		}
+		return repo.TypeSelf(host, nodePath, result)
+	}
+	return m.metadataRepo.TypeData(nodePath, result)
+}
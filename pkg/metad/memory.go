@@ -0,0 +1,104 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metad
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// memoryReportInterval is the interval of updating the memory gauge, the data tree is walked every time.
+const memoryReportInterval = time.Minute
+
+// the defaults and the max of the depth and limit of the memory stats api.
+const (
+	defaultMemoryDepth = 1
+	defaultMemoryLimit = 20
+	maxMemoryLimit     = 1000
+)
+
+var storeMemory = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "metad_store_memory_bytes",
+	Help: "The approximate memory of the heaviest data prefixes of the store, prefix / is the whole data tree.",
+}, []string{"group", "prefix"})
+
+func init() {
+	prometheus.MustRegister(storeMemory)
+}
+
+func checkMemoryReport(config *Config) error {
+	if config.MemoryReportTop < 0 || config.MemoryReportDepth < 0 {
+		return errors.New("memory_report_top and memory_report_depth should not be negative.")
+	}
+	return nil
+}
+
+// reportMemory update the memory gauge of the whole data tree and the memory_report_top heaviest prefixes
+// memory_report_depth levels deep every memoryReportInterval until metad stopped, the prefixes no longer in the
+// top are removed from the gauge.
+func (m *Metad) reportMemory() {
+	ticker := time.NewTicker(memoryReportInterval)
+	defer ticker.Stop()
+	reported := map[string]bool{}
+	for {
+		select {
+		case <-ticker.C:
+			config := m.getConfig()
+			current := map[string]bool{}
+			if config.MemoryReportTop > 0 {
+				total, top := m.metadataRepo.DataMemoryUsage("/", config.MemoryReportDepth, config.MemoryReportTop)
+				storeMemory.WithLabelValues(config.Group, "/").Set(float64(total.Bytes))
+				current["/"] = true
+				for _, usage := range top {
+					storeMemory.WithLabelValues(config.Group, usage.Prefix).Set(float64(usage.Bytes))
+					current[usage.Prefix] = true
+				}
+			}
+			for prefix := range reported {
+				if !current[prefix] {
+					storeMemory.DeleteLabelValues(config.Group, prefix)
+				}
+			}
+			reported = current
+		case <-m.shutdownChan:
+			return
+		}
+	}
+}
+
+// memoryGet report the approximate memory of the data subtree at prefix (default /), and its heaviest limit subtrees
+// depth levels below.
+func (m *Metad) memoryGet(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	prefix := req.FormValue("prefix")
+	depth, limit := defaultMemoryDepth, defaultMemoryLimit
+	var httpErr *HttpError
+	if req.FormValue("depth") != "" {
+		if depth, httpErr = intParam(req, "depth"); httpErr != nil {
+			return nil, httpErr
+		}
+	}
+	if req.FormValue("limit") != "" {
+		if limit, httpErr = intParam(req, "limit"); httpErr != nil {
+			return nil, httpErr
+		}
+	}
+	if limit > maxMemoryLimit {
+		limit = maxMemoryLimit
+	}
+	total, top := m.metadataRepo.DataMemoryUsage(prefix, depth, limit)
+	if total == nil {
+		return nil, NewHttpError(http.StatusNotFound, "Not found")
+	}
+	return map[string]interface{}{
+		"prefix": total.Prefix,
+		"bytes":  total.Bytes,
+		"nodes":  total.Nodes,
+		"top":    top,
+	}, nil
+}
@@ -18,9 +18,11 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"path"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"syscall"
 	"time"
@@ -30,10 +32,14 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	yaml "gopkg.in/yaml.v2"
 
+	"openpitrix.io/metad/pkg/audit"
 	"openpitrix.io/metad/pkg/backends"
 	"openpitrix.io/metad/pkg/flatmap"
 	"openpitrix.io/metad/pkg/logger"
 	"openpitrix.io/metad/pkg/metadata"
+	"openpitrix.io/metad/pkg/notify"
+	"openpitrix.io/metad/pkg/ratelimit"
+	"openpitrix.io/metad/pkg/redact"
 	"openpitrix.io/metad/pkg/store"
 )
 
@@ -72,11 +78,50 @@ type Metad struct {
 	router       *mux.Router
 	manageRouter *mux.Router
 	requestIDGen atomic_AtomicLong
+	auditor      audit.Auditor
+	siemAuditor  audit.Auditor
+	notifier     *notify.Notifier
+	limiter      *ratelimit.Limiter
+	cache        *responseCache
+	jobs         *jobManager
+	configLock   sync.RWMutex
+	reloadLock   sync.Mutex
+	servers      []*http.Server
+	serverLock   sync.Mutex
+	shutdownChan chan struct{}
+	stoppedChan  chan struct{}
+	stopOnce     sync.Once
+	servingCache int32
+	recorder     *recorder
+	verifyReport *metadata.VerifyReport
+	verifyLock   sync.Mutex
+	sources      []*httpSource
+	computed     []*computedKey
+	authz        *authzCache
+	slo          *sloTracker
+	renders      *renderSet
+	changeLog    *changeLog
+	leader       int32
+	// conditionalLock serialize the conditional data writes with the other data writes, see conditional.
+	conditionalLock sync.RWMutex
+	// unsyncedWrites are the paths of the data writes may not synced back yet, with the write time, see conditional.
+	unsyncedWrites     map[string]time.Time
+	unsyncedWritesLock sync.Mutex
+
+	trustedProxies []*net.IPNet
+	namespaces     []*namespace
 }
 
 type atomic_AtomicLong int64
 
 func New(config *Config) (*Metad, error) {
+	encryptionKey, err := readEncryptionKey(config)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := unixSocketMode(config); err != nil {
+		return nil, err
+	}
 
 	backendsConfig := backends.Config{
 		Backend:      config.Backend,
@@ -87,8 +132,14 @@ func New(config *Config) (*Metad, error) {
 		BackendNodes: config.BackendNodes,
 		Password:     config.Password,
 		Username:     config.Username,
+		UsernameFile: config.UsernameFile,
+		PasswordFile: config.PasswordFile,
 		Prefix:       config.Prefix,
 		Group:        config.Group,
+
+		EncryptionPrefixes: config.EncryptionPrefixes,
+		EncryptionKey:      encryptionKey,
+		EncryptedRecords:   []string{metadata.RecordRelease, metadata.RecordDeadLetter},
 	}
 
 	storeClient, err := backends.New(backendsConfig)
@@ -96,19 +147,116 @@ func New(config *Config) (*Metad, error) {
 		return nil, err
 	}
 
+	auditor, siemAuditor, err := newAuditor(config, storeClient)
+	if err != nil {
+		return nil, err
+	}
+
 	metadataRepo := metadata.New(storeClient)
-	return &Metad{config: config, metadataRepo: metadataRepo, router: mux.NewRouter(), manageRouter: mux.NewRouter()}, nil
+	if _, err := configSubscriptions(config); err != nil {
+		return nil, err
+	}
+	quotas, err := configQuotas(config)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkSLOConfig(config); err != nil {
+		return nil, err
+	}
+	renders, err := configRenders(config)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkIndexKeys(config); err != nil {
+		return nil, err
+	}
+	metadataRepo.SetIndexKeys(config.IndexKeys)
+	metadataRepo.SetTypedValues(config.TypedValues)
+	if err := checkNodeHistory(config); err != nil {
+		return nil, err
+	}
+	metadataRepo.SetNodeHistory(config.NodeHistory)
+	if err := checkTrashRetention(config); err != nil {
+		return nil, err
+	}
+	if err := checkVerifyInterval(config); err != nil {
+		return nil, err
+	}
+	if err := checkLeaderConfig(config); err != nil {
+		return nil, err
+	}
+	proxies, err := configTrustedProxies(config)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkRoleBindings(config); err != nil {
+		return nil, err
+	}
+	if err := checkMemoryReport(config); err != nil {
+		return nil, err
+	}
+	if err := checkSelfProfiles(config); err != nil {
+		return nil, err
+	}
+	setRedactPrefixes(config)
+	metadataRepo.SetDataQuotas(quotas, config.QuotaMode != QuotaModeFlag)
+	sources, err := configHTTPSources(config)
+	if err != nil {
+		return nil, err
+	}
+	for _, source := range sources {
+		metadataRepo.MountData(source.prefix)
+	}
+	computed, err := configComputedKeys(config, sources)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range computed {
+		metadataRepo.MountData(k.path)
+	}
+	changeLog, err := openChangeLog(config.ChangeLog)
+	if err != nil {
+		return nil, err
+	}
+	namespaces, err := newNamespaces(config)
+	if err != nil {
+		return nil, err
+	}
+	notifier := newNotifier(config, metadataRepo)
+	return &Metad{namespaces: namespaces, changeLog: changeLog, config: config, sources: sources, computed: computed, metadataRepo: metadataRepo, router: mux.NewRouter(), manageRouter: mux.NewRouter(), auditor: auditor, siemAuditor: siemAuditor, notifier: notifier, limiter: newLimiter(config),
+		cache: newResponseCache(config.ResponseCacheSize), jobs: newJobManager(), authz: newAuthzCache(), slo: newSLOTracker(), renders: renders, recorder: &recorder{}, trustedProxies: proxies, shutdownChan: make(chan struct{}), stoppedChan: make(chan struct{})}, nil
 }
 
 func (m *Metad) Init() {
-	m.metadataRepo.StartSync()
+	m.startElection()
+	m.startChangeLog()
+	m.startSync()
+	go m.runVerify()
+	for _, source := range m.sources {
+		go m.pollHTTPSource(source)
+	}
+	for _, k := range m.computed {
+		m.startComputedKey(k)
+	}
+	go m.expireOverlays()
+	go m.expireTrash()
+	go m.updateSLOMetrics()
+	go m.reportMemory()
+	go m.runRenders()
+	go m.syncSubscriptions(m.checkSubscriptions(-1))
 	m.initRouter()
 	m.initManageRouter()
+	m.initNamespaces()
 }
 
 func (m *Metad) initRouter() {
 	m.router.HandleFunc("/favicon.ico", http.NotFound)
 
+	if m.getConfig().TemplatePrefix != "" {
+		m.router.HandleFunc("/render/{name:.*}", m.handleWrapper(m.templateHandler)).
+			Methods("GET", "HEAD")
+	}
+
 	m.router.HandleFunc("/self", m.handleWrapper(m.selfHandler)).
 		Methods("GET", "HEAD")
 
@@ -128,6 +276,8 @@ func (m *Metad) initManageRouter() {
 		result, _ := json.Marshal(status)
 		arg1.Write(result)
 	})
+	m.manageRouter.HandleFunc("/healthz", m.healthzHandler)
+	m.manageRouter.HandleFunc("/readyz", m.readyzHandler)
 
 	v1 := m.manageRouter.PathPrefix("/v1").Subrouter()
 
@@ -135,21 +285,51 @@ func (m *Metad) initManageRouter() {
 	v1.HandleFunc("/mapping", m.manageWrapper(m.mappingUpdate)).Methods("POST", "PUT")
 	v1.HandleFunc("/mapping", m.manageWrapper(m.mappingDelete)).Methods("DELETE")
 
+	v1.HandleFunc("/mapping_rule", m.manageWrapper(m.mappingRuleList)).Methods("GET")
+	v1.HandleFunc("/mapping_rule/{name}", m.manageWrapper(m.mappingRuleGet)).Methods("GET")
+	v1.HandleFunc("/mapping_rule/{name}", m.manageWrapper(m.mappingRuleUpdate)).Methods("POST", "PUT")
+	v1.HandleFunc("/mapping_rule/{name}", m.manageWrapper(m.mappingRuleDelete)).Methods("DELETE")
+
+	v1.HandleFunc("/mapping:list", m.manageWrapper(m.mappingList)).Methods("GET")
+	v1.HandleFunc("/mapping:export", m.manageWrapper(m.mappingExport)).Methods("GET")
+	v1.HandleFunc("/mapping:import", m.manageWrapper(m.mappingImport)).Methods("POST")
+	v1.HandleFunc("/mapping:replace", m.manageWrapper(m.mappingReplace)).Methods("POST")
+
 	mapping := v1.PathPrefix("/mapping").Subrouter()
 	//mapping.HandleFunc("", mappingGET).Methods("GET")
 	mapping.HandleFunc("/{nodePath:.*}", m.manageWrapper(m.mappingGet)).Methods("GET")
 	mapping.HandleFunc("/{nodePath:.*}", m.manageWrapper(m.mappingUpdate)).Methods("POST", "PUT")
 	mapping.HandleFunc("/{nodePath:.*}", m.manageWrapper(m.mappingDelete)).Methods("DELETE")
 
+	v1.HandleFunc("/data:move", m.manageWrapper(m.dataMove)).Methods("POST")
+	v1.HandleFunc("/data:copy", m.manageWrapper(m.dataCopy)).Methods("POST")
+
 	v1.HandleFunc("/data", m.manageWrapper(m.dataGet)).Methods("GET")
-	v1.HandleFunc("/data", m.manageWrapper(m.dataUpdate)).Methods("POST", "PUT")
-	v1.HandleFunc("/data", m.manageWrapper(m.dataDelete)).Methods("DELETE")
+	v1.HandleFunc("/data", m.manageWrapper(m.conditional(m.dataUpdate))).Methods("POST", "PUT")
+	v1.HandleFunc("/data", m.manageWrapper(m.conditional(m.dataPatch))).Methods("PATCH")
+	v1.HandleFunc("/data", m.manageWrapper(m.conditional(m.dataDelete))).Methods("DELETE")
 
 	data := v1.PathPrefix("/data").Subrouter()
 	//mapping.HandleFunc("", mappingGET).Methods("GET")
 	data.HandleFunc("/{nodePath:.*}", m.manageWrapper(m.dataGet)).Methods("GET")
-	data.HandleFunc("/{nodePath:.*}", m.manageWrapper(m.dataUpdate)).Methods("POST", "PUT")
-	data.HandleFunc("/{nodePath:.*}", m.manageWrapper(m.dataDelete)).Methods("DELETE")
+	data.HandleFunc("/{nodePath:.*}", m.manageWrapper(m.conditional(m.dataUpdate))).Methods("POST", "PUT")
+	data.HandleFunc("/{nodePath:.*}", m.manageWrapper(m.conditional(m.dataPatch))).Methods("PATCH")
+	data.HandleFunc("/{nodePath:.*}", m.manageWrapper(m.conditional(m.dataDelete))).Methods("DELETE")
+
+	v1.HandleFunc("/overlay", m.manageWrapper(m.overlayList)).Methods("GET")
+	overlay := v1.PathPrefix("/overlay").Subrouter()
+	overlay.HandleFunc("/{nodePath:.*}", m.manageWrapper(m.overlayGet)).Methods("GET")
+	overlay.HandleFunc("/{nodePath:.*}", m.manageWrapper(m.overlayUpdate)).Methods("POST", "PUT")
+	overlay.HandleFunc("/{nodePath:.*}", m.manageWrapper(m.overlayDelete)).Methods("DELETE")
+
+	v1.HandleFunc("/alias", m.manageWrapper(m.aliasList)).Methods("GET")
+	alias := v1.PathPrefix("/alias").Subrouter()
+	alias.HandleFunc("/{nodePath:.*}", m.manageWrapper(m.aliasGet)).Methods("GET")
+	alias.HandleFunc("/{nodePath:.*}", m.manageWrapper(m.aliasUpdate)).Methods("POST", "PUT")
+	alias.HandleFunc("/{nodePath:.*}", m.manageWrapper(m.aliasDelete)).Methods("DELETE")
+
+	v1.HandleFunc("/export", m.manageWrapper(m.dataExport)).Methods("GET")
+	v1.HandleFunc("/import", m.manageWrapper(m.dataImport)).Methods("POST", "PUT")
 
 	v1.HandleFunc("/rule", m.manageWrapper(m.accessRuleGet)).Methods("GET")
 	v1.HandleFunc("/rule", m.manageWrapper(m.accessRuleUpdate)).Methods("POST", "PUT")
@@ -159,18 +339,169 @@ func (m *Metad) initManageRouter() {
 	rule.HandleFunc("/", m.manageWrapper(m.accessRuleGet)).Methods("GET")
 	rule.HandleFunc("/", m.manageWrapper(m.accessRuleUpdate)).Methods("POST", "PUT")
 	rule.HandleFunc("/", m.manageWrapper(m.accessRuleDelete)).Methods("DELETE")
+
+	v1.HandleFunc("/release", m.manageWrapper(m.releaseList)).Methods("GET")
+	v1.HandleFunc("/release", m.manageWrapper(m.releaseCreate)).Methods("POST", "PUT")
+
+	release := v1.PathPrefix("/release").Subrouter()
+	release.HandleFunc("/{name}", m.manageWrapper(m.releaseGet)).Methods("GET")
+	release.HandleFunc("/{name}", m.manageWrapper(m.releaseDelete)).Methods("DELETE")
+	release.HandleFunc("/{name}/apply", m.manageWrapper(m.releaseApply)).Methods("POST")
+	release.HandleFunc("/{name}/rollback", m.manageWrapper(m.releaseRollback)).Methods("POST")
+
+	v1.HandleFunc("/annotation", m.manageWrapper(m.annotationGet)).Methods("GET")
+	v1.HandleFunc("/annotation", m.manageWrapper(m.annotationUpdate)).Methods("POST", "PUT")
+	v1.HandleFunc("/annotation", m.manageWrapper(m.annotationDelete)).Methods("DELETE")
+
+	annotation := v1.PathPrefix("/annotation").Subrouter()
+	annotation.HandleFunc("/{nodePath:.*}", m.manageWrapper(m.annotationGet)).Methods("GET")
+	annotation.HandleFunc("/{nodePath:.*}", m.manageWrapper(m.annotationUpdate)).Methods("POST", "PUT")
+	annotation.HandleFunc("/{nodePath:.*}", m.manageWrapper(m.annotationDelete)).Methods("DELETE")
+
+	v1.HandleFunc("/token", m.manageWrapper(m.tokenList)).Methods("GET")
+	v1.HandleFunc("/token", m.manageWrapper(m.tokenCreate)).Methods("POST", "PUT")
+	v1.HandleFunc("/token/{id}", m.manageWrapper(m.tokenDelete)).Methods("DELETE")
+
+	v1.HandleFunc("/override", m.manageWrapper(m.overrideList)).Methods("GET")
+
+	v1.HandleFunc("/subscription", m.manageWrapper(m.subscriptionList)).Methods("GET")
+
+	subscription := v1.PathPrefix("/subscription").Subrouter()
+	subscription.HandleFunc("/{name}", m.manageWrapper(m.subscriptionGet)).Methods("GET")
+	subscription.HandleFunc("/{name}", m.manageWrapper(m.subscriptionUpdate)).Methods("POST", "PUT")
+	subscription.HandleFunc("/{name}", m.manageWrapper(m.subscriptionDelete)).Methods("DELETE")
+	subscription.HandleFunc("/{name}/test", m.manageWrapper(m.subscriptionTest)).Methods("POST")
+
+	v1.HandleFunc("/deadletter", m.manageWrapper(m.deadLetterList)).Methods("GET")
+
+	deadLetter := v1.PathPrefix("/deadletter").Subrouter()
+	deadLetter.HandleFunc("/{id}", m.manageWrapper(m.deadLetterGet)).Methods("GET")
+	deadLetter.HandleFunc("/{id}", m.manageWrapper(m.deadLetterDelete)).Methods("DELETE")
+	deadLetter.HandleFunc("/{id}/replay", m.manageWrapper(m.deadLetterReplay)).Methods("POST")
+
+	v1.HandleFunc("/leader", m.manageWrapper(m.leaderGet)).Methods("GET")
+
+	v1.HandleFunc("/namespace", m.manageWrapper(m.namespaceList)).Methods("GET")
+
+	v1.HandleFunc("/backend", m.manageWrapper(m.backendGet)).Methods("GET")
+	v1.HandleFunc("/backend/endpoints", m.manageWrapper(m.backendEndpointsUpdate)).Methods("POST", "PUT")
+
+	v1.HandleFunc("/trash", m.manageWrapper(m.trashList)).Methods("GET")
+
+	trash := v1.PathPrefix("/trash").Subrouter()
+	trash.HandleFunc("/{id}", m.manageWrapper(m.trashGet)).Methods("GET")
+	trash.HandleFunc("/{id}", m.manageWrapper(m.trashDelete)).Methods("DELETE")
+	trash.HandleFunc("/{id}/restore", m.manageWrapper(m.trashRestore)).Methods("POST")
+
+	// the stream for the read replicas, not wrapped as the response is streamed.
+	v1.HandleFunc("/replicate/{kind:.*}", m.replicateHandler).Methods("GET")
+
+	v1.HandleFunc("/analysis/unreferenced", m.manageWrapper(m.unreferencedDataGet)).Methods("GET")
+
+	v1.HandleFunc("/stats", m.manageWrapper(m.statsGet)).Methods("GET")
+	v1.HandleFunc("/stats/memory", m.manageWrapper(m.memoryGet)).Methods("GET")
+
+	v1.HandleFunc("/freeze", m.manageWrapper(m.freezeGet)).Methods("GET")
+	v1.HandleFunc("/freeze", m.manageWrapper(m.freezeUpdate)).Methods("POST", "PUT")
+	v1.HandleFunc("/freeze", m.manageWrapper(m.freezeDelete)).Methods("DELETE")
+
+	v1.HandleFunc("/slo", m.manageWrapper(m.sloGet)).Methods("GET")
+
+	v1.HandleFunc("/render", m.manageWrapper(m.renderList)).Methods("GET")
+
+	v1.HandleFunc("/find", m.manageWrapper(m.dataFind)).Methods("GET")
+
+	v1.HandleFunc("/source", m.manageWrapper(m.sourceList)).Methods("GET")
+
+	v1.HandleFunc("/sync", m.manageWrapper(m.syncGet)).Methods("GET")
+	v1.HandleFunc("/sync:pause", m.manageWrapper(m.syncPause)).Methods("POST")
+	v1.HandleFunc("/sync:resume", m.manageWrapper(m.syncResume)).Methods("POST")
+	v1.HandleFunc("/sync:resync", m.manageWrapper(m.syncResync)).Methods("POST")
+
+	v1.HandleFunc("/verify", m.manageWrapper(m.verifyGet)).Methods("GET")
+	v1.HandleFunc("/verify", m.manageWrapper(m.verifyRun)).Methods("POST")
+
+	v1.HandleFunc("/job", m.manageWrapper(m.jobList)).Methods("GET")
+	v1.HandleFunc("/job", m.manageWrapper(m.jobCreate)).Methods("POST", "PUT")
+
+	job := v1.PathPrefix("/job").Subrouter()
+	job.HandleFunc("/{id}", m.manageWrapper(m.jobGet)).Methods("GET")
+	job.HandleFunc("/{id}", m.manageWrapper(m.jobCancel)).Methods("DELETE")
+	job.HandleFunc("/{id}/log", m.manageWrapper(m.jobLog)).Methods("GET")
+	job.HandleFunc("/{id}/result", m.manageWrapper(m.jobResult)).Methods("GET")
+
+	v1.HandleFunc("/record", m.manageWrapper(m.recordGet)).Methods("GET")
+	v1.HandleFunc("/record", m.manageWrapper(m.recordStart)).Methods("POST", "PUT")
+	v1.HandleFunc("/record", m.manageWrapper(m.recordDelete)).Methods("DELETE")
+
+	v1.HandleFunc("/schema", m.manageWrapper(m.schemaList)).Methods("GET")
+	v1.HandleFunc("/schema/event/{version}", m.manageWrapper(m.eventSchemaGet)).Methods("GET")
+	v1.HandleFunc("/schema/data", m.manageWrapper(m.dataSchemaList)).Methods("GET")
+	v1.HandleFunc("/schema/data/{prefix:.*}", m.manageWrapper(m.dataSchemaGet)).Methods("GET")
+	v1.HandleFunc("/schema/data/{prefix:.*}", m.manageWrapper(m.dataSchemaUpdate)).Methods("POST", "PUT")
+	v1.HandleFunc("/schema/data/{prefix:.*}", m.manageWrapper(m.dataSchemaDelete)).Methods("DELETE")
 }
 
 func (m *Metad) Serve() {
 	m.watchSignals()
+	m.watchReload()
 	m.watchManage()
+	m.watchUnix()
+	m.serveNamespaces()
 
-	logger.Info("Listening on %s", m.config.Listen)
-	logger.Fatal("%v", http.ListenAndServe(m.config.Listen, m.router))
+	if err := m.serveMetadata(); err != http.ErrServerClosed {
+		logger.Fatal("%v", err)
+	}
+	// wait the shutdown finish.
+	<-m.stoppedChan
 }
 
+// serveMetadata serve the metadata api on the listen address until the server closed.
+func (m *Metad) serveMetadata() error {
+	config := m.getConfig()
+	server := &http.Server{Addr: config.Listen, Handler: m.router}
+	m.addServer(server)
+	listener, err := net.Listen("tcp", config.Listen)
+	if err != nil {
+		logger.Fatal("%v", err)
+	}
+	if config.ProxyProtocol {
+		listener = m.proxyListener(listener)
+	}
+	if config.TLSCert != "" {
+		certs, tlsErr := newServerCerts(config.TLSCert, config.TLSKey, config.TLSClientCA)
+		if tlsErr != nil {
+			logger.Fatal("Init tls config error: %v", tlsErr)
+		}
+		go m.watchCerts(certs)
+		server.TLSConfig = tlsConfig(certs, config.TLSRequireClientCert)
+		logger.Info("Listening on %s (TLS)", config.Listen)
+		// the cert is served by the tls config.
+		err = server.ServeTLS(listener, "", "")
+	} else {
+		logger.Info("Listening on %s", config.Listen)
+		err = server.Serve(listener)
+	}
+	return err
+}
+
+// Stop shutdown metad gracefully, drain the requests, then stop the notifier and backend sync.
 func (m *Metad) Stop() {
-	m.metadataRepo.StopSync()
+	m.stopOnce.Do(func() {
+		close(m.shutdownChan)
+		m.drain()
+		m.stopNamespaces()
+		m.jobs.stop()
+		m.reloadLock.Lock()
+		m.notifier.Stop()
+		m.reloadLock.Unlock()
+		m.saveCache()
+		m.metadataRepo.StopSync()
+		m.closeChangeLog()
+		m.closeAuditor()
+		logger.Info("Metad stopped")
+		close(m.stoppedChan)
+	})
 }
 
 func (m *Metad) watchSignals() {
@@ -191,8 +522,32 @@ func (m *Metad) watchSignals() {
 }
 
 func (m *Metad) watchManage() {
-	logger.Info("Listening for Manage on %s", m.config.ListenManage)
-	go http.ListenAndServe(m.config.ListenManage, m.manageRouter)
+	config := m.getConfig()
+	server := &http.Server{Addr: config.ListenManage, Handler: m.manageRouter}
+	m.addServer(server)
+	if config.ManageTLSCert != "" {
+		certs, err := newServerCerts(config.ManageTLSCert, config.ManageTLSKey, config.ManageTLSClientCA)
+		if err != nil {
+			logger.Fatal("Init manage tls config error: %v", err)
+		}
+		go m.watchCerts(certs)
+		// the client cert is optional, the requests can still be authenticated by token.
+		server.TLSConfig = tlsConfig(certs, false)
+		logger.Info("Listening for Manage on %s (TLS)", server.Addr)
+	} else {
+		logger.Info("Listening for Manage on %s", server.Addr)
+	}
+	go func() {
+		var err error
+		if server.TLSConfig != nil {
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != http.ErrServerClosed {
+			logger.Error("Manage listener error: %v", err)
+		}
+	}()
 }
 
 func (m *Metad) dataGet(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
@@ -201,58 +556,223 @@ func (m *Metad) dataGet(ctx context.Context, req *http.Request) (interface{}, *H
 	if nodePath == "" {
 		nodePath = "/"
 	}
-	val := m.metadataRepo.GetData(nodePath)
+	if isMetaRequest(req) {
+		return m.dataMeta(nodePath, req)
+	}
+	p, httpErr := parseProjection(req, nodePath)
+	if httpErr != nil {
+		return nil, httpErr
+	}
+	if req.FormValue("at") != "" {
+		return m.dataAt(nodePath, req, p)
+	}
+	p.createdOrder = m.metadataRepo.DataCreatedOrder
+	if revision := m.metadataRepo.DataRevision(nodePath); revision > 0 {
+		setResponseHeader(ctx, "ETag", revisionETag(revision))
+	}
+	val := m.metadataRepo.ReadData(nodePath)
 	if val == nil {
 		return nil, NewHttpError(http.StatusNotFound, "Not found")
 	} else {
+		val = p.apply(m.refData(req, nodePath, m.metadataRepo.TypeData(nodePath, val)))
+		if p.next != "" {
+			setResponseHeader(ctx, continueTokenHeader, p.next)
+		}
 		return val, nil
 	}
 }
 
 func (m *Metad) dataUpdate(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	if "PUT" == strings.ToUpper(req.Method) && isMergePatch(req) {
+		return m.dataPatch(ctx, req)
+	}
 	vars := mux.Vars(req)
 	nodePath := vars["nodePath"]
 	if nodePath == "" {
 		nodePath = "/"
 	}
+	if httpErr := m.authorizeWrite(ctx, req, "update", nodePath); httpErr != nil {
+		return nil, httpErr
+	}
+	replace, httpErr := putMode(req)
+	if httpErr != nil {
+		return nil, httpErr
+	}
 	decoder := json.NewDecoder(req.Body)
 	var data interface{}
 	err := decoder.Decode(&data)
 	if err != nil {
 		return nil, NewHttpError(http.StatusBadRequest, fmt.Sprintf("invalid json format, error:%s", err.Error()))
 	} else {
-		// POST means replace old value
-		// PUT means merge to old value
-		replace := "POST" == strings.ToUpper(req.Method)
+		untrack := m.metadataRepo.TrackPut(m.requestActor(req), nodePath, data, replace)
 		err = m.metadataRepo.PutData(nodePath, data, replace)
 		if err != nil {
-			logger.Debug("dataUpdate  nodePath:%s, data:%v, error:%s", nodePath, data, err.Error())
-			return nil, NewServerError(err)
+			untrack()
+			logger.Debug("dataUpdate  nodePath:%s, data:%v, error:%s", nodePath, redact.Tree(nodePath, data), err.Error())
+			return nil, writeError(err, http.StatusInternalServerError)
 		} else {
 			return nil, nil
 		}
 	}
 }
 
+// putMode return whether the data write replace the old value, by the mode parameter (merge or replace) if present,
+// otherwise POST means replace and PUT means merge. The replace delete the old keys absent from the data with the puts.
+func putMode(req *http.Request) (bool, *HttpError) {
+	switch mode := strings.ToLower(req.FormValue("mode")); mode {
+	case "":
+		return "POST" == strings.ToUpper(req.Method), nil
+	case "merge":
+		return false, nil
+	case "replace":
+		return true, nil
+	default:
+		return false, NewHttpError(http.StatusBadRequest, fmt.Sprintf("invalid mode [%s], should be merge or replace.", mode))
+	}
+}
+
 func (m *Metad) dataDelete(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
 	vars := mux.Vars(req)
 	nodePath := vars["nodePath"]
 	if nodePath == "" {
 		nodePath = "/"
 	}
+	if pattern := req.FormValue("match"); pattern != "" {
+		if req.FormValue("subs") != "" {
+			return nil, NewHttpError(http.StatusBadRequest, "match and subs parameter should not be both present.")
+		}
+		return m.dataDeleteMatch(ctx, req, nodePath, pattern)
+	}
 	subsParam := req.FormValue("subs")
 	var subs []string
 	if subsParam != "" {
 		subs = strings.Split(subsParam, ",")
 	}
-	err := m.metadataRepo.DeleteData(nodePath, subs...)
+	deletePaths := []string{nodePath}
+	if len(subs) > 0 {
+		deletePaths = make([]string, 0, len(subs))
+		for _, sub := range subs {
+			deletePaths = append(deletePaths, path.Join(nodePath, sub))
+		}
+	}
+	if httpErr := m.authorizeWrite(ctx, req, "delete", deletePaths...); httpErr != nil {
+		return nil, httpErr
+	}
+	untrack := m.metadataRepo.TrackDelete(m.requestActor(req), deletePaths...)
+	var err error
+	if m.trashEnabled() {
+		err = m.deleteToTrash(ctx, req, deletePaths...)
+	} else {
+		err = m.metadataRepo.DeleteData(nodePath, subs...)
+	}
 	if err != nil {
-		return nil, NewServerError(err)
+		untrack()
+		return nil, writeError(err, http.StatusInternalServerError)
 	} else {
 		return nil, nil
 	}
 }
 
+// isDryRun return whether the request has the dryRun (or dry_run) parameter, the write is only checked but not done.
+func isDryRun(req *http.Request) bool {
+	query := req.URL.Query()
+	return strings.ToLower(query.Get("dryRun")) == "true" || strings.ToLower(query.Get("dry_run")) == "true"
+}
+
+// dataDeleteMatch delete the paths under nodePath matched by the glob pattern, return the matched paths.
+// If dryRun, the matched paths are returned without delete, for checking a large cleanup.
+func (m *Metad) dataDeleteMatch(ctx context.Context, req *http.Request, nodePath string, pattern string) (interface{}, *HttpError) {
+	paths, err := m.metadataRepo.MatchData(nodePath, pattern)
+	if err != nil {
+		return nil, NewHttpError(http.StatusBadRequest, err.Error())
+	}
+	dryRun := isDryRun(req)
+	result := map[string]interface{}{"dry_run": dryRun, "count": len(paths), "paths": paths}
+	if len(paths) == 0 {
+		return result, nil
+	}
+	if httpErr := m.authorizeWrite(ctx, req, "delete", paths...); httpErr != nil {
+		return nil, httpErr
+	}
+	if dryRun {
+		return result, nil
+	}
+	untrack := m.metadataRepo.TrackDelete(m.requestActor(req), paths...)
+	if m.trashEnabled() {
+		err = m.deleteToTrash(ctx, req, paths...)
+	} else {
+		err = m.metadataRepo.DeletePaths(paths...)
+	}
+	if err != nil {
+		untrack()
+		return nil, writeError(err, http.StatusInternalServerError)
+	}
+	requestLogger(ctx).Info("Delete %d paths under [%s] matched by [%s]", len(paths), nodePath, pattern)
+	return result, nil
+}
+
+type moveRequest struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+func (m *Metad) dataMove(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	decoder := json.NewDecoder(req.Body)
+	var move moveRequest
+	err := decoder.Decode(&move)
+	if err != nil {
+		return nil, NewHttpError(http.StatusBadRequest, fmt.Sprintf("invalid json format, error:%s", err.Error()))
+	}
+	if move.From == "" || move.To == "" {
+		return nil, NewHttpError(http.StatusBadRequest, "from and to should not be empty")
+	}
+	if httpErr := m.authorizeWrite(ctx, req, "move", move.From, move.To); httpErr != nil {
+		return nil, httpErr
+	}
+	untrackFrom := m.metadataRepo.TrackDelete(m.requestActor(req), move.From)
+	untrackTo := m.metadataRepo.TrackActor(m.requestActor(req), move.To)
+	mappings, err := m.metadataRepo.MoveData(move.From, move.To)
+	if err != nil {
+		untrackFrom()
+		untrackTo()
+		logger.Debug("dataMove from:%s, to:%s, error:%s", move.From, move.To, err.Error())
+		return nil, writeError(err, http.StatusBadRequest)
+	}
+	return map[string]interface{}{"from": move.From, "to": move.To, "mappings": mappings}, nil
+}
+
+type copyRequest struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	metadata.CopyOptions
+}
+
+func (m *Metad) dataCopy(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	decoder := json.NewDecoder(req.Body)
+	var copyReq copyRequest
+	err := decoder.Decode(&copyReq)
+	if err != nil {
+		return nil, NewHttpError(http.StatusBadRequest, fmt.Sprintf("invalid json format, error:%s", err.Error()))
+	}
+	if copyReq.From == "" || copyReq.To == "" {
+		return nil, NewHttpError(http.StatusBadRequest, "from and to should not be empty")
+	}
+	if httpErr := m.checkRoleRead(req, copyReq.From); httpErr != nil {
+		return nil, httpErr
+	}
+	if httpErr := m.authorizeWrite(ctx, req, "copy", copyReq.To); httpErr != nil {
+		return nil, httpErr
+	}
+	untrack := m.metadataRepo.TrackActor(m.requestActor(req), copyReq.To)
+	count, err := m.metadataRepo.CopyData(copyReq.From, copyReq.To, &copyReq.CopyOptions)
+	if err != nil {
+		untrack()
+		logger.Debug("dataCopy from:%s, to:%s, error:%s", copyReq.From, copyReq.To, err.Error())
+		return nil, writeError(err, http.StatusBadRequest)
+	}
+	return map[string]interface{}{"from": copyReq.From, "to": copyReq.To, "keys": count}, nil
+}
+
 func (m *Metad) mappingGet(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
 	vars := mux.Vars(req)
 	nodePath := vars["nodePath"]
@@ -277,7 +797,8 @@ func (m *Metad) mappingUpdate(ctx context.Context, req *http.Request) (interface
 	if err != nil {
 		return nil, NewHttpError(http.StatusBadRequest, fmt.Sprintf("read request error:%s", err.Error()))
 	}
-	logger.Info("%s\tBODY\t%s", ctx.Value("requestID"), string(buf))
+	// the mapping may contain sensitive values, only log the body in debug level.
+	requestLogger(ctx).Debug("body %s", string(buf))
 	decoder := json.NewDecoder(bytes.NewReader(buf))
 	var data interface{}
 	err = decoder.Decode(&data)
@@ -373,12 +894,29 @@ func contentType(req *http.Request) int {
 }
 
 func (m *Metad) rootHandler(ctx context.Context, req *http.Request) (currentVersion int64, result interface{}, httpErr *HttpError) {
-	clientIP := m.requestIP(req)
+	clientIP, repo, httpErr := m.clientRepo(req)
+	if httpErr != nil {
+		return
+	}
 	vars := mux.Vars(req)
 	nodePath := vars["nodePath"]
 	if nodePath == "" {
 		nodePath = "/"
 	}
+	revision, httpErr := atRevision(req)
+	if httpErr != nil {
+		return
+	}
+	if revision > 0 {
+		var err error
+		result, err = repo.RootAtRevision(clientIP, nodePath, revision)
+		if err != nil {
+			httpErr = NewHttpError(http.StatusBadRequest, err.Error())
+		} else if result == nil {
+			httpErr = NewHttpError(http.StatusNotFound, "Not found")
+		}
+		return
+	}
 	wait := strings.ToLower(req.FormValue("wait")) == "true"
 	if wait {
 		prevVersionStr := req.FormValue("prev_version")
@@ -390,15 +928,29 @@ func (m *Metad) rootHandler(ctx context.Context, req *http.Request) (currentVers
 				prevVersion = -1
 			}
 		}
+		withEvents := strings.ToLower(req.FormValue("with_events")) == "true"
+		events := []*store.Event{}
 		if prevVersion > 0 && prevVersion != m.metadataRepo.DataVersion() {
-			currentVersion, result = m.metadataRepo.Root(clientIP, nodePath)
+			currentVersion, result = repo.Root(clientIP, nodePath)
 		} else {
-			m.metadataRepo.Watch(ctx, clientIP, nodePath)
+			if withEvents {
+				events = repo.WatchEvents(ctx, clientIP, nodePath)
+			} else {
+				repo.Watch(ctx, clientIP, nodePath)
+			}
+			if m.shuttingDown() {
+				httpErr = errShuttingDown
+				return
+			}
 			// directly return new result to client ,not change, for keep same as request with prev_version
-			currentVersion, result = m.metadataRepo.Root(clientIP, nodePath)
+			currentVersion, result = repo.Root(clientIP, nodePath)
+		}
+		if withEvents && result != nil {
+			result = withEventsResult(result, events)
 		}
 	} else {
-		currentVersion, result = m.metadataRepo.Root(clientIP, nodePath)
+		currentVersion, result = repo.Root(clientIP, nodePath)
+		result = m.tieredRead(ctx, repo, clientIP, nodePath, false, result)
 	}
 	if result == nil {
 		httpErr = NewHttpError(http.StatusNotFound, "Not found")
@@ -406,13 +958,64 @@ func (m *Metad) rootHandler(ctx context.Context, req *http.Request) (currentVers
 	return
 }
 
+// withEventsResult wrap the watch result with the change events, include the actor of every change.
+func withEventsResult(result interface{}, events []*store.Event) interface{} {
+	eventList := make([]interface{}, 0, len(events))
+	for _, e := range events {
+		eventList = append(eventList, map[string]interface{}{
+			"action": e.Action,
+			"path":   e.Path,
+			"value":  e.Value,
+			"actor":  e.Actor,
+		})
+	}
+	return map[string]interface{}{"value": result, "events": eventList, "schema_version": store.EventSchemaVersion}
+}
+
+// atRevision parse the at_revision parameter, return 0 if not present.
+func atRevision(req *http.Request) (int64, *HttpError) {
+	revisionStr := req.FormValue("at_revision")
+	if revisionStr == "" {
+		return 0, nil
+	}
+	revision, err := strconv.ParseInt(revisionStr, 10, 64)
+	if err != nil || revision <= 0 {
+		return 0, NewHttpError(http.StatusBadRequest, fmt.Sprintf("invalid at_revision parameter [%s]", revisionStr))
+	}
+	return revision, nil
+}
+
 func (m *Metad) selfHandler(ctx context.Context, req *http.Request) (currentVersion int64, result interface{}, httpErr *HttpError) {
-	clientIP := m.requestIP(req)
+	clientIP, repo, httpErr := m.clientRepo(req)
+	if httpErr != nil {
+		return
+	}
 	vars := mux.Vars(req)
 	nodePath := vars["nodePath"]
 	if nodePath == "" {
 		nodePath = "/"
 	}
+	revision, httpErr := atRevision(req)
+	if httpErr != nil {
+		return
+	}
+	profileKeys, httpErr := m.selfProfileKeys(req)
+	if httpErr != nil {
+		return
+	}
+	if revision > 0 {
+		var err error
+		result, err = repo.SelfAtRevision(clientIP, nodePath, revision)
+		if profileKeys != nil && result != nil {
+			result = profileSelf(result, nodePath, profileKeys)
+		}
+		if err != nil {
+			httpErr = NewHttpError(http.StatusBadRequest, err.Error())
+		} else if result == nil {
+			httpErr = NewHttpError(http.StatusNotFound, "Not found")
+		}
+		return
+	}
 	wait := strings.ToLower(req.FormValue("wait")) == "true"
 	// TODO this version may be not match the data, get version first, may be cause client repeat get data, but not lost change, so it work for now.
 	currentVersion = m.metadataRepo.DataVersion()
@@ -428,15 +1031,36 @@ func (m *Metad) selfHandler(ctx context.Context, req *http.Request) (currentVers
 		}
 		// if prevVersion < currentVersion, client lost change, so return immediately.
 		// if prevVersion > currentVersion, may be metad reboot and recount version, so return immediately, let client use new version.
+		withEvents := strings.ToLower(req.FormValue("with_events")) == "true"
+		events := []*store.Event{}
 		if prevVersion > 0 && prevVersion != currentVersion {
-			result = m.metadataRepo.Self(clientIP, nodePath)
+			result = repo.Self(clientIP, nodePath)
 		} else {
-			m.metadataRepo.WatchSelf(ctx, clientIP, nodePath)
+			if withEvents {
+				events = repo.WatchSelfEvents(ctx, clientIP, nodePath)
+			} else {
+				repo.WatchSelf(ctx, clientIP, nodePath)
+			}
+			if m.shuttingDown() {
+				httpErr = errShuttingDown
+				return
+			}
 			// directly return new result to client ,not change, for pre_version.
-			result = m.metadataRepo.Self(clientIP, nodePath)
+			result = repo.Self(clientIP, nodePath)
+		}
+		if profileKeys != nil && result != nil {
+			result = profileSelf(result, nodePath, profileKeys)
+			events = profileEvents(events, nodePath, profileKeys)
+		}
+		if withEvents && result != nil {
+			result = withEventsResult(result, events)
 		}
 	} else {
-		result = m.metadataRepo.Self(clientIP, nodePath)
+		result = repo.Self(clientIP, nodePath)
+		result = m.tieredRead(ctx, repo, clientIP, nodePath, true, result)
+		if profileKeys != nil && result != nil {
+			result = profileSelf(result, nodePath, profileKeys)
+		}
 	}
 	if result == nil {
 		httpErr = NewHttpError(http.StatusNotFound, "Not found")
@@ -485,6 +1109,9 @@ func respondSuccessDefault(w http.ResponseWriter, req *http.Request) {
 }
 
 func respondSuccess(w http.ResponseWriter, req *http.Request, val interface{}) int {
+	if leaf, ok := val.(*typedLeaf); ok {
+		return respondTypedLeaf(w, leaf)
+	}
 	switch contentType(req) {
 	case ContentText:
 		return respondText(w, req, val)
@@ -506,6 +1133,12 @@ func respondText(w http.ResponseWriter, req *http.Request, val interface{}) int
 	switch v := val.(type) {
 	case string:
 		buffer.WriteString(v)
+	case bool, int64:
+		buffer.WriteString(fmt.Sprintf("%v", v))
+	case float64:
+		buffer.WriteString(strconv.FormatFloat(v, 'f', -1, 64))
+	case *orderedDir:
+		v.writeText(&buffer, "/")
 	case map[string]interface{}:
 		fm := flatmap.Flatten(v)
 		var keys []string
@@ -534,20 +1167,16 @@ func respondJSON(w http.ResponseWriter, req *http.Request, val interface{}) int
 	}
 	prettyParam := req.FormValue("pretty")
 	pretty := prettyParam != "" && prettyParam != "false"
-	var bytes []byte
-	var err error
-	if pretty {
-		bytes, err = json.MarshalIndent(val, "", "  ")
-	} else {
-		bytes, err = json.Marshal(val)
-	}
-
-	if err == nil {
-		w.Write(bytes)
-	} else {
-		respondError(w, req, "Error serializing to JSON: "+err.Error(), http.StatusInternalServerError)
+	// the result tree is streamed to the response, the error can only be responded if nothing is written yet.
+	n, written, err := writeJSON(w, val, pretty)
+	if err != nil {
+		if written {
+			logger.Error("Error serializing to JSON after %d bytes written: %s", n, err.Error())
+		} else {
+			respondError(w, req, "Error serializing to JSON: "+err.Error(), http.StatusInternalServerError)
+		}
 	}
-	return len(bytes)
+	return n
 }
 
 func respondYAML(w http.ResponseWriter, req *http.Request, val interface{}) int {
@@ -562,17 +1191,24 @@ func respondYAML(w http.ResponseWriter, req *http.Request, val interface{}) int
 }
 
 func (m *Metad) requestIP(req *http.Request) string {
-	if m.config.EnableXff {
-		clientIp := req.Header.Get("X-Forwarded-For")
-		if len(clientIp) > 0 {
-			return clientIp
-		}
+	// the request from the unix socket has no ip, use the peer identity.
+	if identity := peerIdentity(req); identity != "" {
+		return identity
 	}
-
 	clientIp, _, err := net.SplitHostPort(req.RemoteAddr)
 	if err != nil {
 		logger.Error("Get RequestIP error: %s", err.Error())
 	}
+	if m.getConfig().EnableXff {
+		// the headers are only honored from the trusted proxies if configured.
+		if proxies := m.getTrustedProxies(); len(proxies) > 0 {
+			if forwarded := forwardedIP(proxies, clientIp, req); forwarded != "" {
+				return forwarded
+			}
+		} else if forwarded := req.Header.Get("X-Forwarded-For"); len(forwarded) > 0 {
+			return forwarded
+		}
+	}
 	return clientIp
 }
 
@@ -583,22 +1219,89 @@ func (m *Metad) handleWrapper(handler handleFunc) func(w http.ResponseWriter, re
 		requestID := m.generateRequestID()
 
 		ctx := context.WithValue(req.Context(), "requestID", requestID)
+		source := &readSource{}
+		ctx = context.WithValue(ctx, "readSource", source)
 		cancelCtx, cancelFun := context.WithCancel(ctx)
+		defer cancelFun()
+		var closeNotify <-chan bool
 		if x, ok := w.(http.CloseNotifier); ok {
-			closeNotify := x.CloseNotify()
-			go func() {
-				select {
-				case <-closeNotify:
-					cancelFun()
+			closeNotify = x.CloseNotify()
+		}
+		// cancel the long-poll when client closed or metad shutting down.
+		go func() {
+			select {
+			case <-closeNotify:
+			case <-m.shutdownChan:
+			case <-cancelCtx.Done():
+			}
+			cancelFun()
+		}()
+		if cw := m.compressWriter(w, req); cw != nil {
+			defer cw.Close()
+			w = cw
+		}
+		w, finishRecord := m.recordWriter(w, req, start)
+		defer finishRecord()
+		var version int64
+		var result interface{}
+		var cacheKey, etag string
+		var cached *cachedResponse
+		var p *projection
+		notModified := false
+		err := m.rateLimit(w, req)
+		if err == nil {
+			err = m.authorizeRequest(ctx, req, AuthzAPIMetadata)
+		}
+		// the request timeout cover the long-poll wait and the tiered read, the result is dropped if exceeded.
+		reqCtx := cancelCtx
+		if err == nil {
+			var timeout time.Duration
+			timeout, err = m.requestTimeout(req)
+			if timeout > 0 {
+				var cancelTimeout context.CancelFunc
+				reqCtx, cancelTimeout = context.WithTimeout(cancelCtx, timeout)
+				defer cancelTimeout()
+			}
+		}
+		if err == nil {
+			cacheKey, etag, version = m.responseCacheKey(req)
+			if etag != "" && matchETag(req.Header.Get("If-None-Match"), etag) {
+				notModified = true
+			} else if cached = m.cache.get(cacheKey, etag); cached != nil {
+				version = cached.version
+			} else if p, err = parseProjection(req, req.URL.Path); err == nil {
+				// the /self paths are not the data paths, ordered by name if created order.
+				if !isSelfPath(path.Clean(req.URL.Path)) {
+					p.createdOrder = m.metadataRepo.DataCreatedOrder
 				}
-			}()
-		} else {
-			defer cancelFun()
+				version, result, err = handler(reqCtx, req)
+				if err == nil && reqCtx.Err() == context.DeadlineExceeded {
+					result, err = nil, errRequestTimeout
+				}
+				if err == nil {
+					result = m.typedLeafResult(req, p.apply(m.refResult(req, m.typedResult(req, result))))
+				}
+				// the result of other tiers is not the local version, should not be cached.
+				if source.tier != "" && source.tier != ReadSourceLocal {
+					cacheKey, etag = "", ""
+				}
+			}
 		}
-		version, result, err := handler(cancelCtx, req)
 
 		w.Header().Add("X-Metad-RequestID", requestID)
 		w.Header().Add("X-Metad-Version", fmt.Sprintf("%d", version))
+		if m.servingStale() {
+			w.Header().Set("X-Metad-Stale", "true")
+		}
+		if source.tier != "" {
+			w.Header().Set("X-Metad-Read-Source", source.tier)
+		}
+		if p != nil && p.next != "" && err == nil {
+			w.Header().Set(continueTokenHeader, p.next)
+		}
+		if etag != "" && err == nil {
+			w.Header().Set("ETag", etag)
+		}
 		elapsed := time.Since(start)
 		status := 200
 		var len int
@@ -606,15 +1309,30 @@ func (m *Metad) handleWrapper(handler handleFunc) func(w http.ResponseWriter, re
 			status = err.Status
 			respondError(w, req, err.Message, status)
 			m.errorLog(requestID, req, status, err.Message)
+		} else if notModified {
+			status = http.StatusNotModified
+			w.WriteHeader(status)
+		} else if cached != nil {
+			w.Header().Set("Content-Type", cached.contentType)
+			len, _ = w.Write(cached.body)
 		} else {
 			if result == nil {
 				respondSuccessDefault(w, req)
+			} else if cacheKey != "" {
+				len = m.respondCached(w, req, cacheKey, etag, version, result)
+				debugResponse(requestID, req, false, result)
 			} else {
 				len = respondSuccess(w, req, result)
-				logger.Debug("%s\tRESP\t%v", requestID, result)
+				debugResponse(requestID, req, false, result)
 			}
 		}
 		m.requestLog(requestID, version, req, status, elapsed, len)
+		if (status == http.StatusOK || status == http.StatusNotModified) && m.metadataRepo.HasOverlays() {
+			m.metadataRepo.TouchOverlays(m.readPaths(req)...)
+		}
+		m.auditRead(requestID, req, version, status)
+		m.auditAccess(requestID, req, version, status, elapsed, len)
+		m.recordSLO(SLOGroupMetadata, req, status, elapsed)
 	}
 }
 
@@ -624,11 +1342,33 @@ func (m *Metad) manageWrapper(manager manageFunc) func(w http.ResponseWriter, re
 		start := time.Now()
 		requestID := m.generateRequestID()
 		ctx := context.WithValue(req.Context(), "requestID", requestID)
-		result, err := manager(ctx, req)
+		header := http.Header{}
+		ctx = context.WithValue(ctx, "responseHeader", header)
+		var result interface{}
+		err := m.authorizeRequest(ctx, req, AuthzAPIManage)
+		if err == nil {
+			err = m.checkRole(req)
+		}
+		if err == nil {
+			err = m.checkFreeze(req)
+		}
+		if err == nil {
+			err = m.checkLeader(req)
+		}
+		if err == nil {
+			err = m.checkReplica(req)
+		}
+		if err == nil {
+			result, err = manager(ctx, req)
+		}
 		version := m.metadataRepo.DataVersion()
 
 		w.Header().Add("X-Metad-RequestID", requestID)
 		w.Header().Add("X-Metad-Version", fmt.Sprintf("%d", version))
+		// the handler headers are also responded with the error, such as the ETag of the conditional write failed.
+		for k, v := range header {
+			w.Header()[k] = v
+		}
 		elapsed := time.Since(start)
 		status := 200
 		var len int
@@ -641,10 +1381,12 @@ func (m *Metad) manageWrapper(manager manageFunc) func(w http.ResponseWriter, re
 				respondSuccessDefault(w, req)
 			} else {
 				len = respondSuccess(w, req, result)
-				logger.Debug("%s\tRESP\t%v", requestID, result)
+				debugResponse(requestID, req, true, result)
 			}
 		}
 		m.requestLog(requestID, version, req, status, elapsed, len)
+		m.auditManage(requestID, req, version, status)
+		m.recordSLO(SLOGroupManage, req, status, elapsed)
 	}
 }
 
@@ -653,14 +1395,49 @@ func (m *Metad) generateRequestID() string {
 	return fmt.Sprintf("REQ-%d", id)
 }
 
+// setResponseHeader set the response header of the manage request from the manage handler.
+func setResponseHeader(ctx context.Context, key string, value string) {
+	if header, ok := ctx.Value("responseHeader").(http.Header); ok {
+		header.Set(key, value)
+	}
+}
+
+// requestLogger return the logger with the request id of the ctx.
+func requestLogger(ctx context.Context) *logger.Entry {
+	return logger.WithFields(logger.Fields{"request_id": ctx.Value("requestID")})
+}
+
+func (m *Metad) requestFields(requestID string, req *http.Request, status int) logger.Fields {
+	clientIP := m.requestIP(req)
+	fields := logger.Fields{
+		"request_id":     requestID,
+		"method":         req.Method,
+		"client_ip":      clientIP,
+		"uri":            req.URL.RequestURI(),
+		"content_length": req.ContentLength,
+		"status":         status,
+	}
+	// the client identity used for mapping, if not the client ip.
+	if host, err := m.requestHost(req); err == nil && host != clientIP {
+		fields["host"] = host
+	}
+	return fields
+}
+
 func (m *Metad) requestLog(requestID string, version int64, req *http.Request, status int, elapsed time.Duration, len int) {
-	logger.Info("%s\t%d\t%s\t%s\t%s\t%v\t%v\t%v\t%v", requestID, version, req.Method, m.requestIP(req), req.URL.RequestURI(), req.ContentLength, status, int64(elapsed.Seconds()*1000), len)
+	fields := m.requestFields(requestID, req, status)
+	fields["version"] = version
+	fields["latency_ms"] = int64(elapsed.Seconds() * 1000)
+	fields["bytes"] = len
+	logger.WithFields(fields).Info("access")
 }
 
 func (m *Metad) errorLog(requestID string, req *http.Request, status int, msg string) {
+	fields := m.requestFields(requestID, req, status)
+	fields["error"] = msg
 	if status == 500 {
-		logger.Error("ERR\t%s\t%s\t%s\t%s\t%v\t%v\t%s", requestID, req.Method, m.requestIP(req), req.RequestURI, req.ContentLength, status, msg)
+		logger.WithFields(fields).Error("request error")
 	} else {
-		logger.Warn("ERR\t%s\t%s\t%s\t%s\t%v\t%v\t%s", requestID, req.Method, m.requestIP(req), req.RequestURI, req.ContentLength, status, msg)
+		logger.WithFields(fields).Warn("request error")
 	}
 }
@@ -9,18 +9,49 @@
 package metad
 
 import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	crand "crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
+	"io/ioutil"
+	"math"
+	"math/big"
 	"math/rand"
+	"net"
+	"net/http"
 	"net/http/httptest"
+	"os"
+	"path"
 	"reflect"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/gorilla/mux"
+	"github.com/klauspost/compress/zstd"
+	yaml "gopkg.in/yaml.v2"
+
 	. "openpitrix.io/metad/pkg/assert"
+	"openpitrix.io/metad/pkg/audit"
+	"openpitrix.io/metad/pkg/bench"
+	"openpitrix.io/metad/pkg/confd"
 	"openpitrix.io/metad/pkg/logger"
+	"openpitrix.io/metad/pkg/metadata"
+	"openpitrix.io/metad/pkg/redact"
+	"openpitrix.io/metad/pkg/store"
 	"openpitrix.io/metad/pkg/util"
 )
 
@@ -280,6 +311,70 @@ func TestMetadWatch(t *testing.T) {
 	Assert(t, "192.168.3.1" == fmt.Sprintf("%v", parse(w)))
 }
 
+func TestMetadWatchActor(t *testing.T) {
+	metad := NewTestMetad()
+	defer metad.Stop()
+
+	req := httptest.NewRequest("PUT", "/v1/data/", strings.NewReader(`{"nodes":{"1":{"ip":"192.168.1.1"}}}`))
+	w := httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+
+	req = httptest.NewRequest("PUT", "/v1/mapping/", strings.NewReader(`{"192.0.2.1":{"node":"/nodes/1"}}`))
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+
+	time.Sleep(sleepTime)
+
+	watch := func(uri string) chan interface{} {
+		results := make(chan interface{}, 1)
+		go func() {
+			req := httptest.NewRequest("GET", uri, nil)
+			req.Header.Set("accept", "application/json")
+			w := httptest.NewRecorder()
+			metad.router.ServeHTTP(w, req)
+			Assert(t, 200 == w.Code)
+			results <- parse(w)
+		}()
+		time.Sleep(sleepTime)
+		return results
+	}
+
+	results := watch("/nodes/1?wait=true&with_events=true")
+	req = httptest.NewRequest("PUT", "/v1/data/nodes/1/ip", strings.NewReader(`"192.168.2.1"`))
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+	result := <-results
+	Assert(t, "192.168.2.1" == util.GetMapValue(result, "/value/ip"))
+	Assert(t, "UPDATE" == util.GetMapValue(result, "/events/0/action"))
+	Assert(t, "/nodes/1/ip" == util.GetMapValue(result, "/events/0/path"))
+	Assert(t, "manage:192.0.2.1" == util.GetMapValue(result, "/events/0/actor"))
+	Assert(t, strconv.Itoa(store.EventSchemaVersion) == util.GetMapValue(result, "/schema_version"))
+
+	// change not made by manage api.
+	results = watch("/self/node?wait=true&with_events=true")
+	err := metad.metadataRepo.PutData("/nodes/1/ip", "192.168.3.1", false)
+	Assert(t, nil == err)
+	result = <-results
+	Assert(t, "192.168.3.1" == util.GetMapValue(result, "/value/ip"))
+	Assert(t, "/node/ip" == util.GetMapValue(result, "/events/0/path"))
+	Assert(t, "backend" == util.GetMapValue(result, "/events/0/actor"))
+
+	// a failed change should not be tracked.
+	req = httptest.NewRequest("POST", "/v1/data:copy", strings.NewReader(`{"from":"/not_exist","to":"/nodes/1"}`))
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 400 == w.Code)
+	results = watch("/nodes/1?wait=true&with_events=true")
+	err = metad.metadataRepo.PutData("/nodes/1/ip", "192.168.4.1", false)
+	Assert(t, nil == err)
+	result = <-results
+	Assert(t, "192.168.4.1" == util.GetMapValue(result, "/value/ip"))
+	Assert(t, "backend" == util.GetMapValue(result, "/events/0/actor"))
+}
+
 func TestMetadWatchSelf(t *testing.T) {
 	metad := NewTestMetad()
 
@@ -506,12 +601,4416 @@ func TestMetadAccessRule(t *testing.T) {
 	Assert(t, "" == util.GetMapValue(parse(w), "/clusters/cl-1/name"))
 }
 
-func NewTestMetad() *Metad {
-	group := fmt.Sprintf("/group%v", rand.Intn(10000))
-	config := &Config{
-		Backend: testBackend,
-		Group:   group,
+func TestMetadRelease(t *testing.T) {
+	metad := NewTestMetad()
+	defer metad.Stop()
+
+	req := httptest.NewRequest("PUT", "/v1/data/", strings.NewReader(`{"clusters":{"cl-1":{"version":"v1","tmp":"t"}}}`))
+	w := httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+
+	time.Sleep(sleepTime)
+
+	releaseJson := `{"name":"r1","changes":[
+	{"path":"/clusters/cl-1/version","action":"put","value":"v2"},
+	{"path":"/clusters/cl-1/new","action":"put","value":{"k":"v"}},
+	{"path":"/clusters/cl-1/tmp","action":"delete"}]}`
+	req = httptest.NewRequest("POST", "/v1/release", strings.NewReader(releaseJson))
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+
+	// rollback a release not applied.
+	req = httptest.NewRequest("POST", "/v1/release/r1/rollback", nil)
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 409 == w.Code, w.Code)
+
+	req = httptest.NewRequest("POST", "/v1/release/r1/apply", nil)
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+
+	time.Sleep(sleepTime)
+
+	req = httptest.NewRequest("GET", "/v1/data/clusters/cl-1", nil)
+	req.Header.Set("accept", "application/json")
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+	Assert(t, reflect.DeepEqual(map[string]interface{}{"version": "v2", "new": map[string]interface{}{"k": "v"}}, parse(w)))
+
+	req = httptest.NewRequest("POST", "/v1/release/r1/rollback", nil)
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+
+	time.Sleep(sleepTime)
+
+	req = httptest.NewRequest("GET", "/v1/data/clusters/cl-1", nil)
+	req.Header.Set("accept", "application/json")
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+	Assert(t, reflect.DeepEqual(map[string]interface{}{"version": "v1", "tmp": "t"}, parse(w)))
+
+	req = httptest.NewRequest("GET", "/v1/release/r1", nil)
+	req.Header.Set("accept", "application/json")
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+	Assert(t, "rolled_back" == util.GetMapValue(parse(w), "/status"))
+
+	// rollback again.
+	req = httptest.NewRequest("POST", "/v1/release/r1/rollback", nil)
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 409 == w.Code, w.Code)
+
+	req = httptest.NewRequest("DELETE", "/v1/release/r1", nil)
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+
+	req = httptest.NewRequest("GET", "/v1/release/r1", nil)
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 404 == w.Code)
+
+	// concurrent applies, only one should pass the status check.
+	req = httptest.NewRequest("POST", "/v1/release", strings.NewReader(`{"name":"r2","changes":[{"path":"/clusters/cl-1/version","action":"put","value":"v3"}]}`))
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+
+	time.Sleep(sleepTime)
+
+	var applied int32
+	wg := sync.WaitGroup{}
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest("POST", "/v1/release/r2/apply", nil)
+			w := httptest.NewRecorder()
+			metad.manageRouter.ServeHTTP(w, req)
+			if w.Code == 200 {
+				atomic.AddInt32(&applied, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	Assert(t, 1 == applied, applied)
+	req = httptest.NewRequest("POST", "/v1/release/r2/apply", nil)
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 409 == w.Code, w.Code)
+
+	// the backups are read from the backend, the write just before the apply is restored by the rollback
+	// even if the local store has not synced it.
+	req = httptest.NewRequest("POST", "/v1/release", strings.NewReader(`{"name":"r3","changes":[{"path":"/clusters/cl-2/version","action":"put","value":"v2"}]}`))
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+	time.Sleep(sleepTime)
+	Assert(t, nil == metad.metadataRepo.PutData("/clusters/cl-2/version", "v1", true))
+	req = httptest.NewRequest("POST", "/v1/release/r3/apply", nil)
+	req.Header.Set("accept", "application/json")
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+	Assert(t, "v1" == util.GetMapValue(parse(w), "/backups/0/value"), w.Body.String())
+	time.Sleep(sleepTime)
+	req = httptest.NewRequest("POST", "/v1/release/r3/rollback", nil)
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+	time.Sleep(sleepTime)
+	Assert(t, "v1" == metad.metadataRepo.GetData("/clusters/cl-2/version"))
+}
+
+func TestMetadAnnotation(t *testing.T) {
+	metad := NewTestMetad()
+	defer metad.Stop()
+
+	req := httptest.NewRequest("PUT", "/v1/annotation/clusters/cl-1", strings.NewReader(`{"ticket":"OPS-1","tags":["prod"],"expiry":"2000-01-01T00:00:00Z"}`))
+	w := httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+
+	req = httptest.NewRequest("PUT", "/v1/annotation/clusters/cl-2", strings.NewReader(`{"owner":"team2"}`))
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+
+	req = httptest.NewRequest("PUT", "/v1/annotation/clusters/cl-3", strings.NewReader(`{"expiry":"tomorrow"}`))
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 400 == w.Code)
+
+	req = httptest.NewRequest("GET", "/v1/annotation/clusters/cl-1", nil)
+	req.Header.Set("accept", "application/json")
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+	Assert(t, "OPS-1" == util.GetMapValue(parse(w), "/ticket"))
+
+	req = httptest.NewRequest("GET", "/v1/annotation/clusters?recursive=true", nil)
+	req.Header.Set("accept", "application/json")
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+	Assert(t, 2 == len(parse(w).([]interface{})))
+
+	req = httptest.NewRequest("GET", "/v1/annotation?tag=prod&expired=true", nil)
+	req.Header.Set("accept", "application/json")
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+	Assert(t, "/clusters/cl-1" == util.GetMapValue(parse(w), "/0/path"))
+	Assert(t, 1 == len(parse(w).([]interface{})))
+
+	req = httptest.NewRequest("DELETE", "/v1/annotation/clusters/cl-1", nil)
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+
+	req = httptest.NewRequest("GET", "/v1/annotation/clusters/cl-1", nil)
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 404 == w.Code)
+}
+
+func TestMetadToken(t *testing.T) {
+	metad := NewTestMetadWithConfig(&Config{AdminToken: "bootstrap"})
+	defer metad.Stop()
+
+	req := httptest.NewRequest("PUT", "/v1/data/", strings.NewReader(`{"nodes":{"1":{"name":"node1"}}}`))
+	w := httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+
+	req = httptest.NewRequest("POST", "/v1/mapping", strings.NewReader(`{"192.168.1.1":{"node":"/nodes/1"}}`))
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+
+	// creating token require the admin token.
+	req = httptest.NewRequest("POST", "/v1/token", strings.NewReader(`{"admin":true}`))
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 401 == w.Code, w.Code)
+	req = httptest.NewRequest("POST", "/v1/token", strings.NewReader(`{"admin":true}`))
+	req.Header.Set("Authorization", "Bearer invalid")
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 401 == w.Code, w.Code)
+	Assert(t, 0 == len(metad.metadataRepo.GetTokens()))
+
+	req = httptest.NewRequest("POST", "/v1/token", strings.NewReader(`{"host":"192.168.1.1"}`))
+	req.Header.Set("accept", "application/json")
+	req.Header.Set("Authorization", "Bearer bootstrap")
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+	token := util.GetMapValue(parse(w), "/token")
+	id := util.GetMapValue(parse(w), "/id")
+	Assert(t, token != "")
+
+	time.Sleep(sleepTime)
+
+	req = httptest.NewRequest("GET", "/self/node/name", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("accept", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	w = httptest.NewRecorder()
+	metad.router.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+	Assert(t, reflect.DeepEqual("node1", parse(w)))
+
+	req = httptest.NewRequest("GET", "/self/node/name", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("Authorization", "Bearer invalid")
+	w = httptest.NewRecorder()
+	metad.router.ServeHTTP(w, req)
+	Assert(t, 401 == w.Code)
+
+	// the host token is not admin.
+	req = httptest.NewRequest("POST", "/v1/token", strings.NewReader(`{"admin":true}`))
+	req.Header.Set("Authorization", "Bearer "+token)
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 403 == w.Code, w.Code)
+	req = httptest.NewRequest("GET", "/v1/token", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 403 == w.Code, w.Code)
+	req = httptest.NewRequest("GET", "/v1/token", nil)
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 401 == w.Code, w.Code)
+	req = httptest.NewRequest("GET", "/v1/token", nil)
+	req.Header.Set("accept", "application/json")
+	req.Header.Set("Authorization", "Bearer bootstrap")
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code, w.Code)
+	Assert(t, strings.Contains(w.Body.String(), id), w.Body.String())
+	req = httptest.NewRequest("DELETE", "/v1/token/"+id, nil)
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 401 == w.Code, w.Code)
+
+	req = httptest.NewRequest("DELETE", "/v1/token/"+id, nil)
+	req.Header.Set("Authorization", "Bearer bootstrap")
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+
+	req = httptest.NewRequest("GET", "/self/node/name", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("Authorization", "Bearer "+token)
+	w = httptest.NewRecorder()
+	metad.router.ServeHTTP(w, req)
+	Assert(t, 401 == w.Code)
+}
+
+func TestMetadCertIdentity(t *testing.T) {
+	metad := NewTestMetad()
+	defer metad.Stop()
+
+	req := httptest.NewRequest("PUT", "/v1/data/", strings.NewReader(`{"nodes":{"1":{"name":"node1"}}}`))
+	w := httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+
+	req = httptest.NewRequest("POST", "/v1/mapping", strings.NewReader(`{"node1.example.com":{"node":"/nodes/1"}}`))
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+
+	time.Sleep(sleepTime)
+
+	req = httptest.NewRequest("GET", "/self/node/name", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("accept", "application/json")
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "node1.example.com"}}
+	req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	w = httptest.NewRecorder()
+	metad.router.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+	Assert(t, reflect.DeepEqual("node1", parse(w)))
+}
+
+func TestMetadOwnership(t *testing.T) {
+	metad := NewTestMetadWithConfig(&Config{AdminToken: "bootstrap"})
+	defer metad.Stop()
+
+	createToken := func(body string) string {
+		req := httptest.NewRequest("POST", "/v1/token", strings.NewReader(body))
+		req.Header.Set("accept", "application/json")
+		req.Header.Set("Authorization", "Bearer bootstrap")
+		w := httptest.NewRecorder()
+		metad.manageRouter.ServeHTTP(w, req)
+		Assert(t, 200 == w.Code)
+		return util.GetMapValue(parse(w), "/token")
+	}
+	team1 := createToken(`{"team":"team1"}`)
+	team2 := createToken(`{"team":"team2"}`)
+	admin := createToken(`{"admin":true}`)
+
+	req := httptest.NewRequest("PUT", "/v1/annotation/clusters/cl-1", strings.NewReader(`{"owner":"team1"}`))
+	w := httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+
+	time.Sleep(sleepTime)
+
+	update := func(nodePath string, token string) int {
+		req := httptest.NewRequest("PUT", "/v1/data"+nodePath, strings.NewReader(`{"k":"v"}`))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		metad.manageRouter.ServeHTTP(w, req)
+		return w.Code
 	}
+	Assert(t, 403 == update("/clusters/cl-1/env", ""))
+	Assert(t, 403 == update("/clusters/cl-1/env", team2))
+	// parent path contains sub path owned by other team.
+	Assert(t, 403 == update("/clusters", team2))
+	Assert(t, 200 == update("/clusters/cl-1/env", team1))
+	Assert(t, 200 == update("/clusters/cl-2", ""))
+	Assert(t, 200 == update("/clusters", admin))
+
+	time.Sleep(sleepTime)
+
+	req = httptest.NewRequest("GET", "/v1/override", nil)
+	req.Header.Set("accept", "application/json")
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+	Assert(t, "/clusters" == util.GetMapValue(parse(w), "/0/path"))
+	Assert(t, "team1" == util.GetMapValue(parse(w), "/0/owners/0"))
+}
+
+func TestMetadMove(t *testing.T) {
+	metad := NewTestMetad()
+	defer metad.Stop()
+
+	req := httptest.NewRequest("PUT", "/v1/data/", strings.NewReader(`{"clusters":{"cl-1":{"env":{"name":"cl-1"},"size":"2"}}}`))
+	w := httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+
+	req = httptest.NewRequest("PUT", "/v1/mapping/", strings.NewReader(`{"192.0.2.1":{"env":"/clusters/cl-1/env","cluster":"/clusters/cl-1","other":"/clusters/cl-10"}}`))
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+
+	time.Sleep(sleepTime)
+
+	move := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/v1/data:move", strings.NewReader(body))
+		req.Header.Set("accept", "application/json")
+		w := httptest.NewRecorder()
+		metad.manageRouter.ServeHTTP(w, req)
+		return w
+	}
+	Assert(t, 400 == move(`{"from":"/clusters/cl-1","to":"/clusters/cl-1/sub"}`).Code)
+	Assert(t, 400 == move(`{"from":"/clusters/cl-3","to":"/clusters/cl-4"}`).Code)
+
+	w = move(`{"from":"/clusters/cl-1","to":"/clusters/cl-2"}`)
+	Assert(t, 200 == w.Code, w.Body.String())
+	Assert(t, "/192.0.2.1/cluster" == util.GetMapValue(parse(w), "/mappings/0"))
+	Assert(t, "/192.0.2.1/env" == util.GetMapValue(parse(w), "/mappings/1"))
+
+	time.Sleep(sleepTime)
+
+	req = httptest.NewRequest("GET", "/v1/data/clusters/cl-1", nil)
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 404 == w.Code)
+
+	req = httptest.NewRequest("GET", "/v1/data/clusters/cl-2/env/name", nil)
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+	Assert(t, "cl-1" == w.Body.String())
+
+	req = httptest.NewRequest("GET", "/v1/mapping/192.0.2.1", nil)
+	req.Header.Set("accept", "application/json")
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, "/clusters/cl-2" == util.GetMapValue(parse(w), "/cluster"))
+	Assert(t, "/clusters/cl-2/env" == util.GetMapValue(parse(w), "/env"))
+	Assert(t, "/clusters/cl-10" == util.GetMapValue(parse(w), "/other"))
+
+	req = httptest.NewRequest("GET", "/self/env/name", nil)
+	w = httptest.NewRecorder()
+	metad.router.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+	Assert(t, "cl-1" == w.Body.String())
+}
+
+func TestMetadCopy(t *testing.T) {
+	metad := NewTestMetad()
+	defer metad.Stop()
+
+	req := httptest.NewRequest("PUT", "/v1/data/clusters/blue", strings.NewReader(`{"name":"blue","subnet":"10.0.1.0/24","env":{"cluster":"blue","zone":"pek3"},"secret":{"password":"pwd"}}`))
+	w := httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+
+	time.Sleep(sleepTime)
+
+	body := `{"from":"/clusters/blue","to":"/clusters/green","exclude":["/secret"],
+		"substitutions":[{"old":"blue","new":"green"},{"old":"10.0.1.","new":"10.0.2."}]}`
+	req = httptest.NewRequest("POST", "/v1/data:copy", strings.NewReader(body))
+	req.Header.Set("accept", "application/json")
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code, w.Body.String())
+	Assert(t, "4" == util.GetMapValue(parse(w), "/keys"))
+
+	time.Sleep(sleepTime)
+
+	// target exist.
+	req = httptest.NewRequest("POST", "/v1/data:copy", strings.NewReader(body))
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 400 == w.Code)
+
+	req = httptest.NewRequest("GET", "/v1/data/clusters/green", nil)
+	req.Header.Set("accept", "application/json")
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+	Assert(t, "green" == util.GetMapValue(parse(w), "/name"))
+	Assert(t, "10.0.2.0/24" == util.GetMapValue(parse(w), "/subnet"))
+	Assert(t, "green" == util.GetMapValue(parse(w), "/env/cluster"))
+	Assert(t, "pek3" == util.GetMapValue(parse(w), "/env/zone"))
+	Assert(t, "" == util.GetMapValue(parse(w), "/secret/password"))
+
+	req = httptest.NewRequest("POST", "/v1/data:copy", strings.NewReader(`{"from":"/clusters/blue","to":"/clusters/red","include":["/env/*"]}`))
+	req.Header.Set("accept", "application/json")
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code, w.Body.String())
+	Assert(t, "2" == util.GetMapValue(parse(w), "/keys"))
+}
+
+func TestMetadUnreferenced(t *testing.T) {
+	metad := NewTestMetad()
+	defer metad.Stop()
+
+	req := httptest.NewRequest("PUT", "/v1/data/", strings.NewReader(`{"clusters":{"cl-1":{"env":{"a":"1"},"old":{"b":"2","c":"3"}},"cl-2":{"x":"1"}},"nodes":{"1":"n1"},"tmp":"t"}`))
+	w := httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+
+	req = httptest.NewRequest("PUT", "/v1/mapping/", strings.NewReader(`{"192.0.2.1":{"env":"/clusters/cl-1/env","nodes":"/nodes"}}`))
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+
+	time.Sleep(sleepTime)
+
+	req = httptest.NewRequest("GET", "/v1/analysis/unreferenced", nil)
+	req.Header.Set("accept", "application/json")
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+	var result []map[string]interface{}
+	Assert(t, nil == json.Unmarshal(w.Body.Bytes(), &result))
+	Assert(t, 3 == len(result), w.Body.String())
+	Assert(t, "/clusters/cl-1/old" == result[0]["path"])
+	Assert(t, float64(2) == result[0]["keys"])
+	Assert(t, "/clusters/cl-2" == result[1]["path"])
+	Assert(t, "/tmp" == result[2]["path"])
+}
+
+func TestMetadAtRevision(t *testing.T) {
+	metad := NewTestMetad()
+	defer metad.Stop()
+
+	for _, uri := range []string{"/nodes?at_revision=abc", "/self?at_revision=-1", "/nodes?at_revision=1", "/self?at_revision=1"} {
+		req := httptest.NewRequest("GET", uri, nil)
+		w := httptest.NewRecorder()
+		metad.router.ServeHTTP(w, req)
+		// local backend does not support read at revision.
+		Assert(t, 400 == w.Code, uri)
+	}
+}
+
+func TestMetadHealth(t *testing.T) {
+	metad := NewTestMetad()
+	defer metad.Stop()
+
+	for _, uri := range []string{"/healthz", "/readyz"} {
+		req := httptest.NewRequest("GET", uri, nil)
+		w := httptest.NewRecorder()
+		metad.manageRouter.ServeHTTP(w, req)
+		Assert(t, 200 == w.Code, uri)
+	}
+	req := httptest.NewRequest("GET", "/readyz", nil)
+	w := httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, "ready" == util.GetMapValue(parse(w), "/status"))
+	Assert(t, "true" == util.GetMapValue(parse(w), "/synced"))
+}
+
+func TestMetadAudit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metad_audit")
+	Assert(t, err == nil, err)
+	defer os.RemoveAll(dir)
+	auditFile := path.Join(dir, "audit.log")
+
+	metad := NewTestMetadWithConfig(&Config{
+		AuditFile:        auditFile,
+		AuditSecretPaths: []string{"/secrets"},
+	})
+	defer metad.Stop()
+
+	req := httptest.NewRequest("PUT", "/v1/data/", strings.NewReader(`{"secrets":{"db":{"password":"pwd"}},"nodes":{"1":"n1"}}`))
+	w := httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+
+	req = httptest.NewRequest("PUT", "/v1/mapping/", strings.NewReader(`{"192.0.2.1":{"db":"/secrets/db","node":"/nodes/1"}}`))
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+
+	time.Sleep(sleepTime)
+
+	// manage read is not audited.
+	req = httptest.NewRequest("GET", "/v1/data/secrets", nil)
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+
+	for _, uri := range []string{"/nodes/1", "/secrets/db/password", "/self/node", "/self/db"} {
+		req = httptest.NewRequest("GET", uri, nil)
+		w = httptest.NewRecorder()
+		metad.router.ServeHTTP(w, req)
+		Assert(t, 200 == w.Code, uri)
+	}
+
+	data, err := ioutil.ReadFile(auditFile)
+	Assert(t, err == nil, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	Assert(t, 4 == len(lines), string(data))
+	entries := make([]map[string]interface{}, 0, len(lines))
+	for _, line := range lines {
+		entry := make(map[string]interface{})
+		Assert(t, nil == json.Unmarshal([]byte(line), &entry), line)
+		entries = append(entries, entry)
+	}
+	Assert(t, "manage" == entries[0]["api"])
+	Assert(t, "PUT" == entries[0]["action"])
+	Assert(t, "/v1/data/" == entries[0]["path"])
+	Assert(t, "/v1/mapping/" == entries[1]["path"])
+	Assert(t, "data" == entries[2]["api"])
+	Assert(t, "read" == entries[2]["action"])
+	Assert(t, "/secrets/db/password" == entries[2]["path"])
+	Assert(t, "192.0.2.1" == entries[2]["client_ip"])
+	Assert(t, entries[2]["revision"].(float64) > 0)
+	Assert(t, "/self/db" == entries[3]["path"])
+}
+
+func TestMetadAuditSIEM(t *testing.T) {
+	var lock sync.Mutex
+	var entries []audit.Entry
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		b, _ := ioutil.ReadAll(req.Body)
+		lock.Lock()
+		defer lock.Unlock()
+		for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+			entry := audit.Entry{}
+			Assert(t, nil == json.Unmarshal([]byte(line), &entry), line)
+			entries = append(entries, entry)
+		}
+	}))
+	defer server.Close()
+
+	metad := NewTestMetadWithConfig(&Config{
+		AuditSIEM:       server.URL,
+		AuditSIEMBuffer: 100,
+		AuditSIEMAccess: true,
+	})
+
+	req := httptest.NewRequest("PUT", "/v1/data/nodes/1", strings.NewReader(`"n1"`))
+	w := httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+
+	req = httptest.NewRequest("PUT", "/v1/mapping/192.0.2.1", strings.NewReader(`{"node":"/nodes/1"}`))
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+
+	time.Sleep(sleepTime)
+
+	req = httptest.NewRequest("GET", "/self/node", nil)
+	w = httptest.NewRecorder()
+	metad.router.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+
+	// stop flush the buffered entries.
+	metad.Stop()
+
+	lock.Lock()
+	defer lock.Unlock()
+	Assert(t, 3 == len(entries), entries)
+	Assert(t, "manage" == entries[0].API && "PUT" == entries[0].Action)
+	Assert(t, "data" == entries[2].API && "access" == entries[2].Action)
+	Assert(t, "/self/node" == entries[2].Path)
+	Assert(t, "192.0.2.1" == entries[2].ClientIP)
+	Assert(t, entries[2].Bytes > 0)
+}
+
+func TestMetadDeadLetter(t *testing.T) {
+	var failing int32 = 1
+	received := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		b, _ := ioutil.ReadAll(req.Body)
+		received <- string(b)
+	}))
+	defer server.Close()
+
+	metad := NewTestMetadWithConfig(&Config{
+		NotifyWebhooks: []string{"/nodes=" + server.URL},
+		NotifyRetries:  0,
+	})
+	defer metad.Stop()
+
+	req := httptest.NewRequest("PUT", "/v1/data/nodes/1", strings.NewReader(`{"ip":"192.168.1.1"}`))
+	w := httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+
+	time.Sleep(sleepTime * 5)
+
+	req = httptest.NewRequest("GET", "/v1/deadletter", nil)
+	req.Header.Set("Accept", "application/json")
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+	var deadLetters []map[string]interface{}
+	err := json.Unmarshal(w.Body.Bytes(), &deadLetters)
+	Assert(t, err == nil, err)
+	Assert(t, 1 == len(deadLetters), w.Body.String())
+	id := deadLetters[0]["id"].(string)
+	Assert(t, server.URL == deadLetters[0]["url"])
+	Assert(t, "/nodes/1/ip" == util.GetMapValue(deadLetters[0], "/notification/events/0/path"))
+	Assert(t, "192.168.1.1" == util.GetMapValue(deadLetters[0], "/notification/events/0/value"))
+
+	// replay fail, the dead letter is kept.
+	req = httptest.NewRequest("POST", "/v1/deadletter/"+id+"/replay", nil)
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, http.StatusBadGateway == w.Code)
+
+	time.Sleep(sleepTime)
+
+	req = httptest.NewRequest("GET", "/v1/deadletter/"+id, nil)
+	req.Header.Set("Accept", "application/json")
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+	Assert(t, "2" == util.GetMapValue(parse(w), "/attempts"))
+
+	atomic.StoreInt32(&failing, 0)
+
+	req = httptest.NewRequest("POST", "/v1/deadletter/"+id+"/replay", nil)
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+	Assert(t, strings.Contains(<-received, id))
+
+	time.Sleep(sleepTime)
+
+	req = httptest.NewRequest("GET", "/v1/deadletter/"+id, nil)
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 404 == w.Code)
+
+	// change out of prefix is not notified.
+	req = httptest.NewRequest("PUT", "/v1/data/clusters/1", strings.NewReader(`{"name":"c1"}`))
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+
+	req = httptest.NewRequest("PUT", "/v1/data/nodes/2", strings.NewReader(`{"ip":"192.168.1.2"}`))
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+
+	select {
+	case body := <-received:
+		m := make(map[string]interface{})
+		err = json.Unmarshal([]byte(body), &m)
+		Assert(t, err == nil, err)
+		Assert(t, "/nodes" == m["prefix"])
+		Assert(t, "/nodes/2/ip" == util.GetMapValue(m, "/events/0/path"), body)
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook not notified")
+	}
+
+	// discard
+	metad.metadataRepo.PutDeadLetter(&metadata.DeadLetter{ID: "1-test", URL: server.URL})
+	time.Sleep(sleepTime)
+	req = httptest.NewRequest("DELETE", "/v1/deadletter/1-test", nil)
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+	time.Sleep(sleepTime)
+	Assert(t, nil == metad.metadataRepo.GetDeadLetter("1-test"))
+}
+
+func TestMetadReloadConfig(t *testing.T) {
+	received := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		b, _ := ioutil.ReadAll(req.Body)
+		received <- string(b)
+	}))
+	defer server.Close()
+
+	initial, err := loadConfig()
+	Assert(t, err == nil, err)
+	initial.LogLevel = "debug"
+	metad := NewTestMetadWithConfig(initial)
+	defer metad.Stop()
+
+	tmpFile, err := ioutil.TempFile("", "metad_config")
+	Assert(t, err == nil, err)
+	defer os.Remove(tmpFile.Name())
+	changed := *initial
+	changed.Listen = ":8080"
+	changed.ReadyMaxSyncLag = 5
+	changed.NotifyWebhooks = []string{"/nodes=" + server.URL}
+	data, err := yaml.Marshal(&changed)
+	Assert(t, err == nil, err)
+	_, err = tmpFile.Write(data)
+	Assert(t, err == nil, err)
+	tmpFile.Close()
+
+	// no config file to reload.
+	Assert(t, metad.Reload() != nil)
+
+	oldConfigFile := configFile
+	configFile = tmpFile.Name()
+	defer func() { configFile = oldConfigFile }()
+
+	config, err := loadConfig()
+	Assert(t, err == nil, err)
+	restartRequired, err := metad.applyConfig(config)
+	Assert(t, err == nil, err)
+	Assert(t, reflect.DeepEqual([]string{"listen"}, restartRequired), restartRequired)
+	Assert(t, 5 == metad.getConfig().ReadyMaxSyncLag)
+	Assert(t, ":9180" == metad.getConfig().Listen)
+
+	req := httptest.NewRequest("PUT", "/v1/data/nodes/1", strings.NewReader(`{"ip":"192.168.1.1"}`))
+	w := httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+
+	select {
+	case body := <-received:
+		Assert(t, strings.Contains(body, "/nodes/1/ip"), body)
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook not notified after reload")
+	}
+
+	// invalid webhook keep the old config.
+	config.NotifyWebhooks = []string{"/nodes"}
+	_, err = metad.applyConfig(config)
+	Assert(t, err != nil)
+	Assert(t, server.URL == strings.TrimPrefix(metad.getConfig().NotifyWebhooks[0], "/nodes="))
+}
+
+func TestMetadShutdown(t *testing.T) {
+	metad := NewTestMetadWithConfig(&Config{DrainTimeout: 5})
+	server := httptest.NewServer(metad.router)
+	defer server.Close()
+	metad.addServer(server.Config)
+
+	req := httptest.NewRequest("PUT", "/v1/data/nodes/1", strings.NewReader(`{"ip":"192.168.1.1"}`))
+	w := httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+
+	time.Sleep(sleepTime)
+
+	status := make(chan int, 1)
+	go func() {
+		resp, err := http.Get(server.URL + "/nodes?wait=true")
+		if err != nil {
+			status <- 0
+			return
+		}
+		resp.Body.Close()
+		status <- resp.StatusCode
+	}()
+	time.Sleep(sleepTime)
+
+	metad.Stop()
+	select {
+	case code := <-status:
+		Assert(t, http.StatusServiceUnavailable == code, code)
+	case <-time.After(5 * time.Second):
+		t.Fatal("long-poll not interrupted by shutdown")
+	}
+
+	req = httptest.NewRequest("GET", "/readyz", nil)
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, http.StatusServiceUnavailable == w.Code)
+	Assert(t, "metad is shutting down" == util.GetMapValue(parse(w), "/reason"))
+
+	// new request is refused after shutdown.
+	_, err := http.Get(server.URL + "/nodes")
+	Assert(t, err != nil)
+
+	// stop again is no-op.
+	metad.Stop()
+}
+
+func TestMetadSubscription(t *testing.T) {
+	received := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		b, _ := ioutil.ReadAll(req.Body)
+		received <- req.Header.Get("Content-Type") + "|" + string(b)
+	}))
+	defer server.Close()
+
+	metad := NewTestMetadWithConfig(&Config{NotifyWebhooks: []string{"/clusters=" + server.URL}})
+	defer metad.Stop()
+
+	for _, body := range []string{
+		`{"url":"` + server.URL + `","actions":["PUT"]}`,
+		`{"url":"` + server.URL + `","format":"xml"}`,
+		`{"url":"127.0.0.1"}`,
+	} {
+		req := httptest.NewRequest("PUT", "/v1/subscription/cmdb", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		metad.manageRouter.ServeHTTP(w, req)
+		Assert(t, 400 == w.Code, body)
+	}
+	req := httptest.NewRequest("PUT", "/v1/subscription/config-1", strings.NewReader(`{"url":"`+server.URL+`"}`))
+	w := httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 400 == w.Code)
+
+	req = httptest.NewRequest("PUT", "/v1/subscription/cmdb", strings.NewReader(`{"url":"`+server.URL+`","prefix":"/nodes","actions":["DELETE"],"format":"text","secret":"s1"}`))
+	req.Header.Set("Accept", "application/json")
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code, w.Body.String())
+	Assert(t, "******" == util.GetMapValue(parse(w), "/secret"))
+
+	req = httptest.NewRequest("PUT", "/v1/data/nodes/1", strings.NewReader(`{"ip":"192.168.1.1"}`))
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+
+	time.Sleep(subscriptionSyncInterval + sleepTime*5)
+
+	// update is filtered.
+	req = httptest.NewRequest("PUT", "/v1/data/nodes/1", strings.NewReader(`{"ip":"192.168.1.2"}`))
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+	req = httptest.NewRequest("DELETE", "/v1/data/nodes/1", nil)
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+
+	select {
+	case body := <-received:
+		Assert(t, "text/plain|DELETE\t/nodes/1/ip\t192.168.1.2\n" == body, body)
+	case <-time.After(5 * time.Second):
+		t.Fatal("subscription not notified")
+	}
+	time.Sleep(sleepTime)
+
+	req = httptest.NewRequest("GET", "/v1/subscription", nil)
+	req.Header.Set("Accept", "application/json")
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+	var views []map[string]interface{}
+	err := json.Unmarshal(w.Body.Bytes(), &views)
+	Assert(t, err == nil, err)
+	Assert(t, 2 == len(views), w.Body.String())
+	Assert(t, "config-0" == views[0]["name"] && "config" == views[0]["source"])
+	Assert(t, "/clusters" == views[0]["prefix"])
+	Assert(t, "cmdb" == views[1]["name"] && "api" == views[1]["source"])
+	Assert(t, "1" == util.GetMapValue(views[1], "/metrics/delivered"))
+
+	req = httptest.NewRequest("POST", "/v1/subscription/cmdb/test", nil)
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code, w.Body.String())
+	Assert(t, "text/plain|" == <-received)
+
+	req = httptest.NewRequest("POST", "/v1/subscription/config-0/test", nil)
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code, w.Body.String())
+	Assert(t, strings.Contains(<-received, `"test":true`))
+
+	req = httptest.NewRequest("DELETE", "/v1/subscription/config-0", nil)
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 400 == w.Code)
+
+	req = httptest.NewRequest("DELETE", "/v1/subscription/cmdb", nil)
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+
+	time.Sleep(subscriptionSyncInterval + sleepTime*5)
+
+	req = httptest.NewRequest("GET", "/v1/subscription/cmdb", nil)
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 404 == w.Code)
+
+	req = httptest.NewRequest("DELETE", "/v1/data/nodes", nil)
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+	select {
+	case body := <-received:
+		t.Fatal("deleted subscription notified", body)
+	case <-time.After(sleepTime * 5):
+	}
+}
+
+func TestMetadRateLimit(t *testing.T) {
+	metad := NewTestMetadWithConfig(&Config{ClientRateLimit: 1, ClientRateLimitBurst: 2})
+	defer metad.Stop()
+
+	req := httptest.NewRequest("PUT", "/v1/data/nodes/1", strings.NewReader(`{"ip":"192.168.1.1"}`))
+	w := httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+
+	req = httptest.NewRequest("PUT", "/v1/mapping/", strings.NewReader(`{"192.0.2.1":{"node":"/nodes/1"},"192.0.2.2":{"node":"/nodes/1"}}`))
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+
+	time.Sleep(sleepTime)
+
+	get := func(ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/self/node/ip", nil)
+		req.RemoteAddr = ip + ":1234"
+		w := httptest.NewRecorder()
+		metad.router.ServeHTTP(w, req)
+		return w
+	}
+	for i := 0; i < 2; i++ {
+		Assert(t, 200 == get("192.0.2.1").Code)
+	}
+	w = get("192.0.2.1")
+	Assert(t, http.StatusTooManyRequests == w.Code)
+	Assert(t, "1" == w.Header().Get("Retry-After"))
+
+	// other client is not limited.
+	Assert(t, 200 == get("192.0.2.2").Code)
+
+	config := *metad.getConfig()
+	config.ClientRateLimit = 0
+	_, err := metad.applyConfig(&config)
+	Assert(t, err == nil, err)
+	for i := 0; i < 5; i++ {
+		Assert(t, 200 == get("192.0.2.1").Code)
+	}
+}
+
+func TestMetadResponseCache(t *testing.T) {
+	metad := NewTestMetadWithConfig(&Config{ResponseCacheSize: 10})
+	defer metad.Stop()
+
+	req := httptest.NewRequest("PUT", "/v1/data/nodes", strings.NewReader(`{"1":{"ip":"192.168.1.1"},"2":{"ip":"192.168.1.2"}}`))
+	w := httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+
+	req = httptest.NewRequest("PUT", "/v1/mapping/192.0.2.1", strings.NewReader(`{"node":"/nodes/1"}`))
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+
+	time.Sleep(sleepTime)
+
+	get := func(etag string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/self/node", nil)
+		req.Header.Set("accept", "application/json")
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		w := httptest.NewRecorder()
+		metad.router.ServeHTTP(w, req)
+		return w
+	}
+	w = get("")
+	Assert(t, 200 == w.Code)
+	etag := w.Header().Get("ETag")
+	Assert(t, etag != "")
+	body := w.Body.String()
+
+	w = get(etag)
+	Assert(t, http.StatusNotModified == w.Code)
+	Assert(t, 0 == w.Body.Len())
+	Assert(t, etag == w.Header().Get("ETag"))
+
+	// served from cache.
+	w = get("")
+	Assert(t, 200 == w.Code)
+	Assert(t, body == w.Body.String())
+	Assert(t, "application/json" == w.Header().Get("Content-Type"))
+	Assert(t, 1 == metad.cache.lru.Len())
+
+	// mapping change.
+	req = httptest.NewRequest("PUT", "/v1/mapping/192.0.2.1", strings.NewReader(`{"node":"/nodes/2"}`))
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+
+	time.Sleep(sleepTime)
+
+	w = get(etag)
+	Assert(t, 200 == w.Code)
+	Assert(t, etag != w.Header().Get("ETag"))
+	m := parse(w)
+	Assert(t, "192.168.1.2" == util.GetMapValue(m, "/ip"))
+	etag = w.Header().Get("ETag")
+
+	// data change.
+	req = httptest.NewRequest("PUT", "/v1/data/nodes/2/ip", strings.NewReader(`"192.168.1.3"`))
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+
+	time.Sleep(sleepTime)
+
+	w = get(etag)
+	Assert(t, 200 == w.Code)
+	m = parse(w)
+	Assert(t, "192.168.1.3" == util.GetMapValue(m, "/ip"))
+	Assert(t, 1 == metad.cache.lru.Len())
+
+	// long-poll is not cached.
+	req = httptest.NewRequest("GET", "/self/node?wait=true&prev_version=1", nil)
+	w = httptest.NewRecorder()
+	metad.router.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+	Assert(t, "" == w.Header().Get("ETag"))
+}
+
+func TestMetadCompress(t *testing.T) {
+	metad := NewTestMetadWithConfig(&Config{CompressMinSize: 100})
+	defer metad.Stop()
+
+	nodes := map[string]interface{}{}
+	for i := 0; i < 20; i++ {
+		nodes[strconv.Itoa(i)] = map[string]interface{}{"ip": fmt.Sprintf("192.168.1.%d", i)}
+	}
+	b, _ := json.Marshal(nodes)
+	req := httptest.NewRequest("PUT", "/v1/data/nodes", strings.NewReader(string(b)))
+	w := httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+
+	req = httptest.NewRequest("PUT", "/v1/mapping/192.0.2.1", strings.NewReader(`{"nodes":"/nodes"}`))
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+
+	time.Sleep(sleepTime)
+
+	get := func(uri string, acceptEncoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", uri, nil)
+		req.Header.Set("accept", "application/json")
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		w := httptest.NewRecorder()
+		metad.router.ServeHTTP(w, req)
+		return w
+	}
+	w = get("/self/nodes", "br, gzip")
+	Assert(t, 200 == w.Code)
+	Assert(t, "gzip" == w.Header().Get("Content-Encoding"))
+	Assert(t, "Accept-Encoding" == w.Header().Get("Vary"))
+	gr, err := gzip.NewReader(w.Body)
+	Assert(t, err == nil, err)
+	b, err = ioutil.ReadAll(gr)
+	Assert(t, err == nil, err)
+	m := map[string]interface{}{}
+	Assert(t, nil == json.Unmarshal(b, &m), string(b))
+	Assert(t, "192.168.1.1" == util.GetMapValue(m, "/1/ip"))
+
+	w = get("/self/nodes", "gzip;q=0, deflate")
+	Assert(t, 200 == w.Code)
+	Assert(t, "deflate" == w.Header().Get("Content-Encoding"))
+	b, err = ioutil.ReadAll(flate.NewReader(w.Body))
+	Assert(t, err == nil, err)
+	Assert(t, nil == json.Unmarshal(b, &m), string(b))
+
+	// zstd is preferred with the same q-value, the higher q-value wins.
+	w = get("/self/nodes", "gzip, zstd")
+	Assert(t, 200 == w.Code)
+	Assert(t, "zstd" == w.Header().Get("Content-Encoding"))
+	zr, err := zstd.NewReader(w.Body)
+	Assert(t, err == nil, err)
+	b, err = ioutil.ReadAll(zr)
+	zr.Close()
+	Assert(t, err == nil, err)
+	Assert(t, nil == json.Unmarshal(b, &m), string(b))
+	Assert(t, "192.168.1.1" == util.GetMapValue(m, "/1/ip"))
+	w = get("/self/nodes", "zstd;q=0.5, gzip;q=0.8")
+	Assert(t, "gzip" == w.Header().Get("Content-Encoding"))
+	w = get("/self/nodes", "*;q=0.1, gzip;q=0")
+	Assert(t, "zstd" == w.Header().Get("Content-Encoding"))
+
+	// small response and unsupported encoding are not compressed.
+	w = get("/self/nodes/1/ip", "gzip")
+	Assert(t, 200 == w.Code)
+	Assert(t, "" == w.Header().Get("Content-Encoding"))
+	Assert(t, `"192.168.1.1"` == w.Body.String())
+	w = get("/self/nodes", "br")
+	Assert(t, 200 == w.Code)
+	Assert(t, "" == w.Header().Get("Content-Encoding"))
+
+	// error response
+	w = get("/self/notexist", "*")
+	Assert(t, 404 == w.Code)
+}
+
+func TestMetadBulkDeleteJob(t *testing.T) {
+	metad := NewTestMetad()
+	defer metad.Stop()
+
+	nodes := map[string]interface{}{}
+	for i := 0; i < 50; i++ {
+		nodes[strconv.Itoa(i)] = map[string]interface{}{"ip": fmt.Sprintf("192.168.1.%d", i), "name": fmt.Sprintf("node%d", i)}
+	}
+	b, _ := json.Marshal(map[string]interface{}{"nodes": nodes, "clusters": nodes})
+	req := httptest.NewRequest("PUT", "/v1/data/", strings.NewReader(string(b)))
+	w := httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+
+	time.Sleep(sleepTime)
+
+	submit := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/v1/job", strings.NewReader(body))
+		req.Header.Set("accept", "application/json")
+		w := httptest.NewRecorder()
+		metad.manageRouter.ServeHTTP(w, req)
+		return w
+	}
+	getJob := func(id string) map[string]interface{} {
+		req := httptest.NewRequest("GET", "/v1/job/"+id, nil)
+		req.Header.Set("accept", "application/json")
+		w := httptest.NewRecorder()
+		metad.manageRouter.ServeHTTP(w, req)
+		Assert(t, 200 == w.Code)
+		return parse(w).(map[string]interface{})
+	}
+	waitJob := func(id string) map[string]interface{} {
+		for i := 0; i < 100; i++ {
+			job := getJob(id)
+			if job["status"] != JobStatusRunning {
+				return job
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		t.Fatal("job not finished")
+		return nil
+	}
+
+	w = submit(`{"type":"delete","path":"/nodes","batch_size":20,"interval":10}`)
+	Assert(t, 200 == w.Code)
+	job := parse(w).(map[string]interface{})
+	Assert(t, JobStatusRunning == job["status"])
+	id := job["id"].(string)
+	job = waitJob(id)
+	Assert(t, JobStatusSucceeded == job["status"], job)
+	Assert(t, float64(100) == job["done"] && float64(100) == job["total"], job)
+
+	time.Sleep(sleepTime)
+	Assert(t, nil == metad.metadataRepo.GetData("/nodes"))
+
+	// cancel
+	w = submit(`{"type":"delete","path":"/clusters","batch_size":1,"interval":50}`)
+	Assert(t, 200 == w.Code)
+	id = parse(w).(map[string]interface{})["id"].(string)
+	time.Sleep(sleepTime)
+	req = httptest.NewRequest("DELETE", "/v1/job/"+id, nil)
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+	job = waitJob(id)
+	Assert(t, JobStatusCanceled == job["status"], job)
+	Assert(t, job["done"].(float64) < 100, job)
+
+	time.Sleep(sleepTime)
+	Assert(t, nil != metad.metadataRepo.GetData("/clusters"))
+
+	req = httptest.NewRequest("GET", "/v1/job", nil)
+	req.Header.Set("accept", "application/json")
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+	Assert(t, 2 == len(parse(w).([]interface{})))
+
+	w = submit(`{"type":"unknown","path":"/clusters"}`)
+	Assert(t, 400 == w.Code)
+	w = submit(`{"type":"delete"}`)
+	Assert(t, 400 == w.Code)
+	Assert(t, 404 == func() int {
+		req := httptest.NewRequest("GET", "/v1/job/job-100", nil)
+		w := httptest.NewRecorder()
+		metad.manageRouter.ServeHTTP(w, req)
+		return w.Code
+	}())
+}
+
+func TestMetadJob(t *testing.T) {
+	metad := NewTestMetad()
+	defer metad.Stop()
+
+	manage := func(method string, uri string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, uri, strings.NewReader(body))
+		req.Header.Set("accept", "application/json")
+		w := httptest.NewRecorder()
+		metad.manageRouter.ServeHTTP(w, req)
+		return w
+	}
+	run := func(body string) string {
+		w := manage("POST", "/v1/job", body)
+		Assert(t, 200 == w.Code, w.Body.String())
+		id := util.GetMapValue(parse(w), "/id")
+		for i := 0; i < 100; i++ {
+			w = manage("GET", "/v1/job/"+id, "")
+			Assert(t, 200 == w.Code)
+			if util.GetMapValue(parse(w), "/status") != JobStatusRunning {
+				break
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		Assert(t, JobStatusSucceeded == util.GetMapValue(parse(w), "/status"), w.Body.String())
+		return id
+	}
+
+	id := run(`{"type":"import","path":"/clusters/cl-1","data":{"nodes":{"1":{"ip":"192.168.1.1"},"2":{"ip":"192.168.1.2"}},"name":"cl-1"},"batch_size":2,"interval":10}`)
+	w := manage("GET", "/v1/job/"+id, "")
+	Assert(t, "3" == util.GetMapValue(parse(w), "/total"))
+
+	w = manage("GET", "/v1/job/"+id+"/log", "")
+	Assert(t, 200 == w.Code)
+	logs := parse(w).([]interface{})
+	Assert(t, strings.Contains(logs[len(logs)-2].(string), "imported 3/3 keys."), logs)
+	Assert(t, strings.Contains(logs[len(logs)-1].(string), "job succeeded."), logs)
+
+	time.Sleep(sleepTime)
+	Assert(t, "192.168.1.2" == util.GetMapValue(metad.metadataRepo.GetData("/clusters/cl-1"), "/nodes/2/ip"))
+
+	req := httptest.NewRequest("PUT", "/v1/mapping/192.0.2.1", strings.NewReader(`{"node":"/clusters/cl-1/nodes/1"}`))
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+
+	time.Sleep(sleepTime)
+
+	id = run(`{"type":"move","from":"/clusters/cl-1","to":"/clusters/cl-2"}`)
+	w = manage("GET", "/v1/job/"+id+"/result", "")
+	Assert(t, 200 == w.Code)
+	Assert(t, "/clusters/cl-2" == util.GetMapValue(parse(w), "/to"))
+
+	time.Sleep(sleepTime)
+	Assert(t, "/clusters/cl-2/nodes/1" == util.GetMapValue(metad.metadataRepo.GetMapping("/192.0.2.1"), "/node"))
+
+	id = run(`{"type":"copy","from":"/clusters/cl-2","to":"/clusters/cl-3","exclude":["/name"]}`)
+	w = manage("GET", "/v1/job/"+id+"/result", "")
+	Assert(t, "2" == util.GetMapValue(parse(w), "/keys"))
+
+	time.Sleep(sleepTime)
+
+	id = run(`{"type":"export","path":"/clusters/cl-3"}`)
+	w = manage("GET", "/v1/job/"+id+"/result", "")
+	Assert(t, 200 == w.Code)
+	Assert(t, "192.168.1.1" == util.GetMapValue(parse(w), "/nodes/1/ip"))
+	Assert(t, "" == util.GetMapValue(parse(w), "/name"))
+
+	w = manage("POST", "/v1/job", `{"type":"export","path":"/notexist"}`)
+	Assert(t, 200 == w.Code)
+	id = util.GetMapValue(parse(w), "/id")
+	time.Sleep(sleepTime)
+	w = manage("GET", "/v1/job/"+id, "")
+	Assert(t, JobStatusFailed == util.GetMapValue(parse(w), "/status"))
+	w = manage("GET", "/v1/job/"+id+"/result", "")
+	Assert(t, 404 == w.Code)
+
+	w = manage("POST", "/v1/job", `{"type":"import","path":"/clusters"}`)
+	Assert(t, 400 == w.Code)
+	w = manage("POST", "/v1/job", `{"type":"move","from":"/clusters"}`)
+	Assert(t, 400 == w.Code)
+}
+
+func TestMetadQuota(t *testing.T) {
+	metad := NewTestMetadWithConfig(&Config{Quotas: []string{"/nodes=3,100"}})
+	defer metad.Stop()
+
+	put := func(method string, uri string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, uri, strings.NewReader(body))
+		req.Header.Set("accept", "application/json")
+		w := httptest.NewRecorder()
+		metad.manageRouter.ServeHTTP(w, req)
+		return w
+	}
+	w := put("PUT", "/v1/data/nodes/1", `{"ip":"192.168.1.1","name":"node1"}`)
+	Assert(t, 200 == w.Code)
+	time.Sleep(sleepTime)
+
+	w = put("PUT", "/v1/data/nodes/2", `{"ip":"192.168.1.2","name":"node2"}`)
+	Assert(t, 413 == w.Code, w.Code)
+	// replace the old value is not counted.
+	w = put("POST", "/v1/data/nodes", `{"2":{"ip":"192.168.1.2","name":"node2"}}`)
+	Assert(t, 200 == w.Code, w.Code)
+	// other prefixes are not limited.
+	w = put("PUT", "/v1/data/clusters", `{"c1":"cluster1","c2":"cluster2"}`)
+	Assert(t, 200 == w.Code)
+	time.Sleep(sleepTime)
+
+	req := httptest.NewRequest("GET", "/v1/stats", nil)
+	req.Header.Set("accept", "application/json")
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+	usages := map[string]map[string]interface{}{}
+	for _, u := range parse(w).(map[string]interface{})["usage"].([]interface{}) {
+		usage := u.(map[string]interface{})
+		usages[usage["prefix"].(string)] = usage
+	}
+	Assert(t, float64(2) == usages["/nodes"]["keys"], usages["/nodes"])
+	Assert(t, float64(3) == usages["/nodes"]["max_keys"], usages["/nodes"])
+	Assert(t, float64(100) == usages["/nodes"]["max_bytes"], usages["/nodes"])
+	Assert(t, float64(2) == usages["/clusters"]["keys"], usages["/clusters"])
+
+	// copy, move and import are also checked before write to backend.
+	w = put("POST", "/v1/data:copy", `{"from":"/nodes/2","to":"/nodes/9"}`)
+	Assert(t, 413 == w.Code, w.Code)
+	w = put("POST", "/v1/data:move", `{"from":"/clusters","to":"/nodes/clusters"}`)
+	Assert(t, 413 == w.Code, w.Code)
+	w = put("POST", "/v1/job", `{"type":"import","path":"/nodes/5","data":{"ip":"192.168.1.5","name":"node5"}}`)
+	Assert(t, 200 == w.Code, w.Body.String())
+	id := util.GetMapValue(parse(w), "/id")
+	for i := 0; i < 100; i++ {
+		w = put("GET", "/v1/job/"+id, "")
+		if util.GetMapValue(parse(w), "/status") != JobStatusRunning {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	Assert(t, JobStatusFailed == util.GetMapValue(parse(w), "/status"), w.Body.String())
+	time.Sleep(sleepTime)
+	Assert(t, nil == metad.metadataRepo.GetData("/nodes/9"))
+	Assert(t, nil == metad.metadataRepo.GetData("/nodes/5"))
+	Assert(t, nil == metad.metadataRepo.GetData("/nodes/clusters"))
+	Assert(t, "cluster1" == metad.metadataRepo.GetData("/clusters/c1"))
+
+	// flag mode apply the writes.
+	config := *metad.getConfig()
+	config.QuotaMode = QuotaModeFlag
+	_, err := metad.applyConfig(&config)
+	Assert(t, err == nil, err)
+	w = put("PUT", "/v1/data/nodes/3", `{"ip":"192.168.1.3","name":"node3"}`)
+	Assert(t, 200 == w.Code)
+	time.Sleep(sleepTime)
+	Assert(t, "node3" == metad.metadataRepo.GetData("/nodes/3/name"))
+	for _, usage := range metad.metadataRepo.DataUsage() {
+		if usage.Prefix == "/nodes" {
+			Assert(t, usage.Exceeded && usage.Flagged == 1, usage)
+		}
+	}
+
+	// the value size and depth are limited.
+	config.Quotas = []string{"/nodes=0,0,16,3"}
+	_, err = metad.applyConfig(&config)
+	Assert(t, err == nil, err)
+	config.QuotaMode = QuotaModeReject
+	_, err = metad.applyConfig(&config)
+	Assert(t, err == nil, err)
+	w = put("PUT", "/v1/data/nodes/4", `{"ip":"192.168.1.4"}`)
+	Assert(t, 200 == w.Code, w.Body.String())
+	w = put("PUT", "/v1/data/nodes/4", `{"cert":"-----BEGIN CERTIFICATE-----"}`)
+	Assert(t, 413 == w.Code, w.Code)
+	Assert(t, strings.Contains(w.Body.String(), "max value bytes 16"), w.Body.String())
+	w = put("PUT", "/v1/data/nodes/4", `{"labels":{"zone":"z1"}}`)
+	Assert(t, 413 == w.Code, w.Code)
+	Assert(t, strings.Contains(w.Body.String(), "max depth 3"), w.Body.String())
+
+	config.Quotas = []string{"/nodes/1=1"}
+	_, err = metad.applyConfig(&config)
+	Assert(t, err != nil)
+	config.Quotas = []string{"/nodes=1,2,3,4,5"}
+	_, err = metad.applyConfig(&config)
+	Assert(t, err != nil)
+}
+
+func TestMetadFixture(t *testing.T) {
+	metad := NewTestMetad()
+	defer metad.Stop()
+
+	fixture, err := LoadFixture("../../testdata/fixture")
+	Assert(t, err == nil, err)
+	Assert(t, 4 == len(fixture.Script.Steps))
+	Assert(t, time.Second == fixture.Script.Steps[0].After)
+	Assert(t, nil == metad.LoadFixture(fixture))
+	time.Sleep(sleepTime)
+	Assert(t, "2" == metad.metadataRepo.GetData("/cluster/size"))
+	Assert(t, "/nodes/1" == metad.metadataRepo.GetMapping("/192.168.1.1/node"))
+
+	script := &FixtureScript{Steps: fixture.Script.Steps}
+	for _, step := range script.Steps {
+		step.After = 10 * time.Millisecond
+	}
+	metad.RunFixtureScript(script)
+	time.Sleep(sleepTime)
+	Assert(t, "node3" == metad.metadataRepo.GetData("/nodes/3/name"))
+	Assert(t, "3" == metad.metadataRepo.GetData("/cluster/size"))
+	Assert(t, nil == metad.metadataRepo.GetData("/nodes/1"))
+	Assert(t, "/nodes/3" == metad.metadataRepo.GetMapping("/127.0.0.1/node"))
+
+	dir, err := ioutil.TempDir("", "fixture")
+	Assert(t, err == nil)
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(path.Join(dir, "script.yml"), []byte("steps:\n  - action: move\n    path: /nodes\n"), 0644)
+	_, err = LoadFixture(dir)
+	Assert(t, err != nil)
+}
+
+func TestMetadRecord(t *testing.T) {
+	metad := NewTestMetad()
+	defer metad.Stop()
+
+	req := httptest.NewRequest("PUT", "/v1/data/", strings.NewReader(`{"nodes":{"1":{"ip":"192.168.1.1"}}}`))
+	w := httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+
+	req = httptest.NewRequest("PUT", "/v1/mapping/", strings.NewReader(`{"192.0.2.1":{"node":"/nodes/1"}}`))
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+
+	time.Sleep(sleepTime)
+
+	req = httptest.NewRequest("GET", "/v1/record", nil)
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 404 == w.Code)
+
+	req = httptest.NewRequest("POST", "/v1/record", strings.NewReader(`{"duration":7200}`))
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 400 == w.Code)
+
+	req = httptest.NewRequest("POST", "/v1/record", strings.NewReader(`{"duration":60}`))
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+
+	get := func(handler http.Handler, uri string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", uri, nil)
+		req.Header.Set("accept", "application/json")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+	w = get(metad.router, "/self/node/ip")
+	Assert(t, 200 == w.Code)
+	err := metad.metadataRepo.PutData("/nodes/1/ip", "192.168.1.2", false)
+	Assert(t, nil == err)
+	time.Sleep(sleepTime)
+	w = get(metad.router, "/self/node/ip")
+	Assert(t, 200 == w.Code)
+	w = get(metad.router, "/self/not_exist")
+	Assert(t, 404 == w.Code)
+
+	w = get(metad.manageRouter, "/v1/record")
+	Assert(t, 200 == w.Code)
+	recording := &Recording{}
+	Assert(t, nil == json.Unmarshal(w.Body.Bytes(), recording))
+	Assert(t, 3 == len(recording.Responses), len(recording.Responses))
+	Assert(t, "192.0.2.1" == recording.Responses[0].Identity)
+	Assert(t, `"192.168.1.1"` == recording.Responses[0].Body, recording.Responses[0].Body)
+
+	// replay the responses of the same request in order, and repeat the last.
+	replayer := NewReplayer(recording, "")
+	w = get(replayer, "/self/node/ip")
+	Assert(t, 200 == w.Code)
+	Assert(t, `"192.168.1.1"` == w.Body.String())
+	Assert(t, "true" == w.Header().Get("X-Metad-Replay"))
+	Assert(t, recording.Responses[0].Version == w.Header().Get("X-Metad-Version"))
+	for i := 0; i < 2; i++ {
+		w = get(replayer, "/self/node/ip")
+		Assert(t, `"192.168.1.2"` == w.Body.String())
+	}
+	w = get(replayer, "/self/not_exist")
+	Assert(t, 404 == w.Code)
+	w = get(replayer, "/self/node")
+	Assert(t, 404 == w.Code)
+
+	// replay the responses of other identity.
+	req = httptest.NewRequest("GET", "/self/node/ip", nil)
+	req.Header.Set("accept", "application/json")
+	req.RemoteAddr = "192.0.2.2:1234"
+	w = httptest.NewRecorder()
+	replayer.ServeHTTP(w, req)
+	Assert(t, 404 == w.Code)
+	w = httptest.NewRecorder()
+	NewReplayer(recording, "192.0.2.1").ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+
+	req = httptest.NewRequest("DELETE", "/v1/record", nil)
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+	get(metad.router, "/self/node/ip")
+	w = get(metad.manageRouter, "/v1/record")
+	Assert(t, 404 == w.Code)
+}
+
+func TestMetadSchema(t *testing.T) {
+	metad := NewTestMetad()
+	defer metad.Stop()
+
+	get := func(uri string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", uri, nil)
+		req.Header.Set("accept", "application/json")
+		w := httptest.NewRecorder()
+		metad.manageRouter.ServeHTTP(w, req)
+		return w
+	}
+	w := get("/v1/schema")
+	Assert(t, 200 == w.Code)
+	result := parse(w)
+	Assert(t, "1" == util.GetMapValue(result, "/event/current"))
+	Assert(t, "1" == util.GetMapValue(result, "/event/versions/0"))
+
+	w = get("/v1/schema/event/1")
+	Assert(t, 200 == w.Code)
+	Assert(t, "metad/event/1" == util.GetMapValue(parse(w), "/$id"))
+
+	w = get("/v1/schema/event/9")
+	Assert(t, 404 == w.Code)
+	w = get("/v1/schema/event/v1")
+	Assert(t, 400 == w.Code)
+}
+
+func TestMetadMappingRule(t *testing.T) {
+	metad := NewTestMetad()
+	defer metad.Stop()
+
+	req := httptest.NewRequest("PUT", "/v1/data/", strings.NewReader(`{"hosts":{"192.0.2.1":{"name":"h1"}}}`))
+	w := httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+
+	req = httptest.NewRequest("PUT", "/v1/mapping_rule/r1", strings.NewReader(`{"match":"192.0.2.0/24","mapping":{"node":"/hosts/{ip}"}}`))
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+
+	req = httptest.NewRequest("PUT", "/v1/mapping_rule/r2", strings.NewReader(`{"match":"192.0.2.0/24"}`))
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 400 == w.Code)
+
+	time.Sleep(sleepTime)
+
+	req = httptest.NewRequest("GET", "/v1/mapping_rule", nil)
+	req.Header.Set("accept", "application/json")
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+	Assert(t, "192.0.2.0/24" == util.GetMapValue(parse(w), "/0/match"))
+
+	req = httptest.NewRequest("GET", "/self/node/name", nil)
+	req.Header.Set("accept", "application/json")
+	w = httptest.NewRecorder()
+	metad.router.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+	Assert(t, "h1" == parse(w))
+
+	req = httptest.NewRequest("DELETE", "/v1/mapping_rule/r1", nil)
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+
+	time.Sleep(sleepTime)
+
+	req = httptest.NewRequest("GET", "/v1/mapping_rule/r1", nil)
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 404 == w.Code)
+
+	req = httptest.NewRequest("GET", "/self/node/name", nil)
+	req.Header.Set("accept", "application/json")
+	w = httptest.NewRecorder()
+	metad.router.ServeHTTP(w, req)
+	Assert(t, 404 == w.Code)
+}
+
+func TestMetadTieredRead(t *testing.T) {
+	peer := NewTestMetad()
+	defer peer.Stop()
+	peerServer := httptest.NewServer(peer.manageRouter)
+	defer peerServer.Close()
+
+	metad := NewTestMetadWithConfig(&Config{ReadBudget: 500, ReadPeers: []string{"http://127.0.0.1:1", peerServer.URL}})
+	defer metad.Stop()
+
+	req := httptest.NewRequest("PUT", "/v1/data/", strings.NewReader(`{"hosts":{"1":{"name":"h1"}}}`))
+	w := httptest.NewRecorder()
+	peer.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+
+	req = httptest.NewRequest("PUT", "/v1/mapping/192.0.2.1", strings.NewReader(`{"node":"/hosts/1"}`))
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+
+	time.Sleep(sleepTime)
+
+	get := func(uri string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", uri, nil)
+		req.Header.Set("accept", "application/json")
+		w := httptest.NewRecorder()
+		metad.router.ServeHTTP(w, req)
+		return w
+	}
+
+	// local miss, the unreachable peer is skipped.
+	w = get("/self/node/name")
+	Assert(t, 200 == w.Code, w.Code)
+	Assert(t, "h1" == parse(w))
+	Assert(t, ReadSourcePeer == w.Header().Get("X-Metad-Read-Source"))
+	Assert(t, "" == w.Header().Get("ETag"))
+
+	w = get("/hosts/1")
+	Assert(t, 200 == w.Code, w.Code)
+	Assert(t, "h1" == util.GetMapValue(parse(w), "/name"))
+	Assert(t, ReadSourcePeer == w.Header().Get("X-Metad-Read-Source"))
+
+	// missed by all tiers.
+	w = get("/hosts/2")
+	Assert(t, 404 == w.Code, w.Code)
+
+	req = httptest.NewRequest("PUT", "/v1/data/", strings.NewReader(`{"hosts":{"1":{"name":"h2"}}}`))
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+
+	time.Sleep(sleepTime)
+
+	w = get("/self/node/name")
+	Assert(t, 200 == w.Code, w.Code)
+	Assert(t, "h2" == parse(w))
+	Assert(t, ReadSourceLocal == w.Header().Get("X-Metad-Read-Source"))
+	Assert(t, "" != w.Header().Get("ETag"))
+}
+
+func TestMetadMappingVars(t *testing.T) {
+	metad := NewTestMetadWithConfig(&Config{AdminToken: "bootstrap"})
+	defer metad.Stop()
+
+	req := httptest.NewRequest("PUT", "/v1/data/", strings.NewReader(`{"instances":{"i-1":{"name":"n1"},"i-2":{"name":"n2"}},"ips":{"10.0.0.1":"ip1"}}`))
+	w := httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+
+	req = httptest.NewRequest("PUT", "/v1/mapping_rule/gw", strings.NewReader(`{"match":"gw*","mapping":{"node":"/instances/{token.sub}","ip":"/ips/{ip}","host":"/hosts/{hostname}"}}`))
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+
+	tokens := []string{}
+	for _, sub := range []string{"i-1", "i-2"} {
+		req = httptest.NewRequest("POST", "/v1/token", strings.NewReader(`{"host":"gw1","sub":"`+sub+`"}`))
+		req.Header.Set("accept", "application/json")
+		req.Header.Set("Authorization", "Bearer bootstrap")
+		w = httptest.NewRecorder()
+		metad.manageRouter.ServeHTTP(w, req)
+		Assert(t, 200 == w.Code)
+		Assert(t, sub == util.GetMapValue(parse(w), "/sub"))
+		tokens = append(tokens, util.GetMapValue(parse(w), "/token"))
+	}
+
+	time.Sleep(sleepTime)
+
+	get := func(uri string, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", uri, nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		req.Header.Set("accept", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		metad.router.ServeHTTP(w, req)
+		return w
+	}
+	// the tokens of the same host get their own subtree, not mixed by the response cache.
+	for i := 0; i < 2; i++ {
+		w = get("/self/node/name", tokens[0])
+		Assert(t, 200 == w.Code, w.Code)
+		Assert(t, "n1" == parse(w))
+		w = get("/self/node/name", tokens[1])
+		Assert(t, 200 == w.Code, w.Code)
+		Assert(t, "n2" == parse(w))
+	}
+	w = get("/self/ip", tokens[0])
+	Assert(t, 200 == w.Code, w.Code)
+	Assert(t, "ip1" == parse(w))
+	w = get("/instances/i-2/name", tokens[0])
+	Assert(t, 404 == w.Code, w.Code)
+	// the link of missing data.
+	w = get("/self/host", tokens[0])
+	Assert(t, 404 == w.Code, w.Code)
+}
+
+func TestMetadMappingBulk(t *testing.T) {
+	metad := NewTestMetad()
+	defer metad.Stop()
+
+	do := func(method string, uri string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, uri, strings.NewReader(body))
+		req.Header.Set("accept", "application/json")
+		w := httptest.NewRecorder()
+		metad.manageRouter.ServeHTTP(w, req)
+		return w
+	}
+	w := do("POST", "/v1/mapping:import", `{"mappings":{"192.168.1.1":{"node":"/nodes/1"},"192.168.1.2":{"node":"/nodes/2"}}}`)
+	Assert(t, 200 == w.Code, w.Code)
+	Assert(t, "192.168.1.1" == util.GetMapValue(parse(w), "/added/0"))
+
+	time.Sleep(sleepTime)
+
+	w = do("GET", "/v1/mapping:list?host=192.168.1.*&limit=1", "")
+	Assert(t, 200 == w.Code, w.Code)
+	Assert(t, "2" == util.GetMapValue(parse(w), "/total"))
+	Assert(t, "/nodes/1" == util.GetMapValue(parse(w), "/mappings/0/mapping/node"))
+	w = do("GET", "/v1/mapping:list?limit=x", "")
+	Assert(t, 400 == w.Code, w.Code)
+
+	w = do("GET", "/v1/mapping:export", "")
+	Assert(t, 200 == w.Code, w.Code)
+	version := util.GetMapValue(parse(w), "/version")
+	Assert(t, "/nodes/2" == util.GetMapValue(parse(w), "/mappings/192.168.1.2/node"))
+
+	table := `{"version":` + version + `,"mappings":{"192.168.1.1":{"node":"/nodes/1"},"192.168.1.3":{"node":"/nodes/3"}}}`
+	w = do("POST", "/v1/mapping:replace?dry_run=true", table)
+	Assert(t, 200 == w.Code, w.Code)
+	Assert(t, "true" == util.GetMapValue(parse(w), "/dry_run"))
+	Assert(t, "192.168.1.2" == util.GetMapValue(parse(w), "/removed/0"))
+	Assert(t, "1" == util.GetMapValue(parse(w), "/unchanged"))
+
+	w = do("POST", "/v1/mapping:replace", `{"mappings":{"192.168.1.1":{"node":"nodes/1"},"192.168.1.3":{"node":"/nodes/3"}}}`)
+	Assert(t, 400 == w.Code, w.Code)
+	w = do("POST", "/v1/mapping:replace", `{}`)
+	Assert(t, 400 == w.Code, w.Code)
+
+	w = do("POST", "/v1/mapping:replace", table)
+	Assert(t, 200 == w.Code, w.Code)
+	time.Sleep(sleepTime)
+	getAndCheckMapping(metad, t, "192.168.1.2", false)
+	getAndCheckMapping(metad, t, "192.168.1.3", true)
+
+	w = do("POST", "/v1/mapping:replace", table)
+	Assert(t, 409 == w.Code, w.Code)
+}
+
+func TestMetadVerify(t *testing.T) {
+	metad := NewTestMetad()
+	defer metad.Stop()
+
+	do := func(method string, uri string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, uri, nil)
+		req.Header.Set("accept", "application/json")
+		w := httptest.NewRecorder()
+		metad.manageRouter.ServeHTTP(w, req)
+		return w
+	}
+	// local backend is not verified on start.
+	w := do("GET", "/v1/verify")
+	Assert(t, 404 == w.Code, w.Code)
+
+	metad.metadataRepo.PutData("/nodes/1", map[string]interface{}{"ip": "192.168.1.1"}, true)
+	time.Sleep(sleepTime)
+
+	w = do("POST", "/v1/verify?repair=true")
+	Assert(t, 200 == w.Code, w.Code)
+	Assert(t, "true" == util.GetMapValue(parse(w), "/consistent"))
+	Assert(t, "0" == util.GetMapValue(parse(w), "/data/repaired"))
+
+	w = do("GET", "/v1/verify")
+	Assert(t, 200 == w.Code, w.Code)
+	Assert(t, "true" == util.GetMapValue(parse(w), "/consistent"))
+}
+
+func TestMetadWatchSelfRemap(t *testing.T) {
+	metad := NewTestMetad()
+	defer metad.Stop()
+
+	err := metad.metadataRepo.PutData("/nodes", map[string]interface{}{
+		"1": map[string]interface{}{"ip": "192.168.1.1"},
+		"2": map[string]interface{}{"ip": "192.168.1.2"},
+	}, true)
+	Assert(t, nil == err)
+	err = metad.metadataRepo.PutMapping("/192.0.2.1", map[string]interface{}{"node": "/nodes/1"}, true)
+	Assert(t, nil == err)
+	time.Sleep(sleepTime)
+
+	results := make(chan interface{}, 1)
+	go func() {
+		req := httptest.NewRequest("GET", "/self/node?wait=true&with_events=true", nil)
+		req.Header.Set("accept", "application/json")
+		w := httptest.NewRecorder()
+		metad.router.ServeHTTP(w, req)
+		Assert(t, 200 == w.Code)
+		results <- parse(w)
+	}()
+	time.Sleep(sleepTime)
+
+	req := httptest.NewRequest("PUT", "/v1/mapping/192.0.2.1", strings.NewReader(`{"node":"/nodes/2"}`))
+	w := httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+	result := <-results
+	Assert(t, "192.168.1.2" == util.GetMapValue(result, "/value/ip"))
+	Assert(t, "UPDATE" == util.GetMapValue(result, "/events/0/action"))
+	Assert(t, "/node/ip" == util.GetMapValue(result, "/events/0/path"))
+	Assert(t, "192.168.1.2" == util.GetMapValue(result, "/events/0/value"))
+	Assert(t, "mapping" == util.GetMapValue(result, "/events/0/actor"))
+}
+
+func TestMetadSyncPause(t *testing.T) {
+	metad := NewTestMetad()
+	defer metad.Stop()
+
+	do := func(method string, uri string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, uri, strings.NewReader(body))
+		req.Header.Set("accept", "application/json")
+		w := httptest.NewRecorder()
+		metad.manageRouter.ServeHTTP(w, req)
+		return w
+	}
+	Assert(t, nil == metad.metadataRepo.PutData("/nodes/1/ip", "192.168.1.1", false))
+	time.Sleep(sleepTime)
+
+	w := do("POST", "/v1/sync:pause", `{"prefix":"/nodes"}`)
+	Assert(t, 200 == w.Code, w.Code)
+	w = do("POST", "/v1/sync:pause", `{"prefix":"/nodes"}`)
+	Assert(t, 409 == w.Code, w.Code)
+	w = do("POST", "/v1/sync:pause", `{}`)
+	Assert(t, 400 == w.Code, w.Code)
+
+	Assert(t, nil == metad.metadataRepo.PutData("/nodes/1/ip", "192.168.2.1", false))
+	time.Sleep(sleepTime)
+	w = do("GET", "/v1/data/nodes/1/ip", "")
+	Assert(t, "192.168.1.1" == fmt.Sprint(parse(w)))
+	w = do("GET", "/v1/sync", "")
+	Assert(t, 200 == w.Code, w.Code)
+	Assert(t, "/nodes" == util.GetMapValue(parse(w), "/paused/0/prefix"))
+	Assert(t, "1" == util.GetMapValue(parse(w), "/paused/0/dropped"))
+
+	w = do("POST", "/v1/sync:resume", `{"prefix":"/nodes"}`)
+	Assert(t, 200 == w.Code, w.Code)
+	Assert(t, "1" == util.GetMapValue(parse(w), "/updated"))
+	w = do("GET", "/v1/data/nodes/1/ip", "")
+	Assert(t, "192.168.2.1" == fmt.Sprint(parse(w)))
+	w = do("POST", "/v1/sync:resume", `{"prefix":"/nodes"}`)
+	Assert(t, 409 == w.Code, w.Code)
+
+	w = do("POST", "/v1/sync:resync", `{"prefix":"/nodes"}`)
+	Assert(t, 200 == w.Code, w.Code)
+	Assert(t, "0" == util.GetMapValue(parse(w), "/updated"))
+}
+
+func TestMetadHTTPSource(t *testing.T) {
+	var lock sync.Mutex
+	etag, body, status := `"v1"`, `{"region-1":{"ami":"ami-1"},"region-2":{"ami":"ami-2"}}`, 200
+	var notModified int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		if status != 200 {
+			w.WriteHeader(status)
+			return
+		}
+		if req.Header.Get("If-None-Match") == etag {
+			atomic.AddInt32(&notModified, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	_, err := configHTTPSources(&Config{HTTPSources: []string{"/=" + server.URL}})
+	Assert(t, nil != err)
+	_, err = configHTTPSources(&Config{HTTPSources: []string{"/catalog=ftp://example.com"}})
+	Assert(t, nil != err)
+	_, err = configHTTPSources(&Config{HTTPSources: []string{"/catalog=" + server.URL, "/catalog/ami=" + server.URL}})
+	Assert(t, nil != err)
+
+	metad := NewTestMetadWithConfig(&Config{HTTPSources: []string{"/catalog/ami=" + server.URL}, HTTPSourceInterval: 1})
+	defer metad.Stop()
+
+	do := func(method string, uri string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, uri, strings.NewReader(body))
+		req.Header.Set("accept", "application/json")
+		w := httptest.NewRecorder()
+		metad.manageRouter.ServeHTTP(w, req)
+		return w
+	}
+	time.Sleep(sleepTime)
+	w := do("GET", "/v1/data/catalog/ami/region-1/ami", "")
+	Assert(t, 200 == w.Code, w.Code)
+	Assert(t, "ami-1" == fmt.Sprint(parse(w)))
+
+	// the mounted prefix is served by the metadata api, and read only.
+	Assert(t, nil == metad.metadataRepo.PutMapping("/192.0.2.1", map[string]interface{}{"ami": "/catalog/ami/region-2/ami"}, true))
+	time.Sleep(sleepTime)
+	req := httptest.NewRequest("GET", "/self/ami", nil)
+	req.Header.Set("accept", "application/json")
+	w = httptest.NewRecorder()
+	metad.router.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code, w.Code)
+	Assert(t, "ami-2" == fmt.Sprint(parse(w)))
+	w = do("PUT", "/v1/data/catalog/ami/region-1/ami", `"ami-x"`)
+	Assert(t, 403 == w.Code, w.Code)
+	w = do("DELETE", "/v1/data/catalog/ami", "")
+	Assert(t, 403 == w.Code, w.Code)
+
+	lock.Lock()
+	etag, body = `"v2"`, `{"region-1":{"ami":"ami-3"}}`
+	lock.Unlock()
+	time.Sleep(1500 * time.Millisecond)
+	w = do("GET", "/v1/data/catalog/ami/region-1/ami", "")
+	Assert(t, "ami-3" == fmt.Sprint(parse(w)))
+	w = do("GET", "/v1/data/catalog/ami/region-2", "")
+	Assert(t, 404 == w.Code, w.Code)
+	time.Sleep(1200 * time.Millisecond)
+	Assert(t, atomic.LoadInt32(&notModified) > 0)
+
+	// the last value is kept if the source is unavailable.
+	lock.Lock()
+	status = 500
+	lock.Unlock()
+	time.Sleep(1200 * time.Millisecond)
+	w = do("GET", "/v1/data/catalog/ami/region-1/ami", "")
+	Assert(t, "ami-3" == fmt.Sprint(parse(w)))
+	w = do("GET", "/v1/source", "")
+	Assert(t, 200 == w.Code, w.Code)
+	Assert(t, "/catalog/ami" == util.GetMapValue(parse(w), "/0/prefix"))
+	Assert(t, `"v2"` == util.GetMapValue(parse(w), "/0/etag"))
+	Assert(t, "unexpected status 500" == util.GetMapValue(parse(w), "/0/error"))
+}
+
+func TestMetadDataPatch(t *testing.T) {
+	metad := NewTestMetad()
+	defer metad.Stop()
+
+	do := func(method string, uri string, contentType string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, uri, strings.NewReader(body))
+		req.Header.Set("accept", "application/json")
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		w := httptest.NewRecorder()
+		metad.manageRouter.ServeHTTP(w, req)
+		return w
+	}
+	w := do("POST", "/v1/data/clusters/cl-1", "", `{"name":"cl-1","env":{"debug":"false","legacy":"true"}}`)
+	Assert(t, 200 == w.Code)
+	time.Sleep(sleepTime)
+
+	w = do("PATCH", "/v1/data/clusters/cl-1", "", `{"env":{"debug":"true","legacy":null}}`)
+	Assert(t, 200 == w.Code, w.Body.String())
+	time.Sleep(sleepTime)
+	w = do("GET", "/v1/data/clusters/cl-1", "", "")
+	result := parse(w)
+	Assert(t, "cl-1" == util.GetMapValue(result, "/name"))
+	Assert(t, "true" == util.GetMapValue(result, "/env/debug"))
+	Assert(t, "" == util.GetMapValue(result, "/env/legacy"))
+
+	w = do("PUT", "/v1/data", "application/merge-patch+json; charset=utf-8", `{"clusters":{"cl-1":{"name":null}}}`)
+	Assert(t, 200 == w.Code, w.Body.String())
+	time.Sleep(sleepTime)
+	w = do("GET", "/v1/data/clusters/cl-1", "", "")
+	result = parse(w)
+	Assert(t, "" == util.GetMapValue(result, "/name"))
+	Assert(t, "true" == util.GetMapValue(result, "/env/debug"))
+
+	w = do("PATCH", "/v1/data/clusters/cl-1", "", `{"env":`)
+	Assert(t, 400 == w.Code)
+}
+
+func TestMetadTypedLeaf(t *testing.T) {
+	metad := NewTestMetad()
+	defer metad.Stop()
+
+	script := "#!/bin/sh\necho hello\n"
+	err := metad.metadataRepo.PutData("/nodes/1", map[string]interface{}{"init": script, "name": "n1"}, true)
+	Assert(t, nil == err)
+	err = metad.metadataRepo.PutMapping("/192.0.2.1", map[string]interface{}{"node": "/nodes/1"}, true)
+	Assert(t, nil == err)
+
+	req := httptest.NewRequest("PUT", "/v1/annotation/nodes/1/init", strings.NewReader(`{"content_type":"not a type/"}`))
+	w := httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 400 == w.Code)
+	req = httptest.NewRequest("PUT", "/v1/annotation/nodes/1/init", strings.NewReader(`{"content_type":"text/x-shellscript"}`))
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+	time.Sleep(sleepTime)
+
+	get := func(uri string, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", uri, nil)
+		if accept != "" {
+			req.Header.Set("accept", accept)
+		}
+		w := httptest.NewRecorder()
+		metad.router.ServeHTTP(w, req)
+		return w
+	}
+	for _, uri := range []string{"/nodes/1/init", "/self/node/init"} {
+		for _, accept := range []string{"", "*/*", "text/x-shellscript"} {
+			w = get(uri, accept)
+			Assert(t, 200 == w.Code)
+			Assert(t, "text/x-shellscript" == w.Header().Get("Content-Type"), uri, accept, w.Header().Get("Content-Type"))
+			Assert(t, script == w.Body.String())
+		}
+		w = get(uri, "application/json")
+		Assert(t, ContentTypeJSON == w.Header().Get("Content-Type"))
+		w = get(uri, "text/plain")
+		Assert(t, ContentTypeText == w.Header().Get("Content-Type"))
+	}
+	// the leaf without declared media type and the dir are not changed.
+	w = get("/nodes/1/name", "")
+	Assert(t, ContentTypeText == w.Header().Get("Content-Type"))
+	w = get("/nodes/1", "")
+	Assert(t, ContentTypeText == w.Header().Get("Content-Type"))
+}
+
+func TestMetadDataJSONPatch(t *testing.T) {
+	metad := NewTestMetad()
+	defer metad.Stop()
+
+	do := func(method string, uri string, contentType string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, uri, strings.NewReader(body))
+		req.Header.Set("accept", "application/json")
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		w := httptest.NewRecorder()
+		metad.manageRouter.ServeHTTP(w, req)
+		return w
+	}
+	w := do("POST", "/v1/data/apps/a1", "", `{"version":"3","env":{"debug":"false"}}`)
+	Assert(t, 200 == w.Code)
+	time.Sleep(sleepTime)
+
+	w = do("PATCH", "/v1/data/apps/a1", jsonPatchType, `[{"op":"test","path":"/version","value":"2"},{"op":"replace","path":"/version","value":"4"}]`)
+	Assert(t, 409 == w.Code, w.Code)
+	w = do("PATCH", "/v1/data/apps/a1", jsonPatchType, `[{"op":"increment","path":"/version"}]`)
+	Assert(t, 400 == w.Code, w.Code)
+	w = do("PATCH", "/v1/data/apps/a1", jsonPatchType, `{"op":"remove","path":"/version"}`)
+	Assert(t, 400 == w.Code, w.Code)
+	time.Sleep(sleepTime)
+	Assert(t, "3" == metad.metadataRepo.GetData("/apps/a1/version"))
+
+	w = do("PATCH", "/v1/data/apps", jsonPatchType, `[{"op":"test","path":"/a1/version","value":"3"},{"op":"replace","path":"/a1/version","value":"4"},{"op":"move","from":"/a1/env","path":"/a1/environment"}]`)
+	Assert(t, 200 == w.Code, w.Body.String())
+	time.Sleep(sleepTime)
+	w = do("GET", "/v1/data/apps/a1", "", "")
+	result := parse(w)
+	Assert(t, "4" == util.GetMapValue(result, "/version"))
+	Assert(t, "false" == util.GetMapValue(result, "/environment/debug"))
+	Assert(t, "" == util.GetMapValue(result, "/env/debug"))
+}
+
+func TestMetadDataDeleteMatch(t *testing.T) {
+	metad := NewTestMetad()
+	defer metad.Stop()
+
+	err := metad.metadataRepo.PutData("/clusters", map[string]interface{}{
+		"cl-1": map[string]interface{}{"tmp": "1", "name": "cl-1"},
+		"cl-2": map[string]interface{}{"tmp": "2"},
+	}, true)
+	Assert(t, nil == err)
+	time.Sleep(sleepTime)
+
+	do := func(uri string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("DELETE", uri, nil)
+		req.Header.Set("accept", "application/json")
+		w := httptest.NewRecorder()
+		metad.manageRouter.ServeHTTP(w, req)
+		return w
+	}
+	w := do("/v1/data?match=/clusters/*/tmp&subs=a")
+	Assert(t, 400 == w.Code)
+	w = do("/v1/data?match=/clusters/[")
+	Assert(t, 400 == w.Code)
+
+	w = do("/v1/data?match=/clusters/*/tmp&dry_run=true")
+	Assert(t, 200 == w.Code)
+	result := parse(w)
+	Assert(t, "true" == util.GetMapValue(result, "/dry_run"))
+	Assert(t, "2" == util.GetMapValue(result, "/count"))
+	Assert(t, "/clusters/cl-1/tmp" == util.GetMapValue(result, "/paths/0"))
+	time.Sleep(sleepTime)
+	Assert(t, "1" == metad.metadataRepo.GetData("/clusters/cl-1/tmp"))
+	w = do("/v1/data?match=/clusters/*/tmp&dryRun=true")
+	Assert(t, 200 == w.Code)
+	Assert(t, "true" == util.GetMapValue(parse(w), "/dry_run"))
+	time.Sleep(sleepTime)
+	Assert(t, "1" == metad.metadataRepo.GetData("/clusters/cl-1/tmp"))
+
+	w = do("/v1/data/clusters?match=*/tmp")
+	Assert(t, 200 == w.Code)
+	result = parse(w)
+	Assert(t, "false" == util.GetMapValue(result, "/dry_run"))
+	Assert(t, "2" == util.GetMapValue(result, "/count"))
+	time.Sleep(sleepTime)
+	Assert(t, nil == metad.metadataRepo.GetData("/clusters/cl-1/tmp"))
+	Assert(t, nil == metad.metadataRepo.GetData("/clusters/cl-2"))
+	Assert(t, "cl-1" == metad.metadataRepo.GetData("/clusters/cl-1/name"))
+
+	// the dry run require the write access of the matched paths too.
+	err = metad.metadataRepo.PutData("/clusters/cl-3/tmp", "3", true)
+	Assert(t, nil == err)
+	req := httptest.NewRequest("PUT", "/v1/annotation/clusters/cl-3", strings.NewReader(`{"owner":"team1"}`))
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code, w.Body.String())
+	time.Sleep(sleepTime)
+	w = do("/v1/data?match=/clusters/*/tmp&dryRun=true")
+	Assert(t, 403 == w.Code, w.Body.String())
+	Assert(t, "" == util.GetMapValue(parse(w), "/paths/0"), w.Body.String())
+}
+
+func TestMetadRequestTimeout(t *testing.T) {
+	metad := NewTestMetadWithConfig(&Config{MaxRequestTimeout: 1})
+	defer metad.Stop()
+
+	err := metad.metadataRepo.PutData("/nodes/1/name", "n1", false)
+	Assert(t, nil == err)
+	err = metad.metadataRepo.PutMapping("/192.0.2.1", map[string]interface{}{"node": "/nodes/1"}, true)
+	Assert(t, nil == err)
+	time.Sleep(sleepTime)
+
+	get := func(uri string, timeout string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", uri, nil)
+		req.Header.Set("accept", "application/json")
+		req.Header.Set(RequestTimeoutHeader, timeout)
+		w := httptest.NewRecorder()
+		metad.router.ServeHTTP(w, req)
+		return w
+	}
+	w := get("/nodes/1", "soon")
+	Assert(t, 400 == w.Code)
+	w = get("/nodes/1", "-1")
+	Assert(t, 400 == w.Code)
+	w = get("/nodes/1", "0.5")
+	Assert(t, 200 == w.Code)
+	Assert(t, "n1" == util.GetMapValue(parse(w), "/name"))
+
+	start := time.Now()
+	w = get("/nodes/1?wait=true", "200ms")
+	Assert(t, 504 == w.Code, w.Code)
+	Assert(t, time.Since(start) < time.Second)
+
+	// the timeout is bounded by max_request_timeout.
+	start = time.Now()
+	w = get("/nodes/1?wait=true", "60")
+	Assert(t, 504 == w.Code, w.Code)
+	Assert(t, time.Since(start) < 3*time.Second)
+
+	// the wait request changed before the timeout is not affected.
+	results := make(chan int, 1)
+	go func() {
+		results <- get("/nodes/1?wait=true", "1").Code
+	}()
+	time.Sleep(sleepTime)
+	Assert(t, nil == metad.metadataRepo.PutData("/nodes/1/name", "n2", false))
+	Assert(t, 200 == <-results)
+}
+
+func TestMetadDataSchema(t *testing.T) {
+	metad := NewTestMetad()
+	defer metad.Stop()
+
+	do := func(method string, uri string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, uri, strings.NewReader(body))
+		req.Header.Set("accept", "application/json")
+		w := httptest.NewRecorder()
+		metad.manageRouter.ServeHTTP(w, req)
+		return w
+	}
+	w := do("PUT", "/v1/data/nodes", `{"1": {"port": "http"}}`)
+	Assert(t, 200 == w.Code)
+	time.Sleep(sleepTime)
+
+	schema := `{"additionalProperties": {"type": "object", "required": ["port"], "properties": {"port": {"type": "integer"}}}}`
+	w = do("PUT", "/v1/schema/data/nodes", `{"type": "map"}`)
+	Assert(t, 400 == w.Code)
+	w = do("PUT", "/v1/schema/data/nodes", schema)
+	Assert(t, 422 == w.Code)
+	Assert(t, strings.Contains(w.Body.String(), "/1/port: should be integer."), w.Body.String())
+	w = do("PUT", "/v1/data/nodes/1/port", `"80"`)
+	Assert(t, 200 == w.Code)
+	time.Sleep(sleepTime)
+	w = do("PUT", "/v1/schema/data/nodes", schema)
+	Assert(t, 200 == w.Code)
+	time.Sleep(sleepTime)
+
+	w = do("GET", "/v1/schema/data/nodes", "")
+	Assert(t, 200 == w.Code)
+	Assert(t, "integer" == util.GetMapValue(parse(w), "/schema/additionalProperties/properties/port/type"))
+	w = do("GET", "/v1/schema/data", "")
+	Assert(t, 200 == w.Code)
+	Assert(t, "/nodes" == util.GetMapValue(parse(w), "/0/prefix"))
+	w = do("GET", "/v1/schema", "")
+	Assert(t, "/nodes" == util.GetMapValue(parse(w), "/data/0"))
+
+	w = do("POST", "/v1/data/nodes/2", `{"name": "n2"}`)
+	Assert(t, 422 == w.Code)
+	Assert(t, strings.Contains(w.Body.String(), "required property [port] is missing."), w.Body.String())
+	w = do("POST", "/v1/data/nodes/2", `{"name": "n2", "port": 8080}`)
+	Assert(t, 200 == w.Code)
+	time.Sleep(sleepTime)
+	w = do("DELETE", "/v1/data/nodes/2?subs=port", "")
+	Assert(t, 422 == w.Code)
+	time.Sleep(sleepTime)
+	Assert(t, "8080" == metad.metadataRepo.GetData("/nodes/2/port"))
+
+	w = do("DELETE", "/v1/schema/data/nodes", "")
+	Assert(t, 200 == w.Code)
+	time.Sleep(sleepTime)
+	w = do("GET", "/v1/schema/data/nodes", "")
+	Assert(t, 404 == w.Code)
+	w = do("DELETE", "/v1/data/nodes/2?subs=port", "")
+	Assert(t, 200 == w.Code)
+}
+
+func TestMetadExportImport(t *testing.T) {
+	metad := NewTestMetad()
+	defer metad.Stop()
+
+	do := func(method string, uri string, contentType string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, uri, strings.NewReader(body))
+		req.Header.Set("accept", "application/json")
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		w := httptest.NewRecorder()
+		metad.manageRouter.ServeHTTP(w, req)
+		return w
+	}
+	err := metad.metadataRepo.PutData("/clusters/cl-1", map[string]interface{}{"name": "cl-1", "size": "2"}, true)
+	Assert(t, nil == err)
+	time.Sleep(sleepTime)
+
+	w := do("GET", "/v1/export?prefix=/notexist", "", "")
+	Assert(t, 404 == w.Code)
+	w = do("GET", "/v1/export?prefix=/clusters", "", "")
+	Assert(t, 200 == w.Code)
+	exported := w.Body.String()
+	result := parse(w)
+	Assert(t, "/clusters" == util.GetMapValue(result, "/prefix"))
+	Assert(t, "cl-1" == util.GetMapValue(result, "/data/cl-1/name"))
+
+	req := httptest.NewRequest("GET", "/v1/export", nil)
+	req.Header.Set("accept", "application/yaml")
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+	Assert(t, strings.Contains(w.Body.String(), "exported_at:"), w.Body.String())
+
+	w = do("POST", "/v1/import?mode=append", "", exported)
+	Assert(t, 400 == w.Code)
+	w = do("POST", "/v1/import", "", `{"prefix": "/clusters"}`)
+	Assert(t, 400 == w.Code)
+
+	// import the export to other prefix.
+	w = do("POST", "/v1/import?prefix=/backup", "", exported)
+	Assert(t, 200 == w.Code)
+	Assert(t, "2" == util.GetMapValue(parse(w), "/keys"))
+	w = do("POST", "/v1/import?mode=replace", "application/yaml", "prefix: /clusters\ndata:\n  cl-2:\n    size: 3\n")
+	Assert(t, 200 == w.Code)
+	Assert(t, "replace" == util.GetMapValue(parse(w), "/mode"))
+	time.Sleep(sleepTime)
+	Assert(t, "2" == metad.metadataRepo.GetData("/backup/cl-1/size"))
+	Assert(t, nil == metad.metadataRepo.GetData("/clusters/cl-1"))
+	Assert(t, "3" == metad.metadataRepo.GetData("/clusters/cl-2/size"))
+}
+
+func TestMetadAuthz(t *testing.T) {
+	var queries int32
+	var available int32 = 1
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&queries, 1)
+		if atomic.LoadInt32(&available) == 0 {
+			http.Error(w, "unavailable", http.StatusInternalServerError)
+			return
+		}
+		var body struct {
+			Input AuthzInput `json:"input"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		switch {
+		case body.Input.API == AuthzAPIManage && body.Input.Method == "GET":
+			w.Write([]byte(`{"result": true}`))
+		case body.Input.API == AuthzAPIManage:
+			w.Write([]byte(`{"result": {"allow": false, "reason": "read only"}}`))
+		default:
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer srv.Close()
+	metad := NewTestMetadWithConfig(&Config{AuthzURL: srv.URL, AuthzCacheTTL: 10})
+	defer metad.Stop()
+	err := metad.metadataRepo.PutData("/", map[string]interface{}{"nodes": map[string]interface{}{"1": "1"}, "clusters": map[string]interface{}{"1": "1"}}, true)
+	Assert(t, nil == err)
+	time.Sleep(sleepTime)
+
+	manage := func(method string, uri string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, uri, strings.NewReader(`"1"`))
+		req.Header.Set("accept", "application/json")
+		w := httptest.NewRecorder()
+		metad.manageRouter.ServeHTTP(w, req)
+		return w
+	}
+	w := manage("GET", "/v1/data/nodes")
+	Assert(t, 200 == w.Code)
+	w = manage("GET", "/v1/data/nodes")
+	Assert(t, 200 == w.Code)
+	Assert(t, 1 == atomic.LoadInt32(&queries))
+	w = manage("PUT", "/v1/data/nodes/1")
+	Assert(t, 403 == w.Code)
+	Assert(t, strings.Contains(w.Body.String(), "read only"), w.Body.String())
+
+	req := httptest.NewRequest("GET", "/nodes", nil)
+	w = httptest.NewRecorder()
+	metad.router.ServeHTTP(w, req)
+	Assert(t, 403 == w.Code)
+
+	atomic.StoreInt32(&available, 0)
+	w = manage("GET", "/v1/data/clusters")
+	Assert(t, 503 == w.Code)
+	// the cached decisions are still used.
+	w = manage("GET", "/v1/data/nodes")
+	Assert(t, 200 == w.Code)
+
+	config := *metad.getConfig()
+	config.AuthzFailOpen = true
+	_, err = metad.applyConfig(&config)
+	Assert(t, err == nil, err)
+	w = manage("GET", "/v1/data/clusters")
+	Assert(t, 200 == w.Code)
+}
+
+func TestMetadOverlay(t *testing.T) {
+	metad := NewTestMetad()
+	defer metad.Stop()
+
+	do := func(method string, uri string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, uri, strings.NewReader(body))
+		req.Header.Set("accept", "application/json")
+		w := httptest.NewRecorder()
+		metad.manageRouter.ServeHTTP(w, req)
+		return w
+	}
+	err := metad.metadataRepo.PutData("/nodes", map[string]interface{}{"1": map[string]interface{}{"mode": "normal"}}, true)
+	Assert(t, nil == err)
+	w := do("PUT", "/v1/rule/", `{"192.168.1.1":[{"path":"/","mode":1}]}`)
+	Assert(t, 200 == w.Code)
+	time.Sleep(sleepTime)
+
+	w = do("PUT", "/v1/overlay/nodes/1", `{"value": {"mode": "maintenance"}, "ttl": 60, "max_ttl": 30}`)
+	Assert(t, 400 == w.Code)
+	w = do("PUT", "/v1/overlay/nodes/1", `{"value": {"mode": "maintenance"}, "ttl": 60}`)
+	Assert(t, 200 == w.Code)
+	Assert(t, "/nodes/1" == util.GetMapValue(parse(w), "/path"))
+	w = do("PUT", "/v1/overlay/nodes", `{"value": "x", "ttl": 60}`)
+	Assert(t, 409 == w.Code)
+	w = do("GET", "/v1/overlay/nodes/2", "")
+	Assert(t, 404 == w.Code)
+
+	// the read of the parent path extend the overlay.
+	time.Sleep(1100 * time.Millisecond)
+	req := httptest.NewRequest("GET", "/nodes", nil)
+	req.Header.Set("accept", "application/json")
+	req.RemoteAddr = "192.168.1.1:1234"
+	w = httptest.NewRecorder()
+	metad.router.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+	Assert(t, "maintenance" == util.GetMapValue(parse(w), "/1/mode"))
+
+	w = do("GET", "/v1/overlay/nodes/1", "")
+	Assert(t, 200 == w.Code)
+	Assert(t, "1" == util.GetMapValue(parse(w), "/extensions"), w.Body.String())
+	w = do("GET", "/v1/stats", "")
+	Assert(t, "1" == util.GetMapValue(parse(w), "/overlays/overlays"), w.Body.String())
+
+	w = do("DELETE", "/v1/overlay/nodes/1", "")
+	Assert(t, 200 == w.Code)
+	Assert(t, "normal" == metad.metadataRepo.GetData("/nodes/1/mode"))
+	w = do("GET", "/v1/overlay", "")
+	Assert(t, 200 == w.Code)
+	Assert(t, "[]" == strings.TrimSpace(w.Body.String()), w.Body.String())
+	w = do("DELETE", "/v1/overlay/nodes/1", "")
+	Assert(t, 404 == w.Code)
+}
+
+func TestMetadFreeze(t *testing.T) {
+	metad := NewTestMetad()
+	defer metad.Stop()
+
+	do := func(method string, uri string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, uri, strings.NewReader(body))
+		req.Header.Set("accept", "application/json")
+		w := httptest.NewRecorder()
+		metad.manageRouter.ServeHTTP(w, req)
+		return w
+	}
+	w := do("GET", "/v1/freeze", "")
+	Assert(t, 404 == w.Code)
+	w = do("PUT", "/v1/freeze", `{"reason": "black friday"}`)
+	Assert(t, 400 == w.Code)
+	w = do("PUT", "/v1/freeze", `{"reason": "black friday", "ttl": 60, "expire_at": 1}`)
+	Assert(t, 400 == w.Code)
+	w = do("PUT", "/v1/freeze", `{"reason": "black friday", "ttl": 60}`)
+	Assert(t, 200 == w.Code)
+	// the owner is the requester if empty.
+	Assert(t, "192.0.2.1" == util.GetMapValue(parse(w), "/owner"), w.Body.String())
+	time.Sleep(sleepTime)
+
+	w = do("GET", "/v1/freeze", "")
+	Assert(t, 200 == w.Code)
+	Assert(t, "black friday" == util.GetMapValue(parse(w), "/reason"))
+	w = do("PUT", "/v1/data/nodes/1", `{"ip": "192.168.1.1"}`)
+	Assert(t, 423 == w.Code)
+	message := util.GetMapValue(parse(w), "/message")
+	Assert(t, strings.Contains(message, "frozen by [192.0.2.1]") && strings.HasSuffix(message, ": black friday"), message)
+	w = do("PUT", "/v1/rule", `{"192.168.1.1":[{"path":"/","mode":1}]}`)
+	Assert(t, 423 == w.Code)
+	// the reads and the dry runs are allowed.
+	w = do("GET", "/v1/data", "")
+	Assert(t, 423 != w.Code)
+	w = do("DELETE", "/v1/data?match=/nodes/*&dry_run=true", "")
+	Assert(t, 200 == w.Code)
+
+	// replace the freeze.
+	w = do("POST", "/v1/freeze", `{"reason": "incident", "owner": "sre", "ttl": 60}`)
+	Assert(t, 200 == w.Code)
+	time.Sleep(sleepTime)
+	w = do("PUT", "/v1/data/nodes/1", `{"ip": "192.168.1.1"}`)
+	Assert(t, 423 == w.Code)
+	Assert(t, strings.Contains(w.Body.String(), "frozen by [sre]"), w.Body.String())
+
+	w = do("DELETE", "/v1/freeze", "")
+	Assert(t, 200 == w.Code)
+	time.Sleep(sleepTime)
+	w = do("PUT", "/v1/data/nodes/1", `{"ip": "192.168.1.1"}`)
+	Assert(t, 200 == w.Code)
+	w = do("DELETE", "/v1/freeze", "")
+	Assert(t, 404 == w.Code)
+}
+
+func TestSLOTracker(t *testing.T) {
+	tracker := newSLOTracker()
+	now := time.Now()
+	latency := 100 * time.Millisecond
+	tracker.record(SLOGroupMetadata, 500, time.Millisecond, false, latency, now.Add(-10*time.Minute))
+	tracker.record(SLOGroupMetadata, 200, time.Second, false, latency, now)
+	tracker.record(SLOGroupMetadata, 200, time.Millisecond, false, latency, now)
+	// the long-poll timeout is not error, and not counted by latency.
+	tracker.record(SLOGroupMetadata, 504, time.Minute, true, latency, now)
+	// the bucket of the same slot 24 hours ago is reset.
+	tracker.record(SLOGroupManage, 500, time.Millisecond, false, latency, now.Add(-24*time.Hour))
+	tracker.record(SLOGroupManage, 503, time.Millisecond, false, latency, now)
+
+	report := tracker.report(&SLOObjectives{Availability: 0.9, LatencyMs: 100, LatencyTarget: 0.5}, now)
+	Assert(t, 2 == len(report.Groups) && 4 == len(report.Groups[0].Windows))
+	w5m, w1h := report.Groups[0].Windows[0], report.Groups[0].Windows[1]
+	Assert(t, 3 == w5m.Requests && 0 == w5m.Errors && 1 == w5m.Availability && 0 == w5m.AvailabilityBurnRate)
+	Assert(t, 2 == w5m.LatencyRequests && 1 == w5m.Slow && 0.5 == w5m.LatencyAttainment && 1 == w5m.LatencyBurnRate)
+	Assert(t, 4 == w1h.Requests && 1 == w1h.Errors && 0.75 == w1h.Availability)
+	Assert(t, math.Abs(w1h.AvailabilityBurnRate-2.5) < 1e-9 && math.Abs(w1h.ErrorBudgetRemaining+1.5) < 1e-9, w1h.AvailabilityBurnRate)
+	manage := report.Groups[1].Windows[3]
+	Assert(t, "24h" == manage.Window && 1 == manage.Requests && 1 == manage.Errors)
+}
+
+func TestMetadSLO(t *testing.T) {
+	metad := NewTestMetadWithConfig(&Config{SLOAvailability: 0.99})
+	defer metad.Stop()
+
+	req := httptest.NewRequest("GET", "/v1/slo", nil)
+	req.Header.Set("accept", "application/json")
+	w := httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+	req = httptest.NewRequest("GET", "/nodes", nil)
+	w = httptest.NewRecorder()
+	metad.router.ServeHTTP(w, req)
+
+	req = httptest.NewRequest("GET", "/v1/slo", nil)
+	req.Header.Set("accept", "application/json")
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+	report := &SLOReport{}
+	Assert(t, nil == json.Unmarshal(w.Body.Bytes(), report))
+	Assert(t, 0.99 == report.Objectives.Availability && 100 == report.Objectives.LatencyMs && 0.99 == report.Objectives.LatencyTarget)
+	Assert(t, SLOGroupMetadata == report.Groups[0].Group && 1 == report.Groups[0].Windows[0].Requests)
+	Assert(t, SLOGroupManage == report.Groups[1].Group && 1 == report.Groups[1].Windows[0].Requests)
+
+	config := *metad.getConfig()
+	config.SLOAvailability = 1
+	_, err := metad.applyConfig(&config)
+	Assert(t, err != nil)
+}
+
+func TestMetadRender(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metad_render")
+	Assert(t, nil == err)
+	defer os.RemoveAll(dir)
+	src := path.Join(dir, "nginx.conf.tmpl")
+	Assert(t, nil == ioutil.WriteFile(src, []byte(`{{range getvs "/upstreams/*"}}server {{.}};
+{{end}}`), 0644))
+	dest := path.Join(dir, "nginx.conf")
+	reloaded := path.Join(dir, "reloaded")
+	_, err = New(&Config{Backend: testBackend, Renders: []*confd.Render{{Src: src, Dest: dest, Prefix: "/self/nginx"}}})
+	Assert(t, err != nil && strings.Contains(err.Error(), "require render_host"), err)
+
+	metad := NewTestMetadWithConfig(&Config{Renders: []*confd.Render{{Name: "nginx", Src: src, Dest: dest, Prefix: "/nginx",
+		Keys: []string{"/upstreams"}, Mode: "0600", CheckCmd: "grep -q server {{.src}}", ReloadCmd: "touch " + reloaded}}})
+	defer metad.Stop()
+
+	do := func(method string, uri string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, uri, strings.NewReader(body))
+		req.Header.Set("accept", "application/json")
+		w := httptest.NewRecorder()
+		metad.manageRouter.ServeHTTP(w, req)
+		return w
+	}
+	w := do("PUT", "/v1/data/nginx/upstreams", `{"1": "10.0.0.1:80", "2": "10.0.0.2:80"}`)
+	Assert(t, 200 == w.Code)
+	time.Sleep(renderInterval + sleepTime*5)
+
+	content, err := ioutil.ReadFile(dest)
+	Assert(t, nil == err, err)
+	Assert(t, "server 10.0.0.1:80;\nserver 10.0.0.2:80;\n" == string(content), string(content))
+	info, _ := os.Stat(dest)
+	Assert(t, os.FileMode(0600) == info.Mode().Perm())
+	_, err = os.Stat(reloaded)
+	Assert(t, nil == err)
+
+	// the check_cmd failed, the dest is kept.
+	w = do("DELETE", "/v1/data/nginx/upstreams", "")
+	Assert(t, 200 == w.Code)
+	time.Sleep(renderInterval + sleepTime*5)
+	content, _ = ioutil.ReadFile(dest)
+	Assert(t, strings.Contains(string(content), "10.0.0.1"), string(content))
+
+	w = do("GET", "/v1/render", "")
+	Assert(t, 200 == w.Code)
+	status := []RenderStatus{}
+	Assert(t, nil == json.Unmarshal(w.Body.Bytes(), &status))
+	Assert(t, 1 == len(status) && "nginx" == status[0].Name && 1 == status[0].Renders && 1 == status[0].Reloads, w.Body.String())
+	Assert(t, 1 == status[0].Failures && strings.Contains(status[0].LastError, "check_cmd"), w.Body.String())
+}
+
+func TestMetadFind(t *testing.T) {
+	metad := NewTestMetadWithConfig(&Config{IndexKeys: []string{"ip"}})
+	defer metad.Stop()
+
+	do := func(method string, uri string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, uri, strings.NewReader(body))
+		req.Header.Set("accept", "application/json")
+		w := httptest.NewRecorder()
+		metad.manageRouter.ServeHTTP(w, req)
+		return w
+	}
+	w := do("PUT", "/v1/data/nodes", `{"1": {"ip": "192.168.0.7"}, "2": {"ip": "192.168.0.8"}, "3": {"ip": "192.168.0.7", "name": "c"}}`)
+	Assert(t, 200 == w.Code)
+	time.Sleep(sleepTime)
+
+	w = do("GET", "/v1/find?key=ip&value=192.168.0.7", "")
+	Assert(t, 200 == w.Code)
+	result := &FindResult{}
+	Assert(t, nil == json.Unmarshal(w.Body.Bytes(), result))
+	Assert(t, "ip" == result.Key && reflect.DeepEqual([]string{"/nodes/1/ip", "/nodes/3/ip"}, result.Paths), w.Body.String())
+	w = do("GET", "/v1/find?key=ip&value=192.168.0.9", "")
+	Assert(t, 200 == w.Code && strings.Contains(w.Body.String(), `"paths":[]`), w.Body.String())
+	w = do("GET", "/v1/find?key=name&value=c", "")
+	Assert(t, 400 == w.Code && strings.Contains(w.Body.String(), "not indexed"), w.Body.String())
+	w = do("GET", "/v1/find?key=ip", "")
+	Assert(t, 400 == w.Code)
+
+	// the index is rebuilt on reload.
+	config := *metad.getConfig()
+	config.IndexKeys = []string{"ip", "name"}
+	_, err := metad.applyConfig(&config)
+	Assert(t, nil == err, err)
+	w = do("GET", "/v1/find?key=name&value=c&prefix=/nodes", "")
+	Assert(t, 200 == w.Code && strings.Contains(w.Body.String(), `"/nodes/3/name"`), w.Body.String())
+	config.IndexKeys = []string{"a/b"}
+	_, err = metad.applyConfig(&config)
+	Assert(t, err != nil)
+}
+
+func TestMetadTemplate(t *testing.T) {
+	metad := NewTestMetadWithConfig(&Config{TemplatePrefix: "/templates"})
+	defer metad.Stop()
+
+	err := metad.metadataRepo.PutData("/nodes/1", map[string]interface{}{"name": "n1", "upstreams": map[string]interface{}{"1": "10.0.0.1", "2": "10.0.0.2"}}, true)
+	Assert(t, nil == err)
+	err = metad.metadataRepo.PutMapping("/192.0.2.1", map[string]interface{}{"node": "/nodes/1"}, true)
+	Assert(t, nil == err)
+	err = metad.metadataRepo.PutData("/templates", map[string]interface{}{
+		"nginx.conf": `# {{.node.name}}
+{{range getvs "/node/upstreams/*"}}server {{.}};
+{{end}}`,
+		"bad":  `{{getv "/node/missing"}}`,
+		"env":  `{{getenv "HOME"}}`,
+		"conf": map[string]interface{}{"app.ini": "name={{getv \"/node/name\"}}"},
+	}, true)
+	Assert(t, nil == err)
+	time.Sleep(sleepTime)
+
+	get := func(uri string, header string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", uri, nil)
+		if header != "" {
+			req.Header.Set("If-None-Match", header)
+		}
+		w := httptest.NewRecorder()
+		metad.router.ServeHTTP(w, req)
+		return w
+	}
+	w := get("/render/nginx.conf", "")
+	Assert(t, 200 == w.Code, w.Body.String())
+	Assert(t, defaultTemplateMediaType == w.Header().Get("Content-Type"))
+	Assert(t, "# n1\nserver 10.0.0.1;\nserver 10.0.0.2;\n" == w.Body.String(), w.Body.String())
+	etag := w.Header().Get("ETag")
+	Assert(t, etag != "")
+	w = get("/render/nginx.conf", etag)
+	Assert(t, http.StatusNotModified == w.Code)
+	w = get("/render/conf/app.ini", "")
+	Assert(t, 200 == w.Code && "name=n1" == w.Body.String(), w.Body.String())
+
+	w = get("/render/bad", "")
+	Assert(t, http.StatusUnprocessableEntity == w.Code, w.Body.String())
+	// the local environment functions are not available.
+	w = get("/render/env", "")
+	Assert(t, http.StatusUnprocessableEntity == w.Code && strings.Contains(w.Body.String(), "getenv"), w.Body.String())
+	w = get("/render/missing", "")
+	Assert(t, 404 == w.Code)
+	w = get("/render/conf", "")
+	Assert(t, 404 == w.Code)
+
+	// disabled without template_prefix, /render is the data path.
+	metad2 := NewTestMetad()
+	defer metad2.Stop()
+	req := httptest.NewRequest("GET", "/render/nginx.conf", nil)
+	w = httptest.NewRecorder()
+	metad2.router.ServeHTTP(w, req)
+	Assert(t, 404 == w.Code)
+	Assert(t, !strings.Contains(w.Body.String(), "Template"), w.Body.String())
+}
+
+func TestMetadDepth(t *testing.T) {
+	metad := NewTestMetad()
+	defer metad.Stop()
+
+	err := metad.metadataRepo.PutData("/clusters", map[string]interface{}{
+		"cl-1": map[string]interface{}{"name": "a", "nodes": map[string]interface{}{"1": map[string]interface{}{"ip": "192.168.1.1"}}},
+		"cl-2": map[string]interface{}{"name": "b"},
+	}, true)
+	Assert(t, nil == err)
+	err = metad.metadataRepo.PutAccessRule(map[string][]store.AccessRule{"192.168.1.1": {{Path: "/", Mode: store.AccessModeRead}}})
+	Assert(t, nil == err)
+	time.Sleep(sleepTime)
+
+	get := func(router http.Handler, uri string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", uri, nil)
+		req.Header.Set("accept", "application/json")
+		req.RemoteAddr = "192.168.1.1:1234"
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	for _, w := range []*httptest.ResponseRecorder{get(metad.router, "/clusters?depth=1"), get(metad.manageRouter, "/v1/data/clusters?depth=1")} {
+		Assert(t, 200 == w.Code, w.Body.String())
+		Assert(t, `{"cl-1":["name","nodes/"],"cl-2":["name"]}` == w.Body.String(), w.Body.String())
+	}
+	w := get(metad.router, "/clusters?depth=2")
+	Assert(t, `{"cl-1":{"name":"a","nodes":["1/"]},"cl-2":{"name":"b"}}` == w.Body.String(), w.Body.String())
+	// the leaf is not changed.
+	w = get(metad.router, "/clusters/cl-1/name?depth=1")
+	Assert(t, `"a"` == w.Body.String(), w.Body.String())
+	for _, depth := range []string{"0", "-1", "a"} {
+		w = get(metad.router, "/clusters?depth="+depth)
+		Assert(t, 400 == w.Code)
+		w = get(metad.manageRouter, "/v1/data/clusters?depth="+depth)
+		Assert(t, 400 == w.Code)
+	}
+}
+
+func TestMetadFlatten(t *testing.T) {
+	metad := NewTestMetad()
+	defer metad.Stop()
+
+	err := metad.metadataRepo.PutData("/clusters", map[string]interface{}{
+		"cl-1": map[string]interface{}{"name": "a", "nodes": map[string]interface{}{"1": map[string]interface{}{"ip": "192.168.1.1"}}},
+	}, true)
+	Assert(t, nil == err)
+	err = metad.metadataRepo.PutAccessRule(map[string][]store.AccessRule{"192.168.1.1": {{Path: "/", Mode: store.AccessModeRead}}})
+	Assert(t, nil == err)
+	time.Sleep(sleepTime)
+
+	get := func(router http.Handler, uri string, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", uri, nil)
+		req.Header.Set("accept", accept)
+		req.RemoteAddr = "192.168.1.1:1234"
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	for _, w := range []*httptest.ResponseRecorder{get(metad.router, "/clusters?flatten=true", "application/json"), get(metad.manageRouter, "/v1/data/clusters?flatten=true", "application/json")} {
+		Assert(t, 200 == w.Code, w.Body.String())
+		Assert(t, `{"/clusters/cl-1/name":"a","/clusters/cl-1/nodes/1/ip":"192.168.1.1"}` == w.Body.String(), w.Body.String())
+	}
+	w := get(metad.router, "/clusters/cl-1/nodes?flatten=true", "text/plain")
+	Assert(t, "/clusters/cl-1/nodes/1/ip\t192.168.1.1\n" == w.Body.String(), w.Body.String())
+	w = get(metad.router, "/clusters/cl-1/name?flatten=true", "application/json")
+	Assert(t, `{"/clusters/cl-1/name":"a"}` == w.Body.String(), w.Body.String())
+	w = get(metad.router, "/clusters?flatten=true&depth=1", "application/json")
+	Assert(t, 400 == w.Code)
+	w = get(metad.router, "/clusters?flatten=true&wait=true&with_events=true", "application/json")
+	Assert(t, 400 == w.Code)
+}
+
+func TestMetadFilter(t *testing.T) {
+	metad := NewTestMetad()
+	defer metad.Stop()
+
+	err := metad.metadataRepo.PutData("/clusters", map[string]interface{}{
+		"cl-1": map[string]interface{}{"name": "a", "nodes": map[string]interface{}{"1": map[string]interface{}{"ip": "192.168.1.1"}, "2": map[string]interface{}{"ip": "192.168.1.2"}}},
+		"cl-2": map[string]interface{}{"name": "b"},
+	}, true)
+	Assert(t, nil == err)
+	err = metad.metadataRepo.PutAccessRule(map[string][]store.AccessRule{"192.168.1.1": {{Path: "/", Mode: store.AccessModeRead}}})
+	Assert(t, nil == err)
+	time.Sleep(sleepTime)
+
+	get := func(router http.Handler, uri string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", uri, nil)
+		req.Header.Set("accept", "application/json")
+		req.RemoteAddr = "192.168.1.1:1234"
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	for _, w := range []*httptest.ResponseRecorder{get(metad.router, "/clusters?filter=$.*.nodes[*].ip"), get(metad.manageRouter, "/v1/data/clusters?filter=$..ip")} {
+		Assert(t, 200 == w.Code, w.Body.String())
+		Assert(t, `["192.168.1.1","192.168.1.2"]` == w.Body.String(), w.Body.String())
+	}
+	w := get(metad.router, "/clusters?filter=$['cl-2','cl-1'].name")
+	Assert(t, `["a","b"]` == w.Body.String(), w.Body.String())
+	w = get(metad.router, "/clusters?filter=$.cl-3")
+	Assert(t, 200 == w.Code && `[]` == w.Body.String(), w.Body.String())
+	w = get(metad.router, "/clusters?filter=$[?(@.name)]")
+	Assert(t, 400 == w.Code, w.Body.String())
+	w = get(metad.router, "/clusters?filter=$..ip&flatten=true")
+	Assert(t, 400 == w.Code)
+}
+
+func TestMetadPagination(t *testing.T) {
+	metad := NewTestMetad()
+	defer metad.Stop()
+
+	hosts := map[string]interface{}{}
+	for i := 0; i < 5; i++ {
+		hosts[fmt.Sprintf("host-%d", i)] = map[string]interface{}{"ip": fmt.Sprintf("192.168.1.%d", i)}
+	}
+	err := metad.metadataRepo.PutData("/hosts", hosts, true)
+	Assert(t, nil == err)
+	err = metad.metadataRepo.PutAccessRule(map[string][]store.AccessRule{"192.168.1.1": {{Path: "/", Mode: store.AccessModeRead}}})
+	Assert(t, nil == err)
+	time.Sleep(sleepTime)
+
+	get := func(router http.Handler, uri string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", uri, nil)
+		req.Header.Set("accept", "application/json")
+		req.RemoteAddr = "192.168.1.1:1234"
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	for _, pair := range []struct {
+		router http.Handler
+		uri    string
+	}{{metad.router, "/hosts"}, {metad.manageRouter, "/v1/data/hosts"}} {
+		names := []string{}
+		token := ""
+		pages := 0
+		for {
+			w := get(pair.router, pair.uri+"?limit=2&continueToken="+token)
+			Assert(t, 200 == w.Code, w.Body.String())
+			page := map[string]interface{}{}
+			Assert(t, nil == json.Unmarshal(w.Body.Bytes(), &page))
+			Assert(t, len(page) <= 2)
+			for i := len(names); i < len(names)+len(page); i++ {
+				_, ok := page[fmt.Sprintf("host-%d", i)]
+				Assert(t, ok, page)
+			}
+			for name := range page {
+				names = append(names, name)
+			}
+			pages++
+			token = w.Header().Get("X-Metad-Continue-Token")
+			if token == "" {
+				break
+			}
+		}
+		Assert(t, 3 == pages && 5 == len(names), names)
+	}
+	w := get(metad.router, "/hosts?limit=2&depth=1")
+	Assert(t, `{"host-0":["ip"],"host-1":["ip"]}` == w.Body.String(), w.Body.String())
+	w = get(metad.router, "/hosts?limit=0")
+	Assert(t, 400 == w.Code)
+	w = get(metad.router, "/hosts?limit=2&continueToken=!")
+	Assert(t, 400 == w.Code)
+	w = get(metad.router, "/hosts?limit=2&flatten=true")
+	Assert(t, 400 == w.Code)
+}
+
+func TestMetadSort(t *testing.T) {
+	metad := NewTestMetad()
+	defer metad.Stop()
+
+	err := metad.metadataRepo.PutData("/hosts", map[string]interface{}{
+		"10": map[string]interface{}{"ip": "192.168.1.10"},
+		"9":  map[string]interface{}{"ip": "192.168.1.9"},
+	}, true)
+	Assert(t, nil == err)
+	// the later put is created after the synced hosts.
+	time.Sleep(sleepTime)
+	err = metad.metadataRepo.PutData("/hosts/1", map[string]interface{}{"ip": "192.168.1.1"}, true)
+	Assert(t, nil == err)
+	err = metad.metadataRepo.PutAccessRule(map[string][]store.AccessRule{"192.168.1.1": {{Path: "/", Mode: store.AccessModeRead}}})
+	Assert(t, nil == err)
+	time.Sleep(sleepTime)
+
+	get := func(router http.Handler, uri string, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", uri, nil)
+		req.Header.Set("accept", accept)
+		req.RemoteAddr = "192.168.1.1:1234"
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	for _, router := range []http.Handler{metad.router, metad.manageRouter} {
+		prefix := ""
+		if router == metad.manageRouter {
+			prefix = "/v1/data"
+		}
+		w := get(router, prefix+"/hosts?sort=name", "application/json")
+		Assert(t, 200 == w.Code, w.Body.String())
+		Assert(t, `{"1":{"ip":"192.168.1.1"},"10":{"ip":"192.168.1.10"},"9":{"ip":"192.168.1.9"}}` == w.Body.String(), w.Body.String())
+		w = get(router, prefix+"/hosts?sort=numeric", "application/json")
+		Assert(t, `{"1":{"ip":"192.168.1.1"},"9":{"ip":"192.168.1.9"},"10":{"ip":"192.168.1.10"}}` == w.Body.String(), w.Body.String())
+		w = get(router, prefix+"/hosts?sort=created", "application/json")
+		Assert(t, `{"9":{"ip":"192.168.1.9"},"10":{"ip":"192.168.1.10"},"1":{"ip":"192.168.1.1"}}` == w.Body.String(), w.Body.String())
+	}
+	w := get(metad.router, "/hosts?sort=created", "text/plain")
+	Assert(t, "/9/ip\t192.168.1.9\n/10/ip\t192.168.1.10\n/1/ip\t192.168.1.1\n" == w.Body.String(), w.Body.String())
+	w = get(metad.router, "/hosts?sort=numeric", "application/yaml")
+	Assert(t, "\"1\":\n  ip: 192.168.1.1\n\"9\":\n  ip: 192.168.1.9\n\"10\":\n  ip: 192.168.1.10\n" == w.Body.String(), w.Body.String())
+	w = get(metad.router, "/hosts?sort=random", "application/json")
+	Assert(t, 400 == w.Code)
+	w = get(metad.router, "/hosts?sort=name&limit=1", "application/json")
+	Assert(t, 400 == w.Code)
+}
+
+func TestMetadTypedValues(t *testing.T) {
+	metad := NewTestMetadWithConfig(&Config{TypedValues: true})
+	defer metad.Stop()
+
+	err := metad.metadataRepo.PutMapping("/192.0.2.1", map[string]interface{}{"node": "/nodes/1"}, true)
+	Assert(t, nil == err)
+	err = metad.metadataRepo.PutAccessRule(map[string][]store.AccessRule{"192.0.2.1": {{Path: "/", Mode: store.AccessModeRead}}})
+	Assert(t, nil == err)
+
+	do := func(router http.Handler, method string, uri string, body string, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, uri, strings.NewReader(body))
+		req.Header.Set("accept", accept)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	w := do(metad.manageRouter, "PUT", "/v1/data/nodes/1", `{"name":"n1","port":42,"weight":0.5,"enabled":true,"zone":null,"tags":["a",1]}`, "application/json")
+	Assert(t, 200 == w.Code, w.Body.String())
+	time.Sleep(sleepTime)
+
+	typed := `{"enabled":true,"name":"n1","port":42,"tags":{"0":"a","1":1},"weight":0.5,"zone":null}`
+	for _, w := range []*httptest.ResponseRecorder{do(metad.manageRouter, "GET", "/v1/data/nodes/1", "", "application/json"),
+		do(metad.router, "GET", "/nodes/1", "", "application/json"), do(metad.router, "GET", "/self/node", "", "application/json")} {
+		Assert(t, 200 == w.Code, w.Body.String())
+		Assert(t, typed == w.Body.String(), w.Body.String())
+	}
+	w = do(metad.router, "GET", "/self/node/port", "", "application/json")
+	Assert(t, "42" == w.Body.String(), w.Body.String())
+	w = do(metad.router, "GET", "/self/node/enabled", "", "text/plain")
+	Assert(t, "true" == w.Body.String(), w.Body.String())
+	w = do(metad.router, "GET", "/self/node", "", "application/yaml")
+	Assert(t, strings.Contains(w.Body.String(), "port: 42\n") && strings.Contains(w.Body.String(), "zone: null\n"), w.Body.String())
+	// the sorted text keep the typed leaves, same as the unsorted.
+	text := "/enabled\ttrue\n/name\tn1\n/port\t42\n/tags/0\ta\n/tags/1\t1\n/weight\t0.5\n/zone\t\n"
+	w = do(metad.router, "GET", "/nodes/1?sort=name", "", "text/plain")
+	Assert(t, text == w.Body.String(), w.Body.String())
+	w = do(metad.router, "GET", "/nodes/1", "", "text/plain")
+	Assert(t, text == w.Body.String(), w.Body.String())
+
+	// written again as string, or deleted.
+	w = do(metad.manageRouter, "PUT", "/v1/data/nodes/1/port", `"42"`, "application/json")
+	Assert(t, 200 == w.Code, w.Body.String())
+	w = do(metad.manageRouter, "DELETE", "/v1/data/nodes/1/enabled", "", "application/json")
+	Assert(t, 200 == w.Code, w.Body.String())
+	time.Sleep(sleepTime)
+	w = do(metad.manageRouter, "GET", "/v1/data/nodes/1", "", "application/json")
+	Assert(t, `{"name":"n1","port":"42","tags":{"0":"a","1":1},"weight":0.5,"zone":null}` == w.Body.String(), w.Body.String())
+	Assert(t, nil == metad.metadataRepo.PutData("/nodes/1/enabled", "true", false))
+	time.Sleep(sleepTime)
+	w = do(metad.router, "GET", "/nodes/1/enabled", "", "application/json")
+	Assert(t, `"true"` == w.Body.String(), w.Body.String())
+
+	// the types move and copy with the leaves, and are deleted with the matched or bulk deleted leaves.
+	w = do(metad.manageRouter, "PUT", "/v1/data/types/a", `{"port":42,"enabled":true}`, "application/json")
+	Assert(t, 200 == w.Code, w.Body.String())
+	time.Sleep(sleepTime)
+	w = do(metad.manageRouter, "POST", "/v1/data:move", `{"from":"/types/a","to":"/types/b"}`, "application/json")
+	Assert(t, 200 == w.Code, w.Body.String())
+	time.Sleep(sleepTime)
+	w = do(metad.manageRouter, "POST", "/v1/data:copy", `{"from":"/types/b","to":"/types/c","exclude":["/enabled"]}`, "application/json")
+	Assert(t, 200 == w.Code, w.Body.String())
+	time.Sleep(sleepTime)
+	w = do(metad.manageRouter, "GET", "/v1/data/types", "", "application/json")
+	Assert(t, `{"b":{"enabled":true,"port":42},"c":{"port":42}}` == w.Body.String(), w.Body.String())
+	Assert(t, nil == metad.metadataRepo.PutData("/types/a/port", "42", false))
+	Assert(t, nil == metad.metadataRepo.PutData("/types/c/enabled", "true", false))
+	time.Sleep(sleepTime)
+	w = do(metad.manageRouter, "DELETE", "/v1/data/types?match=b/*", "", "application/json")
+	Assert(t, 200 == w.Code, w.Body.String())
+	time.Sleep(sleepTime)
+	w = do(metad.manageRouter, "GET", "/v1/data/types", "", "application/json")
+	Assert(t, `{"a":{"port":"42"},"c":{"enabled":"true","port":42}}` == w.Body.String(), w.Body.String())
+	Assert(t, nil == metad.metadataRepo.BulkDelete(context.Background(), "/types/c", 1, 0, func(int, int) {}))
+	Assert(t, nil == metad.metadataRepo.PutData("/types/b", map[string]interface{}{"port": "42", "enabled": "true"}, false))
+	Assert(t, nil == metad.metadataRepo.PutData("/types/c/port", "42", false))
+	time.Sleep(sleepTime)
+	w = do(metad.manageRouter, "GET", "/v1/data/types", "", "application/json")
+	Assert(t, `{"a":{"port":"42"},"b":{"enabled":"true","port":"42"},"c":{"port":"42"}}` == w.Body.String(), w.Body.String())
+
+	// disabled by reload, the recorded types are not responded, and the cached typed responses are not used.
+	w = do(metad.router, "GET", "/nodes/1/weight", "", "application/json")
+	Assert(t, `0.5` == w.Body.String(), w.Body.String())
+	metad.metadataRepo.SetTypedValues(false)
+	w = do(metad.router, "GET", "/nodes/1/weight", "", "application/json")
+	Assert(t, `"0.5"` == w.Body.String(), w.Body.String())
+}
+
+func TestMetadNodeMeta(t *testing.T) {
+	metad := NewTestMetadWithConfig(&Config{NodeHistory: 3})
+	defer metad.Stop()
+
+	err := metad.metadataRepo.PutData("/nodes/1", map[string]interface{}{"ip": "192.168.1.1", "name": "a"}, true)
+	Assert(t, nil == err)
+	time.Sleep(sleepTime)
+	err = metad.metadataRepo.PutData("/nodes/1/ip", "192.168.1.2", false)
+	Assert(t, nil == err)
+	time.Sleep(sleepTime)
+
+	get := func(uri string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", uri, nil)
+		req.Header.Set("accept", "application/json")
+		w := httptest.NewRecorder()
+		metad.manageRouter.ServeHTTP(w, req)
+		return w
+	}
+	w := get("/v1/data/nodes/1?meta=true")
+	Assert(t, 200 == w.Code, w.Body.String())
+	meta := store.NodeMeta{}
+	Assert(t, nil == json.Unmarshal(w.Body.Bytes(), &meta))
+	ip := meta.Children["ip"]
+	Assert(t, 2 == len(meta.Children) && ip != nil && "192.168.1.2" == *ip.Value, w.Body.String())
+	Assert(t, 1 == len(ip.History) && "192.168.1.1" == ip.History[0].Value && ip.History[0].ModifiedVersion == ip.CreatedVersion, w.Body.String())
+	Assert(t, ip.ModifiedVersion > ip.CreatedVersion && meta.ModifiedVersion == ip.ModifiedVersion, w.Body.String())
+	Assert(t, 0 == len(meta.Children["name"].History) && meta.Children["name"].ModifiedVersion < ip.ModifiedVersion)
+
+	w = get("/v1/data/nodes/2?meta=true")
+	Assert(t, 404 == w.Code)
+	w = get("/v1/data/nodes?meta=true&depth=1")
+	Assert(t, 400 == w.Code)
+}
+
+func TestMetadChangeLog(t *testing.T) {
+	file, err := ioutil.TempFile("", "metad-changelog")
+	Assert(t, nil == err)
+	file.Close()
+	os.Remove(file.Name())
+	defer os.Remove(file.Name())
+	metad := NewTestMetadWithConfig(&Config{ChangeLog: file.Name(), SensitivePrefixes: []string{"/secrets"}})
+	defer metad.Stop()
+	info, err := os.Stat(file.Name())
+	Assert(t, nil == err && 0600 == info.Mode().Perm(), info.Mode())
+
+	err = metad.metadataRepo.PutData("/nodes/1", map[string]interface{}{"ip": "192.168.1.1", "name": "a"}, true)
+	Assert(t, nil == err)
+	// the changes are logged when the subscription received them.
+	time.Sleep(2 * sleepTime)
+	before := time.Now()
+	time.Sleep(sleepTime)
+	err = metad.metadataRepo.PutData("/nodes/1/ip", "192.168.1.2", false)
+	Assert(t, nil == err)
+	err = metad.metadataRepo.DeleteData("/nodes/1/name")
+	Assert(t, nil == err)
+	err = metad.metadataRepo.PutData("/secrets/db", map[string]interface{}{"password": "pw-in-log"}, true)
+	Assert(t, nil == err)
+	time.Sleep(2 * sleepTime)
+
+	// the sensitive values are masked in the log.
+	b, err := ioutil.ReadFile(file.Name())
+	Assert(t, nil == err && !strings.Contains(string(b), "pw-in-log") && strings.Contains(string(b), redact.Mask), string(b))
+
+	get := func(m *Metad, uri string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", uri, nil)
+		req.Header.Set("accept", "application/json")
+		w := httptest.NewRecorder()
+		m.manageRouter.ServeHTTP(w, req)
+		return w
+	}
+	w := get(metad, "/v1/data/nodes/1?at="+before.UTC().Format(time.RFC3339Nano))
+	Assert(t, 200 == w.Code, w.Body.String())
+	Assert(t, `{"ip":"192.168.1.1","name":"a"}` == w.Body.String(), w.Body.String())
+	w = get(metad, "/v1/data/nodes/1/ip?at="+strconv.FormatInt(time.Now().Unix()+1, 10))
+	Assert(t, 200 == w.Code && `"192.168.1.2"` == w.Body.String(), w.Body.String())
+	w = get(metad, "/v1/data/nodes/1/name?at="+strconv.FormatInt(time.Now().Unix()+1, 10))
+	Assert(t, 404 == w.Code, w.Body.String())
+	w = get(metad, "/v1/data/nodes?at=1")
+	Assert(t, 404 == w.Code, w.Body.String())
+	w = get(metad, "/v1/data/nodes?at=yesterday")
+	Assert(t, 400 == w.Code, w.Body.String())
+
+	noLog := NewTestMetad()
+	defer noLog.Stop()
+	w = get(noLog, "/v1/data/nodes?at=1")
+	Assert(t, 400 == w.Code, w.Body.String())
+}
+
+func TestMetadTrash(t *testing.T) {
+	metad := NewTestMetadWithConfig(&Config{TrashRetention: 3600})
+	defer metad.Stop()
+
+	err := metad.metadataRepo.PutData("/nodes", map[string]interface{}{"1": map[string]interface{}{"ip": "192.168.1.1"}, "2": map[string]interface{}{"ip": "192.168.1.2"}}, true)
+	Assert(t, nil == err)
+	time.Sleep(sleepTime)
+
+	do := func(method string, uri string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, uri, nil)
+		req.Header.Set("accept", "application/json")
+		w := httptest.NewRecorder()
+		metad.manageRouter.ServeHTTP(w, req)
+		return w
+	}
+	w := do("DELETE", "/v1/data/nodes/1")
+	Assert(t, 200 == w.Code, w.Body.String())
+	time.Sleep(sleepTime)
+	w = do("GET", "/v1/data/nodes/1")
+	Assert(t, 404 == w.Code)
+
+	entries := []*metadata.TrashEntry{}
+	w = do("GET", "/v1/trash")
+	Assert(t, nil == json.Unmarshal(w.Body.Bytes(), &entries))
+	Assert(t, 1 == len(entries) && reflect.DeepEqual([]string{"/nodes/1"}, entries[0].Paths), w.Body.String())
+	id := entries[0].ID
+	w = do("GET", "/v1/data/_trash/"+id+"/nodes/1/ip")
+	Assert(t, 200 == w.Code && `"192.168.1.1"` == w.Body.String(), w.Body.String())
+
+	err = metad.metadataRepo.PutData("/nodes/1/ip", "192.168.1.3", true)
+	Assert(t, nil == err)
+	time.Sleep(sleepTime)
+	w = do("POST", "/v1/trash/"+id+"/restore")
+	Assert(t, 409 == w.Code, w.Body.String())
+	w = do("POST", "/v1/trash/"+id+"/restore?overwrite=true")
+	Assert(t, 200 == w.Code, w.Body.String())
+	time.Sleep(sleepTime)
+	w = do("GET", "/v1/data/nodes/1/ip")
+	Assert(t, `"192.168.1.1"` == w.Body.String(), w.Body.String())
+	w = do("GET", "/v1/trash/"+id)
+	Assert(t, 404 == w.Code)
+	w = do("GET", "/v1/data/_trash")
+	Assert(t, 404 == w.Code)
+
+	// the root delete keep the trash.
+	w = do("DELETE", "/v1/data")
+	Assert(t, 200 == w.Code, w.Body.String())
+	time.Sleep(sleepTime)
+	w = do("GET", "/v1/data/nodes")
+	Assert(t, 404 == w.Code)
+	entries = metad.metadataRepo.GetTrashEntries()
+	Assert(t, 1 == len(entries) && reflect.DeepEqual([]string{"/nodes"}, entries[0].Paths))
+	w = do("GET", "/v1/data/_trash/"+entries[0].ID+"/nodes/2/ip")
+	Assert(t, `"192.168.1.2"` == w.Body.String(), w.Body.String())
+
+	metad.metadataRepo.ExpireTrash(time.Now().Add(time.Hour))
+	time.Sleep(sleepTime)
+	Assert(t, 0 == len(metad.metadataRepo.GetTrashEntries()))
+	w = do("GET", "/v1/data/_trash")
+	Assert(t, 404 == w.Code)
+
+	// the patch deletes and the delete job move the data to the trash, the release apply don't.
+	doBody := func(method string, uri string, contentType string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, uri, strings.NewReader(body))
+		req.Header.Set("accept", "application/json")
+		req.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		metad.manageRouter.ServeHTTP(w, req)
+		return w
+	}
+	err = metad.metadataRepo.PutData("/nodes", map[string]interface{}{"1": map[string]interface{}{"ip": "192.168.1.1"}, "2": map[string]interface{}{"ip": "192.168.1.2", "name": "n2"}}, true)
+	Assert(t, nil == err)
+	time.Sleep(sleepTime)
+	w = doBody("PATCH", "/v1/data/nodes", "application/merge-patch+json", `{"1":null}`)
+	Assert(t, 200 == w.Code, w.Body.String())
+	time.Sleep(sleepTime)
+	entries = metad.metadataRepo.GetTrashEntries()
+	Assert(t, 1 == len(entries) && reflect.DeepEqual([]string{"/nodes/1"}, entries[0].Paths), entries)
+	w = do("GET", "/v1/data/_trash/"+entries[0].ID+"/nodes/1/ip")
+	Assert(t, `"192.168.1.1"` == w.Body.String(), w.Body.String())
+	w = doBody("PATCH", "/v1/data/nodes", "application/json-patch+json", `[{"op":"remove","path":"/2/name"}]`)
+	Assert(t, 200 == w.Code, w.Body.String())
+	time.Sleep(sleepTime)
+	entries = metad.metadataRepo.GetTrashEntries()
+	Assert(t, 2 == len(entries) && reflect.DeepEqual([]string{"/nodes/2/name"}, entries[1].Paths), entries)
+	w = do("GET", "/v1/data/nodes/2")
+	Assert(t, `{"ip":"192.168.1.2"}` == w.Body.String(), w.Body.String())
+
+	w = doBody("POST", "/v1/release", "application/json", `{"name":"r1","changes":[{"path":"/nodes/2/ip","action":"delete"}]}`)
+	Assert(t, 200 == w.Code, w.Body.String())
+	time.Sleep(sleepTime)
+	w = do("POST", "/v1/release/r1/apply")
+	Assert(t, 200 == w.Code, w.Body.String())
+	time.Sleep(sleepTime)
+	Assert(t, nil == metad.metadataRepo.GetData("/nodes/2"))
+	Assert(t, 2 == len(metad.metadataRepo.GetTrashEntries()))
+
+	err = metad.metadataRepo.PutData("/nodes/3/ip", "192.168.1.3", true)
+	Assert(t, nil == err)
+	time.Sleep(sleepTime)
+	w = doBody("POST", "/v1/job", "application/json", `{"type":"delete","path":"/nodes","batch_size":1}`)
+	Assert(t, 200 == w.Code, w.Body.String())
+	id = parse(w).(map[string]interface{})["id"].(string)
+	for i := 0; i < 100 && JobStatusRunning == metad.jobs.get(id).Status; i++ {
+		time.Sleep(50 * time.Millisecond)
+	}
+	Assert(t, JobStatusSucceeded == metad.jobs.get(id).Status)
+	time.Sleep(sleepTime)
+	Assert(t, nil == metad.metadataRepo.GetData("/nodes"))
+	entries = metad.metadataRepo.GetTrashEntries()
+	Assert(t, 3 == len(entries) && reflect.DeepEqual([]string{"/nodes"}, entries[2].Paths), entries)
+	w = do("GET", "/v1/data/_trash/"+entries[2].ID+"/nodes/3/ip")
+	Assert(t, `"192.168.1.3"` == w.Body.String(), w.Body.String())
+}
+
+func TestMetadLeader(t *testing.T) {
+	metad := NewTestMetadWithConfig(&Config{LeaderElection: true, LeaderTTL: 10, LeaderID: "metad-1"})
+	defer metad.Stop()
+	time.Sleep(sleepTime)
+
+	do := func(method string, uri string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, uri, strings.NewReader(body))
+		req.Header.Set("accept", "application/json")
+		w := httptest.NewRecorder()
+		metad.manageRouter.ServeHTTP(w, req)
+		return w
+	}
+	w := do("GET", "/v1/leader", "")
+	Assert(t, 200 == w.Code)
+	Assert(t, `{"id":"metad-1","is_leader":true,"leader":"metad-1","leader_election":true}` == w.Body.String(), w.Body.String())
+	w = do("PUT", "/v1/data/nodes/1", `{"ip":"192.168.1.1"}`)
+	Assert(t, 200 == w.Code, w.Body.String())
+
+	// the standby serve the reads and reject the writes.
+	metad.setLeader("metad-1", false)
+	w = do("PUT", "/v1/data/nodes/1", `{"ip":"192.168.1.2"}`)
+	Assert(t, 503 == w.Code, w.Body.String())
+	Assert(t, strings.Contains(w.Body.String(), "metad-1"), w.Body.String())
+	time.Sleep(sleepTime)
+	w = do("GET", "/v1/data/nodes/1/ip", "")
+	Assert(t, `"192.168.1.1"` == w.Body.String(), w.Body.String())
+
+	metad.setLeader("metad-1", true)
+	w = do("PUT", "/v1/data/nodes/1", `{"ip":"192.168.1.2"}`)
+	Assert(t, 200 == w.Code, w.Body.String())
+}
+
+func TestMetadReplica(t *testing.T) {
+	upstream := NewTestMetad()
+	server := httptest.NewServer(upstream.manageRouter)
+	defer server.Close()
+	defer upstream.Stop()
+
+	do := func(metad *Metad, method string, uri string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, uri, strings.NewReader(body))
+		req.Header.Set("accept", "application/json")
+		w := httptest.NewRecorder()
+		metad.manageRouter.ServeHTTP(w, req)
+		return w
+	}
+	w := do(upstream, "PUT", "/v1/data/nodes/1", `{"ip":"192.168.1.1"}`)
+	Assert(t, 200 == w.Code, w.Body.String())
+	w = do(upstream, "PUT", "/v1/mapping", `{"192.168.1.1":{"node":"/nodes/1"}}`)
+	Assert(t, 200 == w.Code, w.Body.String())
+	time.Sleep(sleepTime)
+
+	w = do(upstream, "GET", "/v1/replicate/unknown", "")
+	Assert(t, 400 == w.Code, w.Body.String())
+
+	replica, err := New(&Config{Backend: "metad", BackendNodes: []string{server.URL}})
+	Assert(t, err == nil, err)
+	replica.Init()
+	defer replica.Stop()
+
+	// the initial snapshot is synced.
+	w = do(replica, "GET", "/v1/data/nodes/1/ip", "")
+	Assert(t, `"192.168.1.1"` == w.Body.String(), w.Body.String())
+	w = do(replica, "GET", "/v1/mapping/192.168.1.1/node", "")
+	Assert(t, `"/nodes/1"` == w.Body.String(), w.Body.String())
+
+	// the changes are streamed.
+	w = do(upstream, "PUT", "/v1/data/nodes/1", `{"ip":"192.168.1.2"}`)
+	Assert(t, 200 == w.Code, w.Body.String())
+	w = do(upstream, "PUT", "/v1/data/nodes/2", `{"ip":"192.168.1.3"}`)
+	Assert(t, 200 == w.Code, w.Body.String())
+	w = do(upstream, "DELETE", "/v1/mapping/192.168.1.1", "")
+	Assert(t, 200 == w.Code, w.Body.String())
+	time.Sleep(2 * sleepTime)
+	w = do(replica, "GET", "/v1/data/nodes", "")
+	Assert(t, `{"1":{"ip":"192.168.1.2"},"2":{"ip":"192.168.1.3"}}` == w.Body.String(), w.Body.String())
+	w = do(replica, "GET", "/v1/mapping/192.168.1.1", "")
+	Assert(t, 404 == w.Code, w.Body.String())
+
+	// the replica is read only.
+	w = do(replica, "PUT", "/v1/data/nodes/3", `{"ip":"192.168.1.4"}`)
+	Assert(t, 403 == w.Code, w.Body.String())
+	Assert(t, strings.Contains(w.Body.String(), "read-only replica"), w.Body.String())
+	Assert(t, strings.Contains(w.Body.String(), server.URL), w.Body.String())
+	w = do(replica, "PUT", "/v1/mapping", `{"192.168.1.5":{"node":"/nodes/1"}}`)
+	Assert(t, 403 == w.Code, w.Body.String())
+	w = do(replica, "DELETE", "/v1/data/nodes/1", "")
+	Assert(t, 403 == w.Code, w.Body.String())
+	// the local operations of the replica are served.
+	w = do(replica, "POST", "/v1/verify", "")
+	Assert(t, 200 == w.Code, w.Body.String())
+	w = do(replica, "PUT", "/v1/data/nodes/3?dry_run=true", `{"ip":"192.168.1.4"}`)
+	Assert(t, 403 != w.Code, w.Body.String())
+}
+
+func TestMetadVerifyInterval(t *testing.T) {
+	_, err := New(&Config{Backend: testBackend, VerifyInterval: -1})
+	Assert(t, err != nil)
+
+	upstream := NewTestMetad()
+	server := httptest.NewServer(upstream.manageRouter)
+	defer server.Close()
+	defer upstream.Stop()
+
+	// the replica is not the local backend, so it is verified.
+	replica, err := New(&Config{Backend: "metad", BackendNodes: []string{server.URL}, VerifyInterval: 1})
+	Assert(t, err == nil, err)
+	replica.Init()
+	defer replica.Stop()
+
+	checkedAt := func() string {
+		req := httptest.NewRequest("GET", "/v1/verify", nil)
+		req.Header.Set("accept", "application/json")
+		w := httptest.NewRecorder()
+		replica.manageRouter.ServeHTTP(w, req)
+		Assert(t, 200 == w.Code, w.Body.String())
+		report := &metadata.VerifyReport{}
+		Assert(t, nil == json.Unmarshal(w.Body.Bytes(), report))
+		Assert(t, report.Consistent, w.Body.String())
+		return report.CheckedAt.String()
+	}
+	time.Sleep(1500 * time.Millisecond)
+	first := checkedAt()
+	time.Sleep(2 * time.Second)
+	Assert(t, first != checkedAt())
+}
+
+func TestMetadBackendEndpoints(t *testing.T) {
+	upstream := NewTestMetad()
+	server := httptest.NewServer(upstream.manageRouter)
+	defer server.Close()
+	defer upstream.Stop()
+
+	do := func(metad *Metad, method string, uri string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, uri, strings.NewReader(body))
+		req.Header.Set("accept", "application/json")
+		w := httptest.NewRecorder()
+		metad.manageRouter.ServeHTTP(w, req)
+		return w
+	}
+	w := do(upstream, "GET", "/v1/backend", "")
+	Assert(t, `{"backend":"local","endpoints":null}` == w.Body.String(), w.Body.String())
+	w = do(upstream, "PUT", "/v1/backend/endpoints", `["http://127.0.0.1:2379"]`)
+	Assert(t, 400 == w.Code, w.Body.String())
+
+	replica, err := New(&Config{Backend: "metad", BackendNodes: []string{server.URL}})
+	Assert(t, err == nil, err)
+	replica.Init()
+	defer replica.Stop()
+
+	w = do(replica, "GET", "/v1/backend", "")
+	Assert(t, 200 == w.Code, w.Body.String())
+	var backend struct {
+		Backend   string                  `json:"backend"`
+		Endpoints []*store.EndpointStatus `json:"endpoints"`
+	}
+	Assert(t, nil == json.Unmarshal(w.Body.Bytes(), &backend))
+	Assert(t, "metad" == backend.Backend)
+	Assert(t, 1 == len(backend.Endpoints) && backend.Endpoints[0].Endpoint == server.URL && backend.Endpoints[0].Healthy, w.Body.String())
+
+	w = do(replica, "PUT", "/v1/backend/endpoints", `[]`)
+	Assert(t, 400 == w.Code, w.Body.String())
+	w = do(replica, "PUT", "/v1/backend/endpoints", fmt.Sprintf(`["http://127.0.0.1:1", "%s"]`, server.URL))
+	Assert(t, 200 == w.Code, w.Body.String())
+	Assert(t, reflect.DeepEqual([]string{"http://127.0.0.1:1", server.URL}, replica.getConfig().BackendNodes))
+	var endpoints []*store.EndpointStatus
+	Assert(t, nil == json.Unmarshal(w.Body.Bytes(), &endpoints))
+	Assert(t, 2 == len(endpoints) && !endpoints[0].Healthy && endpoints[1].Healthy, w.Body.String())
+
+	// the standby accept the endpoints update.
+	replica.config.LeaderElection = true
+	w = do(replica, "PUT", "/v1/backend/endpoints", fmt.Sprintf(`["%s"]`, server.URL))
+	Assert(t, 200 == w.Code, w.Body.String())
+	w = do(replica, "PUT", "/v1/data/nodes/1", `{"ip":"192.168.1.1"}`)
+	Assert(t, 503 == w.Code, w.Body.String())
+}
+
+func writeTestServerCert(t *testing.T, certFile string, keyFile string, name string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	Assert(t, err == nil, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certDer, err := x509.CreateCertificate(crand.Reader, template, template, &key.PublicKey, key)
+	Assert(t, err == nil, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	Assert(t, err == nil, err)
+	Assert(t, nil == ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDer}), 0600))
+	Assert(t, nil == ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+}
+
+func TestMetadTLSCertReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metad-tls")
+	Assert(t, err == nil, err)
+	defer os.RemoveAll(dir)
+	certFile, keyFile := path.Join(dir, "cert.pem"), path.Join(dir, "key.pem")
+	writeTestServerCert(t, certFile, keyFile, "server-1")
+
+	metad := NewTestMetad()
+	defer metad.Stop()
+	certs, err := newServerCerts(certFile, keyFile, "")
+	Assert(t, err == nil, err)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Assert(t, err == nil, err)
+	server := &http.Server{Handler: metad.router}
+	go server.Serve(tls.NewListener(listener, tlsConfig(certs, metad.getConfig().TLSRequireClientCert)))
+	defer server.Close()
+
+	newClient := func() *http.Client {
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	}
+	serverName := func(client *http.Client) string {
+		resp, err := client.Get("https://" + listener.Addr().String() + "/")
+		Assert(t, err == nil, err)
+		ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return resp.TLS.PeerCertificates[0].Subject.CommonName
+	}
+	client := newClient()
+	Assert(t, "server-1" == serverName(client))
+
+	// a half-written rotation keeps the current cert.
+	later := time.Now().Add(time.Minute)
+	Assert(t, nil == ioutil.WriteFile(keyFile, []byte("invalid"), 0600))
+	Assert(t, nil == os.Chtimes(keyFile, later, later))
+	reloaded, err := certs.reload()
+	Assert(t, err != nil && !reloaded)
+	Assert(t, "server-1" == serverName(newClient()))
+
+	writeTestServerCert(t, certFile, keyFile, "server-2")
+	later = later.Add(time.Minute)
+	Assert(t, nil == os.Chtimes(certFile, later, later))
+	Assert(t, nil == os.Chtimes(keyFile, later, later))
+	reloaded, err = certs.reload()
+	Assert(t, err == nil && reloaded, err)
+	reloaded, err = certs.reload()
+	Assert(t, err == nil && !reloaded, err)
+
+	// the established connection is kept, the new connection use the rotated cert.
+	Assert(t, "server-1" == serverName(client))
+	Assert(t, "server-2" == serverName(newClient()))
+}
+
+func TestMetadUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metad-unix")
+	Assert(t, err == nil, err)
+	defer os.RemoveAll(dir)
+	socket := path.Join(dir, "metad.sock")
+
+	metad := NewTestMetadWithConfig(&Config{ListenUnix: socket})
+	defer metad.Stop()
+	metad.watchUnix()
+
+	do := func(method string, uri string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, uri, strings.NewReader(body))
+		req.Header.Set("accept", "application/json")
+		w := httptest.NewRecorder()
+		metad.manageRouter.ServeHTTP(w, req)
+		return w
+	}
+	identity := fmt.Sprintf("unix:%d", os.Getuid())
+	Assert(t, 200 == do("PUT", "/v1/data/nodes/1", `{"name":"node1"}`).Code)
+	Assert(t, 200 == do("PUT", "/v1/mapping", fmt.Sprintf(`{"%s":{"node":"/nodes/1"}}`, identity)).Code)
+	time.Sleep(sleepTime)
+
+	client := &http.Client{Transport: &http.Transport{DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+	}}}
+	req, _ := http.NewRequest("GET", "http://metad/self/node/name", nil)
+	req.Header.Set("accept", "application/json")
+	resp, err := client.Do(req)
+	Assert(t, err == nil, err)
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	Assert(t, 200 == resp.StatusCode, string(body))
+	Assert(t, `"node1"` == string(body), string(body))
+
+	info, err := os.Stat(socket)
+	Assert(t, err == nil, err)
+	Assert(t, os.FileMode(0660) == info.Mode().Perm(), info.Mode().Perm())
+
+	// the connection without peer credential is closed, not served by the headers.
+	server, peer := net.Pipe()
+	defer peer.Close()
+	ctx := unixConnContext(context.Background(), server)
+	Assert(t, nil == ctx.Value("peerCred"))
+	_, err = server.Write([]byte("x"))
+	Assert(t, err != nil)
+
+	_, err = New(&Config{Backend: testBackend, ListenUnix: socket, ListenUnixMode: "rw"})
+	Assert(t, err != nil)
+	mode, err := unixSocketMode(&Config{ListenUnixMode: "0600"})
+	Assert(t, err == nil, err)
+	Assert(t, os.FileMode(0600) == mode, mode)
+}
+
+func TestMetadTrustedProxies(t *testing.T) {
+	metad := NewTestMetadWithConfig(&Config{EnableXff: true, TrustedProxies: []string{"10.0.0.0/24", "10.0.1.1"}})
+	defer metad.Stop()
+
+	requestIP := func(remoteAddr string, headers map[string]string) string {
+		req := httptest.NewRequest("GET", "/self", nil)
+		req.RemoteAddr = remoteAddr
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		return metad.requestIP(req)
+	}
+	// the headers from the untrusted clients are ignored.
+	Assert(t, "192.168.1.1" == requestIP("192.168.1.1:1234", map[string]string{"X-Forwarded-For": "192.168.1.2"}))
+	Assert(t, "192.168.1.1" == requestIP("192.168.1.1:1234", map[string]string{"X-Real-IP": "192.168.1.2"}))
+	// the nearest untrusted hop is the client, the spoofed hops before it are ignored.
+	Assert(t, "192.168.1.2" == requestIP("10.0.0.5:1234", map[string]string{"X-Forwarded-For": "1.1.1.1, 192.168.1.2"}))
+	Assert(t, "192.168.1.2" == requestIP("10.0.0.5:1234", map[string]string{"X-Forwarded-For": "192.168.1.2, 10.0.1.1"}))
+	Assert(t, "10.0.0.6" == requestIP("10.0.0.5:1234", map[string]string{"X-Forwarded-For": "10.0.0.6, 10.0.1.1"}))
+	Assert(t, "192.168.1.3" == requestIP("10.0.1.1:1234", map[string]string{"X-Real-IP": "192.168.1.3"}))
+	Assert(t, "10.0.1.1" == requestIP("10.0.1.1:1234", nil))
+
+	_, err := metad.applyConfig(&Config{EnableXff: true, TrustedProxies: []string{"10.0.0.0/33"}})
+	Assert(t, err != nil)
+	_, err = metad.applyConfig(&Config{EnableXff: true})
+	Assert(t, err == nil, err)
+	// without trusted proxies, X-Forwarded-For is honored from all clients.
+	Assert(t, "192.168.1.2" == requestIP("192.168.1.1:1234", map[string]string{"X-Forwarded-For": "192.168.1.2"}))
+}
+
+func TestMetadProxyProtocol(t *testing.T) {
+	metad := NewTestMetadWithConfig(&Config{ProxyProtocol: true, TrustedProxies: []string{"127.0.0.1"}})
+	defer metad.Stop()
+
+	req := httptest.NewRequest("PUT", "/v1/data/nodes/1", strings.NewReader(`{"name":"node1"}`))
+	w := httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+	req = httptest.NewRequest("PUT", "/v1/mapping", strings.NewReader(`{"192.168.1.1":{"node":"/nodes/1"}}`))
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+	time.Sleep(sleepTime)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Assert(t, err == nil, err)
+	server := &http.Server{Handler: metad.router}
+	go server.Serve(metad.proxyListener(listener))
+	defer server.Close()
+
+	get := func(header string) string {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		Assert(t, err == nil, err)
+		defer conn.Close()
+		fmt.Fprintf(conn, "%sGET /self/node/name HTTP/1.1\r\nHost: metad\r\nAccept: application/json\r\nConnection: close\r\n\r\n", header)
+		body, _ := ioutil.ReadAll(conn)
+		return string(body)
+	}
+	// the client ip is the source address of the header.
+	resp := get("PROXY TCP4 192.168.1.1 10.0.0.1 56324 80\r\n")
+	Assert(t, strings.HasSuffix(resp, `"node1"`), resp)
+	// the connection without header from the trusted proxy is rejected.
+	resp = get("")
+	Assert(t, strings.HasPrefix(resp, "HTTP/1.1 400") && !strings.Contains(resp, "node1"), resp)
+}
+
+func TestMetadEncryptionKey(t *testing.T) {
+	os.Unsetenv(encryptionKeyEnv)
+	_, err := New(&Config{Backend: "local", EncryptionPrefixes: []string{"/secrets"}})
+	Assert(t, err != nil)
+
+	f, err := ioutil.TempFile("", "metad-key")
+	Assert(t, err == nil, err)
+	defer os.Remove(f.Name())
+	f.WriteString("not base64\n")
+	f.Close()
+	_, err = New(&Config{Backend: "local", EncryptionPrefixes: []string{"/secrets"}, EncryptionKeyFile: f.Name()})
+	Assert(t, err != nil)
+
+	ioutil.WriteFile(f.Name(), []byte(base64.StdEncoding.EncodeToString([]byte("0123456789abcdef"))+"\n"), 0600)
+	metad := NewTestMetadWithConfig(&Config{EncryptionPrefixes: []string{"/secrets"}, EncryptionKeyFile: f.Name()})
+	defer metad.Stop()
+	req := httptest.NewRequest("PUT", "/v1/data/secrets/db", strings.NewReader(`{"password":"pw"}`))
+	w := httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code, w.Body.String())
+	time.Sleep(sleepTime)
+	req = httptest.NewRequest("GET", "/v1/data/secrets/db/password", nil)
+	req.Header.Set("accept", "application/json")
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, `"pw"` == w.Body.String(), w.Body.String())
+}
+
+type lockedBuffer struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.String()
+}
+
+func TestMetadSensitivePrefixes(t *testing.T) {
+	metad := NewTestMetadWithConfig(&Config{SensitivePrefixes: []string{"/secrets"}})
+	defer metad.Stop()
+	defer redact.SetPrefixes(nil)
+	logs := &lockedBuffer{}
+	logger.SetOutput(logs)
+	defer logger.SetOutput(os.Stdout)
+
+	do := func(router *mux.Router, method string, uri string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, uri, strings.NewReader(body))
+		req.Header.Set("accept", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	Assert(t, 200 == do(metad.manageRouter, "PUT", "/v1/data/", `{"secrets":{"db":"pw-secret"},"nodes":{"1":{"name":"node1","db":"pw-secret"}}}`).Code)
+	Assert(t, 200 == do(metad.manageRouter, "PUT", "/v1/mapping", `{"192.0.2.1":{"node":"/nodes/1"}}`).Code)
+	time.Sleep(sleepTime)
+
+	// the values are served, but masked in the logs.
+	w := do(metad.manageRouter, "GET", "/v1/data/secrets", "")
+	Assert(t, `{"db":"pw-secret"}` == w.Body.String(), w.Body.String())
+	req := httptest.NewRequest("GET", "/self/node", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	req.Header.Set("accept", "application/json")
+	w = httptest.NewRecorder()
+	metad.router.ServeHTTP(w, req)
+	Assert(t, strings.Contains(w.Body.String(), "pw-secret"), w.Body.String())
+	w = do(metad.manageRouter, "GET", "/v1/data/nodes/1/name", "")
+	Assert(t, `"node1"` == w.Body.String(), w.Body.String())
+
+	output := logs.String()
+	Assert(t, !strings.Contains(output, "pw-secret"), output)
+	Assert(t, strings.Contains(output, redact.Mask), output)
+	Assert(t, strings.Contains(output, "resp node1"), output)
+
+	// reloadable.
+	_, err := metad.applyConfig(&Config{})
+	Assert(t, err == nil, err)
+	Assert(t, !redact.Enabled())
+}
+
+func TestMetadManageRBAC(t *testing.T) {
+	metad := NewTestMetadWithConfig(&Config{AdminToken: "bootstrap", ManageRBAC: true, RoleBindings: []*metadata.RoleBinding{
+		{Identity: "mapping-ci", Role: metadata.RoleMappingAdmin},
+	}})
+	defer metad.Stop()
+
+	do := func(method string, uri string, body string, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, uri, strings.NewReader(body))
+		req.Header.Set("accept", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		metad.manageRouter.ServeHTTP(w, req)
+		return w
+	}
+	createToken := func(body string) string {
+		w := do("POST", "/v1/token", body, "bootstrap")
+		Assert(t, 200 == w.Code, w.Body.String())
+		return util.GetMapValue(parse(w), "/token")
+	}
+	w := do("POST", "/v1/token", `{"roles":[{"role":"unknown"}]}`, "bootstrap")
+	Assert(t, 400 == w.Code, w.Body.String())
+	reader := createToken(`{"roles":[{"role":"read-only","prefixes":["/apps"]}]}`)
+	writer := createToken(`{"roles":[{"role":"writer","prefixes":["/apps"]}]}`)
+	mappingAdmin := createToken(`{"roles":[{"role":"mapping-admin"}]}`)
+	admin := createToken(`{"admin":true}`)
+	time.Sleep(sleepTime)
+
+	// the request without a role is rejected.
+	w = do("GET", "/v1/data/apps", "", "")
+	Assert(t, 401 == w.Code, w.Body.String())
+
+	// the writer writes the data under its prefixes only.
+	w = do("PUT", "/v1/data/apps/app1", `{"k":"v"}`, writer)
+	Assert(t, 200 == w.Code, w.Body.String())
+	w = do("PUT", "/v1/data/nodes/1", `{"k":"v"}`, writer)
+	Assert(t, 403 == w.Code, w.Body.String())
+	w = do("POST", "/v1/data:move", `{"from":"/apps/app1","to":"/nodes/1"}`, writer)
+	Assert(t, 403 == w.Code, w.Body.String())
+	w = do("PUT", "/v1/mapping", `{"192.168.1.1":{"app":"/apps/app1"}}`, writer)
+	Assert(t, 403 == w.Code, w.Body.String())
+	w = do("PUT", "/v1/rule", `{"192.168.1.1":[{"path":"/apps","mode":1}]}`, writer)
+	Assert(t, 403 == w.Code, w.Body.String())
+	time.Sleep(sleepTime)
+
+	// the read-only role reads the data under its prefixes, but not write.
+	w = do("GET", "/v1/data/apps/app1/k", "", reader)
+	Assert(t, `"v"` == w.Body.String(), w.Body.String())
+	w = do("GET", "/v1/data/nodes", "", reader)
+	Assert(t, 403 == w.Code, w.Body.String())
+	w = do("PUT", "/v1/data/apps/app1", `{"k":"v2"}`, reader)
+	Assert(t, 403 == w.Code, w.Body.String())
+
+	// the mapping-admin writes the mapping, but not the data.
+	w = do("PUT", "/v1/mapping", `{"192.168.1.1":{"app":"/apps/app1"}}`, mappingAdmin)
+	Assert(t, 200 == w.Code, w.Body.String())
+	w = do("PUT", "/v1/data/apps/app1", `{"k":"v2"}`, mappingAdmin)
+	Assert(t, 403 == w.Code, w.Body.String())
+
+	// the admin writes anything.
+	w = do("PUT", "/v1/freeze", `{"reason":"test","ttl":60}`, writer)
+	Assert(t, 403 == w.Code, w.Body.String())
+	w = do("PUT", "/v1/data/nodes/1", `{"k":"v"}`, admin)
+	Assert(t, 200 == w.Code, w.Body.String())
+
+	time.Sleep(sleepTime)
+
+	// the reads outside /v1/data are limited to the prefixes too.
+	w = do("GET", "/v1/export?prefix=/apps", "", reader)
+	Assert(t, 200 == w.Code, w.Body.String())
+	w = do("GET", "/v1/export", "", reader)
+	Assert(t, 403 == w.Code, w.Body.String())
+	w = do("GET", "/v1/export?prefix=/nodes", "", reader)
+	Assert(t, 403 == w.Code, w.Body.String())
+	w = do("GET", "/v1/find?key=k&value=v", "", reader)
+	Assert(t, 403 == w.Code, w.Body.String())
+	w = do("GET", "/v1/find?key=k&value=v&prefix=/nodes", "", reader)
+	Assert(t, 403 == w.Code, w.Body.String())
+	w = do("POST", "/v1/job", `{"type":"export","path":"/nodes"}`, writer)
+	Assert(t, 403 == w.Code, w.Body.String())
+	w = do("POST", "/v1/data:copy", `{"from":"/nodes","to":"/apps/nodes"}`, writer)
+	Assert(t, 403 == w.Code, w.Body.String())
+	w = do("POST", "/v1/job", `{"type":"copy","from":"/nodes","to":"/apps/nodes"}`, writer)
+	Assert(t, 403 == w.Code, w.Body.String())
+	w = do("POST", "/v1/data:copy", `{"from":"/apps/app1","to":"/apps/app2"}`, writer)
+	Assert(t, 200 == w.Code, w.Body.String())
+	w = do("POST", "/v1/job", `{"type":"export","path":"/nodes"}`, admin)
+	Assert(t, 200 == w.Code, w.Body.String())
+	exportJob := util.GetMapValue(parse(w), "/id")
+	w = do("POST", "/v1/job", `{"type":"copy","from":"/nodes","to":"/nodes-copy"}`, admin)
+	Assert(t, 200 == w.Code, w.Body.String())
+	copyJob := util.GetMapValue(parse(w), "/id")
+	time.Sleep(sleepTime)
+	w = do("GET", "/v1/job/"+exportJob+"/result", "", admin)
+	Assert(t, 200 == w.Code, w.Body.String())
+	w = do("GET", "/v1/job/"+exportJob+"/result", "", reader)
+	Assert(t, 403 == w.Code, w.Body.String())
+	w = do("GET", "/v1/job/"+copyJob+"/result", "", reader)
+	Assert(t, 403 == w.Code, w.Body.String())
+	w = do("GET", "/v1/job/"+exportJob, "", reader)
+	Assert(t, 403 == w.Code, w.Body.String())
+	w = do("GET", "/v1/job/"+exportJob+"/log", "", reader)
+	Assert(t, 403 == w.Code, w.Body.String())
+	w = do("GET", "/v1/job", "", reader)
+	Assert(t, 200 == w.Code, w.Body.String())
+	Assert(t, "[]" == w.Body.String(), w.Body.String())
+	w = do("GET", "/v1/job", "", admin)
+	Assert(t, 2 == len(parse(w).([]interface{})), w.Body.String())
+
+	// the roles with prefixes do not read the apis carrying the data of any path.
+	w = do("POST", "/v1/release", `{"name":"r1","changes":[{"path":"/nodes/1/k","action":"put","value":"v2"}]}`, admin)
+	Assert(t, 200 == w.Code, w.Body.String())
+	time.Sleep(sleepTime)
+	w = do("GET", "/v1/release/r1", "", admin)
+	Assert(t, 200 == w.Code, w.Body.String())
+	for _, uri := range []string{"/v1/release", "/v1/release/r1", "/v1/trash", "/v1/trash/t1", "/v1/deadletter", "/v1/deadletter/d1",
+		"/v1/overlay", "/v1/overlay/nodes/1", "/v1/alias", "/v1/alias/nodes/1"} {
+		w = do("GET", uri, "", reader)
+		Assert(t, 403 == w.Code, uri, w.Body.String())
+		w = do("GET", uri, "", writer)
+		Assert(t, 403 == w.Code, uri, w.Body.String())
+		w = do("GET", uri, "", mappingAdmin)
+		Assert(t, 403 != w.Code, uri, w.Body.String())
+	}
+	// the replicate api is admin only.
+	for _, uri := range []string{"/v1/replicate/data", "/v1/replicate/mapping", "/v1/replicate/record/token",
+		"/v1/replicate/record/deadletter", "/v1/replicate/record/release"} {
+		w = do("GET", uri, "", reader)
+		Assert(t, 403 == w.Code, uri, w.Body.String())
+		w = do("GET", uri, "", mappingAdmin)
+		Assert(t, 403 == w.Code, uri, w.Body.String())
+	}
+
+	// the job cancel and the release create and delete are limited to the prefixes too.
+	w = do("DELETE", "/v1/job/"+exportJob, "", writer)
+	Assert(t, 403 == w.Code, w.Body.String())
+	w = do("DELETE", "/v1/job/"+copyJob, "", writer)
+	Assert(t, 403 == w.Code, w.Body.String())
+	w = do("DELETE", "/v1/release/r1", "", writer)
+	Assert(t, 403 == w.Code, w.Body.String())
+	w = do("POST", "/v1/release", `{"name":"r2","changes":[{"path":"/nodes/1/k","action":"put","value":"v2"}]}`, writer)
+	Assert(t, 403 == w.Code, w.Body.String())
+	w = do("POST", "/v1/release", `{"name":"r2","changes":[{"path":"/apps/app1/k","action":"put","value":"v2"}]}`, writer)
+	Assert(t, 200 == w.Code, w.Body.String())
+	time.Sleep(sleepTime)
+	w = do("DELETE", "/v1/release/r2", "", writer)
+	Assert(t, 200 == w.Code, w.Body.String())
+	w = do("DELETE", "/v1/release/r1", "", admin)
+	Assert(t, 200 == w.Code, w.Body.String())
+
+	// the admin_token of config writes the data.
+	w = do("PUT", "/v1/data/nodes/2", `{"k":"v"}`, "bootstrap")
+	Assert(t, 200 == w.Code, w.Body.String())
+
+	// the verified client cert has the role_bindings of its identity.
+	req := httptest.NewRequest("DELETE", "/v1/mapping/192.168.1.1", nil)
+	req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: "mapping-ci"}}}}}
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code, w.Body.String())
+	req = httptest.NewRequest("PUT", "/v1/data/apps/app1", strings.NewReader(`{"k":"v2"}`))
+	req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: "unknown"}}}}}
+	w = httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 403 == w.Code, w.Body.String())
+
+	_, err := metad.applyConfig(&Config{AdminToken: "bootstrap", ManageRBAC: true, RoleBindings: []*metadata.RoleBinding{{Role: metadata.RoleAdmin}}})
+	Assert(t, err != nil)
+}
+
+func TestMetadNamespaces(t *testing.T) {
+	_, err := New(&Config{Backend: "local", Namespaces: []*Namespace{{Name: "team a"}}})
+	Assert(t, err != nil)
+	_, err = New(&Config{Backend: "local", Namespaces: []*Namespace{{Name: "team-a", Hosts: []string{"a.metad"}}, {Name: "team-b", Hosts: []string{"a.metad"}}}})
+	Assert(t, err != nil)
+
+	metad := NewTestMetadWithConfig(&Config{AdminToken: "bootstrap", Namespaces: []*Namespace{
+		{Name: "team-a", Hosts: []string{"a.metad"}},
+		{Name: "team-b", Hosts: []string{"b.metad"}, Quotas: []string{"/nodes=1"}},
+	}})
+	defer metad.Stop()
+
+	do := func(router *mux.Router, method string, uri string, host string, token string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, uri, strings.NewReader(body))
+		req.Header.Set("accept", "application/json")
+		if host != "" {
+			req.Host = host
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	// the namespaces are selected by the Host header.
+	w := do(metad.manageRouter, "PUT", "/v1/data/nodes/1", "a.metad:9611", "", `{"name":"a1"}`)
+	Assert(t, 200 == w.Code, w.Body.String())
+	w = do(metad.manageRouter, "PUT", "/v1/data/nodes/1", "", "", `{"name":"default1"}`)
+	Assert(t, 200 == w.Code, w.Body.String())
+	w = do(metad.manageRouter, "PUT", "/v1/data/nodes", "b.metad", "", `{"1":{"name":"b1"},"2":{"name":"b2"}}`)
+	Assert(t, 413 == w.Code, w.Body.String())
+	w = do(metad.manageRouter, "PUT", "/v1/mapping", "a.metad", "", `{"192.0.2.1":{"node":"/nodes/1"}}`)
+	Assert(t, 200 == w.Code, w.Body.String())
+	time.Sleep(sleepTime)
+
+	w = do(metad.manageRouter, "GET", "/v1/data/nodes/1/name", "a.metad", "", "")
+	Assert(t, `"a1"` == w.Body.String(), w.Body.String())
+	w = do(metad.manageRouter, "GET", "/v1/data/nodes/1/name", "", "", "")
+	Assert(t, `"default1"` == w.Body.String(), w.Body.String())
+	w = do(metad.manageRouter, "GET", "/v1/data/nodes", "b.metad", "", "")
+	Assert(t, 404 == w.Code, w.Body.String())
+	w = do(metad.router, "GET", "/self/node/name", "a.metad", "", "")
+	Assert(t, `"a1"` == w.Body.String(), w.Body.String())
+	w = do(metad.router, "GET", "/self/node/name", "", "", "")
+	Assert(t, 404 == w.Code, w.Body.String())
+
+	// the token of the namespace select it.
+	w = do(metad.manageRouter, "POST", "/v1/token", "a.metad", "bootstrap", `{"host":"192.0.2.1"}`)
+	Assert(t, 200 == w.Code, w.Body.String())
+	token := util.GetMapValue(parse(w), "/token")
+	time.Sleep(sleepTime)
+	w = do(metad.router, "GET", "/self/node/name", "", token, "")
+	Assert(t, `"a1"` == w.Body.String(), w.Body.String())
+
+	w = do(metad.manageRouter, "GET", "/v1/namespace", "", "", "")
+	Assert(t, 200 == w.Code, w.Body.String())
+	Assert(t, "team-a@"+metad.getConfig().Group == util.GetMapValue(parse(w), "/0/group"), w.Body.String())
+	Assert(t, "/_metad/namespace/team-b" == util.GetMapValue(parse(w), "/1/prefix"), w.Body.String())
+}
+
+func TestMetadMemoryStats(t *testing.T) {
+	metad := NewTestMetad()
+	defer metad.Stop()
+
+	get := func(uri string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", uri, nil)
+		req.Header.Set("accept", "application/json")
+		w := httptest.NewRecorder()
+		metad.manageRouter.ServeHTTP(w, req)
+		return w
+	}
+	req := httptest.NewRequest("PUT", "/v1/data/", strings.NewReader(`{"nodes":{"1":{"name":"node1","cert":"-----BEGIN CERTIFICATE-----"},"2":{"name":"node2"}},"clusters":{"c1":"cluster1"}}`))
+	w := httptest.NewRecorder()
+	metad.manageRouter.ServeHTTP(w, req)
+	Assert(t, 200 == w.Code)
+	time.Sleep(sleepTime)
+
+	w = get("/v1/stats/memory")
+	Assert(t, 200 == w.Code, w.Body.String())
+	Assert(t, "/" == util.GetMapValue(parse(w), "/prefix"), w.Body.String())
+	Assert(t, "/nodes" == util.GetMapValue(parse(w), "/top/0/prefix"), w.Body.String())
+	Assert(t, "/clusters" == util.GetMapValue(parse(w), "/top/1/prefix"), w.Body.String())
+
+	w = get("/v1/stats/memory?prefix=/nodes&limit=1")
+	Assert(t, 200 == w.Code, w.Body.String())
+	Assert(t, "/nodes/1" == util.GetMapValue(parse(w), "/top/0/prefix"), w.Body.String())
+	Assert(t, "" == util.GetMapValue(parse(w), "/top/1/prefix"), w.Body.String())
+
+	Assert(t, 404 == get("/v1/stats/memory?prefix=/users").Code)
+	Assert(t, 400 == get("/v1/stats/memory?depth=-1").Code)
+	_, err := New(&Config{Backend: "local", MemoryReportTop: -1})
+	Assert(t, err != nil)
+}
+
+func TestMetadJSONStream(t *testing.T) {
+	type item struct {
+		Name  string            `json:"name"`
+		Links map[string]string `json:"links"`
+	}
+	values := []interface{}{
+		nil,
+		"<a href=\"x\">&amp;</a>\\\n\r\t\x01\u2028\u2029\xff汉字",
+		true,
+		int64(-42),
+		3.14,
+		1e-7,
+		1e21,
+		float64(100),
+		map[string]interface{}{},
+		[]interface{}{},
+		map[string]interface{}(nil),
+		map[string]interface{}{
+			"nodes": map[string]interface{}{
+				"2": map[string]interface{}{"ip": "192.168.1.2", "tags": []interface{}{"a", int64(1), nil, false}},
+				"1": map[string]interface{}{"ip": "192.168.1.1", "empty": map[string]interface{}{}},
+			},
+			"env":   map[string]string{"b": "2", "a": "1"},
+			"items": []interface{}{&item{Name: "i1", Links: map[string]string{"y": "2", "x": "1"}}, []string{"s1", "s2"}},
+		},
+	}
+	for _, v := range values {
+		for _, pretty := range []bool{false, true} {
+			var expect []byte
+			var err error
+			if pretty {
+				expect, err = json.MarshalIndent(v, "", "  ")
+			} else {
+				expect, err = json.Marshal(v)
+			}
+			Assert(t, err == nil)
+			var buf bytes.Buffer
+			n, _, err := writeJSON(&buf, v, pretty)
+			Assert(t, err == nil, err)
+			Assert(t, n == buf.Len())
+			Assert(t, string(expect) == buf.String(), string(expect), buf.String())
+		}
+	}
+
+	dir := &orderedDir{names: []string{"10", "9"}, values: map[string]interface{}{"9": "v9", "10": map[string]interface{}{"a": "b"}}}
+	expect, _ := dir.MarshalJSON()
+	var buf bytes.Buffer
+	writeJSON(&buf, dir, false)
+	Assert(t, string(expect) == buf.String(), buf.String())
+
+	// the error before anything written can still be responded.
+	buf.Reset()
+	n, written, err := writeJSON(&buf, map[string]interface{}{"nan": math.NaN()}, false)
+	Assert(t, err != nil && !written && n == 0 && buf.Len() == 0)
+
+	tree := map[string]interface{}{}
+	for i := 0; i < 100; i++ {
+		tree[strconv.Itoa(i)] = map[string]interface{}{"ip": "192.168.1." + strconv.Itoa(i), "name": "node" + strconv.Itoa(i)}
+	}
+	if raceEnabled {
+		return
+	}
+	writeJSON(ioutil.Discard, tree, false)
+	allocs := testing.AllocsPerRun(100, func() {
+		writeJSON(ioutil.Discard, tree, false)
+	})
+	Assert(t, allocs == 0, allocs)
+}
+
+// newBenchMetad return the metad with 1000 nodes, the client of bench.Target (192.0.2.1) is mapped to /nodes/1.
+func newBenchMetad(b *testing.B) *Metad {
+	metad := NewTestMetad()
+	nodes := map[string]interface{}{}
+	for i := 0; i < 1000; i++ {
+		nodes[strconv.Itoa(i)] = map[string]interface{}{"ip": fmt.Sprintf("192.168.%d.%d", i/256, i%256), "name": fmt.Sprintf("node%d", i)}
+	}
+	data, _ := json.Marshal(map[string]interface{}{"nodes": nodes})
+	for uri, body := range map[string]string{"/v1/data/": string(data), "/v1/mapping/": `{"192.0.2.1":{"node":"/nodes/1"}}`} {
+		req := httptest.NewRequest("PUT", uri, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		metad.manageRouter.ServeHTTP(w, req)
+		if w.Code != 200 {
+			b.Fatal(uri, w.Body.String())
+		}
+	}
+	time.Sleep(sleepTime)
+	return metad
+}
+
+func BenchmarkMetadSelfGet(b *testing.B) {
+	metad := newBenchMetad(b)
+	defer metad.Stop()
+	target := &bench.Target{Handler: metad.router}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if status, _, _ := target.Get(context.Background(), "/self"); status != 200 {
+				b.Fatal("self status", status)
+			}
+		}
+	})
+	// the timer should not count the stop of metad.
+	b.StopTimer()
+}
+
+func BenchmarkMetadPut(b *testing.B) {
+	metad := newBenchMetad(b)
+	defer metad.Stop()
+	target := &bench.Target{Handler: metad.manageRouter}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		body := fmt.Sprintf(`"192.168.1.%d"`, i%256)
+		if status, _, _ := target.Do(context.Background(), "PUT", fmt.Sprintf("/v1/data/nodes/%d/ip", i%1000), strings.NewReader(body)); status != 200 {
+			b.Fatal("put status", status)
+		}
+	}
+	b.StopTimer()
+}
+
+// BenchmarkMetadLongPoll10k long-poll /self by 10k concurrent clients, every op is a change notifying all of them,
+// p50-ns and p99-ns are the latencies from the change to the long-polls returned.
+func BenchmarkMetadLongPoll10k(b *testing.B) {
+	metad := newBenchMetad(b)
+	defer metad.Stop()
+	target := &bench.Target{Handler: metad.router}
+	manage := &bench.Target{Handler: metad.manageRouter}
+	b.ResetTimer()
+	r, err := bench.LongPoll("MetadLongPoll10k", &bench.LongPollOptions{
+		Target:  target,
+		Path:    "/self/node",
+		Clients: 10000,
+		Rounds:  b.N,
+		Change: func(round int) error {
+			body := fmt.Sprintf(`"192.168.0.%d"`, round%256)
+			if status, _, err := manage.Do(context.Background(), "PUT", "/v1/data/nodes/1/ip", strings.NewReader(body)); err != nil || status != 200 {
+				return fmt.Errorf("put status %d: %v", status, err)
+			}
+			return nil
+		},
+	})
+	b.StopTimer()
+	if err != nil {
+		b.Fatal(err)
+	}
+	if r.Errors > 0 {
+		b.Fatal(r.String())
+	}
+	b.ReportMetric(float64(r.Percentile(0.5).Nanoseconds()), "p50-ns")
+	b.ReportMetric(float64(r.Percentile(0.99).Nanoseconds()), "p99-ns")
+}
+
+func TestMetadSelfProfile(t *testing.T) {
+	profiles := []*SelfProfile{{Name: "network-only", Keys: []string{"host/ip", "/network"}}}
+	metad := NewTestMetadWithConfig(&Config{ResponseCacheSize: 10, SelfProfiles: profiles})
+	defer metad.Stop()
+
+	err := metad.metadataRepo.PutData("/", map[string]interface{}{
+		"nodes":    map[string]interface{}{"1": map[string]interface{}{"ip": "192.0.2.1", "name": "a"}},
+		"networks": map[string]interface{}{"vpc-1": map[string]interface{}{"cidr": "192.0.2.0/24", "gateway": "192.0.2.254"}},
+	}, true)
+	Assert(t, nil == err)
+	err = metad.metadataRepo.PutMapping("/192.0.2.1", map[string]interface{}{"host": "/nodes/1", "network": "/networks/vpc-1", "cluster": "/nothing"}, true)
+	Assert(t, nil == err)
+	time.Sleep(sleepTime)
+
+	get := func(uri string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", uri, nil)
+		req.Header.Set("accept", "application/json")
+		w := httptest.NewRecorder()
+		metad.router.ServeHTTP(w, req)
+		return w
+	}
+	w := get("/self?profile=network-only")
+	Assert(t, 200 == w.Code, w.Body.String())
+	Assert(t, `{"host":{"ip":"192.0.2.1"},"network":{"cidr":"192.0.2.0/24","gateway":"192.0.2.254"}}` == w.Body.String(), w.Body.String())
+	w = get("/self/host?profile=network-only")
+	Assert(t, `{"ip":"192.0.2.1"}` == w.Body.String(), w.Body.String())
+	w = get("/self/network/cidr?profile=network-only")
+	Assert(t, `"192.0.2.0/24"` == w.Body.String(), w.Body.String())
+	w = get("/self/host/name?profile=network-only")
+	Assert(t, 404 == w.Code, w.Body.String())
+	w = get("/self")
+	Assert(t, strings.Contains(w.Body.String(), `"name":"a"`), w.Body.String())
+	w = get("/self?profile=nothing")
+	Assert(t, 400 == w.Code, w.Body.String())
+
+	// the events out of the profile are dropped.
+	version := w.Header().Get("X-Metad-Version")
+	time.AfterFunc(sleepTime, func() {
+		metad.metadataRepo.PutData("/nodes/1/name", "b", true)
+		metad.metadataRepo.PutData("/nodes/1/ip", "192.0.2.2", true)
+	})
+	w = get("/self?profile=network-only&wait=true&with_events=true&prev_version=" + version)
+	Assert(t, 200 == w.Code, w.Body.String())
+	Assert(t, !strings.Contains(w.Body.String(), `/host/name`), w.Body.String())
+
+	// the reloaded profiles are not served from the cached responses.
+	time.Sleep(sleepTime)
+	w = get("/self?profile=network-only")
+	Assert(t, `{"host":{"ip":"192.0.2.2"},"network":{"cidr":"192.0.2.0/24","gateway":"192.0.2.254"}}` == w.Body.String(), w.Body.String())
+	config := *metad.getConfig()
+	config.SelfProfiles = []*SelfProfile{{Name: "network-only", Keys: []string{"network/cidr"}}}
+	_, err = metad.applyConfig(&config)
+	Assert(t, nil == err)
+	w = get("/self?profile=network-only")
+	Assert(t, `{"network":{"cidr":"192.0.2.0/24"}}` == w.Body.String(), w.Body.String())
+
+	for _, invalid := range [][]*SelfProfile{{{Keys: []string{"host"}}}, {{Name: "a"}}, {{Name: "a", Keys: []string{"/"}}},
+		{{Name: "a", Keys: []string{"host"}}, {Name: "a", Keys: []string{"network"}}}} {
+		config.SelfProfiles = invalid
+		_, err = metad.applyConfig(&config)
+		Assert(t, err != nil)
+	}
+}
+
+func TestMetadComputedKeys(t *testing.T) {
+	for _, invalid := range []string{"/stats", "/=count(/nodes)", "/stats=avg(/nodes)", "/stats=count(nodes)", "/stats=count(/nodes",
+		"/stats=sum(/nodes, \",\")", "/stats=count(/nodes/[)"} {
+		_, err := configComputedKeys(&Config{ComputedKeys: []string{invalid}}, nil)
+		Assert(t, nil != err, invalid)
+	}
+	_, err := configComputedKeys(&Config{ComputedKeys: []string{"/stats=count(/nodes)", "/stats/a=count(/nodes)"}}, nil)
+	Assert(t, nil != err)
+
+	metad := NewTestMetadWithConfig(&Config{ComputedKeys: []string{
+		"/stats/node_count=count(/nodes)",
+		"/stats/node_names=keys(/nodes, \" \")",
+		"/stats/node_ips=join(/nodes/*/ip)",
+		"/stats/cpu=sum(/nodes/*/cpu)",
+	}})
+	defer metad.Stop()
+	time.Sleep(sleepTime)
+	Assert(t, "0" == metad.metadataRepo.GetData("/stats/node_count"))
+
+	err = metad.metadataRepo.PutData("/nodes", map[string]interface{}{
+		"10": map[string]interface{}{"ip": "192.0.2.10", "cpu": "2"},
+		"9":  map[string]interface{}{"ip": "192.0.2.9", "cpu": "1.5"},
+		"a":  map[string]interface{}{"cpu": "x"},
+	}, true)
+	Assert(t, nil == err)
+	time.Sleep(sleepTime)
+	// the keys are recomputed after the changes synced and collected.
+	time.Sleep(5 * sleepTime)
+	stats := metad.metadataRepo.GetData("/stats")
+	Assert(t, reflect.DeepEqual(map[string]interface{}{"node_count": "3", "node_names": "9 10 a", "node_ips": "192.0.2.9,192.0.2.10", "cpu": "3.5"}, stats), stats)
+
+	// the recomputed key is watched like other data.
+	ch := make(chan interface{})
+	go func() {
+		ch <- metad.metadataRepo.Watch(context.Background(), "192.0.2.1", "/stats/node_count")
+	}()
+	time.Sleep(sleepTime)
+	Assert(t, nil == metad.metadataRepo.DeleteData("/nodes/a"))
+	result := <-ch
+	time.Sleep(5 * sleepTime)
+	Assert(t, "192.0.2.9,192.0.2.10" == metad.metadataRepo.GetData("/stats/node_ips"))
+	Assert(t, "UPDATE|2" == result, result)
+
+	// the computed keys are read only.
+	Assert(t, nil != metad.metadataRepo.PutData("/stats/node_count", "100", true))
+}
+
+func TestMetadAlias(t *testing.T) {
+	metad := NewTestMetad()
+	defer metad.Stop()
+
+	do := func(method string, uri string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, uri, strings.NewReader(body))
+		req.Header.Set("accept", "application/json")
+		w := httptest.NewRecorder()
+		metad.manageRouter.ServeHTTP(w, req)
+		return w
+	}
+	read := func(uri string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", uri, nil)
+		req.Header.Set("accept", "application/json")
+		req.RemoteAddr = "192.168.1.1:1234"
+		w := httptest.NewRecorder()
+		metad.router.ServeHTTP(w, req)
+		return w
+	}
+	err := metad.metadataRepo.PutData("/clusters", map[string]interface{}{"cl-1": map[string]interface{}{"name": "db"}}, true)
+	Assert(t, nil == err)
+	w := do("PUT", "/v1/rule/", `{"192.168.1.1":[{"path":"/","mode":1}]}`)
+	Assert(t, 200 == w.Code)
+	time.Sleep(sleepTime)
+	Assert(t, 404 == read("/zones/z1/db").Code)
+
+	w = do("PUT", "/v1/alias/zones/z1/db", `{"target": "/clusters/cl-1"}`)
+	Assert(t, 200 == w.Code, w.Body.String())
+	w = do("PUT", "/v1/alias/clusters/cl-1/zone", `{"target": "/zones"}`)
+	Assert(t, 400 == w.Code)
+	time.Sleep(sleepTime)
+
+	// the cached response is invalidated by the alias change.
+	w = read("/zones/z1/db")
+	Assert(t, 200 == w.Code)
+	Assert(t, "db" == util.GetMapValue(parse(w), "/name"), w.Body.String())
+	w = do("GET", "/v1/data/zones", "")
+	Assert(t, 200 == w.Code)
+	Assert(t, "db" == util.GetMapValue(parse(w), "/z1/db/name"), w.Body.String())
+	w = do("GET", "/v1/alias", "")
+	Assert(t, 200 == w.Code)
+	Assert(t, "/clusters/cl-1" == util.GetMapValue(parse(w), "/0/target"), w.Body.String())
+
+	w = do("DELETE", "/v1/alias/zones/z1/db", "")
+	Assert(t, 200 == w.Code)
+	w = do("DELETE", "/v1/alias/zones/z1/db", "")
+	Assert(t, 404 == w.Code)
+	time.Sleep(sleepTime)
+	Assert(t, 404 == read("/zones/z1/db").Code)
+}
+
+func TestMetadExpandRefs(t *testing.T) {
+	metad := NewTestMetad()
+	defer metad.Stop()
+
+	read := func(router http.Handler, uri string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", uri, nil)
+		req.Header.Set("accept", "application/json")
+		req.RemoteAddr = "192.168.1.1:1234"
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	err := metad.metadataRepo.PutData("/", map[string]interface{}{
+		"common":  map[string]interface{}{"dns": map[string]interface{}{"nameserver": "10.0.0.2"}},
+		"secrets": map[string]interface{}{"key": "s3cr3t"},
+		"nodes":   map[string]interface{}{"1": map[string]interface{}{"dns": "$ref:/common/dns", "key": "$ref:/secrets/key"}},
+	}, true)
+	Assert(t, nil == err)
+	err = metad.metadataRepo.PutAccessRule(map[string][]store.AccessRule{
+		"192.168.1.1": {{Path: "/", Mode: store.AccessModeRead}, {Path: "/secrets", Mode: store.AccessModeForbidden}},
+	})
+	Assert(t, nil == err)
+	err = metad.metadataRepo.PutMapping("/192.168.1.1", map[string]interface{}{"node": "/nodes/1"}, true)
+	Assert(t, nil == err)
+	time.Sleep(sleepTime)
+
+	w := read(metad.router, "/nodes/1")
+	Assert(t, "$ref:/common/dns" == util.GetMapValue(parse(w), "/dns"), w.Body.String())
+	// the reference is read as the client.
+	w = read(metad.router, "/nodes/1?expand_refs=true")
+	Assert(t, 200 == w.Code)
+	Assert(t, "10.0.0.2" == util.GetMapValue(parse(w), "/dns/nameserver"), w.Body.String())
+	Assert(t, "$ref:/secrets/key" == util.GetMapValue(parse(w), "/key"), w.Body.String())
+	w = read(metad.router, "/self/node/dns/nameserver?expand_refs=true")
+	Assert(t, 404 == w.Code)
+	w = read(metad.router, "/self/node/dns?expand_refs=true")
+	Assert(t, "10.0.0.2" == util.GetMapValue(parse(w), "/nameserver"), w.Body.String())
+	w = read(metad.manageRouter, "/v1/data/nodes/1?expand_refs=true")
+	Assert(t, "s3cr3t" == util.GetMapValue(parse(w), "/key"), w.Body.String())
+}
+
+func TestMetadPutMode(t *testing.T) {
+	metad := NewTestMetad()
+	defer metad.Stop()
+
+	do := func(method string, uri string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, uri, strings.NewReader(body))
+		req.Header.Set("accept", "application/json")
+		w := httptest.NewRecorder()
+		metad.manageRouter.ServeHTTP(w, req)
+		return w
+	}
+	w := do("POST", "/v1/data/clusters/cl-1", `{"name": "db", "size": "3", "env": {"a": "1", "b": "2"}}`)
+	Assert(t, 200 == w.Code)
+
+	// PUT merge by default.
+	w = do("PUT", "/v1/data/clusters/cl-1", `{"size": "5"}`)
+	Assert(t, 200 == w.Code)
+	time.Sleep(sleepTime)
+	Assert(t, "db" == metad.metadataRepo.GetData("/clusters/cl-1/name"))
+	Assert(t, "5" == metad.metadataRepo.GetData("/clusters/cl-1/size"))
+
+	// the keys absent from the payload are deleted by replace, and kept by merge.
+	w = do("PUT", "/v1/data/clusters/cl-1?mode=replace", `{"name": "db", "env": {"a": "1"}}`)
+	Assert(t, 200 == w.Code)
+	time.Sleep(sleepTime)
+	Assert(t, reflect.DeepEqual(map[string]interface{}{"name": "db", "env": map[string]interface{}{"a": "1"}}, metad.metadataRepo.GetData("/clusters/cl-1")))
+	w = do("POST", "/v1/data/clusters/cl-1?mode=merge", `{"env": {"b": "2"}}`)
+	Assert(t, 200 == w.Code)
+	time.Sleep(sleepTime)
+	Assert(t, reflect.DeepEqual(map[string]interface{}{"name": "db", "env": map[string]interface{}{"a": "1", "b": "2"}}, metad.metadataRepo.GetData("/clusters/cl-1")))
+
+	w = do("PUT", "/v1/data/clusters/cl-1?mode=append", `{"size": "1"}`)
+	Assert(t, 400 == w.Code)
+	Assert(t, nil == metad.metadataRepo.GetData("/clusters/cl-1/size"))
+}
+
+func TestMetadIfMatch(t *testing.T) {
+	metad := NewTestMetad()
+	defer metad.Stop()
+
+	do := func(method string, uri string, ifMatch string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, uri, strings.NewReader(body))
+		req.Header.Set("accept", "application/json")
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		w := httptest.NewRecorder()
+		metad.manageRouter.ServeHTTP(w, req)
+		return w
+	}
+	// the path not exist match revision 0 only.
+	w := do("PUT", "/v1/data/clusters/cl-1", "*", `{"size": "3"}`)
+	Assert(t, 412 == w.Code)
+	Assert(t, `"0"` == w.Header().Get("ETag"))
+	w = do("PUT", "/v1/data/clusters/cl-1", `"0"`, `{"size": "3"}`)
+	Assert(t, 200 == w.Code, w.Body.String())
+	// the succeeded write respond the new revision after synced.
+	etag := w.Header().Get("ETag")
+	Assert(t, "" != etag && `"0"` != etag, etag)
+	w = do("GET", "/v1/data/clusters/cl-1", "", "")
+	Assert(t, etag == w.Header().Get("ETag"), w.Header().Get("ETag"))
+	Assert(t, "3" == util.GetMapValue(parse(w), "/size"))
+
+	// the write with the stale revision fail.
+	w = do("PUT", "/v1/data/clusters/cl-1", etag, `{"size": "5"}`)
+	Assert(t, 200 == w.Code)
+	w = do("PATCH", "/v1/data/clusters/cl-1", etag, `{"size": "4"}`)
+	Assert(t, 412 == w.Code, w.Body.String())
+	Assert(t, etag != w.Header().Get("ETag"))
+	Assert(t, "5" == metad.metadataRepo.GetData("/clusters/cl-1/size"))
+	w = do("DELETE", "/v1/data/clusters/cl-1", "W/"+etag, "")
+	Assert(t, 412 == w.Code)
+
+	// the write changing nothing respond at once.
+	w = do("GET", "/v1/data/clusters/cl-1", "", "")
+	etag = w.Header().Get("ETag")
+	start := time.Now()
+	w = do("PUT", "/v1/data/clusters/cl-1", etag, `{"size": "5"}`)
+	Assert(t, 200 == w.Code, w.Body.String())
+	Assert(t, etag == w.Header().Get("ETag"), w.Header().Get("ETag"))
+	Assert(t, time.Since(start) < conditionalSyncTimeout/2, time.Since(start))
+
+	// the conditional write check after the plain write synced back.
+	w = do("PUT", "/v1/data/clusters/cl-1/size", "", `"6"`)
+	Assert(t, 200 == w.Code, w.Body.String())
+	w = do("PUT", "/v1/data/clusters/cl-1", etag, `{"size": "7"}`)
+	Assert(t, 412 == w.Code, w.Body.String())
+	Assert(t, "6" == metad.metadataRepo.GetData("/clusters/cl-1/size"))
+
+	// the change under the path change its revision.
+	revision := metad.metadataRepo.DataRevision("/clusters/cl-1")
+	Assert(t, nil == metad.metadataRepo.PutData("/clusters/cl-1/name", "db", true))
+	time.Sleep(sleepTime)
+	Assert(t, revision < metad.metadataRepo.DataRevision("/clusters/cl-1"))
+	w = do("DELETE", "/v1/data/clusters/cl-1", fmt.Sprintf(`"%d", "%d"`, revision, metad.metadataRepo.DataRevision("/clusters/cl-1")), "")
+	Assert(t, 200 == w.Code)
+	Assert(t, nil == metad.metadataRepo.GetData("/clusters/cl-1"))
+
+	// the failed write is not waited by the conditional write.
+	w = do("PUT", "/v1/data/clusters/cl-2", "", `{"size":`)
+	Assert(t, 400 == w.Code)
+	metad.unsyncedWritesLock.Lock()
+	_, recorded := metad.unsyncedWrites["/clusters/cl-2"]
+	metad.unsyncedWritesLock.Unlock()
+	Assert(t, !recorded)
+
+	// the conditional write wait for the overlapping unsynced write without stalling the other writes, the mounted
+	// data is never same as the backend, so its write is never synced.
+	metad.metadataRepo.MountData("/mounted")
+	metad.metadataRepo.PutMountedData("/mounted", map[string]interface{}{"a": "1"})
+	metad.addUnsyncedWrite("/mounted/a")
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- do("PUT", "/v1/data", `"0"`, `{"size": "1"}`)
+	}()
+	time.Sleep(sleepTime)
+	start = time.Now()
+	w = do("PUT", "/v1/data/clusters/cl-3", "", `{"size": "1"}`)
+	Assert(t, 200 == w.Code, w.Body.String())
+	Assert(t, time.Since(start) < conditionalSyncTimeout/2, time.Since(start))
+	w = <-done
+	Assert(t, 412 == w.Code, w.Body.String())
+}
+
+func NewTestMetad() *Metad {
+	return NewTestMetadWithConfig(&Config{})
+}
+
+func NewTestMetadWithConfig(config *Config) *Metad {
+	config.Backend = testBackend
+	config.Group = fmt.Sprintf("/group%v", rand.Intn(10000))
 	metad, err := New(config)
 	if err != nil {
 		panic(err)
@@ -0,0 +1,218 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metad
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"openpitrix.io/metad/pkg/logger"
+)
+
+// namespacePrefix is the default backend prefix of the namespaces' data, it is under the meta path so not synced
+// as the data of the default namespace.
+const namespacePrefix = "/_metad/namespace"
+
+var namespaceNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// Namespace is an isolated tenant sharing the metad deployment, it has its own data, mapping, access rules, records
+// and quotas. The requests are served by the namespace if they are from its listeners, with its token, or with one of
+// its Hosts as the Host header, otherwise by the default namespace.
+type Namespace struct {
+	Name string `yaml:"name"`
+	// Prefix is the backend prefix of the namespace's data, default /_metad/namespace/$name.
+	Prefix       string   `yaml:"prefix"`
+	Listen       string   `yaml:"listen"`
+	ListenManage string   `yaml:"listen_manage"`
+	Hosts        []string `yaml:"hosts,omitempty"`
+	Quotas       []string `yaml:"quotas,omitempty"`
+}
+
+type namespace struct {
+	config *Namespace
+	metad  *Metad
+}
+
+func checkNamespaces(config *Config) error {
+	names := map[string]bool{}
+	hosts := map[string]string{}
+	listens := map[string]string{config.Listen: "", config.ListenManage: ""}
+	for _, ns := range config.Namespaces {
+		if ns == nil || !namespaceNameRegexp.MatchString(ns.Name) {
+			return fmt.Errorf("invalid namespace name, should be letters, digits, '_' or '-'.")
+		}
+		if names[ns.Name] {
+			return fmt.Errorf("duplicate namespace [%s].", ns.Name)
+		}
+		names[ns.Name] = true
+		if ns.Prefix != "" && path.Join("/", ns.Prefix) == "/" {
+			return fmt.Errorf("prefix of namespace [%s] should not be /.", ns.Name)
+		}
+		for _, host := range ns.Hosts {
+			host = strings.ToLower(host)
+			if other, ok := hosts[host]; ok {
+				return fmt.Errorf("host [%s] of namespace [%s] is also of namespace [%s].", host, ns.Name, other)
+			}
+			hosts[host] = ns.Name
+		}
+		for _, listen := range []string{ns.Listen, ns.ListenManage} {
+			if listen == "" {
+				continue
+			}
+			if _, ok := listens[listen]; ok {
+				return fmt.Errorf("listen address [%s] of namespace [%s] is already used.", listen, ns.Name)
+			}
+			listens[listen] = ns.Name
+		}
+	}
+	return nil
+}
+
+// namespaceConfig return the config of the namespace, inherit the config of the default namespace except the backend
+// prefix, group, listeners and quotas. The local files (pid, cache, change log and audit file), the audit SIEM, the
+// renders, the http sources and the computed keys are only of the default namespace.
+func namespaceConfig(config *Config, ns *Namespace) *Config {
+	c := *config
+	c.Namespaces = nil
+	c.Prefix = ns.Prefix
+	if c.Prefix == "" {
+		c.Prefix = path.Join(namespacePrefix, ns.Name)
+	}
+	c.Group = ns.Name + "@" + config.Group
+	c.Listen = ns.Listen
+	c.ListenManage = ns.ListenManage
+	c.ListenUnix = ""
+	c.Quotas = ns.Quotas
+	c.PIDFile = ""
+	c.CacheFile = ""
+	c.ChangeLog = ""
+	c.AuditFile = ""
+	c.AuditSIEM = ""
+	c.Renders = nil
+	c.HTTPSources = nil
+	c.ComputedKeys = nil
+	return &c
+}
+
+func newNamespaces(config *Config) ([]*namespace, error) {
+	if err := checkNamespaces(config); err != nil {
+		return nil, err
+	}
+	var namespaces []*namespace
+	for _, ns := range config.Namespaces {
+		metad, err := New(namespaceConfig(config, ns))
+		if err != nil {
+			return nil, fmt.Errorf("init namespace [%s] error: %s", ns.Name, err.Error())
+		}
+		namespaces = append(namespaces, &namespace{config: ns, metad: metad})
+	}
+	return namespaces, nil
+}
+
+// initNamespaces start the namespaces, and dispatch the requests of the namespaces from the routers.
+func (m *Metad) initNamespaces() {
+	if len(m.namespaces) == 0 {
+		return
+	}
+	for _, ns := range m.namespaces {
+		ns.metad.Init()
+	}
+	m.router.Use(m.namespaceMiddleware(func(metad *Metad) http.Handler { return metad.router }))
+	m.manageRouter.Use(m.namespaceMiddleware(func(metad *Metad) http.Handler { return metad.manageRouter }))
+}
+
+// serveNamespaces serve the namespaces' own listeners.
+func (m *Metad) serveNamespaces() {
+	for _, ns := range m.namespaces {
+		if ns.config.ListenManage != "" {
+			ns.metad.watchManage()
+		}
+		if ns.config.Listen != "" {
+			go func(ns *namespace) {
+				if err := ns.metad.serveMetadata(); err != http.ErrServerClosed {
+					logger.Error("Namespace [%s] listener error: %v", ns.config.Name, err)
+				}
+			}(ns)
+		}
+	}
+}
+
+func (m *Metad) stopNamespaces() {
+	for _, ns := range m.namespaces {
+		ns.metad.Stop()
+	}
+}
+
+// applyNamespaces apply the reloaded config of the default namespace to the namespaces.
+func (m *Metad) applyNamespaces(config *Config) {
+	for _, ns := range m.namespaces {
+		if _, err := ns.metad.applyConfig(namespaceConfig(config, ns.config)); err != nil {
+			logger.Error("Apply config to namespace [%s] error: %s", ns.config.Name, err.Error())
+		}
+	}
+}
+
+func (m *Metad) namespaceMiddleware(handler func(metad *Metad) http.Handler) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if ns := m.requestNamespace(req); ns != nil {
+				handler(ns.metad).ServeHTTP(w, req)
+				return
+			}
+			next.ServeHTTP(w, req)
+		})
+	}
+}
+
+// requestNamespace return the namespace of the request by the token, or by the Host header if no token, nil means
+// the default namespace.
+func (m *Metad) requestNamespace(req *http.Request) *namespace {
+	if secret := bearerToken(req); secret != "" {
+		if m.metadataRepo.GetToken(secret) != nil {
+			return nil
+		}
+		for _, ns := range m.namespaces {
+			if ns.metad.metadataRepo.GetToken(secret) != nil {
+				return ns
+			}
+		}
+	}
+	host := req.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	for _, ns := range m.namespaces {
+		for _, h := range ns.config.Hosts {
+			if strings.EqualFold(h, host) {
+				return ns
+			}
+		}
+	}
+	return nil
+}
+
+func (m *Metad) namespaceList(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	result := []map[string]interface{}{}
+	for _, ns := range m.namespaces {
+		config := ns.metad.getConfig()
+		result = append(result, map[string]interface{}{
+			"name":          ns.config.Name,
+			"prefix":        path.Join("/", config.Prefix),
+			"group":         config.Group,
+			"hosts":         ns.config.Hosts,
+			"listen":        ns.config.Listen,
+			"listen_manage": ns.config.ListenManage,
+			"data_version":  ns.metad.metadataRepo.DataVersion(),
+		})
+	}
+	return result, nil
+}
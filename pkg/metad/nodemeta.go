@@ -0,0 +1,37 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metad
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+func checkNodeHistory(config *Config) error {
+	if config.NodeHistory < 0 {
+		return errors.New("node_history should not be negative.")
+	}
+	return nil
+}
+
+// isMetaRequest check whether the data read request the change metadata view.
+func isMetaRequest(req *http.Request) bool {
+	return strings.ToLower(req.FormValue("meta")) == "true"
+}
+
+// dataMeta respond the change metadata of the data node and its children, the projections are not supported.
+func (m *Metad) dataMeta(nodePath string, req *http.Request) (interface{}, *HttpError) {
+	for _, param := range []string{"depth", "flatten", "filter", "limit", "sort", "at"} {
+		if req.FormValue(param) != "" {
+			return nil, NewHttpError(http.StatusBadRequest, "meta can not be used with "+param+".")
+		}
+	}
+	meta := m.metadataRepo.DataMeta(nodePath)
+	if meta == nil {
+		return nil, NewHttpError(http.StatusNotFound, "Not found")
+	}
+	return meta, nil
+}
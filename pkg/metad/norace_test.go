@@ -0,0 +1,10 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+//go:build !race
+// +build !race
+
+package metad
+
+const raceEnabled = false
@@ -0,0 +1,271 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metad
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"openpitrix.io/metad/pkg/logger"
+	"openpitrix.io/metad/pkg/metadata"
+	"openpitrix.io/metad/pkg/notify"
+)
+
+const subscriptionSyncInterval = time.Second
+
+func newNotifier(config *Config, metadataRepo *metadata.MetadataRepo) *notify.Notifier {
+	deadLetter := func(webhook *notify.Webhook, notification *notify.Notification, attempts int, err error) {
+		putErr := metadataRepo.PutDeadLetter(&metadata.DeadLetter{
+			ID:           notification.ID,
+			Subscription: webhook.Name,
+			URL:          webhook.URL,
+			Format:       webhook.Format,
+			Notification: notification,
+			Attempts:     attempts,
+			Error:        err.Error(),
+		})
+		if putErr != nil {
+			logger.Error("Put dead letter [%s] error: %s, notification lost.", notification.ID, putErr.Error())
+		}
+	}
+	retryInterval := time.Duration(config.NotifyRetryInterval) * time.Second
+	return notify.NewNotifier(metadataRepo.SubscribeData, config.NotifyRetries, retryInterval, deadLetter)
+}
+
+// configSubscriptions return the subscriptions of notify_webhooks, named config-$index.
+func configSubscriptions(config *Config) ([]*metadata.Subscription, error) {
+	subscriptions := make([]*metadata.Subscription, 0, len(config.NotifyWebhooks))
+	for i, s := range config.NotifyWebhooks {
+		webhook, err := notify.ParseWebhook(s)
+		if err != nil {
+			return nil, err
+		}
+		webhook.Name = fmt.Sprintf("%s%d", metadata.ConfigSubscriptionPrefix, i)
+		webhook.Format = notify.FormatJSON
+		webhook.Secret = config.NotifySecret
+		subscriptions = append(subscriptions, &metadata.Subscription{Webhook: *webhook})
+	}
+	return subscriptions, nil
+}
+
+// updateSubscriptions apply the subscriptions of the config and manage api to the notifier (none if standby),
+// reloadLock should be held.
+func (m *Metad) updateSubscriptions(config *Config) error {
+	subscriptions, err := configSubscriptions(config)
+	if err != nil {
+		return err
+	}
+	subscriptions = append(subscriptions, m.metadataRepo.GetSubscriptions()...)
+	// only the leader notify the webhooks, otherwise every metad of the group deliver the same changes.
+	if !m.isLeader() {
+		subscriptions = nil
+	}
+	webhooks := make([]*notify.Webhook, 0, len(subscriptions))
+	for _, subscription := range subscriptions {
+		webhook := subscription.Webhook
+		webhooks = append(webhooks, &webhook)
+	}
+	m.notifier.Update(webhooks)
+	return nil
+}
+
+// checkSubscriptions update the subscriptions if changed after version, return the current version.
+func (m *Metad) checkSubscriptions(version int64) int64 {
+	current := m.metadataRepo.SubscriptionVersion()
+	if current == version {
+		return version
+	}
+	m.reloadLock.Lock()
+	defer m.reloadLock.Unlock()
+	if m.shuttingDown() {
+		return current
+	}
+	if err := m.updateSubscriptions(m.getConfig()); err != nil {
+		logger.Error("Update subscriptions error: %s", err.Error())
+	}
+	return current
+}
+
+// syncSubscriptions apply the subscription changes from backend until shutdown.
+func (m *Metad) syncSubscriptions(version int64) {
+	ticker := time.NewTicker(subscriptionSyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			version = m.checkSubscriptions(version)
+		case <-m.shutdownChan:
+			return
+		}
+	}
+}
+
+type subscriptionView struct {
+	*metadata.Subscription
+	Source  string         `json:"source"`
+	Metrics notify.Metrics `json:"metrics"`
+}
+
+// subscriptionViews return all subscriptions with metrics, the secrets are masked.
+func (m *Metad) subscriptionViews() []*subscriptionView {
+	configs, _ := configSubscriptions(m.getConfig())
+	views := make([]*subscriptionView, 0, len(configs))
+	for _, subscription := range configs {
+		views = append(views, m.subscriptionView(subscription, "config"))
+	}
+	for _, subscription := range m.metadataRepo.GetSubscriptions() {
+		views = append(views, m.subscriptionView(subscription, "api"))
+	}
+	return views
+}
+
+func (m *Metad) subscriptionView(subscription *metadata.Subscription, source string) *subscriptionView {
+	if subscription.Secret != "" {
+		subscription.Secret = "******"
+	}
+	return &subscriptionView{Subscription: subscription, Source: source, Metrics: m.notifier.Metrics(subscription.Name)}
+}
+
+// findSubscription return the subscription of config or manage api.
+func (m *Metad) findSubscription(name string) *subscriptionView {
+	for _, view := range m.subscriptionViews() {
+		if view.Name == name {
+			return view
+		}
+	}
+	return nil
+}
+
+func (m *Metad) subscriptionList(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	return m.subscriptionViews(), nil
+}
+
+func (m *Metad) subscriptionGet(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	view := m.findSubscription(mux.Vars(req)["name"])
+	if view == nil {
+		return nil, NewHttpError(http.StatusNotFound, "Not found")
+	}
+	return view, nil
+}
+
+func (m *Metad) subscriptionUpdate(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	decoder := json.NewDecoder(req.Body)
+	var subscription metadata.Subscription
+	err := decoder.Decode(&subscription)
+	if err != nil {
+		return nil, NewHttpError(http.StatusBadRequest, fmt.Sprintf("invalid json format, error:%s", err.Error()))
+	}
+	subscription.Name = mux.Vars(req)["name"]
+	err = m.metadataRepo.PutSubscription(&subscription)
+	if err != nil {
+		return nil, NewHttpError(http.StatusBadRequest, err.Error())
+	}
+	return m.subscriptionView(&subscription, "api"), nil
+}
+
+func (m *Metad) subscriptionDelete(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	name := mux.Vars(req)["name"]
+	if strings.HasPrefix(name, metadata.ConfigSubscriptionPrefix) {
+		return nil, NewHttpError(http.StatusBadRequest, fmt.Sprintf("subscription [%s] is configured by notify_webhooks.", name))
+	}
+	err := m.metadataRepo.DeleteSubscription(name)
+	if err != nil {
+		return nil, NewServerError(err)
+	}
+	return nil, nil
+}
+
+// subscriptionTest deliver a test notification without events to the subscription once.
+func (m *Metad) subscriptionTest(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	name := mux.Vars(req)["name"]
+	subscription := m.metadataRepo.GetSubscription(name)
+	if subscription == nil {
+		configs, _ := configSubscriptions(m.getConfig())
+		for _, s := range configs {
+			if s.Name == name {
+				subscription = s
+			}
+		}
+	}
+	if subscription == nil {
+		return nil, NewHttpError(http.StatusNotFound, "Not found")
+	}
+	notification := notify.NewNotification(subscription.Prefix, nil)
+	notification.Test = true
+	err := m.notifier.Deliver(&subscription.Webhook, notification)
+	if err != nil {
+		return nil, NewHttpError(http.StatusBadGateway, err.Error())
+	}
+	return notification, nil
+}
+
+func (m *Metad) deadLetterList(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	return m.metadataRepo.GetDeadLetters(), nil
+}
+
+func (m *Metad) deadLetterGet(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	id := mux.Vars(req)["id"]
+	deadLetter := m.metadataRepo.GetDeadLetter(id)
+	if deadLetter == nil {
+		return nil, NewHttpError(http.StatusNotFound, "Not found")
+	}
+	return deadLetter, nil
+}
+
+// subscriptionSecret return the current secret of the subscription, empty if not exist.
+func (m *Metad) subscriptionSecret(name string) string {
+	if strings.HasPrefix(name, metadata.ConfigSubscriptionPrefix) {
+		return m.getConfig().NotifySecret
+	}
+	if subscription := m.metadataRepo.GetSubscription(name); subscription != nil {
+		return subscription.Secret
+	}
+	return ""
+}
+
+// deadLetterReplay deliver the dead letter once, it is discarded if delivered, otherwise the attempts and error are updated.
+// The replay is signed by the current secret of the subscription with a new timestamp.
+func (m *Metad) deadLetterReplay(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	id := mux.Vars(req)["id"]
+	deadLetter := m.metadataRepo.GetDeadLetter(id)
+	if deadLetter == nil {
+		return nil, NewHttpError(http.StatusNotFound, "Not found")
+	}
+	webhook := &notify.Webhook{
+		Name:   deadLetter.Subscription,
+		URL:    deadLetter.URL,
+		Format: deadLetter.Format,
+		Secret: m.subscriptionSecret(deadLetter.Subscription),
+	}
+	err := m.notifier.Deliver(webhook, deadLetter.Notification)
+	if err != nil {
+		deadLetter.Attempts++
+		deadLetter.Error = err.Error()
+		if putErr := m.metadataRepo.PutDeadLetter(deadLetter); putErr != nil {
+			return nil, NewServerError(putErr)
+		}
+		return nil, NewHttpError(http.StatusBadGateway, err.Error())
+	}
+	err = m.metadataRepo.DeleteDeadLetter(id)
+	if err != nil {
+		return nil, NewServerError(err)
+	}
+	return nil, nil
+}
+
+func (m *Metad) deadLetterDelete(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	id := mux.Vars(req)["id"]
+	err := m.metadataRepo.DeleteDeadLetter(id)
+	if err != nil {
+		return nil, NewServerError(err)
+	}
+	return nil, nil
+}
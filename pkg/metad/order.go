@@ -0,0 +1,129 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metad
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+
+	yaml "gopkg.in/yaml.v2"
+
+	"openpitrix.io/metad/pkg/util"
+)
+
+// the orders of the sort query parameter.
+const (
+	// OrderName order the children by name as strings, same as the default.
+	OrderName = "name"
+	// OrderNumeric order the integer names as numbers, so 9 is before 10, see util.NaturalLess.
+	OrderNumeric = "numeric"
+	// OrderCreated order the children by the creation order in the store, the unknown (such as /self) by numeric.
+	OrderCreated = "created"
+)
+
+// orderedDir is a dir result responded with the children in order, the maps of json and yaml are unordered.
+type orderedDir struct {
+	names  []string
+	values map[string]interface{}
+}
+
+func (d *orderedDir) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, name := range d.names {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, err := json.Marshal(name)
+		if err != nil {
+			return nil, err
+		}
+		v, err := json.Marshal(d.values[name])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func (d *orderedDir) MarshalYAML() (interface{}, error) {
+	items := make(yaml.MapSlice, 0, len(d.names))
+	for _, name := range d.names {
+		items = append(items, yaml.MapItem{Key: name, Value: d.values[name]})
+	}
+	return items, nil
+}
+
+// writeText write the leaves as "path\tvalue" lines in order, the paths are relative to the result.
+func (d *orderedDir) writeText(buf *bytes.Buffer, prefix string) {
+	for _, name := range d.names {
+		p := path.Join(prefix, name)
+		switch t := d.values[name].(type) {
+		case *orderedDir:
+			t.writeText(buf, p)
+		default:
+			buf.WriteString(p)
+			buf.WriteString("\t")
+			buf.WriteString(textValue(t))
+			buf.WriteString("\n")
+		}
+	}
+}
+
+// textValue format the leaf value of the typed_values same as the text response of the unordered dir.
+func textValue(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	default:
+		return fmt.Sprintf("%v", t)
+	}
+}
+
+// orderDir replace the dirs of the value at nodePath by the orderedDir in the order of the projection.
+func (p *projection) orderDir(val interface{}, nodePath string) interface{} {
+	m, ok := val.(map[string]interface{})
+	if !ok {
+		return val
+	}
+	d := &orderedDir{names: make([]string, 0, len(m)), values: make(map[string]interface{}, len(m))}
+	for k, v := range m {
+		d.names = append(d.names, k)
+		d.values[k] = p.orderDir(v, path.Join(nodePath, k))
+	}
+	switch p.order {
+	case OrderName:
+		sort.Strings(d.names)
+	case OrderNumeric:
+		sort.Slice(d.names, func(i, j int) bool {
+			return util.NaturalLess(d.names[i], d.names[j])
+		})
+	case OrderCreated:
+		var created map[string]int64
+		if p.createdOrder != nil {
+			created = p.createdOrder(nodePath)
+		}
+		sort.Slice(d.names, func(i, j int) bool {
+			ci, cj := created[d.names[i]], created[d.names[j]]
+			if ci != cj {
+				return ci < cj
+			}
+			return util.NaturalLess(d.names[i], d.names[j])
+		})
+	}
+	return d
+}
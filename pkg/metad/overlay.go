@@ -0,0 +1,81 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metad
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"openpitrix.io/metad/pkg/metadata"
+)
+
+// expireOverlays remove the expired overlays every second until metad stopped.
+func (m *Metad) expireOverlays() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			m.metadataRepo.ExpireOverlays(now)
+		case <-m.shutdownChan:
+			return
+		}
+	}
+}
+
+func (m *Metad) overlayList(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	return m.metadataRepo.GetOverlays(), nil
+}
+
+func (m *Metad) overlayGet(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	o := m.metadataRepo.GetOverlay(mux.Vars(req)["nodePath"])
+	if o == nil {
+		return nil, NewHttpError(http.StatusNotFound, "Not found")
+	}
+	return o, nil
+}
+
+// overlayUpdate create or replace the overlay of the path, the request is {"value": $value, "ttl": 300, "max_ttl": 3600}.
+func (m *Metad) overlayUpdate(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	decoder := json.NewDecoder(req.Body)
+	var o metadata.Overlay
+	err := decoder.Decode(&o)
+	if err != nil {
+		return nil, NewHttpError(http.StatusBadRequest, fmt.Sprintf("invalid json format, error:%s", err.Error()))
+	}
+	o.Path = path.Join("/", mux.Vars(req)["nodePath"])
+	if httpErr := m.authorizeWrite(ctx, req, "overlay", o.Path); httpErr != nil {
+		return nil, httpErr
+	}
+	if err := m.metadataRepo.PutOverlay(&o); err != nil {
+		if metadata.IsSyncStateError(err) {
+			return nil, NewHttpError(http.StatusConflict, err.Error())
+		}
+		return nil, writeError(err, http.StatusBadRequest)
+	}
+	requestLogger(ctx).Info("Overlay [%s], ttl: %d, max_ttl: %d", o.Path, o.TTL, o.MaxTTL)
+	return &o, nil
+}
+
+func (m *Metad) overlayDelete(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	nodePath := path.Join("/", mux.Vars(req)["nodePath"])
+	if m.metadataRepo.GetOverlay(nodePath) == nil {
+		return nil, NewHttpError(http.StatusNotFound, "Not found")
+	}
+	if httpErr := m.authorizeWrite(ctx, req, "overlay", nodePath); httpErr != nil {
+		return nil, httpErr
+	}
+	result, err := m.metadataRepo.DeleteOverlay(nodePath)
+	if err != nil {
+		return nil, syncError(err)
+	}
+	return result, nil
+}
@@ -0,0 +1,90 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metad
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"openpitrix.io/metad/pkg/logger"
+	"openpitrix.io/metad/pkg/metadata"
+)
+
+// authorizeWrite check whether the request is allowed to modify the paths,
+// a path owned by teams (see annotation's owner) can only be modified by the owner team's token,
+// admin token can modify any path, but leave an override trail when the path is owned by other team.
+// The paths should also be allowed by the request's roles if manage_rbac is enabled.
+func (m *Metad) authorizeWrite(ctx context.Context, req *http.Request, action string, paths ...string) *HttpError {
+	if httpErr := m.checkRolePaths(req, paths...); httpErr != nil {
+		return httpErr
+	}
+	var token *metadata.Token
+	if secret := bearerToken(req); secret != "" {
+		if adminToken := m.getConfig().AdminToken; adminToken != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(adminToken)) == 1 {
+			// the admin_token of config is not a stored token, the override trail record it as admin_token.
+			token = &metadata.Token{ID: "admin_token", Admin: true}
+		} else if token = m.metadataRepo.GetToken(secret); token == nil {
+			return NewHttpError(http.StatusUnauthorized, "Invalid token")
+		}
+	}
+	for _, p := range paths {
+		p = path.Join("/", p)
+		owners := m.metadataRepo.PathOwners(p)
+		if len(owners) == 0 {
+			continue
+		}
+		if token != nil && token.Team != "" && len(owners) == 1 && owners[0] == token.Team {
+			continue
+		}
+		if token != nil && token.Admin {
+			// the dry run does not modify the path, so no override.
+			if isDryRun(req) {
+				continue
+			}
+			override := &metadata.Override{
+				Time:      time.Now().Unix(),
+				RequestID: fmt.Sprintf("%v", ctx.Value("requestID")),
+				TokenID:   token.ID,
+				Action:    action,
+				Path:      p,
+				Owners:    owners,
+			}
+			logger.Warn("%s\tOVERRIDE\t%s\t%s\towners:%s", override.RequestID, action, p, strings.Join(owners, ","))
+			if err := m.metadataRepo.PutOverride(override); err != nil {
+				return NewServerError(err)
+			}
+			continue
+		}
+		return NewHttpError(http.StatusForbidden, fmt.Sprintf("path [%s] is owned by [%s]", p, strings.Join(owners, ",")))
+	}
+	return nil
+}
+
+// requestActor return the actor of the manage request which is attached to the change events.
+func (m *Metad) requestActor(req *http.Request) string {
+	identity, _ := m.requestIdentity(req)
+	if identity == "" {
+		identity = m.requestIP(req)
+	}
+	return "manage:" + identity
+}
+
+func (m *Metad) overrideList(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	var since int64
+	if sinceStr := req.FormValue("since"); sinceStr != "" {
+		var err error
+		since, err = strconv.ParseInt(sinceStr, 10, 64)
+		if err != nil {
+			return nil, NewHttpError(http.StatusBadRequest, fmt.Sprintf("invalid since parameter, error:%s", err.Error()))
+		}
+	}
+	return m.metadataRepo.GetOverrides(since), nil
+}
@@ -0,0 +1,113 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metad
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"openpitrix.io/metad/pkg/logger"
+	"openpitrix.io/metad/pkg/metadata"
+	"openpitrix.io/metad/pkg/redact"
+)
+
+const (
+	mergePatchType = "application/merge-patch+json"
+	jsonPatchType  = "application/json-patch+json"
+)
+
+// requestMediaType return the media type of the request body, empty if not present or invalid.
+func requestMediaType(req *http.Request) string {
+	mediaType, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil {
+		return ""
+	}
+	return mediaType
+}
+
+// isMergePatch return whether the request body is a JSON merge patch.
+func isMergePatch(req *http.Request) bool {
+	return requestMediaType(req) == mergePatchType
+}
+
+// dataPatch apply the JSON Patch if the request body is application/json-patch+json,
+// otherwise merge the JSON merge patch to the data, the null members are deleted.
+func (m *Metad) dataPatch(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	vars := mux.Vars(req)
+	nodePath := vars["nodePath"]
+	if nodePath == "" {
+		nodePath = "/"
+	}
+	if httpErr := m.authorizeWrite(ctx, req, "update", nodePath); httpErr != nil {
+		return nil, httpErr
+	}
+	if requestMediaType(req) == jsonPatchType {
+		return m.dataJSONPatch(ctx, req, nodePath)
+	}
+	decoder := json.NewDecoder(req.Body)
+	var patch interface{}
+	err := decoder.Decode(&patch)
+	if err != nil {
+		return nil, NewHttpError(http.StatusBadRequest, fmt.Sprintf("invalid json format, error:%s", err.Error()))
+	}
+	return m.applyDataPatch(req, m.metadataRepo.PlanPatch(nodePath, patch))
+}
+
+// dataJSONPatch apply the JSON Patch operations to the data at nodePath, nothing is written if any operation fail,
+// so the test operation is the precondition of the others.
+func (m *Metad) dataJSONPatch(ctx context.Context, req *http.Request, nodePath string) (interface{}, *HttpError) {
+	decoder := json.NewDecoder(req.Body)
+	var ops []*metadata.PatchOperation
+	err := decoder.Decode(&ops)
+	if err != nil {
+		return nil, NewHttpError(http.StatusBadRequest, fmt.Sprintf("invalid json format, error:%s", err.Error()))
+	}
+	p, err := m.metadataRepo.PlanJSONPatch(nodePath, ops)
+	if err != nil {
+		if metadata.IsPatchTestError(err) {
+			return nil, NewHttpError(http.StatusConflict, err.Error())
+		}
+		return nil, NewHttpError(http.StatusBadRequest, err.Error())
+	}
+	return m.applyDataPatch(req, p)
+}
+
+func (m *Metad) applyDataPatch(req *http.Request, p *metadata.DataPatch) (interface{}, *HttpError) {
+	actor := m.requestActor(req)
+	untrackDelete := m.metadataRepo.TrackDelete(actor, p.Deletes...)
+	untrackPut := func() {}
+	if p.Value != nil {
+		untrackPut = m.metadataRepo.TrackPut(actor, p.Path, p.Value, false)
+	}
+	// the deleted paths of the patch, removed or replaced by another type, are copied to the trash first, the entry is
+	// purged if the patch fail.
+	var entry *metadata.TrashEntry
+	if m.trashEnabled() && len(p.Deletes) > 0 {
+		var err error
+		if entry, err = m.metadataRepo.TrashData(actor, p.Deletes...); err != nil {
+			untrackDelete()
+			untrackPut()
+			return nil, NewServerError(err)
+		}
+	}
+	err := m.metadataRepo.PatchData(p)
+	if err != nil {
+		untrackDelete()
+		untrackPut()
+		if entry != nil {
+			if purgeErr := m.metadataRepo.PurgeTrash(entry.ID); purgeErr != nil {
+				logger.Error("Purge trash [%s] of failed patch error: %s", entry.ID, purgeErr.Error())
+			}
+		}
+		logger.Debug("dataPatch  nodePath:%s, deletes:%v, value:%v, error:%s", p.Path, p.Deletes, redact.Tree(p.Path, p.Value), err.Error())
+		return nil, writeError(err, http.StatusInternalServerError)
+	}
+	return nil, nil
+}
@@ -0,0 +1,94 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metad
+
+import (
+	"sync/atomic"
+	"time"
+
+	"openpitrix.io/metad/pkg/logger"
+)
+
+// startSync load the local cache if configured, and start the backend sync.
+// The sync blocks until the backend is reachable, so it runs in background if the cache loaded,
+// and the cached metadata is served as stale until synced.
+func (m *Metad) startSync() {
+	config := m.getConfig()
+	if config.CacheFile == "" {
+		m.metadataRepo.StartSync()
+		return
+	}
+	if config.Backend == "local" {
+		logger.Warn("The local cache is not supported by local backend, ignore cache_file.")
+		m.metadataRepo.StartSync()
+		return
+	}
+	savedAt, err := m.metadataRepo.LoadCache(config.CacheFile)
+	if err != nil {
+		logger.Error("Load cache file [%s] error: %s", config.CacheFile, err.Error())
+	}
+	if savedAt.IsZero() {
+		m.metadataRepo.StartSync()
+		go m.persistCache()
+		return
+	}
+	logger.Info("Loaded cache file [%s] saved at %s, serve it until backend synced.", config.CacheFile, savedAt.Format(time.RFC3339))
+	atomic.StoreInt32(&m.servingCache, 1)
+	go func() {
+		m.metadataRepo.StartSync()
+		atomic.StoreInt32(&m.servingCache, 0)
+		logger.Info("Backend synced, stop serving the cache.")
+		m.persistCache()
+	}()
+}
+
+// servingStale return whether the metadata is loaded from the local cache and the backend has not been synced.
+func (m *Metad) servingStale() bool {
+	return atomic.LoadInt32(&m.servingCache) == 1
+}
+
+// persistCache save the synced metadata to the cache file every cache_interval until metad stopped.
+func (m *Metad) persistCache() {
+	config := m.getConfig()
+	interval := time.Duration(config.CacheInterval) * time.Second
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var savedVersion int64 = -1
+	for {
+		select {
+		case <-ticker.C:
+		case <-m.shutdownChan:
+			return
+		}
+		// both versions only increase, the sum changes if either changed.
+		version := m.metadataRepo.DataVersion() + m.metadataRepo.MappingVersion()
+		if version == savedVersion {
+			continue
+		}
+		if err := m.metadataRepo.SaveCache(config.CacheFile, config.EncryptionPrefixes...); err != nil {
+			logger.Error("Save cache file [%s] error: %s", config.CacheFile, err.Error())
+			continue
+		}
+		savedVersion = version
+	}
+}
+
+// saveCache save the metadata before stop, skip if the backend has not been synced.
+func (m *Metad) saveCache() {
+	config := m.getConfig()
+	if config.CacheFile == "" || config.Backend == "local" || m.servingStale() {
+		return
+	}
+	synced, _, _ := m.metadataRepo.SyncStatus()
+	if !synced {
+		return
+	}
+	if err := m.metadataRepo.SaveCache(config.CacheFile, config.EncryptionPrefixes...); err != nil {
+		logger.Error("Save cache file [%s] error: %s", config.CacheFile, err.Error())
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metad
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"openpitrix.io/metad/pkg/flatmap"
+	"openpitrix.io/metad/pkg/jsonpath"
+)
+
+// continueTokenHeader respond the continueToken of the next page of the paginated dir.
+const continueTokenHeader = "X-Metad-Continue-Token"
+
+// projection is how the data read result is projected by the query parameters, before responded.
+type projection struct {
+	// depth is the levels of the dirs kept, the dirs past it are replaced by the names of their children, 0 means unlimited.
+	depth int
+	// flatten respond the leaves as a flat map of the full path to value, the full path is joined to base.
+	flatten bool
+	base    string
+	// filter select the values of the result by the JSONPath, respond them as an array in path order.
+	filter *jsonpath.Path
+	// limit is the max children of the dir result responded, the children are in name order and start after the
+	// continue token, 0 means unlimited.
+	limit int
+	after string
+	// next is the continue token of the next page set by apply, empty if the last page.
+	next string
+	// order is the order of the dir children responded, see orderDir, empty means the default name order.
+	order string
+	// createdOrder return the creation orders of the children of the data dir, for the created order.
+	createdOrder func(nodePath string) map[string]int64
+}
+
+// parseProjection parse the projection query parameters of the read request, base is the path of the result.
+func parseProjection(req *http.Request, base string) (*projection, *HttpError) {
+	p := &projection{base: path.Join("/", base)}
+	if s := req.FormValue("depth"); s != "" {
+		depth, err := strconv.Atoi(s)
+		if err != nil || depth <= 0 {
+			return nil, NewHttpError(http.StatusBadRequest, "depth should be a positive integer.")
+		}
+		p.depth = depth
+	}
+	p.flatten = strings.ToLower(req.FormValue("flatten")) == "true"
+	if p.flatten && p.depth > 0 {
+		return nil, NewHttpError(http.StatusBadRequest, "depth can not be used with flatten.")
+	}
+	if s := req.FormValue("filter"); s != "" {
+		filter, err := jsonpath.Compile(s)
+		if err != nil {
+			return nil, NewHttpError(http.StatusBadRequest, err.Error())
+		}
+		if p.depth > 0 || p.flatten {
+			return nil, NewHttpError(http.StatusBadRequest, "filter can not be used with depth and flatten.")
+		}
+		p.filter = filter
+	}
+	if s := req.FormValue("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit <= 0 {
+			return nil, NewHttpError(http.StatusBadRequest, "limit should be a positive integer.")
+		}
+		if p.flatten || p.filter != nil {
+			return nil, NewHttpError(http.StatusBadRequest, "limit can not be used with flatten and filter.")
+		}
+		p.limit = limit
+		if token := req.FormValue("continueToken"); token != "" {
+			after, err := base64.RawURLEncoding.DecodeString(token)
+			if err != nil || len(after) == 0 {
+				return nil, NewHttpError(http.StatusBadRequest, "invalid continueToken.")
+			}
+			p.after = string(after)
+		}
+	} else if req.FormValue("continueToken") != "" {
+		return nil, NewHttpError(http.StatusBadRequest, "continueToken should be used with limit.")
+	}
+	if s := req.FormValue("sort"); s != "" {
+		switch s {
+		case OrderName, OrderNumeric, OrderCreated:
+		default:
+			return nil, NewHttpError(http.StatusBadRequest, fmt.Sprintf("invalid sort [%s], should be name, numeric or created.", s))
+		}
+		if p.depth > 0 || p.flatten || p.filter != nil || p.limit > 0 {
+			return nil, NewHttpError(http.StatusBadRequest, "sort can not be used with depth, flatten, filter and limit.")
+		}
+		p.order = s
+	}
+	if (p.depth > 0 || p.flatten || p.filter != nil || p.limit > 0 || p.order != "") && strings.ToLower(req.FormValue("with_events")) == "true" {
+		return nil, NewHttpError(http.StatusBadRequest, "depth, flatten, filter, limit and sort can not be used with with_events.")
+	}
+	return p, nil
+}
+
+func (p *projection) apply(val interface{}) interface{} {
+	if p.limit > 0 {
+		val = p.paginate(val)
+	}
+	if p.depth > 0 {
+		val = truncateDepth(val, p.depth)
+	}
+	if p.flatten {
+		val = flattenPaths(val, p.base)
+	}
+	if p.filter != nil {
+		val = p.filter.Values(val)
+	}
+	if p.order != "" {
+		val = p.orderDir(val, p.base)
+	}
+	return val
+}
+
+// paginate keep the first limit children of the dir in name order after the continue token, and set the next token
+// if more children left. The token is the last responded name, so the pages are not shifted by the changes of
+// the responded children.
+func (p *projection) paginate(val interface{}) interface{} {
+	m, ok := val.(map[string]interface{})
+	if !ok {
+		return val
+	}
+	names := make([]string, 0, len(m))
+	for k := range m {
+		if k > p.after {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	if len(names) > p.limit {
+		names = names[:p.limit]
+		p.next = base64.RawURLEncoding.EncodeToString([]byte(names[len(names)-1]))
+	}
+	result := make(map[string]interface{}, len(names))
+	for _, k := range names {
+		result[k] = m[k]
+	}
+	return result
+}
+
+// flattenPaths return the leaves of the value as a map of the full path to value, a leaf value is keyed by base.
+func flattenPaths(val interface{}, base string) interface{} {
+	switch t := val.(type) {
+	case nil:
+		return val
+	case map[string]interface{}:
+		result := make(map[string]interface{})
+		for k, v := range flatmap.Flatten(t) {
+			result[path.Join(base, k)] = v
+		}
+		return result
+	default:
+		return map[string]interface{}{base: val}
+	}
+}
+
+// truncateDepth keep depth levels of the dirs, the dirs past it are replaced by the sorted names of their children,
+// the dir children are suffixed by "/".
+func truncateDepth(val interface{}, depth int) interface{} {
+	m, ok := val.(map[string]interface{})
+	if !ok {
+		return val
+	}
+	if depth == 0 {
+		names := make([]string, 0, len(m))
+		for k, v := range m {
+			if _, dir := v.(map[string]interface{}); dir {
+				k += "/"
+			}
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		return names
+	}
+	result := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		result[k] = truncateDepth(v, depth-1)
+	}
+	return result
+}
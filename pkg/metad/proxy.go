@@ -0,0 +1,102 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metad
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"openpitrix.io/metad/pkg/proxyproto"
+)
+
+// proxyHeaderTimeout is the timeout of reading the PROXY protocol header.
+const proxyHeaderTimeout = 10 * time.Second
+
+// configTrustedProxies parse the trusted_proxies, every proxy is a CIDR or an ip.
+func configTrustedProxies(config *Config) ([]*net.IPNet, error) {
+	var proxies []*net.IPNet
+	for _, proxy := range config.TrustedProxies {
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy [%s].", proxy)
+			}
+			bits := 8 * len(ip.To4())
+			if bits == 0 {
+				bits = 8 * net.IPv6len
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy [%s]: %s", proxy, err.Error())
+		}
+		proxies = append(proxies, ipNet)
+	}
+	return proxies, nil
+}
+
+func (m *Metad) getTrustedProxies() []*net.IPNet {
+	m.configLock.RLock()
+	defer m.configLock.RUnlock()
+	return m.trustedProxies
+}
+
+func isTrustedProxy(proxies []*net.IPNet, addr string) bool {
+	ip := net.ParseIP(strings.TrimSpace(addr))
+	if ip == nil {
+		return false
+	}
+	for _, proxy := range proxies {
+		if proxy.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedIP return the client ip forwarded by the trusted proxy remoteIP, the X-Forwarded-For hops are checked
+// from the nearest, the first untrusted hop is the client, X-Real-IP is used if no X-Forwarded-For. Return empty if
+// remoteIP is not trusted, so the headers from others are ignored.
+func forwardedIP(proxies []*net.IPNet, remoteIP string, req *http.Request) string {
+	if !isTrustedProxy(proxies, remoteIP) {
+		return ""
+	}
+	var hops []string
+	for _, value := range req.Header["X-Forwarded-For"] {
+		for _, hop := range strings.Split(value, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		if !isTrustedProxy(proxies, hops[i]) {
+			return hops[i]
+		}
+	}
+	if len(hops) > 0 {
+		// all hops are trusted proxies, the farthest is the client.
+		return hops[0]
+	}
+	return strings.TrimSpace(req.Header.Get("X-Real-IP"))
+}
+
+// proxyListener read the PROXY protocol header of the connections, from the trusted proxies if configured, so the
+// client ip is the original client behind the TCP load balancers.
+func (m *Metad) proxyListener(listener net.Listener) net.Listener {
+	return &proxyproto.Listener{Listener: listener, Timeout: proxyHeaderTimeout, Trusted: func(addr net.Addr) bool {
+		proxies := m.getTrustedProxies()
+		if len(proxies) == 0 {
+			return true
+		}
+		host, _, err := net.SplitHostPort(addr.String())
+		return err == nil && isTrustedProxy(proxies, host)
+	}}
+}
@@ -0,0 +1,99 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metad
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"openpitrix.io/metad/pkg/metadata"
+	"openpitrix.io/metad/pkg/store"
+)
+
+const (
+	QuotaModeReject = "reject"
+	QuotaModeFlag   = "flag"
+)
+
+// parseQuota parse the quota in format prefix=max_keys[,max_bytes[,max_value_bytes[,max_depth]]], the prefix should be
+// a top level node.
+func parseQuota(s string) (string, store.Quota, error) {
+	quota := store.Quota{}
+	idx := strings.Index(s, "=")
+	if idx < 0 {
+		return "", quota, fmt.Errorf("invalid quota [%s], should be prefix=max_keys[,max_bytes[,max_value_bytes[,max_depth]]].", s)
+	}
+	prefix := path.Clean(path.Join("/", s[:idx]))
+	if prefix == "/" || strings.Count(prefix, "/") > 1 {
+		return "", quota, fmt.Errorf("invalid quota [%s], prefix should be a top level node.", s)
+	}
+	limits := strings.Split(s[idx+1:], ",")
+	if len(limits) > 4 {
+		return "", quota, fmt.Errorf("invalid quota [%s], should be prefix=max_keys[,max_bytes[,max_value_bytes[,max_depth]]].", s)
+	}
+	for i, limit := range limits {
+		v, err := strconv.ParseInt(strings.TrimSpace(limit), 10, 64)
+		if err != nil || v < 0 {
+			return "", quota, fmt.Errorf("invalid quota [%s], the limit should be a non-negative integer.", s)
+		}
+		switch i {
+		case 0:
+			quota.MaxKeys = v
+		case 1:
+			quota.MaxBytes = v
+		case 2:
+			quota.MaxValueBytes = v
+		case 3:
+			quota.MaxDepth = v
+		}
+	}
+	return prefix, quota, nil
+}
+
+func configQuotas(config *Config) (map[string]store.Quota, error) {
+	if config.QuotaMode != "" && config.QuotaMode != QuotaModeReject && config.QuotaMode != QuotaModeFlag {
+		return nil, fmt.Errorf("invalid quota_mode [%s], should be %s or %s.", config.QuotaMode, QuotaModeReject, QuotaModeFlag)
+	}
+	quotas := make(map[string]store.Quota, len(config.Quotas))
+	for _, s := range config.Quotas {
+		prefix, quota, err := parseQuota(s)
+		if err != nil {
+			return nil, err
+		}
+		quotas[prefix] = quota
+	}
+	return quotas, nil
+}
+
+// writeError convert the error of the data write to HttpError with the status, or 413 if the write exceeds the quota,
+// or 403 if the write is to a mounted prefix, or 422 if the data would not match the schema of its prefix.
+func writeError(err error, status int) *HttpError {
+	if metadata.IsQuotaError(err) {
+		return NewHttpError(http.StatusRequestEntityTooLarge, err.Error())
+	}
+	if metadata.IsMountError(err) {
+		return NewHttpError(http.StatusForbidden, err.Error())
+	}
+	if metadata.IsSchemaError(err) {
+		return NewHttpError(http.StatusUnprocessableEntity, err.Error())
+	}
+	if metadata.IsFreezeError(err) {
+		return NewHttpError(http.StatusLocked, err.Error())
+	}
+	return NewHttpError(status, err.Error())
+}
+
+func (m *Metad) statsGet(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	return map[string]interface{}{
+		"data_version": m.metadataRepo.DataVersion(),
+		"quota_mode":   m.getConfig().QuotaMode,
+		"usage":        m.metadataRepo.DataUsage(),
+		"overlays":     m.metadataRepo.OverlayStats(),
+	}, nil
+}
@@ -0,0 +1,11 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+//go:build race
+// +build race
+
+package metad
+
+// raceEnabled is whether the test is built with -race, which allocates in the instrumented code.
+const raceEnabled = true
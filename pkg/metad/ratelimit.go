@@ -0,0 +1,37 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metad
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"openpitrix.io/metad/pkg/ratelimit"
+)
+
+func newLimiter(config *Config) *ratelimit.Limiter {
+	return ratelimit.New(config.RateLimit, config.RateLimitBurst, config.ClientRateLimit, config.ClientRateLimitBurst)
+}
+
+// rateLimit check the metadata api request rate of the client (the mapping host, see requestHost),
+// return 429 error and set Retry-After header if exceeded.
+func (m *Metad) rateLimit(w http.ResponseWriter, req *http.Request) *HttpError {
+	client, httpErr := m.requestHost(req)
+	if httpErr != nil {
+		client = m.requestIP(req)
+	}
+	ok, retryAfter := m.limiter.Allow(client)
+	if ok {
+		return nil
+	}
+	seconds := int64(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+	return NewHttpError(http.StatusTooManyRequests, "too many requests, retry after "+(time.Duration(seconds)*time.Second).String()+".")
+}
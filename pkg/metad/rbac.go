@@ -0,0 +1,218 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metad
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"openpitrix.io/metad/pkg/metadata"
+)
+
+// the endpoint classes of the manage api writes, decide which role can write.
+const (
+	writeClassData    = "data"
+	writeClassMapping = "mapping"
+	writeClassAdmin   = "admin"
+)
+
+// checkRoleBindings check the role_bindings config, every binding should have the identity and a valid role.
+func checkRoleBindings(config *Config) error {
+	for _, binding := range config.RoleBindings {
+		if binding == nil || binding.Identity == "" {
+			return errors.New("role_bindings should have identity.")
+		}
+		if err := metadata.CheckRole(binding.Role); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// requestRoles return the roles of the manage request, the admin_token of config and the admin tokens are admin,
+// other tokens have their roles, and the verified client cert has the role_bindings of its identity.
+func (m *Metad) requestRoles(req *http.Request) ([]metadata.RoleBinding, *HttpError) {
+	config := m.getConfig()
+	if secret := bearerToken(req); secret != "" {
+		if config.AdminToken != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(config.AdminToken)) == 1 {
+			return []metadata.RoleBinding{{Role: metadata.RoleAdmin}}, nil
+		}
+		token := m.metadataRepo.GetToken(secret)
+		if token == nil {
+			return nil, NewHttpError(http.StatusUnauthorized, "Invalid token")
+		}
+		if token.Admin {
+			return []metadata.RoleBinding{{Role: metadata.RoleAdmin}}, nil
+		}
+		return token.Roles, nil
+	}
+	identity := certIdentity(req)
+	if identity == "" {
+		return nil, NewHttpError(http.StatusUnauthorized, "token or client cert with role is required.")
+	}
+	var roles []metadata.RoleBinding
+	for _, binding := range config.RoleBindings {
+		if binding.Identity == identity {
+			roles = append(roles, *binding)
+		}
+	}
+	return roles, nil
+}
+
+// manageWriteClass return the endpoint class of the manage api write.
+func manageWriteClass(urlPath string) string {
+	switch {
+	case strings.HasPrefix(urlPath, "/v1/mapping"), strings.HasPrefix(urlPath, "/v1/rule"):
+		return writeClassMapping
+	case strings.HasPrefix(urlPath, "/v1/data"), strings.HasPrefix(urlPath, "/v1/overlay"),
+		strings.HasPrefix(urlPath, "/v1/alias"),
+		strings.HasPrefix(urlPath, "/v1/annotation"), strings.HasPrefix(urlPath, "/v1/release"),
+		strings.HasPrefix(urlPath, "/v1/job"), urlPath == "/v1/import",
+		strings.HasPrefix(urlPath, "/v1/trash/") && strings.HasSuffix(urlPath, "/restore"):
+		return writeClassData
+	}
+	return writeClassAdmin
+}
+
+// manageDataPath return the node path of the manage data read, false if the request is not a data read.
+func manageDataPath(req *http.Request) (string, bool) {
+	if req.URL.Path != "/v1/data" && !strings.HasPrefix(req.URL.Path, "/v1/data/") {
+		return "", false
+	}
+	return path.Join("/", strings.TrimPrefix(req.URL.Path, "/v1/data")), true
+}
+
+// coversPath check the path is under one of the prefixes, empty prefixes cover all paths.
+func coversPath(prefixes []string, p string) bool {
+	if len(prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range prefixes {
+		prefix = path.Join("/", prefix)
+		if prefix == "/" || p == prefix || strings.HasPrefix(p, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// prefixFilteredRead check the manage api read filters its output by the role prefixes in the handler, such as the
+// prefix of the export and the job paths of the job reads.
+func prefixFilteredRead(urlPath string) bool {
+	switch urlPath {
+	case "/v1/export", "/v1/find", "/v1/job":
+		return true
+	}
+	return strings.HasPrefix(urlPath, "/v1/job/")
+}
+
+// roleAllowed check the role can request the endpoint, the prefixes of the data writes are checked by checkRolePaths
+// as the written paths may be in the body. The replicate api streaming the whole data and records is admin only, and
+// the role with prefixes only reads /v1/data under the prefixes and the reads filtered by the prefixes, as the other
+// reads (such as the trash, release backups and dead letters) carry the data of any path.
+func roleAllowed(binding metadata.RoleBinding, req *http.Request) bool {
+	if binding.Role == metadata.RoleAdmin {
+		return true
+	}
+	if strings.HasPrefix(req.URL.Path, "/v1/replicate/") {
+		return false
+	}
+	switch strings.ToUpper(req.Method) {
+	case "GET", "HEAD", "OPTIONS":
+		if binding.Role == metadata.RoleMappingAdmin || len(binding.Prefixes) == 0 {
+			return true
+		}
+		if p, ok := manageDataPath(req); ok {
+			return coversPath(binding.Prefixes, p)
+		}
+		return prefixFilteredRead(req.URL.Path)
+	}
+	switch manageWriteClass(req.URL.Path) {
+	case writeClassData:
+		return binding.Role == metadata.RoleWriter
+	case writeClassMapping:
+		return binding.Role == metadata.RoleMappingAdmin
+	}
+	return false
+}
+
+// checkRole check the request's roles allow the endpoint when manage_rbac is enabled: reads require any role (see
+// roleAllowed for the roles with prefixes), data writes require writer, mapping and access rule writes require
+// mapping-admin, other writes and the replicate api require admin.
+func (m *Metad) checkRole(req *http.Request) *HttpError {
+	if !m.getConfig().ManageRBAC {
+		return nil
+	}
+	roles, httpErr := m.requestRoles(req)
+	if httpErr != nil {
+		return httpErr
+	}
+	for _, binding := range roles {
+		if roleAllowed(binding, req) {
+			return nil
+		}
+	}
+	return NewHttpError(http.StatusForbidden, fmt.Sprintf("no role allows %s %s", req.Method, req.URL.Path))
+}
+
+// roleReadable check the request's roles allow reading the data path when manage_rbac is enabled, as the data read
+// checked by roleAllowed.
+func (m *Metad) roleReadable(req *http.Request, p string) bool {
+	if !m.getConfig().ManageRBAC {
+		return true
+	}
+	roles, httpErr := m.requestRoles(req)
+	if httpErr != nil {
+		return false
+	}
+	for _, binding := range roles {
+		if binding.Role == metadata.RoleAdmin || binding.Role == metadata.RoleMappingAdmin || coversPath(binding.Prefixes, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkRoleRead check the request's roles allow reading the data paths when manage_rbac is enabled, for the reads
+// outside /v1/data, such as the export and the job result, see roleReadable.
+func (m *Metad) checkRoleRead(req *http.Request, paths ...string) *HttpError {
+	for _, p := range paths {
+		p = path.Join("/", p)
+		if !m.roleReadable(req, p) {
+			return NewHttpError(http.StatusForbidden, fmt.Sprintf("no role allows reading path [%s]", p))
+		}
+	}
+	return nil
+}
+
+// checkRolePaths check the request's roles allow writing the data paths when manage_rbac is enabled, only admin and
+// the writer with the prefixes covering the path can write.
+func (m *Metad) checkRolePaths(req *http.Request, paths ...string) *HttpError {
+	if !m.getConfig().ManageRBAC {
+		return nil
+	}
+	roles, httpErr := m.requestRoles(req)
+	if httpErr != nil {
+		return httpErr
+	}
+	for _, p := range paths {
+		p = path.Join("/", p)
+		allowed := false
+		for _, binding := range roles {
+			if binding.Role == metadata.RoleAdmin || (binding.Role == metadata.RoleWriter && coversPath(binding.Prefixes, p)) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return NewHttpError(http.StatusForbidden, fmt.Sprintf("no role allows writing path [%s]", p))
+		}
+	}
+	return nil
+}
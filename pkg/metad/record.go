@@ -0,0 +1,286 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metad
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"openpitrix.io/metad/pkg/logger"
+)
+
+const (
+	defaultRecordDuration = 60
+	maxRecordDuration     = 3600
+	maxRecordedResponses  = 10000
+)
+
+// RecordedResponse is a data path request and the response of metad, the body is not compressed.
+type RecordedResponse struct {
+	Time        time.Time `json:"time"`
+	Identity    string    `json:"identity"`
+	Method      string    `json:"method"`
+	URI         string    `json:"uri"`
+	Accept      string    `json:"accept,omitempty"`
+	Status      int       `json:"status"`
+	ContentType string    `json:"content_type,omitempty"`
+	Version     string    `json:"version,omitempty"`
+	LatencyMs   int64     `json:"latency_ms"`
+	Body        string    `json:"body"`
+}
+
+// Recording is the responses recorded in a time window, Truncated if stopped by maxRecordedResponses.
+type Recording struct {
+	StartedAt time.Time           `json:"started_at"`
+	Until     time.Time           `json:"until"`
+	Truncated bool                `json:"truncated,omitempty"`
+	Responses []*RecordedResponse `json:"responses"`
+}
+
+// recorder keep the data path responses until the window end, the recording is kept after the window for download.
+type recorder struct {
+	recording *Recording
+	lock      sync.Mutex
+}
+
+func (r *recorder) start(duration time.Duration) *Recording {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	now := time.Now()
+	r.recording = &Recording{StartedAt: now, Until: now.Add(duration), Responses: []*RecordedResponse{}}
+	return &Recording{StartedAt: r.recording.StartedAt, Until: r.recording.Until}
+}
+
+func (r *recorder) stop() {
+	r.lock.Lock()
+	r.recording = nil
+	r.lock.Unlock()
+}
+
+func (r *recorder) get() *Recording {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.recording == nil {
+		return nil
+	}
+	recording := *r.recording
+	recording.Responses = append([]*RecordedResponse{}, r.recording.Responses...)
+	return &recording
+}
+
+// active return whether the request at now should be recorded.
+func (r *recorder) active(now time.Time) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.recording != nil && !r.recording.Truncated && now.Before(r.recording.Until)
+}
+
+func (r *recorder) add(resp *RecordedResponse) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.recording == nil || r.recording.Truncated || resp.Time.After(r.recording.Until) {
+		return
+	}
+	if len(r.recording.Responses) >= maxRecordedResponses {
+		logger.Warn("Recorded responses reach the limit %d, stop recording.", maxRecordedResponses)
+		r.recording.Truncated = true
+		return
+	}
+	r.recording.Responses = append(r.recording.Responses, resp)
+}
+
+type recordWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *recordWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+// recordWriter return the writer to record the response if recording, the returned func finish the record.
+func (m *Metad) recordWriter(w http.ResponseWriter, req *http.Request, start time.Time) (http.ResponseWriter, func()) {
+	if !m.recorder.active(start) {
+		return w, func() {}
+	}
+	rw := &recordWriter{ResponseWriter: w, status: http.StatusOK}
+	return rw, func() {
+		m.recorder.add(&RecordedResponse{
+			Time:        start,
+			Identity:    m.requestIP(req),
+			Method:      req.Method,
+			URI:         req.URL.RequestURI(),
+			Accept:      req.Header.Get("Accept"),
+			Status:      rw.status,
+			ContentType: rw.Header().Get("Content-Type"),
+			Version:     rw.Header().Get("X-Metad-Version"),
+			LatencyMs:   int64(time.Since(start) / time.Millisecond),
+			Body:        rw.body.String(),
+		})
+	}
+}
+
+type recordRequest struct {
+	Duration int `json:"duration"`
+}
+
+func (m *Metad) recordStart(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	recordReq := recordRequest{}
+	if req.ContentLength != 0 {
+		if err := json.NewDecoder(req.Body).Decode(&recordReq); err != nil {
+			return nil, NewHttpError(http.StatusBadRequest, fmt.Sprintf("invalid json format, error:%s", err.Error()))
+		}
+	}
+	if recordReq.Duration == 0 {
+		recordReq.Duration = defaultRecordDuration
+	}
+	if recordReq.Duration < 0 || recordReq.Duration > maxRecordDuration {
+		return nil, NewHttpError(http.StatusBadRequest, fmt.Sprintf("record duration should be in 1-%d seconds.", maxRecordDuration))
+	}
+	recording := m.recorder.start(time.Duration(recordReq.Duration) * time.Second)
+	requestLogger(ctx).Info("Start recording until %s", recording.Until.Format(time.RFC3339))
+	return recording, nil
+}
+
+func (m *Metad) recordGet(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	recording := m.recorder.get()
+	if recording == nil {
+		return nil, NewHttpError(http.StatusNotFound, "Not found")
+	}
+	return recording, nil
+}
+
+func (m *Metad) recordDelete(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	m.recorder.stop()
+	return nil, nil
+}
+
+// Replayer serve the recorded responses by the identity and the request, the responses of the same request
+// are served in the recorded order, and the last one is repeated.
+type Replayer struct {
+	identity  string
+	responses map[string][]*RecordedResponse
+	served    map[string]int
+	lock      sync.Mutex
+}
+
+// NewReplayer create the replayer of the recording, all requests are treated as from identity if not empty,
+// otherwise from the X-Forwarded-For header or the remote ip.
+func NewReplayer(recording *Recording, identity string) *Replayer {
+	r := &Replayer{
+		identity:  identity,
+		responses: make(map[string][]*RecordedResponse),
+		served:    make(map[string]int),
+	}
+	for _, resp := range recording.Responses {
+		key := replayKey(resp.Identity, resp.Method, resp.URI, resp.Accept)
+		r.responses[key] = append(r.responses[key], resp)
+	}
+	return r
+}
+
+// LoadRecording read the recording file, which is the response of GET /v1/record.
+func LoadRecording(file string) (*Recording, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	recording := &Recording{}
+	if err := json.Unmarshal(b, recording); err != nil {
+		return nil, fmt.Errorf("invalid recording file [%s]: %s", file, err.Error())
+	}
+	return recording, nil
+}
+
+func replayKey(identity, method, uri, accept string) string {
+	return strings.Join([]string{identity, method, uri, accept}, " ")
+}
+
+func (r *Replayer) next(key string) *RecordedResponse {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	responses := r.responses[key]
+	if len(responses) == 0 {
+		return nil
+	}
+	i := r.served[key]
+	if i < len(responses)-1 {
+		r.served[key] = i + 1
+	}
+	return responses[i]
+}
+
+func (r *Replayer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	identity := r.identity
+	if identity == "" {
+		identity = req.Header.Get("X-Forwarded-For")
+	}
+	if identity == "" {
+		identity, _, _ = net.SplitHostPort(req.RemoteAddr)
+	}
+	resp := r.next(replayKey(identity, req.Method, req.URL.RequestURI(), req.Header.Get("Accept")))
+	if resp == nil {
+		respondError(w, req, "No recorded response", http.StatusNotFound)
+		return
+	}
+	// long-poll responded after the change, replay the wait.
+	if strings.ToLower(req.FormValue("wait")) == "true" && resp.LatencyMs > 0 {
+		select {
+		case <-time.After(time.Duration(resp.LatencyMs) * time.Millisecond):
+		case <-req.Context().Done():
+			return
+		}
+	}
+	if resp.ContentType != "" {
+		w.Header().Set("Content-Type", resp.ContentType)
+	}
+	if resp.Version != "" {
+		w.Header().Set("X-Metad-Version", resp.Version)
+	}
+	w.Header().Set("X-Metad-Replay", "true")
+	w.WriteHeader(resp.Status)
+	if req.Method != "HEAD" {
+		w.Write([]byte(resp.Body))
+	}
+}
+
+// replayMain serve the recorded responses, for reproducing the client side issues without metad and backend.
+func replayMain(args []string) {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	recordingFile := flags.String("recording", "", "The recording file downloaded by GET /v1/record")
+	listen := flags.String("listen", ":9180", "Address to listen to (TCP)")
+	identity := flags.String("identity", "", "Replay the responses recorded for the identity (client ip) to all requests")
+	logLevel := flags.String("log_level", "info", "Log level for metad print out: debug|info|warning")
+	flags.Parse(args)
+
+	logger.SetLevelByString(*logLevel)
+	if *recordingFile == "" {
+		logger.Fatal("The recording file is required.")
+	}
+	recording, err := LoadRecording(*recordingFile)
+	if err != nil {
+		logger.Fatal("Load recording error: %s", err.Error())
+	}
+	logger.Info("Replaying %d responses recorded at %s on %s", len(recording.Responses), recording.StartedAt.Format(time.RFC3339), *listen)
+	if err := http.ListenAndServe(*listen, NewReplayer(recording, *identity)); err != nil {
+		logger.Fatal("%s", err.Error())
+	}
+}
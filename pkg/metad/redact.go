@@ -0,0 +1,48 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metad
+
+import (
+	"net/http"
+	"strings"
+
+	"openpitrix.io/metad/pkg/logger"
+	"openpitrix.io/metad/pkg/redact"
+)
+
+// setRedactPrefixes mask the values of the sensitive_prefixes and the encryption_prefixes in the logs.
+func setRedactPrefixes(config *Config) {
+	prefixes := append([]string{}, config.SensitivePrefixes...)
+	redact.SetPrefixes(append(prefixes, config.EncryptionPrefixes...))
+}
+
+// responseDataPath return the data path of the response if the request is a data read of the metadata api or the
+// manage api.
+func responseDataPath(req *http.Request, manage bool) (string, bool) {
+	p := req.URL.Path
+	if manage {
+		if p == "/v1/data" || strings.HasPrefix(p, "/v1/data/") {
+			return "/" + strings.TrimPrefix(strings.TrimPrefix(p, "/v1/data"), "/"), true
+		}
+		return "", false
+	}
+	if p == "/self" || strings.HasPrefix(p, "/self/") || strings.HasPrefix(p, "/render/") {
+		return "", false
+	}
+	return p, true
+}
+
+// debugResponse log the response in debug level. If any sensitive prefix configured, the sensitive values of the data
+// reads are masked, and other responses are omitted as the values can not be located, such as the self view.
+func debugResponse(requestID string, req *http.Request, manage bool, result interface{}) {
+	entry := logger.WithFields(logger.Fields{"request_id": requestID})
+	if !redact.Enabled() {
+		entry.Debug("resp %v", result)
+	} else if dataPath, ok := responseDataPath(req, manage); ok {
+		entry.Debug("resp %v", redact.Tree(dataPath, result))
+	} else {
+		entry.Debug("resp omitted as sensitive_prefixes configured")
+	}
+}
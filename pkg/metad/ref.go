@@ -0,0 +1,53 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metad
+
+import (
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"openpitrix.io/metad/pkg/metadata"
+)
+
+func expandRefsRequested(req *http.Request) bool {
+	return strings.ToLower(req.FormValue("expand_refs")) == "true"
+}
+
+// refResult expand the references of the metadata api result if expand_refs, the referenced paths are read as the
+// client, so the access rule is checked. The events of with_events are not expanded.
+func (m *Metad) refResult(req *http.Request, result interface{}) interface{} {
+	if result == nil || !expandRefsRequested(req) || strings.ToLower(req.FormValue("with_events")) == "true" {
+		return result
+	}
+	host, repo, httpErr := m.clientRepo(req)
+	if httpErr != nil {
+		return result
+	}
+	nodePath := path.Join("/", mux.Vars(req)["nodePath"])
+	if isSelfPath(path.Clean(req.URL.Path)) {
+		nodePath = ""
+	}
+	return metadata.ExpandRefs(result, nodePath, func(p string) interface{} {
+		_, val := repo.Root(host, p)
+		return repo.TypeData(p, val)
+	})
+}
+
+// refData expand the references of the manage data read if expand_refs, the referenced paths not readable by the
+// request's roles are kept as is.
+func (m *Metad) refData(req *http.Request, nodePath string, val interface{}) interface{} {
+	if !expandRefsRequested(req) {
+		return val
+	}
+	return metadata.ExpandRefs(val, nodePath, func(p string) interface{} {
+		if !m.roleReadable(req, p) {
+			return nil
+		}
+		return m.metadataRepo.TypeData(p, m.metadataRepo.ReadData(p))
+	})
+}
@@ -0,0 +1,110 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metad
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"openpitrix.io/metad/pkg/metadata"
+)
+
+func (m *Metad) releaseList(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	return m.metadataRepo.GetReleases(), nil
+}
+
+func (m *Metad) releaseGet(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	name := mux.Vars(req)["name"]
+	release := m.metadataRepo.GetRelease(name)
+	if release == nil {
+		return nil, NewHttpError(http.StatusNotFound, "Not found")
+	}
+	return release, nil
+}
+
+func (m *Metad) releaseCreate(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	decoder := json.NewDecoder(req.Body)
+	var release metadata.Release
+	err := decoder.Decode(&release)
+	if err != nil {
+		return nil, NewHttpError(http.StatusBadRequest, fmt.Sprintf("invalid json format, error:%s", err.Error()))
+	}
+	if httpErr := m.authorizeWrite(ctx, req, "release", releasePaths(&release)...); httpErr != nil {
+		return nil, httpErr
+	}
+	err = m.metadataRepo.CreateRelease(&release)
+	if err != nil {
+		return nil, NewHttpError(http.StatusBadRequest, err.Error())
+	}
+	return &release, nil
+}
+
+func (m *Metad) releaseDelete(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	name := mux.Vars(req)["name"]
+	if release := m.metadataRepo.GetRelease(name); release != nil {
+		if httpErr := m.authorizeWrite(ctx, req, "release", releasePaths(release)...); httpErr != nil {
+			return nil, httpErr
+		}
+	}
+	err := m.metadataRepo.DeleteRelease(name)
+	if err != nil {
+		return nil, NewServerError(err)
+	}
+	return nil, nil
+}
+
+func (m *Metad) releaseApply(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	name := mux.Vars(req)["name"]
+	release := m.metadataRepo.GetRelease(name)
+	if release == nil {
+		return nil, NewHttpError(http.StatusNotFound, "Not found")
+	}
+	if httpErr := m.authorizeWrite(ctx, req, "release", releasePaths(release)...); httpErr != nil {
+		return nil, httpErr
+	}
+	untrack := m.metadataRepo.TrackActor(m.requestActor(req), releasePaths(release)...)
+	release, err := m.metadataRepo.ApplyRelease(name)
+	if err != nil {
+		untrack()
+		if metadata.IsReleaseStateError(err) {
+			return nil, NewHttpError(http.StatusConflict, err.Error())
+		}
+		return nil, writeError(err, http.StatusInternalServerError)
+	}
+	return release, nil
+}
+
+func (m *Metad) releaseRollback(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	name := mux.Vars(req)["name"]
+	release := m.metadataRepo.GetRelease(name)
+	if release == nil {
+		return nil, NewHttpError(http.StatusNotFound, "Not found")
+	}
+	if httpErr := m.authorizeWrite(ctx, req, "release", releasePaths(release)...); httpErr != nil {
+		return nil, httpErr
+	}
+	untrack := m.metadataRepo.TrackActor(m.requestActor(req), releasePaths(release)...)
+	release, err := m.metadataRepo.RollbackRelease(name)
+	if err != nil {
+		untrack()
+		if metadata.IsReleaseStateError(err) {
+			return nil, NewHttpError(http.StatusConflict, err.Error())
+		}
+		return nil, NewServerError(err)
+	}
+	return release, nil
+}
+
+func releasePaths(release *metadata.Release) []string {
+	paths := make([]string, 0, len(release.Changes))
+	for _, change := range release.Changes {
+		paths = append(paths, change.Path)
+	}
+	return paths
+}
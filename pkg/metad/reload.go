@@ -0,0 +1,207 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metad
+
+import (
+	"errors"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"syscall"
+	"time"
+
+	"openpitrix.io/metad/pkg/logger"
+)
+
+// reloadableOptions are the config options take effect on reload, other options require restart.
+var reloadableOptions = map[string]bool{
+	"log_level":             true,
+	"log_format":            true,
+	"xff":                   true,
+	"audit_secret_paths":    true,
+	"ready_max_sync_lag":    true,
+	"notify_webhooks":       true,
+	"notify_retries":        true,
+	"notify_retry_interval": true,
+	"notify_secret":         true,
+	"drain_timeout":         true,
+
+	"rate_limit":              true,
+	"rate_limit_burst":        true,
+	"client_rate_limit":       true,
+	"client_rate_limit_burst": true,
+	"compress_min_size":       true,
+	"quotas":                  true,
+	"quota_mode":              true,
+	"admin_token":             true,
+	"read_peers":              true,
+	"read_budget":             true,
+	"verify_repair":           true,
+	"verify_interval":         true,
+	"max_request_timeout":     true,
+	"authz_url":               true,
+	"authz_fail_open":         true,
+	"authz_cache_ttl":         true,
+	"slo_availability":        true,
+	"slo_latency_ms":          true,
+	"slo_latency_target":      true,
+	"index_keys":              true,
+	"typed_values":            true,
+	"node_history":            true,
+	"trash_retention":         true,
+	"trusted_proxies":         true,
+	"sensitive_prefixes":      true,
+	"manage_rbac":             true,
+	"role_bindings":           true,
+	"memory_report_top":       true,
+	"memory_report_depth":     true,
+	"self_profiles":           true,
+	"nodes":                   true,
+}
+
+func (m *Metad) getConfig() *Config {
+	m.configLock.RLock()
+	defer m.configLock.RUnlock()
+	return m.config
+}
+
+// Reload reload the config file and command line flags, and apply the reloadable options.
+func (m *Metad) Reload() error {
+	if configFile == "" {
+		return errors.New("no config file to reload.")
+	}
+	config, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	restartRequired, err := m.applyConfig(config)
+	if err != nil {
+		return err
+	}
+	if len(restartRequired) > 0 {
+		logger.Warn("Config options %s changed, require restart to take effect.", strings.Join(restartRequired, ","))
+	}
+	logger.Info("Config reloaded")
+	return nil
+}
+
+// applyConfig apply the reloadable options of the config, and return the changed options require restart.
+// The watch connections and listeners are kept.
+func (m *Metad) applyConfig(config *Config) ([]string, error) {
+	m.reloadLock.Lock()
+	defer m.reloadLock.Unlock()
+
+	old := m.getConfig()
+	merged, restartRequired := mergeReloadable(old, config)
+	if _, err := configSubscriptions(merged); err != nil {
+		return nil, err
+	}
+	quotas, err := configQuotas(merged)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkSLOConfig(merged); err != nil {
+		return nil, err
+	}
+	if err := checkNodeHistory(merged); err != nil {
+		return nil, err
+	}
+	if err := checkTrashRetention(merged); err != nil {
+		return nil, err
+	}
+	if err := checkVerifyInterval(merged); err != nil {
+		return nil, err
+	}
+	if err := checkIndexKeys(merged); err != nil {
+		return nil, err
+	}
+	proxies, err := configTrustedProxies(merged)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkRoleBindings(merged); err != nil {
+		return nil, err
+	}
+	if err := checkMemoryReport(merged); err != nil {
+		return nil, err
+	}
+	if err := checkSelfProfiles(merged); err != nil {
+		return nil, err
+	}
+
+	if err := m.applyBackendEndpoints(old, merged); err != nil {
+		return nil, err
+	}
+
+	if old.NotifyRetries != merged.NotifyRetries || old.NotifyRetryInterval != merged.NotifyRetryInterval {
+		m.notifier.SetRetry(merged.NotifyRetries, time.Duration(merged.NotifyRetryInterval)*time.Second)
+	}
+	if !reflect.DeepEqual(old.NotifyWebhooks, merged.NotifyWebhooks) || old.NotifySecret != merged.NotifySecret {
+		if err := m.updateSubscriptions(merged); err != nil {
+			return nil, err
+		}
+	}
+
+	m.limiter.SetLimits(merged.RateLimit, merged.RateLimitBurst, merged.ClientRateLimit, merged.ClientRateLimitBurst)
+	m.metadataRepo.SetDataQuotas(quotas, merged.QuotaMode != QuotaModeFlag)
+	if !reflect.DeepEqual(old.IndexKeys, merged.IndexKeys) {
+		m.metadataRepo.SetIndexKeys(merged.IndexKeys)
+	}
+	m.metadataRepo.SetTypedValues(merged.TypedValues)
+	m.metadataRepo.SetNodeHistory(merged.NodeHistory)
+
+	if merged.LogLevel != old.LogLevel {
+		logger.SetLevelByString(merged.LogLevel)
+	}
+	if merged.LogFormat != old.LogFormat {
+		logger.SetFormat(merged.LogFormat)
+	}
+
+	setRedactPrefixes(merged)
+	// the cached responses of the profiles are keyed by the names.
+	if !reflect.DeepEqual(old.SelfProfiles, merged.SelfProfiles) {
+		m.cache.clear()
+	}
+
+	m.configLock.Lock()
+	m.config = merged
+	m.trustedProxies = proxies
+	m.configLock.Unlock()
+	m.applyNamespaces(merged)
+	return restartRequired, nil
+}
+
+// mergeReloadable return a copy of old config with the reloadable options from new config,
+// and the yaml names of other changed options.
+func mergeReloadable(old *Config, new *Config) (*Config, []string) {
+	merged := *old
+	mergedValue := reflect.ValueOf(&merged).Elem()
+	newValue := reflect.ValueOf(new).Elem()
+	configType := mergedValue.Type()
+	var restartRequired []string
+	for i := 0; i < configType.NumField(); i++ {
+		name := strings.Split(configType.Field(i).Tag.Get("yaml"), ",")[0]
+		if reloadableOptions[name] {
+			mergedValue.Field(i).Set(newValue.Field(i))
+		} else if !reflect.DeepEqual(mergedValue.Field(i).Interface(), newValue.Field(i).Interface()) {
+			restartRequired = append(restartRequired, name)
+		}
+	}
+	return &merged, restartRequired
+}
+
+func (m *Metad) watchReload() {
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go func() {
+		for range reload {
+			logger.Info("Received reload signal")
+			if err := m.Reload(); err != nil {
+				logger.Error("Reload config error: %s", err.Error())
+			}
+		}
+	}()
+}
@@ -0,0 +1,276 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metad
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/user"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"openpitrix.io/metad/pkg/confd"
+	"openpitrix.io/metad/pkg/flatmap"
+	"openpitrix.io/metad/pkg/logger"
+)
+
+const (
+	// renderInterval is the interval of checking the changes of the render values.
+	renderInterval = time.Second
+	// renderCmdTimeout bound the check_cmd and reload_cmd.
+	renderCmdTimeout = time.Minute
+	// renderSrcPlaceholder in check_cmd is replaced by the staged file.
+	renderSrcPlaceholder = "{{.src}}"
+)
+
+// RenderStatus is the render result of a render definition since metad started.
+type RenderStatus struct {
+	Name           string `json:"name"`
+	Dest           string `json:"dest"`
+	Renders        int64  `json:"renders"`
+	Reloads        int64  `json:"reloads"`
+	Failures       int64  `json:"failures"`
+	LastRenderedAt int64  `json:"last_rendered_at,omitempty"`
+	LastFailedAt   int64  `json:"last_failed_at,omitempty"`
+	LastError      string `json:"last_error,omitempty"`
+}
+
+// renderer render the template of the render definition when its values changed.
+type renderer struct {
+	render *confd.Render
+	mode   os.FileMode
+	uid    int
+	gid    int
+	// values are the values of the last render attempt, nil if never attempted.
+	values map[string]string
+	status RenderStatus
+}
+
+type renderSet struct {
+	renderers []*renderer
+	lock      sync.Mutex
+}
+
+func parseRenderMode(mode string) (os.FileMode, error) {
+	if mode == "" {
+		return 0644, nil
+	}
+	m, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || m > 0777 {
+		return 0, fmt.Errorf("invalid mode [%s], should be octal such as 0644.", mode)
+	}
+	return os.FileMode(m), nil
+}
+
+// configRenders check the renders of the config and create the renderers.
+func configRenders(config *Config) (*renderSet, error) {
+	set := &renderSet{}
+	names := map[string]bool{}
+	for i, configRender := range config.Renders {
+		// the defaults are set on the copy, the config is compared on reload.
+		render := *configRender
+		if render.Name == "" {
+			render.Name = fmt.Sprintf("render-%d", i)
+		}
+		if names[render.Name] {
+			return nil, fmt.Errorf("duplicate render name [%s].", render.Name)
+		}
+		names[render.Name] = true
+		if render.Src == "" || render.Dest == "" {
+			return nil, fmt.Errorf("render [%s] src and dest are required.", render.Name)
+		}
+		render.Prefix = path.Join("/", render.Prefix)
+		if isSelfPath(render.Prefix) && config.RenderHost == "" {
+			return nil, fmt.Errorf("render [%s] prefix [%s] require render_host.", render.Name, render.Prefix)
+		}
+		if len(render.Keys) == 0 {
+			render.Keys = []string{"/"}
+		}
+		r := &renderer{render: &render, uid: -1, gid: -1, status: RenderStatus{Name: render.Name, Dest: render.Dest}}
+		var err error
+		if r.mode, err = parseRenderMode(render.Mode); err != nil {
+			return nil, fmt.Errorf("render [%s] %s", render.Name, err.Error())
+		}
+		if render.Owner != "" {
+			u, err := user.Lookup(render.Owner)
+			if err != nil {
+				return nil, fmt.Errorf("render [%s] owner [%s] error: %s", render.Name, render.Owner, err.Error())
+			}
+			r.uid, _ = strconv.Atoi(u.Uid)
+		}
+		if render.UID != nil {
+			r.uid = int(*render.UID)
+		}
+		if render.GID != nil {
+			r.gid = int(*render.GID)
+		}
+		set.renderers = append(set.renderers, r)
+	}
+	return set, nil
+}
+
+func isSelfPath(p string) bool {
+	return p == "/self" || strings.HasPrefix(p, "/self/")
+}
+
+// renderValues read the keys of the render as flat values, the /self paths are the view of render_host.
+func (m *Metad) renderValues(render *confd.Render) map[string]string {
+	values := map[string]string{}
+	host := m.getConfig().RenderHost
+	for _, key := range render.Keys {
+		key = path.Join("/", key)
+		p := path.Join(render.Prefix, key)
+		var val interface{}
+		if isSelfPath(p) {
+			val = m.metadataRepo.Self(host, strings.TrimPrefix(p, "/self"))
+		} else {
+			val = m.metadataRepo.GetData(p)
+		}
+		switch t := val.(type) {
+		case nil:
+		case map[string]interface{}, []interface{}:
+			for k, v := range flatmap.Flatten(t) {
+				values[path.Join(key, k)] = v
+			}
+		default:
+			values[key] = fmt.Sprintf("%v", t)
+		}
+	}
+	return values
+}
+
+// runRenders render the changed renders every renderInterval after the backend synced, until metad stopped.
+func (m *Metad) runRenders() {
+	if len(m.renders.renderers) == 0 {
+		return
+	}
+	ticker := time.NewTicker(renderInterval)
+	defer ticker.Stop()
+	var dataVersion, mappingVersion int64 = -1, -1
+	for {
+		select {
+		case <-ticker.C:
+			if synced, _, _ := m.metadataRepo.SyncStatus(); !synced {
+				continue
+			}
+			dv, mv := m.metadataRepo.DataVersion(), m.metadataRepo.MappingVersion()
+			if dv == dataVersion && mv == mappingVersion {
+				continue
+			}
+			dataVersion, mappingVersion = dv, mv
+			for _, r := range m.renders.renderers {
+				values := m.renderValues(r.render)
+				if r.values != nil && reflect.DeepEqual(values, r.values) {
+					continue
+				}
+				r.values = values
+				m.renderOnce(r)
+			}
+		case <-m.shutdownChan:
+			return
+		}
+	}
+}
+
+func (m *Metad) renderOnce(r *renderer) {
+	reloaded, err := r.renderFile()
+	now := time.Now().Unix()
+	m.renders.lock.Lock()
+	defer m.renders.lock.Unlock()
+	if err != nil {
+		r.status.Failures++
+		r.status.LastFailedAt = now
+		r.status.LastError = err.Error()
+		logger.Error("Render [%s] to [%s] error: %s", r.render.Name, r.render.Dest, err.Error())
+		return
+	}
+	r.status.Renders++
+	r.status.LastRenderedAt = now
+	r.status.LastError = ""
+	if reloaded {
+		r.status.Reloads++
+	}
+}
+
+// renderFile render the template to a staged file besides dest, check it by check_cmd, replace dest and run the
+// reload_cmd, return whether reloaded. The dest is kept if unchanged, or the render failed.
+func (r *renderer) renderFile() (bool, error) {
+	render := r.render
+	content, err := confd.RenderTemplate(render.Src, r.values)
+	if err != nil {
+		return false, err
+	}
+	if old, err := ioutil.ReadFile(render.Dest); err == nil && bytes.Equal(old, content) {
+		if info, err := os.Stat(render.Dest); err == nil && info.Mode().Perm() == r.mode {
+			return false, nil
+		}
+	}
+	dir, base := filepath.Split(render.Dest)
+	staged, err := ioutil.TempFile(dir, "."+base+".metad-")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(staged.Name())
+	_, err = staged.Write(content)
+	if closeErr := staged.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(staged.Name(), r.mode)
+	}
+	if err == nil && (r.uid >= 0 || r.gid >= 0) {
+		err = os.Chown(staged.Name(), r.uid, r.gid)
+	}
+	if err != nil {
+		return false, err
+	}
+	if render.CheckCmd != "" {
+		if err := runRenderCmd(strings.Replace(render.CheckCmd, renderSrcPlaceholder, staged.Name(), -1)); err != nil {
+			return false, fmt.Errorf("check_cmd error: %s", err.Error())
+		}
+	}
+	if err := os.Rename(staged.Name(), render.Dest); err != nil {
+		return false, err
+	}
+	logger.Info("Render [%s] updated [%s].", render.Name, render.Dest)
+	if render.ReloadCmd == "" {
+		return false, nil
+	}
+	if err := runRenderCmd(render.ReloadCmd); err != nil {
+		return false, fmt.Errorf("reload_cmd error: %s", err.Error())
+	}
+	return true, nil
+}
+
+func runRenderCmd(cmd string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), renderCmdTimeout)
+	defer cancel()
+	output, err := exec.CommandContext(ctx, "/bin/sh", "-c", cmd).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("[%s] %s, output: %s", cmd, err.Error(), strings.TrimSpace(string(output)))
+	}
+	logger.Debug("Render command [%s] output: %s", cmd, output)
+	return nil
+}
+
+func (m *Metad) renderList(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	m.renders.lock.Lock()
+	defer m.renders.lock.Unlock()
+	result := make([]RenderStatus, 0, len(m.renders.renderers))
+	for _, r := range m.renders.renderers {
+		result = append(result, r.status)
+	}
+	return result, nil
+}
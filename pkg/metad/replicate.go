@@ -0,0 +1,145 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metad
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"openpitrix.io/metad/pkg/logger"
+	"openpitrix.io/metad/pkg/metadata"
+)
+
+// replicateHeartbeatInterval is the interval of the heartbeat comments of the replicate stream, so the replica can
+// detect the broken connection.
+const replicateHeartbeatInterval = 10 * time.Second
+
+// replicaLocalPaths are the manage api writes served by the read replica itself, as they do not write the backend.
+var replicaLocalPaths = map[string]bool{
+	backendEndpointsPath: true,
+	"/v1/sync:pause":     true,
+	"/v1/sync:resume":    true,
+	"/v1/sync:resync":    true,
+	"/v1/verify":         true,
+	"/v1/record":         true,
+}
+
+// checkReplica reject the manage api writes of the read replica (the metad backend), so the clients are told to write
+// to the upstream instead of the backend error.
+func (m *Metad) checkReplica(req *http.Request) *HttpError {
+	switch strings.ToUpper(req.Method) {
+	case "GET", "HEAD", "OPTIONS":
+		return nil
+	}
+	config := m.getConfig()
+	if config.Backend != "metad" || replicaLocalPaths[req.URL.Path] || isDryRun(req) {
+		return nil
+	}
+	if strings.HasPrefix(req.URL.Path, "/v1/subscription/") && strings.HasSuffix(req.URL.Path, "/test") {
+		return nil
+	}
+	return NewHttpError(http.StatusForbidden, fmt.Sprintf("metad is a read-only replica, send the writes to the upstream metad %v.", config.BackendNodes))
+}
+
+// replicateHandler stream the updates of the kind as server-sent events to the read replica (the metad backend)
+// until the replica disconnected or metad stopped. Every event is `event: snapshot` or `event: events` with the
+// ReplicaUpdate json as data.
+func (m *Metad) replicateHandler(w http.ResponseWriter, req *http.Request) {
+	start := time.Now()
+	requestID := m.generateRequestID()
+	ctx := context.WithValue(req.Context(), "requestID", requestID)
+	kind := mux.Vars(req)["kind"]
+	w.Header().Add("X-Metad-RequestID", requestID)
+	fail := func(httpErr *HttpError) {
+		respondError(w, req, httpErr.Message, httpErr.Status)
+		m.errorLog(requestID, req, httpErr.Status, httpErr.Message)
+		m.requestLog(requestID, m.metadataRepo.DataVersion(), req, httpErr.Status, time.Since(start), 0)
+	}
+	httpErr := m.authorizeRequest(ctx, req, AuthzAPIManage)
+	if httpErr == nil {
+		httpErr = m.checkRole(req)
+	}
+	if httpErr != nil {
+		fail(httpErr)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		fail(NewHttpError(http.StatusInternalServerError, "streaming is not supported."))
+		return
+	}
+
+	stopChan := make(chan struct{})
+	go func() {
+		select {
+		case <-req.Context().Done():
+		case <-m.shutdownChan:
+		}
+		close(stopChan)
+	}()
+	var lock sync.Mutex
+	started := false
+	bytes := 0
+	write := func(s string) error {
+		lock.Lock()
+		defer lock.Unlock()
+		if !started {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Header().Set("Cache-Control", "no-cache")
+			w.WriteHeader(http.StatusOK)
+			started = true
+		}
+		n, err := fmt.Fprint(w, s)
+		bytes += n
+		if err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+	go func() {
+		ticker := time.NewTicker(replicateHeartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				write(": heartbeat\n\n")
+			case <-stopChan:
+				return
+			}
+		}
+	}()
+	err := m.metadataRepo.Replicate(kind, stopChan, func(update *metadata.ReplicaUpdate) error {
+		b, err := json.Marshal(update)
+		if err != nil {
+			return err
+		}
+		event := "events"
+		if update.Snapshot {
+			event = "snapshot"
+		}
+		return write(fmt.Sprintf("event: %s\ndata: %s\n\n", event, b))
+	})
+	lock.Lock()
+	wrote := started
+	started = true
+	lock.Unlock()
+	if !wrote {
+		// nothing sent as the kind is unknown.
+		fail(NewHttpError(http.StatusBadRequest, err.Error()))
+		return
+	}
+	if err != nil {
+		logger.WithFields(logger.Fields{"request_id": requestID}).Info("Replicate [%s] to %s stopped: %s", kind, m.requestIP(req), err.Error())
+	}
+	m.requestLog(requestID, m.metadataRepo.DataVersion(), req, http.StatusOK, time.Since(start), bytes)
+}
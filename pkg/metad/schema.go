@@ -0,0 +1,43 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metad
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"openpitrix.io/metad/pkg/store"
+)
+
+// schemaList list the published wire schemas, for the non-Go consumers to check the compatibility.
+func (m *Metad) schemaList(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	return map[string]interface{}{
+		"event": map[string]interface{}{
+			"current":  store.EventSchemaVersion,
+			"versions": store.EventSchemaVersions(),
+		},
+		"data": m.dataSchemaPrefixes(),
+	}, nil
+}
+
+func (m *Metad) eventSchemaGet(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	version, err := strconv.Atoi(mux.Vars(req)["version"])
+	if err != nil {
+		return nil, NewHttpError(http.StatusBadRequest, "schema version should be integer.")
+	}
+	schema, ok := store.EventSchema(version)
+	if !ok {
+		return nil, NewHttpError(http.StatusNotFound, "Not found")
+	}
+	var doc interface{}
+	if err := json.Unmarshal([]byte(schema), &doc); err != nil {
+		return nil, NewServerError(err)
+	}
+	return doc, nil
+}
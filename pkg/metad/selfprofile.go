@@ -0,0 +1,135 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metad
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"openpitrix.io/metad/pkg/store"
+)
+
+// SelfProfile is a named projection of the /self response, selected by the profile query parameter, so the agents
+// fetch only the mapped keys they need.
+type SelfProfile struct {
+	Name string `yaml:"name"`
+	// Keys is the paths relative to /self returned, such as host/ip, a dir key return its subtree.
+	Keys []string `yaml:"keys"`
+}
+
+// checkSelfProfiles check the self_profiles config, every profile should have an unique name and keys.
+func checkSelfProfiles(config *Config) error {
+	names := map[string]bool{}
+	for _, profile := range config.SelfProfiles {
+		if profile == nil || profile.Name == "" {
+			return errors.New("self_profiles should have name.")
+		}
+		if names[profile.Name] {
+			return fmt.Errorf("duplicate self profile [%s].", profile.Name)
+		}
+		names[profile.Name] = true
+		if len(profile.Keys) == 0 {
+			return fmt.Errorf("self profile [%s] should have keys.", profile.Name)
+		}
+		for _, key := range profile.Keys {
+			if path.Join("/", key) == "/" {
+				return fmt.Errorf("invalid key [%s] of self profile [%s], should be a path under /self such as host/ip.", key, profile.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// selfProfileKeys return the keys of the profile query parameter as the absolute paths relative to /self, nil if not
+// present.
+func (m *Metad) selfProfileKeys(req *http.Request) ([]string, *HttpError) {
+	name := req.FormValue("profile")
+	if name == "" {
+		return nil, nil
+	}
+	for _, profile := range m.getConfig().SelfProfiles {
+		if profile.Name == name {
+			keys := make([]string, 0, len(profile.Keys))
+			for _, key := range profile.Keys {
+				keys = append(keys, path.Join("/", key))
+			}
+			return keys, nil
+		}
+	}
+	return nil, NewHttpError(http.StatusBadRequest, fmt.Sprintf("unknown profile [%s].", name))
+}
+
+// profileSelf keep the values of the profile keys in the self result of nodePath, nil if nodePath is a leaf not
+// selected, the dir without selected keys is empty.
+func profileSelf(val interface{}, nodePath string, keys []string) interface{} {
+	nodePath = path.Join("/", nodePath)
+	var result map[string]interface{}
+	for _, key := range keys {
+		if profileCovers(key, nodePath) {
+			return val
+		}
+		if nodePath != "/" && !strings.HasPrefix(key, nodePath+"/") {
+			continue
+		}
+		names := strings.Split(strings.TrimPrefix(key[len(nodePath):], "/"), "/")
+		v, ok := val, true
+		for _, name := range names {
+			var dir map[string]interface{}
+			if dir, ok = v.(map[string]interface{}); ok {
+				v, ok = dir[name]
+			}
+			if !ok {
+				break
+			}
+		}
+		if !ok {
+			continue
+		}
+		if result == nil {
+			result = map[string]interface{}{}
+		}
+		dir := result
+		for _, name := range names[:len(names)-1] {
+			child, _ := dir[name].(map[string]interface{})
+			if child == nil {
+				child = map[string]interface{}{}
+				dir[name] = child
+			}
+			dir = child
+		}
+		dir[names[len(names)-1]] = v
+	}
+	if result == nil {
+		if _, ok := val.(map[string]interface{}); !ok {
+			return nil
+		}
+		result = map[string]interface{}{}
+	}
+	return result
+}
+
+// profileEvents drop the events of the self watch of nodePath out of the profile keys.
+func profileEvents(events []*store.Event, nodePath string, keys []string) []*store.Event {
+	nodePath = path.Join("/", nodePath)
+	result := make([]*store.Event, 0, len(events))
+	for _, e := range events {
+		eventPath := path.Join(nodePath, e.Path)
+		for _, key := range keys {
+			if profileCovers(key, eventPath) || profileCovers(eventPath, key) {
+				result = append(result, e)
+				break
+			}
+		}
+	}
+	return result
+}
+
+// profileCovers return whether nodePath is the key or under it.
+func profileCovers(key string, nodePath string) bool {
+	return key == "/" || nodePath == key || strings.HasPrefix(nodePath, key+"/")
+}
@@ -0,0 +1,50 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metad
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"openpitrix.io/metad/pkg/logger"
+)
+
+var errShuttingDown = NewHttpError(http.StatusServiceUnavailable, "metad is shutting down.")
+
+// addServer register the server to be drained when shutdown.
+func (m *Metad) addServer(server *http.Server) {
+	m.serverLock.Lock()
+	defer m.serverLock.Unlock()
+	m.servers = append(m.servers, server)
+}
+
+func (m *Metad) shuttingDown() bool {
+	select {
+	case <-m.shutdownChan:
+		return true
+	default:
+		return false
+	}
+}
+
+// drain stop accepting new requests, and wait the in-flight requests finish until drain_timeout,
+// the long-polls are interrupted by shutdownChan and respond errShuttingDown.
+func (m *Metad) drain() {
+	m.serverLock.Lock()
+	servers := m.servers
+	m.serverLock.Unlock()
+
+	drainTimeout := time.Duration(m.getConfig().DrainTimeout) * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+	for _, server := range servers {
+		logger.Info("Draining %s", server.Addr)
+		if err := server.Shutdown(ctx); err != nil {
+			logger.Warn("Drain %s error: %s, close the remaining connections.", server.Addr, err.Error())
+			server.Close()
+		}
+	}
+}
@@ -0,0 +1,256 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metad
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// the api groups of the slo tracking.
+const (
+	SLOGroupMetadata = "metadata"
+	SLOGroupManage   = "manage"
+)
+
+const (
+	sloBucketDuration   = time.Minute
+	sloMetricsInterval  = 10 * time.Second
+	sloMaxWindowBuckets = 24 * 60
+
+	defaultSLOAvailability  = 0.999
+	defaultSLOLatencyMs     = 100
+	defaultSLOLatencyTarget = 0.99
+)
+
+// sloWindows are the rolling windows of the slo attainment, the longest is covered by sloMaxWindowBuckets.
+var sloWindows = []struct {
+	name     string
+	duration time.Duration
+}{
+	{"5m", 5 * time.Minute},
+	{"1h", time.Hour},
+	{"6h", 6 * time.Hour},
+	{"24h", 24 * time.Hour},
+}
+
+var (
+	sloAttainment = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "metad_slo_attainment",
+		Help: "The ratio of the good requests of the api group in the rolling window, by slo availability or latency.",
+	}, []string{"group", "window", "slo"})
+	sloBurnRate = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "metad_slo_burn_rate",
+		Help: "The error budget burn rate of the api group in the rolling window, by slo availability or latency.",
+	}, []string{"group", "window", "slo"})
+)
+
+func init() {
+	prometheus.MustRegister(sloAttainment, sloBurnRate)
+}
+
+// SLOObjectives are the configured objectives of every api group.
+type SLOObjectives struct {
+	Availability  float64 `json:"availability"`
+	LatencyMs     int     `json:"latency_ms"`
+	LatencyTarget float64 `json:"latency_target"`
+}
+
+// SLOWindow is the slo attainment of the api group in the rolling window. The burn rate is the bad ratio divided by
+// the error budget (1 - objective), 1 means the budget is exactly used up at the end of the window.
+// The long-poll (wait=true) requests are not counted by the latency slo.
+type SLOWindow struct {
+	Window               string  `json:"window"`
+	Requests             int64   `json:"requests"`
+	Errors               int64   `json:"errors"`
+	Availability         float64 `json:"availability"`
+	AvailabilityBurnRate float64 `json:"availability_burn_rate"`
+	ErrorBudgetRemaining float64 `json:"error_budget_remaining"`
+	LatencyRequests      int64   `json:"latency_requests"`
+	Slow                 int64   `json:"slow"`
+	LatencyAttainment    float64 `json:"latency_attainment"`
+	LatencyBurnRate      float64 `json:"latency_burn_rate"`
+}
+
+// SLOGroup is the slo attainment of the api group in every window.
+type SLOGroup struct {
+	Group   string       `json:"group"`
+	Windows []*SLOWindow `json:"windows"`
+}
+
+// SLOReport is the response of /v1/slo.
+type SLOReport struct {
+	Objectives *SLOObjectives `json:"objectives"`
+	Groups     []*SLOGroup    `json:"groups"`
+}
+
+type sloBucket struct {
+	// minute is the unix minute of the bucket, the bucket is reused when the minute changed.
+	minute          int64
+	requests        int64
+	errors          int64
+	latencyRequests int64
+	slow            int64
+}
+
+type sloSeries struct {
+	buckets [sloMaxWindowBuckets]sloBucket
+}
+
+// sloTracker count the requests of every api group in per minute buckets of the longest window.
+type sloTracker struct {
+	series map[string]*sloSeries
+	lock   sync.Mutex
+}
+
+func newSLOTracker() *sloTracker {
+	return &sloTracker{series: map[string]*sloSeries{
+		SLOGroupMetadata: {},
+		SLOGroupManage:   {},
+	}}
+}
+
+// record count the request, the request is error if status is 5xx except the 504 of long-poll, and slow if
+// the latency exceeds latency, the long-poll is not counted by the latency.
+func (t *sloTracker) record(group string, status int, elapsed time.Duration, wait bool, latency time.Duration, now time.Time) {
+	minute := now.Unix() / int64(sloBucketDuration/time.Second)
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	s, ok := t.series[group]
+	if !ok {
+		return
+	}
+	b := &s.buckets[minute%sloMaxWindowBuckets]
+	if b.minute != minute {
+		*b = sloBucket{minute: minute}
+	}
+	b.requests++
+	if status >= 500 && !(wait && status == http.StatusGatewayTimeout) {
+		b.errors++
+	}
+	if !wait {
+		b.latencyRequests++
+		if elapsed > latency {
+			b.slow++
+		}
+	}
+}
+
+// sum return the total of the buckets in the window ending at now.
+func (t *sloTracker) sum(group string, window time.Duration, now time.Time) sloBucket {
+	minute := now.Unix() / int64(sloBucketDuration/time.Second)
+	n := int64(window / sloBucketDuration)
+	total := sloBucket{}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	s, ok := t.series[group]
+	if !ok {
+		return total
+	}
+	for i := range s.buckets {
+		b := &s.buckets[i]
+		if b.minute > minute-n && b.minute <= minute {
+			total.requests += b.requests
+			total.errors += b.errors
+			total.latencyRequests += b.latencyRequests
+			total.slow += b.slow
+		}
+	}
+	return total
+}
+
+// report return the slo attainment of every group and window by the objectives.
+func (t *sloTracker) report(objectives *SLOObjectives, now time.Time) *SLOReport {
+	report := &SLOReport{Objectives: objectives}
+	for _, group := range []string{SLOGroupMetadata, SLOGroupManage} {
+		g := &SLOGroup{Group: group}
+		for _, window := range sloWindows {
+			total := t.sum(group, window.duration, now)
+			w := &SLOWindow{Window: window.name, Requests: total.requests, Errors: total.errors,
+				LatencyRequests: total.latencyRequests, Slow: total.slow}
+			w.Availability, w.AvailabilityBurnRate = attainment(total.requests, total.errors, objectives.Availability)
+			w.ErrorBudgetRemaining = 1 - w.AvailabilityBurnRate
+			w.LatencyAttainment, w.LatencyBurnRate = attainment(total.latencyRequests, total.slow, objectives.LatencyTarget)
+			g.Windows = append(g.Windows, w)
+		}
+		report.Groups = append(report.Groups, g)
+	}
+	return report
+}
+
+// attainment return the good ratio and the error budget burn rate, the window without request is fully attained.
+func attainment(requests, bad int64, objective float64) (float64, float64) {
+	if requests == 0 {
+		return 1, 0
+	}
+	badRatio := float64(bad) / float64(requests)
+	return 1 - badRatio, badRatio / (1 - objective)
+}
+
+// checkSLOConfig check the slo objectives, 0 means the default.
+func checkSLOConfig(config *Config) error {
+	if config.SLOAvailability < 0 || config.SLOAvailability >= 1 {
+		return errors.New("slo_availability should be between 0 and 1.")
+	}
+	if config.SLOLatencyTarget < 0 || config.SLOLatencyTarget >= 1 {
+		return errors.New("slo_latency_target should be between 0 and 1.")
+	}
+	if config.SLOLatencyMs < 0 {
+		return errors.New("slo_latency_ms should not be negative.")
+	}
+	return nil
+}
+
+func (m *Metad) sloObjectives() *SLOObjectives {
+	config := m.getConfig()
+	objectives := &SLOObjectives{Availability: config.SLOAvailability, LatencyMs: config.SLOLatencyMs, LatencyTarget: config.SLOLatencyTarget}
+	if objectives.Availability == 0 {
+		objectives.Availability = defaultSLOAvailability
+	}
+	if objectives.LatencyMs == 0 {
+		objectives.LatencyMs = defaultSLOLatencyMs
+	}
+	if objectives.LatencyTarget == 0 {
+		objectives.LatencyTarget = defaultSLOLatencyTarget
+	}
+	return objectives
+}
+
+func (m *Metad) recordSLO(group string, req *http.Request, status int, elapsed time.Duration) {
+	wait := strings.ToLower(req.FormValue("wait")) == "true"
+	latency := time.Duration(m.sloObjectives().LatencyMs) * time.Millisecond
+	m.slo.record(group, status, elapsed, wait, latency, time.Now())
+}
+
+// updateSLOMetrics refresh the slo gauges every sloMetricsInterval until metad stopped.
+func (m *Metad) updateSLOMetrics() {
+	ticker := time.NewTicker(sloMetricsInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			for _, g := range m.slo.report(m.sloObjectives(), now).Groups {
+				for _, w := range g.Windows {
+					sloAttainment.WithLabelValues(g.Group, w.Window, "availability").Set(w.Availability)
+					sloAttainment.WithLabelValues(g.Group, w.Window, "latency").Set(w.LatencyAttainment)
+					sloBurnRate.WithLabelValues(g.Group, w.Window, "availability").Set(w.AvailabilityBurnRate)
+					sloBurnRate.WithLabelValues(g.Group, w.Window, "latency").Set(w.LatencyBurnRate)
+				}
+			}
+		case <-m.shutdownChan:
+			return
+		}
+	}
+}
+
+func (m *Metad) sloGet(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	return m.slo.report(m.sloObjectives(), time.Now()), nil
+}
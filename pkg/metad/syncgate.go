@@ -0,0 +1,80 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metad
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"openpitrix.io/metad/pkg/metadata"
+)
+
+type syncRequest struct {
+	Prefix string `json:"prefix"`
+}
+
+func syncPrefix(req *http.Request) (string, *HttpError) {
+	decoder := json.NewDecoder(req.Body)
+	var syncReq syncRequest
+	err := decoder.Decode(&syncReq)
+	if err != nil {
+		return "", NewHttpError(http.StatusBadRequest, fmt.Sprintf("invalid json format, error:%s", err.Error()))
+	}
+	if syncReq.Prefix == "" {
+		return "", NewHttpError(http.StatusBadRequest, "prefix should not be empty")
+	}
+	return syncReq.Prefix, nil
+}
+
+func syncError(err error) *HttpError {
+	if metadata.IsSyncStateError(err) {
+		return NewHttpError(http.StatusConflict, err.Error())
+	}
+	return NewServerError(err)
+}
+
+func (m *Metad) syncGet(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	return map[string]interface{}{"paused": m.metadataRepo.PausedSyncs()}, nil
+}
+
+func (m *Metad) syncPause(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	prefix, httpErr := syncPrefix(req)
+	if httpErr != nil {
+		return nil, httpErr
+	}
+	if err := m.metadataRepo.PauseSync(prefix); err != nil {
+		return nil, syncError(err)
+	}
+	requestLogger(ctx).Info("Pause sync of prefix [%s]", prefix)
+	return nil, nil
+}
+
+func (m *Metad) syncResume(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	prefix, httpErr := syncPrefix(req)
+	if httpErr != nil {
+		return nil, httpErr
+	}
+	result, err := m.metadataRepo.ResumeSync(prefix)
+	if err != nil {
+		return nil, syncError(err)
+	}
+	requestLogger(ctx).Info("Resume sync of prefix [%s]", prefix)
+	return result, nil
+}
+
+func (m *Metad) syncResync(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	prefix, httpErr := syncPrefix(req)
+	if httpErr != nil {
+		return nil, httpErr
+	}
+	result, err := m.metadataRepo.ResyncData(prefix)
+	if err != nil {
+		return nil, syncError(err)
+	}
+	requestLogger(ctx).Info("Resync prefix [%s]", prefix)
+	return result, nil
+}
@@ -0,0 +1,64 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metad
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"openpitrix.io/metad/pkg/confd"
+	"openpitrix.io/metad/pkg/flatmap"
+)
+
+// defaultTemplateMediaType is the media type of the rendered template without the content_type annotation.
+const defaultTemplateMediaType = "text/plain; charset=utf-8"
+
+// templateHandler render the template stored at template_prefix/{name} against the self subtree of the client.
+// The self subtree is the dot of the template, and the keys of the confd template functions (such as getv) are
+// relative to /self.
+func (m *Metad) templateHandler(ctx context.Context, req *http.Request) (currentVersion int64, result interface{}, httpErr *HttpError) {
+	host, repo, httpErr := m.clientRepo(req)
+	if httpErr != nil {
+		return
+	}
+	prefix := path.Join("/", m.getConfig().TemplatePrefix)
+	templatePath := path.Join(prefix, mux.Vars(req)["name"])
+	if !strings.HasPrefix(templatePath, strings.TrimSuffix(prefix, "/")+"/") {
+		httpErr = NewHttpError(http.StatusNotFound, "Template not found")
+		return
+	}
+	currentVersion = m.metadataRepo.DataVersion()
+	content, ok := m.metadataRepo.GetData(templatePath).(string)
+	if !ok {
+		httpErr = NewHttpError(http.StatusNotFound, "Template not found")
+		return
+	}
+	self := repo.Self(host, "/")
+	if self == nil {
+		httpErr = NewHttpError(http.StatusNotFound, "Not found")
+		return
+	}
+	values := map[string]string{}
+	if selfMap, ok := self.(map[string]interface{}); ok {
+		for k, v := range flatmap.Flatten(selfMap) {
+			values[path.Join("/", k)] = v
+		}
+	}
+	rendered, err := confd.ExecuteTemplate(templatePath, content, self, values)
+	if err != nil {
+		httpErr = NewHttpError(http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	mediaType := defaultTemplateMediaType
+	if annotation := m.metadataRepo.GetAnnotation(templatePath); annotation != nil && annotation.ContentType != "" {
+		mediaType = annotation.ContentType
+	}
+	result = &typedLeaf{value: string(rendered), mediaType: mediaType}
+	return
+}
@@ -0,0 +1,141 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metad
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"openpitrix.io/metad/pkg/metadata"
+)
+
+// The tiers of the tiered read, responded in the X-Metad-Read-Source header.
+const (
+	ReadSourceLocal   = "local"
+	ReadSourcePeer    = "peer"
+	ReadSourceBackend = "backend"
+)
+
+// readSource is put to the request context by handleWrapper, the handler set the tier which served the read.
+type readSource struct {
+	tier string
+}
+
+func setReadSource(ctx context.Context, tier string) {
+	if source, ok := ctx.Value("readSource").(*readSource); ok {
+		source.tier = tier
+	}
+}
+
+var peerClient = &http.Client{}
+
+// localStale return whether the local store may miss the recent writes, serving the cache file,
+// the backend has not been synced or the sync lag exceeds the ready_max_sync_lag.
+func (m *Metad) localStale() bool {
+	if m.servingStale() {
+		return true
+	}
+	synced, lag, err := m.metadataRepo.SyncStatus()
+	maxLag := time.Duration(m.getConfig().ReadyMaxSyncLag) * time.Second
+	return err != nil || !synced || (maxLag > 0 && lag > maxLag)
+}
+
+// tieredStale return whether the reads should skip the local response cache and try the other tiers.
+func (m *Metad) tieredStale() bool {
+	return m.getConfig().ReadBudget > 0 && m.localStale()
+}
+
+// tieredRead return the local result if it is present and not stale, otherwise try the read_peers in order and then
+// the backend within the read_budget, the first present result is returned.
+// The local result is returned if all the tiers miss or the budget is exhausted.
+func (m *Metad) tieredRead(ctx context.Context, repo *metadata.MetadataRepo, clientIP string, nodePath string, self bool, local interface{}) interface{} {
+	config := m.getConfig()
+	if config.ReadBudget <= 0 {
+		return local
+	}
+	setReadSource(ctx, ReadSourceLocal)
+	if local != nil && !m.localStale() {
+		return local
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(config.ReadBudget)*time.Millisecond)
+	defer cancel()
+	read := func(fetch metadata.Fetcher) (interface{}, error) {
+		if self {
+			return repo.SelfFrom(clientIP, nodePath, fetch)
+		}
+		return repo.RootFrom(clientIP, nodePath, fetch)
+	}
+	for _, peer := range config.ReadPeers {
+		result, err := read(peerFetcher(ctx, peer))
+		if err != nil {
+			requestLogger(ctx).Warn("Tiered read from peer [%s] error: %s", peer, err.Error())
+			continue
+		}
+		if result != nil {
+			setReadSource(ctx, ReadSourcePeer)
+			return result
+		}
+	}
+	result, err := read(m.backendFetcher(ctx))
+	if err != nil {
+		requestLogger(ctx).Warn("Tiered read from backend error: %s", err.Error())
+	} else if result != nil {
+		setReadSource(ctx, ReadSourceBackend)
+		return result
+	}
+	return local
+}
+
+// peerFetcher read the data by the manage api of the peer metad.
+func peerFetcher(ctx context.Context, peer string) metadata.Fetcher {
+	return func(nodePath string) (interface{}, error) {
+		req, err := http.NewRequestWithContext(ctx, "GET", strings.TrimRight(peer, "/")+"/v1/data"+nodePath, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", "application/json")
+		resp, err := peerClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, nil
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+		}
+		var val interface{}
+		if err := json.NewDecoder(resp.Body).Decode(&val); err != nil {
+			return nil, err
+		}
+		return val, nil
+	}
+}
+
+// backendFetcher read the data from the backend, give up when the ctx is done.
+func (m *Metad) backendFetcher(ctx context.Context) metadata.Fetcher {
+	type fetched struct {
+		val interface{}
+		err error
+	}
+	return func(nodePath string) (interface{}, error) {
+		ch := make(chan fetched, 1)
+		go func() {
+			val, err := m.metadataRepo.GetBackendData(nodePath)
+			ch <- fetched{val, err}
+		}()
+		select {
+		case f := <-ch:
+			return f.val, f.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
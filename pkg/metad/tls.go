@@ -0,0 +1,150 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metad
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"openpitrix.io/metad/pkg/logger"
+)
+
+// certCheckInterval is the interval of checking the listener cert files for rotation.
+const certCheckInterval = 10 * time.Second
+
+// serverCerts serve the server cert and the client ca of the metadata listener from the files, and load them again
+// when the files modified. The new handshakes use the rotated cert and ca, the established connections (such as the
+// long-poll watches) are kept.
+type serverCerts struct {
+	certFile string
+	keyFile  string
+	caFile   string
+	lock     sync.RWMutex
+	cert     *tls.Certificate
+	clientCA *x509.CertPool
+	modTimes map[string]time.Time
+}
+
+func newServerCerts(certFile, keyFile, caFile string) (*serverCerts, error) {
+	c := &serverCerts{certFile: certFile, keyFile: keyFile, caFile: caFile, modTimes: map[string]time.Time{}}
+	if _, err := c.reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// reload load the files if any modified since the last load, return whether loaded. The current cert and ca are
+// kept if the files are invalid, such as only one of the cert and key is written yet.
+func (c *serverCerts) reload() (bool, error) {
+	modTimes := map[string]time.Time{}
+	changed := false
+	for _, file := range []string{c.certFile, c.keyFile, c.caFile} {
+		if file == "" {
+			continue
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			return false, err
+		}
+		modTimes[file] = info.ModTime()
+		if !info.ModTime().Equal(c.modTimes[file]) {
+			changed = true
+		}
+	}
+	if !changed {
+		return false, nil
+	}
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return false, err
+	}
+	var pool *x509.CertPool
+	if c.caFile != "" {
+		caBytes, err := ioutil.ReadFile(c.caFile)
+		if err != nil {
+			return false, err
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caBytes) {
+			return false, errors.New("no valid certificate in tls client ca file.")
+		}
+	}
+	c.lock.Lock()
+	c.cert, c.clientCA, c.modTimes = &cert, pool, modTimes
+	c.lock.Unlock()
+	return true, nil
+}
+
+func (c *serverCerts) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.cert, nil
+}
+
+func (c *serverCerts) getClientCA() *x509.CertPool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.clientCA
+}
+
+// watchCerts check the cert files every certCheckInterval until metad stopped.
+func (m *Metad) watchCerts(certs *serverCerts) {
+	ticker := time.NewTicker(certCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			reloaded, err := certs.reload()
+			if err != nil {
+				logger.Warn("Reload tls cert error, keep the current cert: %v", err)
+			} else if reloaded {
+				logger.Info("Reloaded tls cert %s", certs.certFile)
+			}
+		case <-m.shutdownChan:
+			return
+		}
+	}
+}
+
+// tlsConfig build the tls config of a listener, the cert is served by GetCertificate so it can be rotated, and
+// if client ca is configured, client cert is verified by the current ca, required if requireClientCert.
+func tlsConfig(certs *serverCerts, requireClientCert bool) *tls.Config {
+	tlsConfig := &tls.Config{GetCertificate: certs.getCertificate}
+	if certs.caFile != "" {
+		if requireClientCert {
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		} else {
+			tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+		tlsConfig.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			clientConfig := tlsConfig.Clone()
+			clientConfig.GetConfigForClient = nil
+			clientConfig.ClientCAs = certs.getClientCA()
+			return clientConfig, nil
+		}
+	}
+	return tlsConfig
+}
+
+// certIdentity return the verified client cert's common name, or the first DNS SAN if common name is empty.
+func certIdentity(req *http.Request) string {
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 || len(req.TLS.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	cert := req.TLS.VerifiedChains[0][0]
+	if cert.Subject.CommonName != "" {
+		return cert.Subject.CommonName
+	}
+	if len(cert.DNSNames) > 0 {
+		return cert.DNSNames[0]
+	}
+	return ""
+}
@@ -0,0 +1,122 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metad
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"openpitrix.io/metad/pkg/metadata"
+)
+
+func (m *Metad) tokenList(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	if err := m.authorizeTokenAdmin(req); err != nil {
+		return nil, err
+	}
+	return m.metadataRepo.GetTokens(), nil
+}
+
+func (m *Metad) tokenCreate(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	if err := m.authorizeTokenAdmin(req); err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(req.Body)
+	var token metadata.Token
+	err := decoder.Decode(&token)
+	if err != nil {
+		return nil, NewHttpError(http.StatusBadRequest, fmt.Sprintf("invalid json format, error:%s", err.Error()))
+	}
+	result, err := m.metadataRepo.CreateToken(&token)
+	if err != nil {
+		return nil, NewHttpError(http.StatusBadRequest, err.Error())
+	}
+	return result, nil
+}
+
+func (m *Metad) tokenDelete(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	if err := m.authorizeTokenAdmin(req); err != nil {
+		return nil, err
+	}
+	id := mux.Vars(req)["id"]
+	err := m.metadataRepo.DeleteToken(id)
+	if err != nil {
+		return nil, NewServerError(err)
+	}
+	return nil, nil
+}
+
+// authorizeTokenAdmin check the request is authenticated by the admin_token of config or an admin token,
+// only they can list, create and delete tokens, as an admin token can modify the paths owned by any team.
+func (m *Metad) authorizeTokenAdmin(req *http.Request) *HttpError {
+	secret := bearerToken(req)
+	if secret == "" {
+		return NewHttpError(http.StatusUnauthorized, "admin token is required.")
+	}
+	if adminToken := m.getConfig().AdminToken; adminToken != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(adminToken)) == 1 {
+		return nil
+	}
+	token := m.metadataRepo.GetToken(secret)
+	if token == nil {
+		return NewHttpError(http.StatusUnauthorized, "Invalid token")
+	}
+	if !token.Admin {
+		return NewHttpError(http.StatusForbidden, "admin token is required.")
+	}
+	return nil
+}
+
+func bearerToken(req *http.Request) string {
+	auth := req.Header.Get("Authorization")
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
+
+// requestHost return the client identity used as the key of mapping and access rule,
+// client authenticated by bearer token use the token's host, client with verified tls cert use the cert identity,
+// otherwise use the client ip, or the peer identity for the unix socket.
+func (m *Metad) requestHost(req *http.Request) (string, *HttpError) {
+	if token := bearerToken(req); token != "" {
+		host, ok := m.metadataRepo.GetTokenHost(token)
+		if !ok {
+			return "", NewHttpError(http.StatusUnauthorized, "Invalid token")
+		}
+		return host, nil
+	}
+	if identity := certIdentity(req); identity != "" {
+		return identity, nil
+	}
+	return m.requestIP(req), nil
+}
+
+// mappingVars return the values of the mapping placeholders of the request.
+func (m *Metad) mappingVars(req *http.Request) metadata.MappingVars {
+	vars := metadata.MappingVars{metadata.MappingVarIP: m.requestIP(req)}
+	if secret := bearerToken(req); secret != "" {
+		if token := m.metadataRepo.GetToken(secret); token != nil {
+			vars[metadata.MappingVarHostname] = token.Host
+			vars[metadata.MappingVarTokenSub] = token.Sub
+		}
+	} else if identity := certIdentity(req); identity != "" {
+		vars[metadata.MappingVarHostname] = identity
+	}
+	return vars
+}
+
+// clientRepo return the client identity and the read view of the metadata repo for the request.
+func (m *Metad) clientRepo(req *http.Request) (string, *metadata.MetadataRepo, *HttpError) {
+	host, httpErr := m.requestHost(req)
+	if httpErr != nil {
+		return "", nil, httpErr
+	}
+	return host, m.metadataRepo.ForClient(m.mappingVars(req)), nil
+}
@@ -0,0 +1,108 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metad
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"openpitrix.io/metad/pkg/metadata"
+)
+
+func checkTrashRetention(config *Config) error {
+	if config.TrashRetention < 0 {
+		return errors.New("trash_retention should not be negative.")
+	}
+	return nil
+}
+
+func (m *Metad) trashEnabled() bool {
+	return m.getConfig().TrashRetention > 0
+}
+
+// deleteToTrash move the deleted paths to the trash, so they can be restored until trash_retention passed.
+func (m *Metad) deleteToTrash(ctx context.Context, req *http.Request, paths ...string) error {
+	entry, err := m.metadataRepo.DeleteToTrash(m.requestActor(req), paths...)
+	if err != nil {
+		return err
+	}
+	if entry != nil {
+		requestLogger(ctx).Info("Moved %v to trash [%s]", entry.Paths, entry.ID)
+	}
+	return nil
+}
+
+// expireTrash purge the trash entries older than trash_retention every minute until metad stopped, only by the
+// leader. The entries are kept if the trash is disabled later, until it is enabled again or purged by the trash api.
+func (m *Metad) expireTrash() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			if retention := m.getConfig().TrashRetention; retention > 0 && m.isLeader() {
+				m.metadataRepo.ExpireTrash(now.Add(-time.Duration(retention) * time.Second))
+			}
+		case <-m.shutdownChan:
+			return
+		}
+	}
+}
+
+func (m *Metad) trashList(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	return m.metadataRepo.GetTrashEntries(), nil
+}
+
+func (m *Metad) trashGet(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	entry := m.metadataRepo.GetTrashEntry(mux.Vars(req)["id"])
+	if entry == nil {
+		return nil, NewHttpError(http.StatusNotFound, "Not found")
+	}
+	return entry, nil
+}
+
+// trashRestore put the data of the trash entry back to its paths, respond 409 if any path exists unless overwrite=true.
+func (m *Metad) trashRestore(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	id := mux.Vars(req)["id"]
+	entry := m.metadataRepo.GetTrashEntry(id)
+	if entry == nil {
+		return nil, NewHttpError(http.StatusNotFound, "Not found")
+	}
+	if httpErr := m.authorizeWrite(ctx, req, "restore", entry.Paths...); httpErr != nil {
+		return nil, httpErr
+	}
+	overwrite := strings.ToLower(req.FormValue("overwrite")) == "true"
+	untrack := m.metadataRepo.TrackActor(m.requestActor(req), entry.Paths...)
+	entry, err := m.metadataRepo.RestoreTrash(id, overwrite)
+	if err != nil {
+		untrack()
+		if metadata.IsTrashConflictError(err) {
+			return nil, NewHttpError(http.StatusConflict, err.Error())
+		}
+		return nil, writeError(err, http.StatusInternalServerError)
+	}
+	if entry == nil {
+		untrack()
+		return nil, NewHttpError(http.StatusNotFound, "Not found")
+	}
+	requestLogger(ctx).Info("Restored %v from trash [%s]", entry.Paths, entry.ID)
+	return entry, nil
+}
+
+func (m *Metad) trashDelete(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	id := mux.Vars(req)["id"]
+	if m.metadataRepo.GetTrashEntry(id) == nil {
+		return nil, NewHttpError(http.StatusNotFound, "Not found")
+	}
+	if err := m.metadataRepo.PurgeTrash(id); err != nil {
+		return nil, NewServerError(err)
+	}
+	return nil, nil
+}
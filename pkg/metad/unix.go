@@ -0,0 +1,93 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metad
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+
+	"openpitrix.io/metad/pkg/logger"
+)
+
+// peerCred is the credential of the process connected to the unix socket.
+type peerCred struct {
+	PID int
+	UID int
+	GID int
+}
+
+// defaultUnixSocketMode is the file mode of the unix socket if listen_unix_mode is empty.
+const defaultUnixSocketMode os.FileMode = 0660
+
+// unixSocketMode return the file mode of the unix socket by listen_unix_mode.
+func unixSocketMode(config *Config) (os.FileMode, error) {
+	if config.ListenUnixMode == "" {
+		return defaultUnixSocketMode, nil
+	}
+	mode, err := strconv.ParseUint(config.ListenUnixMode, 8, 32)
+	if err != nil || os.FileMode(mode)&^os.ModePerm != 0 {
+		return 0, fmt.Errorf("invalid listen_unix_mode [%s], should be octal permission bits such as 0660.", config.ListenUnixMode)
+	}
+	return os.FileMode(mode), nil
+}
+
+// watchUnix serve the metadata api on the unix socket if listen_unix present, the peer credential of the connection
+// is kept in the request context as the client identity.
+func (m *Metad) watchUnix() {
+	config := m.getConfig()
+	path := config.ListenUnix
+	if path == "" {
+		return
+	}
+	mode, err := unixSocketMode(config)
+	if err != nil {
+		logger.Fatal("%s", err.Error())
+	}
+	// remove the socket left by the last process.
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		logger.Fatal("Listen on unix socket %s error: %v", path, err)
+	}
+	// the clients are identified by the peer uid, the access is restricted by the socket mode and the directory permission.
+	if err := os.Chmod(path, mode); err != nil {
+		logger.Warn("Chmod unix socket %s error: %v", path, err)
+	}
+	server := &http.Server{Addr: path, Handler: m.router, ConnContext: unixConnContext}
+	m.addServer(server)
+	logger.Info("Listening on unix socket %s", path)
+	go func() {
+		if err := server.Serve(listener); err != http.ErrServerClosed {
+			logger.Error("Unix socket listener error: %v", err)
+		}
+	}()
+}
+
+// unixConnContext keep the peer credential of the unix socket connection in the context, the connection without the
+// credential is closed, as its requests have no client identity and should not fall back to the headers.
+func unixConnContext(ctx context.Context, conn net.Conn) context.Context {
+	cred, err := peerCredential(conn)
+	if err != nil {
+		logger.Warn("Get peer credential of unix socket connection error: %v, close it.", err)
+		conn.Close()
+		return ctx
+	}
+	return context.WithValue(ctx, "peerCred", cred)
+}
+
+// peerIdentity return the identity `unix:{uid}` of the peer process if the request is from the unix socket.
+func peerIdentity(req *http.Request) string {
+	cred, ok := req.Context().Value("peerCred").(*peerCred)
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("unix:%d", cred.UID)
+}
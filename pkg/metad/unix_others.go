@@ -0,0 +1,18 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+//go:build !linux
+// +build !linux
+
+package metad
+
+import (
+	"errors"
+	"net"
+)
+
+// peerCredential is only supported on linux, the requests from the unix socket have no identity otherwise.
+func peerCredential(conn net.Conn) (*peerCred, error) {
+	return nil, errors.New("peer credential is not supported on this platform.")
+}
@@ -0,0 +1,126 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metad
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"openpitrix.io/metad/pkg/logger"
+	"openpitrix.io/metad/pkg/metadata"
+)
+
+var (
+	storeDrift = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "metad_store_drift",
+		Help: "The count of the differences of the store with the backend found by the last consistency check.",
+	}, []string{"store"})
+	storeDriftRepaired = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "metad_store_drift_repaired_total",
+		Help: "The count of the store keys repaired by the consistency check.",
+	}, []string{"store"})
+)
+
+func init() {
+	prometheus.MustRegister(storeDrift)
+	prometheus.MustRegister(storeDriftRepaired)
+}
+
+func checkVerifyInterval(config *Config) error {
+	if config.VerifyInterval < 0 {
+		return errors.New("verify_interval should not be negative.")
+	}
+	return nil
+}
+
+// runVerify wait until the initial sync is done, then check the store with the backend, and again every
+// verify_interval seconds until metad stopped, repair the drift if verify_repair.
+// The store of local backend is the backend itself, so it is not checked.
+func (m *Metad) runVerify() {
+	if m.getConfig().Backend == "local" {
+		return
+	}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		if synced, _, err := m.metadataRepo.SyncStatus(); err == nil && synced && !m.servingStale() {
+			break
+		}
+		select {
+		case <-ticker.C:
+		case <-m.shutdownChan:
+			return
+		}
+	}
+	last := time.Now()
+	report, err := m.verify(m.getConfig().VerifyRepair)
+	if err != nil {
+		logger.Error("Verify store with backend error: %s", err.Error())
+	} else if report.Consistent {
+		logger.Info("Verify store with backend, store is consistent.")
+	}
+	for {
+		select {
+		case now := <-ticker.C:
+			config := m.getConfig()
+			if config.VerifyInterval <= 0 || now.Sub(last) < time.Duration(config.VerifyInterval)*time.Second {
+				continue
+			}
+			last = now
+			if _, err := m.verify(config.VerifyRepair); err != nil {
+				logger.Error("Verify store with backend error: %s", err.Error())
+			}
+		case <-m.shutdownChan:
+			return
+		}
+	}
+}
+
+// verify run the consistency check, log the discrepancies and keep the report for the verify api.
+func (m *Metad) verify(repair bool) (*metadata.VerifyReport, error) {
+	report, err := m.metadataRepo.Verify(repair)
+	if err != nil {
+		return nil, err
+	}
+	for name, result := range map[string]*metadata.VerifyResult{"data": report.Data, "mapping": report.Mapping} {
+		drift := result.MissingCount + result.ExtraCount + result.MismatchedCount
+		storeDrift.WithLabelValues(name).Set(float64(drift))
+		storeDriftRepaired.WithLabelValues(name).Add(float64(result.Repaired))
+		if drift > 0 {
+			logger.Warn("Verify %s store with backend at revision %d, missing: %d, extra: %d, mismatched: %d, repaired: %d",
+				name, result.Revision, result.MissingCount, result.ExtraCount, result.MismatchedCount, result.Repaired)
+		}
+	}
+	m.verifyLock.Lock()
+	m.verifyReport = report
+	m.verifyLock.Unlock()
+	return report, nil
+}
+
+func (m *Metad) verifyGet(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	m.verifyLock.Lock()
+	defer m.verifyLock.Unlock()
+	if m.verifyReport == nil {
+		return nil, NewHttpError(http.StatusNotFound, "store has not been verified.")
+	}
+	return m.verifyReport, nil
+}
+
+func (m *Metad) verifyRun(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	repair := strings.ToLower(req.FormValue("repair")) == "true"
+	report, err := m.verify(repair)
+	if err != nil {
+		return nil, NewServerError(err)
+	}
+	if repair {
+		requestLogger(ctx).Info("Verify store with backend and repair, consistent: %v", report.Consistent)
+	}
+	return report, nil
+}
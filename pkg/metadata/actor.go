@@ -0,0 +1,156 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metadata
+
+import (
+	"fmt"
+	"path"
+	"sync"
+	"time"
+
+	"openpitrix.io/metad/pkg/flatmap"
+	"openpitrix.io/metad/pkg/store"
+)
+
+const (
+	// ActorBackend is the actor of the changes not made by this metad, such as other metad or etcd client.
+	ActorBackend = "backend"
+	// ActorMapping is the actor of the changes of a client's self view made by its mapping changed.
+	ActorMapping = "mapping"
+
+	actorTTL        = 10 * time.Second
+	maxActorEntries = 1000
+)
+
+// actorEntry is a change going to be made by the actor,
+// if values is not nil, the entry match the update events of the exact key and value, and each key match only once,
+// otherwise the entry match the events of the path and its sub paths, only delete events if deleteOnly.
+type actorEntry struct {
+	actor      string
+	path       string
+	values     map[string]string
+	deleteOnly bool
+	expire     time.Time
+}
+
+func (e *actorEntry) match(action string, nodePath string, value string) bool {
+	if e.values != nil {
+		v, ok := e.values[nodePath]
+		if ok && action == store.Update && v == value {
+			delete(e.values, nodePath)
+			return true
+		}
+		return false
+	}
+	if e.deleteOnly && action != store.Delete {
+		return false
+	}
+	return isSubPath(nodePath, e.path)
+}
+
+// actorTracker remember who is changing the paths, until the changes are synced back from backend.
+type actorTracker struct {
+	entries []*actorEntry
+	lock    sync.Mutex
+}
+
+func (t *actorTracker) add(entries ...*actorEntry) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	expire := time.Now().Add(actorTTL)
+	for _, e := range entries {
+		e.path = path.Join("/", e.path)
+		e.expire = expire
+		t.entries = append(t.entries, e)
+	}
+	if len(t.entries) > maxActorEntries {
+		t.entries = t.entries[len(t.entries)-maxActorEntries:]
+	}
+}
+
+// remove the entries of a change which failed, the entries matched nothing should not be left until expired.
+func (t *actorTracker) remove(entries ...*actorEntry) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	removed := make(map[*actorEntry]struct{}, len(entries))
+	for _, e := range entries {
+		removed[e] = struct{}{}
+	}
+	kept := t.entries[:0]
+	for _, e := range t.entries {
+		if _, ok := removed[e]; !ok {
+			kept = append(kept, e)
+		}
+	}
+	for i := len(kept); i < len(t.entries); i++ {
+		t.entries[i] = nil
+	}
+	t.entries = kept
+}
+
+// track add the entries, and return the func to remove them.
+func (t *actorTracker) track(entries ...*actorEntry) func() {
+	t.add(entries...)
+	return func() {
+		t.remove(entries...)
+	}
+}
+
+// resolve return the actor of the latest matched change, see store.ActorFunc.
+func (t *actorTracker) resolve(action string, nodePath string, value string) string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	now := time.Now()
+	expired := 0
+	for expired < len(t.entries) && t.entries[expired].expire.Before(now) {
+		expired++
+	}
+	t.entries = t.entries[expired:]
+	for i := len(t.entries) - 1; i >= 0; i-- {
+		if t.entries[i].match(action, nodePath, value) {
+			return t.entries[i].actor
+		}
+	}
+	return ActorBackend
+}
+
+// TrackPut record the actor who is going to put the value to nodePath, the store events of the change carry the actor.
+// The returned func untrack the change, should be called if the change failed.
+func (r *MetadataRepo) TrackPut(actor string, nodePath string, value interface{}, replace bool) func() {
+	nodePath = path.Join("/", nodePath)
+	values := make(map[string]string)
+	switch t := value.(type) {
+	case map[string]interface{}, map[string]string, []interface{}:
+		for k, v := range flatmap.Flatten(t) {
+			values[path.Join(nodePath, k)] = v
+		}
+	case nil:
+	default:
+		values[nodePath] = fmt.Sprintf("%v", t)
+	}
+	entries := []*actorEntry{{actor: actor, path: nodePath, values: values}}
+	if replace {
+		entries = append(entries, &actorEntry{actor: actor, path: nodePath, deleteOnly: true})
+	}
+	return r.actors.track(entries...)
+}
+
+// TrackDelete record the actor who is going to delete the paths.
+func (r *MetadataRepo) TrackDelete(actor string, paths ...string) func() {
+	entries := make([]*actorEntry, 0, len(paths))
+	for _, p := range paths {
+		entries = append(entries, &actorEntry{actor: actor, path: p, deleteOnly: true})
+	}
+	return r.actors.track(entries...)
+}
+
+// TrackActor record the actor who is going to change the paths in any way.
+func (r *MetadataRepo) TrackActor(actor string, paths ...string) func() {
+	entries := make([]*actorEntry, 0, len(paths))
+	for _, p := range paths {
+		entries = append(entries, &actorEntry{actor: actor, path: p})
+	}
+	return r.actors.track(entries...)
+}
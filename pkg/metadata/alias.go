@@ -0,0 +1,220 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metadata
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"openpitrix.io/metad/pkg/logger"
+)
+
+// Alias is a data path referencing the target path like a symlink, the reads of the alias path and its sub paths are
+// resolved to the target at read time, so the same data is exposed under multiple paths without copies.
+// The alias in the target is resolved too, and the cycle is not followed.
+type Alias struct {
+	Path   string `json:"path"`
+	Target string `json:"target"`
+}
+
+// GetAliases return the aliases in path order.
+func (r *MetadataRepo) GetAliases() []*Alias {
+	aliases := []*Alias{}
+	for p, target := range r.records[RecordAlias].GetAll() {
+		aliases = append(aliases, &Alias{Path: p, Target: target})
+	}
+	sort.Slice(aliases, func(i, j int) bool {
+		return aliases[i].Path < aliases[j].Path
+	})
+	return aliases
+}
+
+func (r *MetadataRepo) GetAlias(nodePath string) *Alias {
+	nodePath = path.Join("/", nodePath)
+	target, ok := r.records[RecordAlias].Get(nodePath)
+	if !ok {
+		return nil
+	}
+	return &Alias{Path: nodePath, Target: target}
+}
+
+// PutAlias create or replace the alias of the path, the path should not have data, and the alias should not make a
+// cycle with the other aliases.
+func (r *MetadataRepo) PutAlias(alias *Alias) error {
+	if alias.Target == "" {
+		return errors.New("alias target should not be empty.")
+	}
+	alias.Path, alias.Target = path.Join("/", alias.Path), path.Join("/", alias.Target)
+	if alias.Path == "/" {
+		return errors.New("can not alias root path.")
+	}
+	if isSubPath(alias.Path, alias.Target) || isSubPath(alias.Target, alias.Path) {
+		return fmt.Errorf("alias [%s] can not contain or be contained by its target [%s].", alias.Path, alias.Target)
+	}
+	if err := r.checkMounted(alias.Path); err != nil {
+		return err
+	}
+	if _, val := r.data.Get(alias.Path); val != nil {
+		return fmt.Errorf("path [%s] has data, can not be alias.", alias.Path)
+	}
+	aliases := r.records[RecordAlias].GetAll()
+	aliases[alias.Path] = alias.Target
+	if aliasCycle(aliases, alias.Path) {
+		return fmt.Errorf("alias [%s] to [%s] makes a cycle.", alias.Path, alias.Target)
+	}
+	return r.storeClient.PutRecord(RecordAlias, alias.Path, alias.Target)
+}
+
+func (r *MetadataRepo) DeleteAlias(nodePath string) error {
+	return r.storeClient.DeleteRecord(RecordAlias, path.Join("/", nodePath))
+}
+
+// ReadData return the data of nodePath with the aliases resolved, see Alias.
+func (r *MetadataRepo) ReadData(nodePath string) interface{} {
+	return r.readAliased(path.Join("/", nodePath), func(p string) interface{} {
+		_, val := r.data.Get(p)
+		return val
+	})
+}
+
+// readAliased read the value of nodePath by read with the aliases resolved, nil if nodePath is resolved to a cycle.
+func (r *MetadataRepo) readAliased(nodePath string, read func(string) interface{}) interface{} {
+	aliases := r.records[RecordAlias].GetAll()
+	if len(aliases) == 0 {
+		return read(nodePath)
+	}
+	target, ok := resolveAlias(aliases, nodePath)
+	if !ok {
+		logger.Warn("Alias of [%s] makes a cycle, ignore.", nodePath)
+		return nil
+	}
+	return aliasValue(aliases, target, read(target), read, []string{target})
+}
+
+// aliasPath return the data path nodePath resolved to, nodePath itself if not aliased or resolved to a cycle.
+func (r *MetadataRepo) aliasPath(nodePath string) string {
+	aliases := r.records[RecordAlias].GetAll()
+	if len(aliases) == 0 {
+		return nodePath
+	}
+	if target, ok := resolveAlias(aliases, nodePath); ok {
+		return target
+	}
+	return nodePath
+}
+
+// resolveAlias replace the nearest aliased parent of nodePath by its target until not aliased, ok is false if an alias
+// is replaced twice.
+func resolveAlias(aliases map[string]string, nodePath string) (string, bool) {
+	visited := map[string]bool{}
+	for {
+		alias, target := aliasOf(aliases, nodePath)
+		if alias == "" {
+			return nodePath, true
+		}
+		if visited[alias] {
+			return "", false
+		}
+		visited[alias] = true
+		nodePath = path.Join(target, nodePath[len(alias):])
+	}
+}
+
+// aliasCycle return whether the read of the alias p reach p again, by the target or the aliases under the targets.
+func aliasCycle(aliases map[string]string, p string) bool {
+	visited := map[string]bool{}
+	var reach func(alias string) bool
+	reach = func(alias string) bool {
+		target, ok := resolveAlias(aliases, alias)
+		if !ok || isSubPath(p, target) || isSubPath(target, p) {
+			return true
+		}
+		if visited[target] {
+			return false
+		}
+		visited[target] = true
+		for q := range aliases {
+			if q != target && isSubPath(q, target) && reach(q) {
+				return true
+			}
+		}
+		return false
+	}
+	return reach(p)
+}
+
+// aliasOf return the alias of nodePath or its nearest aliased parent, and its target.
+func aliasOf(aliases map[string]string, nodePath string) (string, string) {
+	for p := nodePath; ; p = path.Dir(p) {
+		if target, ok := aliases[p]; ok {
+			return p, target
+		}
+		if p == "/" {
+			return "", ""
+		}
+	}
+}
+
+// aliasValue graft the values of the aliases under nodePath into val, the value of nodePath, resolving is the data
+// paths being resolved, the alias whose target contains one of them is a cycle and dropped. The values are not
+// modified.
+func aliasValue(aliases map[string]string, nodePath string, val interface{}, read func(string) interface{}, resolving []string) interface{} {
+	var subs []string
+	for p := range aliases {
+		if p != nodePath && isSubPath(p, nodePath) {
+			subs = append(subs, p)
+		}
+	}
+	sort.Strings(subs)
+	grafted := ""
+	for _, p := range subs {
+		// the alias under an alias is shadowed by the outer one.
+		if grafted != "" && isSubPath(p, grafted) {
+			continue
+		}
+		grafted = p
+		var v interface{}
+		target, ok := resolveAlias(aliases, p)
+		for i := 0; ok && i < len(resolving); i++ {
+			ok = !isSubPath(resolving[i], target)
+		}
+		if ok {
+			v = aliasValue(aliases, target, read(target), read, append(resolving[:len(resolving):len(resolving)], target))
+		} else {
+			logger.Warn("Alias [%s] makes a cycle, ignore.", p)
+		}
+		rel := strings.TrimPrefix(strings.TrimPrefix(p, nodePath), "/")
+		val = graftValue(val, strings.Split(rel, "/"), v)
+	}
+	return val
+}
+
+// graftValue return the copy of val with the value of the path names replaced by v, the parent dirs are created if
+// not present, and the path is removed if v is nil. The leaf val is returned as is.
+func graftValue(val interface{}, names []string, v interface{}) interface{} {
+	if len(names) == 0 {
+		return v
+	}
+	if val == nil && v == nil {
+		return nil
+	}
+	dir, ok := val.(map[string]interface{})
+	if !ok && val != nil {
+		return val
+	}
+	result := make(map[string]interface{}, len(dir)+1)
+	for k, child := range dir {
+		result[k] = child
+	}
+	if child := graftValue(dir[names[0]], names[1:], v); child != nil {
+		result[names[0]] = child
+	} else {
+		delete(result, names[0])
+	}
+	return result
+}
@@ -0,0 +1,150 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metadata
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"openpitrix.io/metad/pkg/logger"
+)
+
+// Annotation is operator-defined governance info of a data path, it is stored out-of-band of the data.
+type Annotation struct {
+	Path   string            `json:"path"`
+	Owner  string            `json:"owner,omitempty"`
+	Ticket string            `json:"ticket,omitempty"`
+	Expiry string            `json:"expiry,omitempty"`
+	Tags   []string          `json:"tags,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+	// ContentType is the media type of the leaf value, the direct leaf read respond it as the Content-Type.
+	ContentType string `json:"content_type,omitempty"`
+}
+
+// AnnotationFilter filter annotations by owner, tag or expired, empty field means not filter.
+type AnnotationFilter struct {
+	Owner   string
+	Tag     string
+	Expired bool
+}
+
+func (a *Annotation) ExpiryTime() (time.Time, error) {
+	return time.Parse(time.RFC3339, a.Expiry)
+}
+
+func (a *Annotation) HasTag(tag string) bool {
+	for _, t := range a.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *AnnotationFilter) match(a *Annotation, now time.Time) bool {
+	if f.Owner != "" && f.Owner != a.Owner {
+		return false
+	}
+	if f.Tag != "" && !a.HasTag(f.Tag) {
+		return false
+	}
+	if f.Expired {
+		if a.Expiry == "" {
+			return false
+		}
+		expiry, err := a.ExpiryTime()
+		if err != nil || expiry.After(now) {
+			return false
+		}
+	}
+	return true
+}
+
+func checkAnnotation(annotation *Annotation) error {
+	if annotation.Expiry != "" {
+		if _, err := annotation.ExpiryTime(); err != nil {
+			return fmt.Errorf("annotation expiry should be RFC3339 time, error: %s", err.Error())
+		}
+	}
+	if annotation.ContentType != "" {
+		if _, _, err := mime.ParseMediaType(annotation.ContentType); err != nil {
+			return fmt.Errorf("annotation content_type should be media type, error: %s", err.Error())
+		}
+	}
+	return nil
+}
+
+// GetAnnotations return the annotations of nodePath and all its sub paths.
+func (r *MetadataRepo) GetAnnotations(nodePath string, filter AnnotationFilter) []*Annotation {
+	nodePath = path.Join("/", nodePath)
+	now := time.Now()
+	annotations := []*Annotation{}
+	for k, v := range r.records[RecordAnnotation].GetAll() {
+		if !isSubPath(k, nodePath) {
+			continue
+		}
+		annotation, err := unmarshalAnnotation(v)
+		if err != nil {
+			logger.Error("Unexpect annotation json value [%s]", v)
+			continue
+		}
+		if filter.match(annotation, now) {
+			annotations = append(annotations, annotation)
+		}
+	}
+	sort.Slice(annotations, func(i, j int) bool {
+		return annotations[i].Path < annotations[j].Path
+	})
+	return annotations
+}
+
+func (r *MetadataRepo) GetAnnotation(nodePath string) *Annotation {
+	v, ok := r.records[RecordAnnotation].Get(path.Join("/", nodePath))
+	if !ok {
+		return nil
+	}
+	annotation, err := unmarshalAnnotation(v)
+	if err != nil {
+		logger.Error("Unexpect annotation json value [%s]", v)
+		return nil
+	}
+	return annotation
+}
+
+func (r *MetadataRepo) PutAnnotation(nodePath string, annotation *Annotation) error {
+	err := checkAnnotation(annotation)
+	if err != nil {
+		return err
+	}
+	annotation.Path = path.Join("/", nodePath)
+	b, err := json.Marshal(annotation)
+	if err != nil {
+		return err
+	}
+	return r.storeClient.PutRecord(RecordAnnotation, annotation.Path, string(b))
+}
+
+func (r *MetadataRepo) DeleteAnnotation(nodePath string) error {
+	return r.storeClient.DeleteRecord(RecordAnnotation, path.Join("/", nodePath))
+}
+
+func unmarshalAnnotation(data string) (*Annotation, error) {
+	annotation := &Annotation{}
+	err := json.Unmarshal([]byte(data), annotation)
+	return annotation, err
+}
+
+// isSubPath check whether nodePath is the parent itself or under the parent.
+func isSubPath(nodePath string, parent string) bool {
+	if parent == "/" || nodePath == parent {
+		return true
+	}
+	return strings.HasPrefix(nodePath, parent+"/")
+}
@@ -0,0 +1,161 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metadata
+
+import (
+	"context"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// BulkProgress report the number of processed leaves, and the total leaves.
+type BulkProgress func(done int, total int)
+
+// BulkDelete delete the leaves under nodePath in batches of batchSize, and wait interval after every batch,
+// so deleting a huge subtree does not saturate the backend and flood the watchers with events.
+// It stops when ctx is done, the deleted leaves are not restored.
+func (r *MetadataRepo) BulkDelete(ctx context.Context, nodePath string, batchSize int, interval time.Duration, progress BulkProgress) error {
+	nodePath = path.Join("/", nodePath)
+	v := r.GetData(nodePath)
+	if v == nil {
+		progress(0, 0)
+		return nil
+	}
+	if err := r.checkSchemas(deleteWrite(nodePath)); err != nil {
+		return err
+	}
+	leaves := []string{}
+	collectLeaves(nodePath, v, &leaves)
+	sort.Strings(leaves)
+	total := len(leaves)
+	progress(0, total)
+	if batchSize <= 0 {
+		batchSize = total
+	}
+	for i := 0; i < total; i += batchSize {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		// a freeze stop the bulk writes between the batches.
+		if err := r.CheckFreeze(); err != nil {
+			return err
+		}
+		end := i + batchSize
+		if end > total {
+			end = total
+		}
+		for _, leaf := range leaves[i:end] {
+			if err := r.storeClient.Delete(leaf, false); err != nil {
+				return err
+			}
+		}
+		if err := r.deleteLeafValueTypes(leaves[i:end]); err != nil {
+			return err
+		}
+		progress(end, total)
+		if end < total {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(interval):
+			}
+		}
+	}
+	// remove the empty directories left.
+	if _, dir := v.(map[string]interface{}); dir {
+		return r.storeClient.Delete(nodePath, true)
+	}
+	return nil
+}
+
+func collectLeaves(nodePath string, value interface{}, leaves *[]string) {
+	dir, ok := value.(map[string]interface{})
+	if !ok {
+		*leaves = append(*leaves, nodePath)
+		return
+	}
+	for k, v := range dir {
+		collectLeaves(path.Join(nodePath, k), v, leaves)
+	}
+}
+
+// BulkPut merge the value to nodePath in batches of batchSize leaves, and wait interval after every batch.
+// It stops when ctx is done, the imported leaves are not reverted.
+func (r *MetadataRepo) BulkPut(ctx context.Context, nodePath string, value interface{}, batchSize int, interval time.Duration, progress BulkProgress) error {
+	nodePath = path.Join("/", nodePath)
+	// check the whole value, as the usage of the written batches may be not synced yet.
+	if err := r.checkQuota(nodePath, value, false); err != nil {
+		return err
+	}
+	// the batches are not checked, as the data is incomplete until all the batches written.
+	if err := r.checkSchemas(putWrite(nodePath, value, false)); err != nil {
+		return err
+	}
+	if _, dir := value.(map[string]interface{}); !dir {
+		progress(0, 1)
+		if err := r.putData(nodePath, value, false); err != nil {
+			return err
+		}
+		progress(1, 1)
+		return nil
+	}
+	leaves := []string{}
+	collectLeaves("/", value, &leaves)
+	sort.Strings(leaves)
+	total := len(leaves)
+	progress(0, total)
+	if batchSize <= 0 {
+		batchSize = total
+	}
+	for i := 0; i < total; i += batchSize {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := r.CheckFreeze(); err != nil {
+			return err
+		}
+		end := i + batchSize
+		if end > total {
+			end = total
+		}
+		batch := map[string]interface{}{}
+		for _, leaf := range leaves[i:end] {
+			setLeaf(batch, strings.Split(strings.Trim(leaf, "/"), "/"), getLeaf(value, leaf))
+		}
+		if err := r.putData(nodePath, batch, false); err != nil {
+			return err
+		}
+		progress(end, total)
+		if end < total {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(interval):
+			}
+		}
+	}
+	return nil
+}
+
+func getLeaf(value interface{}, leaf string) interface{} {
+	for _, k := range strings.Split(strings.Trim(leaf, "/"), "/") {
+		value = value.(map[string]interface{})[k]
+	}
+	return value
+}
+
+func setLeaf(m map[string]interface{}, keys []string, value interface{}) {
+	for _, k := range keys[:len(keys)-1] {
+		sub, ok := m[k].(map[string]interface{})
+		if !ok {
+			sub = map[string]interface{}{}
+			m[k] = sub
+		}
+		m = sub
+	}
+	m[keys[len(keys)-1]] = value
+}
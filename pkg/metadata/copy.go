@@ -0,0 +1,133 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metadata
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"openpitrix.io/metad/pkg/flatmap"
+)
+
+// Substitution replace all Old in the copied values with New.
+type Substitution struct {
+	Old string `json:"old"`
+	New string `json:"new"`
+}
+
+// CopyOptions control which keys are copied and how the values are transformed,
+// Include and Exclude are glob patterns (see path.Match) of the key path relative to the source path,
+// a pattern match a key if it match the key or one of the key's parents.
+type CopyOptions struct {
+	Include       []string       `json:"include,omitempty"`
+	Exclude       []string       `json:"exclude,omitempty"`
+	Substitutions []Substitution `json:"substitutions,omitempty"`
+}
+
+func checkCopyOptions(options *CopyOptions) error {
+	for _, pattern := range append(append([]string{}, options.Include...), options.Exclude...) {
+		if _, err := path.Match(pattern, "/"); err != nil {
+			return fmt.Errorf("invalid key pattern [%s].", pattern)
+		}
+	}
+	for i, sub := range options.Substitutions {
+		if sub.Old == "" {
+			return fmt.Errorf("substitution [%d] old should not be empty.", i)
+		}
+	}
+	return nil
+}
+
+// matchKey check whether any pattern match the key or the key's parents.
+func matchKey(patterns []string, key string) bool {
+	for _, pattern := range patterns {
+		pattern = path.Join("/", pattern)
+		for k := key; ; k = path.Dir(k) {
+			if ok, _ := path.Match(pattern, k); ok {
+				return true
+			}
+			if k == "/" {
+				break
+			}
+		}
+	}
+	return false
+}
+
+// CopyData copy the subtree at from to the path to, which should not exist, return the count of copied keys.
+func (r *MetadataRepo) CopyData(from string, to string, options *CopyOptions) (int, error) {
+	from = path.Join("/", from)
+	to = path.Join("/", to)
+	if to == "/" {
+		return 0, errors.New("can not copy to root path.")
+	}
+	if isSubPath(to, from) {
+		return 0, fmt.Errorf("can not copy [%s] to its sub path [%s].", from, to)
+	}
+	if err := checkCopyOptions(options); err != nil {
+		return 0, err
+	}
+	if err := r.checkMounted(to); err != nil {
+		return 0, err
+	}
+	if r.GetData(to) != nil {
+		return 0, fmt.Errorf("path [%s] already exist.", to)
+	}
+	pairs := make([]string, 0, 2*len(options.Substitutions))
+	for _, sub := range options.Substitutions {
+		pairs = append(pairs, sub.Old, sub.New)
+	}
+	replacer := strings.NewReplacer(pairs...)
+
+	switch val := r.GetData(from).(type) {
+	case nil:
+		return 0, fmt.Errorf("path [%s] not found.", from)
+	case string:
+		value := replacer.Replace(val)
+		if err := r.checkQuota(to, value, false); err != nil {
+			return 0, err
+		}
+		if err := r.checkSchemas(putWrite(to, value, false)); err != nil {
+			return 0, err
+		}
+		if err := r.storeClient.Put(to, value, false); err != nil {
+			return 0, err
+		}
+		return 1, r.copyValueTypes(from, to, nil)
+	case map[string]interface{}:
+		values := make(map[string]string)
+		for k, v := range flatmap.Flatten(val) {
+			k = path.Join("/", k)
+			if len(options.Include) > 0 && !matchKey(options.Include, k) {
+				continue
+			}
+			if matchKey(options.Exclude, k) {
+				continue
+			}
+			values[k] = replacer.Replace(v)
+		}
+		if len(values) == 0 {
+			return 0, errors.New("no key matched.")
+		}
+		if err := r.checkQuota(to, values, false); err != nil {
+			return 0, err
+		}
+		if err := r.checkSchemas(putWrite(to, values, false)); err != nil {
+			return 0, err
+		}
+		if err := r.storeClient.Put(to, values, false); err != nil {
+			return 0, err
+		}
+		// the type records of the copied leaves are copied too.
+		return len(values), r.copyValueTypes(from, to, func(key string) bool {
+			_, ok := values[key]
+			return ok
+		})
+	default:
+		return 0, fmt.Errorf("unexpect value type of path [%s].", from)
+	}
+}
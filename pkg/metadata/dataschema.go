@@ -0,0 +1,282 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metadata
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"openpitrix.io/metad/pkg/flatmap"
+	"openpitrix.io/metad/pkg/jsonschema"
+	"openpitrix.io/metad/pkg/logger"
+)
+
+// DataSchema is the JSON Schema the data under the prefix should match, the data writes make the prefix not match
+// the schema are rejected.
+type DataSchema struct {
+	Prefix    string      `json:"prefix"`
+	Schema    interface{} `json:"schema"`
+	CreatedAt int64       `json:"created_at"`
+	UpdatedAt int64       `json:"updated_at"`
+}
+
+// SchemaError is the error of the data write make the data of a prefix not match its schema.
+type SchemaError struct {
+	Prefix string                        `json:"prefix"`
+	Errors []*jsonschema.ValidationError `json:"errors"`
+}
+
+func (e *SchemaError) Error() string {
+	messages := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		messages = append(messages, err.Error())
+	}
+	return fmt.Sprintf("data of prefix [%s] does not match the schema: %s", e.Prefix, strings.Join(messages, "; "))
+}
+
+// IsSchemaError return whether the error is caused by the data not matching the schema.
+func IsSchemaError(err error) bool {
+	_, ok := err.(*SchemaError)
+	return ok
+}
+
+// dataWrite is a data write checked by checkSchemas, the value is put to the path, or the path is deleted.
+type dataWrite struct {
+	path    string
+	value   interface{}
+	replace bool
+	delete  bool
+}
+
+func putWrite(nodePath string, value interface{}, replace bool) *dataWrite {
+	return &dataWrite{path: path.Join("/", nodePath), value: value, replace: replace}
+}
+
+func deleteWrite(nodePath string) *dataWrite {
+	return &dataWrite{path: path.Join("/", nodePath), delete: true}
+}
+
+type compiledDataSchema struct {
+	prefix string
+	schema *jsonschema.Schema
+}
+
+// dataSchemaCache keep the compiled schemas until the schema records changed.
+type dataSchemaCache struct {
+	version int64
+	loaded  bool
+	schemas []*compiledDataSchema
+	lock    sync.Mutex
+}
+
+func (r *MetadataRepo) compiledDataSchemas() []*compiledDataSchema {
+	records := r.records[RecordDataSchema]
+	cache := r.dataSchemas
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	version := records.Version()
+	if cache.loaded && cache.version == version {
+		return cache.schemas
+	}
+	schemas := []*compiledDataSchema{}
+	for _, s := range r.GetDataSchemas() {
+		schema, err := jsonschema.Compile(s.Schema)
+		if err != nil {
+			logger.Error("Ignore data schema of prefix [%s]: %s", s.Prefix, err.Error())
+			continue
+		}
+		schemas = append(schemas, &compiledDataSchema{prefix: s.Prefix, schema: schema})
+	}
+	cache.schemas, cache.version, cache.loaded = schemas, version, true
+	return schemas
+}
+
+// checkSchemas return SchemaError if the data of a prefix with schema would not match the schema after the writes
+// applied in order. A prefix without data after the writes is not checked.
+func (r *MetadataRepo) checkSchemas(writes ...*dataWrite) error {
+	for _, s := range r.compiledDataSchemas() {
+		affected := false
+		for _, w := range writes {
+			if isSubPath(w.path, s.prefix) || isSubPath(s.prefix, w.path) {
+				affected = true
+				break
+			}
+		}
+		if !affected {
+			continue
+		}
+		view := flattenValue(r.GetData(s.prefix))
+		for _, w := range writes {
+			applyWrite(view, s.prefix, w)
+		}
+		if len(view) == 0 {
+			continue
+		}
+		var value interface{}
+		if v, leaf := view["/"]; leaf {
+			value = v
+		} else {
+			value = flatmap.Expand(view, "/")
+		}
+		if errs := s.schema.Validate(value); len(errs) > 0 {
+			return &SchemaError{Prefix: s.prefix, Errors: errs}
+		}
+	}
+	return nil
+}
+
+// applyWrite apply the write to the flat view of the prefix, the keys of the view are relative to the prefix,
+// and "/" is the key of the leaf at the prefix.
+func applyWrite(view map[string]string, prefix string, w *dataWrite) {
+	switch {
+	case isSubPath(w.path, prefix):
+		key := relativeKey(w.path, prefix)
+		if w.delete {
+			removeKeys(view, key)
+			return
+		}
+		values := flattenValue(w.value)
+		if _, leaf := values["/"]; leaf || w.replace {
+			removeKeys(view, key)
+		}
+		for k, v := range values {
+			k = path.Join(key, k)
+			// a leaf is replaced by the dir.
+			for parent := path.Dir(k); parent != "/"; parent = path.Dir(parent) {
+				delete(view, parent)
+			}
+			if k != "/" {
+				delete(view, "/")
+			}
+			view[k] = v
+		}
+	case isSubPath(prefix, w.path):
+		if w.delete || w.replace {
+			removeKeys(view, "/")
+		}
+		if w.delete {
+			return
+		}
+		key := relativeKey(prefix, w.path)
+		for k, v := range flattenValue(w.value) {
+			k = path.Join("/", k)
+			if k == key {
+				removeKeys(view, "/")
+				view["/"] = v
+			} else if strings.HasPrefix(k, key+"/") {
+				delete(view, "/")
+				view[k[len(key):]] = v
+			} else if isSubPath(key, k) {
+				// a leaf put to the parent of the prefix.
+				removeKeys(view, "/")
+			}
+		}
+	}
+}
+
+func relativeKey(nodePath string, parent string) string {
+	if parent == "/" {
+		return nodePath
+	}
+	if nodePath == parent {
+		return "/"
+	}
+	return nodePath[len(parent):]
+}
+
+func removeKeys(view map[string]string, key string) {
+	for k := range view {
+		if isSubPath(k, key) {
+			delete(view, k)
+		}
+	}
+}
+
+func checkDataSchema(s *DataSchema) error {
+	if s.Prefix == "/" {
+		return errors.New("can not register data schema to root path.")
+	}
+	if s.Schema == nil {
+		return errors.New("data schema should not be empty.")
+	}
+	if _, err := jsonschema.Compile(s.Schema); err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetDataSchemas return all the data schemas in prefix order.
+func (r *MetadataRepo) GetDataSchemas() []*DataSchema {
+	schemas := []*DataSchema{}
+	for _, v := range r.records[RecordDataSchema].GetAll() {
+		s, err := unmarshalDataSchema(v)
+		if err != nil {
+			logger.Error("Unexpect data schema json value [%s]", v)
+			continue
+		}
+		schemas = append(schemas, s)
+	}
+	sort.Slice(schemas, func(i, j int) bool {
+		return schemas[i].Prefix < schemas[j].Prefix
+	})
+	return schemas
+}
+
+func (r *MetadataRepo) GetDataSchema(prefix string) *DataSchema {
+	v, ok := r.records[RecordDataSchema].Get(path.Join("/", prefix))
+	if !ok {
+		return nil
+	}
+	s, err := unmarshalDataSchema(v)
+	if err != nil {
+		logger.Error("Unexpect data schema json value [%s]", v)
+		return nil
+	}
+	return s
+}
+
+// PutDataSchema create or replace the schema of the prefix, CreatedAt is kept when replace. The current data of the
+// prefix should match the schema, unless force.
+func (r *MetadataRepo) PutDataSchema(s *DataSchema, force bool) error {
+	s.Prefix = path.Join("/", s.Prefix)
+	if err := checkDataSchema(s); err != nil {
+		return err
+	}
+	if !force {
+		schema, _ := jsonschema.Compile(s.Schema)
+		if data := r.GetData(s.Prefix); data != nil {
+			if errs := schema.Validate(data); len(errs) > 0 {
+				return &SchemaError{Prefix: s.Prefix, Errors: errs}
+			}
+		}
+	}
+	now := time.Now().Unix()
+	s.CreatedAt = now
+	if old := r.GetDataSchema(s.Prefix); old != nil {
+		s.CreatedAt = old.CreatedAt
+	}
+	s.UpdatedAt = now
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return r.storeClient.PutRecord(RecordDataSchema, s.Prefix, string(b))
+}
+
+func (r *MetadataRepo) DeleteDataSchema(prefix string) error {
+	return r.storeClient.DeleteRecord(RecordDataSchema, path.Join("/", prefix))
+}
+
+func unmarshalDataSchema(data string) (*DataSchema, error) {
+	s := &DataSchema{}
+	err := json.Unmarshal([]byte(data), s)
+	return s, err
+}
@@ -0,0 +1,82 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metadata
+
+import (
+	"encoding/json"
+	"path"
+	"sort"
+	"time"
+
+	"openpitrix.io/metad/pkg/logger"
+	"openpitrix.io/metad/pkg/notify"
+)
+
+// DeadLetter is a notification failed to deliver to the webhook URL of the subscription after all attempts,
+// the ID is same as the notification's ID.
+type DeadLetter struct {
+	ID           string               `json:"id"`
+	Subscription string               `json:"subscription"`
+	URL          string               `json:"url"`
+	Format       string               `json:"format,omitempty"`
+	Notification *notify.Notification `json:"notification"`
+	Attempts     int                  `json:"attempts"`
+	Error        string               `json:"error"`
+	CreatedAt    int64                `json:"created_at"`
+	UpdatedAt    int64                `json:"updated_at"`
+}
+
+func (r *MetadataRepo) GetDeadLetters() []*DeadLetter {
+	deadLetters := []*DeadLetter{}
+	for _, v := range r.records[RecordDeadLetter].GetAll() {
+		deadLetter, err := unmarshalDeadLetter(v)
+		if err != nil {
+			logger.Error("Unexpect dead letter json value [%s]", v)
+			continue
+		}
+		deadLetters = append(deadLetters, deadLetter)
+	}
+	sort.Slice(deadLetters, func(i, j int) bool {
+		return deadLetters[i].CreatedAt < deadLetters[j].CreatedAt
+	})
+	return deadLetters
+}
+
+func (r *MetadataRepo) GetDeadLetter(id string) *DeadLetter {
+	v, ok := r.records[RecordDeadLetter].Get(path.Join("/", id))
+	if !ok {
+		return nil
+	}
+	deadLetter, err := unmarshalDeadLetter(v)
+	if err != nil {
+		logger.Error("Unexpect dead letter json value [%s]", v)
+		return nil
+	}
+	return deadLetter
+}
+
+// PutDeadLetter create or update the dead letter, CreatedAt is kept when update.
+func (r *MetadataRepo) PutDeadLetter(deadLetter *DeadLetter) error {
+	now := time.Now().Unix()
+	if deadLetter.CreatedAt == 0 {
+		deadLetter.CreatedAt = now
+	}
+	deadLetter.UpdatedAt = now
+	b, err := json.Marshal(deadLetter)
+	if err != nil {
+		return err
+	}
+	return r.storeClient.PutRecord(RecordDeadLetter, deadLetter.ID, string(b))
+}
+
+func (r *MetadataRepo) DeleteDeadLetter(id string) error {
+	return r.storeClient.DeleteRecord(RecordDeadLetter, id)
+}
+
+func unmarshalDeadLetter(data string) (*DeadLetter, error) {
+	deadLetter := &DeadLetter{}
+	err := json.Unmarshal([]byte(data), deadLetter)
+	return deadLetter, err
+}
@@ -0,0 +1,80 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metadata
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// MatchData return the data paths under nodePath matched by the glob pattern (see path.Match) relative to nodePath,
+// every element of the pattern match one path element, so `/clusters/*/tmp` match `/clusters/cl-1/tmp`
+// but not `/clusters/cl-1/x/tmp`. The paths are sorted.
+func (r *MetadataRepo) MatchData(nodePath string, pattern string) ([]string, error) {
+	nodePath = path.Join("/", nodePath)
+	pattern = path.Join("/", pattern)
+	if pattern == "/" {
+		return nil, errors.New("match pattern should not be empty.")
+	}
+	elems := strings.Split(pattern[1:], "/")
+	for _, elem := range elems {
+		if _, err := path.Match(elem, ""); err != nil {
+			return nil, fmt.Errorf("invalid match pattern [%s], error: %s", pattern, err.Error())
+		}
+	}
+	result := []string{}
+	matchData(r.GetData(nodePath), nodePath, elems, &result)
+	sort.Strings(result)
+	return result, nil
+}
+
+func matchData(value interface{}, nodePath string, elems []string, result *[]string) {
+	if len(elems) == 0 {
+		if value != nil {
+			*result = append(*result, nodePath)
+		}
+		return
+	}
+	dir, ok := value.(map[string]interface{})
+	if !ok {
+		return
+	}
+	for k, v := range dir {
+		if matched, _ := path.Match(elems[0], k); matched {
+			matchData(v, path.Join(nodePath, k), elems[1:], result)
+		}
+	}
+}
+
+// DeletePaths delete the data paths, such as the matched paths of MatchData, the not exist paths are ignored.
+func (r *MetadataRepo) DeletePaths(paths ...string) error {
+	if err := r.checkMounted(paths...); err != nil {
+		return err
+	}
+	writes := make([]*dataWrite, 0, len(paths))
+	for _, p := range paths {
+		writes = append(writes, deleteWrite(p))
+	}
+	if err := r.checkSchemas(writes...); err != nil {
+		return err
+	}
+	for _, p := range paths {
+		v := r.GetData(p)
+		if v == nil {
+			continue
+		}
+		_, dir := v.(map[string]interface{})
+		if err := r.storeClient.Delete(p, dir); err != nil {
+			return err
+		}
+		if err := r.deleteValueTypes(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metadata
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"time"
+)
+
+// DataExport is the data of a prefix with the store version and the backend revision it is exported at,
+// the import request is same as the export.
+type DataExport struct {
+	Prefix     string      `json:"prefix" yaml:"prefix"`
+	Version    int64       `json:"version" yaml:"version"`
+	Revision   int64       `json:"revision" yaml:"revision"`
+	ExportedAt int64       `json:"exported_at" yaml:"exported_at"`
+	Data       interface{} `json:"data" yaml:"data"`
+}
+
+// ExportData return the data of the prefix, the whole store if the prefix is "/".
+// The revision is the backend revision the data sync has caught up with, 0 if the backend has no revision.
+func (r *MetadataRepo) ExportData(prefix string) (*DataExport, error) {
+	prefix = path.Join("/", prefix)
+	revision, _ := r.storeClient.SyncedRevisions()
+	export := &DataExport{Prefix: prefix, Version: r.DataVersion(), Revision: revision, ExportedAt: time.Now().Unix()}
+	export.Data = r.GetData(prefix)
+	if export.Data == nil {
+		if prefix != "/" {
+			return nil, fmt.Errorf("path [%s] not found.", prefix)
+		}
+		export.Data = map[string]interface{}{}
+	}
+	return export, nil
+}
+
+// ImportData put the data to the prefix, merge to the current data, or replace it if replace,
+// return the count of the imported keys.
+func (r *MetadataRepo) ImportData(prefix string, data interface{}, replace bool) (int, error) {
+	prefix = path.Join("/", prefix)
+	if data == nil {
+		return 0, errors.New("import data should not be empty.")
+	}
+	if _, dir := data.(map[string]interface{}); !dir && prefix == "/" {
+		return 0, errors.New("import data of root path should be object.")
+	}
+	if err := r.PutData(prefix, data, replace); err != nil {
+		return 0, err
+	}
+	return len(flattenValue(data)), nil
+}
@@ -0,0 +1,109 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metadata
+
+import (
+	"errors"
+	"path"
+
+	"openpitrix.io/metad/pkg/store"
+)
+
+// Fetcher read the value (string or map) of the data path from a source other than the local store,
+// such as a peer metad or the backend, return nil if not exist.
+type Fetcher func(nodePath string) (interface{}, error)
+
+// fetchRepo create a temporary repo of the client mapping, the data is put by the fetcher.
+func (r *MetadataRepo) fetchRepo(clientIP string) (*MetadataRepo, error) {
+	if clientIP == "" {
+		return nil, errors.New("clientIP must not be empty.")
+	}
+	repo := &MetadataRepo{
+		mapping:      store.New(),
+		data:         store.New(),
+		storeClient:  r.storeClient,
+		accessStore:  r.accessStore,
+		records:      r.records,
+		mappingRules: r.mappingRules,
+		vars:         r.vars,
+	}
+	if mapping := r.clientMapping(clientIP); mapping != nil {
+		repo.mapping.Put(path.Join("/", clientIP), mapping)
+	}
+	return repo, nil
+}
+
+func (r *MetadataRepo) putFetched(nodePath string, fetch Fetcher) error {
+	val, err := fetch(nodePath)
+	if err != nil {
+		return err
+	}
+	if val != nil {
+		r.data.Put(nodePath, val)
+	}
+	return nil
+}
+
+// RootFrom is same as Root, but read the data by the fetcher, the mapping and access rules are local.
+func (r *MetadataRepo) RootFrom(clientIP string, nodePath string, fetch Fetcher) (interface{}, error) {
+	repo, err := r.fetchRepo(clientIP)
+	if err != nil {
+		return nil, err
+	}
+	defer repo.destroy()
+	nodePath = path.Join("/", nodePath)
+	if err = repo.putFetched(nodePath, fetch); err != nil {
+		return nil, err
+	}
+	_, val := repo.Root(clientIP, nodePath)
+	return val, nil
+}
+
+// SelfFrom is same as Self, but read the data by the fetcher, the mapping and access rules are local.
+func (r *MetadataRepo) SelfFrom(clientIP string, nodePath string, fetch Fetcher) (interface{}, error) {
+	repo, err := r.fetchRepo(clientIP)
+	if err != nil {
+		return nil, err
+	}
+	defer repo.destroy()
+	paths := r.SelfPaths(clientIP, nodePath)
+	if len(paths) == 0 {
+		return nil, nil
+	}
+	for _, p := range paths {
+		if err = repo.putFetched(p, fetch); err != nil {
+			return nil, err
+		}
+	}
+	return repo.Self(clientIP, nodePath), nil
+}
+
+// GetBackendData read the data of nodePath from the backend directly, return nil if not exist.
+func (r *MetadataRepo) GetBackendData(nodePath string) (interface{}, error) {
+	nodePath = path.Join("/", nodePath)
+	val, err := r.storeClient.Get(nodePath, false)
+	if err != nil {
+		return nil, err
+	}
+	if !emptyData(val) {
+		return val, nil
+	}
+	val, err = r.storeClient.Get(nodePath, true)
+	if err != nil || emptyData(val) {
+		return nil, err
+	}
+	return val, nil
+}
+
+func emptyData(val interface{}) bool {
+	switch t := val.(type) {
+	case string:
+		return t == ""
+	case map[string]interface{}:
+		return len(t) == 0
+	default:
+		return val == nil
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metadata
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"openpitrix.io/metad/pkg/logger"
+)
+
+// freezeKey is the record key of the freeze, there is at most one freeze of the group.
+const freezeKey = "global"
+
+// Freeze is the administrative freeze of all the writes of the manage api, shared by the metad group, such as a
+// change-freeze window around a major event. The freeze is lifted at ExpireAt even if not deleted.
+type Freeze struct {
+	Reason    string `json:"reason"`
+	Owner     string `json:"owner"`
+	CreatedAt int64  `json:"created_at"`
+	ExpireAt  int64  `json:"expire_at"`
+}
+
+// FreezeError is the error of the writes rejected by the freeze.
+type FreezeError struct {
+	Freeze *Freeze
+}
+
+func (e *FreezeError) Error() string {
+	return fmt.Sprintf("writes are frozen by [%s] until %s: %s", e.Freeze.Owner,
+		time.Unix(e.Freeze.ExpireAt, 0).UTC().Format(time.RFC3339), e.Freeze.Reason)
+}
+
+func IsFreezeError(err error) bool {
+	_, ok := err.(*FreezeError)
+	return ok
+}
+
+func checkFreeze(f *Freeze, now int64) error {
+	if f.Reason == "" {
+		return errors.New("freeze reason should not be empty.")
+	}
+	if f.Owner == "" {
+		return errors.New("freeze owner should not be empty.")
+	}
+	if f.ExpireAt <= now {
+		return errors.New("freeze expire_at should be in the future.")
+	}
+	return nil
+}
+
+// GetFreeze return the freeze in effect, nil if not frozen or the freeze expired.
+func (r *MetadataRepo) GetFreeze() *Freeze {
+	v, ok := r.records[RecordFreeze].Get("/" + freezeKey)
+	if !ok {
+		return nil
+	}
+	f := &Freeze{}
+	if err := json.Unmarshal([]byte(v), f); err != nil {
+		logger.Error("Unexpect freeze json value [%s]", v)
+		return nil
+	}
+	if f.ExpireAt <= time.Now().Unix() {
+		return nil
+	}
+	return f
+}
+
+// PutFreeze freeze the writes until the ExpireAt, replace the current freeze if present.
+func (r *MetadataRepo) PutFreeze(f *Freeze) error {
+	now := time.Now().Unix()
+	if err := checkFreeze(f, now); err != nil {
+		return err
+	}
+	f.CreatedAt = now
+	b, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	return r.storeClient.PutRecord(RecordFreeze, freezeKey, string(b))
+}
+
+// DeleteFreeze lift the freeze.
+func (r *MetadataRepo) DeleteFreeze() error {
+	return r.storeClient.DeleteRecord(RecordFreeze, freezeKey)
+}
+
+// CheckFreeze return FreezeError if the writes are frozen.
+func (r *MetadataRepo) CheckFreeze() error {
+	if f := r.GetFreeze(); f != nil {
+		return &FreezeError{Freeze: f}
+	}
+	return nil
+}
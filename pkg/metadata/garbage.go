@@ -0,0 +1,83 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metadata
+
+import (
+	"path"
+	"sort"
+
+	"openpitrix.io/metad/pkg/flatmap"
+)
+
+// UnreferencedData is a data subtree which is not linked by any mapping, so no client can read it by self api.
+type UnreferencedData struct {
+	Path string `json:"path"`
+	Keys int    `json:"keys"`
+}
+
+// mappingLinks return all data paths linked by mappings (every layer of the layered links), the templated link
+// links the parent of the templated path element, such as /hosts for /hosts/{ip}.
+func (r *MetadataRepo) mappingLinks() []string {
+	links := []string{}
+	if mapping, ok := r.GetMapping("/").(map[string]interface{}); ok {
+		for _, link := range mappingLayers(flatmap.Flatten(mapping)) {
+			links = append(links, templateLink(link))
+		}
+	}
+	for _, rule := range r.GetMappingRules() {
+		for _, link := range mappingLayers(flatmap.Flatten(rule.Mapping)) {
+			links = append(links, templateLink(link))
+		}
+	}
+	return links
+}
+
+func templateLink(link string) string {
+	if i := mappingVarIndex(link); i >= 0 {
+		link = path.Dir(link[:i] + "_")
+	}
+	return path.Join("/", link)
+}
+
+// GetUnreferencedData report the largest data subtrees which are not reachable through any mapping,
+// a subtree is reachable if it, its parent or its sub path is linked by mapping.
+func (r *MetadataRepo) GetUnreferencedData() []*UnreferencedData {
+	result := []*UnreferencedData{}
+	links := r.mappingLinks()
+	data, ok := r.GetData("/").(map[string]interface{})
+	if !ok {
+		return result
+	}
+	collectUnreferenced("/", data, links, &result)
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Path < result[j].Path
+	})
+	return result
+}
+
+func collectUnreferenced(nodePath string, value interface{}, links []string, result *[]*UnreferencedData) {
+	containsLink := false
+	for _, link := range links {
+		if isSubPath(nodePath, link) {
+			// nodePath is linked or under a link.
+			return
+		}
+		if isSubPath(link, nodePath) {
+			containsLink = true
+		}
+	}
+	dir, isDir := value.(map[string]interface{})
+	if containsLink && isDir {
+		for k, v := range dir {
+			collectUnreferenced(path.Join(nodePath, k), v, links, result)
+		}
+		return
+	}
+	keys := 1
+	if isDir {
+		keys = len(flatmap.Flatten(dir))
+	}
+	*result = append(*result, &UnreferencedData{Path: nodePath, Keys: keys})
+}
@@ -0,0 +1,247 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metadata
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"openpitrix.io/metad/pkg/flatmap"
+)
+
+// PatchOperation is an operation of JSON Patch (RFC 6902), the paths are JSON pointers relative to the patched path.
+type PatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	From  string      `json:"from,omitempty"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// PatchTestError is the error of the JSON Patch test operation failed, nothing is written.
+type PatchTestError struct {
+	error
+}
+
+// IsPatchTestError return whether the error is caused by a failed test operation.
+func IsPatchTestError(err error) bool {
+	_, ok := err.(*PatchTestError)
+	return ok
+}
+
+// patchDoc is the flat values of the patched subtree, the keys are relative to the patched path, "/" is the leaf itself.
+type patchDoc map[string]string
+
+// pointerPath convert the JSON pointer to the path relative to the patched path.
+func pointerPath(pointer string) (string, error) {
+	if pointer == "" {
+		return "/", nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return "", fmt.Errorf("invalid json pointer [%s], should start with /.", pointer)
+	}
+	elems := strings.Split(pointer[1:], "/")
+	for i, elem := range elems {
+		elem = strings.Replace(strings.Replace(elem, "~1", "/", -1), "~0", "~", -1)
+		if elem == "" || elem == "." || elem == ".." || elem == "-" || strings.Contains(elem, "/") {
+			return "", fmt.Errorf("invalid json pointer [%s], unsupported reference token [%s].", pointer, elem)
+		}
+		elems[i] = elem
+	}
+	return "/" + strings.Join(elems, "/"), nil
+}
+
+func underPath(key string, p string) bool {
+	return p == "/" || key == p || strings.HasPrefix(key, p+"/")
+}
+
+func (d patchDoc) exists(p string) bool {
+	for k := range d {
+		if underPath(k, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// get return the flat values of the subtree at p, relative to p.
+func (d patchDoc) get(p string) patchDoc {
+	sub := patchDoc{}
+	for k, v := range d {
+		if !underPath(k, p) {
+			continue
+		}
+		if p == "/" {
+			sub[k] = v
+		} else if k == p {
+			sub["/"] = v
+		} else {
+			sub[k[len(p):]] = v
+		}
+	}
+	return sub
+}
+
+func (d patchDoc) remove(p string) {
+	for k := range d {
+		if underPath(k, p) {
+			delete(d, k)
+		}
+	}
+}
+
+func (d patchDoc) add(p string, sub patchDoc) error {
+	if p != "/" {
+		parent := path.Dir(p)
+		if _, leaf := d[parent]; leaf || (parent != "/" && !d.exists(parent)) {
+			return fmt.Errorf("the parent of path [%s] should be an existing object.", p)
+		}
+	}
+	d.remove(p)
+	for k, v := range sub {
+		d[path.Join(p, k)] = v
+	}
+	return nil
+}
+
+// valueDoc return the flat values of the operation value, null is an absent value.
+func valueDoc(value interface{}) patchDoc {
+	return patchDoc(flattenValue(value))
+}
+
+func (d patchDoc) equal(other patchDoc) bool {
+	if len(d) != len(other) {
+		return false
+	}
+	for k, v := range d {
+		if ov, ok := other[k]; !ok || ov != v {
+			return false
+		}
+	}
+	return true
+}
+
+func (d patchDoc) apply(op *PatchOperation) error {
+	p, err := pointerPath(op.Path)
+	if err != nil {
+		return err
+	}
+	if (op.Op == "add" || op.Op == "replace") && op.Value == nil {
+		return fmt.Errorf("value of %s operation should not be null.", op.Op)
+	}
+	switch op.Op {
+	case "add":
+		return d.add(p, valueDoc(op.Value))
+	case "remove", "replace":
+		if !d.exists(p) {
+			return fmt.Errorf("path [%s] of %s operation does not exist.", op.Path, op.Op)
+		}
+		if op.Op == "remove" {
+			d.remove(p)
+			return nil
+		}
+		return d.add(p, valueDoc(op.Value))
+	case "move", "copy":
+		from, err := pointerPath(op.From)
+		if err != nil {
+			return err
+		}
+		if !d.exists(from) {
+			return fmt.Errorf("from [%s] of %s operation does not exist.", op.From, op.Op)
+		}
+		sub := d.get(from)
+		if op.Op == "move" {
+			if from == p {
+				return nil
+			}
+			if underPath(p, from) {
+				return fmt.Errorf("can not move [%s] to its child [%s].", op.From, op.Path)
+			}
+			d.remove(from)
+		}
+		return d.add(p, sub)
+	case "test":
+		if !d.get(p).equal(valueDoc(op.Value)) {
+			return &PatchTestError{fmt.Errorf("test operation failed, the value of path [%s] is not equal.", op.Path)}
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported patch operation [%s].", op.Op)
+}
+
+// PlanJSONPatch apply the JSON Patch operations to the current data at nodePath in order, and compute the writes of
+// the changed keys. If any operation fail, include the test operation, the error is returned and nothing should be written.
+func (r *MetadataRepo) PlanJSONPatch(nodePath string, ops []*PatchOperation) (*DataPatch, error) {
+	nodePath = path.Join("/", nodePath)
+	old := patchDoc(flattenValue(r.GetData(nodePath)))
+	doc := patchDoc{}
+	for k, v := range old {
+		doc[k] = v
+	}
+	for i, op := range ops {
+		if err := doc.apply(op); err != nil {
+			if IsPatchTestError(err) {
+				return nil, err
+			}
+			return nil, fmt.Errorf("operation %d: %s", i, err.Error())
+		}
+	}
+	p := &DataPatch{Path: nodePath, Deletes: patchDeletes(old, doc, nodePath)}
+	if v, ok := doc["/"]; ok {
+		if old["/"] != v || len(p.Deletes) > 0 {
+			p.Value = v
+		}
+		return p, nil
+	}
+	changed := map[string]string{}
+	for k, v := range doc {
+		if ov, ok := old[k]; !ok || ov != v {
+			changed[k] = v
+		}
+	}
+	if len(changed) > 0 {
+		p.Value = flatmap.Expand(changed, "/")
+	}
+	return p, nil
+}
+
+// patchDeletes return the top most paths to delete, for the old keys not in the new doc.
+func patchDeletes(old patchDoc, doc patchDoc, nodePath string) []string {
+	deletes := map[string]bool{}
+	for k := range old {
+		if _, ok := doc[k]; ok {
+			continue
+		}
+		// the highest ancestor without any new key, or the old leaf itself which becomes a dir.
+		target := k
+		if k != "/" {
+			elems := strings.Split(k[1:], "/")
+			for i := range elems {
+				q := "/" + strings.Join(elems[:i+1], "/")
+				if !doc.exists(q) {
+					target = q
+					break
+				}
+			}
+		}
+		deletes[target] = true
+	}
+	result := []string{}
+	for k := range deletes {
+		covered := false
+		for other := range deletes {
+			if other != k && underPath(k, other) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			result = append(result, path.Join(nodePath, k))
+		}
+	}
+	sort.Strings(result)
+	return result
+}
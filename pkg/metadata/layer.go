@@ -0,0 +1,90 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metadata
+
+import (
+	"path"
+	"strings"
+
+	"openpitrix.io/metad/pkg/store"
+)
+
+// LayerSeparator separate the data paths of a layered mapping link, such as "/defaults/nginx|/overrides/{ip}/nginx".
+// The client's view of the link is the base layer (the first path) merged with the override layers in order at read
+// time, so the fleet-wide defaults are not duplicated into every client's subtree.
+const LayerSeparator = "|"
+
+// linkLayers return the data paths of the mapping link, base layer first.
+func linkLayers(link string) []string {
+	if !strings.Contains(link, LayerSeparator) {
+		return []string{link}
+	}
+	layers := strings.Split(link, LayerSeparator)
+	for i, layer := range layers {
+		layers[i] = strings.TrimSpace(layer)
+	}
+	return layers
+}
+
+// mappingLayers return the data paths of all layers of the mapping links.
+func mappingLayers(links map[string]string) []string {
+	layers := make([]string, 0, len(links))
+	for _, link := range links {
+		layers = append(layers, linkLayers(link)...)
+	}
+	return layers
+}
+
+// mergeLayer merge the value of the override layer into the value of the lower layers, the dirs are merged recursively,
+// otherwise the override value replace the lower one. The values are not modified.
+func mergeLayer(val interface{}, override interface{}) interface{} {
+	if override == nil {
+		return val
+	}
+	dir, ok := val.(map[string]interface{})
+	if !ok {
+		return override
+	}
+	overrideDir, ok := override.(map[string]interface{})
+	if !ok {
+		return override
+	}
+	merged := make(map[string]interface{}, len(dir)+len(overrideDir))
+	for k, v := range dir {
+		merged[k] = v
+	}
+	for k, v := range overrideDir {
+		merged[k] = mergeLayer(merged[k], v)
+	}
+	return merged
+}
+
+// watchLink watch the layers of the mapping link, the events of every layer are relative to the link.
+func (r *MetadataRepo) watchLink(link string) store.Watcher {
+	layers := linkLayers(link)
+	if len(layers) == 1 {
+		return r.data.Watch(link, DEFAULT_WATCH_BUF_LEN)
+	}
+	watchers := make([]store.Watcher, 0, len(layers))
+	for _, layer := range layers {
+		watchers = append(watchers, r.data.Watch(layer, DEFAULT_WATCH_BUF_LEN))
+	}
+	return store.NewMergedWatcher(watchers...)
+}
+
+// relinkLayers replace the layers of the link linked to path from (or its sub path) with path to, ok is false if no
+// layer replaced.
+func relinkLayers(link string, from string, to string) (string, bool) {
+	layers := linkLayers(link)
+	relinked := false
+	for i, layer := range layers {
+		layer = path.Join("/", layer)
+		if isSubPath(layer, from) {
+			layers[i] = to + layer[len(from):]
+			relinked = true
+		}
+	}
+	return strings.Join(layers, LayerSeparator), relinked
+}
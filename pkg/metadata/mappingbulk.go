@@ -0,0 +1,194 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metadata
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+
+	"openpitrix.io/metad/pkg/flatmap"
+)
+
+// ErrMappingConflict is returned by ImportMappings if the mapping table changed since the expected version.
+var ErrMappingConflict = errors.New("mapping has been changed since the version.")
+
+// MappingTable is the whole self mapping table, and the mapping version it exported at.
+type MappingTable struct {
+	Version  int64                  `json:"version,omitempty"`
+	Mappings map[string]interface{} `json:"mappings"`
+}
+
+// HostMapping is the mapping of a host.
+type HostMapping struct {
+	Host    string      `json:"host"`
+	Mapping interface{} `json:"mapping"`
+}
+
+// MappingDiff is the hosts changed by the import, every list is sorted.
+type MappingDiff struct {
+	DryRun    bool     `json:"dry_run"`
+	Added     []string `json:"added"`
+	Updated   []string `json:"updated"`
+	Removed   []string `json:"removed"`
+	Unchanged int      `json:"unchanged"`
+}
+
+// MappingError is the validation error of the mapping table, include the errors of all invalid hosts.
+type MappingError struct {
+	Errors map[string]string
+}
+
+func (e *MappingError) Error() string {
+	hosts := make([]string, 0, len(e.Errors))
+	for host := range e.Errors {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	msgs := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		msgs = append(msgs, fmt.Sprintf("%s: %s", host, e.Errors[host]))
+	}
+	return fmt.Sprintf("invalid mappings, %s", strings.Join(msgs, "; "))
+}
+
+// ExportMappings return the whole mapping table.
+func (r *MetadataRepo) ExportMappings() *MappingTable {
+	mappings, ok := r.GetMapping("/").(map[string]interface{})
+	if !ok {
+		mappings = map[string]interface{}{}
+	}
+	return &MappingTable{Version: r.MappingVersion(), Mappings: mappings}
+}
+
+// ListMappings return the mappings of the hosts match the wildcard pattern (all if empty) in host order,
+// start from offset and at most limit (all if not positive), and the number of matched hosts.
+func (r *MetadataRepo) ListMappings(pattern string, offset int, limit int) ([]*HostMapping, int, error) {
+	if pattern != "" {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, 0, fmt.Errorf("invalid host pattern [%s]: %s", pattern, err.Error())
+		}
+	}
+	mappings := r.ExportMappings().Mappings
+	hosts := make([]string, 0, len(mappings))
+	for host := range mappings {
+		if ok, _ := path.Match(pattern, host); pattern == "" || ok {
+			hosts = append(hosts, host)
+		}
+	}
+	sort.Strings(hosts)
+	total := len(hosts)
+	if offset > total {
+		offset = total
+	}
+	hosts = hosts[offset:]
+	if limit > 0 && limit < len(hosts) {
+		hosts = hosts[:limit]
+	}
+	result := make([]*HostMapping, 0, len(hosts))
+	for _, host := range hosts {
+		result = append(result, &HostMapping{Host: host, Mapping: mappings[host]})
+	}
+	return result, total, nil
+}
+
+func checkMappingTable(mappings map[string]interface{}) error {
+	errs := map[string]string{}
+	for host, v := range mappings {
+		err := checkMappingHost(host)
+		if err == nil {
+			err = checkMapping(v)
+		}
+		if err != nil {
+			errs[host] = err.Error()
+		}
+	}
+	if len(errs) > 0 {
+		return &MappingError{Errors: errs}
+	}
+	return nil
+}
+
+// ImportMappings put the mappings of the hosts in the table, if replace, the hosts not in the table are removed.
+// All hosts are validated before any write, and only the changed mapping keys are written, so the clients of
+// unchanged hosts never see a gap. If the table version is not 0, it should be the current mapping version,
+// otherwise ErrMappingConflict is returned. If dryRun, the diff is returned without write.
+func (r *MetadataRepo) ImportMappings(table *MappingTable, replace bool, dryRun bool) (*MappingDiff, error) {
+	if table.Mappings == nil {
+		return nil, errors.New("mappings should be json object.")
+	}
+	if err := checkMappingTable(table.Mappings); err != nil {
+		return nil, err
+	}
+	r.mappingLock.Lock()
+	defer r.mappingLock.Unlock()
+
+	current := r.ExportMappings()
+	if table.Version != 0 && table.Version != current.Version {
+		return nil, ErrMappingConflict
+	}
+	diff := &MappingDiff{DryRun: dryRun, Added: []string{}, Updated: []string{}, Removed: []string{}}
+	for host, v := range table.Mappings {
+		old, ok := current.Mappings[host]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, host)
+		case !reflect.DeepEqual(old, v):
+			diff.Updated = append(diff.Updated, host)
+		default:
+			diff.Unchanged++
+		}
+	}
+	if replace {
+		for host := range current.Mappings {
+			if _, ok := table.Mappings[host]; !ok {
+				diff.Removed = append(diff.Removed, host)
+			}
+		}
+	}
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Updated)
+	sort.Strings(diff.Removed)
+	if dryRun {
+		return diff, nil
+	}
+
+	for _, host := range diff.Removed {
+		_, dir := current.Mappings[host].(map[string]interface{})
+		if err := r.storeClient.DeleteMapping(path.Join("/", host), dir); err != nil {
+			return nil, err
+		}
+	}
+	changed := map[string]string{}
+	for _, host := range append(diff.Added, diff.Updated...) {
+		oldLeaves := map[string]string{}
+		if old, ok := current.Mappings[host]; ok {
+			oldLeaves = flatmap.Flatten(map[string]interface{}{host: old})
+		}
+		newLeaves := flatmap.Flatten(map[string]interface{}{host: table.Mappings[host]})
+		// delete the removed keys before put, a key may change from leaf to dir or reverse.
+		for k := range oldLeaves {
+			if _, ok := newLeaves[k]; !ok {
+				if err := r.storeClient.DeleteMapping(k, false); err != nil {
+					return nil, err
+				}
+			}
+		}
+		for k, v := range newLeaves {
+			if old, ok := oldLeaves[k]; !ok || old != v {
+				changed[k] = v
+			}
+		}
+	}
+	if len(changed) > 0 {
+		if err := r.storeClient.PutMapping("/", flatmap.Expand(changed, "/"), false); err != nil {
+			return nil, err
+		}
+	}
+	return diff, nil
+}
@@ -0,0 +1,207 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metadata
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"openpitrix.io/metad/pkg/flatmap"
+	"openpitrix.io/metad/pkg/logger"
+)
+
+// MappingRule map the clients match a CIDR (such as 10.2.0.0/24) or a wildcard (such as 10.2.*.*) to a templated mapping,
+// such as {"node": "/hosts/{ip}"}, for the clients have no explicit mapping, see MappingVarIP for the placeholders.
+type MappingRule struct {
+	Name      string                 `json:"name"`
+	Match     string                 `json:"match"`
+	Mapping   map[string]interface{} `json:"mapping"`
+	CreatedAt int64                  `json:"created_at"`
+	UpdatedAt int64                  `json:"updated_at"`
+}
+
+func (rule *MappingRule) matcher() (func(ip net.IP, clientIP string) bool, error) {
+	if strings.Contains(rule.Match, "/") {
+		_, ipNet, err := net.ParseCIDR(rule.Match)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mapping rule match [%s]: %s", rule.Match, err.Error())
+		}
+		return func(ip net.IP, clientIP string) bool {
+			return ip != nil && ipNet.Contains(ip)
+		}, nil
+	}
+	if _, err := path.Match(rule.Match, ""); err != nil {
+		return nil, fmt.Errorf("invalid mapping rule match [%s]: %s", rule.Match, err.Error())
+	}
+	return func(ip net.IP, clientIP string) bool {
+		ok, _ := path.Match(rule.Match, clientIP)
+		return ok
+	}, nil
+}
+
+func checkMappingRule(rule *MappingRule) error {
+	if rule.Name == "" || strings.Index(rule.Name, "/") >= 0 {
+		return errors.New("mapping rule name should not be empty or contains '/'.")
+	}
+	if rule.Match == "" {
+		return errors.New("mapping rule match should not be empty.")
+	}
+	if _, err := rule.matcher(); err != nil {
+		return err
+	}
+	if len(rule.Mapping) == 0 {
+		return errors.New("mapping rule mapping should not be empty.")
+	}
+	for k, v := range flatmap.Flatten(rule.Mapping) {
+		for _, layer := range linkLayers(v) {
+			if !strings.HasPrefix(layer, "/") {
+				return fmt.Errorf("mapping rule mapping [%s] should be absolute path.", k)
+			}
+		}
+	}
+	return nil
+}
+
+type compiledMappingRule struct {
+	rule  *MappingRule
+	match func(ip net.IP, clientIP string) bool
+}
+
+// mappingRuleCache keep the compiled rules until the rule records changed.
+type mappingRuleCache struct {
+	version int64
+	loaded  bool
+	rules   []*compiledMappingRule
+	lock    sync.Mutex
+}
+
+func (r *MetadataRepo) compiledMappingRules() []*compiledMappingRule {
+	records := r.records[RecordMappingRule]
+	cache := r.mappingRules
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	version := records.Version()
+	if cache.loaded && cache.version == version {
+		return cache.rules
+	}
+	rules := []*compiledMappingRule{}
+	for _, rule := range r.GetMappingRules() {
+		match, err := rule.matcher()
+		if err != nil {
+			logger.Error("Ignore mapping rule [%s]: %s", rule.Name, err.Error())
+			continue
+		}
+		rules = append(rules, &compiledMappingRule{rule: rule, match: match})
+	}
+	cache.rules, cache.version, cache.loaded = rules, version, true
+	return rules
+}
+
+// ruleMapping return the mapping of the first (in name order) rule matched the client ip, nil if no rule matched.
+func (r *MetadataRepo) ruleMapping(clientIP string) interface{} {
+	rules := r.compiledMappingRules()
+	if len(rules) == 0 {
+		return nil
+	}
+	ip := net.ParseIP(clientIP)
+	for _, rule := range rules {
+		if rule.match(ip, clientIP) {
+			return rule.rule.Mapping
+		}
+	}
+	return nil
+}
+
+// clientMapping return the explicit mapping of the client, or the mapping of the matched rule,
+// with the placeholders resolved.
+func (r *MetadataRepo) clientMapping(clientIP string) interface{} {
+	mapping := r.GetMapping(path.Join("/", clientIP))
+	if mapping == nil {
+		mapping = r.ruleMapping(clientIP)
+		if mapping == nil {
+			return nil
+		}
+	}
+	return r.expandMapping(mapping, clientIP)
+}
+
+// mappingAt return the sub value of the mapping at nodePath.
+func mappingAt(mapping interface{}, nodePath string) interface{} {
+	for _, elem := range strings.Split(path.Join("/", nodePath), "/") {
+		if elem == "" {
+			continue
+		}
+		m, ok := mapping.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		mapping = m[elem]
+	}
+	return mapping
+}
+
+func (r *MetadataRepo) GetMappingRules() []*MappingRule {
+	rules := []*MappingRule{}
+	for _, v := range r.records[RecordMappingRule].GetAll() {
+		rule, err := unmarshalMappingRule(v)
+		if err != nil {
+			logger.Error("Unexpect mapping rule json value [%s]", v)
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		return rules[i].Name < rules[j].Name
+	})
+	return rules
+}
+
+func (r *MetadataRepo) GetMappingRule(name string) *MappingRule {
+	v, ok := r.records[RecordMappingRule].Get(path.Join("/", name))
+	if !ok {
+		return nil
+	}
+	rule, err := unmarshalMappingRule(v)
+	if err != nil {
+		logger.Error("Unexpect mapping rule json value [%s]", v)
+		return nil
+	}
+	return rule
+}
+
+// PutMappingRule create or replace the mapping rule, CreatedAt is kept when replace.
+func (r *MetadataRepo) PutMappingRule(rule *MappingRule) error {
+	if err := checkMappingRule(rule); err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	rule.CreatedAt = now
+	if old := r.GetMappingRule(rule.Name); old != nil {
+		rule.CreatedAt = old.CreatedAt
+	}
+	rule.UpdatedAt = now
+	b, err := json.Marshal(rule)
+	if err != nil {
+		return err
+	}
+	return r.storeClient.PutRecord(RecordMappingRule, rule.Name, string(b))
+}
+
+func (r *MetadataRepo) DeleteMappingRule(name string) error {
+	return r.storeClient.DeleteRecord(RecordMappingRule, name)
+}
+
+func unmarshalMappingRule(data string) (*MappingRule, error) {
+	rule := &MappingRule{}
+	err := json.Unmarshal([]byte(data), rule)
+	return rule, err
+}
@@ -0,0 +1,136 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metadata
+
+import (
+	"strings"
+	"sync/atomic"
+)
+
+// The placeholders in the mapping values (of both the explicit mapping and the mapping rule), resolved at request time,
+// so one generic mapping such as {"node": "/hosts/{hostname}"} serve every client its own subtree.
+const (
+	// MappingVarIP is the client ip of the request, or the client identity if the request has no ip.
+	MappingVarIP = "{ip}"
+	// MappingVarHostname is the host of the bearer token, or the identity of the verified tls client cert.
+	MappingVarHostname = "{hostname}"
+	// MappingVarTokenSub is the sub of the bearer token.
+	MappingVarTokenSub = "{token.sub}"
+)
+
+var mappingVarNames = []string{MappingVarIP, MappingVarHostname, MappingVarTokenSub}
+
+// MappingVars is the values of the mapping placeholders of a request, keyed by the placeholder.
+type MappingVars map[string]string
+
+// ForClient return a read view of the repo, which resolve the mapping placeholders by the vars of the request.
+// The view share the stores with the repo, it should only be used to read.
+func (r *MetadataRepo) ForClient(vars MappingVars) *MetadataRepo {
+	return &MetadataRepo{
+		mapping:      r.mapping,
+		storeClient:  r.storeClient,
+		data:         r.data,
+		syncGate:     r.syncGate,
+		accessStore:  r.accessStore,
+		records:      r.records,
+		timerPool:    r.timerPool,
+		actors:       r.actors,
+		mappingRules: r.mappingRules,
+		vars:         vars,
+		typedValues:  atomic.LoadInt32(&r.typedValues),
+	}
+}
+
+// mappingVar return the value of the placeholder for the client, empty if unresolved.
+// The value contains '/' or '{', or is a dot path element is unresolved, as it may link outside the templated subtree.
+func (r *MetadataRepo) mappingVar(clientIP string, name string) string {
+	v := r.vars[name]
+	if v == "" && name == MappingVarIP {
+		v = clientIP
+	}
+	if strings.ContainsAny(v, "/{") || v == "." || v == ".." {
+		return ""
+	}
+	return v
+}
+
+func hasMappingVar(v interface{}) bool {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for _, v := range t {
+			if hasMappingVar(v) {
+				return true
+			}
+		}
+	case string:
+		return mappingVarIndex(t) >= 0
+	}
+	return false
+}
+
+// mappingVarIndex return the index of the first placeholder in s, -1 if not present.
+func mappingVarIndex(s string) int {
+	index := -1
+	for _, name := range mappingVarNames {
+		if i := strings.Index(s, name); i >= 0 && (index < 0 || i < index) {
+			index = i
+		}
+	}
+	return index
+}
+
+// expandMapping replace the placeholders in the mapping values, the values with unresolved placeholder are omitted.
+func (r *MetadataRepo) expandMapping(mapping interface{}, clientIP string) interface{} {
+	if !hasMappingVar(mapping) {
+		return mapping
+	}
+	v, _ := r.expandMappingValue(mapping, clientIP)
+	return v
+}
+
+func (r *MetadataRepo) expandMappingValue(v interface{}, clientIP string) (interface{}, bool) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, v := range t {
+			if expanded, ok := r.expandMappingValue(v, clientIP); ok {
+				m[k] = expanded
+			}
+		}
+		return m, true
+	case string:
+		if layers := linkLayers(t); len(layers) > 1 {
+			return r.expandLayers(layers, clientIP)
+		}
+		for _, name := range mappingVarNames {
+			if !strings.Contains(t, name) {
+				continue
+			}
+			value := r.mappingVar(clientIP, name)
+			if value == "" {
+				return nil, false
+			}
+			t = strings.Replace(t, name, value, -1)
+		}
+		return t, true
+	default:
+		return v, true
+	}
+}
+
+// expandLayers expand the layers of the layered link, the layers with unresolved placeholder are omitted, such as the
+// override layer of the hostname of a client without token.
+func (r *MetadataRepo) expandLayers(layers []string, clientIP string) (interface{}, bool) {
+	expanded := make([]string, 0, len(layers))
+	for _, layer := range layers {
+		if v, ok := r.expandMappingValue(layer, clientIP); ok {
+			expanded = append(expanded, v.(string))
+		}
+	}
+	if len(expanded) == 0 {
+		return nil, false
+	}
+	return strings.Join(expanded, LayerSeparator), true
+}
@@ -15,7 +15,10 @@ import (
 	"net"
 	"path"
 	"reflect"
+	"regexp"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"openpitrix.io/metad/pkg/backends"
@@ -27,15 +30,49 @@ import (
 
 const DEFAULT_WATCH_BUF_LEN = 100
 
+// subscribeWatchBufLen is bigger than DEFAULT_WATCH_BUF_LEN, as subscriber handle the events slowly.
+const subscribeWatchBufLen = 1000
+
+// record kinds, every kind is synced from backend to a separate RecordStore.
+const (
+	RecordRelease      = "release"
+	RecordAnnotation   = "annotation"
+	RecordToken        = "token"
+	RecordOverride     = "override"
+	RecordDeadLetter   = "dead_letter"
+	RecordSubscription = "subscription"
+	RecordMappingRule  = "mapping_rule"
+	RecordDataSchema   = "data_schema"
+	RecordFreeze       = "freeze"
+	RecordValueType    = "value_type"
+	RecordTrash        = "trash"
+	RecordAlias        = "alias"
+)
+
+var recordKinds = []string{RecordRelease, RecordAnnotation, RecordToken, RecordOverride, RecordDeadLetter, RecordSubscription, RecordMappingRule, RecordDataSchema, RecordFreeze,
+	RecordValueType, RecordTrash, RecordAlias}
+
 type MetadataRepo struct {
 	mapping            store.Store
 	storeClient        backends.StoreClient
 	data               store.Store
+	syncGate           *syncGate
 	accessStore        store.AccessStore
+	records            map[string]store.RecordStore
 	metaStopChan       chan bool
 	mappingStopChan    chan bool
 	accessRuleStopChan chan bool
+	recordStopChan     map[string]chan bool
 	timerPool          *util.TimerPool
+	actors             *actorTracker
+	quotaReject        int32
+	releaseLock        sync.Mutex
+	mappingLock        sync.Mutex
+	mappingRules       *mappingRuleCache
+	dataSchemas        *dataSchemaCache
+	overlays           *overlaySet
+	vars               MappingVars
+	typedValues        int32
 }
 
 func New(storeClient backends.StoreClient) *MetadataRepo {
@@ -44,10 +81,22 @@ func New(storeClient backends.StoreClient) *MetadataRepo {
 		storeClient:        storeClient,
 		data:               store.New(),
 		accessStore:        store.NewAccessStore(),
+		records:            make(map[string]store.RecordStore, len(recordKinds)),
 		metaStopChan:       make(chan bool),
 		mappingStopChan:    make(chan bool),
 		accessRuleStopChan: make(chan bool),
+		recordStopChan:     make(map[string]chan bool, len(recordKinds)),
 		timerPool:          util.NewTimerPool(100 * time.Millisecond),
+		actors:             &actorTracker{},
+		mappingRules:       &mappingRuleCache{},
+		dataSchemas:        &dataSchemaCache{},
+		overlays:           newOverlaySet(),
+	}
+	metadataRepo.data.SetActorFunc(metadataRepo.actors.resolve)
+	metadataRepo.syncGate = newSyncGate(metadataRepo.data)
+	for _, kind := range recordKinds {
+		metadataRepo.records[kind] = store.NewRecordStore()
+		metadataRepo.recordStopChan[kind] = make(chan bool)
 	}
 	return &metadataRepo
 }
@@ -57,10 +106,11 @@ func (r *MetadataRepo) StartSync() {
 	r.startMetaSync()
 	r.startMappingSync()
 	r.startAccessRuleSync()
+	r.startRecordSync()
 }
 
 func (r *MetadataRepo) startMetaSync() {
-	r.storeClient.Sync(r.data, r.metaStopChan)
+	r.storeClient.Sync(r.syncGate, r.metaStopChan)
 }
 
 func (r *MetadataRepo) startMappingSync() {
@@ -71,11 +121,20 @@ func (r *MetadataRepo) startAccessRuleSync() {
 	r.storeClient.SyncAccessRule(r.accessStore, r.accessRuleStopChan)
 }
 
+func (r *MetadataRepo) startRecordSync() {
+	for _, kind := range recordKinds {
+		r.storeClient.SyncRecords(kind, r.records[kind], r.recordStopChan[kind])
+	}
+}
+
 func (r *MetadataRepo) StopSync() {
 	logger.Info("Stop Sync")
 	r.metaStopChan <- true
 	r.mappingStopChan <- true
 	r.accessRuleStopChan <- true
+	for _, kind := range recordKinds {
+		r.recordStopChan[kind] <- true
+	}
 	time.Sleep(1 * time.Second)
 	r.data.Destroy()
 	time.Sleep(1 * time.Second)
@@ -83,10 +142,14 @@ func (r *MetadataRepo) StopSync() {
 }
 
 func (r *MetadataRepo) getAccessTree(clientIP string) store.AccessTree {
+	return r.accessTreeOf(clientIP, r.clientMapping(clientIP))
+}
+
+// accessTreeOf return the access tree of the client, or the tree converted from the mapping if the client has no access rule.
+func (r *MetadataRepo) accessTreeOf(clientIP string, mappingData interface{}) store.AccessTree {
 	accessTree := r.accessStore.Get(clientIP)
 	//for compatible with old version, auto convert mapping to AccessRule
 	if accessTree == nil {
-		mappingData := r.GetMapping(path.Join("/", clientIP))
 		if mappingData == nil {
 			logger.Debug("Can not find mapping for %s", clientIP)
 			return nil
@@ -98,7 +161,7 @@ func (r *MetadataRepo) getAccessTree(clientIP string) store.AccessTree {
 		}
 		flattenMapping := flatmap.Flatten(mapping)
 		rules := []store.AccessRule{}
-		for _, dataPath := range flattenMapping {
+		for _, dataPath := range mappingLayers(flattenMapping) {
 			rules = append(rules, store.AccessRule{Path: dataPath, Mode: store.AccessModeRead})
 		}
 		accessTree = store.NewAccessTree(rules)
@@ -117,12 +180,19 @@ func (r *MetadataRepo) Root(clientIP string, nodePath string) (currentVersion in
 	}
 	traveller := r.data.Traveller(accessTree)
 	defer traveller.Close()
-	if !traveller.Enter(nodePath) {
+	val = r.readAliased(nodePath, func(p string) interface{} {
+		traveller.BackToRoot()
+		if !traveller.Enter(p) {
+			return nil
+		}
+		return traveller.GetValue()
+	})
+	if val == nil {
 		return
 	}
 	currentVersion = traveller.GetVersion()
-	val = traveller.GetValue()
-	if val != nil && nodePath == "/" {
+	if nodePath == "/" {
+		traveller.BackToRoot()
 		selfVal := r.self(clientIP, "/", traveller)
 		if selfVal != nil {
 			mapVal, ok := val.(map[string]interface{})
@@ -136,15 +206,30 @@ func (r *MetadataRepo) Root(clientIP string, nodePath string) (currentVersion in
 
 func (r *MetadataRepo) Watch(ctx context.Context, clientIP string, nodePath string) interface{} {
 	nodePath = path.Join("/", nodePath)
-	w := r.data.Watch(nodePath, DEFAULT_WATCH_BUF_LEN)
+	w := r.data.Watch(r.aliasPath(nodePath), DEFAULT_WATCH_BUF_LEN)
 	return r.changeToResult(w, ctx.Done())
 }
 
+// WatchEvents is same as Watch, but return the events with absolute path and actor.
+func (r *MetadataRepo) WatchEvents(ctx context.Context, clientIP string, nodePath string) []*store.Event {
+	nodePath = path.Join("/", nodePath)
+	w := r.data.Watch(r.aliasPath(nodePath), DEFAULT_WATCH_BUF_LEN)
+	return changeToEvents(r.watchChanges(w, ctx.Done()), nodePath)
+}
+
 var TIMER_NIL *time.Timer = &time.Timer{C: nil}
 
-func (r *MetadataRepo) changeToResult(watcher store.Watcher, stopChan <-chan struct{}) interface{} {
+// watchChanges collect the events until no more event arrive in a short time, or a leaf node event arrive,
+// return empty if stopped.
+func (r *MetadataRepo) watchChanges(watcher store.Watcher, stopChan <-chan struct{}) []*store.Event {
 	defer watcher.Remove()
-	m := make(map[string]string)
+	events, _ := r.collectEvents(watcher, stopChan)
+	return events
+}
+
+// collectEvents is same as watchChanges, but keep the watcher, closed is true if the watcher is closed or stopped.
+func (r *MetadataRepo) collectEvents(watcher store.Watcher, stopChan <-chan struct{}) (events []*store.Event, closed bool) {
+	events = []*store.Event{}
 	timer := TIMER_NIL
 
 	for {
@@ -152,25 +237,27 @@ func (r *MetadataRepo) changeToResult(watcher store.Watcher, stopChan <-chan str
 		select {
 		case e, ok := <-watcher.EventChan():
 			if ok {
-				value := fmt.Sprintf("%s|%s", e.Action, e.Value)
-				// if event is one leaf node, just return value.
-				if e.Path == "/" {
-					return value
+				events = append(events, e.Flatten()...)
+				// if event is one leaf node, just return.
+				if e.Path == "/" && e.Action != store.Batch {
+					finish = true
+					break
 				}
-				m[e.Path] = value
 				if timer.C != nil {
 					r.timerPool.ReleaseTimer(timer)
 				}
 				timer = r.timerPool.AcquireTimer()
 			} else {
 				finish = true
+				closed = true
 			}
 		case <-timer.C:
 			finish = true
 		case <-stopChan:
-			//when stop, return empty map, discard prev result.
-			m = make(map[string]string)
+			//when stop, return empty, discard prev result.
+			events = []*store.Event{}
 			finish = true
+			closed = true
 		}
 
 		if finish {
@@ -179,49 +266,163 @@ func (r *MetadataRepo) changeToResult(watcher store.Watcher, stopChan <-chan str
 			}
 			break
 		}
-		//TODO check map size, avoid too big result.
+		//TODO check events size, avoid too big result.
+	}
+	return
+}
+
+// SubscribeData watch the data changes under nodePath in background until stopChan closed,
+// every batch of the changes is passed to handler as the events with absolute path.
+// The changes after SubscribeData returned are not missed, the returned channel is closed after stopped.
+func (r *MetadataRepo) SubscribeData(nodePath string, stopChan <-chan struct{}, handler func(events []*store.Event)) <-chan struct{} {
+	nodePath = path.Join("/", nodePath)
+	w := r.data.Watch(nodePath, subscribeWatchBufLen)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer w.Remove()
+		for {
+			events, closed := r.collectEvents(w, stopChan)
+			if len(events) > 0 {
+				handler(changeToEvents(events, nodePath))
+			}
+			if closed {
+				return
+			}
+		}
+	}()
+	return done
+}
+
+func (r *MetadataRepo) changeToResult(watcher store.Watcher, stopChan <-chan struct{}) interface{} {
+	return eventsToResult(r.watchChanges(watcher, stopChan))
+}
+
+func eventsToResult(events []*store.Event) interface{} {
+	m := make(map[string]string)
+	for _, e := range events {
+		value := fmt.Sprintf("%s|%s", e.Action, e.Value)
+		// if event is one leaf node, just return value.
+		if e.Path == "/" {
+			return value
+		}
+		m[e.Path] = value
 	}
 	return flatmap.Expand(m, "/")
 }
 
+func changeToEvents(events []*store.Event, nodePath string) []*store.Event {
+	result := make([]*store.Event, 0, len(events))
+	for _, e := range events {
+		// event is shared by watchers, so copy it.
+		event := *e
+		event.Path = path.Join(nodePath, e.Path)
+		result = append(result, &event)
+	}
+	return result
+}
+
 func (r *MetadataRepo) WatchSelf(ctx context.Context, clientIP string, nodePath string) interface{} {
-	nodePath = path.Join(clientIP, "/", nodePath)
-	logger.Debug("WatchSelf nodePath: %s", nodePath)
-	mappingData := r.GetMapping(nodePath)
-	if mappingData == nil {
+	events, ok := r.watchSelfChanges(ctx, clientIP, nodePath)
+	if !ok {
 		return nil
 	}
-	mappingWatcher := r.mapping.Watch(nodePath, DEFAULT_WATCH_BUF_LEN)
-	defer mappingWatcher.Remove()
+	return eventsToResult(events)
+}
+
+// WatchSelfEvents is same as WatchSelf, but return the events with path relative to self and actor.
+func (r *MetadataRepo) WatchSelfEvents(ctx context.Context, clientIP string, nodePath string) []*store.Event {
+	events, ok := r.watchSelfChanges(ctx, clientIP, nodePath)
+	if !ok {
+		return nil
+	}
+	return changeToEvents(events, path.Join("/", nodePath))
+}
+
+// watchSelfChanges watch the data linked by the client's mapping at nodePath, if the mapping changed while watching,
+// the changes of the client's view made by the remapping are returned. ok is false if the client has no mapping at nodePath.
+func (r *MetadataRepo) watchSelfChanges(ctx context.Context, clientIP string, nodePath string) (events []*store.Event, ok bool) {
+	mapping := r.clientMapping(clientIP)
+	w, stopChan, remapped, remove := r.selfWatcher(ctx, clientIP, nodePath, mapping)
+	if w == nil {
+		return nil, false
+	}
+	defer remove()
+	events = r.watchChanges(w, stopChan)
+	select {
+	case <-remapped:
+		events = r.remapEvents(clientIP, nodePath, mapping)
+	default:
+	}
+	return events, true
+}
+
+// selfWatcher watch the data linked by the client's mapping at nodePath, the stopChan is closed when the mapping changed
+// (and the remapped chan is closed before) or ctx done, the returned remove func should be called after watched.
+func (r *MetadataRepo) selfWatcher(ctx context.Context, clientIP string, nodePath string, mapping interface{}) (store.Watcher, <-chan struct{}, <-chan struct{}, func()) {
+	mappingData := mappingAt(mapping, nodePath)
+	mappingPath := path.Join("/", clientIP, nodePath)
+	logger.Debug("WatchSelf nodePath: %s", mappingPath)
+	if mappingData == nil {
+		return nil, nil, nil, nil
+	}
+	// the explicit mapping registered later override the rule, so watch it even the mapping is from rule.
+	mappingWatcher := r.mapping.Watch(mappingPath, DEFAULT_WATCH_BUF_LEN)
+	fromRule := r.GetMapping(path.Join("/", clientIP)) == nil
 
 	stopChan := make(chan struct{})
+	remapped := make(chan struct{})
 
 	go func() {
-		select {
-		case _, ok := <-mappingWatcher.EventChan():
-			if ok {
+		// the rules are records without watcher, so check whether the rule mapping changed by the rule version.
+		var tick <-chan time.Time
+		if fromRule {
+			ticker := time.NewTicker(mappingRuleCheckInterval)
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+		ruleVersion := r.records[RecordMappingRule].Version()
+		for {
+			select {
+			case _, ok := <-mappingWatcher.EventChan():
+				if ok {
+					close(remapped)
+					close(stopChan)
+				}
+				return
+			case <-tick:
+				version := r.records[RecordMappingRule].Version()
+				if version == ruleVersion {
+					continue
+				}
+				ruleVersion = version
+				if !reflect.DeepEqual(mappingData, mappingAt(r.clientMapping(clientIP), nodePath)) {
+					close(remapped)
+					close(stopChan)
+					return
+				}
+			case <-ctx.Done():
 				close(stopChan)
+				return
 			}
-		case <-ctx.Done():
-			close(stopChan)
 		}
 	}()
 
-	mapping, mok := mappingData.(map[string]interface{})
+	mappingMap, mok := mappingData.(map[string]interface{})
 	if !mok {
 		dataNodePath := fmt.Sprintf("%s", mappingData)
 		//log.Debug("watcher: %v", dataNodePath)
-		w := r.data.Watch(dataNodePath, DEFAULT_WATCH_BUF_LEN)
-		return r.changeToResult(w, stopChan)
+		w := r.watchLink(dataNodePath)
+		return w, stopChan, remapped, mappingWatcher.Remove
 	} else {
-		flatMapping := flatmap.Flatten(mapping)
+		flatMapping := flatmap.Flatten(mappingMap)
 		watchers := make(map[string]store.Watcher)
 		for k, v := range flatMapping {
-			watchers[k] = r.data.Watch(v, DEFAULT_WATCH_BUF_LEN)
+			watchers[k] = r.watchLink(v)
 		}
 		//log.Debug("aggWatcher: %v", watchers)
 		aggWatcher := store.NewAggregateWatcher(watchers)
-		return r.changeToResult(aggWatcher, stopChan)
+		return aggWatcher, stopChan, remapped, mappingWatcher.Remove
 	}
 }
 
@@ -241,7 +442,7 @@ func (r *MetadataRepo) Self(clientIP string, nodePath string) interface{} {
 }
 
 func (r *MetadataRepo) self(clientIP string, nodePath string, traveller store.Traveller) interface{} {
-	mappingData := r.GetMapping(path.Join("/", clientIP))
+	mappingData := r.clientMapping(clientIP)
 	if mappingData == nil {
 		logger.Debug("Can not find mapping for %s", clientIP)
 		return nil
@@ -254,14 +455,61 @@ func (r *MetadataRepo) self(clientIP string, nodePath string, traveller store.Tr
 	return r.getMappingDatas(nodePath, mapping, traveller)
 }
 
+// SelfPaths return the data paths which the self request of the client at nodePath is mapped to.
+func (r *MetadataRepo) SelfPaths(clientIP string, nodePath string) []string {
+	mappingData := r.clientMapping(clientIP)
+	paths := strings.Split(path.Join("/", nodePath), "/")[1:]
+	for i, elem := range paths {
+		if elem == "" {
+			break
+		}
+		mapping, isMap := mappingData.(map[string]interface{})
+		if !isMap {
+			return nil
+		}
+		v, ok := mapping[elem]
+		if !ok {
+			return nil
+		}
+		if _, isMap := v.(map[string]interface{}); !isMap {
+			var result []string
+			for _, layer := range linkLayers(fmt.Sprintf("%v", v)) {
+				result = append(result, path.Join(append([]string{layer}, paths[i+1:]...)...))
+			}
+			return result
+		}
+		mappingData = v
+	}
+	var result []string
+	collectMappingPaths(mappingData, &result)
+	return result
+}
+
+func collectMappingPaths(mappingData interface{}, result *[]string) {
+	mapping, isMap := mappingData.(map[string]interface{})
+	if !isMap {
+		if mappingData != nil {
+			for _, layer := range linkLayers(fmt.Sprintf("%v", mappingData)) {
+				*result = append(*result, path.Join("/", layer))
+			}
+		}
+		return
+	}
+	for _, v := range mapping {
+		collectMappingPaths(v, result)
+	}
+}
+
+// getMappingData return the data at nodePath of the link, the layers of the layered link are merged.
 func (r *MetadataRepo) getMappingData(nodePath, link string, traveller store.Traveller) interface{} {
-	nodePath = path.Join(link, nodePath)
-	if traveller.Enter(nodePath) {
-		val := traveller.GetValue()
-		traveller.BackToRoot()
-		return val
+	var val interface{}
+	for _, layer := range linkLayers(link) {
+		if traveller.Enter(path.Join(layer, nodePath)) {
+			val = mergeLayer(val, traveller.GetValue())
+			traveller.BackToRoot()
+		}
 	}
-	return nil
+	return val
 }
 
 func (r *MetadataRepo) getMappingDatas(nodePath string, mapping map[string]interface{}, traveller store.Traveller) interface{} {
@@ -314,7 +562,23 @@ func (r *MetadataRepo) GetData(nodePath string) interface{} {
 }
 
 func (r *MetadataRepo) PutData(nodePath string, data interface{}, replace bool) error {
-	return r.storeClient.Put(nodePath, data, replace)
+	if err := r.checkSchemas(putWrite(nodePath, data, replace)); err != nil {
+		return err
+	}
+	return r.putData(nodePath, data, replace)
+}
+
+func (r *MetadataRepo) putData(nodePath string, data interface{}, replace bool) error {
+	if err := r.checkMounted(nodePath); err != nil {
+		return err
+	}
+	if err := r.checkQuota(nodePath, data, replace); err != nil {
+		return err
+	}
+	if err := r.storeClient.Put(nodePath, data, replace); err != nil {
+		return err
+	}
+	return r.putValueTypes(nodePath, data, replace)
 }
 
 func (r *MetadataRepo) DeleteData(nodePath string, subs ...string) error {
@@ -322,6 +586,24 @@ func (r *MetadataRepo) DeleteData(nodePath string, subs ...string) error {
 	if err != nil {
 		return err
 	}
+	paths := []string{nodePath}
+	for _, sub := range subs {
+		paths = append(paths, path.Join(nodePath, sub))
+	}
+	if err := r.checkMounted(paths...); err != nil {
+		return err
+	}
+	// the subs are deleted if given, otherwise the nodePath.
+	writes := []*dataWrite{}
+	for _, p := range paths[1:] {
+		writes = append(writes, deleteWrite(p))
+	}
+	if len(subs) == 0 {
+		writes = append(writes, deleteWrite(nodePath))
+	}
+	if err := r.checkSchemas(writes...); err != nil {
+		return err
+	}
 	if len(subs) > 0 {
 		for _, sub := range subs {
 			subPath := path.Join(nodePath, sub)
@@ -333,6 +615,9 @@ func (r *MetadataRepo) DeleteData(nodePath string, subs ...string) error {
 				if err != nil {
 					return err
 				}
+				if err := r.deleteValueTypes(subPath); err != nil {
+					return err
+				}
 			}
 		}
 		return nil
@@ -340,7 +625,10 @@ func (r *MetadataRepo) DeleteData(nodePath string, subs ...string) error {
 		_, v := r.data.Get(nodePath)
 		if v != nil {
 			_, dir := v.(map[string]interface{})
-			return r.storeClient.Delete(nodePath, dir)
+			if err := r.storeClient.Delete(nodePath, dir); err != nil {
+				return err
+			}
+			return r.deleteValueTypes(nodePath)
 		}
 		return nil
 	}
@@ -361,20 +649,20 @@ func (r *MetadataRepo) PutMapping(nodePath string, data interface{}, replace boo
 			return errors.New("mapping data should be json object.")
 		}
 		for k, v := range m {
-			ip := net.ParseIP(k)
-			if ip == nil {
-				return errors.New("mapping's first level key should be ip .")
+			err := checkMappingHost(k)
+			if err != nil {
+				return err
 			}
-			err := checkMapping(v)
+			err = checkMapping(v)
 			if err != nil {
 				return err
 			}
 		}
 	} else {
 		parts := strings.Split(nodePath, "/")
-		ip := net.ParseIP(parts[1])
-		if ip == nil {
-			return errors.New("mapping's first level key should be ip .")
+		err := checkMappingHost(parts[1])
+		if err != nil {
+			return err
 		}
 		// nodePath: /ip
 		if len(parts) == 2 {
@@ -434,10 +722,159 @@ func (r *MetadataRepo) DeleteMapping(nodePath string, subs ...string) error {
 	}
 }
 
+// SyncStatus return whether the backend has been synced, and how long the sync is behind backend.
+func (r *MetadataRepo) SyncStatus() (bool, time.Duration, error) {
+	return r.storeClient.SyncStatus()
+}
+
+// Campaign campaign for the leader of the metad group in background until stopChan closed, see StoreClient.Campaign.
+func (r *MetadataRepo) Campaign(candidate string, ttl int, leaderChan chan<- bool, stopChan chan bool) {
+	r.storeClient.Campaign(candidate, ttl, leaderChan, stopChan)
+}
+
+// Leader return the candidate of the current leader of the metad group, empty if no leader.
+func (r *MetadataRepo) Leader() (string, error) {
+	return r.storeClient.Leader()
+}
+
+// BackendEndpoints return the health of the backend endpoints, see StoreClient.Endpoints.
+func (r *MetadataRepo) BackendEndpoints() []*store.EndpointStatus {
+	return r.storeClient.Endpoints()
+}
+
+// SetBackendEndpoints replace the backend endpoints at runtime.
+func (r *MetadataRepo) SetBackendEndpoints(endpoints []string) error {
+	return r.storeClient.SetEndpoints(endpoints)
+}
+
 func (r *MetadataRepo) DataVersion() int64 {
 	return r.data.Version()
 }
 
+func (r *MetadataRepo) MappingVersion() int64 {
+	return r.mapping.Version()
+}
+
+// QuotaError is the error of the data write exceeding the quota.
+type QuotaError struct {
+	error
+}
+
+// IsQuotaError return whether the error is caused by exceeding the quota.
+func IsQuotaError(err error) bool {
+	_, ok := err.(*QuotaError)
+	return ok
+}
+
+// SetDataQuotas set the budgets of the data top level prefixes, the syncs exceeding the budget are dropped if reject,
+// otherwise are applied and flagged.
+// The data writes of the repo exceeding the budget are rejected with QuotaError before written to backend if reject,
+// so the backend and the store do not diverge.
+func (r *MetadataRepo) SetDataQuotas(quotas map[string]store.Quota, reject bool) {
+	var rejectFlag int32
+	if reject {
+		rejectFlag = 1
+	}
+	atomic.StoreInt32(&r.quotaReject, rejectFlag)
+	r.data.SetQuotas(quotas, reject)
+}
+
+// checkQuota return QuotaError if put the data would exceed the quota in reject mode.
+func (r *MetadataRepo) checkQuota(nodePath string, data interface{}, replace bool) error {
+	if atomic.LoadInt32(&r.quotaReject) == 0 {
+		return nil
+	}
+	if err := r.data.CheckQuota(nodePath, data, replace); err != nil {
+		return &QuotaError{err}
+	}
+	return nil
+}
+
+// DataUsage return the keys and bytes of the data top level prefixes, and their quotas.
+func (r *MetadataRepo) DataUsage() []*store.Usage {
+	return r.data.Usage()
+}
+
+// DataMemoryUsage return the approximate memory of the data subtree at nodePath, and its heaviest limit subtrees depth
+// levels below.
+func (r *MetadataRepo) DataMemoryUsage(nodePath string, depth int, limit int) (*store.MemoryUsage, []*store.MemoryUsage) {
+	return r.data.MemoryUsage(nodePath, depth, limit)
+}
+
+// SetIndexKeys set the leaf names of the data inverted index, such as ip, the index is rebuilt.
+func (r *MetadataRepo) SetIndexKeys(keys []string) {
+	r.data.SetIndexKeys(keys)
+}
+
+// IndexKeys return the leaf names of the data inverted index.
+func (r *MetadataRepo) IndexKeys() []string {
+	return r.data.IndexKeys()
+}
+
+// FindData return the data paths under prefix of the leaves named key with the value, and false if the key is not indexed.
+func (r *MetadataRepo) FindData(key string, value string, prefix string) ([]string, bool) {
+	return r.data.Find(key, value, prefix)
+}
+
+// DataMeta return the change metadata of the data node and its children, nil if not exist.
+func (r *MetadataRepo) DataMeta(nodePath string) *store.NodeMeta {
+	return r.data.Meta(nodePath)
+}
+
+// DataRevision return the revision of the data path, the data version of the last change of the path or under it, 0
+// if not exist. The revision is local to the metad as the data version.
+func (r *MetadataRepo) DataRevision(nodePath string) int64 {
+	return r.data.Revision(nodePath)
+}
+
+// WaitDataSynced wait until the data of nodePath in the store is same as in the backend, such as after a write, return
+// false if timeout. The write changing nothing is synced at once.
+func (r *MetadataRepo) WaitDataSynced(nodePath string, timeout time.Duration) bool {
+	nodePath = path.Join("/", nodePath)
+	// watch before the compare, so the change synced after the compare is not missed.
+	w := r.data.Watch(nodePath, 1)
+	defer w.Remove()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		backendVal, err := r.GetBackendData(nodePath)
+		if err != nil {
+			logger.Warn("Read backend data of [%s] error: %s", nodePath, err.Error())
+			return false
+		}
+		_, storeVal := r.data.Get(nodePath)
+		if reflect.DeepEqual(flattenValue(storeVal), flattenValue(backendVal)) {
+			return true
+		}
+		select {
+		case _, ok := <-w.EventChan():
+			if !ok {
+				return false
+			}
+		case <-timer.C:
+			return false
+		}
+	}
+}
+
+// SetNodeHistory set the number of the previous values kept by every data leaf.
+func (r *MetadataRepo) SetNodeHistory(size int) {
+	r.data.SetHistorySize(size)
+}
+
+// DataCreatedOrder return the creation orders of the children of the data dir by name, nil if not a dir.
+func (r *MetadataRepo) DataCreatedOrder(nodePath string) map[string]int64 {
+	return r.data.CreatedOrder(nodePath)
+}
+
+// ReadRevision return the data version, and the revision of data, mapping, mapping rules, access rules, annotations, value types,
+// the typed values switch and aliases, the response of Root and Self only changes when the revision changes.
+func (r *MetadataRepo) ReadRevision() (int64, string) {
+	dataVersion := r.data.Version()
+	return dataVersion, fmt.Sprintf("%d-%d-%d-%d-%d-%d-%d-%d", dataVersion, r.mapping.Version(), r.records[RecordMappingRule].Version(), r.accessStore.Version(),
+		r.records[RecordAnnotation].Version(), r.records[RecordValueType].Version(), atomic.LoadInt32(&r.typedValues), r.records[RecordAlias].Version())
+}
+
 func (r *MetadataRepo) PutAccessRule(rulesMap map[string][]store.AccessRule) error {
 	for _, v := range rulesMap {
 		err := store.CheckAccessRules(v)
@@ -468,6 +905,16 @@ func checkSubs(subs []string) error {
 	return nil
 }
 
+var clientIdentityRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._@:-]*$`)
+
+// checkMappingHost check the mapping's first level key, it should be ip or client identity, such as tls cert's CN.
+func checkMappingHost(host string) error {
+	if net.ParseIP(host) == nil && !clientIdentityRegexp.MatchString(host) {
+		return errors.New("mapping's first level key should be ip or client identity.")
+	}
+	return nil
+}
+
 func checkMapping(data interface{}) error {
 	m, ok := data.(map[string]interface{})
 	if !ok {
@@ -498,8 +945,10 @@ func checkMappingPath(v interface{}) error {
 	if !vok {
 		return errors.New("mapping's value should be path .")
 	}
-	if vs == "" || vs[0] != '/' {
-		return errors.New("mapping's value should be path .")
+	for _, layer := range linkLayers(vs) {
+		if layer == "" || layer[0] != '/' {
+			return errors.New("mapping's value should be path .")
+		}
 	}
 	return nil
 }
@@ -10,9 +10,15 @@ package metadata
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"math/rand"
+	"os"
+	"path"
 	"reflect"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 
@@ -562,6 +568,860 @@ func TestAccessRule(t *testing.T) {
 	metarepo.StopSync()
 }
 
+func TestMetarepoCache(t *testing.T) {
+	metarepo := NewTestMetarepo()
+	metarepo.StartSync()
+	testData := FillTestData(metarepo)
+	metarepo.PutMapping("/", map[string]interface{}{"192.168.1.1": map[string]interface{}{"host": "/nodes/1"}}, true)
+	time.Sleep(sleepTime)
+
+	dir, err := ioutil.TempDir("", "metad")
+	Assert(t, err == nil)
+	defer os.RemoveAll(dir)
+	cacheFile := path.Join(dir, "cache.json")
+	Assert(t, nil == metarepo.SaveCache(cacheFile))
+	excludedFile := path.Join(dir, "excluded.json")
+	Assert(t, nil == metarepo.SaveCache(excludedFile, "/nodes/1"))
+	metarepo.StopSync()
+
+	// load the cache without sync.
+	metarepo2 := NewTestMetarepo()
+	savedAt, err := metarepo2.LoadCache(cacheFile)
+	Assert(t, err == nil && !savedAt.IsZero(), err)
+	ValidTestData(t, testData, metarepo2.data)
+	Assert(t, "/nodes/1" == metarepo2.GetMapping("/192.168.1.1/host"))
+
+	savedAt, err = metarepo2.LoadCache(path.Join(dir, "notexist.json"))
+	Assert(t, err == nil && savedAt.IsZero())
+	ioutil.WriteFile(cacheFile, []byte("invalid"), 0600)
+	_, err = metarepo2.LoadCache(cacheFile)
+	Assert(t, err != nil)
+
+	// the excluded prefixes are not saved.
+	metarepo3 := NewTestMetarepo()
+	_, err = metarepo3.LoadCache(excludedFile)
+	Assert(t, err == nil, err)
+	_, val := metarepo3.data.Get("/nodes/1")
+	Assert(t, nil == val, val)
+	_, val = metarepo3.data.Get("/nodes/2")
+	Assert(t, nil != val)
+}
+
+func TestMetarepoMappingRule(t *testing.T) {
+	metarepo := NewTestMetarepo()
+	metarepo.StartSync()
+	defer metarepo.StopSync()
+
+	err := metarepo.PutData("/hosts", map[string]interface{}{
+		"10.2.0.5": map[string]interface{}{"name": "h5"},
+		"default":  map[string]interface{}{"name": "default"},
+	}, true)
+	Assert(t, nil == err)
+
+	Assert(t, nil != metarepo.PutMappingRule(&MappingRule{Name: "bad/name", Match: "10.2.0.0/24", Mapping: map[string]interface{}{"node": "/hosts/{ip}"}}))
+	Assert(t, nil != metarepo.PutMappingRule(&MappingRule{Name: "bad", Match: "10.2.0.0/33", Mapping: map[string]interface{}{"node": "/hosts/{ip}"}}))
+	Assert(t, nil != metarepo.PutMappingRule(&MappingRule{Name: "bad", Match: "10.2.[", Mapping: map[string]interface{}{"node": "/hosts/{ip}"}}))
+	Assert(t, nil != metarepo.PutMappingRule(&MappingRule{Name: "bad", Match: "10.2.*"}))
+	Assert(t, nil != metarepo.PutMappingRule(&MappingRule{Name: "bad", Match: "10.2.*", Mapping: map[string]interface{}{"node": "hosts"}}))
+
+	Assert(t, nil == metarepo.PutMappingRule(&MappingRule{Name: "1-subnet", Match: "10.2.0.0/24", Mapping: map[string]interface{}{"node": "/hosts/{ip}"}}))
+	Assert(t, nil == metarepo.PutMappingRule(&MappingRule{Name: "2-wildcard", Match: "10.*", Mapping: map[string]interface{}{"node": "/hosts/default"}}))
+	time.Sleep(sleepTime)
+	Assert(t, 2 == len(metarepo.GetMappingRules()))
+
+	Assert(t, "h5" == metarepo.Self("10.2.0.5", "/node/name"))
+	Assert(t, reflect.DeepEqual([]string{"/hosts/10.2.0.5/name"}, metarepo.SelfPaths("10.2.0.5", "/node/name")))
+	// the first rule in name order match.
+	Assert(t, "default" == metarepo.Self("10.3.0.1", "/node/name"))
+	Assert(t, nil == metarepo.Self("10.2.0.6", "/node/name"))
+	Assert(t, nil == metarepo.Self("192.168.1.1", "/"))
+	Assert(t, 0 == len(metarepo.GetUnreferencedData()), metarepo.GetUnreferencedData())
+
+	// the explicit mapping override the rules.
+	err = metarepo.PutMapping("/10.2.0.5", map[string]interface{}{"node": "/hosts/default"}, true)
+	Assert(t, nil == err)
+	time.Sleep(sleepTime)
+	Assert(t, "default" == metarepo.Self("10.2.0.5", "/node/name"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := make(chan interface{})
+	go func() {
+		ch <- metarepo.WatchSelf(ctx, "10.2.0.9", "/node")
+	}()
+	time.Sleep(sleepTime)
+	Assert(t, nil == metarepo.PutData("/hosts/10.2.0.9/name", "h9", false))
+	result := <-ch
+	Assert(t, "UPDATE|h9" == fmt.Sprint(result.(map[string]interface{})["name"]), result)
+
+	Assert(t, nil == metarepo.DeleteMappingRule("1-subnet"))
+	time.Sleep(sleepTime)
+	Assert(t, "default" == metarepo.Self("10.2.0.9", "/node/name"))
+}
+
+func TestMetarepoFetch(t *testing.T) {
+	metarepo := NewTestMetarepo()
+	metarepo.StartSync()
+	defer metarepo.StopSync()
+
+	err := metarepo.PutData("/hosts/1", map[string]interface{}{"name": "h1", "ip": "10.0.0.1"}, true)
+	Assert(t, nil == err)
+	err = metarepo.PutMapping("/192.168.1.1", map[string]interface{}{"host": "/hosts/2", "name": "/hosts/2/name"}, true)
+	Assert(t, nil == err)
+	time.Sleep(sleepTime)
+
+	val, err := metarepo.GetBackendData("/hosts/1/name")
+	Assert(t, nil == err)
+	Assert(t, "h1" == val)
+	val, err = metarepo.GetBackendData("/hosts/1")
+	Assert(t, nil == err)
+	Assert(t, "10.0.0.1" == val.(map[string]interface{})["ip"], val)
+	val, err = metarepo.GetBackendData("/hosts/3")
+	Assert(t, nil == err)
+	Assert(t, nil == val)
+
+	fetched := []string{}
+	fetch := func(nodePath string) (interface{}, error) {
+		fetched = append(fetched, nodePath)
+		if nodePath == "/hosts/2" {
+			return map[string]interface{}{"name": "h2"}, nil
+		}
+		if nodePath == "/hosts/2/name" {
+			return "h2", nil
+		}
+		return nil, nil
+	}
+	val, err = metarepo.SelfFrom("192.168.1.1", "/host/name", fetch)
+	Assert(t, nil == err)
+	Assert(t, "h2" == val, val)
+	Assert(t, reflect.DeepEqual([]string{"/hosts/2/name"}, fetched), fetched)
+	val, err = metarepo.SelfFrom("192.168.1.1", "/", fetch)
+	Assert(t, nil == err)
+	Assert(t, "h2" == val.(map[string]interface{})["name"], val)
+	// no mapping.
+	val, err = metarepo.SelfFrom("192.168.1.2", "/", fetch)
+	Assert(t, nil == err)
+	Assert(t, nil == val)
+
+	val, err = metarepo.RootFrom("192.168.1.1", "/hosts/2", fetch)
+	Assert(t, nil == err)
+	Assert(t, "h2" == val.(map[string]interface{})["name"], val)
+	val, err = metarepo.RootFrom("192.168.1.1", "/hosts/3", fetch)
+	Assert(t, nil == err)
+	Assert(t, nil == val)
+
+	_, err = metarepo.RootFrom("192.168.1.1", "/hosts/2", func(nodePath string) (interface{}, error) {
+		return nil, errors.New("unreachable")
+	})
+	Assert(t, nil != err)
+	// the local store is not changed.
+	Assert(t, nil == metarepo.GetData("/hosts/2"))
+}
+
+func TestMetarepoMappingVars(t *testing.T) {
+	metarepo := NewTestMetarepo()
+	metarepo.StartSync()
+	defer metarepo.StopSync()
+
+	err := metarepo.PutData("/", map[string]interface{}{
+		"hosts": map[string]interface{}{"h1": map[string]interface{}{"name": "h1"}, "h2": map[string]interface{}{"name": "h2"}},
+		"ips":   map[string]interface{}{"10.0.0.1": "ip1", "10.0.0.2": "ip2"},
+		"subs":  map[string]interface{}{"i-1": "sub1"},
+	}, true)
+	Assert(t, nil == err)
+	err = metarepo.PutMapping("/gw", map[string]interface{}{
+		"node": "/hosts/{hostname}",
+		"ip":   "/ips/{ip}",
+		"sub":  "/subs/{token.sub}",
+	}, true)
+	Assert(t, nil == err)
+	time.Sleep(sleepTime)
+
+	repo := metarepo.ForClient(MappingVars{MappingVarIP: "10.0.0.1", MappingVarHostname: "h1", MappingVarTokenSub: "i-1"})
+	Assert(t, "h1" == repo.Self("gw", "/node/name"))
+	Assert(t, "ip1" == repo.Self("gw", "/ip"))
+	Assert(t, "sub1" == repo.Self("gw", "/sub"))
+	Assert(t, reflect.DeepEqual([]string{"/hosts/h1/name"}, repo.SelfPaths("gw", "/node/name")))
+	_, val := repo.Root("gw", "/hosts/h1/name")
+	Assert(t, "h1" == val)
+	_, val = repo.Root("gw", "/hosts/h2/name")
+	Assert(t, nil == val)
+
+	repo = metarepo.ForClient(MappingVars{MappingVarIP: "10.0.0.2", MappingVarHostname: "h2"})
+	Assert(t, "h2" == repo.Self("gw", "/node/name"))
+	Assert(t, "ip2" == repo.Self("gw", "/ip"))
+	// unresolved placeholder is omitted.
+	Assert(t, nil == repo.Self("gw", "/sub"))
+	self := repo.Self("gw", "/").(map[string]interface{})
+	_, ok := self["sub"]
+	Assert(t, !ok, self)
+
+	// the placeholder value should not escape the templated subtree.
+	repo = metarepo.ForClient(MappingVars{MappingVarHostname: "../ips"})
+	Assert(t, nil == repo.Self("gw", "/node"))
+	// without vars, {ip} is the client identity and others are unresolved.
+	Assert(t, nil == metarepo.Self("gw", "/node"))
+	Assert(t, reflect.DeepEqual([]string{"/ips/gw"}, metarepo.SelfPaths("gw", "/ip")))
+
+	// the templated links reference the parent.
+	Assert(t, 0 == len(metarepo.GetUnreferencedData()), metarepo.GetUnreferencedData())
+}
+
+func TestMetarepoLayeredMapping(t *testing.T) {
+	metarepo := NewTestMetarepo()
+	metarepo.StartSync()
+	defer metarepo.StopSync()
+
+	err := metarepo.PutData("/", map[string]interface{}{
+		"defaults":  map[string]interface{}{"nginx": map[string]interface{}{"port": "80", "workers": "4", "log": map[string]interface{}{"level": "info", "file": "/var/log/nginx.log"}}},
+		"overrides": map[string]interface{}{"10.0.0.1": map[string]interface{}{"nginx": map[string]interface{}{"workers": "8", "log": map[string]interface{}{"level": "debug"}}}},
+	}, true)
+	Assert(t, nil == err)
+	err = metarepo.PutMapping("/10.0.0.1", map[string]interface{}{"nginx": "/defaults/nginx|/overrides/{ip}/nginx"}, true)
+	Assert(t, nil == err)
+	err = metarepo.PutMapping("/10.0.0.2", map[string]interface{}{"nginx": "/defaults/nginx | /overrides/{ip}/nginx | /overrides/{hostname}/nginx"}, true)
+	Assert(t, nil == err)
+	Assert(t, nil != metarepo.PutMapping("/10.0.0.3", map[string]interface{}{"nginx": "/defaults/nginx|overrides"}, true))
+	time.Sleep(sleepTime)
+
+	// the override layer is merged into the base layer.
+	val := metarepo.Self("10.0.0.1", "/")
+	Assert(t, reflect.DeepEqual(map[string]interface{}{"nginx": map[string]interface{}{"port": "80", "workers": "8",
+		"log": map[string]interface{}{"level": "debug", "file": "/var/log/nginx.log"}}}, val), val)
+	Assert(t, "8" == metarepo.Self("10.0.0.1", "/nginx/workers"))
+	Assert(t, "/var/log/nginx.log" == metarepo.Self("10.0.0.1", "/nginx/log/file"))
+	Assert(t, reflect.DeepEqual([]string{"/defaults/nginx/port", "/overrides/10.0.0.1/nginx/port"}, metarepo.SelfPaths("10.0.0.1", "/nginx/port")))
+	// the missing override and the unresolved layers are omitted.
+	val = metarepo.Self("10.0.0.2", "/nginx/workers")
+	Assert(t, "4" == val, val)
+	// the base layer is not modified.
+	Assert(t, "4" == metarepo.GetData("/defaults/nginx/workers"))
+
+	// the changes of every layer are watched.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for _, nodePath := range []string{"/overrides/10.0.0.1/nginx/port", "/defaults/nginx/port"} {
+		ch := make(chan interface{})
+		go func() {
+			ch <- metarepo.WatchSelf(ctx, "10.0.0.1", "/nginx")
+		}()
+		time.Sleep(sleepTime)
+		Assert(t, nil == metarepo.PutData(nodePath, "8080", true))
+		result := <-ch
+		Assert(t, reflect.DeepEqual(map[string]interface{}{"port": "UPDATE|8080"}, result), result)
+	}
+	Assert(t, "8080" == metarepo.Self("10.0.0.1", "/nginx/port"))
+
+	// the layers linked to the moved path are relinked.
+	_, err = metarepo.MoveData("/overrides", "/node-overrides")
+	Assert(t, nil == err)
+	time.Sleep(sleepTime)
+	Assert(t, "/defaults/nginx|/node-overrides/{ip}/nginx" == metarepo.GetMapping("/10.0.0.1/nginx"), metarepo.GetMapping("/10.0.0.1/nginx"))
+}
+
+func TestMetarepoAlias(t *testing.T) {
+	metarepo := NewTestMetarepo()
+	metarepo.DeleteData("/")
+	clientIP := "192.168.0.1"
+	metarepo.PutAccessRule(map[string][]store.AccessRule{
+		clientIP: {{Path: "/", Mode: store.AccessModeRead}, {Path: "/secrets", Mode: store.AccessModeForbidden}},
+	})
+	metarepo.StartSync()
+	defer metarepo.StopSync()
+
+	err := metarepo.PutData("/", map[string]interface{}{
+		"clusters": map[string]interface{}{"cl-1": map[string]interface{}{"name": "db", "size": "3"}},
+		"secrets":  map[string]interface{}{"key": "s3cr3t"},
+	}, true)
+	Assert(t, nil == err)
+	time.Sleep(sleepTime)
+	Assert(t, nil == metarepo.PutAlias(&Alias{Path: "/zones/z1/db", Target: "/clusters/cl-1"}))
+	Assert(t, nil == metarepo.PutAlias(&Alias{Path: "/apps/db", Target: "zones/z1/db"}))
+	Assert(t, nil == metarepo.PutAlias(&Alias{Path: "/zones/z1/secret", Target: "/secrets"}))
+	time.Sleep(sleepTime)
+
+	// the alias and its sub paths are resolved to the target, the alias of alias too.
+	Assert(t, reflect.DeepEqual(map[string]interface{}{"name": "db", "size": "3"}, metarepo.ReadData("/zones/z1/db")))
+	Assert(t, "3" == metarepo.ReadData("/apps/db/size"))
+	_, val := metarepo.Root(clientIP, "/apps/db/name")
+	Assert(t, "db" == val, val)
+	// the targets are grafted into the dir containing the aliases, the access rule of the target is checked.
+	_, val = metarepo.Root(clientIP, "/zones")
+	Assert(t, reflect.DeepEqual(map[string]interface{}{"z1": map[string]interface{}{"db": map[string]interface{}{"name": "db", "size": "3"}}}, val), val)
+	Assert(t, "s3cr3t" == metarepo.ReadData("/zones/z1/secret/key"))
+	_, val = metarepo.Root(clientIP, "/zones/z1/secret/key")
+	Assert(t, nil == val)
+	// the data is not copied.
+	Assert(t, nil == metarepo.GetData("/zones"))
+
+	// the alias should not have data, or make a cycle.
+	Assert(t, nil != metarepo.PutAlias(&Alias{Path: "/secrets", Target: "/clusters"}))
+	Assert(t, nil != metarepo.PutAlias(&Alias{Path: "/clusters/cl-1/zone", Target: "/clusters"}))
+	Assert(t, nil != metarepo.PutAlias(&Alias{Path: "/clusters/cl-1/zone", Target: "/zones"}))
+	Assert(t, nil != metarepo.PutAlias(&Alias{Path: "/", Target: "/clusters"}))
+	Assert(t, 3 == len(metarepo.GetAliases()))
+
+	// the watch of the alias watch the target.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := make(chan interface{})
+	go func() {
+		ch <- metarepo.Watch(ctx, clientIP, "/apps/db")
+	}()
+	time.Sleep(sleepTime)
+	Assert(t, nil == metarepo.PutData("/clusters/cl-1/size", "5", true))
+	result := <-ch
+	Assert(t, reflect.DeepEqual(map[string]interface{}{"size": "UPDATE|5"}, result), result)
+
+	Assert(t, nil == metarepo.DeleteAlias("/zones/z1/db"))
+	time.Sleep(sleepTime)
+	Assert(t, nil == metarepo.ReadData("/apps/db"))
+	Assert(t, nil == metarepo.GetAlias("/zones/z1/db"))
+}
+
+func TestMetarepoImportMappings(t *testing.T) {
+	metarepo := NewTestMetarepo()
+	metarepo.StartSync()
+	defer metarepo.StopSync()
+
+	err := metarepo.PutMapping("/", map[string]interface{}{
+		"10.0.0.1": map[string]interface{}{"node": "/nodes/1", "env": map[string]interface{}{"a": "/env/a"}},
+		"10.0.0.2": map[string]interface{}{"node": "/nodes/2"},
+		"10.0.0.3": map[string]interface{}{"node": "/nodes/3"},
+	}, true)
+	Assert(t, nil == err)
+	time.Sleep(sleepTime)
+
+	table := metarepo.ExportMappings()
+	Assert(t, 3 == len(table.Mappings))
+	Assert(t, table.Version > 0)
+
+	mappings, total, err := metarepo.ListMappings("10.0.0.*", 1, 1)
+	Assert(t, nil == err)
+	Assert(t, 3 == total)
+	Assert(t, 1 == len(mappings) && "10.0.0.2" == mappings[0].Host, mappings)
+	_, _, err = metarepo.ListMappings("10.[", 0, 0)
+	Assert(t, nil != err)
+
+	newTable := &MappingTable{Version: table.Version, Mappings: map[string]interface{}{
+		"10.0.0.1": map[string]interface{}{"node": "/nodes/1", "env": "/env"},
+		"10.0.0.2": map[string]interface{}{"node": "/nodes/2"},
+		"10.0.0.4": map[string]interface{}{"node": "/nodes/4"},
+	}}
+	// validation error of all hosts, nothing written.
+	_, err = metarepo.ImportMappings(&MappingTable{Mappings: map[string]interface{}{
+		"10.0.0.5": map[string]interface{}{"node": "nodes/5"},
+		"bad/host": map[string]interface{}{"node": "/nodes/6"},
+		"10.0.0.7": map[string]interface{}{"node": "/nodes/7"},
+	}}, true, false)
+	mappingErr, ok := err.(*MappingError)
+	Assert(t, ok, err)
+	Assert(t, 2 == len(mappingErr.Errors), mappingErr.Errors)
+
+	diff, err := metarepo.ImportMappings(newTable, true, true)
+	Assert(t, nil == err)
+	Assert(t, diff.DryRun)
+	Assert(t, reflect.DeepEqual([]string{"10.0.0.4"}, diff.Added), diff.Added)
+	Assert(t, reflect.DeepEqual([]string{"10.0.0.1"}, diff.Updated), diff.Updated)
+	Assert(t, reflect.DeepEqual([]string{"10.0.0.3"}, diff.Removed), diff.Removed)
+	Assert(t, 1 == diff.Unchanged)
+	time.Sleep(sleepTime)
+	Assert(t, 3 == len(metarepo.ExportMappings().Mappings))
+	Assert(t, nil == metarepo.GetMapping("/10.0.0.4"))
+
+	diff, err = metarepo.ImportMappings(newTable, true, false)
+	Assert(t, nil == err)
+	Assert(t, !diff.DryRun)
+	time.Sleep(sleepTime)
+	Assert(t, reflect.DeepEqual(newTable.Mappings, metarepo.ExportMappings().Mappings), metarepo.ExportMappings().Mappings)
+
+	// the table has been changed since the version.
+	_, err = metarepo.ImportMappings(newTable, true, false)
+	Assert(t, ErrMappingConflict == err)
+
+	// import merge the hosts.
+	diff, err = metarepo.ImportMappings(&MappingTable{Mappings: map[string]interface{}{
+		"10.0.0.5": map[string]interface{}{"node": "/nodes/5"},
+	}}, false, false)
+	Assert(t, nil == err)
+	Assert(t, 0 == len(diff.Removed))
+	time.Sleep(sleepTime)
+	Assert(t, 4 == len(metarepo.ExportMappings().Mappings))
+}
+
+func TestMetarepoVerify(t *testing.T) {
+	metarepo := NewTestMetarepo()
+	metarepo.StartSync()
+	defer metarepo.StopSync()
+
+	FillTestData(metarepo)
+	err := metarepo.PutMapping("/", map[string]interface{}{
+		"192.168.1.1": map[string]interface{}{"node": "/nodes/1"},
+	}, true)
+	Assert(t, nil == err)
+	time.Sleep(sleepTime)
+
+	report, err := metarepo.Verify(false)
+	Assert(t, nil == err)
+	Assert(t, report.Consistent, report.Data, report.Mapping)
+	Assert(t, report.Data.StoreHash == report.Data.BackendHash)
+	Assert(t, report.Data.StoreKeys > 0)
+
+	// make the store diverge from backend behind the sync.
+	metarepo.data.Put("/nodes/1/ip", "10.0.0.1")
+	metarepo.data.Delete("/nodes/2/name")
+	metarepo.data.Put("/extra", "x")
+	metarepo.mapping.Delete("/192.168.1.1/node")
+
+	report, err = metarepo.Verify(false)
+	Assert(t, nil == err)
+	Assert(t, !report.Consistent)
+	Assert(t, reflect.DeepEqual([]string{"/nodes/2/name"}, report.Data.Missing), report.Data.Missing)
+	Assert(t, reflect.DeepEqual([]string{"/extra"}, report.Data.Extra), report.Data.Extra)
+	Assert(t, reflect.DeepEqual([]string{"/nodes/1/ip"}, report.Data.Mismatched), report.Data.Mismatched)
+	Assert(t, 1 == report.Mapping.MissingCount)
+	Assert(t, 0 == report.Data.Repaired)
+
+	report, err = metarepo.Verify(true)
+	Assert(t, nil == err)
+	Assert(t, 3 == report.Data.Repaired)
+	Assert(t, 1 == report.Mapping.Repaired)
+
+	report, err = metarepo.Verify(false)
+	Assert(t, nil == err)
+	Assert(t, report.Consistent, report.Data, report.Mapping)
+	Assert(t, "192.168.1.1" == metarepo.GetData("/nodes/1/ip"))
+}
+
+func TestMetarepoWatchSelfRemap(t *testing.T) {
+	metarepo := NewTestMetarepo()
+	metarepo.StartSync()
+	defer metarepo.StopSync()
+
+	err := metarepo.PutData("/hosts", map[string]interface{}{
+		"1": map[string]interface{}{"name": "h1", "zone": "z1"},
+		"2": map[string]interface{}{"name": "h2"},
+	}, true)
+	Assert(t, nil == err)
+	ip := "192.168.1.1"
+	err = metarepo.PutMapping(ip, map[string]interface{}{"host": "/hosts/1"}, true)
+	Assert(t, nil == err)
+	time.Sleep(sleepTime)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := make(chan []*store.Event, 1)
+	go func() {
+		ch <- metarepo.WatchSelfEvents(ctx, ip, "/host")
+	}()
+	time.Sleep(sleepTime)
+	err = metarepo.PutMapping(ip, map[string]interface{}{"host": "/hosts/2"}, true)
+	Assert(t, nil == err)
+	events := <-ch
+	Assert(t, 2 == len(events), events)
+	Assert(t, store.Update == events[0].Action && "/host/name" == events[0].Path && "h2" == events[0].Value, events[0])
+	Assert(t, store.Delete == events[1].Action && "/host/zone" == events[1].Path && "z1" == events[1].Value, events[1])
+	Assert(t, ActorMapping == events[0].Actor)
+
+	// the client mapped by rule is remapped by the rule change.
+	err = metarepo.PutMappingRule(&MappingRule{Name: "subnet", Match: "10.2.0.0/24", Mapping: map[string]interface{}{"host": "/hosts/1"}})
+	Assert(t, nil == err)
+	time.Sleep(sleepTime)
+	ch2 := make(chan interface{}, 1)
+	go func() {
+		ch2 <- metarepo.WatchSelf(ctx, "10.2.0.1", "/")
+	}()
+	time.Sleep(sleepTime)
+	err = metarepo.PutMappingRule(&MappingRule{Name: "subnet", Match: "10.2.0.0/24", Mapping: map[string]interface{}{"host": "/hosts/2"}})
+	Assert(t, nil == err)
+	select {
+	case result := <-ch2:
+		fmap := flatmap.Flatten(result.(map[string]interface{}))
+		Assert(t, "UPDATE|h2" == fmap["/host/name"], fmap)
+		Assert(t, "DELETE|z1" == fmap["/host/zone"], fmap)
+	case <-time.After(3 * mappingRuleCheckInterval):
+		t.Fatal("watch self not woken up by the rule change")
+	}
+}
+
+func TestMetarepoSyncPause(t *testing.T) {
+	metarepo := NewTestMetarepo()
+	metarepo.StartSync()
+	defer metarepo.StopSync()
+
+	err := metarepo.PutData("/nodes", map[string]interface{}{
+		"1": map[string]interface{}{"ip": "192.168.1.1"},
+		"2": map[string]interface{}{"ip": "192.168.1.2"},
+	}, true)
+	Assert(t, nil == err)
+	time.Sleep(sleepTime)
+
+	Assert(t, nil == metarepo.PauseSync("/nodes"))
+	Assert(t, IsSyncStateError(metarepo.PauseSync("/nodes/")))
+	Assert(t, nil == metarepo.PutData("/nodes/1/ip", "192.168.2.1", false))
+	Assert(t, nil == metarepo.PutData("/hosts/1/ip", "192.168.1.1", false))
+	time.Sleep(sleepTime)
+	// the paused prefix keep the view, others are synced.
+	Assert(t, "192.168.1.1" == metarepo.GetData("/nodes/1/ip"))
+	Assert(t, "192.168.1.1" == metarepo.GetData("/hosts/1/ip"))
+	paused := metarepo.PausedSyncs()
+	Assert(t, 1 == len(paused) && "/nodes" == paused[0].Prefix && 1 == paused[0].Dropped, paused)
+
+	result, err := metarepo.ResumeSync("/nodes")
+	Assert(t, nil == err)
+	Assert(t, 1 == result.Updated && 0 == result.Deleted, result)
+	Assert(t, "192.168.2.1" == metarepo.GetData("/nodes/1/ip"))
+	Assert(t, 0 == len(metarepo.PausedSyncs()))
+	_, err = metarepo.ResumeSync("/nodes")
+	Assert(t, IsSyncStateError(err))
+
+	// the delete of the parent keep the paused prefix.
+	Assert(t, nil == metarepo.PauseSync("/nodes/1"))
+	Assert(t, nil == metarepo.DeleteData("/nodes"))
+	time.Sleep(sleepTime)
+	Assert(t, "192.168.2.1" == metarepo.GetData("/nodes/1/ip"))
+	Assert(t, nil == metarepo.GetData("/nodes/2"))
+	result, err = metarepo.ResumeSync("/nodes/1")
+	Assert(t, nil == err)
+	Assert(t, 1 == result.Deleted, result)
+	Assert(t, nil == metarepo.GetData("/nodes/1"))
+
+	// resync drop the values not in backend.
+	metarepo.data.Put("/hosts/2/ip", "192.168.1.2")
+	metarepo.data.Put("/hosts/1/ip", "10.0.0.1")
+	result, err = metarepo.ResyncData("/hosts")
+	Assert(t, nil == err)
+	Assert(t, 1 == result.Updated && 1 == result.Deleted, result)
+	Assert(t, "192.168.1.1" == metarepo.GetData("/hosts/1/ip"))
+	Assert(t, nil == metarepo.GetData("/hosts/2"))
+}
+
+func TestMetarepoMountData(t *testing.T) {
+	metarepo := NewTestMetarepo()
+	metarepo.MountData("/catalog")
+	metarepo.StartSync()
+	defer metarepo.StopSync()
+
+	updated, deleted := metarepo.PutMountedData("/catalog", map[string]interface{}{"a": "1", "b": map[string]interface{}{"c": "2"}})
+	Assert(t, 2 == updated && 0 == deleted)
+	Assert(t, "2" == metarepo.GetData("/catalog/b/c"))
+	updated, deleted = metarepo.PutMountedData("/catalog", map[string]interface{}{"a": "1", "d": "3"})
+	Assert(t, 1 == updated && 1 == deleted)
+	Assert(t, nil == metarepo.GetData("/catalog/b"))
+
+	Assert(t, IsMountError(metarepo.PutData("/catalog/a", "x", false)))
+	Assert(t, IsMountError(metarepo.DeleteData("/catalog")))
+	Assert(t, IsMountError(metarepo.DeleteData("/", "catalog")))
+	_, err := metarepo.CopyData("/nodes", "/catalog/nodes", &CopyOptions{})
+	Assert(t, IsMountError(err))
+
+	// the backend values of the mounted prefix are ignored.
+	Assert(t, nil == metarepo.storeClient.Put("/catalog/a", "x", false))
+	Assert(t, nil == metarepo.PutData("/nodes/1/ip", "192.168.1.1", false))
+	time.Sleep(sleepTime)
+	Assert(t, "1" == metarepo.GetData("/catalog/a"))
+	Assert(t, "192.168.1.1" == metarepo.GetData("/nodes/1/ip"))
+	report, err := metarepo.Verify(false)
+	Assert(t, nil == err)
+	Assert(t, report.Consistent, report.Data)
+}
+
+func TestMetarepoPatchData(t *testing.T) {
+	metarepo := NewTestMetarepo()
+	metarepo.StartSync()
+	defer metarepo.StopSync()
+
+	err := metarepo.PutData("/clusters/cl-1", map[string]interface{}{
+		"name": "cl-1",
+		"env":  map[string]interface{}{"debug": "false", "legacy": "true"},
+		"tags": map[string]interface{}{"a": "1"},
+	}, true)
+	Assert(t, nil == err)
+	time.Sleep(sleepTime)
+
+	p := metarepo.PlanPatch("/clusters/cl-1", map[string]interface{}{
+		"env":  map[string]interface{}{"debug": "true", "legacy": nil},
+		"tags": "none",
+		"name": map[string]interface{}{"short": "c1"},
+		"nil":  nil,
+	})
+	Assert(t, "/clusters/cl-1" == p.Path)
+	sort.Strings(p.Deletes)
+	Assert(t, 3 == len(p.Deletes) && "/clusters/cl-1/env/legacy" == p.Deletes[0] && "/clusters/cl-1/name" == p.Deletes[1] && "/clusters/cl-1/tags" == p.Deletes[2], p.Deletes)
+	Assert(t, nil == metarepo.PatchData(p))
+	time.Sleep(sleepTime)
+	Assert(t, "true" == metarepo.GetData("/clusters/cl-1/env/debug"))
+	Assert(t, nil == metarepo.GetData("/clusters/cl-1/env/legacy"))
+	Assert(t, "none" == metarepo.GetData("/clusters/cl-1/tags"))
+	Assert(t, "c1" == metarepo.GetData("/clusters/cl-1/name/short"))
+
+	// a non object patch replace the target.
+	p = metarepo.PlanPatch("/clusters/cl-1/env", "off")
+	Assert(t, "off" == p.Value && 1 == len(p.Deletes))
+	Assert(t, nil == metarepo.PatchData(p))
+	time.Sleep(sleepTime)
+	Assert(t, "off" == metarepo.GetData("/clusters/cl-1/env"))
+
+	p = metarepo.PlanPatch("/clusters/cl-1", nil)
+	Assert(t, nil == p.Value && 1 == len(p.Deletes))
+	Assert(t, nil == metarepo.PatchData(p))
+	time.Sleep(sleepTime)
+	Assert(t, nil == metarepo.GetData("/clusters/cl-1"))
+}
+
+func TestMetarepoJSONPatch(t *testing.T) {
+	metarepo := NewTestMetarepo()
+	metarepo.StartSync()
+	defer metarepo.StopSync()
+
+	err := metarepo.PutData("/apps/a1", map[string]interface{}{
+		"version": "3",
+		"env":     map[string]interface{}{"old_name": "x", "legacy": "true"},
+		"tags":    "t1",
+	}, true)
+	Assert(t, nil == err)
+	time.Sleep(sleepTime)
+
+	_, err = metarepo.PlanJSONPatch("/apps/a1", []*PatchOperation{{Op: "test", Path: "/version", Value: "2"}, {Op: "remove", Path: "/tags"}})
+	Assert(t, IsPatchTestError(err))
+	_, err = metarepo.PlanJSONPatch("/apps/a1", []*PatchOperation{{Op: "remove", Path: "/nothing"}})
+	Assert(t, nil != err && !IsPatchTestError(err))
+	_, err = metarepo.PlanJSONPatch("/apps/a1", []*PatchOperation{{Op: "add", Path: "/no/parent", Value: "1"}})
+	Assert(t, nil != err)
+	_, err = metarepo.PlanJSONPatch("/apps/a1", []*PatchOperation{{Op: "move", From: "/env", Path: "/env/sub"}})
+	Assert(t, nil != err)
+
+	p, err := metarepo.PlanJSONPatch("/apps/a1", []*PatchOperation{
+		{Op: "test", Path: "/version", Value: 3},
+		{Op: "test", Path: "/missing", Value: nil},
+		{Op: "replace", Path: "/version", Value: "4"},
+		{Op: "move", From: "/env/old_name", Path: "/env/new_name"},
+		{Op: "remove", Path: "/env/legacy"},
+		{Op: "add", Path: "/tags", Value: map[string]interface{}{"a": "1"}},
+		{Op: "add", Path: "/tags/b~0c", Value: "2"},
+		{Op: "copy", From: "/env", Path: "/env2"},
+	})
+	Assert(t, nil == err, err)
+	Assert(t, 3 == len(p.Deletes), p.Deletes)
+	Assert(t, nil == metarepo.PatchData(p))
+	time.Sleep(sleepTime)
+	fmap := flatmap.Flatten(metarepo.GetData("/apps/a1").(map[string]interface{}))
+	Assert(t, reflect.DeepEqual(map[string]string{
+		"/version":       "4",
+		"/env/new_name":  "x",
+		"/env2/new_name": "x",
+		"/tags/a":        "1",
+		"/tags/b~c":      "2",
+	}, fmap), fmap)
+}
+
+func TestMetarepoMatchData(t *testing.T) {
+	metarepo := NewTestMetarepo()
+	metarepo.StartSync()
+	defer metarepo.StopSync()
+
+	err := metarepo.PutData("/clusters", map[string]interface{}{
+		"cl-1": map[string]interface{}{"tmp": map[string]interface{}{"a": "1"}, "name": "cl-1"},
+		"cl-2": map[string]interface{}{"tmp": "2", "x": map[string]interface{}{"tmp": "3"}},
+	}, true)
+	Assert(t, nil == err)
+	time.Sleep(sleepTime)
+
+	_, err = metarepo.MatchData("/", "/clusters/[")
+	Assert(t, nil != err)
+	paths, err := metarepo.MatchData("/", "/clusters/*/tmp")
+	Assert(t, nil == err)
+	Assert(t, reflect.DeepEqual([]string{"/clusters/cl-1/tmp", "/clusters/cl-2/tmp"}, paths), paths)
+	paths, err = metarepo.MatchData("/clusters", "cl-?/*/tmp")
+	Assert(t, nil == err)
+	Assert(t, reflect.DeepEqual([]string{"/clusters/cl-2/x/tmp"}, paths), paths)
+
+	Assert(t, nil == metarepo.DeletePaths("/clusters/cl-1/tmp", "/clusters/cl-2/tmp", "/clusters/none"))
+	time.Sleep(sleepTime)
+	Assert(t, nil == metarepo.GetData("/clusters/cl-1/tmp"))
+	Assert(t, nil == metarepo.GetData("/clusters/cl-2/tmp"))
+	Assert(t, "3" == metarepo.GetData("/clusters/cl-2/x/tmp"))
+	Assert(t, "cl-1" == metarepo.GetData("/clusters/cl-1/name"))
+}
+
+func TestMetarepoDataSchema(t *testing.T) {
+	metarepo := NewTestMetarepo()
+	metarepo.StartSync()
+	defer metarepo.StopSync()
+
+	schema := map[string]interface{}{
+		"type":     "object",
+		"required": []interface{}{"name"},
+		"additionalProperties": map[string]interface{}{
+			"type":       "object",
+			"required":   []interface{}{"port"},
+			"properties": map[string]interface{}{"port": map[string]interface{}{"type": "integer"}},
+		},
+		"properties": map[string]interface{}{"name": map[string]interface{}{"type": "string"}},
+	}
+	err := metarepo.PutData("/clusters", map[string]interface{}{"other": "1"}, true)
+	Assert(t, nil == err)
+	time.Sleep(sleepTime)
+
+	Assert(t, nil != metarepo.PutDataSchema(&DataSchema{Prefix: "/", Schema: schema}, false))
+	Assert(t, nil != metarepo.PutDataSchema(&DataSchema{Prefix: "/clusters", Schema: map[string]interface{}{"type": "map"}}, false))
+	err = metarepo.PutDataSchema(&DataSchema{Prefix: "/clusters", Schema: schema}, false)
+	Assert(t, IsSchemaError(err), err)
+	err = metarepo.PutDataSchema(&DataSchema{Prefix: "/clusters", Schema: schema}, true)
+	Assert(t, nil == err)
+	time.Sleep(sleepTime)
+	Assert(t, "/clusters" == metarepo.GetDataSchemas()[0].Prefix)
+
+	err = metarepo.PutData("/clusters", map[string]interface{}{"name": "a", "cl-1": map[string]interface{}{"port": "80"}}, true)
+	Assert(t, nil == err, err)
+	time.Sleep(sleepTime)
+
+	err = metarepo.PutData("/clusters/cl-2", map[string]interface{}{"port": "http"}, false)
+	Assert(t, IsSchemaError(err), err)
+	Assert(t, "/cl-2/port" == err.(*SchemaError).Errors[0].Path)
+	err = metarepo.PutData("/", map[string]interface{}{"clusters": map[string]interface{}{"cl-1": map[string]interface{}{"port": "81"}}}, true)
+	Assert(t, IsSchemaError(err), err)
+	Assert(t, IsSchemaError(metarepo.DeleteData("/clusters", "name")))
+	Assert(t, IsSchemaError(metarepo.DeletePaths("/clusters/name")))
+	_, err = metarepo.CopyData("/clusters/cl-1", "/clusters/cl-3", &CopyOptions{Substitutions: []Substitution{{Old: "80", New: "http"}}})
+	Assert(t, IsSchemaError(err), err)
+	_, err = metarepo.MoveData("/clusters/name", "/name")
+	Assert(t, IsSchemaError(err), err)
+	Assert(t, IsSchemaError(metarepo.PatchData(metarepo.PlanPatch("/clusters", map[string]interface{}{"cl-1": "1"}))))
+	Assert(t, "a" == metarepo.GetData("/clusters/name"))
+
+	// the other prefixes and the delete of the whole prefix are not checked.
+	Assert(t, nil == metarepo.PutData("/nodes/1", "x", false))
+	Assert(t, nil == metarepo.DeleteData("/clusters"))
+	time.Sleep(sleepTime)
+	Assert(t, nil == metarepo.GetData("/clusters"))
+
+	Assert(t, nil == metarepo.DeleteDataSchema("/clusters"))
+	time.Sleep(sleepTime)
+	Assert(t, nil == metarepo.GetDataSchema("/clusters"))
+	Assert(t, nil == metarepo.PutData("/clusters/cl-1/port", "http", false))
+}
+
+func TestMetarepoExportImport(t *testing.T) {
+	metarepo := NewTestMetarepo()
+	metarepo.StartSync()
+	defer metarepo.StopSync()
+
+	export, err := metarepo.ExportData("/")
+	Assert(t, nil == err)
+	Assert(t, "/" == export.Prefix)
+	Assert(t, reflect.DeepEqual(map[string]interface{}{}, export.Data), export.Data)
+	_, err = metarepo.ExportData("/clusters")
+	Assert(t, nil != err)
+
+	count, err := metarepo.ImportData("/clusters", map[string]interface{}{"cl-1": map[string]interface{}{"name": "cl-1", "size": "2"}}, false)
+	Assert(t, nil == err)
+	Assert(t, 2 == count)
+	time.Sleep(sleepTime)
+	export, err = metarepo.ExportData("/clusters")
+	Assert(t, nil == err)
+	Assert(t, "/clusters" == export.Prefix)
+	Assert(t, export.Version == metarepo.DataVersion())
+	Assert(t, "2" == export.Data.(map[string]interface{})["cl-1"].(map[string]interface{})["size"])
+
+	_, err = metarepo.ImportData("/", "x", true)
+	Assert(t, nil != err)
+	_, err = metarepo.ImportData("/clusters", nil, true)
+	Assert(t, nil != err)
+	count, err = metarepo.ImportData("/clusters", map[string]interface{}{"cl-2": map[string]interface{}{"name": "cl-2"}}, true)
+	Assert(t, nil == err)
+	Assert(t, 1 == count)
+	time.Sleep(sleepTime)
+	Assert(t, nil == metarepo.GetData("/clusters/cl-1"))
+	Assert(t, "cl-2" == metarepo.GetData("/clusters/cl-2/name"))
+}
+
+func TestMetarepoOverlay(t *testing.T) {
+	metarepo := NewTestMetarepo()
+	metarepo.StartSync()
+	defer metarepo.StopSync()
+
+	err := metarepo.PutData("/nodes", map[string]interface{}{"1": map[string]interface{}{"mode": "normal"}, "2": "2"}, true)
+	Assert(t, nil == err)
+	time.Sleep(sleepTime)
+
+	Assert(t, nil != metarepo.PutOverlay(&Overlay{Path: "/nodes/1", Value: "x"}))
+	Assert(t, nil != metarepo.PutOverlay(&Overlay{Path: "/nodes/1", Value: "x", TTL: 10, MaxTTL: 5}))
+	err = metarepo.PutOverlay(&Overlay{Path: "/nodes/1", Value: map[string]interface{}{"mode": "maintenance"}, TTL: 10, MaxTTL: 15})
+	Assert(t, nil == err)
+	Assert(t, IsSyncStateError(metarepo.PutOverlay(&Overlay{Path: "/nodes", Value: "x", TTL: 10})))
+	Assert(t, "maintenance" == metarepo.GetData("/nodes/1/mode"))
+	Assert(t, 1 == len(metarepo.GetOverlays()))
+
+	// the backend writes are not applied while overlaid.
+	Assert(t, nil == metarepo.PutData("/nodes", map[string]interface{}{"1": map[string]interface{}{"mode": "upgrade"}, "2": "3"}, false))
+	time.Sleep(sleepTime)
+	Assert(t, "maintenance" == metarepo.GetData("/nodes/1/mode"))
+	Assert(t, "3" == metarepo.GetData("/nodes/2"))
+
+	Assert(t, 0 == len(metarepo.ExpireOverlays(time.Now())))
+	expired := metarepo.ExpireOverlays(time.Now().Add(11 * time.Second))
+	Assert(t, reflect.DeepEqual([]string{"/nodes/1"}, expired), expired)
+	Assert(t, "upgrade" == metarepo.GetData("/nodes/1/mode"))
+	Assert(t, 1 == metarepo.OverlayStats().Expiries)
+	Assert(t, 0 == len(metarepo.PausedSyncs()))
+
+	Assert(t, nil == metarepo.PutOverlay(&Overlay{Path: "/nodes/2", Value: true, TTL: 10}))
+	Assert(t, "true" == metarepo.GetData("/nodes/2"))
+	_, err = metarepo.DeleteOverlay("/nodes/2")
+	Assert(t, nil == err)
+	Assert(t, "3" == metarepo.GetData("/nodes/2"))
+	_, err = metarepo.DeleteOverlay("/nodes/2")
+	Assert(t, nil != err)
+}
+
+func TestOverlayExtend(t *testing.T) {
+	o := &Overlay{TTL: 10, MaxTTL: 25, CreatedAt: 100, ExpireAt: 110}
+	Assert(t, !o.extend(100))
+	Assert(t, o.extend(105) && 115 == o.ExpireAt)
+	Assert(t, o.extend(120) && 125 == o.ExpireAt)
+	Assert(t, !o.extend(124))
+	Assert(t, 2 == o.Extensions)
+	o = &Overlay{TTL: 10, CreatedAt: 100, ExpireAt: 110}
+	Assert(t, o.extend(1000) && 1010 == o.ExpireAt)
+}
+
+func TestMetarepoFreeze(t *testing.T) {
+	metarepo := NewTestMetarepo()
+	metarepo.StartSync()
+	defer metarepo.StopSync()
+
+	Assert(t, nil == metarepo.GetFreeze())
+	Assert(t, nil == metarepo.CheckFreeze())
+	expireAt := time.Now().Unix() + 60
+	Assert(t, nil != metarepo.PutFreeze(&Freeze{Owner: "ops", ExpireAt: expireAt}))
+	Assert(t, nil != metarepo.PutFreeze(&Freeze{Reason: "release", ExpireAt: expireAt}))
+	Assert(t, nil != metarepo.PutFreeze(&Freeze{Reason: "release", Owner: "ops", ExpireAt: time.Now().Unix()}))
+	Assert(t, nil == metarepo.PutFreeze(&Freeze{Reason: "release", Owner: "ops", ExpireAt: expireAt}))
+	time.Sleep(sleepTime)
+
+	f := metarepo.GetFreeze()
+	Assert(t, nil != f && "ops" == f.Owner && expireAt == f.ExpireAt)
+	err := metarepo.CheckFreeze()
+	Assert(t, IsFreezeError(err))
+	Assert(t, strings.Contains(err.Error(), "frozen by [ops]") && strings.HasSuffix(err.Error(), ": release"), err.Error())
+
+	// the bulk writes stop at the freeze.
+	err = metarepo.BulkPut(context.Background(), "/bulk", map[string]interface{}{"a": "1", "b": "2"}, 1, 0, func(done, total int) {})
+	Assert(t, IsFreezeError(err), err)
+
+	Assert(t, nil == metarepo.DeleteFreeze())
+	time.Sleep(sleepTime)
+	Assert(t, nil == metarepo.GetFreeze())
+
+	// the expired freeze is lifted.
+	Assert(t, nil == metarepo.PutFreeze(&Freeze{Reason: "release", Owner: "ops", ExpireAt: time.Now().Unix() + 2}))
+	time.Sleep(sleepTime)
+	Assert(t, nil != metarepo.GetFreeze())
+	time.Sleep(2 * time.Second)
+	Assert(t, nil == metarepo.GetFreeze())
+}
+
 func NewTestMetarepo() *MetadataRepo {
 	prefix := fmt.Sprintf("/prefix%v", rand.Intn(10000))
 	group := fmt.Sprintf("/group%v", rand.Intn(10000))
@@ -606,3 +1466,51 @@ func ValidTestData(t *testing.T, testData map[string]string, metastore store.Sto
 		Assert(t, reflect.DeepEqual(v, storeVal))
 	}
 }
+
+func TestValueTypes(t *testing.T) {
+	types := map[string]string{}
+	collectValueTypes("/node", map[string]interface{}{"port": float64(42), "enabled": false, "zone": nil, "name": "n1", "tags": []interface{}{"a", 1.5}}, types)
+	Assert(t, reflect.DeepEqual(map[string]string{"/node/port": ValueTypeNumber, "/node/enabled": ValueTypeBoolean, "/node/zone": ValueTypeNull,
+		"/node/name": "", "/node/tags/0": "", "/node/tags/1": ValueTypeNumber}, types), types)
+
+	Assert(t, int64(42) == typedValue("42", ValueTypeNumber))
+	Assert(t, 1.5 == typedValue("1.5", ValueTypeNumber))
+	Assert(t, "abc" == typedValue("abc", ValueTypeNumber))
+	Assert(t, false == typedValue("false", ValueTypeBoolean))
+	Assert(t, "1" == typedValue("1", ValueTypeBoolean))
+	Assert(t, nil == typedValue("", ValueTypeNull))
+	Assert(t, "x" == typedValue("x", ValueTypeNull))
+
+	value := typeValue(map[string]interface{}{"port": "42", "name": "n1"}, "/node", map[string]string{"/node/port": ValueTypeNumber}, func(p string) string { return p })
+	Assert(t, reflect.DeepEqual(map[string]interface{}{"port": int64(42), "name": "n1"}, value), value)
+}
+
+func TestExpandRefs(t *testing.T) {
+	data := map[string]interface{}{
+		"common": map[string]interface{}{"dns": map[string]interface{}{"nameserver": "10.0.0.2"}, "ntp": "$ref:/common/ntp-servers", "ntp-servers": "ntp.local"},
+		"loop":   map[string]interface{}{"a": "$ref:/loop/b", "b": "$ref:/loop/a", "self": "$ref:/loop"},
+	}
+	read := func(p string) interface{} {
+		var v interface{} = data
+		for _, name := range strings.Split(strings.TrimPrefix(p, "/"), "/") {
+			dir, ok := v.(map[string]interface{})
+			if !ok {
+				return nil
+			}
+			v = dir[name]
+		}
+		return v
+	}
+	value := map[string]interface{}{"dns": "$ref:/common/dns", "ntp": "$ref: /common/ntp", "missing": "$ref:/common/missing", "plain": "ref:/common/dns"}
+	val := ExpandRefs(value, "/nodes/1", read)
+	Assert(t, reflect.DeepEqual(map[string]interface{}{"dns": map[string]interface{}{"nameserver": "10.0.0.2"}, "ntp": "ntp.local",
+		"missing": "$ref:/common/missing", "plain": "ref:/common/dns"}, val), val)
+	// the value is not modified.
+	Assert(t, "$ref:/common/dns" == value["dns"])
+
+	// the cycle is kept as is.
+	val = ExpandRefs(read("/loop"), "/loop", read)
+	Assert(t, reflect.DeepEqual(map[string]interface{}{"a": "$ref:/loop/b", "b": "$ref:/loop/a", "self": "$ref:/loop"}, val), val)
+	val = ExpandRefs("$ref:/loop/a", "", read)
+	Assert(t, "$ref:/loop/a" == val, val)
+}
@@ -0,0 +1,62 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metadata
+
+import (
+	"fmt"
+	"path"
+)
+
+// MountError is the error of the data write to a mounted prefix, which is read only.
+type MountError struct {
+	error
+}
+
+// IsMountError return whether the error is caused by writing a mounted prefix.
+func IsMountError(err error) bool {
+	_, ok := err.(*MountError)
+	return ok
+}
+
+// MountData mount the data prefix from an external source, the backend values under the prefix are ignored
+// and the prefix can only be changed by PutMountedData. It should be called before StartSync.
+func (r *MetadataRepo) MountData(prefix string) {
+	prefix = path.Join("/", prefix)
+	r.syncGate.lock.Lock()
+	defer r.syncGate.lock.Unlock()
+	r.syncGate.mounted[prefix] = true
+}
+
+// PutMountedData replace the data of the mounted prefix by the value, only the changed keys are written,
+// return the count of the updated and deleted keys.
+func (r *MetadataRepo) PutMountedData(prefix string, value interface{}) (updated int, deleted int) {
+	prefix = path.Join("/", prefix)
+	r.syncGate.lock.Lock()
+	defer r.syncGate.lock.Unlock()
+	return r.syncGate.replace(prefix, flattenValue(value))
+}
+
+// mountedPath return whether the nodePath is under a mounted prefix.
+func (r *MetadataRepo) mountedPath(nodePath string) bool {
+	nodePath = path.Join("/", nodePath)
+	r.syncGate.lock.Lock()
+	defer r.syncGate.lock.Unlock()
+	for prefix := range r.syncGate.mounted {
+		if isSubPath(nodePath, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkMounted return MountError if any of the paths is under a mounted prefix.
+func (r *MetadataRepo) checkMounted(paths ...string) error {
+	for _, p := range paths {
+		if r.mountedPath(p) {
+			return &MountError{fmt.Errorf("path [%s] is mounted from external source, read only.", path.Join("/", p))}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metadata
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"openpitrix.io/metad/pkg/flatmap"
+	"openpitrix.io/metad/pkg/logger"
+)
+
+// MoveData move the subtree at from to the path to, and update the mappings which link to the old path,
+// return the updated mapping paths.
+func (r *MetadataRepo) MoveData(from string, to string) ([]string, error) {
+	from = path.Join("/", from)
+	to = path.Join("/", to)
+	if from == "/" || to == "/" {
+		return nil, errors.New("can not move from or to root path.")
+	}
+	if isSubPath(to, from) || isSubPath(from, to) {
+		return nil, fmt.Errorf("can not move [%s] to [%s], one path contains the other.", from, to)
+	}
+	if err := r.checkMounted(from, to); err != nil {
+		return nil, err
+	}
+	val := r.GetData(from)
+	if val == nil {
+		return nil, fmt.Errorf("path [%s] not found.", from)
+	}
+	if r.GetData(to) != nil {
+		return nil, fmt.Errorf("path [%s] already exist.", to)
+	}
+	// the usage is not changed if move in the same top level prefix.
+	if topLevel(from) != topLevel(to) {
+		if err := r.checkQuota(to, val, false); err != nil {
+			return nil, err
+		}
+	}
+	if err := r.checkSchemas(deleteWrite(from), putWrite(to, val, false)); err != nil {
+		return nil, err
+	}
+	err := r.storeClient.Move(from, to)
+	if err != nil {
+		return nil, err
+	}
+	// the type records move with the leaves.
+	if err := r.copyValueTypes(from, to, nil); err != nil {
+		return nil, err
+	}
+	if err := r.deleteValueTypes(from); err != nil {
+		return nil, err
+	}
+	return r.relinkMappings(from, to)
+}
+
+// topLevel return the top level node name of the clean path.
+func topLevel(nodePath string) string {
+	return strings.SplitN(strings.TrimPrefix(nodePath, "/"), "/", 2)[0]
+}
+
+// relinkMappings replace the mapping links to path from (or its sub path) with path to.
+func (r *MetadataRepo) relinkMappings(from string, to string) ([]string, error) {
+	updated := []string{}
+	mapping, ok := r.GetMapping("/").(map[string]interface{})
+	if !ok {
+		return updated, nil
+	}
+	for k, link := range flatmap.Flatten(mapping) {
+		newLink, ok := relinkLayers(link, from, to)
+		if !ok {
+			continue
+		}
+		mappingPath := path.Join("/", k)
+		logger.Info("Relink mapping %s from %s to %s", mappingPath, link, newLink)
+		err := r.storeClient.PutMapping(mappingPath, newLink, false)
+		if err != nil {
+			return updated, err
+		}
+		updated = append(updated, mappingPath)
+	}
+	sort.Strings(updated)
+	return updated, nil
+}
@@ -0,0 +1,261 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metadata
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"openpitrix.io/metad/pkg/logger"
+)
+
+var (
+	overlayExtensions = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "metad_overlay_extensions_total",
+		Help: "Count of the overlay ttl extended by reads.",
+	})
+	overlayExpiries = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "metad_overlay_expiries_total",
+		Help: "Count of the overlays expired.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(overlayExtensions, overlayExpiries)
+}
+
+// Overlay is a local value of this metad served in place of the backend data of the path, such as a temporary incident
+// override. The overlay has a sliding TTL, every read of the path extend the expiry to TTL seconds later, bounded by
+// MaxTTL seconds after created if MaxTTL is positive, so an unused overlay expire quickly. The backend sync of the
+// path is paused while overlaid, and the path is reloaded from the backend when the overlay expired or deleted.
+// The overlays are not persisted.
+type Overlay struct {
+	Path       string      `json:"path"`
+	Value      interface{} `json:"value"`
+	TTL        int64       `json:"ttl"`
+	MaxTTL     int64       `json:"max_ttl"`
+	CreatedAt  int64       `json:"created_at"`
+	ExpireAt   int64       `json:"expire_at"`
+	Extensions int64       `json:"extensions"`
+}
+
+// OverlayStats is the overlays count, and the total extensions and expiries since started.
+type OverlayStats struct {
+	Overlays   int   `json:"overlays"`
+	Extensions int64 `json:"extensions"`
+	Expiries   int64 `json:"expiries"`
+}
+
+type overlaySet struct {
+	overlays   map[string]*Overlay
+	extensions int64
+	expiries   int64
+	// count is the len of overlays, for checking without lock on every read.
+	count int32
+	lock  sync.Mutex
+}
+
+func newOverlaySet() *overlaySet {
+	return &overlaySet{overlays: map[string]*Overlay{}}
+}
+
+func (o *Overlay) maxExpireAt() int64 {
+	if o.MaxTTL <= 0 {
+		return 0
+	}
+	return o.CreatedAt + o.MaxTTL
+}
+
+// extend move the expiry to TTL seconds after now, return whether the expiry moved.
+func (o *Overlay) extend(now int64) bool {
+	expireAt := now + o.TTL
+	if max := o.maxExpireAt(); max > 0 && expireAt > max {
+		expireAt = max
+	}
+	if expireAt <= o.ExpireAt {
+		return false
+	}
+	o.ExpireAt = expireAt
+	o.Extensions++
+	return true
+}
+
+func checkOverlay(o *Overlay) error {
+	if o.Path == "/" {
+		return errors.New("can not overlay root path.")
+	}
+	if o.Value == nil {
+		return errors.New("overlay value should not be empty.")
+	}
+	if o.TTL <= 0 {
+		return errors.New("overlay ttl should be positive.")
+	}
+	if o.MaxTTL > 0 && o.MaxTTL < o.TTL {
+		return errors.New("overlay max_ttl should not be less than ttl.")
+	}
+	return nil
+}
+
+// PutOverlay overlay the value to the path, replace the current overlay of the path if present.
+func (r *MetadataRepo) PutOverlay(o *Overlay) error {
+	o.Path = path.Join("/", o.Path)
+	if err := checkOverlay(o); err != nil {
+		return err
+	}
+	if err := r.checkMounted(o.Path); err != nil {
+		return err
+	}
+	set := r.overlays
+	set.lock.Lock()
+	defer set.lock.Unlock()
+	for p := range set.overlays {
+		if p != o.Path && (isSubPath(p, o.Path) || isSubPath(o.Path, p)) {
+			return &SyncStateError{fmt.Errorf("overlay [%s] overlaps [%s].", o.Path, p)}
+		}
+	}
+	if _, ok := set.overlays[o.Path]; !ok {
+		if err := r.syncGate.pause(o.Path); err != nil {
+			return err
+		}
+	}
+	switch o.Value.(type) {
+	case map[string]interface{}, []interface{}, string:
+	default:
+		o.Value = fmt.Sprintf("%v", o.Value)
+	}
+	now := time.Now().Unix()
+	o.CreatedAt, o.ExpireAt, o.Extensions = now, now+o.TTL, 0
+	set.overlays[o.Path] = o
+	atomic.StoreInt32(&set.count, int32(len(set.overlays)))
+	r.data.Delete(o.Path)
+	r.data.Put(o.Path, o.Value)
+	logger.Info("Overlay [%s], ttl: %d, max_ttl: %d.", o.Path, o.TTL, o.MaxTTL)
+	return nil
+}
+
+// GetOverlays return the overlays in path order.
+func (r *MetadataRepo) GetOverlays() []*Overlay {
+	set := r.overlays
+	set.lock.Lock()
+	defer set.lock.Unlock()
+	result := make([]*Overlay, 0, len(set.overlays))
+	for _, o := range set.overlays {
+		copied := *o
+		result = append(result, &copied)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Path < result[j].Path
+	})
+	return result
+}
+
+func (r *MetadataRepo) GetOverlay(nodePath string) *Overlay {
+	set := r.overlays
+	set.lock.Lock()
+	defer set.lock.Unlock()
+	o, ok := set.overlays[path.Join("/", nodePath)]
+	if !ok {
+		return nil
+	}
+	copied := *o
+	return &copied
+}
+
+// OverlayStats return the overlays count, and the total extensions and expiries.
+func (r *MetadataRepo) OverlayStats() *OverlayStats {
+	set := r.overlays
+	set.lock.Lock()
+	defer set.lock.Unlock()
+	return &OverlayStats{Overlays: len(set.overlays), Extensions: set.extensions, Expiries: set.expiries}
+}
+
+// HasOverlays return whether any path is overlaid, without lock.
+func (r *MetadataRepo) HasOverlays() bool {
+	return atomic.LoadInt32(&r.overlays.count) > 0
+}
+
+// TouchOverlays extend the overlays read by the paths, an overlay is read if the path is the overlay path,
+// its parent or its sub path.
+func (r *MetadataRepo) TouchOverlays(paths ...string) {
+	if !r.HasOverlays() {
+		return
+	}
+	set := r.overlays
+	now := time.Now().Unix()
+	set.lock.Lock()
+	defer set.lock.Unlock()
+	for p, o := range set.overlays {
+		for _, read := range paths {
+			read = path.Join("/", read)
+			if isSubPath(p, read) || isSubPath(read, p) {
+				if o.extend(now) {
+					set.extensions++
+					overlayExtensions.Inc()
+				}
+				break
+			}
+		}
+	}
+}
+
+// DeleteOverlay remove the overlay of the path, and reload the path from the backend.
+func (r *MetadataRepo) DeleteOverlay(nodePath string) (*ResyncResult, error) {
+	nodePath = path.Join("/", nodePath)
+	set := r.overlays
+	set.lock.Lock()
+	_, ok := set.overlays[nodePath]
+	delete(set.overlays, nodePath)
+	atomic.StoreInt32(&set.count, int32(len(set.overlays)))
+	set.lock.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("overlay [%s] not found.", nodePath)
+	}
+	return r.removeOverlay(nodePath)
+}
+
+// ExpireOverlays remove the overlays expired at now and reload their paths from the backend, return the expired paths.
+func (r *MetadataRepo) ExpireOverlays(now time.Time) []string {
+	if !r.HasOverlays() {
+		return nil
+	}
+	set := r.overlays
+	expired := []string{}
+	set.lock.Lock()
+	for p, o := range set.overlays {
+		if o.ExpireAt <= now.Unix() {
+			expired = append(expired, p)
+			delete(set.overlays, p)
+		}
+	}
+	set.expiries += int64(len(expired))
+	atomic.StoreInt32(&set.count, int32(len(set.overlays)))
+	set.lock.Unlock()
+	sort.Strings(expired)
+	for _, p := range expired {
+		overlayExpiries.Inc()
+		logger.Info("Overlay [%s] expired.", p)
+		if _, err := r.removeOverlay(p); err != nil {
+			logger.Error("Reload expired overlay [%s] from backend error: %s", p, err.Error())
+		}
+	}
+	return expired
+}
+
+// removeOverlay resume the sync of the overlay path, which reload it from the backend.
+func (r *MetadataRepo) removeOverlay(nodePath string) (*ResyncResult, error) {
+	result, err := r.ResumeSync(nodePath)
+	if IsSyncStateError(err) {
+		// the sync has been resumed by the sync api.
+		return r.ResyncData(nodePath)
+	}
+	return result, err
+}
@@ -0,0 +1,82 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metadata
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+
+	"openpitrix.io/metad/pkg/logger"
+)
+
+// Override is the trail of an admin modified a path owned by other team.
+type Override struct {
+	Time      int64    `json:"time"`
+	RequestID string   `json:"request_id"`
+	TokenID   string   `json:"token_id"`
+	Action    string   `json:"action"`
+	Path      string   `json:"path"`
+	Owners    []string `json:"owners"`
+}
+
+// PathOwners return the owners of the nodePath's subtree,
+// include the owner of nodePath or its nearest annotated parent, and the owners of all sub paths.
+func (r *MetadataRepo) PathOwners(nodePath string) []string {
+	nodePath = path.Join("/", nodePath)
+	ownerSet := map[string]struct{}{}
+	nearest := ""
+	nearestOwner := ""
+	for _, v := range r.records[RecordAnnotation].GetAll() {
+		annotation, err := unmarshalAnnotation(v)
+		if err != nil || annotation.Owner == "" {
+			continue
+		}
+		if isSubPath(annotation.Path, nodePath) {
+			ownerSet[annotation.Owner] = struct{}{}
+		} else if isSubPath(nodePath, annotation.Path) && len(annotation.Path) > len(nearest) {
+			nearest = annotation.Path
+			nearestOwner = annotation.Owner
+		}
+	}
+	if nearestOwner != "" {
+		ownerSet[nearestOwner] = struct{}{}
+	}
+	owners := make([]string, 0, len(ownerSet))
+	for owner := range ownerSet {
+		owners = append(owners, owner)
+	}
+	sort.Strings(owners)
+	return owners
+}
+
+func (r *MetadataRepo) PutOverride(override *Override) error {
+	b, err := json.Marshal(override)
+	if err != nil {
+		return err
+	}
+	return r.storeClient.PutRecord(RecordOverride, fmt.Sprintf("%d-%s", override.Time, override.RequestID), string(b))
+}
+
+// GetOverrides return override trails since the time (unix seconds).
+func (r *MetadataRepo) GetOverrides(since int64) []*Override {
+	overrides := []*Override{}
+	for _, v := range r.records[RecordOverride].GetAll() {
+		override := &Override{}
+		err := json.Unmarshal([]byte(v), override)
+		if err != nil {
+			logger.Error("Unexpect override json value [%s]", v)
+			continue
+		}
+		if override.Time >= since {
+			overrides = append(overrides, override)
+		}
+	}
+	sort.Slice(overrides, func(i, j int) bool {
+		return overrides[i].Time < overrides[j].Time
+	})
+	return overrides
+}
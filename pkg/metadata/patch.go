@@ -0,0 +1,117 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metadata
+
+import (
+	"path"
+	"strings"
+)
+
+// DataPatch is the writes of a JSON merge patch (RFC 7386) to the data, the Deletes are deleted before the Value
+// merged to the Path.
+type DataPatch struct {
+	Path    string
+	Deletes []string
+	// Value is nil if nothing to put.
+	Value interface{}
+}
+
+// PlanPatch compute the writes of the JSON merge patch at nodePath by the current data: the object members are merged
+// recursively, the null members are deleted, and other values replace the target.
+func (r *MetadataRepo) PlanPatch(nodePath string, patch interface{}) *DataPatch {
+	nodePath = path.Join("/", nodePath)
+	p := &DataPatch{Path: nodePath, Deletes: []string{}}
+	puts := map[string]interface{}{}
+	r.planPatch(p, puts, nodePath, patch)
+	if v, ok := puts[nodePath]; ok {
+		p.Value = v
+		return p
+	}
+	if len(puts) == 0 {
+		return p
+	}
+	value := map[string]interface{}{}
+	for k, v := range puts {
+		setPatchValue(value, strings.Split(strings.TrimPrefix(k, strings.TrimSuffix(nodePath, "/")+"/"), "/"), v)
+	}
+	p.Value = value
+	return p
+}
+
+func (r *MetadataRepo) planPatch(p *DataPatch, puts map[string]interface{}, nodePath string, patch interface{}) {
+	current := r.GetData(nodePath)
+	_, currentDir := current.(map[string]interface{})
+	switch t := patch.(type) {
+	case nil:
+		if current != nil {
+			p.Deletes = append(p.Deletes, nodePath)
+		}
+	case map[string]interface{}:
+		// a leaf is replaced by the object.
+		if current != nil && !currentDir {
+			p.Deletes = append(p.Deletes, nodePath)
+		}
+		for k, v := range t {
+			if k == "" {
+				continue
+			}
+			r.planPatch(p, puts, path.Join(nodePath, k), v)
+		}
+	default:
+		if currentDir {
+			p.Deletes = append(p.Deletes, nodePath)
+		}
+		puts[nodePath] = t
+	}
+}
+
+func setPatchValue(m map[string]interface{}, elems []string, v interface{}) {
+	if len(elems) == 1 {
+		m[elems[0]] = v
+		return
+	}
+	sub, ok := m[elems[0]].(map[string]interface{})
+	if !ok {
+		sub = map[string]interface{}{}
+		m[elems[0]] = sub
+	}
+	setPatchValue(sub, elems[1:], v)
+}
+
+// PatchData apply the writes of the patch planned by PlanPatch, the deletes first.
+func (r *MetadataRepo) PatchData(p *DataPatch) error {
+	if err := r.checkMounted(append([]string{p.Path}, p.Deletes...)...); err != nil {
+		return err
+	}
+	writes := []*dataWrite{}
+	for _, nodePath := range p.Deletes {
+		writes = append(writes, deleteWrite(nodePath))
+	}
+	if p.Value != nil {
+		if err := r.checkQuota(p.Path, p.Value, false); err != nil {
+			return err
+		}
+		writes = append(writes, putWrite(p.Path, p.Value, false))
+	}
+	if err := r.checkSchemas(writes...); err != nil {
+		return err
+	}
+	for _, nodePath := range p.Deletes {
+		_, dir := r.GetData(nodePath).(map[string]interface{})
+		if err := r.storeClient.Delete(nodePath, dir); err != nil {
+			return err
+		}
+		if err := r.deleteValueTypes(nodePath); err != nil {
+			return err
+		}
+	}
+	if p.Value == nil {
+		return nil
+	}
+	if err := r.storeClient.Put(p.Path, p.Value, false); err != nil {
+		return err
+	}
+	return r.putValueTypes(p.Path, p.Value, false)
+}
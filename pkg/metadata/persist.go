@@ -0,0 +1,74 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metadata
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// repoCache is the local copy of the synced data and mapping, to survive the backend outage on startup.
+type repoCache struct {
+	Version int64       `json:"version"`
+	SavedAt time.Time   `json:"saved_at"`
+	Data    interface{} `json:"data"`
+	Mapping interface{} `json:"mapping"`
+}
+
+// SaveCache write the snapshots of the data and mapping to the cache file atomically. The cache file is plain, so the
+// data under the exclude prefixes, such as the encryption_prefixes, is not saved, and is served after backend synced.
+func (r *MetadataRepo) SaveCache(file string, exclude ...string) error {
+	data := r.data.Snapshot().Get("/")
+	for _, prefix := range exclude {
+		if prefix = path.Join("/", prefix); prefix == "/" {
+			data = nil
+			break
+		}
+		data = graftValue(data, strings.Split(prefix[1:], "/"), nil)
+	}
+	cache := repoCache{
+		Version: r.data.Version(),
+		SavedAt: time.Now(),
+		Data:    data,
+		Mapping: r.mapping.Snapshot().Get("/"),
+	}
+	b, err := json.Marshal(cache)
+	if err != nil {
+		return err
+	}
+	tmpFile := file + ".tmp"
+	if err := ioutil.WriteFile(tmpFile, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, file)
+}
+
+// LoadCache put the data and mapping of the cache file to the stores, should be called before sync,
+// return the time of the cache saved, zero if the file not exist.
+func (r *MetadataRepo) LoadCache(file string) (time.Time, error) {
+	b, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	cache := repoCache{}
+	if err := json.Unmarshal(b, &cache); err != nil {
+		return time.Time{}, fmt.Errorf("invalid cache file [%s]: %s", file, err.Error())
+	}
+	if m, ok := cache.Data.(map[string]interface{}); ok {
+		r.data.Put("/", m)
+	}
+	if m, ok := cache.Mapping.(map[string]interface{}); ok {
+		r.mapping.Put("/", m)
+	}
+	return cache.SavedAt, nil
+}
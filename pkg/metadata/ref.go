@@ -0,0 +1,69 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metadata
+
+import (
+	"path"
+	"strings"
+)
+
+// RefPrefix is the prefix of the reference leaf value, such as "$ref:/common/dns", which is expanded to the value of the
+// referenced path when the read opt in, so the shared blocks are not duplicated.
+const RefPrefix = "$ref:"
+
+// MaxRefDepth is the max nested references expanded, the deeper ones are kept as is.
+const MaxRefDepth = 8
+
+// RefPath return the path referenced by the leaf value, false if not a reference.
+func RefPath(value string) (string, bool) {
+	if !strings.HasPrefix(value, RefPrefix) {
+		return "", false
+	}
+	ref := strings.TrimSpace(value[len(RefPrefix):])
+	if !strings.HasPrefix(ref, "/") {
+		return "", false
+	}
+	return path.Clean(ref), true
+}
+
+// ExpandRefs replace the reference leaves of the value of nodePath by the values of the referenced paths read by read,
+// the references in the referenced values are expanded too. The reference not found, making a cycle or deeper than
+// MaxRefDepth is kept as is. nodePath is the data path of the value, empty if not a data path, such as self.
+// The value is not modified.
+func ExpandRefs(value interface{}, nodePath string, read func(string) interface{}) interface{} {
+	var expanding []string
+	if nodePath != "" {
+		expanding = []string{path.Join("/", nodePath)}
+	}
+	return expandRefs(value, read, expanding)
+}
+
+// expandRefs expand the value, expanding is the paths being expanded, the reference containing one of them is a cycle.
+func expandRefs(value interface{}, read func(string) interface{}, expanding []string) interface{} {
+	switch t := value.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(t))
+		for k, v := range t {
+			result[k] = expandRefs(v, read, expanding)
+		}
+		return result
+	case string:
+		ref, ok := RefPath(t)
+		if !ok || len(expanding) >= MaxRefDepth {
+			return value
+		}
+		for _, p := range expanding {
+			if isSubPath(p, ref) {
+				return value
+			}
+		}
+		v := read(ref)
+		if v == nil {
+			return value
+		}
+		return expandRefs(v, read, append(expanding[:len(expanding):len(expanding)], ref))
+	}
+	return value
+}
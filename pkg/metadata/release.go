@@ -0,0 +1,249 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metadata
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"openpitrix.io/metad/pkg/logger"
+)
+
+const (
+	ReleaseActionPut    = "put"
+	ReleaseActionDelete = "delete"
+
+	ReleaseStatusCreated    = "created"
+	ReleaseStatusApplied    = "applied"
+	ReleaseStatusRolledBack = "rolled_back"
+)
+
+// ReleaseChange is one data change of a release, Value and Replace are only used by put action.
+type ReleaseChange struct {
+	Path    string      `json:"path"`
+	Action  string      `json:"action"`
+	Value   interface{} `json:"value,omitempty"`
+	Replace bool        `json:"replace,omitempty"`
+}
+
+// ReleaseBackup keep the value of a path before the release applied, nil Value means the path did not exist.
+type ReleaseBackup struct {
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+}
+
+// Release is a named group of data changes, which is applied and rolled back as a unit.
+type Release struct {
+	Name         string          `json:"name"`
+	Changes      []ReleaseChange `json:"changes"`
+	Status       string          `json:"status"`
+	Backups      []ReleaseBackup `json:"backups,omitempty"`
+	CreatedAt    int64           `json:"created_at"`
+	AppliedAt    int64           `json:"applied_at,omitempty"`
+	RolledBackAt int64           `json:"rolled_back_at,omitempty"`
+}
+
+// ReleaseStateError is the error of the apply or rollback not allowed by the release status, such as rolling back
+// a release which is not applied.
+type ReleaseStateError struct {
+	Name   string
+	Status string
+}
+
+func (e *ReleaseStateError) Error() string {
+	return fmt.Sprintf("release [%s] is %s.", e.Name, e.Status)
+}
+
+func IsReleaseStateError(err error) bool {
+	_, ok := err.(*ReleaseStateError)
+	return ok
+}
+
+func checkRelease(release *Release) error {
+	if release.Name == "" || strings.Index(release.Name, "/") >= 0 {
+		return errors.New("release name should not be empty or contains '/'.")
+	}
+	if len(release.Changes) == 0 {
+		return errors.New("release should contains at least one change.")
+	}
+	for i, change := range release.Changes {
+		if change.Path == "" || change.Path[0] != '/' {
+			return fmt.Errorf("release change [%d] path should be absolute path.", i)
+		}
+		switch change.Action {
+		case ReleaseActionPut:
+			if change.Value == nil {
+				return fmt.Errorf("release change [%d] value should not be null.", i)
+			}
+		case ReleaseActionDelete:
+		default:
+			return fmt.Errorf("release change [%d] invalid action [%s].", i, change.Action)
+		}
+	}
+	return nil
+}
+
+func (r *MetadataRepo) GetReleases() []*Release {
+	releases := []*Release{}
+	for _, v := range r.records[RecordRelease].GetAll() {
+		release, err := unmarshalRelease(v)
+		if err != nil {
+			logger.Error("Unexpect release json value [%s]", v)
+			continue
+		}
+		releases = append(releases, release)
+	}
+	sort.Slice(releases, func(i, j int) bool {
+		return releases[i].CreatedAt < releases[j].CreatedAt
+	})
+	return releases
+}
+
+func (r *MetadataRepo) GetRelease(name string) *Release {
+	v, ok := r.records[RecordRelease].Get(path.Join("/", name))
+	if !ok {
+		return nil
+	}
+	release, err := unmarshalRelease(v)
+	if err != nil {
+		logger.Error("Unexpect release json value [%s]", v)
+		return nil
+	}
+	return release
+}
+
+func (r *MetadataRepo) CreateRelease(release *Release) error {
+	err := checkRelease(release)
+	if err != nil {
+		return err
+	}
+	if r.GetRelease(release.Name) != nil {
+		return fmt.Errorf("release [%s] already exist.", release.Name)
+	}
+	release.Status = ReleaseStatusCreated
+	release.Backups = nil
+	release.CreatedAt = time.Now().Unix()
+	release.AppliedAt = 0
+	release.RolledBackAt = 0
+	return r.putRelease(release)
+}
+
+func (r *MetadataRepo) DeleteRelease(name string) error {
+	return r.storeClient.DeleteRecord(RecordRelease, name)
+}
+
+// ApplyRelease apply all changes of the release, and backup the old values for rollback.
+// The old values are read from the backend, as the local store may not have synced the latest writes yet.
+// If one change fail, the applied changes will be reverted. The deletes are not moved to the trash, the backups are
+// kept for rollback.
+func (r *MetadataRepo) ApplyRelease(name string) (*Release, error) {
+	r.releaseLock.Lock()
+	defer r.releaseLock.Unlock()
+	release := r.GetRelease(name)
+	if release == nil {
+		return nil, fmt.Errorf("release [%s] not found.", name)
+	}
+	if release.Status == ReleaseStatusApplied {
+		return nil, &ReleaseStateError{Name: name, Status: release.Status}
+	}
+	backups := make([]ReleaseBackup, 0, len(release.Changes))
+	backuped := make(map[string]struct{}, len(release.Changes))
+	for _, change := range release.Changes {
+		if _, ok := backuped[change.Path]; !ok {
+			value, err := r.GetBackendData(change.Path)
+			if err != nil {
+				logger.Error("Backup release [%s] path %s error: %s, revert.", name, change.Path, err.Error())
+				if revertErr := r.restoreBackups(backups); revertErr != nil {
+					logger.Error("Revert release [%s] error: %s", name, revertErr.Error())
+					return nil, fmt.Errorf("%s, and revert error: %s", err.Error(), revertErr.Error())
+				}
+				return nil, err
+			}
+			backups = append(backups, ReleaseBackup{Path: change.Path, Value: value})
+			backuped[change.Path] = struct{}{}
+		}
+		var err error
+		switch change.Action {
+		case ReleaseActionPut:
+			err = r.PutData(change.Path, change.Value, change.Replace)
+		case ReleaseActionDelete:
+			err = r.DeleteData(change.Path)
+		}
+		if err != nil {
+			logger.Error("Apply release [%s] change %s %s error: %s, revert.", name, change.Action, change.Path, err.Error())
+			if revertErr := r.restoreBackups(backups); revertErr != nil {
+				logger.Error("Revert release [%s] error: %s", name, revertErr.Error())
+				return nil, fmt.Errorf("%s, and revert error: %s", err.Error(), revertErr.Error())
+			}
+			return nil, err
+		}
+	}
+	release.Status = ReleaseStatusApplied
+	release.Backups = backups
+	release.AppliedAt = time.Now().Unix()
+	return release, r.putRelease(release)
+}
+
+// RollbackRelease restore the values of the paths changed by the release.
+func (r *MetadataRepo) RollbackRelease(name string) (*Release, error) {
+	r.releaseLock.Lock()
+	defer r.releaseLock.Unlock()
+	release := r.GetRelease(name)
+	if release == nil {
+		return nil, fmt.Errorf("release [%s] not found.", name)
+	}
+	if release.Status != ReleaseStatusApplied {
+		return nil, &ReleaseStateError{Name: name, Status: release.Status}
+	}
+	err := r.restoreBackups(release.Backups)
+	if err != nil {
+		return nil, err
+	}
+	release.Status = ReleaseStatusRolledBack
+	release.Backups = nil
+	release.RolledBackAt = time.Now().Unix()
+	return release, r.putRelease(release)
+}
+
+func (r *MetadataRepo) restoreBackups(backups []ReleaseBackup) error {
+	for i := len(backups) - 1; i >= 0; i-- {
+		backup := backups[i]
+		var err error
+		if backup.Value == nil {
+			err = r.DeleteData(backup.Path)
+		} else {
+			err = r.PutData(backup.Path, backup.Value, true)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// putRelease also update the local record store, the status check of the next apply or rollback
+// should not see the stale status before the backend watch event arrived.
+func (r *MetadataRepo) putRelease(release *Release) error {
+	b, err := json.Marshal(release)
+	if err != nil {
+		return err
+	}
+	if err := r.storeClient.PutRecord(RecordRelease, release.Name, string(b)); err != nil {
+		return err
+	}
+	r.records[RecordRelease].Put(path.Join("/", release.Name), string(b))
+	return nil
+}
+
+func unmarshalRelease(data string) (*Release, error) {
+	release := &Release{}
+	err := json.Unmarshal([]byte(data), release)
+	return release, err
+}
@@ -0,0 +1,53 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metadata
+
+import (
+	"path"
+	"sort"
+	"time"
+
+	"openpitrix.io/metad/pkg/store"
+)
+
+// mappingRuleCheckInterval is the interval the self watchers of the clients mapped by rule check the rule changes.
+var mappingRuleCheckInterval = time.Second
+
+// remapEvents return the changes of the client's view at nodePath made by the mapping changed from the oldMapping,
+// the values linked by the current mapping are update events, and the values only linked by the oldMapping are delete events.
+func (r *MetadataRepo) remapEvents(clientIP string, nodePath string, oldMapping interface{}) []*store.Event {
+	oldValues := flattenValue(r.selfWith(clientIP, nodePath, oldMapping))
+	newValues := flattenValue(r.Self(clientIP, nodePath))
+	events := []*store.Event{}
+	for k, v := range newValues {
+		if old, ok := oldValues[k]; !ok || old != v {
+			events = append(events, &store.Event{Action: store.Update, Path: k, Value: v, Actor: ActorMapping})
+		}
+	}
+	for k, v := range oldValues {
+		if _, ok := newValues[k]; !ok {
+			events = append(events, &store.Event{Action: store.Delete, Path: k, Value: v, Actor: ActorMapping})
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].Path < events[j].Path
+	})
+	return events
+}
+
+// selfWith is same as Self, but read the client's view by the mapping instead of the current mapping of the client.
+func (r *MetadataRepo) selfWith(clientIP string, nodePath string, mappingData interface{}) interface{} {
+	mapping, ok := mappingData.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	accessTree := r.accessTreeOf(clientIP, mappingData)
+	if accessTree == nil {
+		return nil
+	}
+	traveller := r.data.Traveller(accessTree)
+	defer traveller.Close()
+	return r.getMappingDatas(path.Join("/", nodePath), mapping, traveller)
+}
@@ -0,0 +1,111 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metadata
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"openpitrix.io/metad/pkg/flatmap"
+	"openpitrix.io/metad/pkg/store"
+)
+
+// the replicated kinds, the records are ReplicateRecordPrefix + record kind, such as record/release.
+const (
+	ReplicateData         = "data"
+	ReplicateMapping      = "mapping"
+	ReplicateRule         = "rule"
+	ReplicateRecordPrefix = "record/"
+)
+
+// replicatePollInterval is the interval of checking the changes of the access rules and the records, which have
+// no watcher.
+const replicatePollInterval = time.Second
+
+// ReplicaUpdate is an update of the replicated kind, a snapshot replacing the replica or the changes since the last
+// update. The data, mapping and records snapshots are Values, the access rules snapshot is Rules, and only the data
+// and mapping have Events.
+type ReplicaUpdate struct {
+	Snapshot bool                          `json:"snapshot"`
+	Values   map[string]string             `json:"values,omitempty"`
+	Rules    map[string][]store.AccessRule `json:"rules,omitempty"`
+	Events   []*store.Event                `json:"events,omitempty"`
+}
+
+func storeSnapshot(s store.Store) *ReplicaUpdate {
+	values := map[string]string{}
+	if _, val := s.Get("/"); val != nil {
+		values = flatmap.Flatten(val)
+	}
+	return &ReplicaUpdate{Snapshot: true, Values: values}
+}
+
+// Replicate stream the updates of the kind to send until stopChan closed or send failed, the first update is the
+// snapshot, and the snapshot is sent again if the changes are dropped as send is slow.
+func (r *MetadataRepo) Replicate(kind string, stopChan <-chan struct{}, send func(*ReplicaUpdate) error) error {
+	switch kind {
+	case ReplicateData:
+		return r.replicateStore(r.data, stopChan, send)
+	case ReplicateMapping:
+		return r.replicateStore(r.mapping, stopChan, send)
+	case ReplicateRule:
+		return pollReplicate(r.accessStore.Version, func() *ReplicaUpdate {
+			return &ReplicaUpdate{Snapshot: true, Rules: r.accessStore.GetAccessRule(nil)}
+		}, stopChan, send)
+	}
+	if strings.HasPrefix(kind, ReplicateRecordPrefix) {
+		if records, ok := r.records[strings.TrimPrefix(kind, ReplicateRecordPrefix)]; ok {
+			return pollReplicate(records.Version, func() *ReplicaUpdate {
+				return &ReplicaUpdate{Snapshot: true, Values: records.GetAll()}
+			}, stopChan, send)
+		}
+	}
+	return fmt.Errorf("unknown replicate kind [%s].", kind)
+}
+
+func (r *MetadataRepo) replicateStore(s store.Store, stopChan <-chan struct{}, send func(*ReplicaUpdate) error) error {
+	w := s.Watch("/", subscribeWatchBufLen)
+	defer w.Remove()
+	if err := send(storeSnapshot(s)); err != nil {
+		return err
+	}
+	for {
+		events, closed := r.collectEvents(w, stopChan)
+		if closed {
+			return nil
+		}
+		update := &ReplicaUpdate{Events: events}
+		for _, e := range events {
+			if e.Action == store.Resync {
+				update = storeSnapshot(s)
+				break
+			}
+		}
+		if err := send(update); err != nil {
+			return err
+		}
+	}
+}
+
+// pollReplicate send the snapshot when the version changed.
+func pollReplicate(version func() int64, snapshot func() *ReplicaUpdate, stopChan <-chan struct{}, send func(*ReplicaUpdate) error) error {
+	ticker := time.NewTicker(replicatePollInterval)
+	defer ticker.Stop()
+	var sent int64 = -1
+	for {
+		if v := version(); v != sent {
+			if err := send(snapshot()); err != nil {
+				return err
+			}
+			sent = v
+		}
+		select {
+		case <-ticker.C:
+		case <-stopChan:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metadata
+
+import (
+	"errors"
+	"path"
+
+	"openpitrix.io/metad/pkg/flatmap"
+	"openpitrix.io/metad/pkg/store"
+)
+
+// revisionRepo build a temporary repo with the client's mapping at the backend revision,
+// the data is filled by caller, access rules and mapping rules are the current rules.
+func (r *MetadataRepo) revisionRepo(clientIP string, revision int64) (*MetadataRepo, error) {
+	if clientIP == "" {
+		return nil, errors.New("clientIP must not be empty.")
+	}
+	if revision <= 0 {
+		return nil, errors.New("revision should be positive.")
+	}
+	repo := &MetadataRepo{
+		mapping:      store.New(),
+		data:         store.New(),
+		storeClient:  r.storeClient,
+		accessStore:  r.accessStore,
+		records:      r.records,
+		mappingRules: r.mappingRules,
+		vars:         r.vars,
+	}
+	mappingPath := path.Join("/", clientIP)
+	mapping, err := r.storeClient.GetMappingAtRevision(mappingPath, revision)
+	if err != nil {
+		repo.destroy()
+		return nil, err
+	}
+	if mapping != nil {
+		repo.mapping.Put(mappingPath, mapping)
+	}
+	return repo, nil
+}
+
+func (r *MetadataRepo) destroy() {
+	r.data.Destroy()
+	r.mapping.Destroy()
+}
+
+func (r *MetadataRepo) putDataAtRevision(nodePath string, revision int64) error {
+	val, err := r.storeClient.GetAtRevision(nodePath, revision)
+	if err != nil {
+		return err
+	}
+	if val != nil {
+		r.data.Put(nodePath, val)
+	}
+	return nil
+}
+
+// RootAtRevision is same as Root, but read the data and mapping at the backend revision.
+func (r *MetadataRepo) RootAtRevision(clientIP string, nodePath string, revision int64) (interface{}, error) {
+	repo, err := r.revisionRepo(clientIP, revision)
+	if err != nil {
+		return nil, err
+	}
+	defer repo.destroy()
+	nodePath = path.Join("/", nodePath)
+	if err = repo.putDataAtRevision(nodePath, revision); err != nil {
+		return nil, err
+	}
+	_, val := repo.Root(clientIP, nodePath)
+	return val, nil
+}
+
+// SelfAtRevision is same as Self, but read the data and mapping at the backend revision.
+func (r *MetadataRepo) SelfAtRevision(clientIP string, nodePath string, revision int64) (interface{}, error) {
+	repo, err := r.revisionRepo(clientIP, revision)
+	if err != nil {
+		return nil, err
+	}
+	defer repo.destroy()
+	mapping, ok := repo.GetMapping(path.Join("/", clientIP)).(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+	for _, link := range mappingLayers(flatmap.Flatten(mapping)) {
+		if err = repo.putDataAtRevision(path.Join("/", link), revision); err != nil {
+			return nil, err
+		}
+	}
+	return repo.Self(clientIP, nodePath), nil
+}
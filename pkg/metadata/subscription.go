@@ -0,0 +1,92 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metadata
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"openpitrix.io/metad/pkg/logger"
+	"openpitrix.io/metad/pkg/notify"
+)
+
+// ConfigSubscriptionPrefix is the name prefix of the subscriptions configured by notify_webhooks, reserved.
+const ConfigSubscriptionPrefix = "config-"
+
+// Subscription is a webhook registered by manage api.
+type Subscription struct {
+	notify.Webhook
+	CreatedAt int64 `json:"created_at"`
+	UpdatedAt int64 `json:"updated_at"`
+}
+
+func (r *MetadataRepo) GetSubscriptions() []*Subscription {
+	subscriptions := []*Subscription{}
+	for _, v := range r.records[RecordSubscription].GetAll() {
+		subscription, err := unmarshalSubscription(v)
+		if err != nil {
+			logger.Error("Unexpect subscription json value [%s]", v)
+			continue
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+	sort.Slice(subscriptions, func(i, j int) bool {
+		return subscriptions[i].Name < subscriptions[j].Name
+	})
+	return subscriptions
+}
+
+func (r *MetadataRepo) GetSubscription(name string) *Subscription {
+	v, ok := r.records[RecordSubscription].Get(path.Join("/", name))
+	if !ok {
+		return nil
+	}
+	subscription, err := unmarshalSubscription(v)
+	if err != nil {
+		logger.Error("Unexpect subscription json value [%s]", v)
+		return nil
+	}
+	return subscription
+}
+
+// SubscriptionVersion change when any subscription changed.
+func (r *MetadataRepo) SubscriptionVersion() int64 {
+	return r.records[RecordSubscription].Version()
+}
+
+// PutSubscription create or replace the subscription, CreatedAt is kept when replace.
+func (r *MetadataRepo) PutSubscription(subscription *Subscription) error {
+	if err := notify.CheckWebhook(&subscription.Webhook); err != nil {
+		return err
+	}
+	if strings.HasPrefix(subscription.Name, ConfigSubscriptionPrefix) {
+		return fmt.Errorf("subscription name prefix [%s] is reserved.", ConfigSubscriptionPrefix)
+	}
+	now := time.Now().Unix()
+	subscription.CreatedAt = now
+	if old := r.GetSubscription(subscription.Name); old != nil {
+		subscription.CreatedAt = old.CreatedAt
+	}
+	subscription.UpdatedAt = now
+	b, err := json.Marshal(subscription)
+	if err != nil {
+		return err
+	}
+	return r.storeClient.PutRecord(RecordSubscription, subscription.Name, string(b))
+}
+
+func (r *MetadataRepo) DeleteSubscription(name string) error {
+	return r.storeClient.DeleteRecord(RecordSubscription, name)
+}
+
+func unmarshalSubscription(data string) (*Subscription, error) {
+	subscription := &Subscription{}
+	err := json.Unmarshal([]byte(data), subscription)
+	return subscription, err
+}
@@ -0,0 +1,327 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metadata
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"openpitrix.io/metad/pkg/flatmap"
+	"openpitrix.io/metad/pkg/logger"
+	"openpitrix.io/metad/pkg/store"
+)
+
+// PausedPrefix is a data prefix whose backend sync is paused, the store keep the view of the prefix when paused.
+type PausedPrefix struct {
+	Prefix   string    `json:"prefix"`
+	PausedAt time.Time `json:"paused_at"`
+	// Dropped is the count of the sync writes dropped since paused.
+	Dropped int64 `json:"dropped"`
+}
+
+// ResyncResult is the changes of the store made by reloading the prefix from the backend.
+type ResyncResult struct {
+	Prefix  string `json:"prefix"`
+	Updated int    `json:"updated"`
+	Deleted int    `json:"deleted"`
+	// Replayed is the count of the sync writes arrived while reloading, which are applied after reloaded.
+	Replayed int `json:"replayed"`
+}
+
+// SyncStateError is the error of the sync control conflicting with the sync state of the prefix.
+type SyncStateError struct {
+	error
+}
+
+// IsSyncStateError return whether the error is caused by the sync state of the prefix.
+func IsSyncStateError(err error) bool {
+	_, ok := err.(*SyncStateError)
+	return ok
+}
+
+type heldWrite struct {
+	nodePath string
+	value    string
+	delete   bool
+}
+
+// syncGate is the data store the backend sync write to, the sync writes under the paused and mounted prefixes are
+// dropped, and the sync writes under the prefixes reloading are held and replayed after reloaded.
+type syncGate struct {
+	store.Store
+	lock      sync.Mutex
+	paused    map[string]*PausedPrefix
+	reloading map[string][]*heldWrite
+	mounted   map[string]bool
+}
+
+func newSyncGate(s store.Store) *syncGate {
+	return &syncGate{Store: s, paused: map[string]*PausedPrefix{}, reloading: map[string][]*heldWrite{}, mounted: map[string]bool{}}
+}
+
+// gated return whether a paused or reloading prefix is under nodePath, or nodePath is under it.
+func (g *syncGate) gated(nodePath string) bool {
+	for prefix := range g.paused {
+		if isSubPath(nodePath, prefix) || isSubPath(prefix, nodePath) {
+			return true
+		}
+	}
+	for prefix := range g.reloading {
+		if isSubPath(nodePath, prefix) || isSubPath(prefix, nodePath) {
+			return true
+		}
+	}
+	for prefix := range g.mounted {
+		if isSubPath(nodePath, prefix) || isSubPath(prefix, nodePath) {
+			return true
+		}
+	}
+	return false
+}
+
+// pass return whether the sync write of the leaf should be applied, the write is dropped or held otherwise.
+func (g *syncGate) pass(write *heldWrite) bool {
+	for prefix := range g.mounted {
+		if isSubPath(write.nodePath, prefix) {
+			return false
+		}
+	}
+	for prefix, paused := range g.paused {
+		if isSubPath(write.nodePath, prefix) {
+			paused.Dropped++
+			return false
+		}
+	}
+	for prefix, held := range g.reloading {
+		if isSubPath(write.nodePath, prefix) {
+			g.reloading[prefix] = append(held, write)
+			return false
+		}
+	}
+	return true
+}
+
+func (g *syncGate) Put(nodePath string, value interface{}) {
+	nodePath = path.Join("/", nodePath)
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if !g.gated(nodePath) {
+		g.Store.Put(nodePath, value)
+		return
+	}
+	if v, ok := value.(string); ok {
+		if g.pass(&heldWrite{nodePath: nodePath, value: v}) {
+			g.Store.Put(nodePath, v)
+		}
+		return
+	}
+	g.putBulk(nodePath, flatmap.Flatten(value))
+}
+
+func (g *syncGate) PutBulk(nodePath string, values map[string]string) {
+	nodePath = path.Join("/", nodePath)
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if !g.gated(nodePath) {
+		g.Store.PutBulk(nodePath, values)
+		return
+	}
+	g.putBulk(nodePath, values)
+}
+
+// Load load the values only if no prefix is gated, the caller put the values through the gate otherwise.
+func (g *syncGate) Load(values map[string]string) bool {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if len(g.paused) > 0 || len(g.reloading) > 0 || len(g.mounted) > 0 {
+		return false
+	}
+	return g.Store.Load(values)
+}
+
+func (g *syncGate) putBulk(nodePath string, values map[string]string) {
+	passed := make(map[string]string, len(values))
+	for k, v := range values {
+		if g.pass(&heldWrite{nodePath: path.Join(nodePath, k), value: v}) {
+			passed[k] = v
+		}
+	}
+	if len(passed) > 0 {
+		g.Store.PutBulk(nodePath, passed)
+	}
+}
+
+func (g *syncGate) Delete(nodePath string) {
+	nodePath = path.Join("/", nodePath)
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if !g.gated(nodePath) {
+		g.Store.Delete(nodePath)
+		return
+	}
+	_, old := g.Store.Get(nodePath)
+	if _, dir := old.(map[string]interface{}); !dir {
+		if g.pass(&heldWrite{nodePath: nodePath, delete: true}) {
+			g.Store.Delete(nodePath)
+		}
+		return
+	}
+	// the delete of a dir covering gated prefixes, only delete the leaves not gated.
+	for k := range flattenValue(old) {
+		leaf := path.Join(nodePath, k)
+		if g.pass(&heldWrite{nodePath: leaf, delete: true}) {
+			g.Store.Delete(leaf)
+		}
+	}
+}
+
+func (g *syncGate) pause(prefix string) error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if _, ok := g.paused[prefix]; ok {
+		return &SyncStateError{fmt.Errorf("sync of prefix [%s] has been paused.", prefix)}
+	}
+	g.paused[prefix] = &PausedPrefix{Prefix: prefix, PausedAt: time.Now()}
+	return nil
+}
+
+func (g *syncGate) pausedPrefixes() []*PausedPrefix {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	result := make([]*PausedPrefix, 0, len(g.paused))
+	for _, paused := range g.paused {
+		p := *paused
+		result = append(result, &p)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Prefix < result[j].Prefix
+	})
+	return result
+}
+
+// startReload hold the sync writes under the prefix, and unpause it if resume, the unpaused entry is returned.
+func (g *syncGate) startReload(prefix string, resume bool) (*PausedPrefix, error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if _, ok := g.reloading[prefix]; ok {
+		return nil, &SyncStateError{fmt.Errorf("prefix [%s] is reloading.", prefix)}
+	}
+	var paused *PausedPrefix
+	if resume {
+		paused = g.paused[prefix]
+		if paused == nil {
+			return nil, &SyncStateError{fmt.Errorf("sync of prefix [%s] is not paused.", prefix)}
+		}
+		delete(g.paused, prefix)
+	}
+	g.reloading[prefix] = []*heldWrite{}
+	return paused, nil
+}
+
+// finishReload replace the store view of the prefix by the backend value if loaded, then replay the held writes.
+// If the reload failed, the unpaused prefix is paused again, as the view has missed the changes while paused.
+func (g *syncGate) finishReload(prefix string, val interface{}, loaded bool, unpaused *PausedPrefix) *ResyncResult {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	result := &ResyncResult{Prefix: prefix}
+	if !loaded && unpaused != nil {
+		g.paused[prefix] = unpaused
+	}
+	if loaded {
+		result.Updated, result.Deleted = g.replace(prefix, flattenValue(val))
+	}
+	held := g.reloading[prefix]
+	delete(g.reloading, prefix)
+	for _, write := range held {
+		if !g.pass(write) {
+			continue
+		}
+		if write.delete {
+			g.Store.Delete(write.nodePath)
+		} else {
+			g.Store.Put(write.nodePath, write.value)
+		}
+		result.Replayed++
+	}
+	return result
+}
+
+// replace replace the store view of the prefix by the flat values, only the changed keys are written,
+// return the count of the updated and deleted keys.
+func (g *syncGate) replace(prefix string, values map[string]string) (updated int, deleted int) {
+	_, old := g.Store.Get(prefix)
+	oldValues := flattenValue(old)
+	for k := range oldValues {
+		if _, ok := values[k]; !ok {
+			g.Store.Delete(path.Join(prefix, k))
+			deleted++
+		}
+	}
+	if v, leaf := values["/"]; leaf {
+		if oldValues["/"] != v {
+			g.Store.Put(prefix, v)
+			updated++
+		}
+		return
+	}
+	changed := map[string]string{}
+	for k, v := range values {
+		if old, ok := oldValues[k]; !ok || old != v {
+			changed[k] = v
+		}
+	}
+	if len(changed) > 0 {
+		g.Store.PutBulk(prefix, changed)
+		updated = len(changed)
+	}
+	return
+}
+
+// PauseSync stop applying the backend changes under the data prefix, the store keep the current view of the prefix
+// until ResumeSync. The pause is not persisted, a restarted metad sync all the prefixes.
+func (r *MetadataRepo) PauseSync(prefix string) error {
+	prefix = path.Join("/", prefix)
+	if err := r.syncGate.pause(prefix); err != nil {
+		return err
+	}
+	logger.Info("Pause sync of prefix [%s].", prefix)
+	return nil
+}
+
+// PausedSyncs return the data prefixes whose sync is paused, in prefix order.
+func (r *MetadataRepo) PausedSyncs() []*PausedPrefix {
+	return r.syncGate.pausedPrefixes()
+}
+
+// ResumeSync apply the backend changes under the data prefix again, the prefix is reloaded from the backend,
+// as the changes while paused are dropped.
+func (r *MetadataRepo) ResumeSync(prefix string) (*ResyncResult, error) {
+	return r.reloadData(prefix, true)
+}
+
+// ResyncData reload the data prefix from the backend immediately, the store view of the prefix is replaced by the
+// backend value, the backend changes arrive while reloading are applied after reloaded.
+func (r *MetadataRepo) ResyncData(prefix string) (*ResyncResult, error) {
+	return r.reloadData(prefix, false)
+}
+
+func (r *MetadataRepo) reloadData(prefix string, resume bool) (*ResyncResult, error) {
+	prefix = path.Join("/", prefix)
+	unpaused, err := r.syncGate.startReload(prefix, resume)
+	if err != nil {
+		return nil, err
+	}
+	val, err := r.GetBackendData(prefix)
+	if err != nil {
+		r.syncGate.finishReload(prefix, nil, false, unpaused)
+		return nil, err
+	}
+	result := r.syncGate.finishReload(prefix, val, true, nil)
+	logger.Info("Resync prefix [%s], updated: %d, deleted: %d, replayed: %d.", prefix, result.Updated, result.Deleted, result.Replayed)
+	return result, nil
+}
@@ -0,0 +1,166 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metadata
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"time"
+
+	"openpitrix.io/metad/pkg/logger"
+)
+
+// Token authenticate a client as the Host, the Host is used as the key of mapping and access rule in place of client ip.
+// For manage api, Team is the team the token belong to, and Admin token can modify any path.
+// Only the sha256 hash of the token is stored, the ID is the hash.
+type Token struct {
+	ID   string `json:"id"`
+	Host string `json:"host,omitempty"`
+	// Sub is the subject the token issued to, such as the instance id, resolves the {token.sub} of the mapping.
+	Sub         string `json:"sub,omitempty"`
+	Team        string `json:"team,omitempty"`
+	Admin       bool   `json:"admin,omitempty"`
+	Description string `json:"description,omitempty"`
+	CreatedAt   int64  `json:"created_at"`
+	// Roles are the manage api roles of the token, enforced when manage_rbac is enabled.
+	Roles []RoleBinding `json:"roles,omitempty"`
+	// Token only present in the response of creating.
+	Token string `json:"token,omitempty"`
+}
+
+// the manage api roles.
+const (
+	RoleReadOnly     = "read-only"
+	RoleWriter       = "writer"
+	RoleMappingAdmin = "mapping-admin"
+	RoleAdmin        = "admin"
+)
+
+// RoleBinding grant the Role to a token, or to the Identity (the verified client cert identity) by the role_bindings
+// config. Prefixes limit the data paths a read-only or writer role can access, empty means all paths.
+type RoleBinding struct {
+	Identity string   `json:"identity,omitempty" yaml:"identity,omitempty"`
+	Role     string   `json:"role" yaml:"role"`
+	Prefixes []string `json:"prefixes,omitempty" yaml:"prefixes,omitempty"`
+}
+
+// CheckRole check the role is one of the manage api roles.
+func CheckRole(role string) error {
+	switch role {
+	case RoleReadOnly, RoleWriter, RoleMappingAdmin, RoleAdmin:
+		return nil
+	}
+	return fmt.Errorf("unknown role [%s], should be one of %s, %s, %s and %s.", role, RoleReadOnly, RoleWriter, RoleMappingAdmin, RoleAdmin)
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func generateToken() (string, error) {
+	b := make([]byte, 24)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// CreateToken create a token for the host, if token.Token is empty, a random token will be generated.
+func (r *MetadataRepo) CreateToken(token *Token) (*Token, error) {
+	if token.Host == "" && token.Team == "" && !token.Admin && len(token.Roles) == 0 {
+		return nil, errors.New("token should have host, team, admin or roles.")
+	}
+	for _, binding := range token.Roles {
+		if err := CheckRole(binding.Role); err != nil {
+			return nil, err
+		}
+	}
+	secret := token.Token
+	if secret == "" {
+		var err error
+		secret, err = generateToken()
+		if err != nil {
+			return nil, err
+		}
+	}
+	record := &Token{
+		ID:          hashToken(secret),
+		Host:        token.Host,
+		Sub:         token.Sub,
+		Team:        token.Team,
+		Admin:       token.Admin,
+		Description: token.Description,
+		CreatedAt:   time.Now().Unix(),
+		Roles:       token.Roles,
+	}
+	b, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	err = r.storeClient.PutRecord(RecordToken, record.ID, string(b))
+	if err != nil {
+		return nil, err
+	}
+	result := *record
+	result.Token = secret
+	return &result, nil
+}
+
+func (r *MetadataRepo) GetTokens() []*Token {
+	tokens := []*Token{}
+	for _, v := range r.records[RecordToken].GetAll() {
+		token, err := unmarshalToken(v)
+		if err != nil {
+			logger.Error("Unexpect token json value in backend")
+			continue
+		}
+		tokens = append(tokens, token)
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].CreatedAt < tokens[j].CreatedAt
+	})
+	return tokens
+}
+
+func (r *MetadataRepo) DeleteToken(id string) error {
+	return r.storeClient.DeleteRecord(RecordToken, id)
+}
+
+// GetToken return the token record of the token secret, nil if not exist.
+func (r *MetadataRepo) GetToken(secret string) *Token {
+	v, ok := r.records[RecordToken].Get(path.Join("/", hashToken(secret)))
+	if !ok {
+		return nil
+	}
+	token, err := unmarshalToken(v)
+	if err != nil {
+		logger.Error("Unexpect token json value in backend")
+		return nil
+	}
+	return token
+}
+
+// GetTokenHost return the host which the token authenticated as.
+func (r *MetadataRepo) GetTokenHost(secret string) (string, bool) {
+	token := r.GetToken(secret)
+	if token == nil || token.Host == "" {
+		return "", false
+	}
+	return token.Host, true
+}
+
+func unmarshalToken(data string) (*Token, error) {
+	token := &Token{}
+	err := json.Unmarshal([]byte(data), token)
+	return token, err
+}
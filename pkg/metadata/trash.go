@@ -0,0 +1,216 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metadata
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"time"
+
+	"openpitrix.io/metad/pkg/logger"
+)
+
+// TrashPrefix is the data prefix of the trash, the deleted data is kept at TrashPrefix/<id>/<path>.
+const TrashPrefix = "/_trash"
+
+// TrashEntry is the data deleted by one delete request, kept in the trash until restored or expired.
+// The ID is the unix nanoseconds of the delete.
+type TrashEntry struct {
+	ID        string   `json:"id"`
+	Paths     []string `json:"paths"`
+	Actor     string   `json:"actor,omitempty"`
+	DeletedAt int64    `json:"deleted_at"`
+}
+
+// TrashConflictError is the error of the restore to the path which exists.
+type TrashConflictError struct {
+	Path string
+}
+
+func (e *TrashConflictError) Error() string {
+	return fmt.Sprintf("path [%s] already exist.", e.Path)
+}
+
+func IsTrashConflictError(err error) bool {
+	_, ok := err.(*TrashConflictError)
+	return ok
+}
+
+// IsTrashPath return whether the path is the trash or under it.
+func IsTrashPath(nodePath string) bool {
+	return isSubPath(path.Join("/", nodePath), TrashPrefix)
+}
+
+func (r *MetadataRepo) GetTrashEntries() []*TrashEntry {
+	entries := []*TrashEntry{}
+	for _, v := range r.records[RecordTrash].GetAll() {
+		entry, err := unmarshalTrashEntry(v)
+		if err != nil {
+			logger.Error("Unexpect trash json value [%s]", v)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	// the ids are the unix nanoseconds of the deletes in the same second.
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].DeletedAt != entries[j].DeletedAt {
+			return entries[i].DeletedAt < entries[j].DeletedAt
+		}
+		return entries[i].ID < entries[j].ID
+	})
+	return entries
+}
+
+func (r *MetadataRepo) GetTrashEntry(id string) *TrashEntry {
+	v, ok := r.records[RecordTrash].Get(path.Join("/", id))
+	if !ok {
+		return nil
+	}
+	entry, err := unmarshalTrashEntry(v)
+	if err != nil {
+		logger.Error("Unexpect trash json value [%s]", v)
+		return nil
+	}
+	return entry
+}
+
+// DeleteToTrash move the data of the paths to the trash as one entry, the root is expanded to the top level paths
+// except the trash itself, and the paths under the trash are deleted without trash. The entry is nil if nothing moved.
+func (r *MetadataRepo) DeleteToTrash(actor string, paths ...string) (*TrashEntry, error) {
+	deletes := r.trashDeletes(paths)
+	if err := r.checkMounted(deletes...); err != nil {
+		return nil, err
+	}
+	writes := make([]*dataWrite, 0, len(deletes))
+	for _, p := range deletes {
+		writes = append(writes, deleteWrite(p))
+	}
+	if err := r.checkSchemas(writes...); err != nil {
+		return nil, err
+	}
+	entry, err := r.putTrash(actor, deletes)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.DeletePaths(deletes...); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// TrashData copy the data of the paths to the trash as one entry without delete, for the data deleted in other ways,
+// such as the delete job which delete the leaves in batches. The entry is nil if nothing copied.
+func (r *MetadataRepo) TrashData(actor string, paths ...string) (*TrashEntry, error) {
+	return r.putTrash(actor, r.trashDeletes(paths))
+}
+
+// trashDeletes return the sorted paths to delete, the root is expanded to the top level paths except the trash.
+func (r *MetadataRepo) trashDeletes(paths []string) []string {
+	deletes := []string{}
+	for _, p := range paths {
+		p = path.Join("/", p)
+		if p != "/" {
+			deletes = append(deletes, p)
+			continue
+		}
+		if root, ok := r.GetData("/").(map[string]interface{}); ok {
+			for name := range root {
+				if name != TrashPrefix[1:] {
+					deletes = append(deletes, path.Join("/", name))
+				}
+			}
+		}
+	}
+	sort.Strings(deletes)
+	return deletes
+}
+
+// putTrash copy the data of the paths except the trash paths to a new trash entry, nil if nothing copied.
+func (r *MetadataRepo) putTrash(actor string, deletes []string) (*TrashEntry, error) {
+	now := time.Now()
+	entry := &TrashEntry{ID: strconv.FormatInt(now.UnixNano(), 10), Paths: []string{}, Actor: actor, DeletedAt: now.Unix()}
+	for _, p := range deletes {
+		v := r.GetData(p)
+		if v == nil || IsTrashPath(p) {
+			continue
+		}
+		if err := r.storeClient.Put(path.Join(TrashPrefix, entry.ID, p), v, true); err != nil {
+			return nil, err
+		}
+		entry.Paths = append(entry.Paths, p)
+	}
+	if len(entry.Paths) == 0 {
+		return nil, nil
+	}
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.storeClient.PutRecord(RecordTrash, entry.ID, string(b)); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// RestoreTrash put the data of the trash entry back to its paths and remove the entry, return TrashConflictError
+// if any path exists unless overwrite, the existing data of the paths is replaced if overwrite. The entry is nil if
+// not exist.
+func (r *MetadataRepo) RestoreTrash(id string, overwrite bool) (*TrashEntry, error) {
+	entry := r.GetTrashEntry(id)
+	if entry == nil {
+		return nil, nil
+	}
+	values := make(map[string]interface{}, len(entry.Paths))
+	for _, p := range entry.Paths {
+		if !overwrite && r.GetData(p) != nil {
+			return nil, &TrashConflictError{Path: p}
+		}
+		if v := r.GetData(path.Join(TrashPrefix, entry.ID, p)); v != nil {
+			values[p] = v
+		}
+	}
+	for _, p := range entry.Paths {
+		if v, ok := values[p]; ok {
+			if err := r.PutData(p, v, true); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return entry, r.PurgeTrash(entry.ID)
+}
+
+// PurgeTrash delete the data of the trash entry and the entry.
+func (r *MetadataRepo) PurgeTrash(id string) error {
+	trashPath := path.Join(TrashPrefix, id)
+	if r.GetData(trashPath) != nil {
+		if err := r.storeClient.Delete(trashPath, true); err != nil {
+			return err
+		}
+	}
+	return r.storeClient.DeleteRecord(RecordTrash, id)
+}
+
+// ExpireTrash purge the trash entries deleted before the time.
+func (r *MetadataRepo) ExpireTrash(before time.Time) {
+	for _, entry := range r.GetTrashEntries() {
+		if entry.DeletedAt >= before.Unix() {
+			break
+		}
+		if err := r.PurgeTrash(entry.ID); err != nil {
+			logger.Error("Purge trash [%s] error: %s", entry.ID, err.Error())
+			continue
+		}
+		logger.Info("Purged trash [%s] of %v deleted at %s", entry.ID, entry.Paths, time.Unix(entry.DeletedAt, 0).Format(time.RFC3339))
+	}
+}
+
+func unmarshalTrashEntry(data string) (*TrashEntry, error) {
+	entry := &TrashEntry{}
+	err := json.Unmarshal([]byte(data), entry)
+	return entry, err
+}
@@ -0,0 +1,216 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metadata
+
+import (
+	"path"
+	"strconv"
+	"sync/atomic"
+)
+
+// the json types of the typed leaves, the leaves without type record are strings.
+const (
+	ValueTypeNumber  = "number"
+	ValueTypeBoolean = "boolean"
+	ValueTypeNull    = "null"
+)
+
+// SetTypedValues enable or disable the typed values, if enabled, the json types of the non string leaves written by
+// PutData and PatchData are recorded, and the typed leaves are converted back by TypeData and TypeSelf.
+func (r *MetadataRepo) SetTypedValues(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&r.typedValues, v)
+}
+
+func (r *MetadataRepo) TypedValues() bool {
+	return atomic.LoadInt32(&r.typedValues) == 1
+}
+
+// collectValueTypes collect the json types of the leaves of the value at nodePath, empty for the string leaves.
+func collectValueTypes(nodePath string, value interface{}, types map[string]string) {
+	switch t := value.(type) {
+	case map[string]interface{}:
+		for k, v := range t {
+			if k != "" {
+				collectValueTypes(path.Join(nodePath, k), v, types)
+			}
+		}
+	case []interface{}:
+		for i, v := range t {
+			collectValueTypes(path.Join(nodePath, strconv.Itoa(i)), v, types)
+		}
+	case map[string]string:
+		for k := range t {
+			if k != "" {
+				types[path.Join(nodePath, k)] = ""
+			}
+		}
+	case nil:
+		types[nodePath] = ValueTypeNull
+	case bool:
+		types[nodePath] = ValueTypeBoolean
+	case float64, float32, int, int64, int32:
+		types[nodePath] = ValueTypeNumber
+	default:
+		types[nodePath] = ""
+	}
+}
+
+// putValueTypes update the type records by the put of the value at nodePath, the records of the string leaves are
+// deleted, and the records of the replaced leaves if replace.
+func (r *MetadataRepo) putValueTypes(nodePath string, value interface{}, replace bool) error {
+	if !r.TypedValues() {
+		return nil
+	}
+	nodePath = path.Join("/", nodePath)
+	types := map[string]string{}
+	collectValueTypes(nodePath, value, types)
+	records := r.records[RecordValueType].GetAll()
+	for p, t := range types {
+		if old := records[p]; old != t {
+			var err error
+			if t == "" {
+				err = r.storeClient.DeleteRecord(RecordValueType, p)
+			} else {
+				err = r.storeClient.PutRecord(RecordValueType, p, t)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if !replace {
+		return nil
+	}
+	for p := range records {
+		if _, ok := types[p]; !ok && isSubPath(p, nodePath) {
+			if err := r.storeClient.DeleteRecord(RecordValueType, p); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// deleteValueTypes delete the type records of the leaves under nodePath.
+func (r *MetadataRepo) deleteValueTypes(nodePath string) error {
+	nodePath = path.Join("/", nodePath)
+	for p := range r.records[RecordValueType].GetAll() {
+		if isSubPath(p, nodePath) {
+			if err := r.storeClient.DeleteRecord(RecordValueType, p); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// copyValueTypes copy the type records of the leaves under from to the same relative paths under to, copied check
+// the relative path of the leaf ("/" for from itself) is copied, nil copy all.
+func (r *MetadataRepo) copyValueTypes(from string, to string, copied func(key string) bool) error {
+	from = path.Join("/", from)
+	to = path.Join("/", to)
+	for p, t := range r.records[RecordValueType].GetAll() {
+		if !isSubPath(p, from) {
+			continue
+		}
+		key := "/"
+		if from == "/" {
+			key = p
+		} else if p != from {
+			key = p[len(from):]
+		}
+		if copied != nil && !copied(key) {
+			continue
+		}
+		if err := r.storeClient.PutRecord(RecordValueType, path.Join(to, key), t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteLeafValueTypes delete the type records of the deleted leaves.
+func (r *MetadataRepo) deleteLeafValueTypes(leaves []string) error {
+	records := r.records[RecordValueType].GetAll()
+	if len(records) == 0 {
+		return nil
+	}
+	for _, leaf := range leaves {
+		if _, ok := records[leaf]; ok {
+			if err := r.storeClient.DeleteRecord(RecordValueType, leaf); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// typedValue convert the leaf value by the type, the value not matching the type (such as changed by other
+// ways) is kept as string.
+func typedValue(value string, valueType string) interface{} {
+	switch valueType {
+	case ValueTypeNumber:
+		if i, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return i
+		}
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
+	case ValueTypeBoolean:
+		if value == "true" || value == "false" {
+			return value == "true"
+		}
+	case ValueTypeNull:
+		if value == "" {
+			return nil
+		}
+	}
+	return value
+}
+
+// typeValue convert the leaves of the value by the types, dataPath return the data path of the leaf.
+func typeValue(value interface{}, nodePath string, types map[string]string, dataPath func(string) string) interface{} {
+	switch t := value.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(t))
+		for k, v := range t {
+			result[k] = typeValue(v, path.Join(nodePath, k), types, dataPath)
+		}
+		return result
+	case string:
+		if valueType, ok := types[dataPath(nodePath)]; ok {
+			return typedValue(t, valueType)
+		}
+	}
+	return value
+}
+
+// TypeData convert the typed leaves of the data value at nodePath back to their json types.
+func (r *MetadataRepo) TypeData(nodePath string, value interface{}) interface{} {
+	types := r.records[RecordValueType].GetAll()
+	if !r.TypedValues() || len(types) == 0 {
+		return value
+	}
+	return typeValue(value, path.Join("/", nodePath), types, func(p string) string { return p })
+}
+
+// TypeSelf convert the typed leaves of the self value of the client at nodePath back to their json types, the
+// leaves are typed by the data paths they mapped to.
+func (r *MetadataRepo) TypeSelf(clientIP string, nodePath string, value interface{}) interface{} {
+	types := r.records[RecordValueType].GetAll()
+	if !r.TypedValues() || len(types) == 0 {
+		return value
+	}
+	return typeValue(value, path.Join("/", nodePath), types, func(p string) string {
+		if paths := r.SelfPaths(clientIP, p); len(paths) == 1 {
+			return paths[0]
+		}
+		return ""
+	})
+}
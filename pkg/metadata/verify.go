@@ -0,0 +1,230 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package metadata
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"openpitrix.io/metad/pkg/flatmap"
+	"openpitrix.io/metad/pkg/store"
+)
+
+// maxVerifyDiffs is the max number of keys reported in every kind of discrepancy, the counts are always reported.
+const maxVerifyDiffs = 100
+
+// VerifyResult is the comparison of a store with the backend at the revision the store synced.
+// Missing are the keys only in backend, Extra are the keys only in store, Mismatched are the keys with different values.
+type VerifyResult struct {
+	Revision        int64    `json:"revision,omitempty"`
+	StoreKeys       int      `json:"store_keys"`
+	BackendKeys     int      `json:"backend_keys"`
+	StoreHash       string   `json:"store_hash"`
+	BackendHash     string   `json:"backend_hash"`
+	MissingCount    int      `json:"missing_count"`
+	ExtraCount      int      `json:"extra_count"`
+	MismatchedCount int      `json:"mismatched_count"`
+	Missing         []string `json:"missing"`
+	Extra           []string `json:"extra"`
+	Mismatched      []string `json:"mismatched"`
+	Repaired        int      `json:"repaired"`
+}
+
+func (v *VerifyResult) consistent() bool {
+	return v.MissingCount == 0 && v.ExtraCount == 0 && v.MismatchedCount == 0
+}
+
+// VerifyReport is the result of the consistency check of the data and mapping stores.
+type VerifyReport struct {
+	CheckedAt  time.Time     `json:"checked_at"`
+	Consistent bool          `json:"consistent"`
+	Data       *VerifyResult `json:"data"`
+	Mapping    *VerifyResult `json:"mapping"`
+}
+
+// Verify compare the data and mapping stores with a fresh backend read at the revisions the stores synced,
+// the differences are rechecked once, so the changes in flight are not reported. The mounted data prefixes are skipped.
+// If repair, the differences of the store are fixed by the backend values, backend is not changed.
+func (r *MetadataRepo) Verify(repair bool) (*VerifyReport, error) {
+	report := &VerifyReport{CheckedAt: time.Now()}
+	var err error
+	report.Data, err = verifyStore(r.data, func() int64 {
+		rev, _ := r.storeClient.SyncedRevisions()
+		return rev
+	}, func(rev int64) (interface{}, error) {
+		if rev > 0 {
+			return r.storeClient.GetAtRevision("/", rev)
+		}
+		return r.storeClient.Get("/", true)
+	}, r.mountedPath, repair)
+	if err != nil {
+		return nil, err
+	}
+	report.Mapping, err = verifyStore(r.mapping, func() int64 {
+		_, rev := r.storeClient.SyncedRevisions()
+		return rev
+	}, func(rev int64) (interface{}, error) {
+		if rev > 0 {
+			return r.storeClient.GetMappingAtRevision("/", rev)
+		}
+		return r.storeClient.GetMapping("/", true)
+	}, nil, repair)
+	if err != nil {
+		return nil, err
+	}
+	report.Consistent = report.Data.consistent() && report.Mapping.consistent()
+	return report, nil
+}
+
+type verifySnapshot struct {
+	revision int64
+	store    map[string]string
+	backend  map[string]string
+}
+
+// snapshot read the store and then the backend at the revision the store synced, without the keys to skip,
+// retry if the store synced new changes while reading.
+func snapshot(s store.Store, revision func() int64, read func(rev int64) (interface{}, error), skip func(key string) bool) (*verifySnapshot, error) {
+	for i := 0; i < 3; i++ {
+		rev := revision()
+		_, storeVal := s.Get("/")
+		if revision() != rev {
+			continue
+		}
+		backendVal, err := read(rev)
+		if err != nil {
+			return nil, err
+		}
+		snap := &verifySnapshot{revision: rev, store: flattenValue(storeVal), backend: flattenValue(backendVal)}
+		if skip != nil {
+			for _, values := range []map[string]string{snap.store, snap.backend} {
+				for k := range values {
+					if skip(k) {
+						delete(values, k)
+					}
+				}
+			}
+		}
+		return snap, nil
+	}
+	return nil, errors.New("store is changing too fast to verify, retry later.")
+}
+
+func flattenValue(v interface{}) map[string]string {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		return flatmap.Flatten(t)
+	case []interface{}:
+		return flatmap.Flatten(t)
+	case nil:
+		return map[string]string{}
+	default:
+		return map[string]string{"/": fmt.Sprintf("%v", t)}
+	}
+}
+
+// diffKeys return the keys only in backend, only in store, and with different values.
+func (s *verifySnapshot) diffKeys() (missing, extra, mismatched []string) {
+	missing, extra, mismatched = []string{}, []string{}, []string{}
+	for k, v := range s.backend {
+		sv, ok := s.store[k]
+		if !ok {
+			missing = append(missing, k)
+		} else if sv != v {
+			mismatched = append(mismatched, k)
+		}
+	}
+	for k := range s.store {
+		if _, ok := s.backend[k]; !ok {
+			extra = append(extra, k)
+		}
+	}
+	return
+}
+
+func hashValues(values map[string]string) string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	h := sha256.New()
+	for _, k := range keys {
+		h.Write([]byte(k))
+		h.Write([]byte{0})
+		h.Write([]byte(values[k]))
+		h.Write([]byte{'\n'})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// confirmed return the keys in both lists.
+func confirmed(first []string, second []string) []string {
+	in := make(map[string]bool, len(first))
+	for _, k := range first {
+		in[k] = true
+	}
+	result := []string{}
+	for _, k := range second {
+		if in[k] {
+			result = append(result, k)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+func truncateKeys(keys []string) []string {
+	if len(keys) > maxVerifyDiffs {
+		return keys[:maxVerifyDiffs]
+	}
+	return keys
+}
+
+func verifyStore(s store.Store, revision func() int64, read func(rev int64) (interface{}, error), skip func(key string) bool, repair bool) (*VerifyResult, error) {
+	snap, err := snapshot(s, revision, read, skip)
+	if err != nil {
+		return nil, err
+	}
+	missing, extra, mismatched := snap.diffKeys()
+	if len(missing)+len(extra)+len(mismatched) > 0 {
+		// recheck, the differences of the changes in flight disappear.
+		first := append(append(append([]string{}, missing...), extra...), mismatched...)
+		snap, err = snapshot(s, revision, read, skip)
+		if err != nil {
+			return nil, err
+		}
+		missing, extra, mismatched = snap.diffKeys()
+		missing, extra, mismatched = confirmed(first, missing), confirmed(first, extra), confirmed(first, mismatched)
+	}
+	result := &VerifyResult{
+		Revision:        snap.revision,
+		StoreKeys:       len(snap.store),
+		BackendKeys:     len(snap.backend),
+		StoreHash:       hashValues(snap.store),
+		BackendHash:     hashValues(snap.backend),
+		MissingCount:    len(missing),
+		ExtraCount:      len(extra),
+		MismatchedCount: len(mismatched),
+		Missing:         truncateKeys(missing),
+		Extra:           truncateKeys(extra),
+		Mismatched:      truncateKeys(mismatched),
+	}
+	if repair {
+		for _, k := range append(missing, mismatched...) {
+			s.Put(k, snap.backend[k])
+			result.Repaired++
+		}
+		for _, k := range extra {
+			s.Delete(k)
+			result.Repaired++
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,437 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+// Package notify POST the data change events to the webhooks.
+package notify
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"openpitrix.io/metad/pkg/logger"
+	"openpitrix.io/metad/pkg/store"
+)
+
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+
+	// headers of the webhook request, the signature is only present if the webhook has secret.
+	HeaderNotificationID = "X-Metad-Notification-ID"
+	HeaderTimestamp      = "X-Metad-Timestamp"
+	HeaderSignature      = "X-Metad-Signature"
+	HeaderEventSchema    = "X-Metad-Event-Schema"
+
+	signaturePrefix = "sha256="
+	defaultTimeout  = 10 * time.Second
+)
+
+// ErrStopped is the error of the notification not delivered because the notifier stopped.
+var ErrStopped = errors.New("notifier stopped.")
+
+var webhookNameRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// Webhook receive the data change events under Prefix,
+// the events are filtered by Actions and Paths (glob patterns match the event path or its parents) if present.
+type Webhook struct {
+	Name    string   `json:"name"`
+	URL     string   `json:"url"`
+	Prefix  string   `json:"prefix"`
+	Actions []string `json:"actions,omitempty"`
+	Paths   []string `json:"paths,omitempty"`
+	Format  string   `json:"format,omitempty"`
+	Secret  string   `json:"secret,omitempty"`
+}
+
+// ParseWebhook parse the webhook from format "[prefix=]url", the default prefix is "/".
+func ParseWebhook(s string) (*Webhook, error) {
+	webhook := &Webhook{URL: s, Prefix: "/"}
+	if strings.HasPrefix(s, "/") {
+		idx := strings.Index(s, "=")
+		if idx < 0 {
+			return nil, fmt.Errorf("invalid webhook [%s], should be [prefix=]url.", s)
+		}
+		webhook.Prefix = path.Join("/", s[:idx])
+		webhook.URL = s[idx+1:]
+	}
+	if !strings.HasPrefix(webhook.URL, "http://") && !strings.HasPrefix(webhook.URL, "https://") {
+		return nil, fmt.Errorf("invalid webhook url [%s].", webhook.URL)
+	}
+	return webhook, nil
+}
+
+// CheckWebhook check the webhook and normalize the prefix and format.
+func CheckWebhook(webhook *Webhook) error {
+	if !webhookNameRegexp.MatchString(webhook.Name) {
+		return fmt.Errorf("invalid webhook name [%s].", webhook.Name)
+	}
+	if !strings.HasPrefix(webhook.URL, "http://") && !strings.HasPrefix(webhook.URL, "https://") {
+		return fmt.Errorf("invalid webhook url [%s].", webhook.URL)
+	}
+	webhook.Prefix = path.Join("/", webhook.Prefix)
+	for _, action := range webhook.Actions {
+		if action != store.Update && action != store.Delete {
+			return fmt.Errorf("invalid webhook action [%s], should be %s or %s.", action, store.Update, store.Delete)
+		}
+	}
+	for _, pattern := range webhook.Paths {
+		if _, err := path.Match(pattern, "/"); err != nil {
+			return fmt.Errorf("invalid webhook path pattern [%s].", pattern)
+		}
+	}
+	switch webhook.Format {
+	case "":
+		webhook.Format = FormatJSON
+	case FormatJSON, FormatText:
+	default:
+		return fmt.Errorf("invalid webhook format [%s], should be %s or %s.", webhook.Format, FormatJSON, FormatText)
+	}
+	return nil
+}
+
+func (w *Webhook) match(e *store.Event) bool {
+	if len(w.Actions) > 0 {
+		matched := false
+		for _, action := range w.Actions {
+			if action == e.Action {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if len(w.Paths) == 0 {
+		return true
+	}
+	for _, pattern := range w.Paths {
+		pattern = path.Join("/", pattern)
+		for p := e.Path; ; p = path.Dir(p) {
+			if ok, _ := path.Match(pattern, p); ok {
+				return true
+			}
+			if p == "/" {
+				break
+			}
+		}
+	}
+	return false
+}
+
+func (w *Webhook) filter(events []*store.Event) []*store.Event {
+	result := make([]*store.Event, 0, len(events))
+	for _, e := range events {
+		if w.match(e) {
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+// Notification is a batch of the data change events, it is the request body of webhook,
+// Test notification has no events.
+type Notification struct {
+	ID     string `json:"id"`
+	Time   int64  `json:"time"`
+	Prefix string `json:"prefix"`
+	// SchemaVersion is the wire format version of the events, see store.EventSchemaVersion.
+	SchemaVersion int            `json:"schema_version"`
+	Events        []*store.Event `json:"events"`
+	Test          bool           `json:"test,omitempty"`
+}
+
+func NewNotification(prefix string, events []*store.Event) *Notification {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return &Notification{
+		ID:            fmt.Sprintf("%d-%s", time.Now().UnixNano(), hex.EncodeToString(b)),
+		Time:          time.Now().Unix(),
+		Prefix:        prefix,
+		SchemaVersion: store.EventSchemaVersion,
+		Events:        events,
+	}
+}
+
+// Metrics is the delivery statistics of a webhook since metad started.
+type Metrics struct {
+	Delivered       int64  `json:"delivered"`
+	FailedAttempts  int64  `json:"failed_attempts"`
+	DeadLettered    int64  `json:"dead_lettered"`
+	LastDeliveredAt int64  `json:"last_delivered_at,omitempty"`
+	LastFailedAt    int64  `json:"last_failed_at,omitempty"`
+	LastError       string `json:"last_error,omitempty"`
+}
+
+// SubscribeFunc watch the data changes under prefix in background until stopChan closed, and pass the events to handler,
+// the returned channel is closed after stopped.
+type SubscribeFunc func(prefix string, stopChan <-chan struct{}, handler func(events []*store.Event)) <-chan struct{}
+
+// DeadLetterFunc is called with the notification failed after all attempts.
+type DeadLetterFunc func(webhook *Webhook, notification *Notification, attempts int, err error)
+
+type runningWebhook struct {
+	webhook  *Webhook
+	stopChan chan struct{}
+	done     <-chan struct{}
+}
+
+// Notifier deliver the data changes to the webhooks, retry with doubled interval on failure,
+// and hand the notification to the dead letter func if all attempts failed.
+type Notifier struct {
+	subscribe     SubscribeFunc
+	retries       int
+	retryInterval time.Duration
+	deadLetter    DeadLetterFunc
+	client        *http.Client
+	running       map[string]*runningWebhook
+	metrics       map[string]*Metrics
+	lock          sync.Mutex
+	updateLock    sync.Mutex
+}
+
+func NewNotifier(subscribe SubscribeFunc, retries int, retryInterval time.Duration, deadLetter DeadLetterFunc) *Notifier {
+	return &Notifier{
+		subscribe:     subscribe,
+		retries:       retries,
+		retryInterval: retryInterval,
+		deadLetter:    deadLetter,
+		client:        &http.Client{Timeout: defaultTimeout},
+		running:       make(map[string]*runningWebhook),
+		metrics:       make(map[string]*Metrics),
+	}
+}
+
+// SetRetry change the retry policy of the later failures.
+func (n *Notifier) SetRetry(retries int, retryInterval time.Duration) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.retries = retries
+	n.retryInterval = retryInterval
+}
+
+func (n *Notifier) retryPolicy() (int, time.Duration) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	return n.retries, n.retryInterval
+}
+
+// Update start the new webhooks, restart the changed and stop the removed ones, webhooks are identified by name.
+func (n *Notifier) Update(webhooks []*Webhook) {
+	n.updateLock.Lock()
+	defer n.updateLock.Unlock()
+	updated := make(map[string]*Webhook, len(webhooks))
+	for _, webhook := range webhooks {
+		updated[webhook.Name] = webhook
+	}
+
+	// stop without lock, the stopping webhook may be recording metrics.
+	n.lock.Lock()
+	var stopping []*runningWebhook
+	for name, r := range n.running {
+		if webhook, ok := updated[name]; !ok || !reflect.DeepEqual(webhook, r.webhook) {
+			stopping = append(stopping, r)
+			delete(n.running, name)
+		}
+	}
+	n.lock.Unlock()
+	for _, r := range stopping {
+		r.stop()
+	}
+
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	for name, webhook := range updated {
+		if _, ok := n.running[name]; ok {
+			continue
+		}
+		if _, ok := n.metrics[name]; !ok {
+			n.metrics[name] = &Metrics{}
+		}
+		n.running[name] = n.start(webhook)
+	}
+	for name := range n.metrics {
+		if _, ok := updated[name]; !ok {
+			delete(n.metrics, name)
+		}
+	}
+}
+
+// Stop stop all the webhooks, the notification in retrying is handed to dead letter func immediately.
+func (n *Notifier) Stop() {
+	n.Update(nil)
+}
+
+func (n *Notifier) start(webhook *Webhook) *runningWebhook {
+	r := &runningWebhook{webhook: webhook, stopChan: make(chan struct{})}
+	r.done = n.subscribe(webhook.Prefix, r.stopChan, func(events []*store.Event) {
+		events = webhook.filter(events)
+		if len(events) == 0 {
+			return
+		}
+		n.notify(webhook, NewNotification(webhook.Prefix, events), r.stopChan)
+	})
+	return r
+}
+
+func (r *runningWebhook) stop() {
+	close(r.stopChan)
+	<-r.done
+}
+
+// Metrics return the delivery metrics of the webhook.
+func (n *Notifier) Metrics(name string) Metrics {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if metrics, ok := n.metrics[name]; ok {
+		return *metrics
+	}
+	return Metrics{}
+}
+
+func (n *Notifier) record(name string, err error, deadLettered bool) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	metrics, ok := n.metrics[name]
+	if !ok {
+		return
+	}
+	now := time.Now().Unix()
+	switch {
+	case deadLettered:
+		metrics.DeadLettered++
+	case err == nil:
+		metrics.Delivered++
+		metrics.LastDeliveredAt = now
+	default:
+		metrics.FailedAttempts++
+		metrics.LastFailedAt = now
+		metrics.LastError = err.Error()
+	}
+}
+
+func (n *Notifier) notify(webhook *Webhook, notification *Notification, stopChan <-chan struct{}) {
+	retries, interval := n.retryPolicy()
+	var err error
+	attempts := 0
+	for {
+		attempts++
+		err = n.Deliver(webhook, notification)
+		n.record(webhook.Name, err, false)
+		if err == nil {
+			return
+		}
+		logger.Warn("Notify webhook [%s] notification [%s] attempt %d error: %s", webhook.Name, notification.ID, attempts, err.Error())
+		if attempts > retries {
+			break
+		}
+		stopped := false
+		select {
+		case <-time.After(interval):
+		case <-stopChan:
+			stopped = true
+		}
+		if stopped {
+			err = ErrStopped
+			break
+		}
+		interval *= 2
+	}
+	logger.Error("Notify webhook [%s] notification [%s] failed after %d attempts: %s", webhook.Name, notification.ID, attempts, err.Error())
+	n.record(webhook.Name, err, true)
+	if n.deadLetter != nil {
+		n.deadLetter(webhook, notification, attempts, err)
+	}
+}
+
+// Deliver POST the notification to the webhook once, non 2xx response status is error.
+// The request is signed if the webhook has secret, see Sign.
+func (n *Notifier) Deliver(webhook *Webhook, notification *Notification) error {
+	body, contentType, err := encode(webhook.Format, notification)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set(HeaderNotificationID, notification.ID)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderEventSchema, strconv.Itoa(notification.SchemaVersion))
+	if webhook.Secret != "" {
+		req.Header.Set(HeaderSignature, Sign(webhook.Secret, timestamp, body))
+	}
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook response status %d.", resp.StatusCode)
+	}
+	return nil
+}
+
+// encode the notification as json, or text format with one "action\tpath\tvalue" line per event.
+func encode(format string, notification *Notification) ([]byte, string, error) {
+	if format != FormatText {
+		b, err := json.Marshal(notification)
+		return b, "application/json", err
+	}
+	var buffer bytes.Buffer
+	for _, e := range notification.Events {
+		buffer.WriteString(e.Action)
+		buffer.WriteString("\t")
+		buffer.WriteString(e.Path)
+		buffer.WriteString("\t")
+		buffer.WriteString(e.Value)
+		buffer.WriteString("\n")
+	}
+	return buffer.Bytes(), "text/plain", nil
+}
+
+// Sign return the signature of the webhook request, "sha256=" + hex(hmac_sha256(secret, timestamp + "." + body)).
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify check the signature of the webhook request, and reject the request whose timestamp is not within maxAge from now,
+// receivers should also reject the repeated notification id within maxAge to prevent replay.
+func Verify(secret string, timestamp string, body []byte, signature string, maxAge time.Duration) error {
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp [%s].", timestamp)
+	}
+	age := time.Since(time.Unix(unix, 0))
+	if age > maxAge || age < -maxAge {
+		return fmt.Errorf("timestamp [%s] expired.", timestamp)
+	}
+	if !hmac.Equal([]byte(signature), []byte(Sign(secret, timestamp, body))) {
+		return errors.New("signature mismatch.")
+	}
+	return nil
+}
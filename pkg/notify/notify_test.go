@@ -0,0 +1,76 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package notify
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	. "openpitrix.io/metad/pkg/assert"
+	"openpitrix.io/metad/pkg/store"
+)
+
+func TestParseWebhook(t *testing.T) {
+	webhook, err := ParseWebhook("http://127.0.0.1:8080/hook?a=b")
+	Assert(t, err == nil, err)
+	Assert(t, "/" == webhook.Prefix)
+	Assert(t, "http://127.0.0.1:8080/hook?a=b" == webhook.URL)
+
+	webhook, err = ParseWebhook("/nodes/=https://127.0.0.1/hook")
+	Assert(t, err == nil, err)
+	Assert(t, "/nodes" == webhook.Prefix)
+	Assert(t, "https://127.0.0.1/hook" == webhook.URL)
+
+	_, err = ParseWebhook("/nodes")
+	Assert(t, err != nil)
+
+	_, err = ParseWebhook("127.0.0.1/hook")
+	Assert(t, err != nil)
+}
+
+func TestSignVerify(t *testing.T) {
+	body := []byte(`{"id":"1"}`)
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	signature := Sign("secret", now, body)
+	Assert(t, "sha256=" == signature[:7])
+	Assert(t, nil == Verify("secret", now, body, signature, time.Minute))
+	Assert(t, nil != Verify("secret2", now, body, signature, time.Minute))
+	Assert(t, nil != Verify("secret", now, []byte(`{"id":"2"}`), signature, time.Minute))
+
+	old := strconv.FormatInt(time.Now().Add(-10*time.Minute).Unix(), 10)
+	Assert(t, nil != Verify("secret", old, body, Sign("secret", old, body), 5*time.Minute))
+	Assert(t, nil != Verify("secret", "abc", body, signature, time.Minute))
+}
+
+func TestDeliverSigned(t *testing.T) {
+	verified := make(chan error, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		err := Verify("secret", req.Header.Get(HeaderTimestamp), body, req.Header.Get(HeaderSignature), 5*time.Minute)
+		if err == nil && req.Header.Get(HeaderNotificationID) == "" {
+			err = errors.New("missing notification id")
+		}
+		if err == nil && req.Header.Get(HeaderEventSchema) != "1" {
+			err = errors.New("missing event schema version")
+		}
+		verified <- err
+	}))
+	defer server.Close()
+
+	notifier := NewNotifier(nil, 0, time.Second, nil)
+	notification := NewNotification("/", []*store.Event{{Action: store.Update, Path: "/nodes/1", Value: "1"}})
+	err := notifier.Deliver(&Webhook{Name: "test", URL: server.URL, Secret: "secret"}, notification)
+	Assert(t, err == nil, err)
+	Assert(t, nil == <-verified)
+
+	err = notifier.Deliver(&Webhook{Name: "test", URL: server.URL}, notification)
+	Assert(t, err == nil, err)
+	Assert(t, nil != <-verified)
+}
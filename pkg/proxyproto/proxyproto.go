@@ -0,0 +1,197 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+// Package proxyproto implement the server side of the HAProxy PROXY protocol v1 and v2, the connections from the
+// TCP load balancers report the original client address as the remote address.
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// v1MaxLength is the max length of the v1 header line, including the CRLF.
+const v1MaxLength = 107
+
+var v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// ErrNoHeader is returned when the connection does not start with a PROXY protocol header.
+var ErrNoHeader = errors.New("missing proxy protocol header.")
+
+// Listener wrap the accepted connections to read the PROXY protocol header, the header is required from the trusted
+// connections (all if Trusted is nil), and the connections without a valid header are closed on read. The other
+// connections are returned as is.
+type Listener struct {
+	net.Listener
+	// Timeout of reading the header, 0 means no timeout.
+	Timeout time.Duration
+	Trusted func(addr net.Addr) bool
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if l.Trusted != nil && !l.Trusted(conn.RemoteAddr()) {
+		return conn, nil
+	}
+	return NewConn(conn, l.Timeout), nil
+}
+
+// Conn read the header on the first Read, RemoteAddr or LocalAddr, so Accept is not blocked by the slow clients.
+type Conn struct {
+	net.Conn
+	timeout    time.Duration
+	reader     *bufio.Reader
+	once       sync.Once
+	remoteAddr net.Addr
+	localAddr  net.Addr
+	err        error
+}
+
+func NewConn(conn net.Conn, timeout time.Duration) *Conn {
+	return &Conn{Conn: conn, timeout: timeout, reader: bufio.NewReader(conn)}
+}
+
+func (c *Conn) init() {
+	c.once.Do(func() {
+		if c.timeout > 0 {
+			c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+			defer c.Conn.SetReadDeadline(time.Time{})
+		}
+		c.remoteAddr, c.localAddr, c.err = ReadHeader(c.reader)
+	})
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+// RemoteAddr return the source address of the header, or the address of the connection if the header has no
+// address, such as the health check of the load balancer.
+func (c *Conn) RemoteAddr() net.Addr {
+	c.init()
+	if c.remoteAddr != nil {
+		return c.remoteAddr
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	c.init()
+	if c.localAddr != nil {
+		return c.localAddr
+	}
+	return c.Conn.LocalAddr()
+}
+
+// ReadHeader read the v1 or v2 header from r, and return the source and destination address, the addresses are nil
+// for the v1 UNKNOWN, the v2 LOCAL command and the unsupported address family.
+func ReadHeader(r *bufio.Reader) (src net.Addr, dst net.Addr, err error) {
+	prefix, err := r.Peek(5)
+	if err != nil {
+		return nil, nil, err
+	}
+	if string(prefix) == "PROXY" {
+		return readV1(r)
+	}
+	signature, err := r.Peek(len(v2Signature))
+	if err != nil || !bytes.Equal(signature, v2Signature) {
+		return nil, nil, ErrNoHeader
+	}
+	return readV2(r)
+}
+
+func readV1(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	var line []byte
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= v1MaxLength {
+			return nil, nil, errors.New("proxy protocol v1 header is too long.")
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, nil, err
+		}
+		line = append(line, b)
+	}
+	fields := strings.Split(strings.TrimSuffix(string(line), "\r\n"), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, nil, fmt.Errorf("invalid proxy protocol v1 header [%s].", strings.TrimSpace(string(line)))
+	}
+	src, err := v1Addr(fields[2], fields[4])
+	if err != nil {
+		return nil, nil, err
+	}
+	dst, err := v1Addr(fields[3], fields[5])
+	if err != nil {
+		return nil, nil, err
+	}
+	return src, dst, nil
+}
+
+func v1Addr(ip string, port string) (net.Addr, error) {
+	addr := &net.TCPAddr{IP: net.ParseIP(ip)}
+	if addr.IP == nil {
+		return nil, fmt.Errorf("invalid proxy protocol address [%s].", ip)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy protocol port [%s].", port)
+	}
+	addr.Port = int(p)
+	return addr, nil
+}
+
+func readV2(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, nil, err
+	}
+	if header[12]>>4 != 2 {
+		return nil, nil, fmt.Errorf("invalid proxy protocol version %d.", header[12]>>4)
+	}
+	body := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, nil, err
+	}
+	if command := header[12] & 0xf; command == 0x0 {
+		// LOCAL, such as the health check of the proxy itself.
+		return nil, nil, nil
+	} else if command != 0x1 {
+		return nil, nil, fmt.Errorf("invalid proxy protocol command %d.", command)
+	}
+	var ipLen int
+	switch header[13] >> 4 {
+	case 0x1:
+		ipLen = net.IPv4len
+	case 0x2:
+		ipLen = net.IPv6len
+	default:
+		// AF_UNSPEC or AF_UNIX
+		return nil, nil, nil
+	}
+	if len(body) < 2*ipLen+4 {
+		return nil, nil, errors.New("proxy protocol v2 address is too short.")
+	}
+	src := &net.TCPAddr{IP: net.IP(body[:ipLen]), Port: int(binary.BigEndian.Uint16(body[2*ipLen:]))}
+	dst := &net.TCPAddr{IP: net.IP(body[ipLen : 2*ipLen]), Port: int(binary.BigEndian.Uint16(body[2*ipLen+2:]))}
+	return src, dst, nil
+}
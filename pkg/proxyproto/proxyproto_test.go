@@ -0,0 +1,94 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	. "openpitrix.io/metad/pkg/assert"
+)
+
+func TestReadHeaderV1(t *testing.T) {
+	r := bufio.NewReader(bytes.NewBufferString("PROXY TCP4 192.168.1.1 10.0.0.1 56324 80\r\nGET / HTTP/1.1\r\n"))
+	src, dst, err := ReadHeader(r)
+	Assert(t, err == nil, err)
+	Assert(t, "192.168.1.1:56324" == src.String(), src)
+	Assert(t, "10.0.0.1:80" == dst.String(), dst)
+	rest, _ := ioutil.ReadAll(r)
+	Assert(t, "GET / HTTP/1.1\r\n" == string(rest))
+
+	src, dst, err = ReadHeader(bufio.NewReader(bytes.NewBufferString("PROXY UNKNOWN\r\n")))
+	Assert(t, err == nil && src == nil && dst == nil, err)
+
+	_, _, err = ReadHeader(bufio.NewReader(bytes.NewBufferString("PROXY TCP4 192.168.1.1\r\n")))
+	Assert(t, err != nil)
+	_, _, err = ReadHeader(bufio.NewReader(bytes.NewBufferString("GET / HTTP/1.1\r\n\r\n")))
+	Assert(t, err == ErrNoHeader, err)
+}
+
+func TestReadHeaderV2(t *testing.T) {
+	header := append([]byte{}, v2Signature...)
+	header = append(header, 0x21, 0x11, 0, 12)
+	header = append(header, 192, 168, 1, 1, 10, 0, 0, 1, 0xdc, 0x04, 0, 80)
+	r := bufio.NewReader(bytes.NewBuffer(append(header, []byte("GET")...)))
+	src, dst, err := ReadHeader(r)
+	Assert(t, err == nil, err)
+	Assert(t, "192.168.1.1:56324" == src.String(), src)
+	Assert(t, "10.0.0.1:80" == dst.String(), dst)
+	rest, _ := ioutil.ReadAll(r)
+	Assert(t, "GET" == string(rest))
+
+	// LOCAL command has no address.
+	local := append(append([]byte{}, v2Signature...), 0x20, 0x00, 0, 0)
+	src, dst, err = ReadHeader(bufio.NewReader(bytes.NewBuffer(local)))
+	Assert(t, err == nil && src == nil && dst == nil, err)
+
+	invalid := append(append([]byte{}, v2Signature...), 0x11, 0x11, 0, 0)
+	_, _, err = ReadHeader(bufio.NewReader(bytes.NewBuffer(invalid)))
+	Assert(t, err != nil)
+}
+
+func TestListener(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	Assert(t, err == nil, err)
+	listener := &Listener{Listener: l, Timeout: time.Second}
+	defer listener.Close()
+
+	go func() {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			return
+		}
+		conn.Write([]byte("PROXY TCP4 192.168.1.1 10.0.0.1 56324 80\r\nhello"))
+		conn.Close()
+	}()
+	conn, err := listener.Accept()
+	Assert(t, err == nil, err)
+	Assert(t, "192.168.1.1:56324" == conn.RemoteAddr().String(), conn.RemoteAddr())
+	body, _ := ioutil.ReadAll(conn)
+	Assert(t, "hello" == string(body))
+	conn.Close()
+
+	// the untrusted connections are returned as is.
+	listener.Trusted = func(addr net.Addr) bool { return false }
+	go func() {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			return
+		}
+		conn.Write([]byte("PROXY TCP4 192.168.1.1 10.0.0.1 56324 80\r\n"))
+		conn.Close()
+	}()
+	conn, err = listener.Accept()
+	Assert(t, err == nil, err)
+	host, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	Assert(t, "127.0.0.1" == host, conn.RemoteAddr())
+	conn.Close()
+}
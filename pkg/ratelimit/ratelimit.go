@@ -0,0 +1,123 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+// Package ratelimit limit the request rate by token buckets, a global bucket and a bucket per client.
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const cleanupInterval = time.Minute
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// refill add the tokens since last refill, the tokens never exceed burst.
+func (b *bucket) refill(now time.Time, rate float64, burst float64) {
+	if b.last.IsZero() {
+		b.tokens = burst
+	} else if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(burst, b.tokens+elapsed*rate)
+	}
+	b.last = now
+}
+
+// wait return the duration until the bucket has one token.
+func (b *bucket) wait(rate float64) time.Duration {
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / rate * float64(time.Second))
+}
+
+// Limiter is a token bucket rate limiter, the rate is requests per second, 0 means unlimited.
+type Limiter struct {
+	rate        float64
+	burst       float64
+	clientRate  float64
+	clientBurst float64
+	global      *bucket
+	clients     map[string]*bucket
+	lastCleanup time.Time
+	lock        sync.Mutex
+}
+
+func New(rate float64, burst int, clientRate float64, clientBurst int) *Limiter {
+	l := &Limiter{global: &bucket{}, clients: make(map[string]*bucket)}
+	l.SetLimits(rate, burst, clientRate, clientBurst)
+	return l
+}
+
+func defaultBurst(rate float64, burst int) float64 {
+	if burst > 0 {
+		return float64(burst)
+	}
+	return math.Max(1, math.Ceil(rate))
+}
+
+// SetLimits change the limits, burst <= 0 means same as the rate.
+func (l *Limiter) SetLimits(rate float64, burst int, clientRate float64, clientBurst int) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.rate = rate
+	l.burst = defaultBurst(rate, burst)
+	l.clientRate = clientRate
+	l.clientBurst = defaultBurst(clientRate, clientBurst)
+}
+
+// Allow take a token from the global bucket and the client's bucket,
+// if any of them is empty, no token is taken and return the duration to retry after.
+func (l *Limiter) Allow(client string) (bool, time.Duration) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := time.Now()
+	l.cleanup(now)
+
+	var retryAfter time.Duration
+	if l.rate > 0 {
+		l.global.refill(now, l.rate, l.burst)
+		retryAfter = l.global.wait(l.rate)
+	}
+	var clientBucket *bucket
+	if l.clientRate > 0 {
+		clientBucket = l.clients[client]
+		if clientBucket == nil {
+			clientBucket = &bucket{}
+			l.clients[client] = clientBucket
+		}
+		clientBucket.refill(now, l.clientRate, l.clientBurst)
+		if wait := clientBucket.wait(l.clientRate); wait > retryAfter {
+			retryAfter = wait
+		}
+	}
+	if retryAfter > 0 {
+		return false, retryAfter
+	}
+	if l.rate > 0 {
+		l.global.tokens--
+	}
+	if clientBucket != nil {
+		clientBucket.tokens--
+	}
+	return true, 0
+}
+
+// cleanup remove the client buckets which are full, they are same as new buckets.
+func (l *Limiter) cleanup(now time.Time) {
+	if now.Sub(l.lastCleanup) < cleanupInterval {
+		return
+	}
+	l.lastCleanup = now
+	for client, b := range l.clients {
+		b.refill(now, l.clientRate, l.clientBurst)
+		if b.tokens >= l.clientBurst {
+			delete(l.clients, client)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	. "openpitrix.io/metad/pkg/assert"
+)
+
+func TestLimiterClient(t *testing.T) {
+	l := New(0, 0, 10, 2)
+	for i := 0; i < 2; i++ {
+		ok, _ := l.Allow("192.168.1.1")
+		Assert(t, ok)
+	}
+	ok, retryAfter := l.Allow("192.168.1.1")
+	Assert(t, !ok)
+	Assert(t, retryAfter > 0 && retryAfter <= 100*time.Millisecond, retryAfter)
+
+	// other client is not limited.
+	ok, _ = l.Allow("192.168.1.2")
+	Assert(t, ok)
+
+	time.Sleep(retryAfter)
+	ok, _ = l.Allow("192.168.1.1")
+	Assert(t, ok)
+}
+
+func TestLimiterGlobal(t *testing.T) {
+	l := New(1, 1, 0, 0)
+	ok, _ := l.Allow("192.168.1.1")
+	Assert(t, ok)
+	ok, retryAfter := l.Allow("192.168.1.2")
+	Assert(t, !ok)
+	Assert(t, retryAfter > 900*time.Millisecond, retryAfter)
+
+	// unlimited
+	l.SetLimits(0, 0, 0, 0)
+	for i := 0; i < 100; i++ {
+		ok, _ = l.Allow("192.168.1.1")
+		Assert(t, ok)
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+// Package redact mask the data values under the sensitive prefixes, so they never appear in the logs, audit entries
+// or error messages. The prefixes are process wide, as the logs are.
+package redact
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+)
+
+// Mask is the replacement of the sensitive values.
+const Mask = "******"
+
+var (
+	lock     sync.RWMutex
+	prefixes []string
+)
+
+// SetPrefixes replace the sensitive data prefixes.
+func SetPrefixes(sensitive []string) {
+	cleaned := make([]string, 0, len(sensitive))
+	for _, prefix := range sensitive {
+		cleaned = append(cleaned, path.Join("/", prefix))
+	}
+	lock.Lock()
+	prefixes = cleaned
+	lock.Unlock()
+}
+
+// Enabled return whether any sensitive prefix is configured.
+func Enabled() bool {
+	lock.RLock()
+	defer lock.RUnlock()
+	return len(prefixes) > 0
+}
+
+// Sensitive return whether the data nodePath is a sensitive prefix or under it.
+func Sensitive(nodePath string) bool {
+	nodePath = path.Join("/", nodePath)
+	lock.RLock()
+	defer lock.RUnlock()
+	for _, prefix := range prefixes {
+		if prefix == "/" || nodePath == prefix || strings.HasPrefix(nodePath, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Value return Mask if nodePath is sensitive, otherwise the value.
+func Value(nodePath string, value string) string {
+	if Sensitive(nodePath) {
+		return Mask
+	}
+	return value
+}
+
+// Values return a copy of the flat values with the sensitive values masked, the keys are relative to nodePath.
+func Values(nodePath string, values map[string]string) map[string]string {
+	if !Enabled() {
+		return values
+	}
+	result := make(map[string]string, len(values))
+	for k, v := range values {
+		result[k] = Value(path.Join(nodePath, k), v)
+	}
+	return result
+}
+
+// Tree return a copy of the value at nodePath with the sensitive leaves masked, the value is a string or a tree of
+// maps and slices as the data.
+func Tree(nodePath string, value interface{}) interface{} {
+	if !Enabled() {
+		return value
+	}
+	return tree(path.Join("/", nodePath), value)
+}
+
+func tree(nodePath string, value interface{}) interface{} {
+	switch t := value.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(t))
+		for k, v := range t {
+			result[k] = tree(path.Join(nodePath, k), v)
+		}
+		return result
+	case map[string]string:
+		result := make(map[string]interface{}, len(t))
+		for k, v := range t {
+			result[k] = tree(path.Join(nodePath, k), v)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(t))
+		for i, v := range t {
+			result[i] = tree(path.Join(nodePath, fmt.Sprintf("%d", i)), v)
+		}
+		return result
+	case nil:
+		return nil
+	default:
+		if Sensitive(nodePath) {
+			return Mask
+		}
+		return value
+	}
+}
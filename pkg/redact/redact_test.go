@@ -0,0 +1,40 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package redact
+
+import (
+	"reflect"
+	"testing"
+
+	. "openpitrix.io/metad/pkg/assert"
+)
+
+func TestRedact(t *testing.T) {
+	SetPrefixes(nil)
+	Assert(t, !Enabled())
+	Assert(t, "pw" == Value("/secrets/db", "pw"))
+
+	SetPrefixes([]string{"secrets", "/nodes/1/password"})
+	Assert(t, Enabled())
+	Assert(t, Mask == Value("/secrets/db", "pw"))
+	Assert(t, Mask == Value("secrets", "pw"))
+	Assert(t, "v" == Value("/secrets1", "v"))
+	Assert(t, reflect.DeepEqual(map[string]string{"/db": Mask, "/1": Mask}, Values("/secrets", map[string]string{"/db": "pw", "/1": "x"})))
+	Assert(t, reflect.DeepEqual(map[string]string{"/secrets/db": Mask, "/nodes/1/ip": "1.1.1.1"},
+		Values("/", map[string]string{"/secrets/db": "pw", "/nodes/1/ip": "1.1.1.1"})))
+
+	value := map[string]interface{}{
+		"secrets": map[string]interface{}{"db": "pw", "list": []interface{}{"a", 1}},
+		"nodes":   map[string]interface{}{"1": map[string]interface{}{"ip": "1.1.1.1", "password": "pw"}},
+	}
+	Assert(t, reflect.DeepEqual(map[string]interface{}{
+		"secrets": map[string]interface{}{"db": Mask, "list": []interface{}{Mask, Mask}},
+		"nodes":   map[string]interface{}{"1": map[string]interface{}{"ip": "1.1.1.1", "password": Mask}},
+	}, Tree("/", value)), Tree("/", value))
+	Assert(t, Mask == Tree("/nodes/1/password", "pw"))
+	// the value is not changed.
+	Assert(t, "pw" == value["secrets"].(map[string]interface{})["db"])
+	SetPrefixes(nil)
+}
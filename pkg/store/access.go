@@ -100,6 +100,8 @@ type AccessStore interface {
 	Put(host string, rules []AccessRule)
 	Puts(rules map[string][]AccessRule)
 	Delete(host string)
+	// Version increase on every change.
+	Version() int64
 }
 
 func NewAccessStore() AccessStore {
@@ -107,13 +109,15 @@ func NewAccessStore() AccessStore {
 }
 
 type accessStore struct {
-	m    map[string]AccessTree
-	lock sync.RWMutex
+	m       map[string]AccessTree
+	lock    sync.RWMutex
+	version int64
 }
 
 func (s *accessStore) Delete(host string) {
 	s.lock.Lock()
 	delete(s.m, host)
+	s.version++
 	s.lock.Unlock()
 }
 
@@ -126,6 +130,7 @@ func (s *accessStore) Get(host string) AccessTree {
 func (s *accessStore) Put(host string, rules []AccessRule) {
 	s.lock.Lock()
 	s.m[host] = NewAccessTree(rules)
+	s.version++
 	s.lock.Unlock()
 }
 
@@ -134,9 +139,16 @@ func (s *accessStore) Puts(rules map[string][]AccessRule) {
 	for k, v := range rules {
 		s.m[k] = NewAccessTree(v)
 	}
+	s.version++
 	s.lock.Unlock()
 }
 
+func (s *accessStore) Version() int64 {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.version
+}
+
 func (s *accessStore) GetAccessRule(hosts []string) map[string][]AccessRule {
 	s.lock.RLock()
 	defer s.lock.RUnlock()
@@ -0,0 +1,119 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package store
+
+import (
+	"sync"
+)
+
+// delivery is an event of the node's watchers, or of the watcher only if set, such as the flushed batch event.
+// The node is referenced until delivered.
+type delivery struct {
+	node    *node
+	watcher *watcher
+	event   *Event
+}
+
+// target return the node of the watchers.
+func (d delivery) target() *node {
+	if d.watcher != nil {
+		return d.watcher.node
+	}
+	return d.node
+}
+
+// deliver notify the watchers, the watchers removed after the event queued are skipped.
+func (d delivery) deliver() {
+	n := d.target()
+	n.watcherLock.RLock()
+	if d.watcher != nil {
+		if !d.watcher.removed {
+			d.watcher.notify(d.event)
+		}
+	} else if n.watchers != nil {
+		for e := n.watchers.Front(); e != nil; e = e.Next() {
+			e.Value.(*watcher).notify(d.event)
+		}
+	}
+	n.watcherLock.RUnlock()
+	n.unref()
+}
+
+// dispatchBacklog is the number of the events queued but not delivered, beyond which the writers wait.
+const dispatchBacklog = 10000
+
+// dispatcher deliver the events of the store in its own goroutine, so the writers only queue the events under the
+// subtree lock instead of pushing into every watcher channel, and the events queued by the concurrent writers are
+// delivered in one batch. The events are delivered in the queued order, the order of the writes, even across the top
+// level subtrees, so the watchers of the root see the changes in order. A slow watcher with the Block policy delays
+// the delivery of all the events until the backlog is full, then the writers wait.
+type dispatcher struct {
+	lock      sync.Mutex
+	cond      *sync.Cond
+	queue     []delivery
+	queued    int64
+	delivered int64
+	closed    bool
+}
+
+func newDispatcher() *dispatcher {
+	d := &dispatcher{}
+	d.cond = sync.NewCond(&d.lock)
+	go d.loop()
+	return d
+}
+
+func (d *dispatcher) add(de delivery) {
+	d.lock.Lock()
+	if d.closed {
+		d.lock.Unlock()
+		de.target().unref()
+		return
+	}
+	d.queue = append(d.queue, de)
+	d.queued++
+	d.lock.Unlock()
+	d.cond.Broadcast()
+}
+
+func (d *dispatcher) loop() {
+	var batch []delivery
+	d.lock.Lock()
+	for {
+		for len(d.queue) == 0 && !d.closed {
+			d.cond.Wait()
+		}
+		if len(d.queue) == 0 {
+			d.lock.Unlock()
+			return
+		}
+		batch, d.queue = d.queue, batch[:0]
+		d.lock.Unlock()
+		for i, de := range batch {
+			de.deliver()
+			batch[i] = delivery{}
+		}
+		d.lock.Lock()
+		d.delivered += int64(len(batch))
+		d.cond.Broadcast()
+	}
+}
+
+// throttle return when the backlog is not full, or the dispatcher closed.
+func (d *dispatcher) throttle() {
+	d.lock.Lock()
+	for d.queued-d.delivered > dispatchBacklog && !d.closed {
+		d.cond.Wait()
+	}
+	d.lock.Unlock()
+}
+
+// close stop the dispatcher after the queued events delivered, the events queued later are dropped.
+func (d *dispatcher) close() {
+	d.lock.Lock()
+	d.closed = true
+	d.lock.Unlock()
+	d.cond.Broadcast()
+}
@@ -0,0 +1,18 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package store
+
+import "time"
+
+// EndpointStatus is the health of a backend endpoint, Failures is the count of the consecutive failed checks.
+type EndpointStatus struct {
+	Endpoint  string    `json:"endpoint"`
+	Healthy   bool      `json:"healthy"`
+	Leader    bool      `json:"leader,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	Failures  int       `json:"failures"`
+	LatencyMs int64     `json:"latency_ms"`
+	CheckedAt time.Time `json:"checked_at"`
+}
@@ -0,0 +1,141 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package store
+
+import (
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// storeIndex is the inverted index of the leaves whose name is one of the index keys, maintained on the leaf
+// changes, see node.Notify.
+type storeIndex struct {
+	// keys is the set of the indexed leaf names, map[string]bool, never modified after stored.
+	keys atomic.Value
+	// values is the paths of the leaves by key and value.
+	values map[string]map[string]map[string]bool
+	// paths is the indexed value of the leaf paths.
+	paths map[string]string
+	lock  sync.Mutex
+}
+
+func (idx *storeIndex) indexed(name string) bool {
+	keys, _ := idx.keys.Load().(map[string]bool)
+	return keys[name]
+}
+
+func (idx *storeIndex) add(key string, nodePath string, value string) {
+	if old, ok := idx.paths[nodePath]; ok {
+		if old == value {
+			return
+		}
+		idx.remove(key, nodePath)
+	}
+	byValue, ok := idx.values[key]
+	if !ok {
+		byValue = make(map[string]map[string]bool)
+		idx.values[key] = byValue
+	}
+	paths, ok := byValue[value]
+	if !ok {
+		paths = make(map[string]bool)
+		byValue[value] = paths
+	}
+	paths[nodePath] = true
+	idx.paths[nodePath] = value
+}
+
+func (idx *storeIndex) remove(key string, nodePath string) {
+	old, ok := idx.paths[nodePath]
+	if !ok {
+		return
+	}
+	delete(idx.paths, nodePath)
+	paths := idx.values[key][old]
+	delete(paths, nodePath)
+	if len(paths) == 0 {
+		delete(idx.values[key], old)
+	}
+}
+
+// indexChange update the index by the change of the node.
+func (s *store) indexChange(action string, n *node) {
+	if n.parent == nil || !s.index.indexed(n.Name) {
+		return
+	}
+	nodePath := n.Path()
+	s.index.lock.Lock()
+	defer s.index.lock.Unlock()
+	if action == Update && !n.IsDir() {
+		s.index.add(n.Name, nodePath, n.Value)
+	} else {
+		s.index.remove(n.Name, nodePath)
+	}
+}
+
+// SetIndexKeys replace the indexed leaf names, and rebuild the index from the whole tree.
+func (s *store) SetIndexKeys(keys []string) {
+	s.lockWorld()
+	defer s.unlockWorld()
+	set := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if key != "" {
+			set[key] = true
+		}
+	}
+	s.index.keys.Store(set)
+	s.index.lock.Lock()
+	defer s.index.lock.Unlock()
+	s.index.values = make(map[string]map[string]map[string]bool)
+	s.index.paths = make(map[string]string)
+	if len(set) == 0 {
+		return
+	}
+	var walk func(n *node, nodePath string)
+	walk = func(n *node, nodePath string) {
+		for name, child := range n.Children {
+			childPath := path.Join(nodePath, name)
+			if child.IsDir() {
+				walk(child, childPath)
+			} else if set[name] {
+				s.index.add(name, childPath, child.Value)
+			}
+		}
+	}
+	walk(s.Root, "/")
+}
+
+// IndexKeys return the indexed leaf names in order.
+func (s *store) IndexKeys() []string {
+	keys, _ := s.index.keys.Load().(map[string]bool)
+	result := make([]string, 0, len(keys))
+	for key := range keys {
+		result = append(result, key)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// Find return the paths under prefix of the leaves named key with the value in order, and false if the key
+// is not indexed.
+func (s *store) Find(key string, value string, prefix string) ([]string, bool) {
+	if !s.index.indexed(key) {
+		return nil, false
+	}
+	prefix = path.Clean(path.Join("/", prefix))
+	s.index.lock.Lock()
+	defer s.index.lock.Unlock()
+	result := []string{}
+	for p := range s.index.values[key][value] {
+		if prefix == "/" || p == prefix || strings.HasPrefix(p, prefix+"/") {
+			result = append(result, p)
+		}
+	}
+	sort.Strings(result)
+	return result, true
+}
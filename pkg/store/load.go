@@ -0,0 +1,167 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package store
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Load build the tree of the flat values in one pass if the store has no leaf, such as the initial sync of millions
+// of backend keys, return false without change otherwise, and the caller should put the values instead.
+// The keys are sorted, so every dir is created once and reused by the following keys without walking from root, and
+// the nodes are materialized (created order, stamps, usage and index) after the tree built. No event is delivered but
+// a Resync event to every watcher, which should read the watched node again.
+func (s *store) Load(values map[string]string) bool {
+	defer s.dispatcher.throttle()
+	s.lockWorld()
+	defer s.unlockWorld()
+	if hasLeaf(s.Root) {
+		return false
+	}
+	if !s.allowPut("/", values) {
+		return true
+	}
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	version := atomic.AddInt64((*int64)(&s.version), int64(len(values)))
+
+	// dirs is the dirs of the previous key, the sorted keys under a dir are contiguous.
+	var dirs []*node
+	for _, key := range keys {
+		d, rest, depth := s.Root, strings.TrimPrefix(key, "/"), 0
+		for i := strings.IndexByte(rest, '/'); i >= 0; i = strings.IndexByte(rest, '/') {
+			name := rest[:i]
+			rest = rest[i+1:]
+			// skip empty node name.
+			if name == "" {
+				continue
+			}
+			if depth < len(dirs) && dirs[depth].Name == name && dirs[depth].IsDir() {
+				d = dirs[depth]
+			} else {
+				dirs = append(dirs[:depth], s.loadDir(d, name))
+				d = dirs[depth]
+			}
+			depth++
+		}
+		if rest == "" {
+			continue
+		}
+		if n, ok := d.Children[rest]; ok {
+			// the empty dir kept for the watchers become leaf as Write.
+			n.Value = values[key]
+			if n.ChildrenCount() == 0 {
+				n.Children = nil
+			}
+			continue
+		}
+		d.Children[rest] = &node{Name: rest, parent: d, Value: values[key], store: s}
+	}
+
+	indexKeys, _ := s.index.keys.Load().(map[string]bool)
+	if len(indexKeys) > 0 {
+		s.index.lock.Lock()
+		defer s.index.lock.Unlock()
+	}
+	l := &loader{store: s, version: version, now: time.Now().Unix(), indexKeys: indexKeys}
+	l.materialize(s.Root, "/", nil)
+	return true
+}
+
+// loadDir return the child dir of the parent, the leaf become dir as put, and the empty dirs kept for the watchers
+// are reused.
+func (s *store) loadDir(parent *node, name string) *node {
+	n, ok := parent.Children[name]
+	if !ok {
+		n = &node{Name: name, parent: parent, Children: make(map[string]*node), store: s}
+		parent.Children[name] = n
+		return n
+	}
+	if n.Children == nil {
+		n.Children = make(map[string]*node)
+	}
+	return n
+}
+
+func hasLeaf(n *node) bool {
+	if !n.IsDir() {
+		return true
+	}
+	for _, child := range n.Children {
+		if hasLeaf(child) {
+			return true
+		}
+	}
+	return false
+}
+
+// loader materialize the loaded tree.
+type loader struct {
+	store     *store
+	version   int64
+	now       int64
+	indexKeys map[string]bool
+}
+
+type loadName struct {
+	name    string
+	number  int
+	numeric bool
+}
+
+// materialize set the created order of the new children in util.NaturalLess order as PutBulk, the stamps, and the
+// usage of the top level nodes, index the leaves and notify the watchers to resync.
+func (l *loader) materialize(n *node, nodePath string, top *node) {
+	n.frozen.Store((*frozenNode)(nil))
+	n.modified.set(l.version, l.now)
+	if n.watchers != nil && n.watchers.Len() > 0 {
+		n.ref()
+		l.store.dispatch(delivery{node: n, event: newEvent(Resync, "/", "")})
+	}
+	names := make([]loadName, 0, len(n.Children))
+	for name := range n.Children {
+		number, err := strconv.Atoi(name)
+		names = append(names, loadName{name: name, number: number, numeric: err == nil})
+	}
+	// the names are compared as util.NaturalLess, the numbers are parsed once.
+	sort.Slice(names, func(i, j int) bool {
+		a, b := names[i], names[j]
+		if a.numeric && b.numeric && a.number != b.number {
+			return a.number < b.number
+		}
+		return a.name < b.name
+	})
+	for _, ln := range names {
+		name := ln.name
+		child := n.Children[name]
+		if child.createdIndex == 0 {
+			child.createdIndex = l.store.nextCreatedIndex()
+			child.created.set(l.version, l.now)
+		}
+		childTop := top
+		if n.IsRoot() {
+			childTop = child
+		}
+		childPath := strings.TrimSuffix(nodePath, "/") + "/" + name
+		if child.IsDir() {
+			l.materialize(child, childPath, childTop)
+			continue
+		}
+		child.frozen.Store((*frozenNode)(nil))
+		child.modified.set(l.version, l.now)
+		atomic.AddInt64(&childTop.usageKeys, 1)
+		atomic.AddInt64(&childTop.usageBytes, int64(len(child.Value)))
+		if l.indexKeys[name] {
+			l.store.index.add(name, childPath, child.Value)
+		}
+	}
+}
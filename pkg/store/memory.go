@@ -0,0 +1,113 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package store
+
+import (
+	"container/heap"
+	"path"
+	"sort"
+)
+
+// nodeOverhead is the approximate bytes of a node besides its name and value, such as the node struct and its entry in
+// the children map of the parent.
+const nodeOverhead = 200
+
+// MemoryUsage is the approximate memory of a subtree, the bytes are the names, values and kept history values of the
+// nodes plus nodeOverhead for every node.
+type MemoryUsage struct {
+	Prefix string `json:"prefix"`
+	Bytes  int64  `json:"bytes"`
+	Nodes  int64  `json:"nodes"`
+}
+
+// memoryHeap is the min heap of the heaviest subtrees found so far.
+type memoryHeap []*MemoryUsage
+
+func (h memoryHeap) Len() int { return len(h) }
+func (h memoryHeap) Less(i, j int) bool {
+	if h[i].Bytes != h[j].Bytes {
+		return h[i].Bytes < h[j].Bytes
+	}
+	return h[i].Prefix > h[j].Prefix
+}
+func (h memoryHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *memoryHeap) Push(x interface{}) { *h = append(*h, x.(*MemoryUsage)) }
+func (h *memoryHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// memoryUsage return the memory of the subtree, and collect the subtrees depth levels below.
+func (n *node) memoryUsage(nodePath string, depth int, collect func(usage *MemoryUsage)) *MemoryUsage {
+	usage := &MemoryUsage{Prefix: nodePath, Bytes: int64(nodeOverhead + len(n.Name) + len(n.Value)), Nodes: 1}
+	for _, h := range n.history {
+		usage.Bytes += int64(len(h.Value))
+	}
+	for name, child := range n.Children {
+		c := child.memoryUsage(path.Join(nodePath, name), depth-1, collect)
+		usage.Bytes += c.Bytes
+		usage.Nodes += c.Nodes
+	}
+	if depth == 0 {
+		collect(usage)
+	}
+	return usage
+}
+
+// MemoryUsage return the approximate memory of the subtree at nodePath, nil if not exist, and the heaviest limit
+// subtrees depth levels below it in bytes order. The top level subtrees are walked one by one, so the writes of other
+// subtrees are not blocked.
+func (s *store) MemoryUsage(nodePath string, depth int, limit int) (*MemoryUsage, []*MemoryUsage) {
+	nodePath = path.Clean(path.Join("/", nodePath))
+	top := &memoryHeap{}
+	collect := func(usage *MemoryUsage) {
+		if limit <= 0 {
+			return
+		}
+		heap.Push(top, usage)
+		if top.Len() > limit {
+			heap.Pop(top)
+		}
+	}
+	var total *MemoryUsage
+	if nodePath == "/" {
+		s.worldLock.RLock()
+		names := make([]string, 0, len(s.Root.Children))
+		for name := range s.Root.Children {
+			names = append(names, name)
+		}
+		s.worldLock.RUnlock()
+		total = &MemoryUsage{Prefix: "/", Bytes: nodeOverhead, Nodes: 1}
+		for _, name := range names {
+			unlock := s.rlockSubtree(name)
+			if child := s.Root.GetChild(name); child != nil {
+				usage := child.memoryUsage("/"+name, depth-1, collect)
+				total.Bytes += usage.Bytes
+				total.Nodes += usage.Nodes
+			}
+			unlock()
+		}
+		if depth == 0 {
+			collect(total)
+		}
+	} else {
+		unlock := s.rlockSubtree(topName(nodePath))
+		if n := s.internalGet(nodePath); n != nil {
+			total = n.memoryUsage(nodePath, depth, collect)
+		}
+		unlock()
+	}
+	result := make([]*MemoryUsage, top.Len())
+	copy(result, *top)
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Bytes != result[j].Bytes {
+			return result[i].Bytes > result[j].Bytes
+		}
+		return result[i].Prefix < result[j].Prefix
+	})
+	return total, result
+}
@@ -0,0 +1,134 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package store
+
+import (
+	"path"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultHistorySize is the default number of the previous values kept by every leaf.
+const DefaultHistorySize = 10
+
+// NodeMeta is the change metadata of a node and its children. The versions are the store versions of the changes,
+// the times are unix seconds. The modified of a dir is the last change of the leaves under it.
+type NodeMeta struct {
+	CreatedVersion  int64                `json:"created_version"`
+	CreatedAt       int64                `json:"created_at"`
+	ModifiedVersion int64                `json:"modified_version"`
+	ModifiedAt      int64                `json:"modified_at"`
+	Value           *string              `json:"value,omitempty"`
+	History         []*HistoryEntry      `json:"history,omitempty"`
+	Children        map[string]*NodeMeta `json:"children,omitempty"`
+}
+
+// HistoryEntry is a previous value of the leaf, and when it was set.
+type HistoryEntry struct {
+	Value           string `json:"value"`
+	ModifiedVersion int64  `json:"modified_version"`
+	ModifiedAt      int64  `json:"modified_at"`
+}
+
+// nodeStamp is the version and time of the creation or the last change of the node, accessed atomically as the root
+// is changed by the writes of all subtrees.
+type nodeStamp struct {
+	version int64
+	at      int64
+}
+
+func (s *nodeStamp) set(version int64, at int64) {
+	atomic.StoreInt64(&s.version, version)
+	atomic.StoreInt64(&s.at, at)
+}
+
+func (s *nodeStamp) get() (int64, int64) {
+	return atomic.LoadInt64(&s.version), atomic.LoadInt64(&s.at)
+}
+
+// stampCreated set the created and modified stamp of the new node.
+func (n *node) stampCreated() {
+	version, now := atomic.LoadInt64((*int64)(&n.store.version)), time.Now().Unix()
+	n.created.set(version, now)
+	n.modified.set(version, now)
+}
+
+// pushHistory keep the current value of the leaf before it is replaced, at most historySize values newest first.
+func (n *node) pushHistory() {
+	size := int(atomic.LoadInt32(&n.store.historySize))
+	if size <= 0 {
+		n.history = nil
+		return
+	}
+	version, at := n.modified.get()
+	entry := &HistoryEntry{Value: n.Value, ModifiedVersion: version, ModifiedAt: at}
+	if len(n.history) >= size {
+		n.history = n.history[:size-1]
+	}
+	n.history = append([]*HistoryEntry{entry}, n.history...)
+}
+
+// stampChange set the modified stamp of the changed leaf and its ancestors, the deleted leaf is only its ancestors.
+func (s *store) stampChange(action string, n *node) {
+	version, now := atomic.LoadInt64((*int64)(&s.version)), time.Now().Unix()
+	if action != Delete {
+		n.modified.set(version, now)
+	}
+	for p := n.parent; p != nil; p = p.parent {
+		p.modified.set(version, now)
+	}
+}
+
+// SetHistorySize set the number of the previous values kept by every leaf, 0 means not keep.
+func (s *store) SetHistorySize(size int) {
+	atomic.StoreInt32(&s.historySize, int32(size))
+}
+
+func (n *node) meta() *NodeMeta {
+	m := &NodeMeta{}
+	m.CreatedVersion, m.CreatedAt = n.created.get()
+	m.ModifiedVersion, m.ModifiedAt = n.modified.get()
+	if !n.IsDir() {
+		value := n.Value
+		m.Value = &value
+		m.History = append([]*HistoryEntry{}, n.history...)
+		return m
+	}
+	m.Children = make(map[string]*NodeMeta, len(n.Children))
+	for name, child := range n.Children {
+		// skip the empty dir, which is treated as not exist.
+		if child.IsDir() && child.ChildrenCount() == 0 {
+			continue
+		}
+		m.Children[name] = child.meta()
+	}
+	return m
+}
+
+// Meta return the change metadata of the node at nodePath and its children, nil if not exist.
+func (s *store) Meta(nodePath string) *NodeMeta {
+	nodePath = path.Clean(path.Join("/", nodePath))
+	unlock := s.rlockSubtree(topName(nodePath))
+	defer unlock()
+	n := s.internalGet(nodePath)
+	if n == nil || (n.IsDir() && n.ChildrenCount() == 0 && !n.IsRoot()) {
+		return nil
+	}
+	return n.meta()
+}
+
+// Revision return the modified version of the node at nodePath, which is the version of the last change of the node
+// or under it, 0 if not exist.
+func (s *store) Revision(nodePath string) int64 {
+	nodePath = path.Clean(path.Join("/", nodePath))
+	unlock := s.rlockSubtree(topName(nodePath))
+	defer unlock()
+	n := s.internalGet(nodePath)
+	if n == nil || (n.IsDir() && n.ChildrenCount() == 0 && !n.IsRoot()) {
+		return 0
+	}
+	version, _ := n.modified.get()
+	return version
+}
@@ -14,6 +14,7 @@ import (
 	"errors"
 	"path"
 	"sync"
+	"sync/atomic"
 )
 
 type node struct {
@@ -29,6 +30,23 @@ type node struct {
 	store *store // A reference to the store this node is attached to.
 
 	watcherLock sync.RWMutex
+
+	// refs is the count of the watchers and the pending event deliveries holding the node, see ref.
+	refs int32
+
+	frozen atomic.Value // *frozenNode, see freeze.
+
+	// the keys and bytes of the leaves in the subtree, only accounted on the top level nodes, see accountUsage.
+	usageKeys  int64
+	usageBytes int64
+
+	// createdIndex is the creation order of the node in the store, see CreatedOrder.
+	createdIndex int64
+
+	// created and modified are the change stamps of the node, history is the previous values of the leaf, see Meta.
+	created  nodeStamp
+	modified nodeStamp
+	history  []*HistoryEntry
 }
 
 func newKV(store *store, nodeName string, value string, parent *node) *node {
@@ -44,6 +62,8 @@ func newKV(store *store, nodeName string, value string, parent *node) *node {
 		store:       store,
 		watcherLock: sync.RWMutex{},
 	}
+	n.createdIndex = store.nextCreatedIndex()
+	n.stampCreated()
 	parent.Add(n)
 	n.Notify(Update)
 	return n
@@ -60,6 +80,10 @@ func newDir(store *store, nodeName string, parent *node) *node {
 		Children: make(map[string]*node),
 		store:    store,
 	}
+	if store != nil {
+		n.createdIndex = store.nextCreatedIndex()
+		n.stampCreated()
+	}
 	if parent != nil {
 		parent.Add(n)
 	}
@@ -94,7 +118,9 @@ func (n *node) IsDir() bool {
 // AsDir convert node to dir
 func (n *node) AsDir() {
 	if !n.IsDir() {
+		n.accountUsage(-1, -int64(len(n.Value)))
 		n.Children = make(map[string]*node)
+		n.unfreeze()
 	}
 	// treat convert leaf to dir as a delete.
 	n.Notify(Delete)
@@ -103,6 +129,8 @@ func (n *node) AsDir() {
 func (n *node) AsLeaf() {
 	if n.IsDir() {
 		n.Children = nil
+		n.unfreeze()
+		n.accountUsage(1, int64(len(n.Value)))
 	}
 	// treat convert dir to leaf as a update.
 	n.Notify(Update)
@@ -120,7 +148,16 @@ func (n *node) Write(value string) {
 	}
 
 	oldValue := n.Value
+	if oldValue != value && !n.IsDir() {
+		n.pushHistory()
+	}
 	n.Value = value
+	if oldValue != value {
+		n.unfreeze()
+		if !n.IsDir() {
+			n.accountUsage(0, int64(len(value)-len(oldValue)))
+		}
+	}
 	if n.IsDir() {
 		// if dir is empty, and set a text value ,so convert to leaf
 		if n.ChildrenCount() == 0 {
@@ -180,20 +217,38 @@ func (n *node) Add(child *node) {
 		n.AsDir()
 	}
 	n.Children[child.Name] = child
+	n.unfreeze()
+	if !child.IsDir() {
+		child.accountUsage(1, int64(len(child.Value)))
+	}
 }
 
 // Remove function remove the node.
 func (n *node) Remove() bool {
 
 	if !n.IsDir() {
-		// do not remove node has watcher
-		if n.HasWatcher() {
+		// do not remove node referenced
+		if n.referenced() {
+			n.accountUsage(0, -int64(len(n.Value)))
 			n.Value = ""
+			n.unfreeze()
 			n.AsDir()
 			return true
 		}
 		if n.parent != nil && n.parent.Children[n.Name] == n {
+			if !n.detachable() {
+				// keep as empty dir, which is treated as not exist, and clean it later.
+				n.accountUsage(-1, -int64(len(n.Value)))
+				n.Children = make(map[string]*node)
+				n.Notify(Delete)
+				n.Value = ""
+				n.unfreeze()
+				n.store.Clean(n.Path())
+				return true
+			}
+			n.accountUsage(-1, -int64(len(n.Value)))
 			delete(n.parent.Children, n.Name)
+			n.parent.unfreeze()
 			// only leaf node trigger delete event.
 			n.Notify(Delete)
 			n.parent.Clean()
@@ -203,21 +258,65 @@ func (n *node) Remove() bool {
 	}
 
 	// clear value
-	n.Value = ""
+	if n.Value != "" {
+		n.Value = ""
+		n.unfreeze()
+	}
 
 	// retry to remove all children
 	for _, node := range n.Children {
 		node.Remove()
 	}
 
-	if n.parent != nil && n.parent.Children[n.Name] == n && n.ChildrenCount() == 0 && !n.HasWatcher() {
+	if n.parent != nil && n.parent.Children[n.Name] == n && n.ChildrenCount() == 0 && !n.referenced() {
+		if !n.detachable() {
+			n.store.Clean(n.Path())
+			return true
+		}
 		delete(n.parent.Children, n.Name)
+		n.parent.unfreeze()
 		n.parent.Clean()
 		return true
 	}
 	return false
 }
 
+// detachable check whether the node can be removed from its parent now,
+// the top level nodes are only removed when the world is locked, see store.lockSubtree.
+func (n *node) detachable() bool {
+	return !n.parent.IsRoot() || n.store.exclusive
+}
+
+// ref hold the node, the referenced node is kept in the tree as an empty dir when deleted, so the watcher or the
+// pending event delivery never hold a node detached by a concurrent delete or clean.
+func (n *node) ref() {
+	atomic.AddInt32(&n.refs, 1)
+}
+
+// unref release the node, the last release clean the node if it is an empty dir.
+func (n *node) unref() {
+	if atomic.AddInt32(&n.refs, -1) == 0 {
+		n.store.Clean(n.Path())
+	}
+}
+
+func (n *node) referenced() bool {
+	return atomic.LoadInt32(&n.refs) > 0
+}
+
+// accountUsage add the delta of leaves' keys and bytes to the top level node of the subtree.
+func (n *node) accountUsage(keys int64, bytes int64) {
+	if n.parent == nil {
+		return
+	}
+	top := n
+	for !top.parent.IsRoot() {
+		top = top.parent
+	}
+	atomic.AddInt64(&top.usageKeys, keys)
+	atomic.AddInt64(&top.usageBytes, bytes)
+}
+
 // Clean empty dir
 func (n *node) Clean() bool {
 	if !n.IsDir() {
@@ -226,7 +325,7 @@ func (n *node) Clean() bool {
 	// if children is empty, try to remove  or covert to leaf node .
 	if n.ChildrenCount() == 0 {
 		if n.Value == "" {
-			if !n.HasWatcher() {
+			if !n.referenced() {
 				return n.Remove()
 			}
 		} else {
@@ -256,44 +355,50 @@ func (n *node) GetValue() interface{} {
 	}
 }
 
-func (n *node) internalNotify(action string, eventNode *node) {
+func (n *node) internalNotify(action string, eventNode *node, actor string) {
 
 	if n.HasWatcher() {
+		// resolve actor only once for the event.
+		if actor == "" {
+			actor = n.store.actorOf(action, eventNode.Path(), eventNode.Value)
+		}
 		event := newEvent(action, eventNode.RelativePath(n), eventNode.Value)
-		n.watcherLock.RLock()
-		for e := n.watchers.Front(); e != nil; e = e.Next() {
-			w := e.Value.(Watcher)
-			select {
-			case w.EventChan() <- event:
-				break
-			default:
-				//avoid block, just drop
-				//TODO use a more grace method.
-				println("drop event:", event.Path, event.Action, event.Value)
+		event.Actor = actor
+		// the batch collect the events by watcher, otherwise the event is delivered by the dispatcher.
+		if batch := n.store.batchOf(eventNode); batch != nil {
+			n.watcherLock.RLock()
+			for e := n.watchers.Front(); e != nil; e = e.Next() {
+				batch.add(e.Value.(*watcher), event)
 			}
+			n.watcherLock.RUnlock()
+		} else {
+			n.ref()
+			n.store.dispatch(delivery{node: n, event: event})
 		}
-		n.watcherLock.RUnlock()
 	}
 
 	// pop up event.
 	if n.parent != nil {
-		n.parent.internalNotify(action, eventNode)
+		n.parent.internalNotify(action, eventNode, actor)
 	}
 }
 
 func (n *node) Notify(action string) {
-	n.internalNotify(action, n)
+	n.store.indexChange(action, n)
+	n.store.stampChange(action, n)
+	n.internalNotify(action, n, "")
 }
 
-func (n *node) Watch(bufLen int) Watcher {
+func (n *node) Watch(bufLen int, policy OverflowPolicy) Watcher {
 	n.watcherLock.Lock()
 	defer n.watcherLock.Unlock()
 
 	if n.watchers == nil {
 		n.watchers = list.New()
 	}
-	w := newWatcher(n, bufLen)
+	w := newWatcher(n, bufLen, policy)
 	elem := n.watchers.PushBack(w)
+	n.ref()
 	w.remove = func() {
 
 		if w.removed { // avoid removing it twice
@@ -301,9 +406,7 @@ func (n *node) Watch(bufLen int) Watcher {
 		}
 		w.removed = true
 		n.watchers.Remove(elem)
-		if n.watchers.Len() == 0 {
-			n.store.Clean(n.Path())
-		}
+		n.unref()
 	}
 
 	return w
@@ -0,0 +1,290 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package store
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"openpitrix.io/metad/pkg/flatmap"
+	"openpitrix.io/metad/pkg/logger"
+	"openpitrix.io/metad/pkg/util"
+)
+
+// Quota is the budget of a top level prefix, 0 means unlimited. MaxValueBytes limit the size of every leaf value,
+// MaxDepth limit the depth of every leaf path including the prefix, such as /nodes/1/ip is 3.
+type Quota struct {
+	MaxKeys       int64 `json:"max_keys"`
+	MaxBytes      int64 `json:"max_bytes"`
+	MaxValueBytes int64 `json:"max_value_bytes"`
+	MaxDepth      int64 `json:"max_depth"`
+}
+
+// Usage is the utilization of a top level prefix, the keys and bytes are the count and value size of the leaves.
+type Usage struct {
+	Prefix   string `json:"prefix"`
+	Keys     int64  `json:"keys"`
+	Bytes    int64  `json:"bytes"`
+	MaxKeys  int64  `json:"max_keys,omitempty"`
+	MaxBytes int64  `json:"max_bytes,omitempty"`
+	// MaxValueBytes and MaxDepth are checked on the writes only, not counted in Exceeded.
+	MaxValueBytes int64 `json:"max_value_bytes,omitempty"`
+	MaxDepth      int64 `json:"max_depth,omitempty"`
+	// Exceeded is whether the usage exceeds the quota.
+	Exceeded bool `json:"exceeded"`
+	// Rejected is the count of the writes rejected by the quota.
+	Rejected int64 `json:"rejected"`
+	// Flagged is the count of the writes exceeding the quota but applied.
+	Flagged int64 `json:"flagged"`
+}
+
+type quotaConfig struct {
+	quotas map[string]Quota
+	reject bool
+}
+
+// quotaLogStep log the exceeding writes every quotaLogStep times, to avoid flood the log by syncs.
+const quotaLogStep = 1000
+
+// quotaState record the writes against the quota of a prefix.
+type quotaState struct {
+	rejected int64
+	flagged  int64
+}
+
+type storeQuota struct {
+	config atomic.Value // *quotaConfig
+	states map[string]*quotaState
+	lock   sync.Mutex
+}
+
+// SetQuotas replace the quotas, the prefixes are normalized to the top level node names.
+func (s *store) SetQuotas(quotas map[string]Quota, reject bool) {
+	normalized := make(map[string]Quota, len(quotas))
+	for prefix, quota := range quotas {
+		normalized[topName(path.Clean(path.Join("/", prefix)))] = quota
+	}
+	s.quota.config.Store(&quotaConfig{quotas: normalized, reject: reject})
+}
+
+func (s *store) quotaConfig() *quotaConfig {
+	config, _ := s.quota.config.Load().(*quotaConfig)
+	return config
+}
+
+func (s *store) quotaState(name string) *quotaState {
+	if s.quota.states == nil {
+		s.quota.states = make(map[string]*quotaState)
+	}
+	state, ok := s.quota.states[name]
+	if !ok {
+		state = &quotaState{}
+		s.quota.states[name] = state
+	}
+	return state
+}
+
+// CheckQuota check whether put the value at nodePath would exceed the quotas, the value is same as Put,
+// the old subtree of nodePath is not counted if replace.
+func (s *store) CheckQuota(nodePath string, value interface{}, replace bool) error {
+	config := s.quotaConfig()
+	if config == nil || len(config.quotas) == 0 {
+		return nil
+	}
+	nodePath = path.Clean(path.Join("/", nodePath))
+	values := flatValues(nodePath, value)
+	if values == nil {
+		return nil
+	}
+	unlock := s.rlockSubtree(topName(nodePath))
+	defer unlock()
+	_, err := s.exceedQuota(config, nodePath, values, replace)
+	return err
+}
+
+// flatValues flatten the value of Put to absolute paths, nil if the value is not supported.
+func flatValues(nodePath string, value interface{}) map[string]string {
+	switch t := value.(type) {
+	case map[string]interface{}, map[string]string, []interface{}:
+		values := make(map[string]string)
+		for k, v := range flatmap.Flatten(t) {
+			values[util.AppendPathPrefix(k, nodePath)] = v
+		}
+		return values
+	case string:
+		return map[string]string{nodePath: t}
+	}
+	return nil
+}
+
+type usageDelta struct {
+	keys  int64
+	bytes int64
+}
+
+// exceedQuota return the prefix and error if put the values at nodePath would exceed its quota, the subtree should be locked.
+func (s *store) exceedQuota(config *quotaConfig, nodePath string, values map[string]string, replace bool) (string, error) {
+	deltas := make(map[string]*usageDelta)
+	deltaOf := func(name string) *usageDelta {
+		d, ok := deltas[name]
+		if !ok {
+			d = &usageDelta{}
+			deltas[name] = d
+		}
+		return d
+	}
+	if replace {
+		if n := s.internalGet(nodePath); n != nil {
+			n.walkLeaves(func(leaf *node) {
+				d := deltaOf(topName(leaf.Path()))
+				d.keys--
+				d.bytes -= int64(len(leaf.Value))
+			})
+		}
+	}
+	valuePaths := make([]string, 0, len(values))
+	for valuePath := range values {
+		valuePaths = append(valuePaths, valuePath)
+	}
+	sort.Strings(valuePaths)
+	for _, valuePath := range valuePaths {
+		value := values[valuePath]
+		name := topName(valuePath)
+		quota, ok := config.quotas[name]
+		if !ok {
+			continue
+		}
+		if quota.MaxValueBytes > 0 && int64(len(value)) > quota.MaxValueBytes {
+			return name, fmt.Errorf("quota of /%s exceeded, max value bytes %d, put %d at %s.", name, quota.MaxValueBytes, len(value), valuePath)
+		}
+		if depth := int64(strings.Count(valuePath, "/")); quota.MaxDepth > 0 && depth > quota.MaxDepth {
+			return name, fmt.Errorf("quota of /%s exceeded, max depth %d, put depth %d at %s.", name, quota.MaxDepth, depth, valuePath)
+		}
+		d := deltaOf(name)
+		var n *node
+		if !replace {
+			n = s.internalGet(valuePath)
+		}
+		switch {
+		case n == nil || (n.IsDir() && n.ChildrenCount() == 0):
+			// a new leaf, or an empty dir convert to leaf.
+			d.keys++
+			d.bytes += int64(len(value))
+		case !n.IsDir():
+			d.bytes += int64(len(value) - len(n.Value))
+		}
+	}
+	names := make([]string, 0, len(deltas))
+	for name := range deltas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		quota, ok := config.quotas[name]
+		if !ok {
+			continue
+		}
+		d := deltas[name]
+		var usedKeys, usedBytes int64
+		if top := s.Root.GetChild(name); top != nil {
+			usedKeys, usedBytes = atomic.LoadInt64(&top.usageKeys), atomic.LoadInt64(&top.usageBytes)
+		}
+		if quota.MaxKeys > 0 && d.keys > 0 && usedKeys+d.keys > quota.MaxKeys {
+			return name, fmt.Errorf("quota of /%s exceeded, max keys %d, used %d, put %d.", name, quota.MaxKeys, usedKeys, d.keys)
+		}
+		if quota.MaxBytes > 0 && d.bytes > 0 && usedBytes+d.bytes > quota.MaxBytes {
+			return name, fmt.Errorf("quota of /%s exceeded, max bytes %d, used %d, put %d.", name, quota.MaxBytes, usedBytes, d.bytes)
+		}
+	}
+	return "", nil
+}
+
+// allowPut check the quota before put the value at nodePath, return false if the put should be dropped.
+// The subtree of nodePath should be locked for write.
+func (s *store) allowPut(nodePath string, value interface{}) bool {
+	config := s.quotaConfig()
+	if config == nil || len(config.quotas) == 0 {
+		return true
+	}
+	values := flatValues(nodePath, value)
+	if values == nil {
+		return true
+	}
+	name, err := s.exceedQuota(config, nodePath, values, false)
+	if err == nil {
+		return true
+	}
+	s.quota.lock.Lock()
+	defer s.quota.lock.Unlock()
+	state := s.quotaState(name)
+	if config.reject {
+		state.rejected++
+		if state.rejected%quotaLogStep == 1 {
+			logger.Warn("Drop the write of %s, %d writes dropped: %s", nodePath, state.rejected, err.Error())
+		}
+		return false
+	}
+	state.flagged++
+	if state.flagged%quotaLogStep == 1 {
+		logger.Warn("Write of %s is flagged, %d writes flagged: %s", nodePath, state.flagged, err.Error())
+	}
+	return true
+}
+
+// walkLeaves apply the function on the leaves of the subtree.
+func (n *node) walkLeaves(f func(leaf *node)) {
+	if !n.IsDir() {
+		f(n)
+		return
+	}
+	for _, child := range n.Children {
+		child.walkLeaves(f)
+	}
+}
+
+// Usage return the utilization of the top level prefixes, and the prefixes with quota.
+func (s *store) Usage() []*Usage {
+	unlock := s.rlockSubtree("")
+	defer unlock()
+	config := s.quotaConfig()
+	usages := make(map[string]*Usage)
+	for name, top := range s.Root.Children {
+		usages[name] = &Usage{Prefix: "/" + name, Keys: atomic.LoadInt64(&top.usageKeys), Bytes: atomic.LoadInt64(&top.usageBytes)}
+	}
+	if config != nil {
+		for name, quota := range config.quotas {
+			usage, ok := usages[name]
+			if !ok {
+				usage = &Usage{Prefix: "/" + name}
+				usages[name] = usage
+			}
+			usage.MaxKeys = quota.MaxKeys
+			usage.MaxBytes = quota.MaxBytes
+			usage.MaxValueBytes = quota.MaxValueBytes
+			usage.MaxDepth = quota.MaxDepth
+			usage.Exceeded = (quota.MaxKeys > 0 && usage.Keys > quota.MaxKeys) || (quota.MaxBytes > 0 && usage.Bytes > quota.MaxBytes)
+		}
+	}
+	s.quota.lock.Lock()
+	for name, state := range s.quota.states {
+		if usage, ok := usages[name]; ok {
+			usage.Rejected = state.rejected
+			usage.Flagged = state.flagged
+		}
+	}
+	s.quota.lock.Unlock()
+	result := make([]*Usage, 0, len(usages))
+	for _, usage := range usages {
+		result = append(result, usage)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Prefix < result[j].Prefix
+	})
+	return result
+}
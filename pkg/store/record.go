@@ -0,0 +1,76 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package store
+
+import (
+	"sync"
+)
+
+// RecordStore keep metad's internal records (such as releases), the value is usually a json string.
+type RecordStore interface {
+	Get(key string) (string, bool)
+	GetAll() map[string]string
+	Put(key string, value string)
+	Puts(values map[string]string)
+	Delete(key string)
+	// Version increase on every change, used to detect the changes.
+	Version() int64
+}
+
+func NewRecordStore() RecordStore {
+	return &recordStore{m: make(map[string]string)}
+}
+
+type recordStore struct {
+	m       map[string]string
+	lock    sync.RWMutex
+	version int64
+}
+
+func (s *recordStore) Get(key string) (string, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	v, ok := s.m[key]
+	return v, ok
+}
+
+func (s *recordStore) GetAll() map[string]string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	result := make(map[string]string, len(s.m))
+	for k, v := range s.m {
+		result[k] = v
+	}
+	return result
+}
+
+func (s *recordStore) Put(key string, value string) {
+	s.lock.Lock()
+	s.m[key] = value
+	s.version++
+	s.lock.Unlock()
+}
+
+func (s *recordStore) Puts(values map[string]string) {
+	s.lock.Lock()
+	for k, v := range values {
+		s.m[k] = v
+	}
+	s.version++
+	s.lock.Unlock()
+}
+
+func (s *recordStore) Delete(key string) {
+	s.lock.Lock()
+	delete(s.m, key)
+	s.version++
+	s.lock.Unlock()
+}
+
+func (s *recordStore) Version() int64 {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.version
+}
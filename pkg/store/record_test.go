@@ -0,0 +1,26 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package store
+
+import (
+	"testing"
+
+	. "openpitrix.io/metad/pkg/assert"
+)
+
+func TestRecordStore(t *testing.T) {
+	recordStore := NewRecordStore()
+	recordStore.Put("/r1", "v1")
+	recordStore.Puts(map[string]string{"/r2": "v2", "/r3": "v3"})
+
+	v, ok := recordStore.Get("/r1")
+	Assert(t, ok && "v1" == v)
+	Assert(t, 3 == len(recordStore.GetAll()))
+
+	recordStore.Delete("/r1")
+	_, ok = recordStore.Get("/r1")
+	Assert(t, !ok)
+	Assert(t, 2 == len(recordStore.GetAll()))
+}
@@ -0,0 +1,67 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package store
+
+import (
+	"sort"
+)
+
+// EventSchemaVersion is the current version of the Event wire format, it is carried by the event consumers' payloads,
+// such as the watch with_events response and the webhook notification.
+// In a version, fields are only added (optional), never removed, renamed or changed in type or meaning,
+// the incompatible change should bump the version, and the previous versions keep published.
+const EventSchemaVersion = 1
+
+// eventSchemas is the JSON schema (draft-07) documents of the Event wire format by version.
+var eventSchemas = map[int]string{
+	1: `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "$id": "metad/event/1",
+  "title": "metad store event",
+  "type": "object",
+  "required": ["action", "path", "value"],
+  "properties": {
+    "action": {
+      "type": "string",
+      "description": "UPDATE or DELETE, consumers should ignore the events of unknown action.",
+      "enum": ["UPDATE", "DELETE"]
+    },
+    "path": {
+      "type": "string",
+      "description": "The path of the changed leaf node in the watched view, such as /nodes/1/ip, or /node/ip of the /self view."
+    },
+    "value": {
+      "type": "string",
+      "description": "The new value of UPDATE, empty for DELETE."
+    },
+    "actor": {
+      "type": "string",
+      "description": "Who made the change, manage:{identity} for the manage api, or backend for the changes not made by this metad."
+    },
+    "events": {
+      "type": "array",
+      "description": "Reserved for the coalesced events, the events are flattened before sent to consumers.",
+      "items": {"$ref": "#"}
+    }
+  },
+  "additionalProperties": true
+}`,
+}
+
+// EventSchema return the JSON schema document of the Event wire format version.
+func EventSchema(version int) (string, bool) {
+	schema, ok := eventSchemas[version]
+	return schema, ok
+}
+
+// EventSchemaVersions return the published versions in ascending order.
+func EventSchemaVersions() []int {
+	versions := make([]int, 0, len(eventSchemas))
+	for v := range eventSchemas {
+		versions = append(versions, v)
+	}
+	sort.Ints(versions)
+	return versions
+}
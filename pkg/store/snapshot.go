@@ -0,0 +1,113 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package store
+
+import (
+	"encoding/json"
+	"path"
+	"strings"
+)
+
+// Snapshot is an immutable view of the store, it can be read and serialized without holding the store lock.
+type Snapshot interface {
+	// Version return the store's version when the snapshot taken.
+	Version() int64
+	// Get is same as Store.Get, but read from the snapshot.
+	Get(nodePath string) interface{}
+	// Json output the snapshot as json, same as Store.Json.
+	Json() string
+}
+
+// frozenNode is the immutable copy of a node. The node keep its frozen copy until changed,
+// so the snapshot only copy the changed nodes, and share the unchanged subtrees with the previous snapshots.
+type frozenNode struct {
+	Name     string                 `json:"name"`
+	Value    string                 `json:"value"`
+	Children map[string]*frozenNode `json:"children"`
+}
+
+// freeze return the frozen copy of the node, the caller should hold the lock of the node's subtree.
+func (n *node) freeze() *frozenNode {
+	if f, _ := n.frozen.Load().(*frozenNode); f != nil {
+		return f
+	}
+	f := &frozenNode{Name: n.Name, Value: n.Value}
+	if n.IsDir() {
+		f.Children = make(map[string]*frozenNode, len(n.Children))
+		for k, child := range n.Children {
+			f.Children[k] = child.freeze()
+		}
+	}
+	n.frozen.Store(f)
+	return f
+}
+
+// unfreeze drop the frozen copy of the node and its ancestors, should be called when the node's value or children changed.
+func (n *node) unfreeze() {
+	for curr := n; curr != nil; curr = curr.parent {
+		curr.frozen.Store((*frozenNode)(nil))
+	}
+}
+
+func (f *frozenNode) IsDir() bool {
+	return f.Children != nil
+}
+
+// GetValue is same as node.GetValue, return a new map for dir, so the caller can modify it.
+func (f *frozenNode) GetValue() interface{} {
+	if f.IsDir() {
+		values := make(map[string]interface{})
+		for k, child := range f.Children {
+			v := child.GetValue()
+			m, isMap := v.(map[string]interface{})
+			// skip empty dir.
+			if isMap && len(m) == 0 {
+				continue
+			}
+			values[k] = v
+		}
+		return values
+	} else {
+		return f.Value
+	}
+}
+
+type snapshot struct {
+	version int64
+	root    *frozenNode
+}
+
+func (s *snapshot) Version() int64 {
+	return s.version
+}
+
+func (s *snapshot) Get(nodePath string) interface{} {
+	nodePath = path.Clean(path.Join("/", nodePath))
+	curr := s.root
+	for _, component := range strings.Split(nodePath, "/") {
+		if component == "" {
+			continue
+		}
+		if !curr.IsDir() {
+			return nil
+		}
+		curr = curr.Children[component]
+		if curr == nil {
+			return nil
+		}
+	}
+	val := curr.GetValue()
+	m, mok := val.(map[string]interface{})
+	// treat empty dir as not found result.
+	if mok && len(m) == 0 && curr != s.root {
+		return nil
+	}
+	return val
+}
+
+func (s *snapshot) Json() string {
+	b, _ := json.Marshal(s.root)
+	return string(b)
+}
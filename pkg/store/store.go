@@ -10,8 +10,10 @@ package store
 
 import (
 	"fmt"
+	"hash/fnv"
 	"path"
 	"reflect"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -32,26 +34,91 @@ type Store interface {
 	Delete(nodePath string)
 	// PutBulk value should be a flatmap
 	PutBulk(nodePath string, value map[string]string)
+	// Load build the tree of the flatmap values in bulk if the store has no leaf, such as the initial sync, and
+	// deliver a Resync event to the watchers instead of the change events, return false without change otherwise.
+	Load(values map[string]string) bool
+	// Watch is same as WatchWithPolicy with DropOldest policy.
 	Watch(nodePath string, buf int) Watcher
+	// WatchWithPolicy watch the changes of nodePath, buf is the capacity of the event channel,
+	// policy is how to handle the new event when the channel is full.
+	// The nodePath can be a glob pattern such as /clusters/*/ip (see path.Match), the events under the static prefix
+	// matched by the pattern are delivered, with path relative to the prefix (/cl-1/ip).
+	WatchWithPolicy(nodePath string, buf int, policy OverflowPolicy) Watcher
 	// Clean clean the nodePath's node
 	Clean(nodePath string)
 	// Json output store as json
 	Json() string
+	// Snapshot return an immutable view of the store, the unchanged subtrees are shared between snapshots.
+	Snapshot() Snapshot
+	// SetQuotas set the budgets of the top level prefixes, the puts exceeding the budget are dropped if reject,
+	// otherwise are applied and flagged in the usage.
+	SetQuotas(quotas map[string]Quota, reject bool)
+	// CheckQuota return error if put the value at nodePath would exceed the quota, the old value is not counted if replace.
+	CheckQuota(nodePath string, value interface{}, replace bool) error
+	// Usage return the utilization of the top level prefixes.
+	Usage() []*Usage
+	// MemoryUsage return the approximate memory of the subtree at nodePath, nil if not exist, and the heaviest limit
+	// subtrees depth levels below it.
+	MemoryUsage(nodePath string, depth int, limit int) (*MemoryUsage, []*MemoryUsage)
+	// SetIndexKeys set the leaf names of the inverted index, such as ip, the index is rebuilt.
+	SetIndexKeys(keys []string)
+	// IndexKeys return the indexed leaf names.
+	IndexKeys() []string
+	// Find return the paths under prefix of the leaves named key with the value, and false if the key is not indexed.
+	Find(key string, value string, prefix string) ([]string, bool)
+	// CreatedOrder return the creation orders of the children of the dir nodePath by name, nil if not a dir.
+	// The children created by one bulk put are created in util.NaturalLess order.
+	CreatedOrder(nodePath string) map[string]int64
+	// Meta return the change metadata of the node at nodePath and its children, nil if not exist.
+	Meta(nodePath string) *NodeMeta
+	// Revision return the modified version of the node at nodePath, 0 if not exist.
+	Revision(nodePath string) int64
+	// SetHistorySize set the number of the previous values kept by every leaf, 0 means not keep.
+	SetHistorySize(size int)
 	// Version return store's current version
 	Version() int64
 	// Destroy the store
 	Destroy()
 	// Traveller
 	Traveller(accessTree AccessTree) Traveller
+	// SetActorFunc set the func to resolve the actor of the change of nodePath, which is attached to the events.
+	SetActorFunc(actorFunc ActorFunc)
 }
 
+// ActorFunc return who made the change of the nodePath.
+type ActorFunc func(action string, nodePath string, value string) string
+
 type atomic_AtomicLong int64
 
+// storeShards is the number of locks of the top level subtrees.
+const storeShards = 32
+
+// store lock the top level subtrees by shards, so writes under one prefix do not block reads of other prefixes.
+// The subtree operations hold worldLock in read mode and the shard lock of the subtree,
+// the root level operations and the changes of root's children hold worldLock in write mode.
 type store struct {
 	Root      *node
 	version   atomic_AtomicLong
 	worldLock sync.RWMutex // stop the world lock
-	cleanChan chan string
+	shards    [storeShards]sync.RWMutex
+	exclusive bool // worldLock is held in write mode
+	// cleanPending is the paths to clean by the clean loop, nil after destroyed.
+	cleanPending map[string]bool
+	cleanSignal  chan struct{}
+	cleanLock    sync.Mutex
+	actorFunc    atomic.Value
+	quota        storeQuota
+	index        storeIndex
+	// batches is the event batches of the running bulk updates by top level name, empty name for root.
+	batches   map[string]*eventBatch
+	batching  int32
+	batchLock sync.Mutex
+	// dispatcher deliver the events in the order of the writes.
+	dispatcher *dispatcher
+	// created is the createdIndex of the last created node.
+	created int64
+	// historySize is the number of the previous values kept by every leaf.
+	historySize int32
 }
 
 func New() Store {
@@ -62,46 +129,69 @@ func New() Store {
 func newStore() *store {
 	s := new(store)
 	s.version = 0
+	s.historySize = DefaultHistorySize
 	s.Root = newDir(s, "/", nil)
-	s.cleanChan = make(chan string, 100)
-	go func() {
-		for {
-			select {
-			case nodePath, ok := <-s.cleanChan:
-				if ok {
-					s.worldLock.Lock()
-					node := s.internalGet(nodePath)
-					if node != nil {
-						node.Clean()
-					}
-					s.worldLock.Unlock()
-				} else {
-					return
+	s.cleanPending = make(map[string]bool)
+	s.cleanSignal = make(chan struct{}, 1)
+	s.batches = make(map[string]*eventBatch)
+	s.dispatcher = newDispatcher()
+	go s.cleanLoop()
+	return s
+}
+
+// cleanLoop clean the pending paths in one world lock, the deeper paths first, so the empty parents are cleaned too.
+func (s *store) cleanLoop() {
+	for range s.cleanSignal {
+		s.cleanLock.Lock()
+		pending := s.cleanPending
+		if pending != nil {
+			s.cleanPending = make(map[string]bool)
+		}
+		s.cleanLock.Unlock()
+		if len(pending) == 0 {
+			continue
+		}
+		paths := make([]string, 0, len(pending))
+		for nodePath := range pending {
+			paths = append(paths, nodePath)
+		}
+		sort.Slice(paths, func(i, j int) bool {
+			return strings.Count(paths[i], "/") > strings.Count(paths[j], "/")
+		})
+		s.lockWorld()
+		if s.Root != nil {
+			for _, nodePath := range paths {
+				node := s.internalGet(nodePath)
+				if node != nil {
+					node.Clean()
 				}
 			}
 		}
-	}()
-	return s
+		s.unlockWorld()
+	}
 }
 
 // Get returns a path value.
 func (s *store) Get(nodePath string) (currentVersion int64, val interface{}) {
+	nodePath = path.Clean(path.Join("/", nodePath))
 
-	s.worldLock.RLock()
-	defer s.worldLock.RUnlock()
+	unlock := s.rlockSubtree(topName(nodePath))
 	currentVersion = atomic.LoadInt64((*int64)(&s.version))
 	val = nil
 
-	nodePath = path.Clean(path.Join("/", nodePath))
-
 	n := s.internalGet(nodePath)
-	if n != nil {
-		val = n.GetValue()
-		m, mok := val.(map[string]interface{})
-		// treat empty dir as not found result.
-		if mok && len(m) == 0 && !n.IsRoot() {
-			val = nil
-		}
+	if n == nil {
+		unlock()
+		return
+	}
+	// build the value from the frozen copy after unlock, so large reads do not block the writes.
+	f := n.freeze()
+	unlock()
+	val = f.GetValue()
+	m, mok := val.(map[string]interface{})
+	// treat empty dir as not found result.
+	if mok && len(m) == 0 && !n.IsRoot() {
+		val = nil
 	}
 	return
 }
@@ -110,10 +200,15 @@ func (s *store) Get(nodePath string) (currentVersion int64, val interface{}) {
 func (s *store) Put(nodePath string, value interface{}) {
 	nodePath = path.Clean(path.Join("/", nodePath))
 
-	s.worldLock.Lock()
-	defer s.worldLock.Unlock()
+	defer s.dispatcher.throttle()
+	unlock := s.lockSubtree(topName(nodePath))
+	defer unlock()
+	if !s.allowPut(nodePath, value) {
+		return
+	}
 	switch t := value.(type) {
 	case map[string]interface{}, map[string]string, []interface{}:
+		defer s.beginBatch(nodePath)()
 		flatValues := flatmap.Flatten(t)
 		s.internalPutBulk(nodePath, flatValues)
 	case string:
@@ -124,19 +219,24 @@ func (s *store) Put(nodePath string, value interface{}) {
 }
 
 func (s *store) PutBulk(nodePath string, values map[string]string) {
-	s.worldLock.Lock()
-	defer s.worldLock.Unlock()
+	defer s.dispatcher.throttle()
+	unlock := s.lockSubtree(topName(path.Clean(path.Join("/", nodePath))))
+	defer unlock()
+	if !s.allowPut(path.Clean(path.Join("/", nodePath)), values) {
+		return
+	}
+	defer s.beginBatch(path.Clean(path.Join("/", nodePath)))()
 	s.internalPutBulk(nodePath, values)
 }
 
 // Delete deletes the node at the given path.
 func (s *store) Delete(nodePath string) {
-
-	s.worldLock.Lock()
-	defer s.worldLock.Unlock()
-
 	nodePath = path.Clean(path.Join("/", nodePath))
 
+	defer s.dispatcher.throttle()
+	unlock := s.lockSubtree(topName(nodePath))
+	defer unlock()
+
 	n := s.internalGet(nodePath)
 	if n == nil {
 		// if the node does not exist, treat as success
@@ -147,55 +247,202 @@ func (s *store) Delete(nodePath string) {
 }
 
 func (s *store) Watch(nodePath string, buf int) Watcher {
-	s.worldLock.Lock()
-	defer s.worldLock.Unlock()
-	var n *node
+	return s.WatchWithPolicy(nodePath, buf, DropOldest)
+}
+
+func (s *store) WatchWithPolicy(nodePath string, buf int, policy OverflowPolicy) Watcher {
+	if prefix, pattern, ok := splitGlob(nodePath); ok {
+		return newGlobWatcher(s.WatchWithPolicy(prefix, buf, policy), pattern, buf)
+	}
 	if nodePath == "/" {
-		n = s.Root
-	} else {
+		s.worldLock.RLock()
+		defer s.worldLock.RUnlock()
+		return s.Root.Watch(buf, policy)
+	}
+	unlock := s.lockSubtree(topName(path.Clean(path.Join("/", nodePath))))
+	defer unlock()
 
-		dirName, nodeName := path.Split(nodePath)
+	dirName, nodeName := path.Split(nodePath)
 
-		// walk through the nodePath, create dirs and get the last directory node
-		d := s.walk(dirName, s.checkDir)
-		n = d.GetChild(nodeName)
-		if n == nil {
-			// if watch node not exist, create a empty dir.
-			n = newDir(s, nodeName, d)
-		}
+	// walk through the nodePath, create dirs and get the last directory node
+	d := s.walk(dirName, s.checkDir)
+	n := d.GetChild(nodeName)
+	if n == nil {
+		// if watch node not exist, create a empty dir.
+		n = newDir(s, nodeName, d)
 	}
-	return n.Watch(buf)
+	return n.Watch(buf, policy)
 }
 
 func (s *store) Json() string {
-	return s.Root.Json()
+	return s.Snapshot().Json()
+}
+
+func (s *store) Snapshot() Snapshot {
+	unlock := s.rlockSubtree("")
+	defer unlock()
+	return &snapshot{version: atomic.LoadInt64((*int64)(&s.version)), root: s.Root.freeze()}
 }
 
 func (s *store) Version() int64 {
 	return atomic.LoadInt64((*int64)(&s.version))
 }
 
+// Clean request the clean loop to clean the nodePath, the requests are coalesced and never dropped,
+// and ignored after the store destroyed.
 func (s *store) Clean(nodePath string) {
+	s.cleanLock.Lock()
+	defer s.cleanLock.Unlock()
+	if s.cleanPending == nil {
+		return
+	}
+	s.cleanPending[nodePath] = true
 	select {
-	case s.cleanChan <- nodePath:
+	case s.cleanSignal <- struct{}{}:
 	default:
-		println("drop clean node:", nodePath)
-		break
 	}
-
 }
 
 func (s *store) Destroy() {
-	s.worldLock.Lock()
-	defer s.worldLock.Unlock()
-	close(s.cleanChan)
+	s.lockWorld()
+	defer s.unlockWorld()
+	s.cleanLock.Lock()
+	s.cleanPending = nil
+	close(s.cleanSignal)
+	s.cleanLock.Unlock()
+	s.dispatcher.close()
 	s.Root = nil
 }
 
+func (s *store) SetActorFunc(actorFunc ActorFunc) {
+	s.actorFunc.Store(actorFunc)
+}
+
+func (s *store) actorOf(action string, nodePath string, value string) string {
+	actorFunc, ok := s.actorFunc.Load().(ActorFunc)
+	if !ok || actorFunc == nil {
+		return ""
+	}
+	return actorFunc(action, nodePath, value)
+}
+
 func (s *store) Traveller(accessTree AccessTree) Traveller {
 	return newTraveller(s, accessTree)
 }
 
+func (s *store) lockWorld() {
+	s.worldLock.Lock()
+	s.exclusive = true
+}
+
+func (s *store) unlockWorld() {
+	s.exclusive = false
+	s.worldLock.Unlock()
+}
+
+// beginBatch coalesce the events of the bulk update at nodePath until the returned func called,
+// the subtree should be locked for write until the events flushed.
+func (s *store) beginBatch(nodePath string) func() {
+	name := topName(nodePath)
+	batch := &eventBatch{events: make(map[*watcher][]*Event)}
+	s.batchLock.Lock()
+	s.batches[name] = batch
+	s.batchLock.Unlock()
+	atomic.AddInt32(&s.batching, 1)
+	return func() {
+		atomic.AddInt32(&s.batching, -1)
+		s.batchLock.Lock()
+		delete(s.batches, name)
+		s.batchLock.Unlock()
+		batch.flush(s.dispatcher)
+	}
+}
+
+// dispatch queue the delivery to the dispatcher, the node of the delivery should be referenced.
+// The writes wait after unlock while the backlog is full, so the subtree is not locked during the delivery.
+func (s *store) dispatch(de delivery) {
+	s.dispatcher.add(de)
+}
+
+// batchOf return the running batch of the node's subtree, nil if not in a bulk update.
+func (s *store) batchOf(n *node) *eventBatch {
+	if atomic.LoadInt32(&s.batching) == 0 {
+		return nil
+	}
+	s.batchLock.Lock()
+	defer s.batchLock.Unlock()
+	if len(s.batches) == 0 {
+		return nil
+	}
+	// the root batch hold the world lock, no other batch is running.
+	if batch, ok := s.batches[""]; ok {
+		return batch
+	}
+	return s.batches[topName(n.Path())]
+}
+
+// topName return the top level node name of the clean nodePath, empty for root.
+func topName(nodePath string) string {
+	name := strings.TrimPrefix(nodePath, "/")
+	if i := strings.Index(name, "/"); i >= 0 {
+		name = name[:i]
+	}
+	return name
+}
+
+func (s *store) shard(name string) *sync.RWMutex {
+	return &s.shards[shardIndex(name)]
+}
+
+func shardIndex(name string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return h.Sum32() % storeShards
+}
+
+// rlockSubtree lock the top level subtree for read, lock all subtrees if name is empty.
+func (s *store) rlockSubtree(name string) func() {
+	s.worldLock.RLock()
+	if name != "" {
+		shard := s.shard(name)
+		shard.RLock()
+		return func() {
+			shard.RUnlock()
+			s.worldLock.RUnlock()
+		}
+	}
+	// always lock shards in order to avoid dead lock.
+	for i := range s.shards {
+		s.shards[i].RLock()
+	}
+	return func() {
+		for i := range s.shards {
+			s.shards[i].RUnlock()
+		}
+		s.worldLock.RUnlock()
+	}
+}
+
+// lockSubtree lock the top level subtree for write, the world is locked if name is empty or the top level node
+// does not exist, as the root's children are changed.
+func (s *store) lockSubtree(name string) func() {
+	if name != "" {
+		s.worldLock.RLock()
+		// root's children are not changed when holding worldLock.
+		if s.Root.GetChild(name) != nil {
+			shard := s.shard(name)
+			shard.Lock()
+			return func() {
+				shard.Unlock()
+				s.worldLock.RUnlock()
+			}
+		}
+		s.worldLock.RUnlock()
+	}
+	s.lockWorld()
+	return s.unlockWorld
+}
+
 // walk walks all the nodePath and apply the walkFunc on each directory
 func (s *store) walk(nodePath string, walkFunc func(prev *node, component string) *node) *node {
 	components := strings.Split(nodePath, "/")
@@ -246,10 +493,37 @@ func (s *store) internalPut(nodePath string, value string) *node {
 }
 
 func (s *store) internalPutBulk(nodePath string, values map[string]string) {
-	for k, v := range values {
+	// put in order, so the created order of the new nodes is deterministic.
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return util.NaturalLess(keys[i], keys[j])
+	})
+	for _, k := range keys {
 		key := util.AppendPathPrefix(k, nodePath)
-		s.internalPut(key, v)
+		s.internalPut(key, values[k])
+	}
+}
+
+func (s *store) nextCreatedIndex() int64 {
+	return atomic.AddInt64(&s.created, 1)
+}
+
+func (s *store) CreatedOrder(nodePath string) map[string]int64 {
+	nodePath = path.Clean(path.Join("/", nodePath))
+	unlock := s.rlockSubtree(topName(nodePath))
+	defer unlock()
+	n := s.internalGet(nodePath)
+	if n == nil || !n.IsDir() {
+		return nil
+	}
+	result := make(map[string]int64, len(n.Children))
+	for name, child := range n.Children {
+		result[name] = child.createdIndex
 	}
+	return result
 }
 
 // InternalGet gets the node of the given nodePath.
@@ -9,9 +9,12 @@
 package store
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"reflect"
+	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -280,6 +283,195 @@ func TestWatchRoot(t *testing.T) {
 	s.Destroy()
 }
 
+func TestWatchOverflow(t *testing.T) {
+	s := New()
+
+	w := s.Watch("/nodes", 3)
+	for i := 0; i < 5; i++ {
+		s.Put(fmt.Sprintf("/nodes/%d", i), "node")
+	}
+	// the events are delivered after the writes returned.
+	time.Sleep(100 * time.Millisecond)
+	// the oldest events are dropped, and a resync event is delivered before the new event.
+	e := readEvent(w.EventChan())
+	Assert(t, Resync == e.Action && "/" == e.Path, e)
+	e = readEvent(w.EventChan())
+	Assert(t, Update == e.Action && "/3" == e.Path, e)
+	e = readEvent(w.EventChan())
+	Assert(t, Update == e.Action && "/4" == e.Path, e)
+	Assert(t, nil == readEvent(w.EventChan()))
+	w.Remove()
+
+	w = s.WatchWithPolicy("/nodes", 1, Block)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 5; i++ {
+			s.Put(fmt.Sprintf("/nodes/%d", i), "node-new")
+		}
+	}()
+	for i := 0; i < 5; i++ {
+		e = readEvent(w.EventChan())
+		Assert(t, Update == e.Action && fmt.Sprintf("/%d", i) == e.Path, e)
+	}
+	<-done
+
+	// the blocked writer is released when the watcher removed.
+	released := make(chan struct{})
+	go func() {
+		defer close(released)
+		s.Put("/nodes/1", "node1")
+		s.Put("/nodes/2", "node2")
+		s.Put("/nodes/3", "node3")
+	}()
+	time.Sleep(100 * time.Millisecond)
+	w.Remove()
+	s.Put("/nodes/4", "node4")
+	_, val := s.Get("/nodes/4")
+	Assert(t, "node4" == val)
+	<-released
+	s.Destroy()
+}
+
+func TestWatchDispatch(t *testing.T) {
+	s := New()
+	w := s.WatchWithPolicy("/nodes", 1, Block)
+	s.Put("/nodes/1", "node1")
+	// the writer is not blocked by the full watcher, and the subtree is not locked during the delivery.
+	released := make(chan struct{})
+	go func() {
+		defer close(released)
+		s.Put("/nodes/2", "node2")
+		s.Put("/nodes/3", "node3")
+	}()
+	select {
+	case <-released:
+	case <-time.After(time.Second):
+		t.Fatal("writer blocked by the delivery")
+	}
+	_, val := s.Get("/nodes/3")
+	Assert(t, "node3" == val, val)
+	for i := 1; i <= 3; i++ {
+		e := readEvent(w.EventChan())
+		Assert(t, Update == e.Action && fmt.Sprintf("/%d", i) == e.Path, e)
+	}
+	w.Remove()
+
+	// the events of the top level subtrees are delivered in the order of the writes.
+	w = s.Watch("/", 1000)
+	for i := 0; i < 100; i++ {
+		s.Put(fmt.Sprintf("/prefix%d/key", i), fmt.Sprintf("%d", i))
+	}
+	for i := 0; i < 100; i++ {
+		e := readEvent(w.EventChan())
+		Assert(t, e != nil && fmt.Sprintf("/prefix%d/key", i) == e.Path, e)
+	}
+	w.Remove()
+
+	// the events of the concurrent writers are delivered in order of every subtree.
+	w = s.Watch("/nodes", 1000)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				s.Put(fmt.Sprintf("/nodes/w%d/ip", i), fmt.Sprintf("%d", j))
+			}
+		}(i)
+	}
+	wg.Wait()
+	last := map[string]int{}
+	for i := 0; i < 500; i++ {
+		e := readEvent(w.EventChan())
+		Assert(t, e != nil && Update == e.Action, e)
+		j, _ := strconv.Atoi(e.Value)
+		if prev, ok := last[e.Path]; ok {
+			Assert(t, j == prev+1, e)
+		}
+		last[e.Path] = j
+	}
+	w.Remove()
+	s.Destroy()
+}
+
+func TestWatchGlob(t *testing.T) {
+	s := New()
+	s.Put("/clusters/c1", map[string]interface{}{"ip": "192.168.1.1", "name": "c1"})
+
+	w := s.Watch("/clusters/*/ip", 10)
+	s.Put("/clusters/c1/name", "cluster1")
+	s.Put("/clusters/c2", map[string]interface{}{"ip": "192.168.1.2", "name": "c2"})
+	s.Put("/clusters/c1/ip", "192.168.1.11")
+	s.Put("/nodes/n1/ip", "192.168.2.1")
+	s.Delete("/clusters/c2")
+
+	e := readEvent(w.EventChan())
+	Assert(t, Update == e.Action && "/c2/ip" == e.Path && "192.168.1.2" == e.Value, e)
+	e = readEvent(w.EventChan())
+	Assert(t, Update == e.Action && "/c1/ip" == e.Path && "192.168.1.11" == e.Value, e)
+	e = readEvent(w.EventChan())
+	Assert(t, Delete == e.Action && "/c2/ip" == e.Path, e)
+	Assert(t, nil == readEvent(w.EventChan()))
+	w.Remove()
+
+	// the changes under the matched path.
+	w = s.Watch("/*/c?", 10)
+	s.Put("/clusters/c1/labels/zone", "z1")
+	s.Put("/clusters/cl3/ip", "192.168.1.3")
+	e = readEvent(w.EventChan())
+	Assert(t, Update == e.Action && "/clusters/c1/labels/zone" == e.Path, e)
+	Assert(t, nil == readEvent(w.EventChan()))
+	w.Remove()
+	s.Destroy()
+}
+
+func TestWatchBatch(t *testing.T) {
+	s := New()
+	s.Put("/nodes/1/name", "node1")
+
+	w := s.Watch("/nodes", 10)
+	rootWatcher := s.Watch("/", 10)
+	s.PutBulk("/nodes", map[string]string{"1/ip": "192.168.1.1", "2/name": "node2", "2/ip": "192.168.1.2"})
+	// the bulk update is coalesced into one event.
+	e := readEvent(w.EventChan())
+	Assert(t, Batch == e.Action && "/" == e.Path && 3 == len(e.Events), e)
+	paths := map[string]string{}
+	for _, sub := range e.Flatten() {
+		Assert(t, Update == sub.Action, sub)
+		paths[sub.Path] = sub.Value
+	}
+	Assert(t, "192.168.1.1" == paths["/1/ip"] && "node2" == paths["/2/name"] && "192.168.1.2" == paths["/2/ip"], paths)
+	Assert(t, nil == readEvent(w.EventChan()))
+	e = readEvent(rootWatcher.EventChan())
+	Assert(t, Batch == e.Action && 3 == len(e.Events) && strings.HasPrefix(e.Events[0].Path, "/nodes/"), e)
+	rootWatcher.Remove()
+
+	// the map put is coalesced, but a single event is not wrapped.
+	s.Put("/nodes/3", map[string]interface{}{"name": "node3"})
+	e = readEvent(w.EventChan())
+	Assert(t, Update == e.Action && "/3/name" == e.Path, e)
+	s.Put("/nodes/4", map[string]interface{}{"name": "node4", "ip": "192.168.1.4"})
+	e = readEvent(w.EventChan())
+	Assert(t, Batch == e.Action && 2 == len(e.Events), e)
+	w.Remove()
+
+	// the glob watcher filter the events of the batch.
+	w = s.Watch("/nodes/*/ip", 10)
+	s.PutBulk("/nodes", map[string]string{"5/name": "node5", "5/ip": "192.168.1.5"})
+	e = readEvent(w.EventChan())
+	Assert(t, Update == e.Action && "/5/ip" == e.Path, e)
+	w.Remove()
+
+	// the aggregate watcher prefix the events of the batch.
+	w = NewAggregateWatcher(map[string]Watcher{"/nodes": s.Watch("/nodes", 10)})
+	s.PutBulk("/nodes/6", map[string]string{"name": "node6", "ip": "192.168.1.6"})
+	e = readEvent(w.EventChan())
+	Assert(t, Batch == e.Action && 2 == len(e.Events) && strings.HasPrefix(e.Events[0].Path, "/nodes/6/"), e)
+	w.Remove()
+	s.Destroy()
+}
+
 func TestEmptyStore(t *testing.T) {
 	s := newStore()
 	_, val := s.Get("/")
@@ -371,3 +563,569 @@ func TestConcurrentWatchAndPut(t *testing.T) {
 	wg.Wait()
 	s.Destroy()
 }
+
+func TestStoreTopLevelDelete(t *testing.T) {
+	s := New()
+	w := s.Watch("/", 10)
+	s.Put("/foo", "bar")
+	s.Put("/nodes/1/name", "n1")
+	Assert(t, Update == readEvent(w.EventChan()).Action)
+	Assert(t, Update == readEvent(w.EventChan()).Action)
+
+	s.Delete("/foo")
+	e := readEvent(w.EventChan())
+	Assert(t, Delete == e.Action && "/foo" == e.Path && "bar" == e.Value)
+	_, val := s.Get("/foo")
+	Assert(t, nil == val)
+	_, val = s.Get("/")
+	Assert(t, reflect.DeepEqual(map[string]interface{}{"nodes": map[string]interface{}{"1": map[string]interface{}{"name": "n1"}}}, val))
+
+	// the deleted top level node is cleaned by background goroutine.
+	time.Sleep(100 * time.Millisecond)
+	s2 := s.(*store)
+	s2.worldLock.RLock()
+	n := s2.internalGet("/foo")
+	s2.worldLock.RUnlock()
+	Assert(t, nil == n)
+
+	s.Put("/foo", "bar2")
+	_, val = s.Get("/foo")
+	Assert(t, "bar2" == val)
+	w.Remove()
+	s.Destroy()
+}
+
+func TestStoreShardConcurrent(t *testing.T) {
+	s := New()
+	s.Put("/", map[string]interface{}{"a": map[string]interface{}{"1": "a1"}, "b": map[string]interface{}{"1": "b1"}})
+	loop := 1000
+	wg := sync.WaitGroup{}
+	for _, prefix := range []string{"/a", "/b", "/c"} {
+		wg.Add(2)
+		go func(prefix string) {
+			defer wg.Done()
+			for i := 0; i < loop; i++ {
+				s.Put(prefix+"/2", fmt.Sprintf("%d", i))
+				if i%10 == 0 {
+					s.Delete(prefix)
+				}
+			}
+		}(prefix)
+		go func(prefix string) {
+			defer wg.Done()
+			for i := 0; i < loop; i++ {
+				s.Get(prefix)
+				s.Get("/")
+				traveller := s.Traveller(NewAccessTree([]AccessRule{{Path: "/", Mode: AccessModeRead}}))
+				traveller.Enter(prefix)
+				traveller.GetValue()
+				traveller.BackToRoot()
+				traveller.GetValue()
+				traveller.Close()
+			}
+		}(prefix)
+	}
+	wg.Wait()
+	for _, prefix := range []string{"/a", "/b", "/c"} {
+		_, val := s.Get(prefix)
+		Assert(t, reflect.DeepEqual(map[string]interface{}{"2": fmt.Sprintf("%d", loop-1)}, val))
+	}
+	s.Destroy()
+}
+
+func TestStoreCleanConcurrent(t *testing.T) {
+	s := New()
+	s2 := s.(*store)
+	// the watchers of not exist paths create empty dirs, which are cleaned when the watchers removed.
+	watchers := make([]Watcher, 0, 1000)
+	for i := 0; i < 1000; i++ {
+		watchers = append(watchers, s.Watch(fmt.Sprintf("/clusters/cl-%d/nodes/%d", i%10, i), 10))
+	}
+	s.Put("/clusters/cl-0/name", "cl-0")
+	wg := sync.WaitGroup{}
+	for i, w := range watchers {
+		wg.Add(1)
+		go func(i int, w Watcher) {
+			defer wg.Done()
+			if i%3 == 0 {
+				s.Delete(fmt.Sprintf("/clusters/cl-%d", i%10))
+			}
+			w.Remove()
+		}(i, w)
+	}
+	wg.Wait()
+	// the clean requests are never dropped, all the empty dirs are cleaned.
+	var count int
+	for i := 0; i < 50; i++ {
+		time.Sleep(20 * time.Millisecond)
+		s2.worldLock.RLock()
+		count = s2.Root.ChildrenCount()
+		s2.worldLock.RUnlock()
+		if count == 0 {
+			break
+		}
+	}
+	Assert(t, 0 == count, s.Json())
+
+	// a watcher removed after the store destroyed is ignored.
+	w := s.Watch("/nodes/1", 10)
+	s.Destroy()
+	w.Remove()
+}
+
+func TestStoreSnapshot(t *testing.T) {
+	s := New()
+	s.Put("/nodes/1", map[string]interface{}{"ip": "192.168.1.1", "name": "node1"})
+	s.Put("/clusters/c1", "cluster1")
+
+	snap := s.Snapshot()
+	Assert(t, snap.Version() == s.Version())
+	s.Put("/nodes/1/name", "node1-new")
+	s.Put("/nodes/2/name", "node2")
+	s.Delete("/clusters")
+
+	Assert(t, "node1" == snap.Get("/nodes/1/name"))
+	Assert(t, nil == snap.Get("/nodes/2"))
+	Assert(t, "cluster1" == snap.Get("/clusters/c1"))
+	_, val := s.Get("/nodes/1/name")
+	Assert(t, "node1-new" == val)
+	_, val = s.Get("/clusters")
+	Assert(t, nil == val)
+
+	// the unchanged subtrees are shared between snapshots.
+	s1 := s.Snapshot().(*snapshot)
+	s.Put("/nodes/2/name", "node2-new")
+	s2 := s.Snapshot().(*snapshot)
+	Assert(t, s1.root != s2.root)
+	Assert(t, s1.root.Children["nodes"].Children["1"] == s2.root.Children["nodes"].Children["1"])
+	Assert(t, s1.root.Children["nodes"].Children["2"] != s2.root.Children["nodes"].Children["2"])
+	Assert(t, s2 != s.Snapshot().(*snapshot))
+	Assert(t, s2.root == s.Snapshot().(*snapshot).root)
+
+	// the value returned by Get can be modified.
+	_, val = s.Get("/nodes")
+	delete(val.(map[string]interface{}), "1")
+	_, val = s.Get("/nodes/1/name")
+	Assert(t, "node1-new" == val)
+	Assert(t, s.Json() == s.(*store).Root.Json())
+	s.Destroy()
+}
+
+func TestStoreQuota(t *testing.T) {
+	s := New()
+	usageOf := func(prefix string) *Usage {
+		for _, usage := range s.Usage() {
+			if usage.Prefix == prefix {
+				return usage
+			}
+		}
+		return nil
+	}
+	s.Put("/nodes/1", map[string]interface{}{"ip": "192.168.1.1", "name": "node1"})
+	s.Put("/nodes/2/name", "node2")
+	s.Put("/clusters", "c1")
+	Assert(t, usageOf("/nodes").Keys == 3 && usageOf("/nodes").Bytes == int64(len("192.168.1.1node1node2")))
+	Assert(t, usageOf("/clusters").Keys == 1 && usageOf("/clusters").Bytes == 2)
+
+	s.Put("/nodes/2/name", "n2")
+	s.Delete("/nodes/1/ip")
+	// convert leaf to dir.
+	s.Put("/clusters/c1", "cluster1")
+	Assert(t, usageOf("/nodes").Keys == 2 && usageOf("/nodes").Bytes == int64(len("node1n2")))
+	Assert(t, usageOf("/clusters").Keys == 1 && usageOf("/clusters").Bytes == int64(len("cluster1")))
+	s.Delete("/nodes")
+	Assert(t, usageOf("/nodes") == nil || (usageOf("/nodes").Keys == 0 && usageOf("/nodes").Bytes == 0))
+
+	s.SetQuotas(map[string]Quota{"/nodes": {MaxKeys: 2}, "clusters": {MaxBytes: 10}}, true)
+	s.Put("/nodes/1/name", "node1")
+	Assert(t, nil == s.CheckQuota("/nodes/1/name", "node1-new", false))
+	Assert(t, nil != s.CheckQuota("/nodes/2", map[string]interface{}{"name": "node2", "ip": "192.168.1.2"}, false))
+	Assert(t, nil != s.CheckQuota("/nodes/1", map[string]interface{}{"ip": "192.168.1.1", "zone": "z1"}, false))
+	Assert(t, nil == s.CheckQuota("/nodes/1", map[string]interface{}{"ip": "192.168.1.1", "zone": "z1"}, true))
+	s.Put("/nodes/2", map[string]interface{}{"name": "node2", "ip": "192.168.1.2"})
+	_, val := s.Get("/nodes/2")
+	Assert(t, nil == val)
+	s.PutBulk("/clusters", map[string]string{"c2": "cluster2"})
+	_, val = s.Get("/clusters/c2")
+	Assert(t, nil == val)
+	// the writes of other prefixes are not limited.
+	s.Put("/users/1", "user1")
+	_, val = s.Get("/users/1")
+	Assert(t, "user1" == val)
+	usage := usageOf("/nodes")
+	Assert(t, usage.Keys == 1 && usage.MaxKeys == 2 && usage.Rejected == 1 && !usage.Exceeded)
+	Assert(t, usageOf("/clusters").Rejected == 1)
+
+	s.SetQuotas(map[string]Quota{"/nodes": {MaxKeys: 2}}, false)
+	s.Put("/nodes/2", map[string]interface{}{"name": "node2", "ip": "192.168.1.2"})
+	_, val = s.Get("/nodes/2/name")
+	Assert(t, "node2" == val)
+	usage = usageOf("/nodes")
+	Assert(t, usage.Keys == 3 && usage.Exceeded && usage.Flagged == 1)
+
+	s.SetQuotas(map[string]Quota{"/nodes": {MaxValueBytes: 5, MaxDepth: 3}}, true)
+	Assert(t, nil == s.CheckQuota("/nodes/1/name", "n1", false))
+	Assert(t, nil != s.CheckQuota("/nodes/1/name", "node1-new", false))
+	Assert(t, nil != s.CheckQuota("/nodes/1", map[string]interface{}{"labels": map[string]interface{}{"zone": "z1"}}, false))
+	s.Put("/nodes/1/labels/zone", "z1")
+	_, val = s.Get("/nodes/1/labels")
+	Assert(t, nil == val)
+	usage = usageOf("/nodes")
+	Assert(t, usage.MaxValueBytes == 5 && usage.MaxDepth == 3 && usage.Rejected == 2)
+	s.Destroy()
+}
+
+func TestStoreMemoryUsage(t *testing.T) {
+	s := New()
+	s.Put("/nodes", map[string]interface{}{"1": map[string]interface{}{"ip": "192.168.1.1", "name": "node1"}, "2": map[string]interface{}{"name": "node2"}})
+	s.Put("/clusters/c1", "cluster1")
+
+	total, top := s.MemoryUsage("/", 1, 10)
+	Assert(t, total.Prefix == "/" && total.Nodes == 9, total)
+	Assert(t, 2 == len(top) && "/nodes" == top[0].Prefix && "/clusters" == top[1].Prefix, top)
+	Assert(t, top[0].Nodes == 6 && top[1].Nodes == 2, top)
+	Assert(t, total.Bytes == top[0].Bytes+top[1].Bytes+nodeOverhead)
+	Assert(t, top[1].Bytes == int64(2*nodeOverhead+len("clusters")+len("c1")+len("cluster1")), top[1])
+
+	// the heaviest subtrees under the prefix.
+	total, top = s.MemoryUsage("/nodes", 1, 1)
+	Assert(t, total.Nodes == 6)
+	Assert(t, 1 == len(top) && "/nodes/1" == top[0].Prefix, top)
+	_, top = s.MemoryUsage("/", 0, 10)
+	Assert(t, 1 == len(top) && "/" == top[0].Prefix, top)
+
+	total, _ = s.MemoryUsage("/users", 1, 10)
+	Assert(t, total == nil)
+	s.Destroy()
+}
+
+func benchmarkStoreReadWithWrites(b *testing.B, writePrefix string) {
+	s := New()
+	for i := 0; i < 100; i++ {
+		s.Put(fmt.Sprintf("/read/%d", i), map[string]interface{}{"ip": "192.168.1.1", "name": "node"})
+	}
+	stopChan := make(chan struct{})
+	doneChan := make(chan struct{})
+	go func() {
+		defer close(doneChan)
+		for i := 0; ; i++ {
+			select {
+			case <-stopChan:
+				return
+			default:
+				s.Put(fmt.Sprintf("%s/%d", writePrefix, i%1000), map[string]interface{}{"ip": "192.168.1.1", "name": "node"})
+			}
+		}
+	}()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			s.Get(fmt.Sprintf("/read/%d", i%100))
+			i++
+		}
+	})
+	b.StopTimer()
+	close(stopChan)
+	<-doneChan
+	s.Destroy()
+}
+
+// BenchmarkStoreReadWithUnrelatedWrites read one prefix when writing another prefix, which do not contend.
+func BenchmarkStoreReadWithUnrelatedWrites(b *testing.B) {
+	benchmarkStoreReadWithWrites(b, "/write")
+}
+
+// BenchmarkStoreReadWithRelatedWrites read and write the same prefix, as the baseline of contention.
+func BenchmarkStoreReadWithRelatedWrites(b *testing.B) {
+	benchmarkStoreReadWithWrites(b, "/read/write")
+}
+
+func BenchmarkStoreParallelWrites(b *testing.B) {
+	s := New()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			s.Put(fmt.Sprintf("/prefix%d/%d", i%storeShards, i%100), "value")
+			i++
+		}
+	})
+	b.StopTimer()
+	s.Destroy()
+}
+
+func BenchmarkStoreReadRootWithWrites(b *testing.B) {
+	s := New()
+	for i := 0; i < 1000; i++ {
+		s.Put(fmt.Sprintf("/read%d/%d", i%storeShards, i), map[string]interface{}{"ip": "192.168.1.1", "name": "node"})
+	}
+	stopChan := make(chan struct{})
+	doneChan := make(chan struct{})
+	go func() {
+		defer close(doneChan)
+		for i := 0; ; i++ {
+			select {
+			case <-stopChan:
+				return
+			default:
+				s.Put(fmt.Sprintf("/write/%d", i%1000), "value")
+			}
+		}
+	}()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			s.Get("/")
+		}
+	})
+	b.StopTimer()
+	close(stopChan)
+	<-doneChan
+	s.Destroy()
+}
+
+func BenchmarkStorePut(b *testing.B) {
+	s := New()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.Put(fmt.Sprintf("/nodes/%d", i%1000), map[string]interface{}{"ip": "192.168.1.1", "name": "node"})
+	}
+	b.StopTimer()
+	s.Destroy()
+}
+
+func BenchmarkStoreGet(b *testing.B) {
+	s := New()
+	for i := 0; i < 1000; i++ {
+		s.Put(fmt.Sprintf("/nodes/%d", i), map[string]interface{}{"ip": "192.168.1.1", "name": "node"})
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			s.Get(fmt.Sprintf("/nodes/%d", i%1000))
+			i++
+		}
+	})
+	b.StopTimer()
+	s.Destroy()
+}
+
+// benchmarkStoreWatchFanout put a value watched by the watchers, every op is done when all the watchers got the event.
+func benchmarkStoreWatchFanout(b *testing.B, watchers int) {
+	s := New()
+	s.Put("/nodes/1/ip", "192.168.1.1")
+	ws := make([]Watcher, watchers)
+	for i := range ws {
+		ws[i] = s.Watch("/nodes", 10)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.Put("/nodes/1/ip", fmt.Sprintf("192.168.1.%d", i%255))
+		for _, w := range ws {
+			<-w.EventChan()
+		}
+	}
+	b.StopTimer()
+	for _, w := range ws {
+		w.Remove()
+	}
+	s.Destroy()
+}
+
+func BenchmarkStoreWatchFanout1k(b *testing.B) {
+	benchmarkStoreWatchFanout(b, 1000)
+}
+
+func BenchmarkStoreWatchFanout10k(b *testing.B) {
+	benchmarkStoreWatchFanout(b, 10000)
+}
+
+func TestEventSchema(t *testing.T) {
+	Assert(t, reflect.DeepEqual([]int{1}, EventSchemaVersions()))
+	for _, version := range EventSchemaVersions() {
+		schema, ok := EventSchema(version)
+		Assert(t, ok)
+		doc := map[string]interface{}{}
+		Assert(t, nil == json.Unmarshal([]byte(schema), &doc), version)
+	}
+	_, ok := EventSchema(0)
+	Assert(t, !ok)
+
+	// every field of the Event should be described by the current schema.
+	schema, _ := EventSchema(EventSchemaVersion)
+	doc := map[string]interface{}{}
+	json.Unmarshal([]byte(schema), &doc)
+	properties := doc["properties"].(map[string]interface{})
+	eventType := reflect.TypeOf(Event{})
+	for i := 0; i < eventType.NumField(); i++ {
+		name := strings.Split(eventType.Field(i).Tag.Get("json"), ",")[0]
+		_, ok := properties[name]
+		Assert(t, ok, name)
+	}
+}
+
+func TestStoreIndex(t *testing.T) {
+	s := New()
+	s.Put("/nodes", map[string]interface{}{
+		"1": map[string]interface{}{"ip": "192.168.1.1", "name": "a"},
+		"2": map[string]interface{}{"ip": "192.168.1.2", "name": "b"},
+	})
+	s.Put("/clusters/c1/ip", "192.168.1.1")
+
+	_, ok := s.Find("ip", "192.168.1.1", "/")
+	Assert(t, !ok)
+	s.SetIndexKeys([]string{"ip"})
+	Assert(t, reflect.DeepEqual([]string{"ip"}, s.IndexKeys()))
+	paths, ok := s.Find("ip", "192.168.1.1", "/")
+	Assert(t, ok && reflect.DeepEqual([]string{"/clusters/c1/ip", "/nodes/1/ip"}, paths), paths)
+	paths, _ = s.Find("ip", "192.168.1.1", "/nodes")
+	Assert(t, reflect.DeepEqual([]string{"/nodes/1/ip"}, paths), paths)
+
+	// the index follows the changes.
+	s.Put("/nodes/2/ip", "192.168.1.1")
+	s.Put("/nodes/3", map[string]interface{}{"ip": "192.168.1.1"})
+	s.Delete("/clusters/c1")
+	paths, _ = s.Find("ip", "192.168.1.1", "/")
+	Assert(t, reflect.DeepEqual([]string{"/nodes/1/ip", "/nodes/2/ip", "/nodes/3/ip"}, paths), paths)
+	paths, _ = s.Find("ip", "192.168.1.2", "/")
+	Assert(t, 0 == len(paths), paths)
+	// the leaf become dir is removed.
+	s.Put("/nodes/3/ip/v4", "192.168.1.1")
+	paths, _ = s.Find("ip", "192.168.1.1", "/")
+	Assert(t, reflect.DeepEqual([]string{"/nodes/1/ip", "/nodes/2/ip"}, paths), paths)
+	_, ok = s.Find("name", "a", "/")
+	Assert(t, !ok)
+
+	s.SetIndexKeys([]string{"name"})
+	paths, ok = s.Find("name", "b", "/")
+	Assert(t, ok && reflect.DeepEqual([]string{"/nodes/2/name"}, paths), paths)
+	_, ok = s.Find("ip", "192.168.1.1", "/")
+	Assert(t, !ok)
+}
+
+func TestStoreCreatedOrder(t *testing.T) {
+	s := New()
+	s.Put("/hosts", map[string]interface{}{"10": "c", "9": "b", "a": "d", "1": "a"})
+	s.Put("/hosts/0", "e")
+	order := s.CreatedOrder("/hosts")
+	Assert(t, 5 == len(order), order)
+	Assert(t, order["1"] < order["9"] && order["9"] < order["10"] && order["10"] < order["a"] && order["a"] < order["0"], order)
+	indexOf1 := order["1"]
+	s.Put("/hosts/1", "f")
+	Assert(t, indexOf1 == s.CreatedOrder("/hosts")["1"])
+	Assert(t, nil == s.CreatedOrder("/hosts/1"))
+	Assert(t, nil == s.CreatedOrder("/nothing"))
+}
+
+func TestStoreMeta(t *testing.T) {
+	s := New()
+	s.SetHistorySize(2)
+	s.Put("/nodes/1", map[string]interface{}{"ip": "192.168.1.1", "name": "a"})
+	created := s.Version()
+	s.Put("/nodes/1/ip", "192.168.1.2")
+	s.Put("/nodes/1/ip", "192.168.1.3")
+	s.Put("/nodes/1/ip", "192.168.1.4")
+
+	m := s.Meta("/nodes/1/ip")
+	Assert(t, m != nil && "192.168.1.4" == *m.Value && m.Children == nil)
+	Assert(t, m.CreatedVersion <= created && s.Version() == m.ModifiedVersion && m.CreatedAt > 0 && m.ModifiedAt >= m.CreatedAt, m)
+	Assert(t, 2 == len(m.History) && "192.168.1.3" == m.History[0].Value && "192.168.1.2" == m.History[1].Value, m.History)
+	Assert(t, m.History[0].ModifiedVersion > m.History[1].ModifiedVersion)
+
+	m = s.Meta("/nodes")
+	Assert(t, nil == m.Value && 1 == len(m.Children) && 2 == len(m.Children["1"].Children))
+	Assert(t, s.Version() == m.ModifiedVersion && m.Children["1"].Children["name"].ModifiedVersion <= created)
+	Assert(t, 0 == len(m.Children["1"].Children["name"].History))
+
+	s.Delete("/nodes/1/name")
+	Assert(t, nil == s.Meta("/nodes/1/name"))
+	Assert(t, s.Version() == s.Meta("/nodes").ModifiedVersion)
+
+	s.SetHistorySize(0)
+	s.Put("/nodes/1/ip", "192.168.1.5")
+	Assert(t, 0 == len(s.Meta("/nodes/1/ip").History))
+	Assert(t, nil == s.Meta("/nothing"))
+}
+
+func TestStoreLoad(t *testing.T) {
+	s := New()
+	s.SetIndexKeys([]string{"ip"})
+	w := s.Watch("/nodes/1", 10)
+	values := map[string]string{
+		"/nodes/1/ip":   "192.168.1.1",
+		"/nodes/1/name": "a",
+		"/nodes/10/ip":  "192.168.1.10",
+		"/nodes/9/ip":   "192.168.1.9",
+		"/nodes/9":      "leaf become dir",
+		"clusters//c2":  "c2",
+		"/clusters/c1":  "c1",
+	}
+	Assert(t, s.Load(values))
+	Assert(t, int64(len(values)) == s.Version())
+
+	_, val := s.Get("/")
+	Assert(t, reflect.DeepEqual(map[string]interface{}{
+		"nodes": map[string]interface{}{
+			"1":  map[string]interface{}{"ip": "192.168.1.1", "name": "a"},
+			"10": map[string]interface{}{"ip": "192.168.1.10"},
+			"9":  map[string]interface{}{"ip": "192.168.1.9"},
+		},
+		"clusters": map[string]interface{}{"c1": "c1", "c2": "c2"},
+	}, val), val)
+	order := s.CreatedOrder("/nodes")
+	Assert(t, order["1"] < order["9"] && order["9"] < order["10"], order)
+	m := s.Meta("/nodes/9/ip")
+	Assert(t, s.Version() == m.CreatedVersion && s.Version() == m.ModifiedVersion && m.CreatedAt > 0, m)
+
+	for _, usage := range s.Usage() {
+		switch usage.Prefix {
+		case "/nodes":
+			Assert(t, 4 == usage.Keys && int64(len("192.168.1.1a192.168.1.10192.168.1.9")) == usage.Bytes, usage)
+		case "/clusters":
+			Assert(t, 2 == usage.Keys && 4 == usage.Bytes, usage)
+		}
+	}
+	paths, _ := s.Find("ip", "192.168.1.9", "/")
+	Assert(t, reflect.DeepEqual([]string{"/nodes/9/ip"}, paths), paths)
+
+	// the watcher registered before got a Resync event only.
+	e := readEvent(w.EventChan())
+	Assert(t, e != nil && Resync == e.Action && "/" == e.Path, e)
+	s.Put("/nodes/1/ip", "192.168.1.2")
+	e = readEvent(w.EventChan())
+	Assert(t, e != nil && Update == e.Action && "/ip" == e.Path, e)
+	w.Remove()
+
+	// the watcher of the store without index keys got the Resync event too.
+	unindexed := New()
+	w = unindexed.Watch("/nodes/1", 10)
+	Assert(t, unindexed.Load(values))
+	e = readEvent(w.EventChan())
+	Assert(t, e != nil && Resync == e.Action && "/" == e.Path, e)
+	w.Remove()
+	unindexed.Destroy()
+
+	// the store with leaf is not loaded.
+	Assert(t, !s.Load(map[string]string{"/nodes/2/ip": "192.168.1.2"}))
+	_, val = s.Get("/nodes/2")
+	Assert(t, nil == val)
+	s.Destroy()
+}
+
+func BenchmarkStoreLoad(b *testing.B) {
+	values := make(map[string]string, 100000)
+	for i := 0; i < 10000; i++ {
+		for j := 0; j < 10; j++ {
+			values[fmt.Sprintf("/clusters/cl-%d/hosts/%d/ip", i%100, i*10+j)] = "192.168.1.1"
+		}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s := New()
+		s.Load(values)
+		b.StopTimer()
+		s.Destroy()
+		b.StartTimer()
+	}
+}
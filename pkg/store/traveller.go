@@ -10,6 +10,7 @@ package store
 
 import (
 	"strings"
+	"sync"
 )
 
 type Traveller interface {
@@ -56,6 +57,8 @@ func (s *travellerStack) Clean() {
 	s.backend = []*stackElement{}
 }
 
+// nodeTraveller hold the store's worldLock in read mode, and the shard lock of the top level subtree it is in,
+// it holds at most one shard lock at a time to avoid dead lock.
 type nodeTraveller struct {
 	store          *store
 	access         AccessTree
@@ -63,6 +66,7 @@ type nodeTraveller struct {
 	currAccessNode *accessNode
 	currMode       AccessMode
 	stack          travellerStack
+	shard          *sync.RWMutex
 }
 
 func newTraveller(store *store, accessTree AccessTree) Traveller {
@@ -119,6 +123,10 @@ func (t *nodeTraveller) enter(node string) bool {
 	}
 
 	if result {
+		if t.currNode.IsRoot() {
+			t.shard = t.store.shard(n.Name)
+			t.shard.RLock()
+		}
 		t.stack.Push(&stackElement{node: t.currAccessNode, mode: t.currMode})
 		t.currNode = n
 		t.currAccessNode = an
@@ -144,6 +152,16 @@ func (t *nodeTraveller) Back() {
 	t.currNode = t.currNode.parent
 	t.currMode = e.mode
 	t.currAccessNode = e.node
+	if t.currNode.IsRoot() {
+		t.unlockShard()
+	}
+}
+
+func (t *nodeTraveller) unlockShard() {
+	if t.shard != nil {
+		t.shard.RUnlock()
+		t.shard = nil
+	}
 }
 
 func (t *nodeTraveller) BackStep(step int) {
@@ -157,6 +175,7 @@ func (t *nodeTraveller) BackToRoot() {
 		panic("illegal status: access a closed traveller.")
 	}
 	t.stack.Clean()
+	t.unlockShard()
 	t.currNode = t.store.Root
 	t.currAccessNode = t.access.GetRoot()
 	t.currMode = t.currAccessNode.Mode
@@ -204,6 +223,7 @@ func (t *nodeTraveller) Close() {
 	t.access = nil
 	t.currAccessNode = nil
 	t.currNode = nil
+	t.unlockShard()
 	t.store.worldLock.RUnlock()
 	t.store = nil
 }
@@ -11,18 +11,42 @@ package store
 import (
 	"fmt"
 	"path"
+	"strings"
 	"sync"
+
+	"openpitrix.io/metad/pkg/logger"
 )
 
 const (
 	Update = "UPDATE"
 	Delete = "DELETE"
+	// Resync is the marker event of the watched node when the older events are dropped,
+	// the receiver should read the watched node again.
+	Resync = "RESYNC"
+	// Batch is the event of a bulk update, the events of the update are coalesced into its Events.
+	Batch = "BATCH"
+)
+
+// OverflowPolicy is how the watcher handle the new event when its buffer is full.
+type OverflowPolicy int
+
+const (
+	// DropOldest drop the buffered events and deliver a Resync event before the new event.
+	DropOldest OverflowPolicy = iota
+	// Block wait the receiver until the event delivered or the watcher removed, no event is dropped. The delivery of
+	// the other watchers' events waits too, and the store writes wait when the dispatch backlog is full, so the
+	// receiver should not write the store.
+	Block
 )
 
 type Event struct {
 	Action string `json:"action"`
 	Path   string `json:"path"`
 	Value  string `json:"value"`
+	// Actor is who made the change, see Store.SetActorFunc.
+	Actor string `json:"actor,omitempty"`
+	// Events is the coalesced events of the Batch event.
+	Events []*Event `json:"events,omitempty"`
 }
 
 func (e *Event) String() string {
@@ -37,6 +61,45 @@ func newEvent(action string, path string, value string) *Event {
 	}
 }
 
+// newBatchEvent coalesce the events, return the event itself if only one.
+func newBatchEvent(events []*Event) *Event {
+	if len(events) == 1 {
+		return events[0]
+	}
+	return &Event{Action: Batch, Path: "/", Events: events}
+}
+
+// Flatten return the events, the Batch event is expanded to its events.
+func (e *Event) Flatten() []*Event {
+	if e.Action != Batch {
+		return []*Event{e}
+	}
+	return e.Events
+}
+
+// eventBatch collect the events of a bulk update by watcher, which are delivered as one Batch event when the update finished.
+type eventBatch struct {
+	watchers []*watcher
+	events   map[*watcher][]*Event
+}
+
+// add collect the event of the watcher, the watcher's node is held until flushed.
+func (b *eventBatch) add(w *watcher, event *Event) {
+	events, ok := b.events[w]
+	if !ok {
+		b.watchers = append(b.watchers, w)
+		w.node.ref()
+	}
+	b.events[w] = append(events, event)
+}
+
+// flush queue the collected events to the dispatcher, the watchers removed during the update are skipped.
+func (b *eventBatch) flush(d *dispatcher) {
+	for _, w := range b.watchers {
+		d.add(delivery{watcher: w, event: newBatchEvent(b.events[w])})
+	}
+}
+
 type Watcher interface {
 	EventChan() chan *Event
 	Remove()
@@ -47,21 +110,66 @@ type watcher struct {
 	removed   bool
 	node      *node
 	remove    func()
+	policy    OverflowPolicy
+	sendLock  sync.Mutex
+	stopChan  chan struct{}
+	stopOnce  sync.Once
 }
 
-func newWatcher(node *node, bufLen int) *watcher {
+func newWatcher(node *node, bufLen int, policy OverflowPolicy) *watcher {
+	// keep room for the resync event and the new event.
+	if policy == DropOldest && bufLen < 2 {
+		bufLen = 2
+	}
 	w := &watcher{
 		eventChan: make(chan *Event, bufLen),
 		node:      node,
+		policy:    policy,
+		stopChan:  make(chan struct{}),
 	}
 	return w
 }
 
+// notify deliver the event by the overflow policy, the node's watcherLock should be held in read mode.
+func (w *watcher) notify(event *Event) {
+	if w.policy == Block {
+		select {
+		case w.eventChan <- event:
+		case <-w.stopChan:
+		}
+		return
+	}
+	// the events of root's watchers are sent by the writers of different subtrees concurrently.
+	w.sendLock.Lock()
+	defer w.sendLock.Unlock()
+	select {
+	case w.eventChan <- event:
+		return
+	default:
+	}
+	dropped := 0
+	for drained := false; !drained; {
+		select {
+		case <-w.eventChan:
+			dropped++
+		default:
+			drained = true
+		}
+	}
+	logger.Warn("Watcher of %s buffer is full, %d events dropped, resync required.", w.node.Path(), dropped)
+	w.eventChan <- newEvent(Resync, "/", "")
+	w.eventChan <- event
+}
+
 func (w *watcher) EventChan() chan *Event {
 	return w.eventChan
 }
 
 func (w *watcher) Remove() {
+	// wake up the blocked notify, which hold the watcherLock.
+	w.stopOnce.Do(func() {
+		close(w.stopChan)
+	})
 	w.node.watcherLock.Lock()
 	defer w.node.watcherLock.Unlock()
 
@@ -72,33 +180,59 @@ func (w *watcher) Remove() {
 }
 
 type aggregateWatcher struct {
-	watchers  map[string]Watcher
+	watchers  []Watcher
 	eventChan chan *Event
 	closeWait *sync.WaitGroup
 }
 
 func NewAggregateWatcher(watchers map[string]Watcher) Watcher {
+	prefixes := make([]string, 0, len(watchers))
+	list := make([]Watcher, 0, len(watchers))
+	for pathPrefix, watcher := range watchers {
+		prefixes = append(prefixes, pathPrefix)
+		list = append(list, watcher)
+	}
+	return newAggregateWatcher(prefixes, list)
+}
+
+// NewMergedWatcher merge the events of the watchers without changing the paths, such as the watchers of the layers
+// of a layered mapping link.
+func NewMergedWatcher(watchers ...Watcher) Watcher {
+	return newAggregateWatcher(make([]string, len(watchers)), watchers)
+}
+
+func newAggregateWatcher(prefixes []string, watchers []Watcher) Watcher {
 	eventChan := make(chan *Event, len(watchers)*50)
 	waitGroup := &sync.WaitGroup{}
 	waitGroup.Add(len(watchers))
-	for pathPrefix, watcher := range watchers {
+	for i, watcher := range watchers {
 		go func(pathPrefix string, watcher Watcher) {
 			for {
 				select {
 				case event, ok := <-watcher.EventChan():
 					if ok {
-						eventChan <- newEvent(event.Action, path.Join(pathPrefix, event.Path), event.Value)
+						eventChan <- prefixEvent(pathPrefix, event)
 					} else {
 						waitGroup.Done()
 						return
 					}
 				}
 			}
-		}(pathPrefix, watcher)
+		}(prefixes[i], watcher)
 	}
 	return &aggregateWatcher{watchers: watchers, eventChan: eventChan, closeWait: waitGroup}
 }
 
+// prefixEvent copy the event with the path prefix, include the events of Batch event.
+func prefixEvent(pathPrefix string, event *Event) *Event {
+	e := newEvent(event.Action, path.Join(pathPrefix, event.Path), event.Value)
+	e.Actor = event.Actor
+	for _, sub := range event.Events {
+		e.Events = append(e.Events, prefixEvent(pathPrefix, sub))
+	}
+	return e
+}
+
 func (w *aggregateWatcher) EventChan() chan *Event {
 	return w.eventChan
 }
@@ -111,3 +245,87 @@ func (w *aggregateWatcher) Remove() {
 	w.closeWait.Wait()
 	close(w.eventChan)
 }
+
+// splitGlob split the nodePath to the static prefix and the glob pattern relative to it, ok is false if no glob.
+// The pattern syntax is same as path.Match, * match one path element.
+func splitGlob(nodePath string) (prefix string, pattern string, ok bool) {
+	components := strings.Split(path.Clean(path.Join("/", nodePath)), "/")
+	for i, component := range components {
+		if strings.ContainsAny(component, "*?[") {
+			pattern = "/" + strings.Join(components[i:], "/")
+			if _, err := path.Match(pattern, ""); err != nil {
+				return "", "", false
+			}
+			return path.Join("/", strings.Join(components[:i], "/")), pattern, true
+		}
+	}
+	return "", "", false
+}
+
+// matchGlob check whether the event path is matched by the pattern, or is under a matched path.
+func matchGlob(pattern string, eventPath string) bool {
+	depth := strings.Count(pattern, "/")
+	elems := strings.SplitN(eventPath, "/", depth+2)
+	if len(elems) < depth+1 {
+		return false
+	}
+	matched, _ := path.Match(pattern, strings.Join(elems[:depth+1], "/"))
+	return matched
+}
+
+// globWatcher forward the events of the prefix watcher matched by the pattern, the event path is relative to the prefix.
+type globWatcher struct {
+	watcher   Watcher
+	eventChan chan *Event
+	stopChan  chan struct{}
+	done      chan struct{}
+	stopOnce  sync.Once
+}
+
+func newGlobWatcher(watcher Watcher, pattern string, bufLen int) Watcher {
+	w := &globWatcher{
+		watcher:   watcher,
+		eventChan: make(chan *Event, bufLen),
+		stopChan:  make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go func() {
+		defer close(w.done)
+		for event := range watcher.EventChan() {
+			if event.Action == Batch {
+				matched := make([]*Event, 0, len(event.Events))
+				for _, e := range event.Events {
+					if matchGlob(pattern, e.Path) {
+						matched = append(matched, e)
+					}
+				}
+				if len(matched) == 0 {
+					continue
+				}
+				event = newBatchEvent(matched)
+			} else if event.Action != Resync && !matchGlob(pattern, event.Path) {
+				// the resync event is for the whole prefix.
+				continue
+			}
+			select {
+			case w.eventChan <- event:
+			case <-w.stopChan:
+				return
+			}
+		}
+	}()
+	return w
+}
+
+func (w *globWatcher) EventChan() chan *Event {
+	return w.eventChan
+}
+
+func (w *globWatcher) Remove() {
+	w.stopOnce.Do(func() {
+		close(w.stopChan)
+		w.watcher.Remove()
+		<-w.done
+		close(w.eventChan)
+	})
+}
@@ -58,6 +58,24 @@ func GetMapValue(m interface{}, nodePath string) string {
 	return v
 }
 
+// NaturalLess compare the paths by elements, the integer elements are compared as numbers, so /hosts/9 is before
+// /hosts/10, the other elements are compared as strings.
+func NaturalLess(a string, b string) bool {
+	as, bs := strings.Split(a, "/"), strings.Split(b, "/")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if as[i] == bs[i] {
+			continue
+		}
+		an, aerr := strconv.Atoi(as[i])
+		bn, berr := strconv.Atoi(bs[i])
+		if aerr == nil && berr == nil && an != bn {
+			return an < bn
+		}
+		return as[i] < bs[i]
+	}
+	return len(as) < len(bs)
+}
+
 func ParseInt(value string, defaultValue int) int {
 	if value == "" {
 		return defaultValue
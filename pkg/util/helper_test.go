@@ -109,3 +109,25 @@ func TestGetMapValue(t *testing.T) {
 	Assert(t, GetMapValue(m, "/nodes/1/name") == "node1")
 	Assert(t, GetMapValue(m, "nodes/2") == "")
 }
+
+func TestNaturalLess(t *testing.T) {
+	cases := []struct {
+		A, B string
+		Less bool
+	}{
+		{"9", "10", true},
+		{"10", "9", false},
+		{"/hosts/9/ip", "/hosts/10/ip", true},
+		{"/hosts/1/ip", "/hosts/1/name", true},
+		{"a", "b", true},
+		{"10", "a", true},
+		{"/hosts", "/hosts/1", true},
+		{"01", "1", true},
+		{"1", "1", false},
+	}
+	for _, tc := range cases {
+		if actual := NaturalLess(tc.A, tc.B); actual != tc.Less {
+			t.Fatalf("NaturalLess(%#v, %#v) = %v, expected %v", tc.A, tc.B, actual, tc.Less)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"openpitrix.io/metad/pkg/bench"
+)
+
+// watchCmd represents the watch command
+var watchCmd = &cobra.Command{
+	Use:   "watch [path](default is /self)",
+	Short: "Benchmark long-poll, the latency is from the change to the long-polls returned",
+
+	Run: watchFunc,
+}
+
+var (
+	watchRounds     int
+	watchChangePath string
+)
+
+func init() {
+	RootCmd.AddCommand(watchCmd)
+	watchCmd.Flags().IntVar(&watchRounds, "rounds", 10, "Total number of changes, all clients long-poll every change")
+	watchCmd.Flags().StringVar(&watchChangePath, "change_path", "/benchmark/watch", "The data path changed every round, should be watched by the path")
+}
+
+func watchFunc(cmd *cobra.Command, args []string) {
+	if len(args) > 1 {
+		fmt.Fprintln(os.Stderr, cmd.Usage())
+		os.Exit(1)
+	}
+	p := "/self"
+	if len(args) == 1 {
+		p = args[0]
+	}
+	// the long-polls should not timeout by the client, and every client keep its connection.
+	transport := &http.Transport{MaxIdleConnsPerHost: int(totalClients)}
+	target := &bench.Target{URL: getEndpoint(), Client: &http.Client{Transport: transport}}
+	if xff != "" {
+		target.Header = http.Header{"X-Forwarded-For": []string{xff}}
+	}
+	manage := &bench.Target{URL: manageEndpoint}
+	report, err := bench.LongPoll("Watch", &bench.LongPollOptions{
+		Target:  target,
+		Path:    p,
+		Clients: int(totalClients),
+		Rounds:  watchRounds,
+		Change: func(round int) error {
+			body := strings.NewReader(fmt.Sprintf(`"%s"`, RandomString(8)))
+			status, _, err := manage.Do(context.Background(), "PUT", path.Join("/v1/data", watchChangePath), body)
+			if err == nil && status != http.StatusOK {
+				err = fmt.Errorf("change %s status %d", watchChangePath, status)
+			}
+			return err
+		},
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Println(report.String())
+}